@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+// adjustmentsCollector is implemented by collectors exposing the taxes,
+// credits and refunds included in the costs of their parsed reports
+type adjustmentsCollector interface {
+	Adjustments() []billing.Adjustment
+}
+
+// adjustmentCollector exposes the month-to-date taxes, credits and refunds
+// per record type, so the costs can be reconciled with the invoice
+type adjustmentCollector struct {
+	desc *prometheus.Desc
+}
+
+func newAdjustmentCollector() *adjustmentCollector {
+	return &adjustmentCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "billing", "monthly_adjustments"),
+			"Month-to-date taxes, credits and refunds included in the costs of a service, per record type.",
+			billing.AdjustmentLabels,
+			nil,
+		),
+	}
+}
+
+func (c *adjustmentCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *adjustmentCollector) collect(adjustments []billing.Adjustment, ch chan<- prometheus.Metric) {
+	// the latest month wins, if a collector reports multiple months
+	latest := make(map[string]billing.Adjustment)
+	for _, adjustment := range adjustments {
+		key := strings.Join(adjustment.Labels(), "\x00")
+		if previous, ok := latest[key]; ok {
+			if previous.Month > adjustment.Month {
+				continue
+			}
+			if previous.Month == adjustment.Month {
+				adjustment.Amount += previous.Amount
+			}
+		}
+		latest[key] = adjustment
+	}
+
+	for _, adjustment := range latest {
+		m, err := prometheus.NewConstMetric(c.desc, prometheus.GaugeValue, adjustment.Amount, adjustment.Labels()...)
+		if err != nil {
+			log.Warnf("error exposing adjustment %+v: %s", adjustment, err)
+			continue
+		}
+		ch <- m
+	}
+}
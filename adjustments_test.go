@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+type adjustmentTestCollector struct {
+	*adjustmentCollector
+	adjustments []billing.Adjustment
+}
+
+func (c adjustmentTestCollector) Collect(ch chan<- prometheus.Metric) {
+	c.collect(c.adjustments, ch)
+}
+
+func TestAdjustmentCollector(t *testing.T) {
+	c := adjustmentTestCollector{
+		adjustmentCollector: newAdjustmentCollector(),
+		adjustments: []billing.Adjustment{
+			{Cloud: "aws", Month: "2019-10", Currency: "USD", Account: "acme-dev", Service: "AmazonEC2", RecordType: billing.RecordTypeTax, Amount: 20},
+			{Cloud: "aws", Month: "2019-11", Currency: "USD", Account: "acme-dev", Service: "AmazonEC2", RecordType: billing.RecordTypeTax, Amount: 1.6},
+			{Cloud: "aws", Month: "2019-11", Currency: "USD", Account: "acme-dev", Service: "AmazonEC2", RecordType: billing.RecordTypeCredit, Amount: 2},
+			{Cloud: "aws", Month: "2019-11", Currency: "USD", Account: "acme-dev", Service: "AmazonS3", RecordType: billing.RecordTypeRefund, Amount: 0.5},
+		},
+	}
+
+	exp := `
+# HELP cloud_billing_monthly_adjustments Month-to-date taxes, credits and refunds included in the costs of a service, per record type.
+# TYPE cloud_billing_monthly_adjustments gauge
+cloud_billing_monthly_adjustments{account="acme-dev",cloud="aws",cost_centre="",currency="USD",owner="",path="",record_type="credit",service="AmazonEC2",type=""} 2
+cloud_billing_monthly_adjustments{account="acme-dev",cloud="aws",cost_centre="",currency="USD",owner="",path="",record_type="refund",service="AmazonS3",type=""} 0.5
+cloud_billing_monthly_adjustments{account="acme-dev",cloud="aws",cost_centre="",currency="USD",owner="",path="",record_type="tax",service="AmazonEC2",type=""} 1.6
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(exp)); err != nil {
+		t.Errorf("unexpected metrics: %s", err)
+	}
+}
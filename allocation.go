@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+
+	"github.com/simonswine/cloud-billing-exporter/opencost"
+)
+
+// allocationSource returns the month-to-date costs allocated to Kubernetes
+// workloads
+type allocationSource interface {
+	MonthToDate(ctx context.Context) ([]opencost.Allocation, error)
+	String() string
+}
+
+// allocationCollector exposes the costs allocated to Kubernetes workloads by
+// OpenCost or Kubecost, which are refreshed in the given interval
+type allocationCollector struct {
+	desc     *prometheus.Desc
+	source   allocationSource
+	cloud    string
+	currency string
+	interval time.Duration
+	now      func() time.Time
+
+	lock        sync.Mutex
+	allocations []opencost.Allocation
+	lastUpdate  time.Time
+}
+
+func newAllocationCollector(source allocationSource, cloud, currency string, interval time.Duration) *allocationCollector {
+	return &allocationCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "kubernetes", "monthly_costs"),
+			"Month-to-date costs allocated to a Kubernetes workload per resource.",
+			[]string{"cloud", "currency", "cluster", "namespace", "controller_kind", "controller", "resource"},
+			nil,
+		),
+		source:   source,
+		cloud:    cloud,
+		currency: currency,
+		interval: interval,
+		now:      time.Now,
+	}
+}
+
+func (c *allocationCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect exposes the allocated costs, they are kept until the next successful
+// refresh if the source fails
+func (c *allocationCollector) Collect(ch chan<- prometheus.Metric) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.now().Sub(c.lastUpdate) >= c.interval {
+		allocations, err := c.source.MonthToDate(context.Background())
+		if err != nil {
+			log.Warnf("error updating allocations from %s: %s", c.source, err)
+		} else {
+			c.allocations = allocations
+			c.lastUpdate = c.now()
+		}
+	}
+
+	for _, a := range c.allocations {
+		resources := make([]string, 0, len(a.Costs))
+		for r := range a.Costs {
+			resources = append(resources, r)
+		}
+		sort.Strings(resources)
+		for _, r := range resources {
+			if a.Costs[r] == 0 {
+				continue
+			}
+			m, err := prometheus.NewConstMetric(c.desc, prometheus.GaugeValue, a.Costs[r],
+				c.cloud, c.currency, a.Cluster, a.Namespace, a.ControllerKind, a.Controller, r)
+			if err != nil {
+				log.Warnf("error exposing allocation %+v: %s", a, err)
+				continue
+			}
+			ch <- m
+		}
+	}
+}
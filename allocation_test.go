@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/simonswine/cloud-billing-exporter/opencost"
+)
+
+type fakeAllocationSource struct {
+	calls int
+	err   error
+}
+
+func (f *fakeAllocationSource) MonthToDate(_ context.Context) ([]opencost.Allocation, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return []opencost.Allocation{
+		{Cluster: "prod", Namespace: "monitoring", ControllerKind: "deployment", Controller: "prometheus", Costs: map[string]float64{"cpu": 12.5, "ram": 7.25, "gpu": 0}},
+	}, nil
+}
+
+func (f *fakeAllocationSource) String() string {
+	return "fake"
+}
+
+func TestAllocationCollector(t *testing.T) {
+	now := time.Date(2019, 11, 20, 10, 0, 0, 0, time.UTC)
+	source := &fakeAllocationSource{}
+	c := newAllocationCollector(source, "kubernetes", "USD", 10*time.Minute)
+	c.now = func() time.Time { return now }
+
+	exp := `
+# HELP cloud_kubernetes_monthly_costs Month-to-date costs allocated to a Kubernetes workload per resource.
+# TYPE cloud_kubernetes_monthly_costs gauge
+cloud_kubernetes_monthly_costs{cloud="kubernetes",cluster="prod",controller="prometheus",controller_kind="deployment",currency="USD",namespace="monitoring",resource="cpu"} 12.5
+cloud_kubernetes_monthly_costs{cloud="kubernetes",cluster="prod",controller="prometheus",controller_kind="deployment",currency="USD",namespace="monitoring",resource="ram"} 7.25
+`
+	for i := 0; i < 2; i++ {
+		if err := testutil.CollectAndCompare(c, strings.NewReader(exp)); err != nil {
+			t.Errorf("unexpected metrics: %s", err)
+		}
+	}
+	if source.calls != 1 {
+		t.Errorf("expected allocations to be queried once within the interval, got %d calls", source.calls)
+	}
+
+	// failed refreshes keep the previous allocations
+	now = now.Add(10 * time.Minute)
+	source.err = fmt.Errorf("connection refused")
+	if err := testutil.CollectAndCompare(c, strings.NewReader(exp)); err != nil {
+		t.Errorf("unexpected metrics: %s", err)
+	}
+	if source.calls != 2 {
+		t.Errorf("expected a refresh after the interval, got %d calls", source.calls)
+	}
+}
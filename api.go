@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/common/log"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+// monthRecords returns the records of a billing month, from the local store
+// if configured or otherwise from the in-memory history
+func (b *BillingCollector) monthRecords(ctx context.Context, month string) ([]billing.Record, error) {
+	if b.store != nil {
+		return b.store.Records(ctx, month)
+	}
+	return b.history.records(month), nil
+}
+
+// queryMonth returns the month (YYYY-MM) requested, it defaults to the
+// current month
+func queryMonth(w http.ResponseWriter, r *http.Request) (string, bool) {
+	month := r.URL.Query().Get("month")
+	if month == "" {
+		month = time.Now().Format(monthFormat)
+	}
+	if _, err := time.Parse(monthFormat, month); err != nil {
+		http.Error(w, fmt.Sprintf("invalid month '%s', expected format YYYY-MM", month), http.StatusBadRequest)
+		return "", false
+	}
+	return month, true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warnf("error writing JSON response: %s", err)
+	}
+}
+
+// costsHandler serves the records of a billing month as JSON
+func (b *BillingCollector) costsHandler(w http.ResponseWriter, r *http.Request) {
+	month, ok := queryMonth(w, r)
+	if !ok {
+		return
+	}
+
+	records, err := b.monthRecords(r.Context(), month)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error querying records: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if records == nil {
+		records = []billing.Record{}
+	}
+	writeJSON(w, records)
+}
+
+// lineItemsHandler serves the line items of a billing month as JSON, they
+// are only kept in the local store
+func (b *BillingCollector) lineItemsHandler(w http.ResponseWriter, r *http.Request) {
+	if b.store == nil {
+		http.Error(w, "line items are only available with -sqlite.path", http.StatusNotFound)
+		return
+	}
+	month, ok := queryMonth(w, r)
+	if !ok {
+		return
+	}
+
+	items, err := b.store.LineItems(r.Context(), month)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error querying line items: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if items == nil {
+		items = []billing.LineItem{}
+	}
+	writeJSON(w, items)
+}
+
+// accountsHandler serves the latest metadata of all accounts as JSON
+func (b *BillingCollector) accountsHandler(w http.ResponseWriter, r *http.Request) {
+	if b.store == nil {
+		http.Error(w, "account metadata is only available with -sqlite.path", http.StatusNotFound)
+		return
+	}
+
+	accounts, err := b.store.Accounts(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error querying accounts: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if accounts == nil {
+		accounts = []billing.AccountMetadata{}
+	}
+	writeJSON(w, accounts)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+	"github.com/simonswine/cloud-billing-exporter/sink"
+)
+
+func TestCostsHandler(t *testing.T) {
+	c := &fakeCollector{cloud: "aws", records: []billing.Record{
+		{Cloud: "aws", Month: "2019-11", Currency: "USD", Account: "a", Service: "AmazonEC2", Costs: 1},
+	}}
+	b := &BillingCollector{history: newHistory()}
+	b.history.update([]cloudBillingCollector{c})
+
+	for _, tc := range []struct {
+		query  string
+		status int
+		exp    []billing.Record
+	}{
+		{query: "?month=2019-11", status: http.StatusOK, exp: c.records},
+		{query: "?month=2019-10", status: http.StatusOK, exp: []billing.Record{}},
+		{query: "?month=november", status: http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		b.costsHandler(w, httptest.NewRequest(http.MethodGet, "/api/v1/costs"+tc.query, nil))
+		if w.Code != tc.status {
+			t.Errorf("unexpected status for '%s': act: %d, exp: %d", tc.query, w.Code, tc.status)
+			continue
+		}
+		if tc.status != http.StatusOK {
+			continue
+		}
+		var act []billing.Record
+		if err := json.NewDecoder(w.Body).Decode(&act); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(act, tc.exp) {
+			t.Errorf("unexpected records for '%s': act: %+v, exp: %+v", tc.query, act, tc.exp)
+		}
+	}
+}
+
+func TestStoreHandlers(t *testing.T) {
+	b := &BillingCollector{history: newHistory()}
+
+	// endpoints need the store
+	w := httptest.NewRecorder()
+	b.lineItemsHandler(w, httptest.NewRequest(http.MethodGet, "/api/v1/line_items?month=2019-11", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unexpected status without store: %d", w.Code)
+	}
+
+	dir, err := ioutil.TempDir("", "api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := sink.NewSQLite(context.Background(), filepath.Join(dir, "billing.db"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.store = store
+
+	items := []billing.LineItem{{Cloud: "gcp", Month: "2019-11", Date: "2019-11-01", Currency: "USD", Account: "b", Service: "compute", Costs: 1}}
+	if err := store.WriteLineItems(context.Background(), items); err != nil {
+		t.Fatal(err)
+	}
+	records := []billing.Record{{Cloud: "gcp", Month: "2019-11", Currency: "USD", Account: "b", Service: "compute", Owner: "team-b", Costs: 1}}
+	if err := store.Write(context.Background(), records); err != nil {
+		t.Fatal(err)
+	}
+
+	w = httptest.NewRecorder()
+	b.lineItemsHandler(w, httptest.NewRequest(http.MethodGet, "/api/v1/line_items?month=2019-11", nil))
+	var actItems []billing.LineItem
+	if err := json.NewDecoder(w.Body).Decode(&actItems); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actItems, items) {
+		t.Errorf("unexpected line items: act: %+v, exp: %+v", actItems, items)
+	}
+
+	// costs are served from the store instead of the empty history
+	w = httptest.NewRecorder()
+	b.costsHandler(w, httptest.NewRequest(http.MethodGet, "/api/v1/costs?month=2019-11", nil))
+	var actRecords []billing.Record
+	if err := json.NewDecoder(w.Body).Decode(&actRecords); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actRecords, records) {
+		t.Errorf("unexpected records: act: %+v, exp: %+v", actRecords, records)
+	}
+
+	w = httptest.NewRecorder()
+	b.accountsHandler(w, httptest.NewRequest(http.MethodGet, "/api/v1/accounts", nil))
+	var actAccounts []billing.AccountMetadata
+	if err := json.NewDecoder(w.Body).Decode(&actAccounts); err != nil {
+		t.Fatal(err)
+	}
+	expAccounts := []billing.AccountMetadata{{Cloud: "gcp", Account: "b", Owner: "team-b", Month: "2019-11"}}
+	if !reflect.DeepEqual(actAccounts, expAccounts) {
+		t.Errorf("unexpected accounts: act: %+v, exp: %+v", actAccounts, expAccounts)
+	}
+}
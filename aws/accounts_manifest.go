@@ -0,0 +1,79 @@
+package aws
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/prometheus/common/log"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// accountsManifest lists the accounts with their alternate contacts, as
+// returned by the Account Management API. It is synced from the API out of
+// band, so accounts can be enriched without access to the organizations API,
+// e.g. in member accounts.
+type accountsManifest struct {
+	Accounts []struct {
+		AccountID         string `yaml:"accountId"`
+		AccountName       string `yaml:"accountName"`
+		Status            string `yaml:"status"`
+		Path              string `yaml:"path"`
+		AlternateContacts []struct {
+			AlternateContactType string `yaml:"alternateContactType"`
+			EmailAddress         string `yaml:"emailAddress"`
+			Name                 string `yaml:"name"`
+		} `yaml:"alternateContacts"`
+	} `yaml:"accounts"`
+}
+
+// WithAccountsManifest looks up the account names and owners from the
+// manifest file instead of the organizations API. The owner is the email
+// address of the alternate contact with the given type, e.g. OPERATIONS.
+func (a *AWSBilling) WithAccountsManifest(path, ownerContactType string) *AWSBilling {
+	a.accountsManifest = path
+	a.accountsManifestOwnerContact = ownerContactType
+	return a
+}
+
+// readAccountsManifest reads the accounts from the manifest file
+func (a *AWSBilling) readAccountsManifest() (map[AccountID]*Account, error) {
+	data, err := ioutil.ReadFile(a.accountsManifest)
+	if err != nil {
+		return nil, fmt.Errorf("error reading accounts manifest: %s", err)
+	}
+
+	var m accountsManifest
+	if err := yaml.UnmarshalStrict(data, &m); err != nil {
+		return nil, fmt.Errorf("error parsing accounts manifest %s: %s", a.accountsManifest, err)
+	}
+
+	accountMap := make(map[AccountID]*Account)
+	for _, e := range m.Accounts {
+		if e.AccountID == "" {
+			return nil, fmt.Errorf("account without accountId in manifest %s", a.accountsManifest)
+		}
+		ac := &Account{
+			ID:     AccountID(e.AccountID),
+			Name:   AccountName(e.AccountName),
+			Status: e.Status,
+		}
+		if ac.Name == "" {
+			ac.Name = AccountName(e.AccountID)
+		}
+		if !a.disableOwner {
+			for _, c := range e.AlternateContacts {
+				if c.AlternateContactType == a.accountsManifestOwnerContact {
+					ac.Owner = AccountOwner(c.EmailAddress)
+				}
+			}
+		}
+		if !a.disablePath {
+			ac.Path = AccountPath(e.Path)
+		}
+		accountMap[ac.ID] = ac
+	}
+
+	log.Debugf("read %d accounts from manifest %s", len(accountMap), a.accountsManifest)
+
+	return accountMap, nil
+}
@@ -0,0 +1,59 @@
+package aws
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/organizations"
+)
+
+const (
+	// AccountStatusPendingClosure is the status of accounts closed within
+	// the last 90 days, which isn't known to the organizations package yet
+	AccountStatusPendingClosure = "PENDING_CLOSURE"
+
+	// InactiveAccountsSkip drops the costs of suspended and closing accounts
+	InactiveAccountsSkip = "skip"
+	// InactiveAccountsLabel exposes the costs of suspended and closing
+	// accounts with their lowercase status as type label
+	InactiveAccountsLabel = "label"
+	// InactiveAccountsKeep exposes the costs of all accounts alike
+	InactiveAccountsKeep = "keep"
+)
+
+// inactive returns true if the account is suspended or being closed
+func (ac *Account) inactive() bool {
+	switch ac.Status {
+	case organizations.AccountStatusSuspended, AccountStatusPendingClosure:
+		return true
+	}
+	return false
+}
+
+// WithInactiveAccounts selects how the costs of suspended and closing
+// accounts are exposed, one of InactiveAccountsSkip, InactiveAccountsLabel
+// or InactiveAccountsKeep
+func (a *AWSBilling) WithInactiveAccounts(mode string) (*AWSBilling, error) {
+	switch mode {
+	case InactiveAccountsSkip, InactiveAccountsLabel, InactiveAccountsKeep:
+	default:
+		return nil, fmt.Errorf("unknown inactive accounts mode '%s', expected one of %s, %s or %s", mode, InactiveAccountsSkip, InactiveAccountsLabel, InactiveAccountsKeep)
+	}
+	a.inactiveAccounts = mode
+	return a, nil
+}
+
+// accountType returns the type label of the costs of the account, it is
+// false if the costs are skipped
+func (a *AWSBilling) accountType(ac *Account) (string, bool) {
+	if !ac.inactive() {
+		return "", true
+	}
+	switch a.inactiveAccounts {
+	case InactiveAccountsSkip:
+		return "", false
+	case InactiveAccountsLabel:
+		return strings.ToLower(ac.Status), true
+	}
+	return "", true
+}
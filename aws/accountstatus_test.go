@@ -0,0 +1,108 @@
+package aws
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+	"github.com/simonswine/cloud-billing-exporter/fake"
+)
+
+// seriesCount returns the number of series of the collector
+func seriesCount(c prometheus.Collector) int {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+	count := 0
+	for range ch {
+		count++
+	}
+	return count
+}
+
+func TestInactiveAccounts(t *testing.T) {
+	elems := func() []*awsBillingElement {
+		return []*awsBillingElement{
+			{ProjectID: "12340001", ServiceName: "AmazonEC2", Currency: "USD", Costs: 8},
+			{ProjectID: "12340003", ServiceName: "AmazonS3", Currency: "USD", Costs: 2},
+			{ProjectID: "12340004", ServiceName: "AmazonS3", Currency: "USD", Costs: 1},
+		}
+	}
+	newBilling := func(status string) (*AWSBilling, *prometheus.CounterVec) {
+		metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+		a := NewAWSBilling(metric, "billing", "eu-west-1", "12340002", "", "owner", "project").WithOwnerAndPath(false, false).WithClients(Clients{
+			Organizations: &fake.Organizations{
+				Accounts: []*organizations.Account{
+					{Id: aws.String("12340001"), Name: aws.String("acme-dev"), Status: aws.String(organizations.AccountStatusActive)},
+					{Id: aws.String("12340003"), Name: aws.String("sandbox-1"), Status: aws.String(status)},
+					{Id: aws.String("12340004"), Name: aws.String("sandbox-2"), Status: aws.String(AccountStatusPendingClosure)},
+				},
+			},
+		})
+		return a, metric
+	}
+
+	for _, c := range []struct {
+		mode string
+		exp  map[string]string
+	}{
+		{mode: InactiveAccountsSkip, exp: map[string]string{"acme-dev": ""}},
+		{mode: InactiveAccountsLabel, exp: map[string]string{"acme-dev": "", "sandbox-1": "suspended", "sandbox-2": "pending_closure"}},
+		{mode: InactiveAccountsKeep, exp: map[string]string{"acme-dev": "", "sandbox-1": "", "sandbox-2": ""}},
+	} {
+		a, metric := newBilling(organizations.AccountStatusSuspended)
+		if _, err := a.WithInactiveAccounts(c.mode); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		a.updateCosts(context.Background(), "2019-11", elems(), "")
+
+		records := a.Records()
+		if len(records) != len(c.exp) {
+			t.Errorf("%s: unexpected records: %+v", c.mode, records)
+		}
+		for _, r := range records {
+			if typ, ok := c.exp[r.Account]; !ok || typ != r.Type {
+				t.Errorf("%s: unexpected record: %+v", c.mode, r)
+			}
+		}
+		if act := seriesCount(metric); act != len(c.exp) {
+			t.Errorf("%s: unexpected number of series: %d (expected: %d)", c.mode, act, len(c.exp))
+		}
+	}
+
+	if _, err := NewAWSBilling(nil, "billing", "eu-west-1", "", "", "owner", "project").WithInactiveAccounts("drop"); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
+
+func TestInactiveAccountsSuspended(t *testing.T) {
+	metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+	organization := &fake.Organizations{
+		Accounts: []*organizations.Account{
+			{Id: aws.String("12340003"), Name: aws.String("sandbox-1"), Status: aws.String(organizations.AccountStatusActive)},
+		},
+	}
+	a := NewAWSBilling(metric, "billing", "eu-west-1", "12340002", "", "owner", "project").WithOwnerAndPath(false, false).WithClients(Clients{Organizations: organization})
+	elems := []*awsBillingElement{{ProjectID: "12340003", ServiceName: "AmazonS3", Currency: "USD", Costs: 2}}
+	a.updateCosts(context.Background(), "2019-11", elems, "")
+	if act := seriesCount(metric); act != 1 {
+		t.Fatalf("unexpected number of series: %d (expected: 1)", act)
+	}
+
+	// the series of the account is dropped once it is suspended
+	organization.Accounts[0].Status = aws.String(organizations.AccountStatusSuspended)
+	a.accountNameByIDAPI = nil
+	a.updateCosts(context.Background(), "2019-11", elems, "")
+	if act := seriesCount(metric); act != 0 {
+		t.Errorf("unexpected number of series: %d (expected: 0)", act)
+	}
+	if len(a.metricValues) != 0 {
+		t.Errorf("unexpected baselines: %+v", a.metricValues)
+	}
+}
@@ -0,0 +1,285 @@
+package aws
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/prometheus/common/log"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+// athenaIdentifier matches valid Athena database and table names
+var athenaIdentifier = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+
+// athenaQueryTemplate sums up the costs of a billing period of a CUR table
+// set up through the CUR Athena integration, it is partitioned by year and
+// month
+const athenaQueryTemplate = `SELECT line_item_usage_account_id, line_item_product_code, line_item_currency_code, SUM(line_item_unblended_cost)
+FROM "%s"."%s"
+WHERE year = '%d' AND month = '%d'
+GROUP BY line_item_usage_account_id, line_item_product_code, line_item_currency_code`
+
+// athenaHourlyQueryTemplate sums up the costs per hour since a time of a CUR
+// table with hourly granularity, the partitions of the months are given
+const athenaHourlyQueryTemplate = `SELECT line_item_usage_account_id, line_item_product_code, line_item_currency_code, date_format(line_item_usage_start_date, '%%Y-%%m-%%dT%%H'), SUM(line_item_unblended_cost)
+FROM "%s"."%s"
+WHERE (%s) AND line_item_usage_start_date >= timestamp '%s'
+GROUP BY 1, 2, 3, 4`
+
+// athenaQuery queries the costs of the Cost and Usage Report through Athena,
+// instead of downloading and parsing the report files
+type athenaQuery struct {
+	svc QueryRunner
+
+	database       string
+	table          string
+	workgroup      string
+	outputLocation string
+
+	// interval is the minimum time between queries, as they are billed per
+	// data scanned
+	interval  time.Duration
+	lastQuery time.Time
+
+	pollInterval time.Duration
+}
+
+// WithAthena queries the costs of the current month from a CUR table in
+// Athena instead of the billing reports in the bucket
+func (a *AWSBilling) WithAthena(database, table, workgroup, outputLocation string, interval time.Duration) (*AWSBilling, error) {
+	for _, name := range []string{database, table} {
+		if !athenaIdentifier.MatchString(name) {
+			return nil, fmt.Errorf("invalid athena database or table name '%s'", name)
+		}
+	}
+	if outputLocation == "" && workgroup == "" {
+		return nil, fmt.Errorf("athena needs either an output location or a workgroup")
+	}
+
+	svc, err := a.queryRunner()
+	if err != nil {
+		return nil, err
+	}
+	a.athena = &athenaQuery{
+		svc:            svc,
+		database:       database,
+		table:          table,
+		workgroup:      workgroup,
+		outputLocation: outputLocation,
+		interval:       interval,
+		pollInterval:   time.Second,
+	}
+	return a, nil
+}
+
+// execute starts the query and waits for it to succeed, it returns the
+// query execution ID
+func (q *athenaQuery) execute(ctx context.Context, query string) (*string, error) {
+	input := &athena.StartQueryExecutionInput{
+		QueryString:           aws.String(query),
+		QueryExecutionContext: &athena.QueryExecutionContext{Database: aws.String(q.database)},
+	}
+	if q.workgroup != "" {
+		input.WorkGroup = aws.String(q.workgroup)
+	}
+	if q.outputLocation != "" {
+		input.ResultConfiguration = &athena.ResultConfiguration{OutputLocation: aws.String(q.outputLocation)}
+	}
+
+	start, err := q.svc.StartQueryExecutionWithContext(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("error starting athena query: %s", err)
+	}
+	id := start.QueryExecutionId
+	log.Debugf("started athena query %s", *id)
+
+	for {
+		resp, err := q.svc.GetQueryExecutionWithContext(ctx, &athena.GetQueryExecutionInput{QueryExecutionId: id})
+		if err != nil {
+			return nil, fmt.Errorf("error getting status of athena query %s: %s", *id, err)
+		}
+		status := resp.QueryExecution.Status
+		done := false
+		switch aws.StringValue(status.State) {
+		case athena.QueryExecutionStateSucceeded:
+			done = true
+		case athena.QueryExecutionStateFailed, athena.QueryExecutionStateCancelled:
+			return nil, fmt.Errorf("athena query %s %s: %s", *id, aws.StringValue(status.State), aws.StringValue(status.StateChangeReason))
+		}
+		if done {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(q.pollInterval):
+		}
+	}
+	return id, nil
+}
+
+// results reads the rows of the query results without the header, they need
+// to have the given number of columns
+func (q *athenaQuery) results(ctx context.Context, id *string, columns int, fn func(row []string)) error {
+	var parseErr error
+	header := true
+	if err := q.svc.GetQueryResultsPagesWithContext(ctx, &athena.GetQueryResultsInput{QueryExecutionId: id}, func(resp *athena.GetQueryResultsOutput, _ bool) bool {
+		for _, row := range resp.ResultSet.Rows {
+			// the first row contains the column names
+			if header {
+				header = false
+				continue
+			}
+			if len(row.Data) != columns {
+				parseErr = fmt.Errorf("unexpected number of columns: %d", len(row.Data))
+				return false
+			}
+			values := make([]string, len(row.Data))
+			for i, d := range row.Data {
+				values[i] = aws.StringValue(d.VarCharValue)
+			}
+			fn(values)
+		}
+		return true
+	}); err != nil {
+		return fmt.Errorf("error getting results of athena query %s: %s", *id, err)
+	}
+	if parseErr != nil {
+		return fmt.Errorf("error parsing results of athena query %s: %s", *id, parseErr)
+	}
+	return nil
+}
+
+// run executes the query for a billing month and waits for its results
+func (q *athenaQuery) run(ctx context.Context, month time.Time) ([]*awsBillingElement, error) {
+	id, err := q.execute(ctx, fmt.Sprintf(athenaQueryTemplate, q.database, q.table, month.Year(), int(month.Month())))
+	if err != nil {
+		return nil, err
+	}
+
+	var elems []*awsBillingElement
+	if err := q.results(ctx, id, 4, func(row []string) {
+		costs, err := strconv.ParseFloat(row[3], 64)
+		if err != nil {
+			log.Warnf("Couldn't parse costs float: %s", err)
+			return
+		}
+		elems = append(elems, &awsBillingElement{
+			ProjectID:   row[0],
+			ServiceName: row[1],
+			Currency:    row[2],
+			Costs:       costs,
+		})
+	}); err != nil {
+		return nil, err
+	}
+	return elems, nil
+}
+
+// WithHourlyCosts queries the costs per hour of the last window from the CUR
+// table in Athena, the report needs to have hourly granularity
+func (a *AWSBilling) WithHourlyCosts(window time.Duration) *AWSBilling {
+	a.hourlyWindow = window
+	return a
+}
+
+// runHourly executes the query of the costs per hour from since until now
+func (q *athenaQuery) runHourly(ctx context.Context, since, now time.Time) ([]billing.HourlyCost, error) {
+	var partitions []string
+	for month := time.Date(since.Year(), since.Month(), 1, 0, 0, 0, 0, time.UTC); !month.After(now); month = month.AddDate(0, 1, 0) {
+		partitions = append(partitions, fmt.Sprintf("(year = '%d' AND month = '%d')", month.Year(), int(month.Month())))
+	}
+	id, err := q.execute(ctx, fmt.Sprintf(athenaHourlyQueryTemplate, q.database, q.table, strings.Join(partitions, " OR "), since.Format("2006-01-02 15:04:05")))
+	if err != nil {
+		return nil, err
+	}
+
+	var costs []billing.HourlyCost
+	if err := q.results(ctx, id, 5, func(row []string) {
+		value, err := strconv.ParseFloat(row[4], 64)
+		if err != nil {
+			log.Warnf("Couldn't parse costs float: %s", err)
+			return
+		}
+		costs = append(costs, billing.HourlyCost{
+			Cloud:    "aws",
+			Hour:     row[3],
+			Currency: row[2],
+			Account:  row[0],
+			Service:  row[1],
+			Costs:    value,
+		})
+	}); err != nil {
+		return nil, err
+	}
+	return costs, nil
+}
+
+// elementsHash identifies the results of a query, so unchanged results are
+// not applied again
+func elementsHash(elems []*awsBillingElement) string {
+	lines := make([]string, 0, len(elems))
+	for _, e := range elems {
+		lines = append(lines, fmt.Sprintf("%s\x00%s\x00%s\x00%v", e.ProjectID, e.ServiceName, e.Currency, e.Costs))
+	}
+	sort.Strings(lines)
+
+	h := sha256.New()
+	for _, l := range lines {
+		fmt.Fprintln(h, l)
+	}
+	return fmt.Sprintf("athena:%x", h.Sum(nil))
+}
+
+// queryAthena updates the costs of the current month from Athena
+func (a *AWSBilling) queryAthena(ctx context.Context) error {
+	a.ReportsLock.Lock()
+	defer a.ReportsLock.Unlock()
+
+	if err := a.restoreState(ctx); err != nil {
+		return err
+	}
+
+	now := a.time.Now()
+	if !a.athena.lastQuery.IsZero() && now.Sub(a.athena.lastQuery) < a.athena.interval {
+		log.Debugf("athena has been queried at %s already", a.athena.lastQuery)
+		return nil
+	}
+
+	elems, err := a.athena.run(ctx, now.UTC())
+	if err != nil {
+		return err
+	}
+	a.athena.lastQuery = now
+
+	if a.hourlyWindow > 0 {
+		since := now.UTC().Add(-a.hourlyWindow).Truncate(time.Hour)
+		hourly, err := a.athena.runHourly(ctx, since, now.UTC())
+		if err != nil {
+			log.Warnf("error querying hourly costs: %s", err)
+		} else {
+			for i := range hourly {
+				hourly[i].Account = string(a.AccountByID(AccountID(hourly[i].Account)).Name)
+			}
+			a.setHourlyCosts(hourly)
+		}
+	}
+
+	hash := elementsHash(elems)
+	if a.ReportHash == hash {
+		log.Debugf("athena query results have not changed")
+		return nil
+	}
+	a.updateCosts(ctx, now.UTC().Format("2006-01"), elems, hash)
+	return nil
+}
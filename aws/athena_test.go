@@ -0,0 +1,129 @@
+package aws
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
+)
+
+type fakeAthena struct {
+	athenaiface.AthenaAPI
+
+	input  *athena.StartQueryExecutionInput
+	states []string
+	rows   [][]string
+}
+
+func (f *fakeAthena) StartQueryExecutionWithContext(_ aws.Context, input *athena.StartQueryExecutionInput, _ ...request.Option) (*athena.StartQueryExecutionOutput, error) {
+	f.input = input
+	return &athena.StartQueryExecutionOutput{QueryExecutionId: aws.String("query-1")}, nil
+}
+
+func (f *fakeAthena) GetQueryExecutionWithContext(_ aws.Context, _ *athena.GetQueryExecutionInput, _ ...request.Option) (*athena.GetQueryExecutionOutput, error) {
+	state := f.states[0]
+	f.states = f.states[1:]
+	return &athena.GetQueryExecutionOutput{QueryExecution: &athena.QueryExecution{
+		Status: &athena.QueryExecutionStatus{State: aws.String(state), StateChangeReason: aws.String("broken")},
+	}}, nil
+}
+
+func (f *fakeAthena) GetQueryResultsPagesWithContext(_ aws.Context, _ *athena.GetQueryResultsInput, fn func(*athena.GetQueryResultsOutput, bool) bool, _ ...request.Option) error {
+	resp := &athena.GetQueryResultsOutput{ResultSet: &athena.ResultSet{}}
+	for _, row := range f.rows {
+		r := &athena.Row{}
+		for _, v := range row {
+			r.Data = append(r.Data, &athena.Datum{VarCharValue: aws.String(v)})
+		}
+		resp.ResultSet.Rows = append(resp.ResultSet.Rows, r)
+	}
+	fn(resp, true)
+	return nil
+}
+
+func TestAthenaQuery(t *testing.T) {
+	fake := &fakeAthena{
+		states: []string{athena.QueryExecutionStateQueued, athena.QueryExecutionStateRunning, athena.QueryExecutionStateSucceeded},
+		rows: [][]string{
+			{"line_item_usage_account_id", "line_item_product_code", "line_item_currency_code", "_col3"},
+			{"12340001", "AmazonEC2", "USD", "10.5"},
+			{"12340002", "AmazonS3", "USD", "0.25"},
+		},
+	}
+	q := &athenaQuery{
+		svc:            fake,
+		database:       "cur",
+		table:          "report",
+		outputLocation: "s3://athena-results/",
+	}
+
+	elems, err := q.run(context.Background(), time.Date(2019, 11, 20, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if query := aws.StringValue(fake.input.QueryString); !strings.Contains(query, `FROM "cur"."report"`) || !strings.Contains(query, `year = '2019' AND month = '11'`) {
+		t.Errorf("unexpected query: %s", query)
+	}
+	if act := aws.StringValue(fake.input.ResultConfiguration.OutputLocation); act != "s3://athena-results/" {
+		t.Errorf("unexpected output location: %s", act)
+	}
+	if fake.input.WorkGroup != nil {
+		t.Errorf("unexpected workgroup: %s", *fake.input.WorkGroup)
+	}
+
+	if len(elems) != 2 {
+		t.Fatalf("unexpected elements: %+v", elems)
+	}
+	if e := elems[0]; e.ProjectID != "12340001" || e.ServiceName != "AmazonEC2" || e.Currency != "USD" || e.Costs != 10.5 {
+		t.Errorf("unexpected element: %+v", e)
+	}
+
+	// the hash doesn't depend on the order of the results
+	if elementsHash(elems) != elementsHash([]*awsBillingElement{elems[1], elems[0]}) {
+		t.Error("expected hash to be independent of the order")
+	}
+}
+
+func TestAthenaQueryFailed(t *testing.T) {
+	q := &athenaQuery{
+		svc:       &fakeAthena{states: []string{athena.QueryExecutionStateFailed}},
+		database:  "cur",
+		table:     "report",
+		workgroup: "billing",
+	}
+
+	if _, err := q.run(context.Background(), time.Now()); err == nil || !strings.Contains(err.Error(), "FAILED: broken") {
+		t.Errorf("expected failed query error, got: %v", err)
+	}
+}
+
+func TestAthenaHourlyQuery(t *testing.T) {
+	fake := &fakeAthena{
+		states: []string{athena.QueryExecutionStateSucceeded},
+		rows: [][]string{
+			{"line_item_usage_account_id", "line_item_product_code", "line_item_currency_code", "_col3", "_col4"},
+			{"12340001", "AmazonEC2", "USD", "2019-11-30T23", "1.5"},
+			{"12340001", "AmazonEC2", "USD", "2019-12-01T00", "invalid"},
+		},
+	}
+	q := &athenaQuery{svc: fake, database: "cur", table: "report", workgroup: "primary"}
+
+	costs, err := q.runHourly(context.Background(), time.Date(2019, 11, 29, 2, 0, 0, 0, time.UTC), time.Date(2019, 12, 2, 2, 30, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	query := aws.StringValue(fake.input.QueryString)
+	if !strings.Contains(query, `WHERE ((year = '2019' AND month = '11') OR (year = '2019' AND month = '12')) AND line_item_usage_start_date >= timestamp '2019-11-29 02:00:00'`) {
+		t.Errorf("unexpected query: %s", query)
+	}
+	if len(costs) != 1 || costs[0].Hour != "2019-11-30T23" || costs[0].Account != "12340001" || costs[0].Costs != 1.5 {
+		t.Errorf("unexpected hourly costs: %+v", costs)
+	}
+}
@@ -2,6 +2,7 @@ package aws
 
 import (
 	"context"
+	"crypto/md5"
 	"encoding/csv"
 	"fmt"
 	"io"
@@ -11,12 +12,20 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/organizations"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+	"github.com/simonswine/cloud-billing-exporter/localfs"
+	"github.com/simonswine/cloud-billing-exporter/parse"
+	"github.com/simonswine/cloud-billing-exporter/state"
 )
 
 type Clock interface {
@@ -35,6 +44,15 @@ type awsBillingElement struct {
 	ProjectID   string
 	ServiceName string
 	Costs       float64
+	// Refunds is the sum of the negative line items, which are included in
+	// the costs
+	Refunds float64
+	// Taxes, Credits and RefundItems are the amounts of the tax, credit and
+	// refund line items, which are included in the costs. Credits and
+	// refunds are positive.
+	Taxes       float64
+	Credits     float64
+	RefundItems float64
 	Currency    string
 }
 
@@ -51,6 +69,11 @@ type Account struct {
 	Parent AccountID
 	Path   AccountPath
 	Type   AccountType
+	// Status is the status of the account in the organization, e.g. ACTIVE
+	// or SUSPENDED, it is empty if unknown
+	Status string
+	// Tags are the values of the account tags mapped to labels
+	Tags map[string]string `json:",omitempty"`
 }
 
 type (
@@ -64,9 +87,23 @@ type (
 type AWSBilling struct {
 	time Clock
 
+	// clients replace the AWS API clients created from the default session
+	clients Clients
+
+	// pipeline parses the reports, they are parsed in their own goroutine
+	// if not set
+	pipeline *parse.Pipeline
+
+	// apiLatency observes the calls of the AWS APIs
+	apiLatency *billing.APILatency
+
 	BucketName string
 	Region     string
 
+	// requesterPays acknowledges that the requests to the bucket are billed
+	// to the exporter's account
+	requesterPays bool
+
 	OwnerTag     string
 	ProjectIDTag string
 
@@ -79,23 +116,136 @@ type AWSBilling struct {
 	accountNameByIDAPILastUpdate time.Time
 	accountNameByIDAPILock       sync.Mutex
 
-	rootAccountID string
+	// accountsManifest is read instead of the organizations API, if set
+	accountsManifest             string
+	accountsManifestOwnerContact string
+
+	// inactiveAccounts selects how the costs of suspended and closing
+	// accounts are exposed
+	inactiveAccounts string
+
+	// rootAccountID is looked up from the caller identity once, if not set
+	rootAccountID     string
+	rootAccountIDLock sync.Mutex
+
+	// billingAccount is the name of the billing account, it defaults to the
+	// root account ID
+	billingAccount     string
+	billingAccountLock sync.Mutex
+
+	// stsRegional uses the STS endpoint of the region instead of the global
+	// one, stsEndpoint overrides the endpoint URL, e.g. of a VPC endpoint
+	stsRegional bool
+	stsEndpoint string
+
+	// roleARN is the role assumed with the default credentials, its
+	// credentials are shared by the sessions
+	roleARN        string
+	roleExternalID string
+	roleCreds      *credentials.Credentials
+	roleLock       sync.Mutex
+
+	// disableEnrichment skips the account lookup through the organizations
+	// API, accounts are labeled by their ID unless overridden
+	disableEnrichment bool
+
+	// disableOwner and disablePath leave the owner and path labels empty,
+	// the account tags and parents are not looked up
+	disableOwner bool
+	disablePath  bool
+	// tagKeys are the keys of the account tags mapped to labels
+	tagKeys []string
+
+	// reportType selects the detailed billing reports or the Cost and Usage
+	// Reports below reportPrefix
+	reportType   string
+	reportPrefix string
+
+	// curTagColumn is the resource tag column the Cost and Usage Reports are
+	// aggregated by instead of the linked account, if set
+	curTagColumn string
+	// reportKeys matches the keys of the reports in the bucket
+	reportKeys *reportKeyPattern
+	// local is set if the reports are read from a local directory
+	local *fileReportBucket
+	// recordTypes are the record types of the rows aggregated
+	recordTypes map[string]bool
 
 	ReportsLock sync.Mutex
 	ReportHash  string
 
+	// downloadPartSize and downloadConcurrency configure ranged downloads
+	// of large reports
+	downloadPartSize    int64
+	downloadConcurrency int
+	// downloadDir persists partial downloads to resume them
+	downloadDir string
+
+	// athena queries the costs from Athena instead of the reports, if set
+	athena *athenaQuery
+	// hourlyWindow is the time the costs per hour are queried for from
+	// Athena
+	hourlyWindow time.Duration
+
+	// records contains the costs of the latest parsed report
+	records     []billing.Record
+	lineItems   []billing.LineItem
+	usage       []billing.Usage
+	adjustments []billing.Adjustment
+	hourlyCosts []billing.HourlyCost
+	// diagnostics describe the reports found by the last query
+	diagnostics billing.Diagnostics
+	recordsLock sync.Mutex
+
 	MetricMonthlyCosts *prometheus.CounterVec
-	metricValues       map[string]float64
+	metricRefunds      *prometheus.CounterVec
+	onDecrease         billing.OnDecrease
+	metricValues       map[string]state.Baseline
+
+	// stateStore persists report hash and counter baselines, so they survive
+	// restarts and can be shared between replicas
+	stateStore    state.Store
+	stateRestored bool
+}
+
+// awsBillingState is the part of AWSBilling persisted in the state store
+type awsBillingState struct {
+	ReportHash string
+	Baselines  map[string]state.Baseline
+	Records    []billing.Record
+	Usage      []billing.Usage `json:",omitempty"`
+	// Adjustments are the taxes, credits and refunds of the records
+	Adjustments []billing.Adjustment `json:",omitempty"`
+
+	// Accounts caches the account mapping retrieved from the organizations
+	// API
+	Accounts        map[AccountID]*Account
+	AccountsUpdated time.Time
 }
 
-func readCSV(input io.Reader) ([]*awsBillingElement, error) {
+// DefaultRecordTypes are the record types of the line items of the linked
+// accounts in reports of consolidated billing
+var DefaultRecordTypes = []string{"LinkedLineItem"}
+
+// readCSV returns the costs per account, service and currency and the usage
+// per usage type of the rows of the record types in a billing report, the
+// default record types are used if nil. The sums are aggregated within the
+// memory budget of the pipeline, the rows are counted in stats.
+func readCSV(input io.Reader, recordTypes map[string]bool, p *parse.Pipeline, stats *parse.Stats) ([]*awsBillingElement, []billing.Usage, error) {
+	if recordTypes == nil {
+		recordTypes = recordTypeSet(DefaultRecordTypes)
+	}
+
 	r := csv.NewReader(input)
 
 	pos := map[string]int{
 		"RecordType": -1,
 	}
 
-	elems := []*awsBillingElement{}
+	costs := p.NewAggregator()
+	defer costs.Close()
+	quantities := p.NewAggregator()
+	defer quantities.Close()
 
 	for {
 		record, err := r.Read()
@@ -103,7 +253,7 @@ func readCSV(input io.Reader) ([]*awsBillingElement, error) {
 			break
 		}
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		// get first line
@@ -113,52 +263,126 @@ func readCSV(input io.Reader) ([]*awsBillingElement, error) {
 			}
 			continue
 		}
+		stats.Read()
 
-		// skip if not a linked line item
-		if record[pos["RecordType"]] != "LinkedLineItem" {
+		// skip totals and line items of other record types
+		if !recordTypes[record[pos["RecordType"]]] {
+			stats.Filtered()
 			continue
 		}
+		// rows of the payer account have no linked account
+		account := record[pos["LinkedAccountId"]]
+		if account == "" {
+			if i, ok := pos["PayerAccountId"]; ok {
+				account = record[i]
+			}
+		}
 
-		costs, err := strconv.ParseFloat(
+		cost, err := strconv.ParseFloat(
 			record[pos["TotalCost"]],
 			64,
 		)
 		if err != nil {
 			log.Warnf("Couldn't parse consts float: %s", err)
+			stats.InvalidCost()
 			continue
 		}
+		stats.Aggregated(account)
 
-		elems = append(elems, &awsBillingElement{
-			ProjectID:   record[pos["LinkedAccountId"]],
-			ServiceName: record[pos["ProductCode"]],
-			Costs:       costs,
-			Currency:    record[pos["CurrencyCode"]],
-		})
+		refund := 0.0
+		if cost < 0 {
+			refund = -cost
+		}
+		// the taxes and credits are part of the total costs of the row,
+		// refunds are negative costs before tax
+		tax := columnAmount(record, pos, "TaxAmount")
+		credit := -columnAmount(record, pos, "Credits")
+		refundItem := 0.0
+		if beforeTax := columnAmount(record, pos, "CostBeforeTax"); beforeTax < 0 {
+			refundItem = -beforeTax
+		}
+		if err := costs.Add(aggregationKey(
+			account,
+			record[pos["ProductCode"]],
+			record[pos["CurrencyCode"]],
+		), cost, refund, tax, credit, refundItem); err != nil {
+			return nil, nil, err
+		}
+
+		if i, ok := pos["UsageQuantity"]; ok && record[pos["UsageType"]] != "" {
+			quantity, err := strconv.ParseFloat(record[i], 64)
+			if err != nil {
+				log.Warnf("Couldn't parse usage quantity float: %s", err)
+				continue
+			}
+			if err := quantities.Add(aggregationKey(
+				record[pos["ProductCode"]],
+				record[pos["UsageType"]],
+				record[pos["CurrencyCode"]],
+			), quantity, cost); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	return aggregatedCosts(costs, quantities)
+}
+
+// columnAmount returns the amount of an optional column of the row, it is 0
+// if the column is missing or empty
+func columnAmount(record []string, pos map[string]int, column string) float64 {
+	i, ok := pos[column]
+	if !ok || i >= len(record) || record[i] == "" {
+		return 0
+	}
+	amount, err := strconv.ParseFloat(record[i], 64)
+	if err != nil {
+		log.Warnf("Couldn't parse %s float: %s", column, err)
+		return 0
 	}
-	return reduceElementsByFunc(elems, groupByProjectIDServiceCurrency), nil
+	return amount
 }
 
-func reduceElementsByFunc(elementsIn []*awsBillingElement, fnKey func(*awsBillingElement) string) []*awsBillingElement {
-	keyMap := map[string]*awsBillingElement{}
-	elementsOut := []*awsBillingElement{}
+// aggregatedCosts returns the costs per account, service and currency and
+// the usage per usage type summed up by the aggregators
+func aggregatedCosts(costs, quantities *parse.Aggregator) ([]*awsBillingElement, []billing.Usage, error) {
+	elems := []*awsBillingElement{}
+	if err := costs.Each(func(key string, values []float64) {
+		fields := strings.Split(key, "\x00")
+		elems = append(elems, &awsBillingElement{
+			ProjectID:   fields[0],
+			ServiceName: fields[1],
+			Currency:    fields[2],
+			Costs:       values[0],
+			Refunds:     values[1],
+			Taxes:       values[2],
+			Credits:     values[3],
+			RefundItems: values[4],
+		})
+	}); err != nil {
+		return nil, nil, err
+	}
 
-	for _, elem := range elementsIn {
-		key := fnKey(elem)
-		if groupElem, ok := keyMap[key]; !ok {
-			e := &awsBillingElement{
-				ProjectID:   elem.ProjectID,
-				ProjectName: elem.ProjectName,
-				ServiceName: elem.ServiceName,
-				Costs:       elem.Costs,
-				Currency:    elem.Currency,
-			}
-			elementsOut = append(elementsOut, e)
-			keyMap[key] = e
-		} else {
-			groupElem.Costs = groupElem.Costs + elem.Costs
-		}
+	var usage []billing.Usage
+	if err := quantities.Each(func(key string, values []float64) {
+		fields := strings.Split(key, "\x00")
+		usage = append(usage, billing.Usage{
+			Cloud:    "aws",
+			Service:  fields[0],
+			SKU:      fields[1],
+			Currency: fields[2],
+			Quantity: values[0],
+			Costs:    values[1],
+		})
+	}); err != nil {
+		return nil, nil, err
 	}
-	return elementsOut
+	return elems, usage, nil
+}
+
+// aggregationKey joins the fields of a key, which don't contain NUL bytes
+func aggregationKey(fields ...string) string {
+	return strings.Join(fields, "\x00")
 }
 
 func groupByProjectIDServiceCurrency(e *awsBillingElement) string {
@@ -185,20 +409,191 @@ func NewAWSBilling(metric *prometheus.CounterVec, bucketName, region, rootAccoun
 		log.With("account_id", id).With("account_name", name).Debug("manual account mapping set up")
 	}
 
-	return &AWSBilling{
+	reportKeys, err := parseReportKeyPattern(DefaultReportKeyPattern)
+	if err != nil {
+		panic(err)
+	}
+
+	a := &AWSBilling{
 		MetricMonthlyCosts:      metric,
 		BucketName:              bucketName,
 		Region:                  region,
 		OwnerTag:                ownerTag,
 		ProjectIDTag:            projectIDTag,
 		rootAccountID:           rootAccountID,
-		metricValues:            map[string]float64{},
+		metricValues:            map[string]state.Baseline{},
 		accountNameByIDOverride: accountMap,
+		reportType:              ReportTypeDBR,
+		reportKeys:              reportKeys,
+		inactiveAccounts:        InactiveAccountsSkip,
 		time:                    &realClock{},
 	}
+	if dir, ok := localfs.Dir(bucketName); ok {
+		a.local = newFileReportBucket(dir)
+	}
+	return a
+}
+
+// WithReportKeyPattern finds the reports by a pattern of their keys, e.g.
+// reports/{account}/{year}/{mm}/costs for renamed reports below a prefix
+func (a *AWSBilling) WithReportKeyPattern(pattern string) (*AWSBilling, error) {
+	p, err := parseReportKeyPattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	a.reportKeys = p
+	return a, nil
+}
+
+// WithStateStore enables persisting the collector state in the given store
+func (a *AWSBilling) WithStateStore(s state.Store) *AWSBilling {
+	a.stateStore = s
+	return a
+}
+
+// WithOnDecrease selects how falling costs are handled, the refunds counter
+// is used by billing.OnDecreaseRefundMetric
+func (a *AWSBilling) WithOnDecrease(mode billing.OnDecrease, refunds *prometheus.CounterVec) *AWSBilling {
+	a.onDecrease = mode
+	a.metricRefunds = refunds
+	return a
+}
+
+// WithPipeline parses the reports on the workers of the given pipeline
+func (a *AWSBilling) WithPipeline(p *parse.Pipeline) *AWSBilling {
+	a.pipeline = p
+	return a
+}
+
+// WithAPILatency observes the duration of the calls of the AWS APIs
+func (a *AWSBilling) WithAPILatency(l *billing.APILatency) *AWSBilling {
+	a.apiLatency = l
+	return a
+}
+
+// WithRequesterPays sets the request payer of the bucket requests, which is
+// required for Requester Pays buckets
+func (a *AWSBilling) WithRequesterPays(enabled bool) *AWSBilling {
+	a.requesterPays = enabled
+	return a
+}
+
+// WithRecordTypes aggregates the rows of the given record types, e.g.
+// PayerLineItem or LineItem of standalone accounts without consolidated
+// billing
+func (a *AWSBilling) WithRecordTypes(recordTypes []string) *AWSBilling {
+	a.recordTypes = recordTypeSet(recordTypes)
+	return a
+}
+
+func recordTypeSet(recordTypes []string) map[string]bool {
+	set := make(map[string]bool, len(recordTypes))
+	for _, t := range recordTypes {
+		if t = strings.TrimSpace(t); t != "" {
+			set[t] = true
+		}
+	}
+	return set
+}
+
+// WithEnrichment enables looking up the account names, owners and paths
+// through the organizations API, which requires organizations permissions
+func (a *AWSBilling) WithEnrichment(enabled bool) *AWSBilling {
+	a.disableEnrichment = !enabled
+	return a
+}
+
+// WithOwnerAndPath enables the owner and path labels, which are looked up
+// from the account tags and the position of the account in the organization
+func (a *AWSBilling) WithOwnerAndPath(owner, path bool) *AWSBilling {
+	a.disableOwner = !owner
+	a.disablePath = !path
+	return a
+}
+
+// WithSTSEndpoint configures the STS endpoint used to look up the account of
+// the credentials. Regional endpoints are required in VPCs without access to
+// the global endpoint, the endpoint URL is set for VPC endpoints.
+func (a *AWSBilling) WithSTSEndpoint(regional bool, endpoint string) *AWSBilling {
+	a.stsRegional = regional
+	a.stsEndpoint = endpoint
+	return a
+}
+
+// requestPayer returns the value of the request payer header
+func (a *AWSBilling) requestPayer() *string {
+	if !a.requesterPays {
+		return nil
+	}
+	return aws.String(s3.RequestPayerRequester)
+}
+
+func (a *AWSBilling) stateKey() string {
+	if a.athena != nil {
+		return fmt.Sprintf("aws/athena/%s.%s", a.athena.database, a.athena.table)
+	}
+	return fmt.Sprintf("aws/%s", a.BucketName)
+}
+
+// restoreState loads the persisted state once, before the first report gets
+// parsed. If that fails the report is not parsed, as otherwise the complete
+// month's costs would be added to the counters again.
+func (a *AWSBilling) restoreState(ctx context.Context) error {
+	if a.stateStore == nil || a.stateRestored {
+		return nil
+	}
+
+	var s awsBillingState
+	if err := state.Load(ctx, a.stateStore, a.stateKey(), &s); err == state.ErrNotFound {
+		log.Debugf("no previous state for '%s' found in %s", a.stateKey(), a.stateStore)
+	} else if err != nil {
+		return fmt.Errorf("error restoring state from %s: %s", a.stateStore, err)
+	}
+
+	for key, baseline := range s.Baselines {
+		// state written by a version with different labels can't be used
+		if _, err := a.MetricMonthlyCosts.GetMetricWithLabelValues(baseline.Labels...); err != nil {
+			log.Warnf("dropping baseline '%s' restored from %s: %s", key, a.stateStore, err)
+			continue
+		}
+		a.metricValues[key] = baseline
+	}
+	a.ReportHash = s.ReportHash
+	a.setRecords(s.Records, nil)
+	a.setUsage(s.Usage)
+	a.setAdjustments(s.Adjustments)
+	if s.Accounts != nil {
+		a.accountNameByIDAPILock.Lock()
+		a.accountNameByIDAPI = s.Accounts
+		a.accountNameByIDAPILastUpdate = s.AccountsUpdated
+		a.accountNameByIDAPILock.Unlock()
+	}
+	a.stateRestored = true
+	return nil
+}
+
+func (a *AWSBilling) saveState(ctx context.Context) {
+	if a.stateStore == nil {
+		return
+	}
+
+	a.accountNameByIDAPILock.Lock()
+	defer a.accountNameByIDAPILock.Unlock()
+
+	if err := state.Save(ctx, a.stateStore, a.stateKey(), &awsBillingState{
+		ReportHash:      a.ReportHash,
+		Baselines:       a.metricValues,
+		Records:         a.Records(),
+		Usage:           a.Usage(),
+		Adjustments:     a.Adjustments(),
+		Accounts:        a.accountNameByIDAPI,
+		AccountsUpdated: a.accountNameByIDAPILastUpdate,
+	}); err != nil {
+		log.Warnf("error persisting state to %s: %s", a.stateStore, err)
+	}
 }
 
-func (a *AWSBilling) getAccountPath(ctx context.Context, svc *organizations.Organizations, ac *Account, accountMap map[AccountID]*Account) ([]string, error) {
+func (a *AWSBilling) getAccountPath(ctx context.Context, svc OrganizationsReader, ac *Account, accountMap map[AccountID]*Account) ([]string, error) {
 	// we are at the root
 	if ac.Type == AccountTypeOrganization {
 		return []string{}, nil
@@ -266,41 +661,56 @@ func (a *AWSBilling) getAccountPath(ctx context.Context, svc *organizations.Orga
 }
 
 func (a *AWSBilling) getAccountNameByIDAPI(ctx context.Context) (map[AccountID]*Account, error) {
-	session, err := a.awsSession()
+	svc, err := a.organizationsReader()
 	if err != nil {
 		return nil, err
 	}
-	svc := organizations.New(session, a.awsConfig())
 
 	accountMap := make(map[AccountID]*Account)
 
 	if err := svc.ListAccountsPagesWithContext(ctx, &organizations.ListAccountsInput{}, func(resp *organizations.ListAccountsOutput, _ bool) bool {
 		for _, account := range resp.Accounts {
 			ac := &Account{
-				ID:   AccountID(*account.Id),
-				Name: AccountName(*account.Name),
+				ID:     AccountID(*account.Id),
+				Name:   AccountName(*account.Name),
+				Status: aws.StringValue(account.Status),
 			}
 
-			// resolve tags
-			if err := svc.ListTagsForResourcePagesWithContext(ctx, &organizations.ListTagsForResourceInput{ResourceId: aws.String(*account.Id)}, func(resp *organizations.ListTagsForResourceOutput, _ bool) bool {
-				for _, tag := range resp.Tags {
-					if *tag.Key == a.ProjectIDTag {
-						ac.Name = AccountName(*tag.Value)
-					}
-					if *tag.Key == a.OwnerTag {
-						ac.Owner = AccountOwner(*tag.Value)
+			// resolve tags, unless neither the name, the owner nor labels
+			// are taken from them
+			if a.ProjectIDTag != "" || !a.disableOwner || len(a.tagKeys) > 0 {
+				if err := svc.ListTagsForResourcePagesWithContext(ctx, &organizations.ListTagsForResourceInput{ResourceId: aws.String(*account.Id)}, func(resp *organizations.ListTagsForResourceOutput, _ bool) bool {
+					for _, tag := range resp.Tags {
+						if *tag.Key == a.ProjectIDTag {
+							ac.Name = AccountName(*tag.Value)
+						}
+						if *tag.Key == a.OwnerTag && !a.disableOwner {
+							ac.Owner = AccountOwner(*tag.Value)
+						}
+						for _, key := range a.tagKeys {
+							if *tag.Key != key {
+								continue
+							}
+							if ac.Tags == nil {
+								ac.Tags = make(map[string]string)
+							}
+							ac.Tags[key] = *tag.Value
+						}
 					}
+					return true
+				}); err != nil {
+					log.Warnf("error listing tags for project %s: %s", ac.ID, err)
 				}
-				return true
-			}); err != nil {
-				log.Warnf("error listing tags for project %s: %s", ac.ID, err)
 			}
 
-			// find position in the organization
-			if path, err := a.getAccountPath(ctx, svc, ac, accountMap); err != nil {
-				log.Warnf("error building account path for project %s: %s", ac.ID, err)
-			} else {
-				ac.Path = AccountPath(strings.Join(path, "/"))
+			// find position in the organization, unless the path label is
+			// disabled
+			if !a.disablePath {
+				if path, err := a.getAccountPath(ctx, svc, ac, accountMap); err != nil {
+					log.Warnf("error building account path for project %s: %s", ac.ID, err)
+				} else {
+					ac.Path = AccountPath(strings.Join(path, "/"))
+				}
 			}
 			accountMap[ac.ID] = ac
 		}
@@ -330,8 +740,15 @@ func (a *AWSBilling) AccountByID(id AccountID) *Account {
 	defer a.accountNameByIDAPILock.Unlock()
 
 	// update cache of API based mapping
-	if a.accountNameByIDAPI == nil || a.time.Now().Add(-time.Hour).After(a.accountNameByIDAPILastUpdate) {
-		if m, err := a.getAccountNameByIDAPI(ctx); err != nil {
+	if !a.disableEnrichment && (a.accountNameByIDAPI == nil || a.time.Now().Add(-time.Hour).After(a.accountNameByIDAPILastUpdate)) {
+		var m map[AccountID]*Account
+		var err error
+		if a.accountsManifest != "" {
+			m, err = a.readAccountsManifest()
+		} else {
+			m, err = a.getAccountNameByIDAPI(ctx)
+		}
+		if err != nil {
 			log.Warnf("couldn't retrieve list of accounts: %s", err)
 		} else {
 			a.accountNameByIDAPI = m
@@ -354,6 +771,12 @@ func (a *AWSBilling) AccountByID(id AccountID) *Account {
 		account.Name = val
 	}
 
+	if account == nil && a.disableEnrichment {
+		return &Account{
+			ID:   AccountID(id),
+			Name: AccountName(id),
+		}
+	}
 	if account == nil {
 		return &Account{
 			ID:   AccountID(id),
@@ -365,123 +788,452 @@ func (a *AWSBilling) AccountByID(id AccountID) *Account {
 }
 
 func (a *AWSBilling) RootAccountID(ctx context.Context) (string, error) {
+	a.rootAccountIDLock.Lock()
+	defer a.rootAccountIDLock.Unlock()
 	if a.rootAccountID != "" {
 		return a.rootAccountID, nil
 	}
+	if a.localReports() {
+		return "", fmt.Errorf("the root account ID needs to be set for the reports in '%s'", a.BucketName)
+	}
 
-	session, err := a.awsSession()
+	svc, err := a.identityReader()
 	if err != nil {
 		return "", err
 	}
-	svc := sts.New(session, a.awsConfig())
 
 	ci, err := svc.GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
 	if err != nil {
 		return "", err
 	}
-	return *ci.Account, nil
+	a.rootAccountID = *ci.Account
+	return a.rootAccountID, nil
+}
+
+// CheckCredentials verifies the credentials by looking up their identity,
+// local reports need no credentials
+func (a *AWSBilling) CheckCredentials(ctx context.Context) error {
+	if a.localReports() {
+		return nil
+	}
+	svc, err := a.identityReader()
+	if err != nil {
+		return err
+	}
+	_, err = svc.GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
+	return err
 }
 
 func (a *AWSBilling) awsSession() (*session.Session, error) {
-	return session.NewSession()
+	s, err := session.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	if a.apiLatency != nil {
+		// the complete handlers run once the request including its retries
+		// is done
+		s.Handlers.Complete.PushBack(func(r *request.Request) {
+			a.apiLatency.Observe("aws", apiOperation(r), r.Time)
+		})
+	}
+	if a.roleARN != "" {
+		s = s.Copy(&aws.Config{Credentials: a.roleCredentials(s)})
+	}
+	return s, nil
+}
+
+// apiOperation returns the name of the operation of the request like the IAM
+// action, e.g. s3:GetObject
+func apiOperation(r *request.Request) string {
+	service := r.ClientInfo.SigningName
+	if service == "" {
+		service = r.ClientInfo.ServiceName
+	}
+	return service + ":" + r.Operation.Name
 }
 
 func (a *AWSBilling) awsConfig() *aws.Config {
 	return &aws.Config{Region: aws.String(a.Region)}
 }
 
+// stsConfig returns the configuration of the STS client
+func (a *AWSBilling) stsConfig() *aws.Config {
+	c := a.awsConfig()
+	if a.stsRegional {
+		c.STSRegionalEndpoint = endpoints.RegionalSTSEndpoint
+	}
+	if a.stsEndpoint != "" {
+		c.Endpoint = aws.String(a.stsEndpoint)
+	}
+	return c
+}
+
 func (a *AWSBilling) Query() error {
 	ctx := context.Background()
 
-	session, err := a.awsSession()
+	if a.athena != nil {
+		return a.queryAthena(ctx)
+	}
+	if a.reportType == ReportTypeCUR {
+		return a.queryCUR(ctx)
+	}
+
+	svc, err := a.reportBucket()
 	if err != nil {
 		return err
 	}
-	svc := s3.New(session, a.awsConfig())
 
 	rootAccountID, err := a.RootAccountID(ctx)
 	if err != nil {
 		return fmt.Errorf("Error detecting root account ID: %s", err)
 	}
 
-	prefix := fmt.Sprintf("%s-aws-billing-csv-", rootAccountID)
+	prefix, keys := a.reportKeys.compile(rootAccountID)
 	params := &s3.ListObjectsInput{
-		Bucket: aws.String(a.BucketName),
-		Prefix: aws.String(prefix),
+		Bucket:       aws.String(a.BucketName),
+		Prefix:       aws.String(prefix),
+		RequestPayer: a.requestPayer(),
 	}
 
+	// the report of the latest month is used, of multiple reports of a
+	// month the last key
 	var billingObject *s3.Object
+	var month string
+	reports := 0
 	if err := svc.ListObjectsPagesWithContext(ctx, params, func(resp *s3.ListObjectsOutput, _ bool) bool {
 		for _, object := range resp.Contents {
 			key := *object.Key
-			log.Debugf("found report '%s' for '%s'", key, key[len(prefix):len(key)-4])
-			if billingObject == nil || strings.Compare(key, *billingObject.Key) > 0 {
+			objectMonth := reportMonth(key, keys)
+			if objectMonth == "" {
+				continue
+			}
+			log.Debugf("found report '%s' for '%s'", key, objectMonth)
+			reports++
+			if billingObject == nil || objectMonth > month || (objectMonth == month && key > *billingObject.Key) {
 				billingObject = object
+				month = objectMonth
 			}
 		}
 		return true
 	}); err != nil {
 		return fmt.Errorf("Error listing AWS bucket: %s", err)
 	}
+	source := fmt.Sprintf("s3://%s/%s", a.BucketName, prefix)
+	if a.localReports() {
+		source = strings.TrimSuffix(a.BucketName, "/") + "/" + prefix
+	}
+	a.setDiagnostics(source, reports, billingObject)
 
 	if billingObject == nil {
-		return fmt.Errorf("No billing report for account '%s' found in bucket '%s'", rootAccountID, a.BucketName)
+		return fmt.Errorf("No billing report for account '%s' matching '%s' found in bucket '%s'", rootAccountID, a.reportKeys.pattern, a.BucketName)
 	}
 
 	key := *billingObject.Key
-	log.Debugf("use report '%s' for '%s' hash (%s)", key, key[len(prefix):len(key)-4], *billingObject.ETag)
+	log.Debugf("use report '%s' for '%s' hash (%s)", key, month, *billingObject.ETag)
 
 	// lock from here on
 	a.ReportsLock.Lock()
 	defer a.ReportsLock.Unlock()
 
+	if err := a.restoreState(ctx); err != nil {
+		return err
+	}
+
 	if a.ReportHash == *billingObject.ETag {
 		log.Debugf("report '%s' has already been parsed", key)
 		return nil
 	}
 	// TODO: check hash
 
-	billingObjectContent, err := svc.GetObject(&s3.GetObjectInput{
-		Bucket: aws.String(a.BucketName),
-		Key:    billingObject.Key,
-	})
-	if err != nil {
-		return fmt.Errorf("Error download billing report '%s': %s", *billingObject.Key, err)
+	var billingElements []*awsBillingElement
+	var usage []billing.Usage
+	if err := a.pipeline.Run("aws", []parse.Job{a.parseReport(ctx, svc, billingObject, func(report io.Reader, stats *parse.Stats) (err error) {
+		billingElements, usage, err = readCSV(report, a.recordTypes, a.pipeline, stats)
+		return err
+	})})[0]; err != nil {
+		return err
 	}
 
-	billingElements, err := readCSV(billingObjectContent.Body)
-	if err != nil {
-		return fmt.Errorf("Error parsing CSV billing report '%s': %s", *billingObject.Key, err)
-	}
-	err = billingObjectContent.Body.Close()
-	if err != nil {
-		return err
+	for i := range usage {
+		usage[i].Month = month
 	}
+	a.setUsage(usage)
+	a.updateCosts(ctx, month, billingElements, *billingObject.ETag)
+	return nil
+}
+
+// parseReport returns the job downloading the report object, which is
+// decompressed and parsed by read. Truncated downloads fail the checksum and
+// are retried.
+func (a *AWSBilling) parseReport(ctx context.Context, svc ReportBucket, object *s3.Object, read func(io.Reader, *parse.Stats) error) parse.Job {
+	return parse.RetryChecksum(parse.ChecksumAttempts, func() (int64, error) {
+		content, err := a.openReport(ctx, svc, object)
+		if err != nil {
+			return 0, fmt.Errorf("Error download billing report '%s': %s", *object.Key, err)
+		}
+		defer content.Close()
+
+		counter := &parse.CountingReader{Reader: content}
+		var r io.Reader = counter
+		var checksum *parse.ChecksumReader
+		if sum := etagMD5(object); sum != nil {
+			checksum = parse.NewChecksumReader(counter, md5.New(), sum)
+			r = checksum
+		}
+
+		stats := &parse.Stats{}
+		report, err := decompress(r)
+		if err == nil {
+			defer report.Close()
+			err = read(report, stats)
+		}
+		// truncated downloads fail the checksum and are retried, even if
+		// they could be parsed
+		if checksum != nil {
+			if verifyErr := checksum.Verify(); verifyErr != nil {
+				return counter.N, fmt.Errorf("Error verifying billing report '%s': %w", *object.Key, verifyErr)
+			}
+		}
+		if err != nil {
+			return counter.N, fmt.Errorf("Error parsing billing report '%s': %s", *object.Key, err)
+		}
+		a.pipeline.Observe("aws", stats)
+		return counter.N, nil
+	})
+}
 
+// updateCosts applies the parsed costs of a billing month to the metric and
+// records, it needs to be called with ReportsLock held
+func (a *AWSBilling) updateCosts(ctx context.Context, month string, billingElements []*awsBillingElement, hash string) {
+	records := make([]billing.Record, 0, len(billingElements))
+	lineItems := make([]billing.LineItem, 0, len(billingElements))
+	var adjustments []billing.Adjustment
 	for _, elem := range billingElements {
 		projectID := elem.ProjectID
-		project := a.AccountByID(AccountID(projectID))
+		var project *Account
+		if a.curTagColumn != "" {
+			// the costs are aggregated by the tag values, which aren't
+			// accounts
+			project = &Account{ID: AccountID(projectID), Name: AccountName(projectID)}
+		} else {
+			project = a.AccountByID(AccountID(projectID))
+		}
 		elem.ProjectName = projectID
-
-		m := a.MetricMonthlyCosts.WithLabelValues(
-			"aws",
-			elem.Currency,
-			string(project.Name),
-			elem.ServiceName,
-			string(project.Path),
-			string(project.Owner),
-		)
 		key := groupByProjectIDServiceCurrency(elem)
-		if _, ok := a.metricValues[key]; !ok {
-			a.metricValues[groupByProjectIDServiceCurrency(elem)] = 0
+
+		accountType, ok := a.accountType(project)
+		if !ok {
+			log.With("account_id", projectID).Debugf("skipping costs of %s account", strings.ToLower(project.Status))
+			a.dropSeries(key)
+			continue
+		}
+
+		record := billing.Record{
+			Cloud:    "aws",
+			Month:    month,
+			Currency: elem.Currency,
+			Account:  string(project.Name),
+			Service:  elem.ServiceName,
+			Path:     string(project.Path),
+			Owner:    string(project.Owner),
+			Type:     accountType,
+			Costs:    elem.Costs,
+		}
+		records = append(records, record)
+		lineItems = append(lineItems, billing.LineItem{
+			Cloud:    record.Cloud,
+			Month:    record.Month,
+			Currency: record.Currency,
+			Account:  record.Account,
+			Service:  record.Service,
+			Costs:    record.Costs,
+		})
+		for _, adjustment := range []struct {
+			recordType string
+			amount     float64
+		}{
+			{billing.RecordTypeTax, elem.Taxes},
+			{billing.RecordTypeCredit, elem.Credits},
+			{billing.RecordTypeRefund, elem.RefundItems},
+		} {
+			if adjustment.amount == 0 {
+				continue
+			}
+			adjustments = append(adjustments, billing.Adjustment{
+				Cloud:      record.Cloud,
+				Month:      record.Month,
+				Currency:   record.Currency,
+				Account:    record.Account,
+				Service:    record.Service,
+				Path:       record.Path,
+				Owner:      record.Owner,
+				Type:       record.Type,
+				RecordType: adjustment.recordType,
+				Amount:     adjustment.amount,
+			})
+		}
+
+		labels := record.Labels()
+		// the series starts over once the account is suspended or active
+		// again
+		if baseline, ok := a.metricValues[key]; ok && len(baseline.Labels) == len(labels) && baseline.Labels[len(labels)-1] != accountType {
+			a.dropSeries(key)
+		}
+		if a.onDecrease != billing.OnDecreaseRefundMetric || a.metricRefunds == nil {
+			a.advance(a.onDecrease, month, key, labels, elem.Costs, a.MetricMonthlyCosts, nil)
+		} else {
+			// the costs counter follows the costs without refunds, the
+			// refunds counter the refunds, withdrawn refunds are added to
+			// the costs
+			a.advance(a.onDecrease, month, key, labels, elem.Costs+elem.Refunds, a.MetricMonthlyCosts, a.metricRefunds)
+			if _, ok := a.metricValues[refundsKey(key)]; ok || elem.Refunds != 0 {
+				a.advance(a.onDecrease, month, refundsKey(key), labels, elem.Refunds, a.metricRefunds, a.MetricMonthlyCosts)
+			}
 		}
-		value := elem.Costs
-		m.Add(value - a.metricValues[key])
-		a.metricValues[key] = value
 		log.Debugf("%+#v", elem)
 	}
-	a.ReportHash = *billingObject.ETag
-	return nil
+	a.setRecords(records, lineItems)
+	a.setAdjustments(adjustments)
+	a.ReportHash = hash
+	a.saveState(ctx)
+}
+
+// dropSeries deletes the series of the key and their baselines
+func (a *AWSBilling) dropSeries(key string) {
+	for _, k := range []string{key, refundsKey(key)} {
+		baseline, ok := a.metricValues[k]
+		if !ok {
+			continue
+		}
+		a.MetricMonthlyCosts.DeleteLabelValues(baseline.Labels...)
+		if a.metricRefunds != nil {
+			a.metricRefunds.DeleteLabelValues(baseline.Labels...)
+		}
+		delete(a.metricValues, k)
+	}
+}
+
+// refundsKey is the key of the baseline of the refunds of a series
+func refundsKey(key string) string {
+	return "refunds/" + key
+}
+
+// advance adds the delta of the value of the month to its baseline to the
+// counter and moves the baseline, falling values are handled according to
+// the mode
+func (a *AWSBilling) advance(mode billing.OnDecrease, month, key string, labels []string, value float64, counter, refunds *prometheus.CounterVec) {
+	previous := a.metricValues[key].Previous(month)
+	if !mode.AddCosts(counter, refunds, labels, previous, value) {
+		log.With("key", key).Warnf("costs are falling by: '%f'", value-previous)
+		return
+	}
+	a.metricValues[key] = state.Baseline{Labels: labels, Value: value, Month: month}
+}
+
+// setDiagnostics keeps the reports found by the last query
+func (a *AWSBilling) setDiagnostics(source string, reports int, newest *s3.Object) {
+	a.recordsLock.Lock()
+	defer a.recordsLock.Unlock()
+	a.diagnostics = billing.Diagnostics{Source: source, Reports: reports}
+	if newest != nil {
+		a.diagnostics.NewestReport = aws.StringValue(newest.Key)
+		a.diagnostics.NewestReportModified = aws.TimeValue(newest.LastModified)
+	}
+}
+
+// LastReport returns when the newest report found by the last query was
+// modified
+func (a *AWSBilling) LastReport() time.Time {
+	a.recordsLock.Lock()
+	defer a.recordsLock.Unlock()
+	return a.diagnostics.NewestReportModified
+}
+
+// Diagnostics returns the identity of the credentials and the reports found
+// by the last query
+func (a *AWSBilling) Diagnostics(ctx context.Context) (billing.Diagnostics, error) {
+	a.recordsLock.Lock()
+	d := a.diagnostics
+	a.recordsLock.Unlock()
+	if a.athena != nil {
+		d.Source = fmt.Sprintf("athena table %s.%s", a.athena.database, a.athena.table)
+	}
+	if a.localReports() {
+		return d, nil
+	}
+
+	svc, err := a.identityReader()
+	if err != nil {
+		return d, err
+	}
+	identity, err := svc.GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return d, err
+	}
+	d.Identity = aws.StringValue(identity.Arn)
+	return d, nil
+}
+
+func (a *AWSBilling) setRecords(records []billing.Record, lineItems []billing.LineItem) {
+	a.recordsLock.Lock()
+	defer a.recordsLock.Unlock()
+	a.records = records
+	a.lineItems = lineItems
+}
+
+func (a *AWSBilling) setHourlyCosts(costs []billing.HourlyCost) {
+	a.recordsLock.Lock()
+	defer a.recordsLock.Unlock()
+	a.hourlyCosts = costs
+}
+
+// HourlyCosts returns the costs per hour of the last hours
+func (a *AWSBilling) HourlyCosts() []billing.HourlyCost {
+	a.recordsLock.Lock()
+	defer a.recordsLock.Unlock()
+	return a.hourlyCosts
+}
+
+// Records returns the costs of the latest parsed report
+func (a *AWSBilling) Records() []billing.Record {
+	a.recordsLock.Lock()
+	defer a.recordsLock.Unlock()
+	return append([]billing.Record(nil), a.records...)
+}
+
+func (a *AWSBilling) setUsage(usage []billing.Usage) {
+	a.recordsLock.Lock()
+	defer a.recordsLock.Unlock()
+	a.usage = usage
+}
+
+// Usage returns the usage per usage type of the latest parsed report, it is
+// not available when querying Athena
+func (a *AWSBilling) Usage() []billing.Usage {
+	a.recordsLock.Lock()
+	defer a.recordsLock.Unlock()
+	return append([]billing.Usage(nil), a.usage...)
+}
+
+func (a *AWSBilling) setAdjustments(adjustments []billing.Adjustment) {
+	a.recordsLock.Lock()
+	defer a.recordsLock.Unlock()
+	a.adjustments = adjustments
+}
+
+// Adjustments returns the taxes, credits and refunds of the latest parsed
+// report, they are not available when querying Athena
+func (a *AWSBilling) Adjustments() []billing.Adjustment {
+	a.recordsLock.Lock()
+	defer a.recordsLock.Unlock()
+	return append([]billing.Adjustment(nil), a.adjustments...)
+}
+
+// LineItems returns the line items of the parsed reports
+func (a *AWSBilling) LineItems() []billing.LineItem {
+	a.recordsLock.Lock()
+	defer a.recordsLock.Unlock()
+	return append([]billing.LineItem(nil), a.lineItems...)
 }
 
 func (a *AWSBilling) Test() error {
@@ -494,5 +1246,13 @@ func (a *AWSBilling) String() string {
 		rootAccountID = "unknown"
 	}
 
+	if a.athena != nil {
+		return fmt.Sprintf("AWS Billing on root account '%s' in athena table '%s.%s'", rootAccountID, a.athena.database, a.athena.table)
+	}
 	return fmt.Sprintf("AWS Billing on root account '%s' in bucket '%s'", rootAccountID, a.BucketName)
 }
+
+// Cloud returns the name of the cloud, as used in the cloud label
+func (a *AWSBilling) Cloud() string {
+	return "aws"
+}
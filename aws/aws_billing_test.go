@@ -1,8 +1,17 @@
 package aws
 
 import (
+	"context"
+	"math"
+	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+	"github.com/simonswine/cloud-billing-exporter/parse"
 )
 
 func TestReadCSVLinkedAccount(t *testing.T) {
@@ -290,7 +299,7 @@ func TestReadCSVLinkedAccount(t *testing.T) {
 "","12340002","12340003","AccountTotal","AccountTotal:12340003","2017/04/01 00:00:00","2017/04/30 23:59:59","","Jane Marry","John Doe","","","","","","","","","Total for linked account# 12340003 (John Doe)","","","","","USD","3.070082","0.0","0.620000","","3.690082"
 "","12340002","","StatementTotal","StatementTotal","2017/04/01 00:00:00","2017/04/30 23:59:59","","Jane Marry","","","","","","","","","","Total statement amount for period 2017/04/01 00:00:00 - 2017/04/30 23:59:59","","","","","USD","267.42","0.0","53.450000","","320.87"`)
 
-	elems, err := readCSV(csvReader)
+	elems, usage, err := readCSV(csvReader, nil, nil, nil)
 
 	if err != nil {
 		t.Errorf("Unexpected error: %s", err)
@@ -309,4 +318,187 @@ func TestReadCSVLinkedAccount(t *testing.T) {
 		t.Errorf("Unexpected sum of costs: %d(expected: %d)", act, exp)
 	}
 
+	if exp, act := 112, len(usage); exp != act {
+		t.Errorf("Unexpected count of usage types returned: %d (expected: %d)", act, exp)
+	}
+	for _, u := range usage {
+		if u.SKU != "EU-NatGateway-Hours" {
+			continue
+		}
+		if u.Service != "AmazonEC2" || u.Quantity != 2103 || math.Abs(u.Costs-121.134) > 1e-9 {
+			t.Errorf("Unexpected usage: %+v", u)
+		}
+	}
+
+}
+
+func TestReadCSVStats(t *testing.T) {
+	report := fakeReport + `"1","12340002","12340004","LinkedLineItem","AmazonS3","EU-Requests-Tier2","1014","USD","n/a"
+`
+	p := parse.New("cloud", 1, 0)
+	defer p.Close()
+	stats := &parse.Stats{}
+	if _, _, err := readCSV(strings.NewReader(report), nil, p, stats); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	p.Observe("aws", stats)
+
+	if err := testutil.CollectAndCompare(p, strings.NewReader(`
+# HELP cloud_parse_accounts Number of distinct accounts in the last parsed report object.
+# TYPE cloud_parse_accounts gauge
+cloud_parse_accounts{backend="aws"} 2
+# HELP cloud_parse_rows_aggregated_total Number of rows of report objects aggregated into the costs.
+# TYPE cloud_parse_rows_aggregated_total counter
+cloud_parse_rows_aggregated_total{backend="aws"} 3
+# HELP cloud_parse_rows_read_total Number of rows read from report objects.
+# TYPE cloud_parse_rows_read_total counter
+cloud_parse_rows_read_total{backend="aws"} 5
+# HELP cloud_parse_rows_skipped_total Number of rows of report objects skipped, by the record type filter or as their cost couldn't be parsed.
+# TYPE cloud_parse_rows_skipped_total counter
+cloud_parse_rows_skipped_total{backend="aws",reason="invalid_cost"} 1
+cloud_parse_rows_skipped_total{backend="aws",reason="record_type"} 1
+`), "cloud_parse_accounts", "cloud_parse_rows_aggregated_total", "cloud_parse_rows_read_total", "cloud_parse_rows_skipped_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUpdateCostsRefunds(t *testing.T) {
+	costs := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+	refunds := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "refunds"}, billing.MonthlyCostsLabels)
+	a := NewAWSBilling(costs, "billing", "eu-west-1", "", "", "owner", "project").WithEnrichment(false).WithOnDecrease(billing.OnDecreaseRefundMetric, refunds)
+	labels := []string{"aws", "USD", "12340001", "AmazonEC2", "", "", "", ""}
+
+	for _, c := range []struct {
+		costs, refunds       float64
+		expCosts, expRefunds float64
+	}{
+		{costs: 8, refunds: 2, expCosts: 10, expRefunds: 2},
+		// the costs without refunds are corrected downwards
+		{costs: 7, refunds: 2, expCosts: 10, expRefunds: 3},
+		// a refund is withdrawn
+		{costs: 9, refunds: 1, expCosts: 12, expRefunds: 3},
+	} {
+		a.updateCosts(context.Background(), "2019-11", []*awsBillingElement{
+			{ProjectID: "12340001", ServiceName: "AmazonEC2", Currency: "USD", Costs: c.costs, Refunds: c.refunds},
+		}, "")
+		if act := testutil.ToFloat64(costs.WithLabelValues(labels...)); act != c.expCosts {
+			t.Errorf("unexpected costs: %f (expected: %f)", act, c.expCosts)
+		}
+		if act := testutil.ToFloat64(refunds.WithLabelValues(labels...)); act != c.expRefunds {
+			t.Errorf("unexpected refunds: %f (expected: %f)", act, c.expRefunds)
+		}
+	}
+}
+
+func TestReadCSVRefunds(t *testing.T) {
+	report := fakeReport + `"1","12340002","12340003","LinkedLineItem","AmazonS3","EU-Requests-Tier2","1","USD","-0.5"
+`
+	elems, _, err := readCSV(strings.NewReader(report), nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// the refunds are included in the costs
+	for _, e := range elems {
+		if e.ProjectID != "12340003" {
+			continue
+		}
+		if math.Abs(e.Costs+0.49) > 1e-9 || e.Refunds != 0.5 {
+			t.Errorf("unexpected costs and refunds: %f, %f (expected: -0.49, 0.5)", e.Costs, e.Refunds)
+		}
+	}
+}
+
+func TestReadCSVAdjustments(t *testing.T) {
+	report := `"InvoiceID","PayerAccountId","LinkedAccountId","RecordType","ProductCode","UsageType","UsageQuantity","CurrencyCode","CostBeforeTax","Credits","TaxAmount","TaxType","TotalCost"
+"1","12340002","12340001","LinkedLineItem","AmazonEC2","EU-BoxUsage:m3.medium","100","USD","10.0","-2.0","1.6","VAT","9.6"
+"1","12340002","12340001","LinkedLineItem","AmazonEC2","","","USD","-3.0","0.0","0.0","None","-3.0"
+`
+	elems, _, err := readCSV(strings.NewReader(report), nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(elems) != 1 {
+		t.Fatalf("unexpected costs: %+v", elems)
+	}
+	// taxes, credits and refunds are included in the costs
+	e := elems[0]
+	if math.Abs(e.Costs-6.6) > 1e-9 || e.Taxes != 1.6 || e.Credits != 2 || e.RefundItems != 3 {
+		t.Errorf("unexpected costs, taxes, credits and refunds: %f, %f, %f, %f (expected: 6.6, 1.6, 2, 3)", e.Costs, e.Taxes, e.Credits, e.RefundItems)
+	}
+
+	a := NewAWSBilling(prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels), "billing", "eu-west-1", "", "", "owner", "project").WithEnrichment(false)
+	a.updateCosts(context.Background(), "2019-11", elems, "")
+	amounts := make(map[string]float64)
+	for _, adjustment := range a.Adjustments() {
+		if adjustment.Account != "12340001" || adjustment.Service != "AmazonEC2" || adjustment.Month != "2019-11" {
+			t.Errorf("unexpected adjustment: %+v", adjustment)
+		}
+		amounts[adjustment.RecordType] = adjustment.Amount
+	}
+	if exp := map[string]float64{billing.RecordTypeTax: 1.6, billing.RecordTypeCredit: 2, billing.RecordTypeRefund: 3}; !reflect.DeepEqual(amounts, exp) {
+		t.Errorf("unexpected adjustments: %+v (expected: %+v)", amounts, exp)
+	}
+}
+
+func TestReadCSVRecordTypes(t *testing.T) {
+	// a standalone account without consolidated billing
+	report := `"InvoiceID","PayerAccountId","LinkedAccountId","RecordType","ProductCode","UsageType","UsageQuantity","CurrencyCode","TotalCost"
+"1","12340002","","PayerLineItem","AmazonEC2","EU-BoxUsage:m3.medium","100","USD","8.76"
+"1","12340002","","PayerLineItem","AmazonS3","EU-Requests-Tier2","1014","USD","0.01"
+"","12340002","","InvoiceTotal","","","","USD","8.77"
+"","12340002","","StatementTotal","","","","USD","8.77"
+`
+	elems, _, err := readCSV(strings.NewReader(report), nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(elems) != 0 {
+		t.Errorf("unexpected costs of payer line items by default: %+v", elems)
+	}
+
+	elems, _, err = readCSV(strings.NewReader(report), recordTypeSet([]string{"PayerLineItem", "LineItem"}), nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	total := 0.0
+	for _, e := range elems {
+		if e.ProjectID != "12340002" {
+			t.Errorf("unexpected account of payer line item: %+v", e)
+		}
+		total += e.Costs
+	}
+	if len(elems) != 2 || math.Abs(total-8.77) > 1e-9 {
+		t.Errorf("unexpected costs: %+v", elems)
+	}
+}
+
+func TestUpdateCostsMonthRollover(t *testing.T) {
+	labels := []string{"aws", "USD", "12340001", "AmazonEC2", "", "", "", ""}
+	for _, mode := range []billing.OnDecrease{billing.OnDecreaseRefundMetric, billing.OnDecreaseSkip, billing.OnDecreaseReset} {
+		costs := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+		refunds := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "refunds"}, billing.MonthlyCostsLabels)
+		a := NewAWSBilling(costs, "billing", "eu-west-1", "", "", "owner", "project").WithEnrichment(false).WithOnDecrease(mode, refunds)
+
+		// the costs of the new month continue the counter, the rollover is
+		// no decrease
+		for _, c := range []struct {
+			month    string
+			costs    float64
+			expCosts float64
+		}{
+			{month: "2019-11", costs: 1000, expCosts: 1000},
+			{month: "2019-12", costs: 5, expCosts: 1005},
+			{month: "2019-12", costs: 7, expCosts: 1007},
+		} {
+			a.updateCosts(context.Background(), c.month, []*awsBillingElement{
+				{ProjectID: "12340001", ServiceName: "AmazonEC2", Currency: "USD", Costs: c.costs},
+			}, "")
+			if act := testutil.ToFloat64(costs.WithLabelValues(labels...)); act != c.expCosts {
+				t.Errorf("%s: unexpected costs in %s: %f (expected: %f)", mode, c.month, act, c.expCosts)
+			}
+			if act := testutil.ToFloat64(refunds.WithLabelValues(labels...)); act != 0 {
+				t.Errorf("%s: unexpected refunds in %s: %f", mode, c.month, act)
+			}
+		}
+	}
 }
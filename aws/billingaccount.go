@@ -0,0 +1,32 @@
+package aws
+
+import (
+	"context"
+
+	"github.com/prometheus/common/log"
+)
+
+// WithBillingAccount sets the name of the billing account the costs are
+// billed to, e.g. to tell apart several payer accounts. The root account ID
+// is used if empty.
+func (a *AWSBilling) WithBillingAccount(name string) *AWSBilling {
+	a.billingAccount = name
+	return a
+}
+
+// BillingAccount returns the name of the billing account or the root
+// account ID, which is looked up once
+func (a *AWSBilling) BillingAccount() string {
+	a.billingAccountLock.Lock()
+	defer a.billingAccountLock.Unlock()
+	if a.billingAccount != "" {
+		return a.billingAccount
+	}
+	id, err := a.RootAccountID(context.Background())
+	if err != nil {
+		log.Warnf("error detecting root account ID of the billing account: %s", err)
+		return ""
+	}
+	a.billingAccount = id
+	return id
+}
@@ -0,0 +1,15 @@
+package aws
+
+import (
+	"testing"
+)
+
+func TestBillingAccount(t *testing.T) {
+	a := NewAWSBilling(nil, "billing", "eu-west-1", "12340002", "", "owner", "project")
+	if act := a.BillingAccount(); act != "12340002" {
+		t.Errorf("unexpected billing account: %s (expected the root account ID)", act)
+	}
+	if act := a.WithBillingAccount("acme").BillingAccount(); act != "acme" {
+		t.Errorf("unexpected billing account: %s", act)
+	}
+}
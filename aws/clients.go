@@ -0,0 +1,106 @@
+package aws
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// ReportBucket lists and downloads the billing reports, it is implemented by
+// the S3 client
+type ReportBucket interface {
+	ListObjectsPagesWithContext(aws.Context, *s3.ListObjectsInput, func(*s3.ListObjectsOutput, bool) bool, ...request.Option) error
+	GetObjectWithContext(aws.Context, *s3.GetObjectInput, ...request.Option) (*s3.GetObjectOutput, error)
+}
+
+// OrganizationsReader looks up the accounts of the organization and their
+// position in it, it is implemented by the organizations client
+type OrganizationsReader interface {
+	ListAccountsPagesWithContext(aws.Context, *organizations.ListAccountsInput, func(*organizations.ListAccountsOutput, bool) bool, ...request.Option) error
+	ListTagsForResourcePagesWithContext(aws.Context, *organizations.ListTagsForResourceInput, func(*organizations.ListTagsForResourceOutput, bool) bool, ...request.Option) error
+	ListParentsWithContext(aws.Context, *organizations.ListParentsInput, ...request.Option) (*organizations.ListParentsOutput, error)
+	DescribeOrganizationWithContext(aws.Context, *organizations.DescribeOrganizationInput, ...request.Option) (*organizations.DescribeOrganizationOutput, error)
+	DescribeOrganizationalUnitWithContext(aws.Context, *organizations.DescribeOrganizationalUnitInput, ...request.Option) (*organizations.DescribeOrganizationalUnitOutput, error)
+}
+
+// IdentityReader looks up the account of the credentials, it is implemented
+// by the STS client
+type IdentityReader interface {
+	GetCallerIdentityWithContext(aws.Context, *sts.GetCallerIdentityInput, ...request.Option) (*sts.GetCallerIdentityOutput, error)
+}
+
+// QueryRunner runs queries and reads their results, it is implemented by the
+// Athena client
+type QueryRunner interface {
+	StartQueryExecutionWithContext(aws.Context, *athena.StartQueryExecutionInput, ...request.Option) (*athena.StartQueryExecutionOutput, error)
+	GetQueryExecutionWithContext(aws.Context, *athena.GetQueryExecutionInput, ...request.Option) (*athena.GetQueryExecutionOutput, error)
+	GetQueryResultsPagesWithContext(aws.Context, *athena.GetQueryResultsInput, func(*athena.GetQueryResultsOutput, bool) bool, ...request.Option) error
+}
+
+// Clients are the AWS APIs used by AWSBilling, clients not set are created
+// from the default session
+type Clients struct {
+	Reports       ReportBucket
+	Organizations OrganizationsReader
+	Identity      IdentityReader
+	Athena        QueryRunner
+}
+
+// WithClients replaces the AWS API clients, e.g. with fakes in tests
+func (a *AWSBilling) WithClients(c Clients) *AWSBilling {
+	a.clients = c
+	if a.athena != nil && c.Athena != nil {
+		a.athena.svc = c.Athena
+	}
+	return a
+}
+
+func (a *AWSBilling) reportBucket() (ReportBucket, error) {
+	if a.local != nil {
+		return a.local, nil
+	}
+	if a.clients.Reports != nil {
+		return a.clients.Reports, nil
+	}
+	session, err := a.awsSession()
+	if err != nil {
+		return nil, err
+	}
+	return s3.New(session, a.awsConfig()), nil
+}
+
+func (a *AWSBilling) organizationsReader() (OrganizationsReader, error) {
+	if a.clients.Organizations != nil {
+		return a.clients.Organizations, nil
+	}
+	session, err := a.awsSession()
+	if err != nil {
+		return nil, err
+	}
+	return organizations.New(session, a.awsConfig()), nil
+}
+
+func (a *AWSBilling) identityReader() (IdentityReader, error) {
+	if a.clients.Identity != nil {
+		return a.clients.Identity, nil
+	}
+	session, err := a.awsSession()
+	if err != nil {
+		return nil, err
+	}
+	return sts.New(session, a.stsConfig()), nil
+}
+
+func (a *AWSBilling) queryRunner() (QueryRunner, error) {
+	if a.clients.Athena != nil {
+		return a.clients.Athena, nil
+	}
+	session, err := a.awsSession()
+	if err != nil {
+		return nil, err
+	}
+	return athena.New(session, a.awsConfig()), nil
+}
@@ -0,0 +1,243 @@
+package aws
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+	"github.com/simonswine/cloud-billing-exporter/fake"
+)
+
+var (
+	_ ReportBucket        = &fake.S3{}
+	_ OrganizationsReader = &fake.Organizations{}
+	_ IdentityReader      = &fake.STS{}
+	_ QueryRunner         = &fake.Athena{}
+)
+
+const fakeReport = `"InvoiceID","PayerAccountId","LinkedAccountId","RecordType","ProductCode","UsageType","UsageQuantity","CurrencyCode","TotalCost"
+"1","12340002","12340001","LinkedLineItem","AmazonEC2","EU-BoxUsage:m3.medium","100","USD","8.76"
+"1","12340002","12340001","LinkedLineItem","AmazonEC2","EU-BoxUsage:m3.medium","10","USD","0.876"
+"1","12340002","12340003","LinkedLineItem","AmazonS3","EU-Requests-Tier2","1014","USD","0.01"
+"1","12340002","","PayerLineItem","AmazonS3","EU-Requests-Tier2","1014","USD","0.01"
+`
+
+func TestQueryWithFakeClients(t *testing.T) {
+	reports := &fake.S3{Objects: map[string]string{
+		"12340002-aws-billing-csv-2017-03.csv": fakeReport,
+		"12340002-aws-billing-csv-2017-04.csv": fakeReport,
+	}}
+	metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+	a := NewAWSBilling(metric, "billing", "eu-west-1", "", "12340003=shared", "owner", "project").WithClients(Clients{
+		Reports:  reports,
+		Identity: &fake.STS{Account: "12340002"},
+		Organizations: &fake.Organizations{
+			MasterAccountEmail:  "aws@example.com",
+			Accounts:            []*organizations.Account{{Id: aws.String("12340001"), Name: aws.String("acme-dev")}},
+			Tags:                map[string]map[string]string{"12340001": {"owner": "jane"}},
+			Parents:             map[string]string{"12340001": "ou-1234-engineering", "ou-1234-engineering": "r-1234"},
+			OrganizationalUnits: map[string]string{"ou-1234-engineering": "engineering"},
+		},
+	})
+
+	if err := a.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if act, exp := testutil.ToFloat64(metric.WithLabelValues("aws", "USD", "acme-dev", "AmazonEC2", "example.com/engineering", "jane", "", "")), 9.636; math.Abs(act-exp) > 1e-9 {
+		t.Errorf("unexpected costs of acme-dev: %f (expected: %f)", act, exp)
+	}
+	if act, exp := testutil.ToFloat64(metric.WithLabelValues("aws", "USD", "shared", "AmazonS3", "", "", "", "")), 0.01; act != exp {
+		t.Errorf("unexpected costs of shared: %f (expected: %f)", act, exp)
+	}
+	for _, r := range a.Records() {
+		if r.Month != "2017-04" {
+			t.Errorf("unexpected month of the latest report: %+v", r)
+		}
+	}
+
+	// an updated report only adds the difference
+	reports.Objects["12340002-aws-billing-csv-2017-04.csv"] = strings.Replace(fakeReport, `"0.01"`, `"0.25"`, 1)
+	if err := a.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if act, exp := testutil.ToFloat64(metric.WithLabelValues("aws", "USD", "shared", "AmazonS3", "", "", "", "")), 0.25; act != exp {
+		t.Errorf("unexpected costs of shared: %f (expected: %f)", act, exp)
+	}
+}
+
+func TestQueryRequesterPays(t *testing.T) {
+	reports := &fake.S3{
+		Objects:       map[string]string{"12340002-aws-billing-csv-2017-04.csv": fakeReport},
+		RequesterPays: true,
+	}
+	metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+	a := NewAWSBilling(metric, "billing", "eu-west-1", "12340002", "", "owner", "project").WithClients(Clients{
+		Reports:       reports,
+		Organizations: &fake.Organizations{},
+	})
+
+	if err := a.Query(); err == nil {
+		t.Fatal("expected access to be denied without request payer")
+	}
+
+	if err := a.WithRequesterPays(true).Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if act := len(a.Records()); act == 0 {
+		t.Error("expected records from the Requester Pays bucket")
+	}
+}
+
+func TestQueryWithoutEnrichment(t *testing.T) {
+	metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+	a := NewAWSBilling(metric, "billing", "eu-west-1", "12340002", "12340003=shared", "owner", "project").WithClients(Clients{
+		Reports: &fake.S3{Objects: map[string]string{"12340002-aws-billing-csv-2017-04.csv": fakeReport}},
+		Organizations: &fake.Organizations{
+			Accounts: []*organizations.Account{{Id: aws.String("12340001"), Name: aws.String("acme-dev")}},
+		},
+	}).WithEnrichment(false)
+
+	if err := a.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// accounts are labeled by their ID, unless overridden
+	if act, exp := testutil.ToFloat64(metric.WithLabelValues("aws", "USD", "12340001", "AmazonEC2", "", "", "", "")), 9.636; math.Abs(act-exp) > 1e-9 {
+		t.Errorf("unexpected costs of 12340001: %f (expected: %f)", act, exp)
+	}
+	if act, exp := testutil.ToFloat64(metric.WithLabelValues("aws", "USD", "shared", "AmazonS3", "", "", "", "")), 0.01; act != exp {
+		t.Errorf("unexpected costs of shared: %f (expected: %f)", act, exp)
+	}
+}
+
+func TestSTSEndpoint(t *testing.T) {
+	metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+	a := NewAWSBilling(metric, "billing", "eu-west-1", "", "", "owner", "project")
+	sess := session.Must(session.NewSession())
+
+	for _, c := range []struct {
+		regional bool
+		endpoint string
+		exp      string
+	}{
+		{exp: "https://sts.amazonaws.com"},
+		{regional: true, exp: "https://sts.eu-west-1.amazonaws.com"},
+		{endpoint: "https://vpce-0123.sts.eu-west-1.vpce.amazonaws.com", exp: "https://vpce-0123.sts.eu-west-1.vpce.amazonaws.com"},
+	} {
+		svc := sts.New(sess, a.WithSTSEndpoint(c.regional, c.endpoint).stsConfig())
+		if act := svc.Endpoint; act != c.exp {
+			t.Errorf("unexpected endpoint: %s (expected: %s)", act, c.exp)
+		}
+	}
+}
+
+func TestQueryWithoutOwnerAndPath(t *testing.T) {
+	metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+	a := NewAWSBilling(metric, "billing", "eu-west-1", "12340002", "", "owner", "project").WithClients(Clients{
+		Reports: &fake.S3{Objects: map[string]string{"12340002-aws-billing-csv-2017-04.csv": fakeReport}},
+		Organizations: &fake.Organizations{
+			MasterAccountEmail:  "aws@example.com",
+			Accounts:            []*organizations.Account{{Id: aws.String("12340001"), Name: aws.String("acme-dev")}},
+			Tags:                map[string]map[string]string{"12340001": {"owner": "jane"}},
+			Parents:             map[string]string{"12340001": "ou-1234-engineering", "ou-1234-engineering": "r-1234"},
+			OrganizationalUnits: map[string]string{"ou-1234-engineering": "engineering"},
+		},
+	}).WithOwnerAndPath(false, false)
+
+	if err := a.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if act, exp := testutil.ToFloat64(metric.WithLabelValues("aws", "USD", "acme-dev", "AmazonEC2", "", "", "", "")), 9.636; math.Abs(act-exp) > 1e-9 {
+		t.Errorf("unexpected costs of acme-dev: %f (expected: %f)", act, exp)
+	}
+}
+
+func TestQueryWithAccountsManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	manifest := filepath.Join(dir, "accounts.yaml")
+	if err := ioutil.WriteFile(manifest, []byte(`accounts:
+- accountId: "12340001"
+  accountName: acme-dev
+  path: example/engineering
+  alternateContacts:
+  - alternateContactType: BILLING
+    emailAddress: finance@example.com
+  - alternateContactType: OPERATIONS
+    emailAddress: jane@example.com
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+	a := NewAWSBilling(metric, "billing", "eu-west-1", "12340002", "", "owner", "project").WithClients(Clients{
+		Reports: &fake.S3{Objects: map[string]string{"12340002-aws-billing-csv-2017-04.csv": fakeReport}},
+		// the organizations API must not be used
+		Organizations: &fake.Organizations{},
+	}).WithAccountsManifest(manifest, "OPERATIONS")
+
+	if err := a.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if act, exp := testutil.ToFloat64(metric.WithLabelValues("aws", "USD", "acme-dev", "AmazonEC2", "example/engineering", "jane@example.com", "", "")), 9.636; math.Abs(act-exp) > 1e-9 {
+		t.Errorf("unexpected costs of acme-dev: %f (expected: %f)", act, exp)
+	}
+	for _, p := range a.Permissions() {
+		if strings.HasPrefix(p.Action, "organizations:") {
+			t.Errorf("unexpected permission with accounts manifest: %s", p.Action)
+		}
+	}
+}
+
+func TestQueryRetriesTruncatedReport(t *testing.T) {
+	reports := &fake.S3{Objects: map[string]string{"12340002-aws-billing-csv-2017-04.csv": fakeReport}, Truncate: 1}
+	metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+	a := NewAWSBilling(metric, "billing", "eu-west-1", "12340002", "", "owner", "project").WithClients(Clients{
+		Reports: reports,
+	}).WithEnrichment(false)
+
+	if err := a.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if act, exp := testutil.ToFloat64(metric.WithLabelValues("aws", "USD", "12340001", "AmazonEC2", "", "", "", "")), 9.636; math.Abs(act-exp) > 1e-9 {
+		t.Errorf("unexpected costs of 12340001: %f (expected: %f)", act, exp)
+	}
+
+	// fails once all attempts are truncated
+	reports.Objects["12340002-aws-billing-csv-2017-05.csv"] = fakeReport + "\n"
+	reports.Truncate = 3
+	if err := a.Query(); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("expected checksum mismatch, got: %v", err)
+	}
+}
+
+func TestRootAccountIDCached(t *testing.T) {
+	identity := &fake.STS{Account: "12340002"}
+	a := NewAWSBilling(nil, "billing", "eu-west-1", "", "", "owner", "project").WithClients(Clients{Identity: identity})
+
+	// the collector is named by the root account on every scrape
+	for i := 0; i < 3; i++ {
+		if act, exp := a.String(), "AWS Billing on root account '12340002' in bucket 'billing'"; act != exp {
+			t.Errorf("unexpected name: %s (expected: %s)", act, exp)
+		}
+	}
+	if identity.Calls != 1 {
+		t.Errorf("expected the root account ID to be looked up once, got %d lookups", identity.Calls)
+	}
+}
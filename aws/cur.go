@@ -0,0 +1,428 @@
+package aws
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/prometheus/common/log"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+	"github.com/simonswine/cloud-billing-exporter/parquet"
+	"github.com/simonswine/cloud-billing-exporter/parse"
+)
+
+const (
+	// ReportTypeDBR reads the legacy detailed billing reports
+	ReportTypeDBR = "dbr"
+	// ReportTypeCUR reads the Cost and Usage Reports
+	ReportTypeCUR = "cur"
+)
+
+const (
+	// curTagColumnPrefix is the prefix of the columns of the resource tags
+	// in the Cost and Usage Report
+	curTagColumnPrefix = "resourceTags/"
+	// UntaggedAccount replaces the account of the costs without the resource
+	// tag, if the costs are aggregated by tag
+	UntaggedAccount = "untagged"
+)
+
+// curManifestKey matches the key of the manifest of a billing period of a
+// Cost and Usage Report, like prefix/name/20191101-20191201/name-Manifest.json.
+// The manifests of the single deliveries below it are ignored.
+var curManifestKey = regexp.MustCompile(`(^|/)(\d{4})(\d{2})\d{2}-\d{8}/[^/]+-Manifest\.json$`)
+
+// curColumns are the columns of the Cost and Usage Report read
+var curColumns = []string{
+	"lineItem/UsageAccountId",
+	"lineItem/ProductCode",
+	"lineItem/CurrencyCode",
+	"lineItem/UnblendedCost",
+	"lineItem/UsageType",
+	"lineItem/UsageAmount",
+}
+
+// curManifest lists the report files of the latest delivery of a billing
+// period
+type curManifest struct {
+	AssemblyID  string   `json:"assemblyId"`
+	ReportName  string   `json:"reportName"`
+	Compression string   `json:"compression"`
+	ReportKeys  []string `json:"reportKeys"`
+}
+
+// WithReportType selects the reports read from the bucket, one of
+// ReportTypeDBR or ReportTypeCUR. The Cost and Usage Reports are found by
+// their prefix in the bucket followed by the report name, e.g. cur/acme.
+func (a *AWSBilling) WithReportType(reportType, prefix string) (*AWSBilling, error) {
+	switch reportType {
+	case ReportTypeDBR, ReportTypeCUR:
+	default:
+		return nil, fmt.Errorf("unknown report type '%s', expected %s or %s", reportType, ReportTypeDBR, ReportTypeCUR)
+	}
+	a.reportType = reportType
+	a.reportPrefix = prefix
+	return a, nil
+}
+
+// WithCURTag aggregates the costs of the Cost and Usage Reports by the values
+// of the resource tag instead of the linked account, e.g. by
+// user:kubernetes.io/cluster. The tag values replace the account, costs
+// without the tag are exposed as UntaggedAccount. It needs to be called after
+// WithReportType.
+func (a *AWSBilling) WithCURTag(tagKey string) (*AWSBilling, error) {
+	if tagKey == "" {
+		return a, nil
+	}
+	if a.reportType != ReportTypeCUR {
+		return nil, fmt.Errorf("costs can only be aggregated by tag with report type %s", ReportTypeCUR)
+	}
+	a.curTagColumn = curTagColumnPrefix + tagKey
+	return a, nil
+}
+
+// readCUR returns the costs per account, service and currency and the usage
+// per usage type of the line items of a CSV Cost and Usage Report file
+func readCUR(input io.Reader, tagColumn string, p *parse.Pipeline, stats *parse.Stats) ([]*awsBillingElement, []billing.Usage, error) {
+	r := csv.NewReader(input)
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	return aggregateCUR(header, r.Read, tagColumn, p, stats)
+}
+
+// curParquetKey returns the key of a column of the Cost and Usage Report to
+// match the columns of the Parquet reports, which are named in snake case,
+// e.g. lineItem/UsageAccountId is line_item_usage_account_id and
+// resourceTags/user:Owner is resource_tags_user_owner
+func curParquetKey(column string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		if r >= 'A' && r <= 'Z' {
+			return r - 'A' + 'a'
+		}
+		return -1
+	}, column)
+}
+
+// readCURParquet returns the costs and usage of a Parquet Cost and Usage
+// Report file like readCUR. The file is spooled to a temporary file of the
+// pipeline, as Parquet files are read from their footer.
+func readCURParquet(input io.Reader, tagColumn string, p *parse.Pipeline, stats *parse.Stats) ([]*awsBillingElement, []billing.Usage, error) {
+	spool, err := p.TempFile("parquet-")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+	size, err := io.Copy(spool, input)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := parquet.Open(spool, size)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// only the columns read are decoded, named like the ones of the CSV
+	// reports
+	byKey := make(map[string]string)
+	for _, name := range f.Columns() {
+		byKey[curParquetKey(name)] = name
+	}
+	columns := append([]string{"lineItem/LineItemType"}, curColumns...)
+	if tagColumn != "" {
+		columns = append(columns, tagColumn)
+	}
+	var header, names []string
+	for _, column := range columns {
+		if name, ok := byKey[curParquetKey(column)]; ok {
+			header = append(header, column)
+			names = append(names, name)
+		}
+	}
+	r, err := f.Reader(names...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return aggregateCUR(header, r.Read, tagColumn, p, stats)
+}
+
+// aggregateCUR aggregates the rows of a Cost and Usage Report file with the
+// header. All line item types are summed up, as the report contains no
+// totals. If the tag column is set, the costs are aggregated by its values
+// instead of the account.
+func aggregateCUR(header []string, read func() ([]string, error), tagColumn string, p *parse.Pipeline, stats *parse.Stats) ([]*awsBillingElement, []billing.Usage, error) {
+	pos := make(map[string]int)
+	for i, field := range header {
+		pos[field] = i
+	}
+	for _, column := range curColumns {
+		if _, ok := pos[column]; !ok {
+			return nil, nil, fmt.Errorf("missing column %s", column)
+		}
+	}
+	if _, ok := pos[tagColumn]; tagColumn != "" && !ok {
+		return nil, nil, fmt.Errorf("missing column %s, the tag needs to be activated as cost allocation tag", tagColumn)
+	}
+
+	costs := p.NewAggregator()
+	defer costs.Close()
+	quantities := p.NewAggregator()
+	defer quantities.Close()
+
+	for {
+		record, err := read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		stats.Read()
+
+		account := record[pos["lineItem/UsageAccountId"]]
+		if tagColumn != "" {
+			account = record[pos[tagColumn]]
+			if account == "" {
+				account = UntaggedAccount
+			}
+		}
+		cost, err := strconv.ParseFloat(record[pos["lineItem/UnblendedCost"]], 64)
+		if err != nil {
+			log.Warnf("Couldn't parse costs float: %s", err)
+			stats.InvalidCost()
+			continue
+		}
+		stats.Aggregated(account)
+
+		refund := 0.0
+		if cost < 0 {
+			refund = -cost
+		}
+		var tax, credit, refundItem float64
+		if i, ok := pos["lineItem/LineItemType"]; ok {
+			switch record[i] {
+			case "Tax":
+				tax = cost
+			case "Credit":
+				credit = -cost
+			case "Refund":
+				refundItem = -cost
+			}
+		}
+		service := record[pos["lineItem/ProductCode"]]
+		currency := record[pos["lineItem/CurrencyCode"]]
+		if err := costs.Add(aggregationKey(account, service, currency), cost, refund, tax, credit, refundItem); err != nil {
+			return nil, nil, err
+		}
+
+		if usageType := record[pos["lineItem/UsageType"]]; usageType != "" {
+			quantity, err := strconv.ParseFloat(record[pos["lineItem/UsageAmount"]], 64)
+			if err != nil {
+				log.Warnf("Couldn't parse usage amount float: %s", err)
+				continue
+			}
+			if err := quantities.Add(aggregationKey(service, usageType, currency), quantity, cost); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return aggregatedCosts(costs, quantities)
+}
+
+// mergeCosts sums up the costs and usage of the files of a report
+func mergeCosts(elems []*awsBillingElement, usage []billing.Usage, moreElems []*awsBillingElement, moreUsage []billing.Usage) ([]*awsBillingElement, []billing.Usage) {
+	byKey := make(map[string]*awsBillingElement, len(elems))
+	for _, e := range elems {
+		byKey[groupByProjectIDServiceCurrency(e)] = e
+	}
+	for _, e := range moreElems {
+		if existing, ok := byKey[groupByProjectIDServiceCurrency(e)]; ok {
+			existing.Costs += e.Costs
+			existing.Refunds += e.Refunds
+			existing.Taxes += e.Taxes
+			existing.Credits += e.Credits
+			existing.RefundItems += e.RefundItems
+			continue
+		}
+		byKey[groupByProjectIDServiceCurrency(e)] = e
+		elems = append(elems, e)
+	}
+
+	usageKey := func(u billing.Usage) string {
+		return aggregationKey(u.Service, u.SKU, u.Currency)
+	}
+	usageByKey := make(map[string]int, len(usage))
+	for i, u := range usage {
+		usageByKey[usageKey(u)] = i
+	}
+	for _, u := range moreUsage {
+		if i, ok := usageByKey[usageKey(u)]; ok {
+			usage[i].Quantity += u.Quantity
+			usage[i].Costs += u.Costs
+			continue
+		}
+		usageByKey[usageKey(u)] = len(usage)
+		usage = append(usage, u)
+	}
+	return elems, usage
+}
+
+// queryCUR parses the files of the latest delivery of the Cost and Usage
+// Report of the latest billing period
+func (a *AWSBilling) queryCUR(ctx context.Context) error {
+	svc, err := a.reportBucket()
+	if err != nil {
+		return err
+	}
+
+	params := &s3.ListObjectsInput{
+		Bucket:       aws.String(a.BucketName),
+		Prefix:       aws.String(a.reportPrefix),
+		RequestPayer: a.requestPayer(),
+	}
+
+	// the manifest of the latest billing period lists the report files of
+	// its latest delivery
+	var manifestObject *s3.Object
+	var month string
+	manifests := 0
+	objects := make(map[string]*s3.Object)
+	if err := svc.ListObjectsPagesWithContext(ctx, params, func(resp *s3.ListObjectsOutput, _ bool) bool {
+		for _, object := range resp.Contents {
+			key := *object.Key
+			objects[key] = object
+			match := curManifestKey.FindStringSubmatch(key)
+			if match == nil {
+				continue
+			}
+			objectMonth := match[2] + "-" + match[3]
+			log.Debugf("found report manifest '%s' for '%s'", key, objectMonth)
+			manifests++
+			if manifestObject == nil || objectMonth > month || (objectMonth == month && key > *manifestObject.Key) {
+				manifestObject = object
+				month = objectMonth
+			}
+		}
+		return true
+	}); err != nil {
+		return fmt.Errorf("Error listing AWS bucket: %s", err)
+	}
+	source := fmt.Sprintf("s3://%s/%s", a.BucketName, a.reportPrefix)
+	if a.localReports() {
+		source = strings.TrimSuffix(a.BucketName, "/") + "/" + a.reportPrefix
+	}
+	a.setDiagnostics(source, manifests, manifestObject)
+
+	if manifestObject == nil {
+		return fmt.Errorf("No Cost and Usage Report manifest below '%s' found in bucket '%s'", a.reportPrefix, a.BucketName)
+	}
+
+	key := *manifestObject.Key
+	log.Debugf("use report manifest '%s' for '%s' hash (%s)", key, month, *manifestObject.ETag)
+
+	// lock from here on
+	a.ReportsLock.Lock()
+	defer a.ReportsLock.Unlock()
+
+	if err := a.restoreState(ctx); err != nil {
+		return err
+	}
+
+	// the tag is part of the hash, so the restored costs are parsed again,
+	// once the tag changes
+	hash := *manifestObject.ETag
+	if a.curTagColumn != "" {
+		hash += "/" + a.curTagColumn
+	}
+	if a.ReportHash == hash {
+		log.Debugf("report manifest '%s' has already been parsed", key)
+		return nil
+	}
+
+	manifest, err := a.readManifest(ctx, svc, manifestObject)
+	if err != nil {
+		return err
+	}
+	read := readCUR
+	switch strings.ToUpper(manifest.Compression) {
+	case "GZIP", "":
+	case "PARQUET":
+		read = readCURParquet
+	default:
+		return fmt.Errorf("Cost and Usage Report '%s' has unsupported compression %s, configure the report with GZIP compression or in Parquet format", manifest.ReportName, manifest.Compression)
+	}
+
+	jobs := make([]parse.Job, len(manifest.ReportKeys))
+	results := make([]struct {
+		elems []*awsBillingElement
+		usage []billing.Usage
+	}, len(manifest.ReportKeys))
+	for i, reportKey := range manifest.ReportKeys {
+		object, ok := objects[reportKey]
+		if !ok {
+			return fmt.Errorf("Report file '%s' of manifest '%s' not found in bucket '%s'", reportKey, key, a.BucketName)
+		}
+		result := &results[i]
+		jobs[i] = a.parseReport(ctx, svc, object, func(report io.Reader, stats *parse.Stats) (err error) {
+			result.elems, result.usage, err = read(report, a.curTagColumn, a.pipeline, stats)
+			return err
+		})
+	}
+	for _, err := range a.pipeline.Run("aws", jobs) {
+		if err != nil {
+			return err
+		}
+	}
+
+	var billingElements []*awsBillingElement
+	var usage []billing.Usage
+	for _, result := range results {
+		billingElements, usage = mergeCosts(billingElements, usage, result.elems, result.usage)
+	}
+	for i := range usage {
+		usage[i].Month = month
+	}
+	a.setUsage(usage)
+	a.updateCosts(ctx, month, billingElements, hash)
+	return nil
+}
+
+// readManifest downloads and decodes the manifest of a Cost and Usage Report
+func (a *AWSBilling) readManifest(ctx context.Context, svc ReportBucket, object *s3.Object) (*curManifest, error) {
+	resp, err := svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket:       aws.String(a.BucketName),
+		Key:          object.Key,
+		RequestPayer: a.requestPayer(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error download report manifest '%s': %s", *object.Key, err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Error download report manifest '%s': %s", *object.Key, err)
+	}
+
+	var manifest curManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("Error parsing report manifest '%s': %s", *object.Key, err)
+	}
+	return &manifest, nil
+}
@@ -0,0 +1,197 @@
+package aws
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+	"github.com/simonswine/cloud-billing-exporter/fake"
+)
+
+const fakeCURHeader = "identity/LineItemId,bill/PayerAccountId,lineItem/UsageAccountId,lineItem/LineItemType,lineItem/ProductCode,lineItem/UsageType,lineItem/UsageAmount,lineItem/CurrencyCode,lineItem/UnblendedCost\n"
+
+func fakeCURManifest(reportKeys ...string) string {
+	return `{"assemblyId":"a1","reportName":"acme","compression":"GZIP","reportKeys":["` + strings.Join(reportKeys, `","`) + `"]}`
+}
+
+func TestQueryCUR(t *testing.T) {
+	reports := &fake.S3{Objects: map[string]string{
+		"cur/acme/20171001-20171101/acme-Manifest.json":    fakeCURManifest("cur/acme/20171001-20171101/a0/acme-1.csv.gz"),
+		"cur/acme/20171001-20171101/a0/acme-1.csv.gz":      gzipped(t, fakeCURHeader+"1,12340002,12340001,Usage,AmazonEC2,BoxUsage,1,USD,100\n"),
+		"cur/acme/20171101-20171201/a1/acme-Manifest.json": fakeCURManifest("cur/acme/20171101-20171201/a1/acme-1.csv.gz"),
+		"cur/acme/20171101-20171201/acme-Manifest.json":    fakeCURManifest("cur/acme/20171101-20171201/a1/acme-1.csv.gz", "cur/acme/20171101-20171201/a1/acme-2.csv.gz"),
+		"cur/acme/20171101-20171201/a1/acme-1.csv.gz": gzipped(t, fakeCURHeader+
+			"1,12340002,12340001,Usage,AmazonEC2,BoxUsage:t3.small,10,USD,0.25\n"+
+			"2,12340002,12340001,Usage,AmazonEC2,BoxUsage:t3.small,20,USD,0.5\n"+
+			"3,12340002,12340001,Credit,AmazonEC2,,0,USD,-0.125\n"),
+		"cur/acme/20171101-20171201/a1/acme-2.csv.gz": gzipped(t, fakeCURHeader+
+			"4,12340002,12340001,Usage,AmazonEC2,BoxUsage:t3.small,10,USD,0.25\n"+
+			"5,12340002,12340003,Usage,AmazonS3,TimedStorage-ByteHrs,2,USD,1\n"+
+			"6,12340002,12340003,Tax,AmazonS3,,0,USD,0.2\n"),
+	}}
+	metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+	a, err := NewAWSBilling(metric, "billing", "eu-west-1", "", "", "owner", "project").WithOwnerAndPath(false, false).WithClients(Clients{
+		Reports:       reports,
+		Organizations: &fake.Organizations{},
+	}).WithReportType(ReportTypeCUR, "cur/acme")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := a.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	costs := make(map[string]float64)
+	for _, r := range a.Records() {
+		if r.Month != "2017-11" {
+			t.Errorf("unexpected month of the latest billing period: %+v", r)
+		}
+		costs[r.Account+"/"+r.Service] = r.Costs
+	}
+	if exp := map[string]float64{"unknown-12340001/AmazonEC2": 0.875, "unknown-12340003/AmazonS3": 1.2}; !reflect.DeepEqual(costs, exp) {
+		t.Errorf("unexpected costs: %+v (expected: %+v)", costs, exp)
+	}
+	adjustments := make(map[string]float64)
+	for _, adjustment := range a.Adjustments() {
+		adjustments[adjustment.Account+"/"+adjustment.RecordType] = adjustment.Amount
+	}
+	if exp := map[string]float64{"unknown-12340001/credit": 0.125, "unknown-12340003/tax": 0.2}; !reflect.DeepEqual(adjustments, exp) {
+		t.Errorf("unexpected adjustments: %+v (expected: %+v)", adjustments, exp)
+	}
+	for _, u := range a.Usage() {
+		if u.SKU == "BoxUsage:t3.small" && (u.Quantity != 40 || u.Costs != 1) {
+			t.Errorf("unexpected usage: %+v", u)
+		}
+	}
+}
+
+func TestQueryCURParquet(t *testing.T) {
+	report := fake.ParquetFile{
+		Columns: []fake.ParquetColumn{
+			{Name: "identity_line_item_id", Values: []interface{}{"1", "2", "3", "4"}},
+			{Name: "line_item_usage_account_id", Values: []interface{}{"12340001", "12340001", "12340001", "12340003"}, Dictionary: true},
+			{Name: "line_item_line_item_type", Values: []interface{}{"Usage", "Usage", "Credit", "Usage"}, Dictionary: true},
+			{Name: "line_item_product_code", Values: []interface{}{"AmazonEC2", "AmazonEC2", "AmazonEC2", "AmazonS3"}, Dictionary: true},
+			{Name: "line_item_usage_type", Values: []interface{}{"BoxUsage:t3.small", "BoxUsage:t3.small", nil, "TimedStorage-ByteHrs"}},
+			{Name: "line_item_usage_amount", Values: []interface{}{10.0, 20.0, nil, 2.0}},
+			{Name: "line_item_currency_code", Values: []interface{}{"USD", "USD", "USD", "USD"}, Dictionary: true},
+			{Name: "line_item_unblended_cost", Values: []interface{}{0.25, 0.5, -0.125, 1.0}},
+			{Name: "resource_tags_user_kubernetes_io_cluster", Values: []interface{}{"prod", "prod", nil, nil}},
+		},
+		RowGroupRows: 3,
+		Compression:  "SNAPPY",
+	}
+	reports := &fake.S3{Objects: map[string]string{
+		"cur/acme/20171101-20171201/acme-Manifest.json":       `{"assemblyId":"a1","reportName":"acme","compression":"Parquet","reportKeys":["cur/acme/20171101-20171201/a1/acme-1.snappy.parquet"]}`,
+		"cur/acme/20171101-20171201/a1/acme-1.snappy.parquet": report.String(),
+	}}
+
+	for name, tc := range map[string]struct {
+		tag      string
+		expected map[string]float64
+	}{
+		"accounts": {
+			expected: map[string]float64{"unknown-12340001/AmazonEC2": 0.625, "unknown-12340003/AmazonS3": 1},
+		},
+		"tag": {
+			tag:      "user:kubernetes.io/cluster",
+			expected: map[string]float64{"prod/AmazonEC2": 0.75, "untagged/AmazonEC2": -0.125, "untagged/AmazonS3": 1},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+			a, err := NewAWSBilling(metric, "billing", "eu-west-1", "", "", "owner", "project").WithOwnerAndPath(false, false).WithClients(Clients{
+				Reports:       reports,
+				Organizations: &fake.Organizations{},
+			}).WithReportType(ReportTypeCUR, "cur/acme")
+			if err == nil {
+				a, err = a.WithCURTag(tc.tag)
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if err := a.Query(); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			costs := make(map[string]float64)
+			for _, r := range a.Records() {
+				costs[r.Account+"/"+r.Service] = r.Costs
+			}
+			if !reflect.DeepEqual(costs, tc.expected) {
+				t.Errorf("unexpected costs: %+v (expected: %+v)", costs, tc.expected)
+			}
+			for _, u := range a.Usage() {
+				if u.SKU == "BoxUsage:t3.small" && (u.Quantity != 30 || u.Costs != 0.75) {
+					t.Errorf("unexpected usage: %+v", u)
+				}
+			}
+		})
+	}
+}
+
+func TestReadCURParquetMissingColumn(t *testing.T) {
+	report := fake.ParquetFile{Columns: []fake.ParquetColumn{
+		{Name: "line_item_usage_account_id", Values: []interface{}{"12340001"}},
+		{Name: "line_item_unblended_cost", Values: []interface{}{1.0}},
+	}}
+	if _, _, err := readCURParquet(strings.NewReader(report.String()), "", nil, nil); err == nil || !strings.Contains(err.Error(), "missing column lineItem/ProductCode") {
+		t.Errorf("expected an error for the missing columns, got: %v", err)
+	}
+	if _, _, err := readCURParquet(strings.NewReader(fakeCURHeader), "", nil, nil); err == nil || !strings.Contains(err.Error(), "not a Parquet file") {
+		t.Errorf("expected an error for the CSV report, got: %v", err)
+	}
+}
+
+func TestReadCURMissingColumn(t *testing.T) {
+	if _, _, err := readCUR(strings.NewReader("lineItem/UsageAccountId,lineItem/UnblendedCost\n12340001,1\n"), "", nil, nil); err == nil {
+		t.Error("expected an error for the missing columns")
+	}
+	if _, err := NewAWSBilling(nil, "billing", "eu-west-1", "", "", "owner", "project").WithReportType("csv", ""); err == nil {
+		t.Error("expected an error for an unknown report type")
+	}
+}
+
+func TestQueryCURTag(t *testing.T) {
+	header := "identity/LineItemId,lineItem/UsageAccountId,lineItem/LineItemType,lineItem/ProductCode,lineItem/UsageType,lineItem/UsageAmount,lineItem/CurrencyCode,lineItem/UnblendedCost,resourceTags/user:kubernetes.io/cluster\n"
+	reports := &fake.S3{Objects: map[string]string{
+		"cur/acme/20171101-20171201/acme-Manifest.json": fakeCURManifest("cur/acme/20171101-20171201/a1/acme-1.csv.gz"),
+		"cur/acme/20171101-20171201/a1/acme-1.csv.gz": gzipped(t, header+
+			"1,12340001,Usage,AmazonEC2,BoxUsage:t3.small,10,USD,0.25,prod\n"+
+			"2,12340003,Usage,AmazonEC2,BoxUsage:t3.small,20,USD,0.5,prod\n"+
+			"3,12340001,Usage,AmazonEC2,BoxUsage:t3.small,10,USD,0.25,dev\n"+
+			"4,12340001,Usage,AmazonS3,TimedStorage-ByteHrs,2,USD,1,\n"),
+	}}
+	metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+	a, err := NewAWSBilling(metric, "billing", "eu-west-1", "", "", "owner", "project").WithOwnerAndPath(false, false).WithClients(Clients{
+		Reports:       reports,
+		Organizations: &fake.Organizations{},
+	}).WithReportType(ReportTypeCUR, "cur/acme")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := a.WithCURTag("user:kubernetes.io/cluster"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := a.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	costs := make(map[string]float64)
+	for _, r := range a.Records() {
+		costs[r.Account+"/"+r.Service] = r.Costs
+	}
+	if exp := map[string]float64{"prod/AmazonEC2": 0.75, "dev/AmazonEC2": 0.25, "untagged/AmazonS3": 1}; !reflect.DeepEqual(costs, exp) {
+		t.Errorf("unexpected costs: %+v (expected: %+v)", costs, exp)
+	}
+
+	if _, err := NewAWSBilling(nil, "billing", "eu-west-1", "", "", "owner", "project").WithCURTag("user:team"); err == nil {
+		t.Error("expected an error for aggregating the detailed billing reports by tag")
+	}
+	if _, _, err := readCUR(strings.NewReader(fakeCURHeader), "resourceTags/user:team", nil, nil); err == nil {
+		t.Error("expected an error for the missing tag column")
+	}
+}
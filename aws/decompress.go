@@ -0,0 +1,36 @@
+package aws
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// reportExtensions are the extensions of uncompressed and compressed reports
+var reportExtensions = []string{".csv.gz", ".csv.zst", ".csv"}
+
+// decompress returns a reader of the decompressed report. Gzip and zstd
+// compressed reports are detected by their magic number, independent of
+// their key and content encoding, and decompressed while they are read.
+func decompress(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, zstdMagic):
+		return newZstdReader(br)
+	}
+	return ioutil.NopCloser(br), nil
+}
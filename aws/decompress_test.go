@@ -0,0 +1,67 @@
+package aws
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+	"github.com/simonswine/cloud-billing-exporter/fake"
+)
+
+func gzipped(t *testing.T, s string) string {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestDecompress(t *testing.T) {
+	for name, input := range map[string]string{
+		"plain": "a,b\n1,2\n",
+		"gzip":  gzipped(t, "a,b\n1,2\n"),
+		"empty": "",
+	} {
+		r, err := decompress(strings.NewReader(input))
+		if err != nil {
+			t.Fatalf("unexpected error decompressing %s: %s", name, err)
+		}
+		act, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("unexpected error reading %s: %s", name, err)
+		}
+		if exp := "a,b\n1,2\n"; name != "empty" && string(act) != exp {
+			t.Errorf("unexpected content of %s: %q (expected: %q)", name, act, exp)
+		}
+	}
+}
+
+func TestQueryGzipReport(t *testing.T) {
+	metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+	a := NewAWSBilling(metric, "billing", "eu-west-1", "12340002", "", "owner", "project").WithClients(Clients{
+		Reports: &fake.S3{Objects: map[string]string{"12340002-aws-billing-csv-2017-04.csv.gz": gzipped(t, fakeReport)}},
+	}).WithEnrichment(false)
+
+	if err := a.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if act, exp := testutil.ToFloat64(metric.WithLabelValues("aws", "USD", "12340001", "AmazonEC2", "", "", "", "")), 9.636; math.Abs(act-exp) > 1e-9 {
+		t.Errorf("unexpected costs of 12340001: %f (expected: %f)", act, exp)
+	}
+	for _, r := range a.Records() {
+		if r.Month != "2017-04" {
+			t.Errorf("unexpected month: %+v", r)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package aws
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/prometheus/common/log"
+)
+
+// WithRangedDownloads downloads reports larger than partSize with
+// concurrency parallel ranged GETs of partSize into a temporary file, which
+// is faster than a single GET over high-latency links. Reports are streamed
+// with a single GET if concurrency is below 2.
+func (a *AWSBilling) WithRangedDownloads(partSize int64, concurrency int) *AWSBilling {
+	a.downloadPartSize = partSize
+	a.downloadConcurrency = concurrency
+	return a
+}
+
+// etagMD5 returns the MD5 of the object from its ETag, the ETag of objects
+// uploaded in multiple parts is no MD5 of the content
+func etagMD5(object *s3.Object) []byte {
+	etag := strings.Trim(aws.StringValue(object.ETag), `"`)
+	if strings.Contains(etag, "-") {
+		return nil
+	}
+	sum, err := hex.DecodeString(etag)
+	if err != nil || len(sum) != md5.Size {
+		return nil
+	}
+	return sum
+}
+
+// WithResumableDownloads downloads the reports in parts into the directory,
+// the downloaded parts are persisted so interrupted downloads are resumed on
+// the next attempt
+func (a *AWSBilling) WithResumableDownloads(dir string) *AWSBilling {
+	a.downloadDir = dir
+	return a
+}
+
+// downloadState is the progress of a resumable download, it is persisted
+// next to the partial report
+type downloadState struct {
+	Key      string
+	ETag     string
+	Size     int64
+	PartSize int64
+	// Parts contains the start offsets of the downloaded parts
+	Parts map[int64]bool
+}
+
+// tempReport is a downloaded report, which is removed once closed
+type tempReport struct {
+	*os.File
+
+	// statePath is the progress of a resumable download
+	statePath string
+
+	lock  sync.Mutex
+	state *downloadState
+}
+
+func (f *tempReport) Close() error {
+	err := f.File.Close()
+	if removeErr := os.Remove(f.Name()); removeErr != nil {
+		log.Warnf("error removing downloaded report %s: %s", f.Name(), removeErr)
+	}
+	if f.statePath != "" {
+		if removeErr := os.Remove(f.statePath); removeErr != nil && !os.IsNotExist(removeErr) {
+			log.Warnf("error removing download state %s: %s", f.statePath, removeErr)
+		}
+	}
+	return err
+}
+
+// abort closes a failed download, partial resumable downloads are kept
+func (f *tempReport) abort() {
+	if f.statePath != "" {
+		_ = f.File.Close()
+		return
+	}
+	_ = f.Close()
+}
+
+// downloaded records the part as downloaded, resumable downloads persist it
+func (f *tempReport) downloaded(start int64) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.state.Parts[start] = true
+	if f.statePath == "" {
+		return nil
+	}
+	data, err := json.Marshal(f.state)
+	if err != nil {
+		return err
+	}
+	tmp := f.statePath + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.statePath)
+}
+
+// openReport returns the content of the report object
+func (a *AWSBilling) openReport(ctx context.Context, svc ReportBucket, object *s3.Object) (io.ReadCloser, error) {
+	size := aws.Int64Value(object.Size)
+	ranged := a.downloadConcurrency >= 2 && a.downloadPartSize > 0 && size > a.downloadPartSize
+	if !ranged && a.downloadDir == "" {
+		resp, err := svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+			Bucket:       aws.String(a.BucketName),
+			Key:          object.Key,
+			RequestPayer: a.requestPayer(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return resp.Body, nil
+	}
+	return a.downloadRanged(ctx, svc, object)
+}
+
+// createReport creates the file the report is downloaded to. Resumable
+// downloads continue a partial download of the same object version.
+func (a *AWSBilling) createReport(object *s3.Object, partSize int64) (*tempReport, error) {
+	state := &downloadState{
+		Key:      aws.StringValue(object.Key),
+		ETag:     aws.StringValue(object.ETag),
+		Size:     aws.Int64Value(object.Size),
+		PartSize: partSize,
+		Parts:    make(map[int64]bool),
+	}
+
+	if a.downloadDir == "" {
+		f, err := ioutil.TempFile("", "aws-report-")
+		if err != nil {
+			return nil, fmt.Errorf("error creating temporary file: %s", err)
+		}
+		return &tempReport{File: f, state: state}, nil
+	}
+
+	name := fmt.Sprintf("%x", sha256.Sum256([]byte(a.BucketName+"/"+state.Key)))[:16]
+	reportPath := filepath.Join(a.downloadDir, name+".report")
+	statePath := filepath.Join(a.downloadDir, name+".json")
+
+	if data, err := ioutil.ReadFile(statePath); err == nil {
+		var previous downloadState
+		if err := json.Unmarshal(data, &previous); err != nil {
+			log.Warnf("error parsing download state %s: %s", statePath, err)
+		} else if previous.Key == state.Key && previous.ETag == state.ETag && previous.Size == state.Size && previous.PartSize == state.PartSize && previous.Parts != nil {
+			log.Infof("resuming download of report '%s' with %d parts downloaded", state.Key, len(previous.Parts))
+			state = &previous
+		}
+	}
+
+	flags := os.O_RDWR | os.O_CREATE
+	if len(state.Parts) == 0 {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(reportPath, flags, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error creating download file: %s", err)
+	}
+	return &tempReport{File: f, statePath: statePath, state: state}, nil
+}
+
+// downloadRanged downloads the parts of the report in parallel into a file
+func (a *AWSBilling) downloadRanged(ctx context.Context, svc ReportBucket, object *s3.Object) (io.ReadCloser, error) {
+	size := aws.Int64Value(object.Size)
+	partSize := a.downloadPartSize
+	if partSize <= 0 {
+		partSize = size
+	}
+	concurrency := a.downloadConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	report, err := a.createReport(object, partSize)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	log.Debugf("downloading report '%s' of %d bytes in parts of %d bytes", aws.StringValue(object.Key), size, partSize)
+
+	parts := make(chan int64)
+	errs := make(chan error, concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for start := range parts {
+				end := start + partSize - 1
+				if end >= size {
+					end = size - 1
+				}
+				err := a.downloadPart(ctx, svc, object, report.File, start, end)
+				if err == nil {
+					err = report.downloaded(start)
+				}
+				if err != nil {
+					errs <- err
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+
+feed:
+	for start := int64(0); start < size; start += partSize {
+		if report.state.Parts[start] {
+			continue
+		}
+		select {
+		case parts <- start:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(parts)
+	wg.Wait()
+
+	select {
+	case err := <-errs:
+		report.abort()
+		return nil, err
+	default:
+	}
+	if _, err := report.Seek(0, io.SeekStart); err != nil {
+		report.abort()
+		return nil, err
+	}
+	return report, nil
+}
+
+// downloadPart downloads the bytes from start to end, the ETag needs to
+// match so parts of a replaced report are not mixed
+func (a *AWSBilling) downloadPart(ctx context.Context, svc ReportBucket, object *s3.Object, w io.WriterAt, start, end int64) error {
+	resp, err := svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket:       aws.String(a.BucketName),
+		Key:          object.Key,
+		Range:        aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+		IfMatch:      object.ETag,
+		RequestPayer: a.requestPayer(),
+	})
+	if err != nil {
+		return fmt.Errorf("error downloading bytes %d-%d: %s", start, end, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error downloading bytes %d-%d: %s", start, end, err)
+	}
+	if int64(len(data)) != end-start+1 {
+		return fmt.Errorf("error downloading bytes %d-%d: got %d bytes", start, end, len(data))
+	}
+	_, err = w.WriteAt(data, start)
+	return err
+}
@@ -0,0 +1,126 @@
+package aws
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+	"github.com/simonswine/cloud-billing-exporter/fake"
+)
+
+func TestQueryRangedDownload(t *testing.T) {
+	reports := &fake.S3{Objects: map[string]string{"12340002-aws-billing-csv-2017-04.csv": fakeReport}}
+	metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+	a := NewAWSBilling(metric, "billing", "eu-west-1", "12340002", "", "owner", "project").WithClients(Clients{
+		Reports: reports,
+	}).WithEnrichment(false).WithRangedDownloads(100, 3)
+
+	if err := a.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if act, exp := testutil.ToFloat64(metric.WithLabelValues("aws", "USD", "12340001", "AmazonEC2", "", "", "", "")), 9.636; math.Abs(act-exp) > 1e-9 {
+		t.Errorf("unexpected costs of 12340001: %f (expected: %f)", act, exp)
+	}
+	if act, exp := len(reports.Ranges), (len(fakeReport)+99)/100; act != exp {
+		t.Errorf("unexpected number of ranged GETs: %d (expected: %d)", act, exp)
+	}
+}
+
+func TestDownloadRanged(t *testing.T) {
+	content := strings.Repeat("0123456789", 25)
+	reports := &fake.S3{Objects: map[string]string{"report.csv": content}}
+	a := NewAWSBilling(nil, "billing", "eu-west-1", "", "", "", "").WithRangedDownloads(64, 2)
+
+	object := &s3.Object{Key: aws.String("report.csv"), Size: aws.Int64(int64(len(content)))}
+	r, err := a.openReport(context.Background(), reports, object)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("unexpected error closing: %s", err)
+	}
+	if string(data) != content {
+		t.Errorf("unexpected content: %q", data)
+	}
+	sort.Strings(reports.Ranges)
+	if act, exp := strings.Join(reports.Ranges, ","), "bytes=0-63,bytes=128-191,bytes=192-249,bytes=64-127"; act != exp {
+		t.Errorf("unexpected ranges: %s (expected: %s)", act, exp)
+	}
+
+	// parts of a replaced report are not mixed
+	object.ETag = aws.String(`"outdated"`)
+	if _, err := a.openReport(context.Background(), reports, object); err == nil {
+		t.Error("expected error downloading a replaced report")
+	}
+}
+
+// interruptedS3 fails the GETs after the first parts
+type interruptedS3 struct {
+	*fake.S3
+	parts int
+}
+
+func (f *interruptedS3) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	if f.parts == 0 {
+		return nil, awserr.New("RequestError", "connection reset by peer", nil)
+	}
+	f.parts--
+	return f.S3.GetObjectWithContext(ctx, input, opts...)
+}
+
+func TestDownloadResumable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aws-downloads-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := strings.Repeat("0123456789", 25)
+	reports := &fake.S3{Objects: map[string]string{"report.csv": content}}
+	a := NewAWSBilling(nil, "billing", "eu-west-1", "", "", "", "").WithRangedDownloads(64, 1).WithResumableDownloads(dir)
+	object := &s3.Object{Key: aws.String("report.csv"), Size: aws.Int64(int64(len(content)))}
+
+	if _, err := a.openReport(context.Background(), &interruptedS3{S3: reports, parts: 2}, object); err == nil {
+		t.Fatal("expected error downloading the report")
+	}
+
+	reports.Ranges = nil
+	r, err := a.openReport(context.Background(), reports, object)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(data) != content {
+		t.Errorf("unexpected content: %q", data)
+	}
+	if act, exp := strings.Join(reports.Ranges, ","), "bytes=128-191,bytes=192-249"; act != exp {
+		t.Errorf("unexpected ranges: %s (expected: %s)", act, exp)
+	}
+
+	if err := r.Close(); err != nil {
+		t.Errorf("unexpected error closing: %s", err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 0 {
+		t.Errorf("unexpected files after closing: %v", files)
+	}
+}
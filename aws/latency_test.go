@@ -0,0 +1,67 @@
+package aws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+func TestAPILatency(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>billing</Name><IsTruncated>false</IsTruncated></ListBucketResult>`))
+	}))
+	defer server.Close()
+
+	for key, value := range map[string]string{"AWS_ACCESS_KEY_ID": "AKIDEXAMPLE", "AWS_SECRET_ACCESS_KEY": "secret"} {
+		previous, ok := os.LookupEnv(key)
+		os.Setenv(key, value)
+		if ok {
+			defer os.Setenv(key, previous)
+		} else {
+			defer os.Unsetenv(key)
+		}
+	}
+
+	latency := billing.NewAPILatency("cloud")
+	a := NewAWSBilling(nil, "billing", "eu-west-1", "", "", "owner", "project").WithAPILatency(latency)
+	session, err := a.awsSession()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	svc := s3.New(session, a.awsConfig().WithEndpoint(server.URL).WithS3ForcePathStyle(true))
+	if err := svc.ListObjectsPagesWithContext(context.Background(), &s3.ListObjectsInput{Bucket: aws.String("billing")}, func(*s3.ListObjectsOutput, bool) bool {
+		return true
+	}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(latency)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(families) != 1 || len(families[0].GetMetric()) != 1 {
+		t.Fatalf("unexpected metrics: %+v", families)
+	}
+	m := families[0].GetMetric()[0]
+	labels := make(map[string]string)
+	for _, l := range m.GetLabel() {
+		labels[l.GetName()] = l.GetValue()
+	}
+	if labels["cloud"] != "aws" || labels["operation"] != "s3:ListObjects" {
+		t.Errorf("unexpected labels: %+v", labels)
+	}
+	if act := m.GetHistogram().GetSampleCount(); act != 1 {
+		t.Errorf("unexpected number of calls: %d (expected: 1)", act)
+	}
+}
@@ -0,0 +1,95 @@
+package aws
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/simonswine/cloud-billing-exporter/localfs"
+)
+
+// fileReportBucket serves the reports synced into a local directory like
+// the S3 bucket, the ETags are the MD5 of the files
+type fileReportBucket struct {
+	*localfs.Bucket
+}
+
+func newFileReportBucket(dir string) *fileReportBucket {
+	return &fileReportBucket{Bucket: localfs.New(dir)}
+}
+
+// localReports returns true if the reports are read from a local directory
+// given as file:// bucket name, no AWS APIs are called then
+func (a *AWSBilling) localReports() bool {
+	return a.local != nil
+}
+
+func fileETag(f localfs.File) string {
+	return fmt.Sprintf(`"%x"`, f.MD5)
+}
+
+func (b *fileReportBucket) ListObjectsPagesWithContext(_ aws.Context, input *s3.ListObjectsInput, fn func(*s3.ListObjectsOutput, bool) bool, _ ...request.Option) error {
+	files, err := b.List(aws.StringValue(input.Prefix))
+	if err != nil {
+		return err
+	}
+	resp := &s3.ListObjectsOutput{}
+	for _, f := range files {
+		if input.MaxKeys != nil && int64(len(resp.Contents)) >= *input.MaxKeys {
+			break
+		}
+		resp.Contents = append(resp.Contents, &s3.Object{
+			Key:          aws.String(f.Name),
+			ETag:         aws.String(fileETag(f)),
+			Size:         aws.Int64(f.Size),
+			LastModified: aws.Time(f.Modified),
+		})
+	}
+	fn(resp, true)
+	return nil
+}
+
+func (b *fileReportBucket) GetObjectWithContext(_ aws.Context, input *s3.GetObjectInput, _ ...request.Option) (*s3.GetObjectOutput, error) {
+	key := aws.StringValue(input.Key)
+	f, err := b.Stat(key)
+	if err == localfs.ErrNotExist {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	} else if err != nil {
+		return nil, err
+	}
+	if input.IfMatch != nil && *input.IfMatch != fileETag(f) {
+		return nil, awserr.New("PreconditionFailed", "At least one of the pre-conditions you specified did not hold", nil)
+	}
+
+	r, err := b.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	var body io.ReadCloser = r
+	length := f.Size
+	if input.Range != nil {
+		var start, end int64
+		if _, err := fmt.Sscanf(*input.Range, "bytes=%d-%d", &start, &end); err != nil || start > end || start >= f.Size {
+			r.Close()
+			return nil, awserr.New("InvalidRange", "The requested range is not satisfiable", nil)
+		}
+		if end >= f.Size {
+			end = f.Size - 1
+		}
+		length = end - start + 1
+		body = struct {
+			io.Reader
+			io.Closer
+		}{io.NewSectionReader(r, start, length), r}
+	}
+	return &s3.GetObjectOutput{
+		Body:          body,
+		ContentLength: aws.Int64(length),
+		ETag:          aws.String(fileETag(f)),
+		LastModified:  aws.Time(f.Modified),
+	}, nil
+}
@@ -0,0 +1,48 @@
+package aws
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+func TestQueryLocalReports(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aws-reports-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"12340002-aws-billing-csv-2017-03.csv", "12340002-aws-billing-csv-2017-04.csv"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(fakeReport), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+	a := NewAWSBilling(metric, "file://"+dir, "eu-west-1", "", "", "owner", "project").WithEnrichment(false)
+	if err := a.Query(); err == nil {
+		t.Fatal("expected error without root account ID")
+	}
+
+	a = NewAWSBilling(metric, "file://"+dir, "eu-west-1", "12340002", "", "owner", "project").WithEnrichment(false).WithRangedDownloads(64, 2)
+	if err := a.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if act, exp := testutil.ToFloat64(metric.WithLabelValues("aws", "USD", "12340003", "AmazonS3", "", "", "", "")), 0.01; act != exp {
+		t.Errorf("unexpected costs: %f (expected: %f)", act, exp)
+	}
+	for _, r := range a.Records() {
+		if r.Month != "2017-04" {
+			t.Errorf("unexpected month of the latest report: %+v", r)
+		}
+	}
+	if len(a.Permissions()) != 0 {
+		t.Errorf("unexpected permissions of local reports: %+v", a.Permissions())
+	}
+}
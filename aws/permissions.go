@@ -0,0 +1,221 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sts"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+// athenaOutputActions are required on the bucket of the Athena query
+// results, athenaOutputObjectActions on the objects below the output location
+var (
+	athenaOutputActions       = []string{"s3:GetBucketLocation", "s3:ListBucket", "s3:ListBucketMultipartUploads"}
+	athenaOutputObjectActions = []string{"s3:GetObject", "s3:PutObject", "s3:ListMultipartUploadParts", "s3:AbortMultipartUpload"}
+)
+
+// Permissions returns the permissions required by the API calls of the
+// collector, with checks making these calls
+func (a *AWSBilling) Permissions() []billing.Permission {
+	var permissions []billing.Permission
+	add := func(action, resource string, check func(context.Context) error) {
+		permissions = append(permissions, billing.Permission{
+			Cloud:    "aws",
+			Action:   action,
+			Resource: resource,
+			Check:    check,
+		})
+	}
+
+	// local reports are read without any AWS API calls
+	local := a.localReports()
+	if !local {
+		if a.roleARN != "" {
+			add("sts:AssumeRole", a.roleARN, a.CheckCredentials)
+		}
+		add("sts:GetCallerIdentity", "*", a.CheckCredentials)
+	}
+
+	if a.athena != nil {
+		query := billing.CheckOnce(a.athena.check)
+		workgroup := a.athena.workgroup
+		if workgroup == "" {
+			workgroup = "primary"
+		}
+		for _, action := range []string{"athena:StartQueryExecution", "athena:GetQueryExecution", "athena:GetQueryResults"} {
+			add(action, fmt.Sprintf("arn:aws:athena:%s:*:workgroup/%s", a.Region, workgroup), query)
+		}
+		for _, resource := range []string{
+			fmt.Sprintf("arn:aws:glue:%s:*:catalog", a.Region),
+			fmt.Sprintf("arn:aws:glue:%s:*:database/%s", a.Region, a.athena.database),
+			fmt.Sprintf("arn:aws:glue:%s:*:table/%s/%s", a.Region, a.athena.database, a.athena.table),
+		} {
+			for _, action := range []string{"glue:GetDatabase", "glue:GetTable", "glue:GetPartitions"} {
+				add(action, resource, query)
+			}
+		}
+		if u, err := url.Parse(a.athena.outputLocation); err == nil && u.Host != "" {
+			for _, action := range athenaOutputActions {
+				add(action, fmt.Sprintf("arn:aws:s3:::%s", u.Host), query)
+			}
+			for _, action := range athenaOutputObjectActions {
+				add(action, fmt.Sprintf("arn:aws:s3:::%s/%s*", u.Host, strings.TrimPrefix(u.Path, "/")), query)
+			}
+		}
+		if a.BucketName != "" {
+			add("s3:GetBucketLocation", fmt.Sprintf("arn:aws:s3:::%s", a.BucketName), query)
+			add("s3:ListBucket", fmt.Sprintf("arn:aws:s3:::%s", a.BucketName), query)
+			add("s3:GetObject", fmt.Sprintf("arn:aws:s3:::%s/*", a.BucketName), query)
+		}
+	} else if !local {
+		add("s3:ListBucket", fmt.Sprintf("arn:aws:s3:::%s", a.BucketName), a.checkListReports)
+		add("s3:GetObject", fmt.Sprintf("arn:aws:s3:::%s/*", a.BucketName), a.checkGetReport)
+	}
+
+	// the accounts manifest is synced out of band
+	if !a.disableEnrichment && a.accountsManifest == "" {
+		add("organizations:ListAccounts", "*", a.checkListAccounts)
+		if a.ProjectIDTag != "" || !a.disableOwner {
+			add("organizations:ListTagsForResource", "*", a.checkListTags)
+		}
+		if !a.disablePath {
+			add("organizations:ListParents", "*", a.checkListParents)
+			add("organizations:DescribeOrganization", "*", a.checkDescribeOrganization)
+			// only called for accounts in organizational units
+			add("organizations:DescribeOrganizationalUnit", "*", nil)
+		}
+	}
+
+	return permissions
+}
+
+// check runs a query reading no rows from the table
+func (q *athenaQuery) check(ctx context.Context) error {
+	id, err := q.execute(ctx, fmt.Sprintf(`SELECT * FROM "%s"."%s" LIMIT 0`, q.database, q.table))
+	if err != nil {
+		return err
+	}
+	return q.svc.GetQueryResultsPagesWithContext(ctx, &athena.GetQueryResultsInput{QueryExecutionId: id}, func(*athena.GetQueryResultsOutput, bool) bool {
+		return false
+	})
+}
+
+// firstReport returns the key of a report in the bucket
+func (a *AWSBilling) firstReport(ctx context.Context) (*string, error) {
+	svc, err := a.reportBucket()
+	if err != nil {
+		return nil, err
+	}
+	var key *string
+	if err := svc.ListObjectsPagesWithContext(ctx, &s3.ListObjectsInput{
+		Bucket:       aws.String(a.BucketName),
+		MaxKeys:      aws.Int64(1),
+		RequestPayer: a.requestPayer(),
+	}, func(resp *s3.ListObjectsOutput, _ bool) bool {
+		if len(resp.Contents) > 0 {
+			key = resp.Contents[0].Key
+		}
+		return false
+	}); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (a *AWSBilling) checkListReports(ctx context.Context) error {
+	_, err := a.firstReport(ctx)
+	return err
+}
+
+func (a *AWSBilling) checkGetReport(ctx context.Context) error {
+	key, err := a.firstReport(ctx)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		return fmt.Errorf("no report found in bucket '%s'", a.BucketName)
+	}
+	svc, err := a.reportBucket()
+	if err != nil {
+		return err
+	}
+	resp, err := svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket:       aws.String(a.BucketName),
+		Key:          key,
+		Range:        aws.String("bytes=0-0"),
+		RequestPayer: a.requestPayer(),
+	})
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	return nil
+}
+
+func (a *AWSBilling) checkListAccounts(ctx context.Context) error {
+	svc, err := a.organizationsReader()
+	if err != nil {
+		return err
+	}
+	return svc.ListAccountsPagesWithContext(ctx, &organizations.ListAccountsInput{MaxResults: aws.Int64(1)}, func(*organizations.ListAccountsOutput, bool) bool {
+		return false
+	})
+}
+
+// callerAccount returns the account of the credentials, whose tags and
+// parents are looked up
+func (a *AWSBilling) callerAccount(ctx context.Context) (string, error) {
+	svc, err := a.identityReader()
+	if err != nil {
+		return "", err
+	}
+	ci, err := svc.GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(ci.Account), nil
+}
+
+func (a *AWSBilling) checkListTags(ctx context.Context) error {
+	account, err := a.callerAccount(ctx)
+	if err != nil {
+		return err
+	}
+	svc, err := a.organizationsReader()
+	if err != nil {
+		return err
+	}
+	return svc.ListTagsForResourcePagesWithContext(ctx, &organizations.ListTagsForResourceInput{ResourceId: aws.String(account)}, func(*organizations.ListTagsForResourceOutput, bool) bool {
+		return false
+	})
+}
+
+func (a *AWSBilling) checkListParents(ctx context.Context) error {
+	account, err := a.callerAccount(ctx)
+	if err != nil {
+		return err
+	}
+	svc, err := a.organizationsReader()
+	if err != nil {
+		return err
+	}
+	_, err = svc.ListParentsWithContext(ctx, &organizations.ListParentsInput{ChildId: aws.String(account)})
+	return err
+}
+
+func (a *AWSBilling) checkDescribeOrganization(ctx context.Context) error {
+	svc, err := a.organizationsReader()
+	if err != nil {
+		return err
+	}
+	_, err = svc.DescribeOrganizationWithContext(ctx, &organizations.DescribeOrganizationInput{})
+	return err
+}
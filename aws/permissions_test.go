@@ -0,0 +1,53 @@
+package aws
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+	"github.com/simonswine/cloud-billing-exporter/fake"
+)
+
+func TestPermissions(t *testing.T) {
+	metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+	a := NewAWSBilling(metric, "billing", "eu-west-1", "", "", "owner", "project").WithClients(Clients{
+		Reports:  &fake.S3{Objects: map[string]string{"12340002-aws-billing-csv-2017-04.csv": fakeReport}},
+		Identity: &fake.STS{Account: "12340002"},
+		Organizations: &fake.Organizations{
+			MasterAccountEmail: "aws@example.com",
+			Accounts:           []*organizations.Account{{Id: aws.String("12340001"), Name: aws.String("acme-dev")}},
+			Parents:            map[string]string{"12340002": "r-1234"},
+		},
+	})
+
+	actions := map[string]string{}
+	for _, p := range a.Permissions() {
+		actions[p.Action] = p.Resource
+		if p.Check == nil {
+			continue
+		}
+		if err := p.Check(context.Background()); err != nil {
+			t.Errorf("unexpected error checking %s: %s", p.Action, err)
+		}
+	}
+	for action, resource := range map[string]string{
+		"s3:ListBucket":              "arn:aws:s3:::billing",
+		"s3:GetObject":               "arn:aws:s3:::billing/*",
+		"organizations:ListAccounts": "*",
+	} {
+		if act := actions[action]; act != resource {
+			t.Errorf("unexpected resource of %s: '%s' (expected: '%s')", action, act, resource)
+		}
+	}
+
+	// no organizations permissions without enrichment
+	for _, p := range a.WithEnrichment(false).Permissions() {
+		if p.Action == "organizations:ListAccounts" {
+			t.Error("unexpected organizations permission without enrichment")
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/pricing"
+	"github.com/aws/aws-sdk-go/service/pricing/pricingiface"
+	"github.com/prometheus/common/log"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+// pricingRegion is the region of the Price List API endpoint
+const pricingRegion = "us-east-1"
+
+// ec2Product is the part of a Price List API product used for the on demand
+// prices of instances
+type ec2Product struct {
+	Product struct {
+		SKU        string `json:"sku"`
+		Attributes struct {
+			InstanceType string `json:"instanceType"`
+			RegionCode   string `json:"regionCode"`
+		} `json:"attributes"`
+	} `json:"product"`
+	Terms struct {
+		OnDemand map[string]struct {
+			PriceDimensions map[string]struct {
+				Unit         string            `json:"unit"`
+				BeginRange   string            `json:"beginRange"`
+				PricePerUnit map[string]string `json:"pricePerUnit"`
+			} `json:"priceDimensions"`
+		} `json:"OnDemand"`
+	} `json:"terms"`
+}
+
+// PricingCatalog looks up the on demand list prices of Linux EC2 instances in
+// the Price List API
+type PricingCatalog struct {
+	svc           pricingiface.PricingAPI
+	instanceTypes []string
+	regions       []string
+}
+
+func NewPricingCatalog(instanceTypes, regions []string) (*PricingCatalog, error) {
+	if len(instanceTypes) == 0 || len(regions) == 0 {
+		return nil, fmt.Errorf("instance types and regions are required")
+	}
+
+	a := &AWSBilling{Region: pricingRegion}
+	session, err := a.awsSession()
+	if err != nil {
+		return nil, err
+	}
+	return &PricingCatalog{
+		svc:           pricing.New(session, a.awsConfig()),
+		instanceTypes: instanceTypes,
+		regions:       regions,
+	}, nil
+}
+
+func termMatch(field, value string) *pricing.Filter {
+	return &pricing.Filter{
+		Type:  aws.String(pricing.FilterTypeTermMatch),
+		Field: aws.String(field),
+		Value: aws.String(value),
+	}
+}
+
+// ListPrices returns the on demand prices of all instance types in all
+// regions
+func (p *PricingCatalog) ListPrices(ctx context.Context) ([]billing.ListPrice, error) {
+	var prices []billing.ListPrice
+	for _, region := range p.regions {
+		for _, instanceType := range p.instanceTypes {
+			input := &pricing.GetProductsInput{
+				ServiceCode: aws.String("AmazonEC2"),
+				Filters: []*pricing.Filter{
+					termMatch("instanceType", instanceType),
+					termMatch("regionCode", region),
+					termMatch("operatingSystem", "Linux"),
+					termMatch("tenancy", "Shared"),
+					termMatch("preInstalledSw", "NA"),
+					termMatch("capacitystatus", "Used"),
+				},
+			}
+
+			found := false
+			var parseErr error
+			if err := p.svc.GetProductsPagesWithContext(ctx, input, func(resp *pricing.GetProductsOutput, _ bool) bool {
+				for _, item := range resp.PriceList {
+					itemPrices, err := parseEC2Product(item)
+					if err != nil {
+						parseErr = err
+						return false
+					}
+					found = found || len(itemPrices) > 0
+					prices = append(prices, itemPrices...)
+				}
+				return true
+			}); err != nil {
+				return nil, fmt.Errorf("error getting prices of %s in %s: %s", instanceType, region, err)
+			}
+			if parseErr != nil {
+				return nil, fmt.Errorf("error parsing prices of %s in %s: %s", instanceType, region, parseErr)
+			}
+			if !found {
+				log.Warnf("no on demand price found for %s in %s", instanceType, region)
+			}
+		}
+	}
+	return prices, nil
+}
+
+func parseEC2Product(item aws.JSONValue) ([]billing.ListPrice, error) {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+	var product ec2Product
+	if err := json.Unmarshal(data, &product); err != nil {
+		return nil, err
+	}
+
+	var prices []billing.ListPrice
+	for _, term := range product.Terms.OnDemand {
+		for _, dimension := range term.PriceDimensions {
+			for currency, value := range dimension.PricePerUnit {
+				price, err := strconv.ParseFloat(value, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid price '%s': %s", value, err)
+				}
+				prices = append(prices, billing.ListPrice{
+					Cloud:       "aws",
+					Service:     "AmazonEC2",
+					SKU:         product.Product.SKU,
+					Description: product.Product.Attributes.InstanceType,
+					Region:      product.Product.Attributes.RegionCode,
+					Unit:        dimension.Unit,
+					Currency:    currency,
+					TierStart:   dimension.BeginRange,
+					Price:       price,
+				})
+			}
+		}
+	}
+	sort.Slice(prices, func(i, j int) bool {
+		return prices[i].TierStart < prices[j].TierStart
+	})
+	return prices, nil
+}
+
+func (p *PricingCatalog) String() string {
+	return "AWS Price List API"
+}
@@ -0,0 +1,78 @@
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/pricing"
+	"github.com/aws/aws-sdk-go/service/pricing/pricingiface"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+type fakePricing struct {
+	pricingiface.PricingAPI
+
+	inputs []*pricing.GetProductsInput
+}
+
+const m5LargeProduct = `{
+  "product": {"sku": "XJ7VP6NE6CBYRWJ7", "attributes": {"instanceType": "m5.large", "regionCode": "eu-west-1", "operatingSystem": "Linux"}},
+  "terms": {"OnDemand": {"XJ7VP6NE6CBYRWJ7.JRTCKXETXF": {"priceDimensions": {"XJ7VP6NE6CBYRWJ7.JRTCKXETXF.6YS6EN2CT7": {
+    "unit": "Hrs", "beginRange": "0", "endRange": "Inf", "pricePerUnit": {"USD": "0.1070000000"}
+  }}}}}
+}`
+
+func (f *fakePricing) GetProductsPagesWithContext(_ aws.Context, input *pricing.GetProductsInput, fn func(*pricing.GetProductsOutput, bool) bool, _ ...request.Option) error {
+	f.inputs = append(f.inputs, input)
+	var item aws.JSONValue
+	if err := json.Unmarshal([]byte(m5LargeProduct), &item); err != nil {
+		return err
+	}
+	fn(&pricing.GetProductsOutput{PriceList: []aws.JSONValue{item}}, true)
+	return nil
+}
+
+func TestPricingCatalog(t *testing.T) {
+	fake := &fakePricing{}
+	p := &PricingCatalog{
+		svc:           fake,
+		instanceTypes: []string{"m5.large"},
+		regions:       []string{"eu-west-1"},
+	}
+
+	prices, err := p.ListPrices(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	exp := []billing.ListPrice{{
+		Cloud:       "aws",
+		Service:     "AmazonEC2",
+		SKU:         "XJ7VP6NE6CBYRWJ7",
+		Description: "m5.large",
+		Region:      "eu-west-1",
+		Unit:        "Hrs",
+		Currency:    "USD",
+		TierStart:   "0",
+		Price:       0.107,
+	}}
+	if !reflect.DeepEqual(prices, exp) {
+		t.Errorf("unexpected prices: act: %+v, exp: %+v", prices, exp)
+	}
+
+	if len(fake.inputs) != 1 {
+		t.Fatalf("unexpected number of requests: %d", len(fake.inputs))
+	}
+	filters := make(map[string]string)
+	for _, f := range fake.inputs[0].Filters {
+		filters[*f.Field] = *f.Value
+	}
+	if filters["instanceType"] != "m5.large" || filters["regionCode"] != "eu-west-1" || filters["operatingSystem"] != "Linux" {
+		t.Errorf("unexpected filters: %+v", filters)
+	}
+}
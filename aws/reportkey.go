@@ -0,0 +1,93 @@
+package aws
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DefaultReportKeyPattern matches the keys of the monthly cost allocation
+// reports written by the detailed billing report feature
+const DefaultReportKeyPattern = "{account}-aws-billing-csv-{month}"
+
+// reportKeyPlaceholder matches the placeholders of a report key pattern
+var reportKeyPlaceholder = regexp.MustCompile(`\{[a-z]+\}|\*`)
+
+// reportKeyPattern matches the keys of the reports of an account, the
+// placeholders {account}, {month} (2019-11), {year} (2019) and {mm} (11) are
+// replaced and * matches any characters but /. The key continues with one
+// of the report extensions.
+type reportKeyPattern struct {
+	pattern string
+}
+
+func parseReportKeyPattern(pattern string) (*reportKeyPattern, error) {
+	found := make(map[string]bool)
+	for _, placeholder := range reportKeyPlaceholder.FindAllString(pattern, -1) {
+		switch placeholder {
+		case "{account}", "{month}", "{year}", "{mm}", "*":
+			found[placeholder] = true
+		default:
+			return nil, fmt.Errorf("unknown placeholder %s in report key pattern '%s'", placeholder, pattern)
+		}
+	}
+	if !found["{month}"] && !(found["{year}"] && found["{mm}"]) {
+		return nil, fmt.Errorf("report key pattern '%s' needs to contain {month} or {year} and {mm}", pattern)
+	}
+	return &reportKeyPattern{pattern: pattern}, nil
+}
+
+// compile returns the prefix of the reports of the account, the keys are
+// listed with, and the expression matching their keys
+func (p *reportKeyPattern) compile(account string) (string, *regexp.Regexp) {
+	pattern := strings.Replace(p.pattern, "{account}", account, -1)
+
+	prefix := pattern
+	if loc := reportKeyPlaceholder.FindStringIndex(pattern); loc != nil {
+		prefix = pattern[:loc[0]]
+	}
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	last := 0
+	for _, loc := range reportKeyPlaceholder.FindAllStringIndex(pattern, -1) {
+		expr.WriteString(regexp.QuoteMeta(pattern[last:loc[0]]))
+		switch pattern[loc[0]:loc[1]] {
+		case "{month}":
+			expr.WriteString(`(?P<year>\d{4})-(?P<mm>\d{2})`)
+		case "{year}":
+			expr.WriteString(`(?P<year>\d{4})`)
+		case "{mm}":
+			expr.WriteString(`(?P<mm>\d{2})`)
+		case "*":
+			expr.WriteString(`[^/]*`)
+		}
+		last = loc[1]
+	}
+	expr.WriteString(regexp.QuoteMeta(pattern[last:]))
+	extensions := make([]string, len(reportExtensions))
+	for i, ext := range reportExtensions {
+		extensions[i] = regexp.QuoteMeta(ext)
+	}
+	expr.WriteString("(" + strings.Join(extensions, "|") + ")$")
+	return prefix, regexp.MustCompile(expr.String())
+}
+
+// reportMonth returns the month of a report from its key, e.g. 2019-11 for
+// 1234-aws-billing-csv-2019-11.csv.gz, it is empty if the key doesn't match
+func reportMonth(key string, expr *regexp.Regexp) string {
+	match := expr.FindStringSubmatch(key)
+	if match == nil {
+		return ""
+	}
+	var year, mm string
+	for i, name := range expr.SubexpNames() {
+		switch name {
+		case "year":
+			year = match[i]
+		case "mm":
+			mm = match[i]
+		}
+	}
+	return year + "-" + mm
+}
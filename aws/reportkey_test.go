@@ -0,0 +1,103 @@
+package aws
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+	"github.com/simonswine/cloud-billing-exporter/fake"
+)
+
+func TestReportMonth(t *testing.T) {
+	for pattern, cases := range map[string]map[string]string{
+		DefaultReportKeyPattern: {
+			"1234-aws-billing-csv-2019-11.csv":         "2019-11",
+			"1234-aws-billing-csv-2019-11.csv.gz":      "2019-11",
+			"1234-aws-billing-csv-2019-11.csv.zst":     "2019-11",
+			"1234-aws-cost-allocation-2019-11.csv":     "",
+			"1234-aws-billing-csv-2019-11.json":        "",
+			"5678-aws-billing-csv-2019-11.csv":         "",
+			"billing/1234-aws-billing-csv-2019-11.csv": "",
+		},
+		"reports/{account}/{year}/{mm}/*": {
+			"reports/1234/2019/11/costs.csv":    "2019-11",
+			"reports/1234/2019/11/costs.csv.gz": "2019-11",
+			"reports/1234/2019/11/a/costs.csv":  "",
+			"reports/1234/2019/costs.csv":       "",
+		},
+		"{account}-aws-cost-allocation-{month}": {
+			"1234-aws-cost-allocation-2019-11.csv": "2019-11",
+			"1234-aws-billing-csv-2019-11.csv":     "",
+		},
+	} {
+		p, err := parseReportKeyPattern(pattern)
+		if err != nil {
+			t.Fatalf("unexpected error parsing %s: %s", pattern, err)
+		}
+		_, expr := p.compile("1234")
+		for key, exp := range cases {
+			if act := reportMonth(key, expr); act != exp {
+				t.Errorf("unexpected month of %s matching %s: '%s' (expected: '%s')", key, pattern, act, exp)
+			}
+		}
+	}
+}
+
+func TestReportKeyPrefix(t *testing.T) {
+	for pattern, exp := range map[string]string{
+		DefaultReportKeyPattern:           "1234-aws-billing-csv-",
+		"reports/{account}/{year}/{mm}/*": "reports/1234/",
+		"reports/*/{account}-{month}":     "reports/",
+	} {
+		p, err := parseReportKeyPattern(pattern)
+		if err != nil {
+			t.Fatalf("unexpected error parsing %s: %s", pattern, err)
+		}
+		if act, _ := p.compile("1234"); act != exp {
+			t.Errorf("unexpected prefix of %s: '%s' (expected: '%s')", pattern, act, exp)
+		}
+	}
+}
+
+func TestParseReportKeyPatternErrors(t *testing.T) {
+	for _, pattern := range []string{
+		"{account}-aws-billing-csv-",
+		"{account}-{year}",
+		"{account}-{date}-{month}",
+	} {
+		if _, err := parseReportKeyPattern(pattern); err == nil {
+			t.Errorf("expected error parsing %s", pattern)
+		}
+	}
+}
+
+func TestQueryReportKeyPattern(t *testing.T) {
+	reports := &fake.S3{Objects: map[string]string{
+		"cur/12340002/2017/03/report.csv":      fakeReport,
+		"cur/12340002/2017/04/report.csv":      fakeReport,
+		"cur/12340002/2017/05/report.json":     fakeReport,
+		"12340002-aws-billing-csv-2017-06.csv": fakeReport,
+	}}
+	metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+	a, err := NewAWSBilling(metric, "billing", "eu-west-1", "12340002", "", "owner", "project").WithClients(Clients{
+		Reports:       reports,
+		Organizations: &fake.Organizations{},
+	}).WithReportKeyPattern("cur/{account}/{year}/{mm}/report")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := a.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	records := a.Records()
+	if len(records) == 0 {
+		t.Fatal("expected records of the renamed report")
+	}
+	for _, r := range records {
+		if r.Month != "2017-04" {
+			t.Errorf("unexpected month of the latest report: %+v", r)
+		}
+	}
+}
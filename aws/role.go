@@ -0,0 +1,38 @@
+package aws
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// roleSessionName identifies the sessions of the assumed role in CloudTrail
+const roleSessionName = "cloud-billing-exporter"
+
+// WithRole assumes the role before calling the AWS APIs, e.g. a role in the
+// billing account, if the exporter runs in another account. The external ID
+// is passed along if set. The credentials of the role are refreshed before
+// they expire.
+func (a *AWSBilling) WithRole(roleARN, externalID string) *AWSBilling {
+	a.roleARN = roleARN
+	a.roleExternalID = externalID
+	return a
+}
+
+// roleCredentials returns the credentials of the role, which are shared by
+// all sessions, so the role is only assumed again once they expire
+func (a *AWSBilling) roleCredentials(s *session.Session) *credentials.Credentials {
+	a.roleLock.Lock()
+	defer a.roleLock.Unlock()
+	if a.roleCreds == nil {
+		a.roleCreds = stscreds.NewCredentialsWithClient(sts.New(s, a.stsConfig()), a.roleARN, func(p *stscreds.AssumeRoleProvider) {
+			p.RoleSessionName = roleSessionName
+			if a.roleExternalID != "" {
+				p.ExternalID = aws.String(a.roleExternalID)
+			}
+		})
+	}
+	return a.roleCreds
+}
@@ -0,0 +1,70 @@
+package aws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestRole(t *testing.T) {
+	var lock sync.Mutex
+	var requests []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		lock.Lock()
+		requests = append(requests, r.PostForm)
+		lock.Unlock()
+		_, _ = w.Write([]byte(`<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+<AssumeRoleResult><Credentials>
+<AccessKeyId>ASIAROLE</AccessKeyId><SecretAccessKey>role-secret</SecretAccessKey><SessionToken>token</SessionToken>
+<Expiration>2099-01-01T00:00:00Z</Expiration>
+</Credentials></AssumeRoleResult>
+</AssumeRoleResponse>`))
+	}))
+	defer server.Close()
+
+	for key, value := range map[string]string{"AWS_ACCESS_KEY_ID": "AKIDEXAMPLE", "AWS_SECRET_ACCESS_KEY": "secret"} {
+		previous, ok := os.LookupEnv(key)
+		os.Setenv(key, value)
+		if ok {
+			defer os.Setenv(key, previous)
+		} else {
+			defer os.Unsetenv(key)
+		}
+	}
+
+	a := NewAWSBilling(nil, "billing", "eu-west-1", "", "", "owner", "project").WithSTSEndpoint(false, server.URL).WithRole("arn:aws:iam::12340002:role/billing-reader", "acme")
+	for i := 0; i < 2; i++ {
+		session, err := a.awsSession()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		creds, err := session.Config.Credentials.Get()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if creds.AccessKeyID != "ASIAROLE" || creds.SessionToken != "token" {
+			t.Errorf("unexpected credentials: %+v", creds)
+		}
+	}
+
+	// the credentials are shared by the sessions until they expire
+	if len(requests) != 1 {
+		t.Fatalf("unexpected number of AssumeRole calls: %d (expected: 1)", len(requests))
+	}
+	for key, exp := range map[string]string{
+		"Action":          "AssumeRole",
+		"RoleArn":         "arn:aws:iam::12340002:role/billing-reader",
+		"RoleSessionName": roleSessionName,
+		"ExternalId":      "acme",
+	} {
+		if act := requests[0].Get(key); act != exp {
+			t.Errorf("unexpected %s: %s (expected: %s)", key, act, exp)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package aws
+
+// WithTags looks up the values of the account tags with the given keys, which
+// are mapped to labels
+func (a *AWSBilling) WithTags(keys []string) *AWSBilling {
+	a.tagKeys = keys
+	return a
+}
+
+// AccountTags returns the values of the mapped tags by the name of the
+// accounts in the organization
+func (a *AWSBilling) AccountTags() map[string]map[string]string {
+	a.accountNameByIDAPILock.Lock()
+	defer a.accountNameByIDAPILock.Unlock()
+
+	tags := make(map[string]map[string]string)
+	for _, account := range a.accountNameByIDAPI {
+		if len(account.Tags) > 0 {
+			tags[string(account.Name)] = account.Tags
+		}
+	}
+	return tags
+}
@@ -0,0 +1,37 @@
+package aws
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+	"github.com/simonswine/cloud-billing-exporter/fake"
+)
+
+func TestAccountTags(t *testing.T) {
+	metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+	a := NewAWSBilling(metric, "billing", "eu-west-1", "12340002", "", "owner", "project").WithClients(Clients{
+		Reports: &fake.S3{Objects: map[string]string{"12340002-aws-billing-csv-2017-04.csv": fakeReport}},
+		Organizations: &fake.Organizations{
+			Accounts: []*organizations.Account{
+				{Id: aws.String("12340001"), Name: aws.String("acme-dev")},
+				{Id: aws.String("12340003"), Name: aws.String("acme-sandbox")},
+			},
+			Tags: map[string]map[string]string{
+				"12340001": {"owner": "jane", "team": "platform", "env": "dev", "ignored": "x"},
+			},
+		},
+	}).WithOwnerAndPath(false, false).WithTags([]string{"team", "env", "cost-centre"})
+
+	if err := a.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	exp := map[string]map[string]string{"acme-dev": {"team": "platform", "env": "dev"}}
+	if act := a.AccountTags(); !reflect.DeepEqual(act, exp) {
+		t.Errorf("unexpected account tags: %+v (expected: %+v)", act, exp)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build cgo
+// +build cgo
+
+package aws
+
+import (
+	"io"
+
+	"github.com/DataDog/zstd"
+)
+
+// newZstdReader decompresses a zstd stream
+func newZstdReader(r io.Reader) (io.ReadCloser, error) {
+	return zstd.NewReader(r), nil
+}
@@ -0,0 +1,15 @@
+//go:build !cgo
+// +build !cgo
+
+package aws
+
+import (
+	"fmt"
+	"io"
+)
+
+// newZstdReader is not supported without cgo, as the zstd library wraps the
+// C implementation
+func newZstdReader(r io.Reader) (io.ReadCloser, error) {
+	return nil, fmt.Errorf("zstd compressed reports require a build with cgo")
+}
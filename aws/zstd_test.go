@@ -0,0 +1,32 @@
+//go:build cgo
+// +build cgo
+
+package aws
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/DataDog/zstd"
+)
+
+func TestDecompressZstd(t *testing.T) {
+	compressed, err := zstd.Compress(nil, []byte("a,b\n1,2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := decompress(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer r.Close()
+	act, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if exp := "a,b\n1,2\n"; string(act) != exp {
+		t.Errorf("unexpected content: %q (expected: %q)", act, exp)
+	}
+}
@@ -0,0 +1,495 @@
+// Package azure collects costs from Azure Cost Management exports, which are
+// scheduled to write CSV files into a Storage Account container.
+//
+// Every run of an export writes the costs of the whole billing period to
+// date below <directory>/<export>/<YYYYMMDD-YYYYMMDD>/, so only the latest
+// run of each export and period is read.
+package azure
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+	"github.com/simonswine/cloud-billing-exporter/objstore"
+	"github.com/simonswine/cloud-billing-exporter/state"
+)
+
+// container lists and reads the exported files
+type container interface {
+	objstore.Bucket
+	objstore.Lister
+}
+
+// periodDir matches the directory of the billing period of an export, e.g.
+// 20191101-20191130
+var periodDir = regexp.MustCompile(`(^|/)(\d{4})(\d{2})\d{2}-\d{8}/`)
+
+// run contains the aggregated costs of the latest run of an export
+type run struct {
+	hash      string
+	month     string
+	records   map[string]*billing.Record
+	lineItems map[string]*billing.LineItem
+}
+
+// AzureBilling reads the Cost Management exports from a container, only the
+// latest billing period is exposed
+type AzureBilling struct {
+	container container
+
+	lock sync.Mutex
+	// runs are the parsed runs by the period directory of their export
+	runs map[string]*run
+
+	records     []billing.Record
+	lineItems   []billing.LineItem
+	recordsLock sync.Mutex
+
+	MetricMonthlyCosts *prometheus.CounterVec
+	metricRefunds      *prometheus.CounterVec
+	onDecrease         billing.OnDecrease
+	metricValues       map[string]state.Baseline
+
+	stateStore    state.Store
+	stateRestored bool
+}
+
+// azureBillingState is the part of AzureBilling persisted in the state store
+type azureBillingState struct {
+	Baselines map[string]state.Baseline
+}
+
+// NewAzureBilling reads the exports below the prefix of the container URL,
+// e.g. azblob://account/container/exports
+func NewAzureBilling(ctx context.Context, metric *prometheus.CounterVec, containerURL string) (*AzureBilling, error) {
+	b, err := objstore.Parse(ctx, containerURL)
+	if err != nil {
+		return nil, err
+	}
+	c, ok := b.(container)
+	if !ok {
+		return nil, fmt.Errorf("listing objects is not supported by %s", b)
+	}
+	return newAzureBilling(metric, c), nil
+}
+
+func newAzureBilling(metric *prometheus.CounterVec, c container) *AzureBilling {
+	return &AzureBilling{
+		container:          c,
+		runs:               make(map[string]*run),
+		MetricMonthlyCosts: metric,
+		metricValues:       make(map[string]state.Baseline),
+	}
+}
+
+// WithStateStore enables persisting the collector state in the given store
+func (a *AzureBilling) WithStateStore(s state.Store) *AzureBilling {
+	a.stateStore = s
+	return a
+}
+
+// WithOnDecrease selects how falling costs are handled, the refunds counter
+// is used by billing.OnDecreaseRefundMetric
+func (a *AzureBilling) WithOnDecrease(mode billing.OnDecrease, refunds *prometheus.CounterVec) *AzureBilling {
+	a.onDecrease = mode
+	a.metricRefunds = refunds
+	return a
+}
+
+func (a *AzureBilling) stateKey() string {
+	return "azure"
+}
+
+// restoreState loads the persisted baselines once, before the first export
+// gets parsed
+func (a *AzureBilling) restoreState(ctx context.Context) error {
+	if a.stateStore == nil || a.stateRestored {
+		return nil
+	}
+
+	var s azureBillingState
+	if err := state.Load(ctx, a.stateStore, a.stateKey(), &s); err == state.ErrNotFound {
+		log.Debugf("no previous state for '%s' found in %s", a.stateKey(), a.stateStore)
+	} else if err != nil {
+		return fmt.Errorf("error restoring state from %s: %s", a.stateStore, err)
+	}
+
+	for key, baseline := range s.Baselines {
+		if _, err := a.MetricMonthlyCosts.GetMetricWithLabelValues(baseline.Labels...); err != nil {
+			log.Warnf("dropping baseline '%s' restored from %s: %s", key, a.stateStore, err)
+			continue
+		}
+		a.metricValues[key] = baseline
+	}
+	a.stateRestored = true
+	return nil
+}
+
+func (a *AzureBilling) saveState(ctx context.Context) {
+	if a.stateStore == nil {
+		return
+	}
+
+	if err := state.Save(ctx, a.stateStore, a.stateKey(), &azureBillingState{
+		Baselines: a.metricValues,
+	}); err != nil {
+		log.Warnf("error persisting state to %s: %s", a.stateStore, err)
+	}
+}
+
+// columns are the candidate names of the columns used, which differ between
+// the export schemas of the agreement types, e.g. PreTaxCost of the legacy
+// usage details and CostInBillingCurrency of EA and MCA exports
+var columns = []struct {
+	name       string
+	candidates []string
+	required   bool
+}{
+	{"cost", []string{"costinbillingcurrency", "pretaxcost", "cost"}, true},
+	{"currency", []string{"billingcurrency", "billingcurrencycode", "currency"}, true},
+	{"service", []string{"metercategory", "servicename", "consumedservice"}, true},
+	{"account", []string{"subscriptionname", "subscriptionid", "subscriptionguid"}, true},
+	{"date", []string{"date", "usagedatetime", "usagedate"}, false},
+}
+
+// parseDate returns the date of a row as YYYY-MM-DD, the exports use either
+// MM/DD/YYYY or ISO 8601 dates
+func parseDate(s string) (string, bool) {
+	if t, err := time.Parse("01/02/2006", s); err == nil {
+		return t.Format("2006-01-02"), true
+	}
+	if len(s) >= 10 {
+		if t, err := time.Parse("2006-01-02", s[:10]); err == nil {
+			return t.Format("2006-01-02"), true
+		}
+	}
+	return "", false
+}
+
+// readCSV adds the costs of an exported file per subscription, service and
+// currency to the run
+func (r *run) readCSV(input io.Reader) error {
+	cr := csv.NewReader(input)
+	cr.ReuseRecord = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return fmt.Errorf("error reading header: %s", err)
+	}
+	names := make(map[string]int)
+	for i, name := range header {
+		// exports can start with a byte order mark
+		names[strings.ToLower(strings.TrimPrefix(name, "\ufeff"))] = i
+	}
+	pos := make(map[string]int)
+	for _, c := range columns {
+		for _, name := range c.candidates {
+			if i, ok := names[name]; ok {
+				pos[c.name] = i
+				break
+			}
+		}
+		if _, ok := pos[c.name]; !ok && c.required {
+			return fmt.Errorf("required column '%s' missing", c.candidates[0])
+		}
+	}
+	value := func(row []string, column string) string {
+		if i, ok := pos[column]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		costs, err := strconv.ParseFloat(value(row, "cost"), 64)
+		if err != nil {
+			log.Warnf("Couldn't parse costs float: %s", err)
+			continue
+		}
+
+		record := billing.Record{
+			Cloud:    "azure",
+			Month:    r.month,
+			Currency: value(row, "currency"),
+			Account:  value(row, "account"),
+			Service:  value(row, "service"),
+		}
+		key := strings.Join([]string{record.Account, record.Service, record.Currency}, "\x00")
+		if _, ok := r.records[key]; !ok {
+			r.records[key] = &record
+		}
+		r.records[key].Costs += costs
+
+		if date, ok := parseDate(value(row, "date")); ok {
+			itemKey := key + "\x00" + date
+			if _, ok := r.lineItems[itemKey]; !ok {
+				r.lineItems[itemKey] = &billing.LineItem{
+					Cloud:    record.Cloud,
+					Month:    record.Month,
+					Date:     date,
+					Currency: record.Currency,
+					Account:  record.Account,
+					Service:  record.Service,
+				}
+			}
+			r.lineItems[itemKey].Costs += costs
+		}
+	}
+}
+
+// readFile downloads and parses an exported file
+func (a *AzureBilling) readFile(ctx context.Context, r *run, name string) error {
+	data, err := a.container.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	var input io.Reader = bytes.NewReader(data)
+	if strings.HasSuffix(name, ".gz") {
+		gz, err := gzip.NewReader(input)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		input = gz
+	}
+	return r.readCSV(input)
+}
+
+// latestRun returns the files of the latest run of an export and period. The
+// files are either written into the period directory or, by partitioned
+// exports, into a directory per run.
+func latestRun(dir string, objects []objstore.Object) []objstore.Object {
+	latest := objects[0]
+	for _, o := range objects[1:] {
+		if o.Modified.After(latest.Modified) || (o.Modified.Equal(latest.Modified) && o.Name > latest.Name) {
+			latest = o
+		}
+	}
+	runDir := path.Dir(latest.Name) + "/"
+	if runDir == dir {
+		return []objstore.Object{latest}
+	}
+	var files []objstore.Object
+	for _, o := range objects {
+		if path.Dir(o.Name)+"/" == runDir {
+			files = append(files, o)
+		}
+	}
+	return files
+}
+
+func (a *AzureBilling) Query() error {
+	ctx := context.Background()
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if err := a.restoreState(ctx); err != nil {
+		return err
+	}
+
+	objects, err := a.container.List(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	// group the exported files by their export and period
+	periods := make(map[string][]objstore.Object)
+	months := make(map[string]string)
+	for _, o := range objects {
+		if !strings.HasSuffix(o.Name, ".csv") && !strings.HasSuffix(o.Name, ".csv.gz") {
+			continue
+		}
+		loc := periodDir.FindStringSubmatchIndex(o.Name)
+		if loc == nil {
+			log.Debugf("skipping '%s' outside of a billing period directory", o.Name)
+			continue
+		}
+		dir := o.Name[:loc[1]]
+		periods[dir] = append(periods[dir], o)
+		months[dir] = o.Name[loc[4]:loc[5]] + "-" + o.Name[loc[6]:loc[7]]
+	}
+
+	runs := make(map[string]*run)
+	for dir, objects := range periods {
+		files := latestRun(dir, objects)
+		hashes := make([]string, len(files))
+		for i, f := range files {
+			hashes[i] = f.Name + "=" + f.Hash
+		}
+		sort.Strings(hashes)
+		hash := strings.Join(hashes, ",")
+		if r, ok := a.runs[dir]; ok && r.hash == hash {
+			runs[dir] = r
+			continue
+		}
+
+		r := &run{
+			hash:      hash,
+			month:     months[dir],
+			records:   make(map[string]*billing.Record),
+			lineItems: make(map[string]*billing.LineItem),
+		}
+		for _, f := range files {
+			if err = a.readFile(ctx, r, f.Name); err != nil {
+				err = fmt.Errorf("failed to read export '%s': %s", f.Name, err)
+				break
+			}
+		}
+		if err != nil {
+			log.Warn(err)
+			// keep the previous run of the export
+			if previous, ok := a.runs[dir]; ok {
+				runs[dir] = previous
+			}
+			continue
+		}
+		log.Debugf("parsed %d files of the export in '%s'", len(files), dir)
+		runs[dir] = r
+	}
+	a.runs = runs
+
+	a.update(ctx)
+	return nil
+}
+
+// update exposes the costs of the latest billing period of all exports, it
+// needs to be called with the lock held
+func (a *AzureBilling) update(ctx context.Context) {
+	var month string
+	for _, r := range a.runs {
+		if len(r.records) > 0 && r.month > month {
+			month = r.month
+		}
+	}
+
+	records := make(map[string]*billing.Record)
+	lineItems := make(map[string]*billing.LineItem)
+	for _, r := range a.runs {
+		if r.month != month {
+			continue
+		}
+		for key, record := range r.records {
+			if _, ok := records[key]; !ok {
+				sum := *record
+				sum.Costs = 0
+				records[key] = &sum
+			}
+			records[key].Costs += record.Costs
+		}
+		for key, i := range r.lineItems {
+			if _, ok := lineItems[key]; !ok {
+				item := *i
+				item.Costs = 0
+				lineItems[key] = &item
+			}
+			lineItems[key].Costs += i.Costs
+		}
+	}
+
+	keys := make([]string, 0, len(records))
+	for key := range records {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	changed := false
+	result := make([]billing.Record, 0, len(records))
+	for _, key := range keys {
+		record := *records[key]
+		result = append(result, record)
+
+		labels := record.Labels()
+		baselineKey := strings.Join(labels, "\x00")
+		previous := a.metricValues[baselineKey].Previous(record.Month)
+		if !a.onDecrease.AddCosts(a.MetricMonthlyCosts, a.metricRefunds, labels, previous, record.Costs) {
+			log.With("account", record.Account).With("service", record.Service).Warnf("costs are falling by: '%f'", record.Costs-previous)
+			continue
+		}
+		if previous, ok := a.metricValues[baselineKey]; !ok || previous.Value != record.Costs || previous.Month != record.Month || !reflect.DeepEqual(previous.Labels, labels) {
+			changed = true
+		}
+		a.metricValues[baselineKey] = state.Baseline{Labels: labels, Value: record.Costs, Month: record.Month}
+	}
+
+	items := make([]billing.LineItem, 0, len(lineItems))
+	for _, i := range lineItems {
+		items = append(items, *i)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if a.Date != b.Date {
+			return a.Date < b.Date
+		}
+		if a.Account != b.Account {
+			return a.Account < b.Account
+		}
+		return a.Service < b.Service
+	})
+
+	a.recordsLock.Lock()
+	a.records = result
+	a.lineItems = items
+	a.recordsLock.Unlock()
+
+	if changed {
+		a.saveState(ctx)
+	}
+}
+
+// Records returns the costs of the latest billing period
+func (a *AzureBilling) Records() []billing.Record {
+	a.recordsLock.Lock()
+	defer a.recordsLock.Unlock()
+	return append([]billing.Record(nil), a.records...)
+}
+
+// LineItems returns the costs per day of the latest billing period
+func (a *AzureBilling) LineItems() []billing.LineItem {
+	a.recordsLock.Lock()
+	defer a.recordsLock.Unlock()
+	return append([]billing.LineItem(nil), a.lineItems...)
+}
+
+// CheckCredentials verifies the credentials by listing the exports
+func (a *AzureBilling) CheckCredentials(ctx context.Context) error {
+	_, err := a.container.List(ctx, "")
+	return err
+}
+
+func (a *AzureBilling) Test() error {
+	return a.Query()
+}
+
+func (a *AzureBilling) String() string {
+	return fmt.Sprintf("Azure cost exports in %s", a.container)
+}
+
+// Cloud returns the name of the cloud, as used in the cloud label
+func (a *AzureBilling) Cloud() string {
+	return "azure"
+}
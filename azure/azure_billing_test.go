@@ -0,0 +1,146 @@
+package azure
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+	"github.com/simonswine/cloud-billing-exporter/objstore"
+)
+
+type blob struct {
+	data     string
+	modified time.Time
+}
+
+type memoryContainer map[string]blob
+
+func (m memoryContainer) Get(_ context.Context, name string) ([]byte, error) {
+	b, ok := m[name]
+	if !ok {
+		return nil, objstore.ErrNotExist
+	}
+	return []byte(b.data), nil
+}
+
+func (m memoryContainer) Put(_ context.Context, name string, data []byte, _ string) error {
+	m[name] = blob{data: string(data), modified: time.Now()}
+	return nil
+}
+
+func (m memoryContainer) List(_ context.Context, prefix string) ([]objstore.Object, error) {
+	var objects []objstore.Object
+	for name, b := range m {
+		if strings.HasPrefix(name, prefix) {
+			objects = append(objects, objstore.Object{Name: name, Hash: b.data, Size: int64(len(b.data)), Modified: b.modified})
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+	return objects, nil
+}
+
+func (m memoryContainer) String() string {
+	return "memory"
+}
+
+const (
+	// eaHeader is the header of the exports of Enterprise Agreements
+	eaHeader = "\ufeffSubscriptionId,SubscriptionName,Date,MeterCategory,CostInBillingCurrency,BillingCurrency\n"
+	// legacyHeader is the header of the usage details of pay-as-you-go
+	// subscriptions
+	legacyHeader = "SubscriptionGuid,ResourceGroup,UsageDateTime,MeterCategory,PreTaxCost,Currency\n"
+)
+
+func day(d int) time.Time {
+	return time.Date(2019, 11, d, 6, 0, 0, 0, time.UTC)
+}
+
+func TestAzureBilling(t *testing.T) {
+	container := memoryContainer{
+		"costs/prod/20191001-20191031/prod_1.csv": {modified: day(1), data: eaHeader +
+			"sub-1,prod,10/31/2019,Virtual Machines,100,EUR\n"},
+		// the runs of the export contain the costs of the month to date
+		"costs/prod/20191101-20191130/prod_1.csv": {modified: day(2), data: eaHeader +
+			"sub-1,prod,11/01/2019,Virtual Machines,10,EUR\n"},
+		"costs/prod/20191101-20191130/prod_2.csv": {modified: day(3), data: eaHeader +
+			"sub-1,prod,11/01/2019,Virtual Machines,10,EUR\n" +
+			"sub-1,prod,11/02/2019,Virtual Machines,5,EUR\n" +
+			"sub-1,prod,11/02/2019,Storage,n/a,EUR\n"},
+		// partitioned exports write the files of a run into a directory
+		"costs/dev/20191101-20191130/run-1/part_0_0001.csv": {modified: day(2), data: legacyHeader +
+			"sub-2,rg,2019-11-01T00:00:00Z,Storage,1,USD\n"},
+		"costs/dev/20191101-20191130/run-2/part_0_0001.csv": {modified: day(3), data: legacyHeader +
+			"sub-2,rg,2019-11-01T00:00:00Z,Storage,1,USD\n"},
+		"costs/dev/20191101-20191130/run-2/part_0_0002.csv": {modified: day(3), data: legacyHeader +
+			"sub-2,rg,2019-11-02T00:00:00Z,Storage,1.5,USD\n"},
+		"costs/dev/20191101-20191130/run-2/manifest.json": {modified: day(3), data: "{}"},
+	}
+	metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs", Help: "Costs."}, billing.MonthlyCostsLabels)
+	a := newAzureBilling(metric, container)
+
+	if err := a.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expRecords := []billing.Record{
+		{Cloud: "azure", Month: "2019-11", Currency: "EUR", Account: "prod", Service: "Virtual Machines", Costs: 15},
+		{Cloud: "azure", Month: "2019-11", Currency: "USD", Account: "sub-2", Service: "Storage", Costs: 2.5},
+	}
+	if act := a.Records(); !reflect.DeepEqual(act, expRecords) {
+		t.Errorf("unexpected records:\n%+v\nexpected:\n%+v", act, expRecords)
+	}
+	if act := len(a.LineItems()); act != 4 {
+		t.Errorf("unexpected number of line items: %d (expected: 4)", act)
+	}
+
+	// a new run replaces the costs of the previous one
+	container["costs/prod/20191101-20191130/prod_3.csv"] = blob{modified: day(4), data: eaHeader +
+		"sub-1,prod,11/01/2019,Virtual Machines,10,EUR\n" +
+		"sub-1,prod,11/02/2019,Virtual Machines,5,EUR\n" +
+		"sub-1,prod,11/03/2019,Virtual Machines,7.5,EUR\n"}
+	if err := a.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := testutil.CollectAndCompare(metric, strings.NewReader(`
+# HELP costs Costs.
+# TYPE costs counter
+costs{account="prod",cloud="azure",cost_centre="",currency="EUR",owner="",path="",service="Virtual Machines",type=""} 22.5
+costs{account="sub-2",cloud="azure",cost_centre="",currency="USD",owner="",path="",service="Storage",type=""} 2.5
+`), "costs"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAzureBillingMissingColumn(t *testing.T) {
+	container := memoryContainer{
+		"costs/prod/20191101-20191130/prod_1.csv": {modified: day(2), data: "SubscriptionName,Date,Cost\nprod,11/01/2019,1\n"},
+	}
+	a := newAzureBilling(prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels), container)
+	if err := a.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if act := a.Records(); len(act) != 0 {
+		t.Errorf("unexpected records of an invalid export: %+v", act)
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	for in, exp := range map[string]string{
+		"11/02/2019":           "2019-11-02",
+		"2019-11-02":           "2019-11-02",
+		"2019-11-02T00:00:00Z": "2019-11-02",
+		"":                     "",
+		"02.11.2019":           "",
+	} {
+		if act, _ := parseDate(in); act != exp {
+			t.Errorf("unexpected date of '%s': '%s' (expected: '%s')", in, act, exp)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package billing
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// OnDecrease selects how the monthly costs counter follows falling costs
+type OnDecrease string
+
+const (
+	// OnDecreaseSkip keeps the counter until the costs exceed the previous
+	// value again, this is the behaviour without a mode set
+	OnDecreaseSkip OnDecrease = "skip"
+	// OnDecreaseReset resets the counter to the costs, so raw values match
+	// the reports while rate() treats the decrease as counter reset
+	OnDecreaseReset OnDecrease = "reset"
+	// OnDecreaseRefundMetric adds the decrease to the refunds counter
+	OnDecreaseRefundMetric OnDecrease = "refund-metric"
+)
+
+// ParseOnDecrease parses the name of a mode
+func ParseOnDecrease(s string) (OnDecrease, error) {
+	switch m := OnDecrease(s); m {
+	case OnDecreaseSkip, OnDecreaseReset, OnDecreaseRefundMetric:
+		return m, nil
+	}
+	return "", fmt.Errorf("invalid on decrease mode '%s', expected one of reset, skip, refund-metric", s)
+}
+
+// AddCosts advances the monthly costs counter of a series from the previous
+// to the current costs. Falling costs are handled according to the mode, the
+// refunds counter is only used by OnDecreaseRefundMetric. It returns whether
+// the costs have been applied, so the baseline of the series can be moved.
+func (m OnDecrease) AddCosts(costs, refunds *prometheus.CounterVec, labels []string, previous, value float64) bool {
+	delta := value - previous
+	if delta >= 0 {
+		costs.WithLabelValues(labels...).Add(delta)
+		return true
+	}
+
+	switch m {
+	case OnDecreaseReset:
+		// negative costs can't be represented by a counter
+		if value < 0 {
+			return false
+		}
+		costs.DeleteLabelValues(labels...)
+		costs.WithLabelValues(labels...).Add(value)
+		return true
+	case OnDecreaseRefundMetric:
+		if refunds == nil {
+			return false
+		}
+		refunds.WithLabelValues(labels...).Add(-delta)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,21 @@
+package billing
+
+import (
+	"time"
+)
+
+// Diagnostics describe the credentials and data sources of a collector, for
+// support triage
+type Diagnostics struct {
+	// Identity is the identity of the credentials, e.g. a role ARN or a
+	// service account
+	Identity string
+	// Source is the location the reports are read from
+	Source string
+	// Reports is the number of reports found by the last query
+	Reports int
+	// NewestReport is the name and NewestReportModified the last
+	// modification of the newest report found by the last query
+	NewestReport         string
+	NewestReportModified time.Time
+}
@@ -0,0 +1,43 @@
+package billing
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// APILatency observes the duration of the calls of the cloud APIs, to tell
+// slow providers apart from slow parsing. A nil APILatency observes nothing.
+type APILatency struct {
+	duration *prometheus.HistogramVec
+}
+
+// NewAPILatency returns the histograms of the API calls by cloud and
+// operation, e.g. s3:GetObject or storage.objects.list
+func NewAPILatency(namespace string) *APILatency {
+	return &APILatency{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "api",
+			Name:      "request_duration_seconds",
+			Help:      "Duration of the calls of the cloud APIs, including retries.",
+			Buckets:   prometheus.ExponentialBuckets(0.025, 2, 13),
+		}, []string{"cloud", "operation"}),
+	}
+}
+
+// Observe records the duration of a call started at the given time
+func (l *APILatency) Observe(cloud, operation string, start time.Time) {
+	if l == nil {
+		return
+	}
+	l.duration.WithLabelValues(cloud, operation).Observe(time.Since(start).Seconds())
+}
+
+func (l *APILatency) Describe(ch chan<- *prometheus.Desc) {
+	l.duration.Describe(ch)
+}
+
+func (l *APILatency) Collect(ch chan<- prometheus.Metric) {
+	l.duration.Collect(ch)
+}
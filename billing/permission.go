@@ -0,0 +1,33 @@
+package billing
+
+import (
+	"context"
+	"sync"
+)
+
+// Permission is an IAM permission required by an API call of a collector
+type Permission struct {
+	// Cloud is the cloud the permission is granted in, aws or gcp
+	Cloud string
+	// Action is the IAM action or GCP permission, e.g. s3:GetObject or
+	// storage.objects.get
+	Action string
+	// Resource is the ARN or resource name the action is performed on
+	Resource string
+	// Check makes the API call requiring the permission, it is nil if the
+	// call can't be tested on its own
+	Check func(context.Context) error
+}
+
+// CheckOnce returns a check which runs fn only on its first call, for
+// permissions tested by the same API call
+func CheckOnce(fn func(context.Context) error) func(context.Context) error {
+	var once sync.Once
+	var err error
+	return func(ctx context.Context) error {
+		once.Do(func() {
+			err = fn(ctx)
+		})
+		return err
+	}
+}
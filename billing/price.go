@@ -0,0 +1,32 @@
+package billing
+
+// ListPriceLabels are the label names of the list price metric
+var ListPriceLabels = []string{"cloud", "service", "sku", "description", "region", "unit", "currency", "tier_start"}
+
+// ListPrice is the list price per usage unit of a SKU, starting from a usage
+// amount of TierStart
+type ListPrice struct {
+	Cloud       string
+	Service     string
+	SKU         string
+	Description string
+	Region      string
+	Unit        string
+	Currency    string
+	TierStart   string
+	Price       float64
+}
+
+// Labels returns the label values of the list price metric
+func (p *ListPrice) Labels() []string {
+	return []string{
+		p.Cloud,
+		p.Service,
+		p.SKU,
+		p.Description,
+		p.Region,
+		p.Unit,
+		p.Currency,
+		p.TierStart,
+	}
+}
@@ -0,0 +1,205 @@
+package billing
+
+// MonthlyCostsLabels are the label names of the monthly costs metric
+var MonthlyCostsLabels = []string{"cloud", "currency", "account", "service", "path", "owner", "cost_centre", "type"}
+
+// Record contains the costs of a service within an account for the billing
+// month, as reported by the latest billing report. The SKU is only set for
+// the GCP costs queried by SKU with the sku label enabled, it isn't a label
+// of the monthly costs counter.
+type Record struct {
+	Cloud      string  `json:"cloud"`
+	Month      string  `json:"month"`
+	Currency   string  `json:"currency"`
+	Account    string  `json:"account"`
+	Service    string  `json:"service"`
+	Path       string  `json:"path"`
+	Owner      string  `json:"owner"`
+	CostCentre string  `json:"cost_centre"`
+	Type       string  `json:"type"`
+	SKU        string  `json:"sku,omitempty"`
+	Costs      float64 `json:"costs"`
+}
+
+// Labels returns the label values of the monthly costs metric
+func (r *Record) Labels() []string {
+	return []string{
+		r.Cloud,
+		r.Currency,
+		r.Account,
+		r.Service,
+		r.Path,
+		r.Owner,
+		r.CostCentre,
+		r.Type,
+	}
+}
+
+// LabelValue returns the value of the monthly costs metric label with the
+// given name
+func (r *Record) LabelValue(name string) string {
+	values := r.Labels()
+	for i, n := range MonthlyCostsLabels {
+		if n == name {
+			return values[i]
+		}
+	}
+	return ""
+}
+
+// LineItem contains the costs of a service within an account as parsed from
+// a billing report, before any metadata got attached. Date is only set if the
+// report has a daily granularity.
+type LineItem struct {
+	Cloud    string  `json:"cloud"`
+	Month    string  `json:"month"`
+	Date     string  `json:"date,omitempty"`
+	Currency string  `json:"currency"`
+	Account  string  `json:"account"`
+	Service  string  `json:"service"`
+	Costs    float64 `json:"costs"`
+}
+
+// HourlyCost contains the costs of a service within an account in an hour,
+// formatted as 2006-01-02T15, as reported with hourly granularity
+type HourlyCost struct {
+	Cloud    string  `json:"cloud"`
+	Hour     string  `json:"hour"`
+	Currency string  `json:"currency"`
+	Account  string  `json:"account"`
+	Service  string  `json:"service"`
+	Costs    float64 `json:"costs"`
+}
+
+// AccountMetadata contains the metadata attached to the costs of an account
+type AccountMetadata struct {
+	Cloud      string `json:"cloud"`
+	Account    string `json:"account"`
+	Path       string `json:"path"`
+	Owner      string `json:"owner"`
+	CostCentre string `json:"cost_centre"`
+	Type       string `json:"type"`
+	// Month is the latest billing month the account had costs in
+	Month string `json:"month"`
+}
+
+// Usage contains the usage quantity of a SKU within the billing month and its
+// costs
+type Usage struct {
+	Cloud    string  `json:"cloud"`
+	Month    string  `json:"month"`
+	Service  string  `json:"service"`
+	SKU      string  `json:"sku"`
+	Unit     string  `json:"unit"`
+	Currency string  `json:"currency"`
+	Quantity float64 `json:"quantity"`
+	Costs    float64 `json:"costs"`
+}
+
+// MergeUsage sums up the usage of the same cloud, month, SKU, unit and
+// currency
+func MergeUsage(usage []Usage) []Usage {
+	index := make(map[[6]string]int)
+	var result []Usage
+	for _, u := range usage {
+		key := [6]string{u.Cloud, u.Month, u.Service, u.SKU, u.Unit, u.Currency}
+		if i, ok := index[key]; ok {
+			result[i].Quantity += u.Quantity
+			result[i].Costs += u.Costs
+			continue
+		}
+		index[key] = len(result)
+		result = append(result, u)
+	}
+	return result
+}
+
+// CreditLabels are the label names of the monthly credits metric
+var CreditLabels = append(append([]string(nil), MonthlyCostsLabels...), "credit_type", "credit_id")
+
+// Credit types distinguished by the credits metric
+const (
+	CreditTypeSustainedUse = "sustained_use_discount"
+	CreditTypeCommittedUse = "committed_use_discount"
+	CreditTypeFreeTier     = "free_tier"
+	CreditTypePromotion    = "promotion"
+	// CreditTypeNegotiated are discounts of negotiated agreements, like
+	// contractual or spend based discounts
+	CreditTypeNegotiated = "negotiated_discount"
+	CreditTypeOther      = "other"
+)
+
+// Credit contains the credits granted on the costs of a service within an
+// account for the billing month, the amount is positive
+type Credit struct {
+	Cloud      string  `json:"cloud"`
+	Month      string  `json:"month"`
+	Currency   string  `json:"currency"`
+	Account    string  `json:"account"`
+	Service    string  `json:"service"`
+	Path       string  `json:"path"`
+	Owner      string  `json:"owner"`
+	CostCentre string  `json:"cost_centre"`
+	Type       string  `json:"type"`
+	CreditType string  `json:"credit_type"`
+	CreditID   string  `json:"credit_id"`
+	Amount     float64 `json:"amount"`
+}
+
+// Labels returns the label values of the monthly credits metric
+func (c *Credit) Labels() []string {
+	return []string{
+		c.Cloud,
+		c.Currency,
+		c.Account,
+		c.Service,
+		c.Path,
+		c.Owner,
+		c.CostCentre,
+		c.Type,
+		c.CreditType,
+		c.CreditID,
+	}
+}
+
+// AdjustmentLabels are the label names of the monthly adjustments metric
+var AdjustmentLabels = append(append([]string(nil), MonthlyCostsLabels...), "record_type")
+
+// Record types of the line items distinguished by the adjustments metric
+const (
+	RecordTypeTax    = "tax"
+	RecordTypeCredit = "credit"
+	RecordTypeRefund = "refund"
+)
+
+// Adjustment contains the taxes, credits or refunds of a service within an
+// account for the billing month, which are included in the costs of its
+// record. The amount of credits and refunds is positive.
+type Adjustment struct {
+	Cloud      string  `json:"cloud"`
+	Month      string  `json:"month"`
+	Currency   string  `json:"currency"`
+	Account    string  `json:"account"`
+	Service    string  `json:"service"`
+	Path       string  `json:"path"`
+	Owner      string  `json:"owner"`
+	CostCentre string  `json:"cost_centre"`
+	Type       string  `json:"type"`
+	RecordType string  `json:"record_type"`
+	Amount     float64 `json:"amount"`
+}
+
+// Labels returns the label values of the monthly adjustments metric
+func (a *Adjustment) Labels() []string {
+	return []string{
+		a.Cloud,
+		a.Currency,
+		a.Account,
+		a.Service,
+		a.Path,
+		a.Owner,
+		a.CostCentre,
+		a.Type,
+		a.RecordType,
+	}
+}
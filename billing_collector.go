@@ -1,79 +1,538 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
-	"sync"
+	"runtime"
+	"strings"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/log"
 	"github.com/prometheus/common/version"
 
 	"github.com/simonswine/cloud-billing-exporter/aws"
+	"github.com/simonswine/cloud-billing-exporter/azure"
+	"github.com/simonswine/cloud-billing-exporter/billing"
+	"github.com/simonswine/cloud-billing-exporter/focus"
 	"github.com/simonswine/cloud-billing-exporter/gcp"
+	"github.com/simonswine/cloud-billing-exporter/opencost"
+	"github.com/simonswine/cloud-billing-exporter/parse"
+	"github.com/simonswine/cloud-billing-exporter/simulate"
+	"github.com/simonswine/cloud-billing-exporter/sink"
+	"github.com/simonswine/cloud-billing-exporter/state"
 )
 
 const AppName = "cloud_billing_exporter"
 const AppNameLong = "Cloud Billing Exporter"
 const Namespace = "cloud"
 
+// fixedCostsLabels are always part of the monthly costs metric, the other
+// labels are used to attribute costs and get their own breakdowns in the
+// generated dashboard and rules
+var fixedCostsLabels = map[string]bool{
+	"cloud":    true,
+	"currency": true,
+	"account":  true,
+	"service":  true,
+}
+
 type cloudBillingCollector interface {
 	Query() error
 	Test() error
 	String() string
+	Cloud() string
+	Records() []billing.Record
+}
+
+// lineItemsCollector is implemented by collectors exposing the line items of
+// their parsed reports
+type lineItemsCollector interface {
+	LineItems() []billing.LineItem
 }
 
 type BillingCollector struct {
-	AWSRegion        *string
-	AWSBucketName    *string
-	AWSRootAccountID *int
-	AWSAccountMap    *string
-	AWSOwnerTag      *string
-	AWSProjectIDTag  *string
-
-	GCPReportPrefix     *string
-	GCPBucketName       *string
-	GCPOwnerLabel       *string
-	GCPCostCentreLabel  *string
-	GCPProjectTypeLabel *string
+	FOCUSURL      *string
+	FOCUSCloud    *string
+	FOCUSFormat   *string
+	FOCUSColumns  *string
+	FOCUSCurrency *string
+
+	SimulateFixture *string
+	SimulateSpeed   *float64
+	SimulateStart   *string
+	SimulateCloud   *string
+
+	OpenCostURL      *string
+	OpenCostCloud    *string
+	OpenCostCurrency *string
+	OpenCostInterval *time.Duration
+
+	AWSRegion                       *string
+	AWSBucketName                   *string
+	AWSRootAccountID                *int
+	AWSAccountMap                   *string
+	AWSOwnerTag                     *string
+	AWSProjectIDTag                 *string
+	AWSRequesterPays                *bool
+	AWSSTSRegionalEndpoint          *bool
+	AWSSTSEndpoint                  *string
+	AWSAccountsManifest             *string
+	AWSAccountsManifestOwnerContact *string
+	AWSDownloadPartSize             *int64
+	AWSDownloadConcurrency          *int
+	AWSDownloadDir                  *string
+	AWSReportKeyPattern             *string
+	AWSReportType                   *string
+	AWSReportPrefix                 *string
+	AWSCURTag                       *string
+	AWSRecordTypes                  *string
+	AWSInactiveAccounts             *string
+	AWSBillingAccount               *string
+	AWSRoleARN                      *string
+	AWSExternalID                   *string
+
+	AWSAthenaDatabase       *string
+	AWSAthenaTable          *string
+	AWSAthenaWorkgroup      *string
+	AWSAthenaOutputLocation *string
+	AWSAthenaInterval       *time.Duration
+	AWSAthenaHourlyWindow   *time.Duration
+
+	GCPReportPrefix      *string
+	GCPReportPrefixMatch *string
+	GCPBucketName        *string
+	GCPOwnerLabel        *string
+	GCPCostCentreLabel   *string
+	GCPProjectTypeLabel  *string
+	GCPUserProject       *string
+	GCPAssetInventory    *string
+	GCPBillingAccount    *string
+
+	GCPBigQueryTable     *string
+	GCPBigQueryDataset   *string
+	GCPBigQueryProject   *string
+	GCPBigQueryInterval  *time.Duration
+	GCPBigQueryLabels    *string
+	GCPBigQuerySKUs      *bool
+	GCPBigQueryLocation  *string
+	GCPBigQueryPriority  *string
+	GCPBigQueryJobLabels *string
+
+	GCPPricingSKUs     *string
+	GCPPricingCurrency *string
+
+	AzureContainerURL *string
+
+	AWSPricingInstanceTypes *string
+	AWSPricingRegions       *string
+
+	PricingInterval *time.Duration
+
+	CredentialsCheckInterval *time.Duration
+	CollectInterval          *time.Duration
+	HealthzIntervals         *int
+
+	ParseWorkers      *int
+	ParseQueueSize    *int
+	ParseMemoryBudget *int64
+	ParseSpillDir     *string
+
+	GCPercent     *int
+	MemoryLimit   *int64
+	MemoryBallast *int64
+
+	ConfigFile *string
 
 	ShowVersion   *bool
+	DryRun        *bool
 	ListenAddress *string
 	MetricsPath   *string
 	LogLevel      *string
 
+	DisableCompression *bool
+	MaxRequests        *int
+	EnablePprof        *bool
+	PprofMutexFraction *int
+	PprofBlockRate     *int
+
+	StateURL *string
+
+	ExportURL      *string
+	ExportFormat   *string
+	ExportInterval *time.Duration
+
+	PostgresDSN   *string
+	PostgresTable *string
+
+	KafkaBrokers *string
+	KafkaTopic   *string
+
+	SQLitePath            *string
+	SQLiteRetentionMonths *int
+
+	GRPCListenAddress   *string
+	GRPCBearerTokenFile *string
+	GRPCTLSCert         *string
+	GRPCTLSKey          *string
+
+	TopN              *int
+	TopNLabels        *string
+	AttributionLabels *string
+	TargetCurrency    *string
+	ExchangeRates     *string
+	ExchangeRatesURL  *string
+	ExchangeRatesTTL  *time.Duration
+	DisableEnrichment *bool
+	DisableOwnerLabel *bool
+	DisablePathLabel  *bool
+	MaxSeries         *int
+	GroupBy           *string
+	CategoriesFile    *string
+	SKULabel          *bool
+	MetricsFile       *string
+	TeamsFile         *string
+	LabelsFromTags    *string
+	EnrichmentURL     *string
+	EnrichmentTTL     *time.Duration
+	DirectoryEnabled  *bool
+	DirectorySubject  *string
+	MaxStaleness      *time.Duration
+	FiscalCalendar    *string
+	WeeklyCostsWeeks  *int
+	OnDecrease        *string
+
+	DashboardTitle *string
+
+	RulesStaleness     *string
+	RulesAnomalyFactor *float64
+	RulesBudget        *float64
+
 	collectors         []cloudBillingCollector
 	metricMonthlyCosts *prometheus.CounterVec
+	hierarchyRollup    *hierarchyRollupCollector
+	cardinality        *cardinalityCollector
+	topN               *topNCollector
+	dimensions         *dimensionMetrics
+	fiscal             *fiscalCollector
+	weekly             *weeklyCollector
+	listPrices         *listPriceCollector
+	allocations        *allocationCollector
+	costShare          *costShareCollector
+	unallocated        *unallocatedCollector
+	currencies         *currencyConverter
+	unitPrice          *unitPriceCollector
+	monthlyCredits     *creditCollector
+	monthlyAdjustments *adjustmentCollector
+	corrections        *correctionCollector
+	discountRate       *discountRateCollector
+	hourlyCosts        *hourlyCostCollector
+	closedMonths       *closedMonthCollector
+	credentials        *credentialsCollector
+	health             *collectorHealth
+	monthlyCosts       *monthlyCostsCollector
+	labelFilter        *labelFilter
+	history            *history
+	// metricMonthlyRefunds counts negative line items and falling costs
+	metricMonthlyRefunds *prometheus.CounterVec
+	// lineItemHistory keeps the line items of the last months for the
+	// fiscal and weekly costs
+	lineItemHistory *lineItemHistory
+
+	// sinkWriter writes the records to the sinks after collections
+	sinkWriter *sinkWriter
+
+	// store keeps historical records, if configured
+	store *sink.SQLite
+
+	// cloudFilter restricts the collected metrics to these clouds, it is set
+	// through collect[] URL parameters
+	cloudFilter map[string]bool
+	// allCollectors contains all collectors, also the ones not selected by
+	// cloudFilter
+	allCollectors []cloudBillingCollector
+
+	// refreshInterval is the interval the collectors are queried in the
+	// background, they are queried per metrics request if 0
+	refreshInterval time.Duration
+
+	// configFlags are the flags the config file was applied to,
+	// configCollectors the collectors it configures
+	configFlags      *flag.FlagSet
+	configCollectors []collectorConfig
+}
+
+// registerCollectorFlags registers the settings of the billing collectors,
+// which are also registered per collector of the config file
+func (b *BillingCollector) registerCollectorFlags(fs *flag.FlagSet) {
+	b.AWSAthenaHourlyWindow = fs.Duration("aws-billing.athena-hourly-window", 0, "Query the costs per hour of this window from Athena along with the monthly costs, e.g. 72h, to expose spend spikes within hours. The Cost and Usage Report needs hourly granularity. Disabled if 0.")
+	b.AWSRegion = fs.String("aws-billing.region", "eu-west-1", "Region name for AWS billing bucket.")
+	b.AWSBucketName = fs.String("aws-billing.bucket-name", "", "Bucket name that stores AWS billing reports, or a local directory the reports are synced into like file:///var/lib/reports. Local reports are read without AWS API calls, unless enrichment is enabled, and require the root account ID.")
+	b.AWSRootAccountID = fs.Int("aws-billing.root-account-id", 0, "Root Account ID.")
+	b.AWSAccountMap = fs.String("aws-billing.account-map", "", "Map account IDs to more readable names. Example: 1200000=acme-dev,120001=acme-prod")
+	b.AWSProjectIDTag = fs.String("aws-billing.project-id-tag", "project-id", "Tag on AWS Projects to override Project Name.")
+	b.AWSOwnerTag = fs.String("aws-billing.owner-tag", "owner", "Tag on AWS Projects to set owner.")
+	b.AWSRequesterPays = fs.Bool("aws-billing.requester-pays", false, "Set the request payer of the requests to the billing bucket, which is required for Requester Pays buckets.")
+	b.AWSSTSRegionalEndpoint = fs.Bool("aws-billing.sts-regional-endpoint", false, "Use the STS endpoint of the region instead of the global one, e.g. in VPCs without internet access.")
+	b.AWSSTSEndpoint = fs.String("aws-billing.sts-endpoint", "", "URL of the STS endpoint, e.g. of a VPC endpoint like https://vpce-0123-abcd.sts.eu-west-1.vpce.amazonaws.com. Resolved from the region if empty.")
+	b.AWSRoleARN = fs.String("aws-billing.role-arn", "", "ARN of a role assumed through STS before calling S3, Organizations and Athena, e.g. a role in the billing account if the exporter runs in another account. Its credentials are refreshed before they expire. The default credentials are used if empty.")
+	b.AWSExternalID = fs.String("aws-billing.external-id", "", "External ID passed along when assuming the role, if the trust policy of the role requires it.")
+	b.AWSAccountsManifest = fs.String("aws-billing.accounts-manifest", "", "YAML or JSON file listing the accounts with their names and alternate contacts, as synced from the Account Management API. If set, accounts are looked up from it instead of the Organizations API, e.g. in member accounts without organizations access.")
+	b.AWSAccountsManifestOwnerContact = fs.String("aws-billing.accounts-manifest-owner-contact", "OPERATIONS", "Type of the alternate contact in the accounts manifest whose email address is the account owner, one of BILLING, OPERATIONS or SECURITY.")
+	b.AWSDownloadPartSize = fs.Int64("aws-billing.download-part-size", 64*1024*1024, "Size in bytes of the parts of reports downloaded with parallel ranged GETs.")
+	b.AWSDownloadConcurrency = fs.Int("aws-billing.download-concurrency", 1, "Number of parallel ranged GETs downloading reports larger than the part size into a temporary file. Reports are streamed with a single GET if below 2.")
+	b.AWSDownloadDir = fs.String("aws-billing.download-dir", "", "Directory to download reports into in parts, interrupted downloads are resumed from the downloaded parts on the next attempt.")
+	b.AWSReportKeyPattern = fs.String("aws-billing.report-key-pattern", aws.DefaultReportKeyPattern, "Pattern of the keys of the reports in the bucket, e.g. reports/{account}/{year}/{mm}/* for renamed reports below a prefix. {account} is replaced by the root account ID, {month} matches the month like 2019-11, {year} and {mm} its parts and * any characters but /. The keys continue with the report extension.")
+	b.AWSReportType = fs.String("aws-billing.report-type", aws.ReportTypeDBR, "Type of the reports in the bucket, dbr for the legacy detailed billing reports or cur for the Cost and Usage Reports. Cost and Usage Reports need GZIP compression or the Parquet format.")
+	b.AWSReportPrefix = fs.String("aws-billing.report-prefix", "", "Path prefix of the Cost and Usage Report in the bucket followed by the report name, e.g. cur/acme. The manifests of the billing periods below it are read, if the report type is cur.")
+	b.AWSCURTag = fs.String("aws-billing.cur-tag", "", "Resource tag key the costs of the Cost and Usage Reports are aggregated by instead of the linked account, e.g. user:kubernetes.io/cluster for the costs per Kubernetes cluster. The tag values are exposed in the account label, costs without the tag as untagged. The tag needs to be activated as cost allocation tag. Only applies to the reports in the bucket, if the report type is cur.")
+	b.AWSRecordTypes = fs.String("aws-billing.record-types", strings.Join(aws.DefaultRecordTypes, ","), "Comma separated list of the record types of the report rows, which are aggregated. Standalone accounts without consolidated billing need PayerLineItem or LineItem, which would double count the linked line items otherwise. Only applies to the detailed billing reports, the line items of Cost and Usage Reports are aggregated regardless of their type.")
+	b.AWSInactiveAccounts = fs.String("aws-billing.inactive-accounts", aws.InactiveAccountsSkip, "How the costs of accounts in SUSPENDED or PENDING_CLOSURE status are exposed, one of skip, label (with the lowercase status as type label) or keep. The status is taken from the Organizations API or the status of the accounts manifest.")
+	b.AWSBillingAccount = fs.String("aws-billing.billing-account", "", "Name of the billing account the costs are billed to, e.g. the name of the payer account, exposed as billing_account label. It defaults to the root account ID, if several AWS collectors are configured in the config file. The label is added if set or several AWS or GCP collectors are configured.")
+	b.AWSAthenaDatabase = fs.String("aws-billing.athena-database", "", "Athena database of the Cost and Usage Report table. If set, the costs are queried through Athena instead of parsing the reports in the bucket.")
+	b.AWSAthenaTable = fs.String("aws-billing.athena-table", "", "Athena table of the Cost and Usage Report, as set up by the CUR Athena integration.")
+	b.AWSAthenaWorkgroup = fs.String("aws-billing.athena-workgroup", "", "Athena workgroup the queries run in.")
+	b.AWSAthenaOutputLocation = fs.String("aws-billing.athena-output-location", "", "S3 location for the Athena query results, e.g. s3://bucket/prefix/. Can be omitted if the workgroup enforces one.")
+	b.AWSAthenaInterval = fs.Duration("aws-billing.athena-interval", time.Hour, "Minimum time between Athena queries, as they are billed by the data scanned.")
+
+	b.GCPReportPrefix = fs.String("gcp-billing.report-prefix", "my-billing", "Report name prefix for GCP billing.")
+	b.GCPReportPrefixMatch = fs.String("gcp-billing.report-prefix-match", gcp.ReportPrefixMatchPrefix, "How the report prefix matches the reports named <prefix>-YYYY-MM-DD.json, one of prefix, glob (e.g. billing-*) or regex (e.g. (billing|legacy)-[0-9A-F]+), to select the exports of several billing accounts or naming schemes in a bucket.")
+	b.GCPBucketName = fs.String("gcp-billing.bucket-name", "", "Bucket name that stores GCP billing reports in JSON or CSV format, or a local directory the reports are synced into like file:///var/lib/reports. Local reports are read without GCP API calls, unless enrichment is enabled.")
+	b.GCPOwnerLabel = fs.String("gcp-billing.owner-label", "owner-base32", "Name of the owner label, which contains the owner in base32 encoding.")
+	b.GCPCostCentreLabel = fs.String("gcp-billing.costcentre-label", "cost_centre", "Name of the cost centre label, which contains the cost centre")
+	b.GCPProjectTypeLabel = fs.String("gcp-billing.project-type-label", "type", "Name of the type label which describes the GPC project")
+	b.GCPUserProject = fs.String("gcp-billing.user-project", "", "Project the requests to the billing bucket are billed to, which is required for Requester Pays buckets.")
+	b.GCPAssetInventory = fs.String("gcp-billing.asset-inventory", "", "Bucket URL of Cloud Asset Inventory exports of the resource content type, e.g. gs://bucket/assets/. If set, projects, folders and organizations are read from the exports instead of the Resource Manager API, which scales better to organizations with many projects.")
+	b.GCPBillingAccount = fs.String("gcp-billing.billing-account", "", "Name of the billing account the costs are billed to, exposed as billing_account label. It defaults to the billing account ID of the BigQuery export table or the report prefix, if several GCP collectors are configured in the config file. The label is added if set or several AWS or GCP collectors are configured.")
+	b.GCPBigQueryTable = fs.String("gcp-billing.bigquery-table", "", "BigQuery table of the standard usage cost export, e.g. my-project.billing.gcp_billing_export_v1_XXXXXX_XXXXXX_XXXXXX, or its name within the dataset. If set, the costs are queried per invoice month through BigQuery instead of reading the reports in the bucket.")
+	b.GCPBigQueryDataset = fs.String("gcp-billing.bigquery-dataset", "", "BigQuery dataset of the table, e.g. billing or my-project.billing, if the table is given by its name only. Datasets without project are in the BigQuery project.")
+	b.GCPBigQueryProject = fs.String("gcp-billing.bigquery-project", "", "Project the BigQuery jobs run in, defaults to the project of the table.")
+	b.GCPBigQueryInterval = fs.Duration("gcp-billing.bigquery-interval", time.Hour, "Minimum time between BigQuery queries, as they are billed by the data scanned.")
+	b.GCPBigQueryLabels = fs.String("gcp-billing.bigquery-labels", "", "Labels the owner, cost centre and type label keys are queried from in BigQuery, either project (project.labels) or resource (labels, splits the costs of a project by them). Looked up through the Resource Manager API if empty.")
+	b.GCPBigQuerySKUs = fs.Bool("gcp-billing.bigquery-skus", false, "Group the costs queried from BigQuery by SKU description in addition to the service, the SKU is appended to the service label or exposed in the sku label using -billing.sku-label. This multiplies the number of series.")
+	b.GCPBigQueryLocation = fs.String("gcp-billing.bigquery-location", "", "Location of the BigQuery dataset the jobs run in, e.g. EU or europe-west1. Detected by BigQuery if empty.")
+	b.GCPBigQueryPriority = fs.String("gcp-billing.bigquery-priority", "interactive", "Priority of the BigQuery jobs, interactive or batch.")
+	b.GCPBigQueryJobLabels = fs.String("gcp-billing.bigquery-job-labels", "app=cloud-billing-exporter", "Comma separated labels attached to the BigQuery jobs, to identify them in the audit logs, e.g. app=cloud-billing-exporter,team=finops.")
+
+	b.AzureContainerURL = fs.String("azure-billing.container-url", "", "Storage Account container the Azure Cost Management exports are written to in CSV format, e.g. azblob://account/container/directory. Requests are authorized by the SAS token in AZURE_STORAGE_SAS_TOKEN or the account key in AZURE_STORAGE_KEY. Disabled if empty.")
+
+	b.FOCUSURL = fs.String("focus.url", "", "Object storage location of cost exports following the FinOps Open Cost and Usage Specification (FOCUS) in CSV or Parquet format, e.g. s3://bucket/prefix?region=eu-west-1 or gs://bucket/prefix. Disabled if empty.")
+	b.FOCUSCloud = fs.String("focus.cloud", "focus", "Value of the cloud label of the costs read from FOCUS exports.")
+	b.FOCUSFormat = fs.String("focus.format", "focus", "Format of the cost exports, one of focus, cloudability (cost report exports) or cloudhealth (cost history exports).")
+	b.FOCUSColumns = fs.String("focus.columns", "", "Comma separated mapping of FOCUS columns to columns of the export, overriding the mapping of the format, e.g. BilledCost=Total Cost,ServiceName=Product.")
+	b.FOCUSCurrency = fs.String("focus.currency", "", "Currency of exports without a BillingCurrency column, defaults to USD for cloudability and cloudhealth exports.")
 }
 
 func (b *BillingCollector) parseFlags() {
-	b.GCPReportPrefix = flag.String("gcp-billing.report-prefix", "my-billing", "Report name prefix for GCP billing.")
-	b.GCPBucketName = flag.String("gcp-billing.bucket-name", "", "Bucket name that stores GCP JSON billing reports.")
-	b.GCPOwnerLabel = flag.String("gcp-billing.owner-label", "owner-base32", "Name of the owner label, which contains the owner in base32 encoding.")
-	b.GCPCostCentreLabel = flag.String("gcp-billing.costcentre-label", "cost_centre", "Name of the cost centre label, which contains the cost centre")
-	b.GCPProjectTypeLabel = flag.String("gcp-billing.project-type-label", "type", "Name of the type label which describes the GPC project")
-
-	b.AWSRegion = flag.String("aws-billing.region", "eu-west-1", "Region name for AWS billing bucket.")
-	b.AWSBucketName = flag.String("aws-billing.bucket-name", "", "Bucket name that stores AWS billing reports.")
-	b.AWSRootAccountID = flag.Int("aws-billing.root-account-id", 0, "Root Account ID.")
-	b.AWSAccountMap = flag.String("aws-billing.account-map", "", "Map account IDs to more readable names. Example: 1200000=acme-dev,120001=acme-prod")
-	b.AWSProjectIDTag = flag.String("aws-billing.project-id-tag", "project-id", "Tag on AWS Projects to override Project Name.")
-	b.AWSOwnerTag = flag.String("aws-billing.owner-tag", "owner", "Tag on AWS Projects to set owner.")
+	b.registerCollectorFlags(flag.CommandLine)
+
+	b.GCPPricingSKUs = flag.String("gcp-pricing.skus", "", "Comma separated list of SKUs, whose list prices are exposed from the Cloud Billing Catalog API. Each SKU is given as <service id>/<sku id>, e.g. 6F81-5844-456A/9CBD-8E06-8A32. Disabled if empty.")
+	b.GCPPricingCurrency = flag.String("gcp-pricing.currency", "", "Currency of the GCP list prices, defaults to USD.")
+	b.AWSPricingInstanceTypes = flag.String("aws-pricing.instance-types", "", "Comma separated list of EC2 instance types, whose on demand Linux list prices are exposed from the AWS Price List API. Disabled if empty.")
+	b.AWSPricingRegions = flag.String("aws-pricing.regions", "eu-west-1", "Comma separated list of regions the EC2 list prices are exposed for.")
+	b.ParseWorkers = flag.Int("parse.workers", runtime.NumCPU(), "Number of workers parsing AWS and GCP billing reports.")
+	b.ParseQueueSize = flag.Int("parse.queue-size", 64, "Number of billing reports waiting to be parsed, before listing further reports blocks.")
+	b.ParseMemoryBudget = flag.Int64("parse.memory-budget", 0, "Approximate bytes of aggregation state per report, beyond which it is spilled to sorted files merged at the end. 0 keeps it in memory.")
+	b.ParseSpillDir = flag.String("parse.spill-dir", os.TempDir(), "Directory the aggregation state exceeding the memory budget is spilled to.")
+	b.GCPercent = flag.Int("runtime.gc-percent", 0, "Garbage collection target percentage, lower values trade CPU for a smaller heap. Set by GOGC if 0.")
+	b.MemoryLimit = flag.Int64("runtime.memory-limit", 0, "Soft memory limit in bytes, the garbage collector runs more often when approaching it, e.g. 90% of the container memory limit. Requires a build with Go 1.19 or newer. Set by GOMEMLIMIT if 0.")
+	b.MemoryBallast = flag.Int64("runtime.memory-ballast", 0, "Size in bytes of a memory ballast allocated at startup, which reduces garbage collections of small heaps without occupying resident memory. Disabled if 0.")
+	b.CredentialsCheckInterval = flag.Duration("credentials.check-interval", time.Hour, "Interval in which the credentials of the collectors are checked, the result is exposed as cloud_billing_credentials_valid.")
+	b.CollectInterval = flag.Duration("collect.interval", time.Hour, "Interval in which the collectors are queried in the background, metrics requests are served from the results of the last queries. The collectors are queried on every metrics request if 0.")
+	b.HealthzIntervals = flag.Int("healthz.refresh-intervals", 3, "Number of -collect.interval without a successful query by any collector, after which /healthz fails. Only applies to collectors queried in the background, disabled if 0.")
+	b.PricingInterval = flag.Duration("pricing.interval", 6*time.Hour, "Interval in which the list prices are refreshed.")
+
+	b.SimulateFixture = flag.String("simulate.fixture", "", "JSON file of the daily line items of a month, as served by /api/v1/line_items, which are replayed as the costs of every month against an accelerated clock, e.g. to develop dashboards and alerts around month rollovers. Disabled if empty.")
+	b.SimulateSpeed = flag.Float64("simulate.speed", 720, "Factor the simulated clock is faster than the real time, 720 compresses a month of 30 days into an hour.")
+	b.SimulateStart = flag.String("simulate.start", "", "Date the simulated clock starts at, e.g. 2019-11-25. Defaults to the first day of the current month.")
+	b.SimulateCloud = flag.String("simulate.cloud", "simulation", "Value of the cloud label of the simulated costs.")
+	b.OpenCostURL = flag.String("opencost.url", "", "URL of the OpenCost or Kubecost allocation API, e.g. http://opencost.opencost:9003 or http://kubecost-cost-analyzer:9090/model/allocation. Disabled if empty.")
+	b.OpenCostCloud = flag.String("opencost.cloud", "kubernetes", "Value of the cloud label of the costs allocated to Kubernetes workloads.")
+	b.OpenCostCurrency = flag.String("opencost.currency", "USD", "Currency configured in OpenCost or Kubecost.")
+	b.OpenCostInterval = flag.Duration("opencost.interval", 10*time.Minute, "Interval in which the allocated costs are refreshed.")
 
+	b.ConfigFile = flag.String("config.file", "", "YAML file setting flags by their name below flags, e.g. billing.top-n: 10, and configuring collectors below collectors, each with its type (aws, gcp, azure or focus) and settings named like the flags of the type without prefix, e.g. bucket-name. Flags set on the command line take precedence. The collectors of the file replace the one configured by the flags of their type, whose values are the defaults of the settings.")
 	b.ShowVersion = flag.Bool("version", false, "Print version information.")
+	b.DryRun = flag.Bool("dry-run", false, "Validate the configuration like the validate command, without starting the HTTP server.")
 	b.LogLevel = flag.String("log-level", "info", "Set log level.")
-	b.ListenAddress = flag.String("web.listen-address", ":9660", "Address on which to expose metrics and web interface.")
+	b.ListenAddress = flag.String("web.listen-address", ":9660", "Comma separated addresses on which to expose metrics and web interface. IPv6 addresses need brackets, e.g. [::]:9660 or [::]:9660,0.0.0.0:9660.")
+	b.DisableCompression = flag.Bool("web.disable-compression", false, "Don't gzip compress the metrics responses, even if the client accepts it.")
+	b.MaxRequests = flag.Int("web.max-requests", 1, "Maximum number of metrics requests served at the same time, as each of them queries the collectors. Further requests wait until they time out. Unlimited if 0.")
+	b.EnablePprof = flag.Bool("web.enable-pprof", false, "Serve the runtime profiles below /debug/pprof/, e.g. to diagnose slow collections.")
+	b.PprofMutexFraction = flag.Int("web.pprof-mutex-fraction", 0, "Report 1/n of the mutex contention events in the mutex profile. Disabled if 0, recording them slows down the exporter.")
+	b.PprofBlockRate = flag.Int("web.pprof-block-rate", 0, "Record one blocking event per n nanoseconds spent blocked in the block profile. Disabled if 0, recording them slows down the exporter.")
 	b.MetricsPath = flag.String("web.telemetry-path", "/metrics", "Path under which to expose metrics.")
 
+	b.DashboardTitle = flag.String("dashboard.title", AppNameLong, "Title of the Grafana dashboard generated by the dashboard command.")
+
+	b.RulesStaleness = flag.String("rules.staleness", "2d", "Time without cost updates after which the stale data alert generated by the rules command fires.")
+	b.RulesAnomalyFactor = flag.Float64("rules.anomaly-factor", 1.5, "Factor by which daily costs need to exceed last week's average to fire the anomaly alert generated by the rules command.")
+	b.RulesBudget = flag.Float64("rules.budget", 0, "Budget for the costs within 30 days per cloud used by the rules command. No budget alert is generated if 0.")
+
+	b.ExportURL = flag.String("export.url", "", "Object storage location the aggregated billing records are exported to periodically, e.g. s3://bucket/prefix?region=eu-west-1 or gs://bucket/prefix. Disabled if empty.")
+	b.ExportFormat = flag.String("export.format", "csv", "Format of the exported billing records (csv or json).")
+	b.ExportInterval = flag.Duration("export.interval", 24*time.Hour, "Interval in which the billing records are exported.")
+
+	b.PostgresDSN = flag.String("postgres.dsn", "", "PostgreSQL connection string, the billing records are upserted into a table after each collection. Disabled if empty.")
+	b.PostgresTable = flag.String("postgres.table", "billing_records", "PostgreSQL table the billing records are upserted into.")
+
+	b.KafkaBrokers = flag.String("kafka.brokers", "", "Comma separated list of Kafka brokers, the billing records and line items are published after each collection. Disabled if empty.")
+	b.KafkaTopic = flag.String("kafka.topic", "cloud-billing", "Kafka topic the billing records and line items are published to.")
+
+	b.SQLitePath = flag.String("sqlite.path", "", "Path of a local SQLite database keeping billing records, line items and account metadata, so historical months can be queried through the API. Disabled if empty.")
+	b.SQLiteRetentionMonths = flag.Int("sqlite.retention-months", 24, "Number of months the billing records, line items and account metadata are kept in the SQLite database.")
+
+	b.GRPCListenAddress = flag.String("grpc.listen-address", "", "Comma separated addresses on which to expose the gRPC API for billing data, IPv6 addresses need brackets, e.g. [::]:9661. Disabled if empty.")
+	b.GRPCBearerTokenFile = flag.String("grpc.bearer-token-file", "", "File containing the bearer token required by gRPC API calls, it is read on every call, so rotated tokens are picked up. No authentication if empty.")
+	b.GRPCTLSCert = flag.String("grpc.tls-cert", "", "TLS certificate file of the gRPC API. Plaintext if empty.")
+	b.GRPCTLSKey = flag.String("grpc.tls-key", "", "TLS key file of the gRPC API.")
+
+	b.TopN = flag.Int("billing.top-n", 0, "Expose the month-to-date costs of the N biggest spenders per cloud in a separate metric. Disabled if 0.")
+	b.DisableEnrichment = flag.Bool("billing.disable-enrichment", false, "Don't look up account names, owners and paths through the AWS Organizations and GCP Resource Manager APIs, e.g. if the exporter lacks their permissions. Accounts and projects are labeled by their ID.")
+	b.DisableOwnerLabel = flag.Bool("billing.disable-owner-label", false, "Leave the owner label empty, the account tags are not looked up unless needed for the account name.")
+	b.DisablePathLabel = flag.Bool("billing.disable-path-label", false, "Leave the path label empty, the position of accounts and projects in the organization is not looked up.")
+	b.CategoriesFile = flag.String("billing.categories-file", "", "YAML file of rules mapping the cloud, account, service and SKU of the monthly costs to the category label by regexes. No category label is added if empty.")
+	b.SKULabel = flag.Bool("billing.sku-label", false, "Expose the SKU of the GCP costs queried from BigQuery with -gcp-billing.bigquery-skus in the sku label, instead of appending it to the service label. The monthly costs counter sums up the costs of all SKUs of a service.")
+	b.LabelsFromTags = flag.String("labels.from-tags", "", "Comma separated list of AWS account tags and GCP project labels, which are added as labels to the monthly costs, e.g. team,env,cost-centre. Characters invalid in label names are replaced by underscores, tags of existing labels like owner or cost_centre override them if set.")
+	b.TeamsFile = flag.String("billing.teams-file", "", "YAML or CSV file mapping accounts and projects to their team, owner and cost centre, which override the ones from tags and labels. Changes are applied without restart. No team label is added if empty.")
+	b.EnrichmentURL = flag.String("enrichment.url", "", "URL of an internal service returning the owner, team and env of an account as JSON, {id} is replaced by the account, e.g. http://cmdb/accounts/{id}. Disabled if empty.")
+	b.EnrichmentTTL = flag.Duration("enrichment.ttl", time.Hour, "Time the accounts looked up from -enrichment.url are cached.")
+	b.DirectoryEnabled = flag.Bool("enrichment.directory", false, "Look up the owners in the Google Workspace directory, their org unit sets the team label unless set otherwise and their manager the manager label.")
+	b.DirectorySubject = flag.String("enrichment.directory-subject", "", "Workspace admin impersonated by the service account of GOOGLE_APPLICATION_CREDENTIALS through domain wide delegation. The default credentials are used if empty.")
+	b.MetricsFile = flag.String("billing.metrics-file", "", "YAML file of additional metrics of the monthly costs, each summed up by its own labels and optionally limited to the top accounts, e.g. a coarse metric by account next to a finer one for the top accounts. Disabled if empty.")
+	b.GroupBy = flag.String("billing.group-by", "", "Comma separated list of labels the monthly costs are summed up by, e.g. account,service or category. The cloud and currency labels are always kept. All labels are kept if empty.")
+	b.MaxStaleness = flag.Duration("billing.max-staleness", 0, "Respond to metrics requests with 503 if a collector had no successful query for longer than this, so outdated costs are not trusted. The time of the last successful query is exposed independent of it. Disabled if 0.")
+	b.FiscalCalendar = flag.String("billing.fiscal-calendar", "", "Expose the costs aggregated to fiscal periods, given as comma separated options, either month-start-day and year-start-month, e.g. month-start-day=15,year-start-month=4, or the weeks of the periods of a quarter and the start of a fiscal year, e.g. weeks=4-4-5,year-start=2019-12-29. Disabled if empty.")
+	b.WeeklyCostsWeeks = flag.Int("billing.weekly-costs-weeks", 0, "Expose the costs per ISO week of the last N weeks, aggregated from the reports with daily granularity. Disabled if 0.")
+	b.OnDecrease = flag.String("billing.on-decrease", string(billing.OnDecreaseRefundMetric), "How the monthly costs counter follows falling costs of the reports: reset resets the counter to the costs, so raw values match the reports while rate() sees a counter reset, skip keeps the counter until the costs exceed the previous value again, refund-metric adds the decrease and negative AWS line items to cloud_billing_monthly_refunds.")
+	b.MaxSeries = flag.Int("billing.max-series", 0, "Maximum number of monthly costs series per cloud. The costs of further series are summed up in series with the account and service overflow. Unlimited if 0.")
+	b.labelFilter = newLabelFilter()
+	flag.Var(&labelFilterFlag{filter: b.labelFilter, allow: true}, "billing.label-allow", "Only expose metrics whose label matches the regex, given as label=regex, e.g. owner=.*@example.com. Can be repeated, metrics matching any of the regexes of a label are kept.")
+	flag.Var(&labelFilterFlag{filter: b.labelFilter}, "billing.label-deny", "Drop metrics whose label matches the regex, given as label=regex, e.g. service=AWS Support.*. Can be repeated.")
+	b.TopNLabels = flag.String("billing.top-n-labels", "account,service", "Comma separated list of labels identifying a spender in the top N metric.")
+	b.AttributionLabels = flag.String("billing.attribution-labels", "", "Comma separated list of labels attributing costs to an owner, team or cost centre. Costs with all of them empty are exposed as unallocated costs. Defaults to owner, team and cost_centre.")
+	b.TargetCurrency = flag.String("billing.target-currency", "", "Currency the month-to-date costs are converted to, exposed as cloud_billing_monthly_costs_converted, e.g. EUR. Needs -billing.exchange-rates or -billing.exchange-rates-url. Disabled if empty.")
+	b.ExchangeRates = flag.String("billing.exchange-rates", "", "Comma separated list of static exchange rates in units of the target currency per unit of the currency, e.g. USD=0.92,GBP=1.17. They take precedence over the fetched rates.")
+	b.ExchangeRatesURL = flag.String("billing.exchange-rates-url", "", "URL of the daily reference rates of the European Central Bank the exchange rates are fetched from, e.g. https://www.ecb.europa.eu/stats/eurofxref/eurofxref-daily.xml.")
+	b.ExchangeRatesTTL = flag.Duration("billing.exchange-rates-ttl", 24*time.Hour, "Interval in which the exchange rates are fetched in the background.")
+
+	b.StateURL = flag.String("state.url", "", "URL of a store for counter baselines, report hashes and metadata caches, so restarts and failovers between replicas don't double count. Supported: file:///var/lib/cloud-billing-exporter, s3://bucket/prefix?region=eu-west-1, gs://bucket/prefix, redis://host:6379/0, configmap://namespace/name")
 	flag.Parse()
 }
 
+// initMetrics sets up the metrics which are always exposed
+func (b *BillingCollector) initMetrics() {
+	b.metricMonthlyCosts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: prometheus.BuildFQName(Namespace, "billing", "monthly_costs"),
+			Help: "Billed costs per calendar month.",
+		},
+		billing.MonthlyCostsLabels,
+	)
+	b.metricMonthlyRefunds = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: prometheus.BuildFQName(Namespace, "billing", "monthly_refunds"),
+			Help: "Refunds and downward corrections of the billed costs per calendar month.",
+		},
+		billing.MonthlyCostsLabels,
+	)
+	b.hierarchyRollup = newHierarchyRollupCollector(b.metricMonthlyCosts)
+	b.cardinality = newCardinalityCollector(b.metricMonthlyCosts)
+	b.costShare = newCostShareCollector()
+	b.unallocated = newUnallocatedCollector()
+	b.unitPrice = newUnitPriceCollector()
+	b.monthlyCredits = newCreditCollector()
+	b.monthlyAdjustments = newAdjustmentCollector()
+	b.corrections = newCorrectionCollector()
+	b.discountRate = newDiscountRateCollector()
+	b.hourlyCosts = newHourlyCostCollector()
+	b.closedMonths = newClosedMonthCollector()
+	b.credentials = newCredentialsCollector(time.Hour)
+	b.health = newCollectorHealth()
+	b.monthlyCosts = newMonthlyCostsCollector(0)
+	b.history = newHistory()
+	b.lineItemHistory = newLineItemHistory()
+}
+
+// setupMonthlyCosts adds the enrichers and group by labels configured to the
+// monthly costs and returns the tag keys exposed as labels
+func (b *BillingCollector) setupMonthlyCosts() ([]string, error) {
+	b.monthlyCosts.limit = *b.MaxSeries
+	// the tags are added first, so the categories and the team mapping
+	// apply on top of them
+	var tagKeys []string
+	if *b.LabelsFromTags != "" {
+		e, err := newTagsEnricher(b, strings.Split(*b.LabelsFromTags, ","))
+		if err != nil {
+			return nil, fmt.Errorf("error setting up labels from tags: %s", err)
+		}
+		b.monthlyCosts.withEnricher(e)
+		tagKeys = e.keys
+	}
+	if *b.CategoriesFile != "" {
+		c, err := loadCategoryRules(*b.CategoriesFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading category rules: %s", err)
+		}
+		b.monthlyCosts.withEnricher(c)
+	}
+	if *b.TeamsFile != "" {
+		m, err := newTeamMapping(*b.TeamsFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading team mapping: %s", err)
+		}
+		b.monthlyCosts.withEnricher(m)
+	}
+	if *b.EnrichmentURL != "" {
+		h, err := newHTTPEnrichment(*b.EnrichmentURL, *b.EnrichmentTTL)
+		if err != nil {
+			return nil, fmt.Errorf("error setting up account enrichment: %s", err)
+		}
+		b.monthlyCosts.withEnricher(h)
+	}
+	if *b.DirectoryEnabled {
+		users, err := newWorkspaceUsers(context.Background(), *b.DirectorySubject)
+		if err != nil {
+			return nil, fmt.Errorf("error setting up directory enrichment: %s", err)
+		}
+		b.monthlyCosts.withEnricher(newDirectoryEnrichment(users, *b.EnrichmentTTL))
+	}
+	awsSettings, err := b.collectorsOfType("aws")
+	if err != nil {
+		return nil, fmt.Errorf("error setting up AWS collectors: %s", err)
+	}
+	gcpSettings, err := b.collectorsOfType("gcp")
+	if err != nil {
+		return nil, fmt.Errorf("error setting up GCP collectors: %s", err)
+	}
+	if billingAccounts(awsSettings, gcpSettings) {
+		b.monthlyCosts.withEnricher(newBillingAccountEnricher(b))
+	}
+	if *b.SKULabel {
+		b.monthlyCosts.withEnricher(&skuEnricher{})
+	}
+	if *b.GroupBy != "" {
+		if err := b.monthlyCosts.withGroupBy(strings.Split(*b.GroupBy, ",")); err != nil {
+			return nil, fmt.Errorf("error setting up group by labels: %s", err)
+		}
+	}
+	return tagKeys, nil
+}
+
 func (b *BillingCollector) Run() {
 	b.parseFlags()
 
+	if *b.ConfigFile != "" {
+		if err := b.loadConfigFile(flag.CommandLine, *b.ConfigFile); err != nil {
+			log.Fatalf("error loading config file: %s", err)
+		}
+	}
+
 	if b.LogLevel != nil {
 		if err := log.Base().SetLevel(*b.LogLevel); err != nil {
 			log.Errorf("error setting log level: %s", err)
@@ -85,68 +544,419 @@ func (b *BillingCollector) Run() {
 		os.Exit(0)
 	}
 
+	cmd := flag.Arg(0)
+	if *b.DryRun && cmd == "" {
+		cmd = "validate"
+	}
+	switch cmd {
+	case "":
+	case "check-permissions", "aws-policy", "gcp-role", "doctor", "validate":
+		// run once the collectors are set up
+	case "dashboard", "rules":
+		// the dashboard and rules break the costs down by the labels of the
+		// enrichers
+		b.initMetrics()
+		if _, err := b.setupMonthlyCosts(); err != nil {
+			log.Fatal(err)
+		}
+		write := b.writeDashboard
+		if cmd == "rules" {
+			write = b.writeRules
+		}
+		if err := write(os.Stdout); err != nil {
+			log.Fatalf("error generating %s: %s", cmd, err)
+		}
+		os.Exit(0)
+	case "healthcheck":
+		url, err := healthcheckURL(*b.ListenAddress)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := healthcheck(&http.Client{Timeout: healthcheckTimeout}, url); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	default:
+		log.Fatalf("unknown command '%s'", cmd)
+	}
+
 	log.Infoln("Starting", AppName, version.Info())
 	log.Infoln("Build context", version.BuildContext())
 
-	b.metricMonthlyCosts = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Name: prometheus.BuildFQName(Namespace, "billing", "monthly_costs"),
-			Help: "Billed costs per calendar month.",
-		},
-		[]string{"cloud", "currency", "account", "service", "path", "owner", "cost_centre", "type"},
-	)
+	if err := tuneMemory(*b.GCPercent, *b.MemoryLimit, *b.MemoryBallast); err != nil {
+		log.Fatalf("error tuning memory: %s", err)
+	}
+
+	b.initMetrics()
+	b.credentials.interval = *b.CredentialsCheckInterval
+	tagKeys, err := b.setupMonthlyCosts()
+	if err != nil {
+		log.Fatal(err)
+	}
+	awsSettings, err := b.collectorsOfType("aws")
+	if err != nil {
+		log.Fatalf("error setting up AWS collectors: %s", err)
+	}
+	gcpSettings, err := b.collectorsOfType("gcp")
+	if err != nil {
+		log.Fatalf("error setting up GCP collectors: %s", err)
+	}
+	if *b.AttributionLabels != "" {
+		if err := b.unallocated.withLabels(strings.Split(*b.AttributionLabels, ","), b.monthlyCosts.allLabels()); err != nil {
+			log.Fatalf("error setting up unallocated costs: %s", err)
+		}
+	}
+	if *b.TargetCurrency != "" {
+		c, err := newCurrencyConverter(*b.TargetCurrency, *b.ExchangeRates, *b.ExchangeRatesURL, *b.ExchangeRatesTTL)
+		if err != nil {
+			log.Fatalf("error setting up currency conversion: %s", err)
+		}
+		b.currencies = c.withLabels(b.monthlyCosts.labels)
+	}
+	if *b.MetricsFile != "" {
+		d, err := loadDimensionMetrics(*b.MetricsFile, b.monthlyCosts.allLabels())
+		if err != nil {
+			log.Fatalf("error loading metrics: %s", err)
+		}
+		b.dimensions = d
+	}
+	if *b.TopN > 0 {
+		topN, err := newTopNCollector(*b.TopN, strings.Split(*b.TopNLabels, ","))
+		if err != nil {
+			log.Fatalf("error setting up top N metric: %s", err)
+		}
+		b.topN = topN
+	}
+	if *b.FiscalCalendar != "" {
+		fiscal, err := newFiscalCollector(*b.FiscalCalendar, b.lineItemHistory)
+		if err != nil {
+			log.Fatalf("error setting up fiscal calendar: %s", err)
+		}
+		b.fiscal = fiscal
+	}
+	if *b.WeeklyCostsWeeks > 0 {
+		b.weekly = newWeeklyCollector(*b.WeeklyCostsWeeks, b.lineItemHistory)
+	}
+
+	var stateStore state.Store
+	if *b.StateURL != "" {
+		s, err := state.New(context.Background(), *b.StateURL)
+		if err != nil {
+			log.Fatalf("error setting up state store: %s", err)
+		}
+		log.Infof("persisting state in %s", s)
+		stateStore = s
+	}
+	b.history.withStateStore(stateStore)
+	if err := b.history.restore(context.Background()); err != nil {
+		log.Warnf("error restoring history from %s: %s", stateStore, err)
+	}
+
+	onDecrease, err := billing.ParseOnDecrease(*b.OnDecrease)
+	if err != nil {
+		log.Fatalf("error setting up monthly costs: %s", err)
+	}
+
+	pipeline := parse.New(Namespace, *b.ParseWorkers, *b.ParseQueueSize).WithSpill(*b.ParseSpillDir, *b.ParseMemoryBudget)
+	prometheus.MustRegister(pipeline)
+	apiLatency := billing.NewAPILatency(Namespace)
+	prometheus.MustRegister(apiLatency)
+
+	for _, s := range awsSettings {
+		if *s.AWSBucketName != "" || *s.AWSAthenaDatabase != "" {
+			var rootAccountID string
+			if *s.AWSRootAccountID != 0 {
+				rootAccountID = fmt.Sprintf("%d", *s.AWSRootAccountID)
+			}
+			c := aws.NewAWSBilling(
+				b.metricMonthlyCosts,
+				*s.AWSBucketName,
+				*s.AWSRegion,
+				rootAccountID,
+				*s.AWSAccountMap,
+				*s.AWSOwnerTag,
+				*s.AWSProjectIDTag,
+			).WithStateStore(stateStore).WithOnDecrease(onDecrease, b.metricMonthlyRefunds).WithPipeline(pipeline).WithAPILatency(apiLatency).WithRequesterPays(*s.AWSRequesterPays).WithEnrichment(!*b.DisableEnrichment).WithOwnerAndPath(!*b.DisableOwnerLabel, !*b.DisablePathLabel).WithSTSEndpoint(*s.AWSSTSRegionalEndpoint, *s.AWSSTSEndpoint).WithRole(*s.AWSRoleARN, *s.AWSExternalID).WithAccountsManifest(*s.AWSAccountsManifest, *s.AWSAccountsManifestOwnerContact).WithRangedDownloads(*s.AWSDownloadPartSize, *s.AWSDownloadConcurrency).WithResumableDownloads(*s.AWSDownloadDir).WithBillingAccount(*s.AWSBillingAccount).WithTags(tagKeys)
+			c.WithRecordTypes(strings.Split(*s.AWSRecordTypes, ","))
+			if _, err := c.WithReportKeyPattern(*s.AWSReportKeyPattern); err != nil {
+				log.Fatalf("error setting up report key pattern: %s", err)
+			}
+			if _, err := c.WithReportType(*s.AWSReportType, *s.AWSReportPrefix); err != nil {
+				log.Fatalf("error setting up report type: %s", err)
+			}
+			if _, err := c.WithCURTag(*s.AWSCURTag); err != nil {
+				log.Fatalf("error setting up report tag: %s", err)
+			}
+			if _, err := c.WithInactiveAccounts(*s.AWSInactiveAccounts); err != nil {
+				log.Fatalf("error setting up inactive accounts: %s", err)
+			}
+			if *s.AWSAthenaDatabase != "" {
+				if _, err := c.WithAthena(*s.AWSAthenaDatabase, *s.AWSAthenaTable, *s.AWSAthenaWorkgroup, *s.AWSAthenaOutputLocation, *s.AWSAthenaInterval); err != nil {
+					log.Fatalf("error setting up athena: %s", err)
+				}
+				c.WithHourlyCosts(*s.AWSAthenaHourlyWindow)
+			}
+			b.collectors = append(b.collectors, c)
+		}
+	}
 
-	if *b.AWSBucketName != "" {
-		var rootAccountID string
-		if *b.AWSRootAccountID != 0 {
-			rootAccountID = fmt.Sprintf("%d", *b.AWSRootAccountID)
-		}
-		c := aws.NewAWSBilling(
-			b.metricMonthlyCosts,
-			*b.AWSBucketName,
-			*b.AWSRegion,
-			rootAccountID,
-			*b.AWSAccountMap,
-			*b.AWSOwnerTag,
-			*b.AWSProjectIDTag,
-		)
-		if err := c.Test(); err != nil {
-			log.Error(err)
-		} else {
+	for _, s := range gcpSettings {
+		if *s.GCPBucketName != "" || *s.GCPBigQueryTable != "" {
+			c := gcp.NewGCPBilling(
+				b.metricMonthlyCosts,
+				*s.GCPBucketName,
+				*s.GCPReportPrefix,
+				*s.GCPOwnerLabel,
+				*s.GCPCostCentreLabel,
+				*s.GCPProjectTypeLabel,
+			).WithStateStore(stateStore).WithOnDecrease(onDecrease, b.metricMonthlyRefunds).WithPipeline(pipeline).WithAPILatency(apiLatency).WithUserProject(*s.GCPUserProject).WithEnrichment(!*b.DisableEnrichment).WithOwnerAndPath(!*b.DisableOwnerLabel, !*b.DisablePathLabel).WithBillingAccount(*s.GCPBillingAccount).WithTags(tagKeys)
+			if _, err := c.WithReportPrefixMatch(*s.GCPReportPrefixMatch); err != nil {
+				log.Fatalf("error setting up report prefix match: %s", err)
+			}
+			if *s.GCPAssetInventory != "" {
+				if _, err := c.WithAssetInventory(context.Background(), *s.GCPAssetInventory); err != nil {
+					log.Fatalf("error setting up asset inventory: %s", err)
+				}
+			}
+			if *s.GCPBigQueryTable != "" {
+				table, err := gcp.QualifyBigQueryTable(*s.GCPBigQueryProject, *s.GCPBigQueryDataset, *s.GCPBigQueryTable)
+				if err != nil {
+					log.Fatalf("error setting up bigquery: %s", err)
+				}
+				if _, err := c.WithBigQuery(context.Background(), *s.GCPBigQueryProject, table, *s.GCPBigQueryInterval); err != nil {
+					log.Fatalf("error setting up bigquery: %s", err)
+				}
+				if _, err := c.WithBigQueryLabels(*s.GCPBigQueryLabels); err != nil {
+					log.Fatalf("error setting up bigquery: %s", err)
+				}
+				if _, err := c.WithBigQuerySKUs(*s.GCPBigQuerySKUs); err != nil {
+					log.Fatalf("error setting up bigquery: %s", err)
+				}
+				if _, err := c.WithBigQuerySKULabel(*b.SKULabel); err != nil {
+					log.Fatalf("error setting up bigquery: %s", err)
+				}
+				if _, err := c.WithBigQueryJobConfig(*s.GCPBigQueryLocation, *s.GCPBigQueryPriority, *s.GCPBigQueryJobLabels); err != nil {
+					log.Fatalf("error setting up bigquery: %s", err)
+				}
+			}
+			b.collectors = append(b.collectors, c)
+		}
+	}
+
+	azureSettings, err := b.collectorsOfType("azure")
+	if err != nil {
+		log.Fatalf("error setting up Azure collectors: %s", err)
+	}
+	for _, s := range azureSettings {
+		if *s.AzureContainerURL != "" {
+			c, err := azure.NewAzureBilling(context.Background(), b.metricMonthlyCosts, *s.AzureContainerURL)
+			if err != nil {
+				log.Fatalf("error setting up Azure collector: %s", err)
+			}
+			c.WithStateStore(stateStore).WithOnDecrease(onDecrease, b.metricMonthlyRefunds)
 			b.collectors = append(b.collectors, c)
 		}
 	}
 
-	if *b.GCPBucketName != "" {
-		c := gcp.NewGCPBilling(
-			b.metricMonthlyCosts,
-			*b.GCPBucketName,
-			*b.GCPReportPrefix,
-			*b.GCPOwnerLabel,
-			*b.GCPCostCentreLabel,
-			*b.GCPProjectTypeLabel,
-		)
-		if err := c.Test(); err != nil {
-			log.Error(err)
-		} else {
+	listPrices := newListPriceCollector(*b.PricingInterval)
+	if *b.GCPPricingSKUs != "" {
+		p, err := gcp.NewPricingCatalog(context.Background(), strings.Split(*b.GCPPricingSKUs, ","), *b.GCPPricingCurrency)
+		if err != nil {
+			log.Fatalf("error setting up GCP pricing: %s", err)
+		}
+		listPrices.withSource(p)
+	}
+	if *b.AWSPricingInstanceTypes != "" {
+		p, err := aws.NewPricingCatalog(strings.Split(*b.AWSPricingInstanceTypes, ","), strings.Split(*b.AWSPricingRegions, ","))
+		if err != nil {
+			log.Fatalf("error setting up AWS pricing: %s", err)
+		}
+		listPrices.withSource(p)
+	}
+	if len(listPrices.sources) > 0 {
+		b.listPrices = listPrices
+	}
+
+	focusSettings, err := b.collectorsOfType("focus")
+	if err != nil {
+		log.Fatalf("error setting up FOCUS collectors: %s", err)
+	}
+	for _, s := range focusSettings {
+		if *s.FOCUSURL != "" {
+			format, err := focus.ParseFormat(*s.FOCUSFormat, *s.FOCUSColumns)
+			if err != nil {
+				log.Fatalf("error setting up FOCUS collector: %s", err)
+			}
+			if *s.FOCUSCurrency != "" {
+				format.Currency = *s.FOCUSCurrency
+			}
+			c, err := focus.NewFOCUSBilling(context.Background(), b.metricMonthlyCosts, *s.FOCUSURL, *s.FOCUSCloud)
+			if err != nil {
+				log.Fatalf("error setting up FOCUS collector: %s", err)
+			}
+			c.WithFormat(format).WithStateStore(stateStore).WithOnDecrease(onDecrease, b.metricMonthlyRefunds)
 			b.collectors = append(b.collectors, c)
 		}
 	}
 
-	if len(b.collectors) == 0 {
-		log.Fatal("no working cloud billing collectors found")
+	if *b.SimulateFixture != "" {
+		now := time.Now().UTC()
+		start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		if *b.SimulateStart != "" {
+			t, err := time.Parse(dateFormat, *b.SimulateStart)
+			if err != nil {
+				log.Fatalf("error setting up simulation: invalid start '%s': %s", *b.SimulateStart, err)
+			}
+			start = t
+		}
+		clock := simulate.NewClock(start, *b.SimulateSpeed)
+		c, err := simulate.NewSimulation(b.metricMonthlyCosts, *b.SimulateFixture, *b.SimulateCloud, clock)
+		if err != nil {
+			log.Fatalf("error setting up simulation: %s", err)
+		}
+		c.WithOnDecrease(onDecrease, b.metricMonthlyRefunds)
+		if b.weekly != nil {
+			b.weekly.now = clock.Now
+		}
+		log.Infof("simulating the costs of %s from %s at %.0f times the real time", *b.SimulateFixture, start.Format(dateFormat), *b.SimulateSpeed)
+		b.collectors = append(b.collectors, c)
+	}
+
+	if *b.OpenCostURL != "" {
+		c, err := opencost.NewClient(*b.OpenCostURL)
+		if err != nil {
+			log.Fatalf("error setting up OpenCost collector: %s", err)
+		}
+		b.allocations = newAllocationCollector(c, *b.OpenCostCloud, *b.OpenCostCurrency, *b.OpenCostInterval)
+	}
+
+	if len(b.collectors) == 0 && b.allocations == nil {
+		log.Fatal("no cloud billing collectors configured")
+	}
+
+	switch cmd {
+	case "check-permissions":
+		if !b.checkPermissions(context.Background(), os.Stdout) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	case "doctor":
+		if !b.doctor(context.Background(), os.Stdout, b.features(), prometheus.DefaultGatherer) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	case "validate":
+		if !b.validate(context.Background(), os.Stdout) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	case "aws-policy":
+		if err := b.writeAWSPolicy(os.Stdout); err != nil {
+			log.Fatalf("error generating AWS policy: %s", err)
+		}
+		os.Exit(0)
+	case "gcp-role":
+		if err := b.writeGCPRole(os.Stdout); err != nil {
+			log.Fatalf("error generating GCP role: %s", err)
+		}
+		os.Exit(0)
 	}
 
+	// collectors failing their test are retried with backoff, their state is
+	// exposed by the collector up metric
+	for _, c := range b.collectors {
+		if err := b.health.run(c, c.Test); err != nil {
+			log.Errorf("error testing %s, retrying later: %s", c, err)
+		}
+	}
+
+	if b.currencies != nil {
+		go b.currencies.run()
+	}
+
+	prometheus.MustRegister(version.NewCollector(AppName), newFeatureCollector(b.features()))
+
 	if err := prometheus.Register(b); err != nil {
 		log.Fatalf("Couldn't register collector: %s", err)
 	}
 
-	handler := promhttp.HandlerFor(prometheus.DefaultGatherer,
-		promhttp.HandlerOpts{
-			ErrorLog:      log.NewErrorLogger(),
-			ErrorHandling: promhttp.ContinueOnError,
-		})
-	http.Handle(*b.MetricsPath, handler)
+	if *b.ExportURL != "" {
+		s, err := sink.NewObjectStorage(context.Background(), *b.ExportURL, *b.ExportFormat)
+		if err != nil {
+			log.Fatalf("error setting up export: %s", err)
+		}
+		log.Infof("exporting billing records every %s as %s", *b.ExportInterval, s)
+		go b.runScheduledExport(s, *b.ExportInterval)
+	}
+
+	var sinks []sink.Sink
+	if *b.PostgresDSN != "" {
+		s, err := sink.NewPostgres(context.Background(), *b.PostgresDSN, *b.PostgresTable)
+		if err != nil {
+			log.Fatalf("error setting up postgres sink: %s", err)
+		}
+		sinks = append(sinks, s)
+	}
+	if *b.KafkaBrokers != "" {
+		s, err := sink.NewKafka(*b.KafkaBrokers, *b.KafkaTopic)
+		if err != nil {
+			log.Fatalf("error setting up kafka sink: %s", err)
+		}
+		sinks = append(sinks, s)
+	}
+	if *b.SQLitePath != "" {
+		s, err := sink.NewSQLite(context.Background(), *b.SQLitePath, *b.SQLiteRetentionMonths)
+		if err != nil {
+			log.Fatalf("error setting up sqlite store: %s", err)
+		}
+		sinks = append(sinks, s)
+		b.store = s
+	}
+	if len(sinks) > 0 {
+		b.sinkWriter = newSinkWriter(sinks)
+		go b.sinkWriter.run()
+	}
+
+	// the refresh hands the records to the sinks, so it is started once they
+	// are set up
+	if *b.CollectInterval > 0 {
+		b.refreshInterval = *b.CollectInterval
+		log.Infof("querying the collectors every %s", b.refreshInterval)
+		go b.runRefresh(b.refreshInterval)
+	}
+
+	if *b.GRPCListenAddress != "" {
+		if err := b.serveGRPC(); err != nil {
+			log.Fatalf("error setting up gRPC API: %s", err)
+		}
+	}
+
+	responseSize := newResponseSizeHistogram()
+	prometheus.MustRegister(responseSize)
+	var metricsHandler http.Handler = b.metricsHandler(*b.DisableCompression, *b.MaxRequests)
+	if *b.MaxStaleness > 0 {
+		metricsHandler = b.health.staleHandler(b.collectors, *b.MaxStaleness, metricsHandler)
+	}
+	http.Handle(*b.MetricsPath, instrumentResponseSize(responseSize, metricsHandler))
+	http.HandleFunc("/api/v1/chargeback.csv", b.chargebackHandler)
+	http.HandleFunc("/api/v1/costs", b.costsHandler)
+	http.HandleFunc("/api/v1/line_items", b.lineItemsHandler)
+	http.HandleFunc("/api/v1/accounts", b.accountsHandler)
+	if b.refreshInterval > 0 && *b.HealthzIntervals > 0 {
+		http.Handle(healthzPath, b.health.healthzHandler(b.collectors, time.Duration(*b.HealthzIntervals)*b.refreshInterval))
+	} else {
+		http.HandleFunc(healthzPath, healthzHandler)
+	}
+	if b.refreshInterval > 0 {
+		http.Handle(readyzPath, b.health.readyzHandler(b.collectors))
+	} else {
+		// the collectors are only queried by metrics requests
+		http.HandleFunc(readyzPath, healthzHandler)
+	}
+	if *b.EnablePprof {
+		registerPprof(http.DefaultServeMux, *b.PprofMutexFraction, *b.PprofBlockRate)
+	}
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if _, err := w.Write([]byte(`<html>
 			<head><title>` + AppNameLong + `</title></head>
@@ -160,32 +970,283 @@ func (b *BillingCollector) Run() {
 
 	})
 
-	log.Infoln("Listening on", *b.ListenAddress)
-	err := http.ListenAndServe(*b.ListenAddress, nil)
+	listeners, err := listen(*b.ListenAddress)
 	if err != nil {
 		log.Fatal(err)
 	}
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		log.Infoln("Listening on", l.Addr())
+		go func(l net.Listener) {
+			errs <- http.Serve(l, nil)
+		}(l)
+	}
+	log.Fatal(<-errs)
+}
+
+// filtered returns a copy of the BillingCollector, which only queries and
+// collects the given clouds
+func (b *BillingCollector) filtered(clouds []string) (*BillingCollector, error) {
+	f := *b
+	f.collectors = nil
+	f.allCollectors = b.collectors
+	f.cloudFilter = make(map[string]bool)
+	for _, cloud := range clouds {
+		f.cloudFilter[cloud] = true
+	}
+
+	for _, c := range b.collectors {
+		if f.cloudFilter[c.Cloud()] {
+			f.collectors = append(f.collectors, c)
+		}
+	}
+
+	for cloud := range f.cloudFilter {
+		// the costs allocated by OpenCost are exposed with their own cloud
+		found := b.allocations != nil && b.allocations.cloud == cloud
+		for _, c := range f.collectors {
+			if c.Cloud() == cloud {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown collector '%s'", cloud)
+		}
+	}
+
+	return &f, nil
+}
+
+// collectFiltered forwards only metrics with a cloud label that is part of
+// the cloud filter
+func (b BillingCollector) collectFiltered(collect func(chan<- prometheus.Metric), ch chan<- prometheus.Metric) {
+	if b.cloudFilter == nil {
+		collect(ch)
+		return
+	}
+
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		collect(metrics)
+		close(metrics)
+	}()
+
+	for m := range metrics {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			log.Warnf("error filtering metric %s: %s", m.Desc(), err)
+			continue
+		}
+		for _, l := range pb.Label {
+			if l.GetName() == "cloud" && b.cloudFilter[l.GetValue()] {
+				ch <- m
+				break
+			}
+		}
+	}
 }
 
 func (b BillingCollector) Describe(ch chan<- *prometheus.Desc) {
-	b.metricMonthlyCosts.Describe(ch)
+	// the monthly costs are described by the monthly costs collector
+	b.hierarchyRollup.Describe(ch)
+	b.cardinality.Describe(ch)
+	b.costShare.Describe(ch)
+	b.unallocated.Describe(ch)
+	b.unitPrice.Describe(ch)
+	b.monthlyCredits.Describe(ch)
+	b.monthlyAdjustments.Describe(ch)
+	b.corrections.Describe(ch)
+	b.discountRate.Describe(ch)
+	b.hourlyCosts.Describe(ch)
+	b.closedMonths.Describe(ch)
+	b.credentials.Describe(ch)
+	b.health.Describe(ch)
+	b.monthlyCosts.Describe(ch)
+	b.metricMonthlyRefunds.Describe(ch)
+	if b.topN != nil {
+		b.topN.Describe(ch)
+	}
+	if b.currencies != nil {
+		b.currencies.Describe(ch)
+	}
+	if b.dimensions != nil {
+		b.dimensions.Describe(ch)
+	}
+	if b.fiscal != nil {
+		b.fiscal.Describe(ch)
+	}
+	if b.weekly != nil {
+		b.weekly.Describe(ch)
+	}
+	if b.listPrices != nil {
+		b.listPrices.Describe(ch)
+	}
+	if b.allocations != nil {
+		b.allocations.Describe(ch)
+	}
 }
 
 func (b BillingCollector) Collect(ch chan<- prometheus.Metric) {
-	var wg sync.WaitGroup
+	if b.labelFilter.empty() {
+		b.collect(ch)
+		return
+	}
+	b.labelFilter.collect(b.collect, ch)
+}
+
+func (b BillingCollector) collect(ch chan<- prometheus.Metric) {
+	// collectors refreshed in the background are not queried per request
+	if b.refreshInterval == 0 {
+		b.query()
+	}
+	b.collectFiltered(func(ch chan<- prometheus.Metric) {
+		b.monthlyCosts.collect(b.metricMonthlyCosts, ch)
+	}, ch)
+	if b.dimensions != nil {
+		b.collectFiltered(func(ch chan<- prometheus.Metric) {
+			b.dimensions.collect(b.monthlyCosts.series(b.metricMonthlyCosts), ch)
+		}, ch)
+	}
+	b.collectFiltered(b.metricMonthlyRefunds.Collect, ch)
+	b.collectFiltered(b.hierarchyRollup.Collect, ch)
+	b.collectFiltered(b.cardinality.Collect, ch)
+
+	// the cost share gets the records of all clouds, independent of the
+	// clouds selected by collect[]
+	records := b.records()
+	allRecords := records
+	if b.cloudFilter != nil {
+		allRecords = b.allRecords()
+	}
+	b.collectFiltered(func(ch chan<- prometheus.Metric) {
+		b.costShare.collect(allRecords, ch)
+	}, ch)
+	b.monthlyCosts.collectCurrent(records, ch)
+	b.collectFiltered(b.corrections.Collect, ch)
+	b.unallocated.collect(records, b.monthlyCosts.recordLabels, ch)
+	if b.currencies != nil {
+		b.currencies.collect(records, b.monthlyCosts.recordLabels, ch)
+	}
+	if b.topN != nil {
+		b.topN.collect(records, ch)
+	}
+	if b.fiscal != nil || b.weekly != nil {
+		b.lineItemHistory.update(b.lineItems())
+	}
+	if b.fiscal != nil {
+		b.collectFiltered(b.fiscal.Collect, ch)
+	}
+	if b.weekly != nil {
+		b.collectFiltered(b.weekly.Collect, ch)
+	}
+	b.unitPrice.collect(b.usage(), ch)
+	credits := b.credits()
+	b.monthlyCredits.collect(credits, ch)
+	b.discountRate.collect(records, credits, ch)
+	b.monthlyAdjustments.collect(b.adjustments(), ch)
+	b.hourlyCosts.collect(b.hourly(), ch)
+	b.closedMonths.collect(b.closedMonthRecords(), ch)
+	b.credentials.collect(b.collectors, ch)
+	b.health.collect(b.collectors, ch)
+	if b.listPrices != nil {
+		b.collectFiltered(b.listPrices.Collect, ch)
+	}
+	if b.allocations != nil {
+		b.collectFiltered(b.allocations.Collect, ch)
+	}
+}
+
+// records returns the current costs of all collectors
+func (b BillingCollector) records() []billing.Record {
+	var records []billing.Record
 	for _, c := range b.collectors {
-		wg.Add(1)
-		go func(c cloudBillingCollector) {
-			defer wg.Done()
-			err := c.Query()
-			if err != nil {
-				log.Warnf("Error querying collector (%s): %s", c.String(), err)
-			}
-		}(c)
+		records = append(records, c.Records()...)
+	}
+	return records
+}
+
+// lineItems returns the line items of all collectors, including the ones not
+// selected by collect[]
+func (b BillingCollector) lineItems() []billing.LineItem {
+	collectors := b.collectors
+	if b.cloudFilter != nil {
+		collectors = b.allCollectors
+	}
+
+	var items []billing.LineItem
+	for _, c := range collectors {
+		if l, ok := c.(lineItemsCollector); ok {
+			items = append(items, l.LineItems()...)
+		}
+	}
+	return items
+}
+
+// usage returns the usage quantities of the collectors
+func (b BillingCollector) usage() []billing.Usage {
+	var usage []billing.Usage
+	for _, c := range b.collectors {
+		if u, ok := c.(usageCollector); ok {
+			usage = append(usage, u.Usage()...)
+		}
+	}
+	return usage
+}
+
+// credits returns the current credits of all collectors
+func (b BillingCollector) credits() []billing.Credit {
+	var credits []billing.Credit
+	for _, c := range b.collectors {
+		if cc, ok := c.(creditsCollector); ok {
+			credits = append(credits, cc.Credits()...)
+		}
+	}
+	return credits
+}
+
+// adjustments returns the taxes, credits and refunds of all collectors
+func (b BillingCollector) adjustments() []billing.Adjustment {
+	var adjustments []billing.Adjustment
+	for _, c := range b.collectors {
+		if ac, ok := c.(adjustmentsCollector); ok {
+			adjustments = append(adjustments, ac.Adjustments()...)
+		}
+	}
+	return adjustments
+}
+
+// hourly returns the costs per hour of the collectors
+func (b BillingCollector) hourly() []billing.HourlyCost {
+	var costs []billing.HourlyCost
+	for _, c := range b.collectors {
+		if h, ok := c.(hourlyCostsCollector); ok {
+			costs = append(costs, h.HourlyCosts()...)
+		}
 	}
+	return costs
+}
 
-	wg.Wait()
-	b.metricMonthlyCosts.Collect(ch)
+// closedMonthRecords returns the costs of the closed invoice months of the
+// collectors
+func (b BillingCollector) closedMonthRecords() []billing.Record {
+	var records []billing.Record
+	for _, c := range b.collectors {
+		if cm, ok := c.(closedMonthsCollector); ok {
+			records = append(records, cm.ClosedMonths()...)
+		}
+	}
+	return records
+}
+
+// allRecords returns the current costs of all collectors, including the ones
+// not selected by collect[]
+func (b BillingCollector) allRecords() []billing.Record {
+	var records []billing.Record
+	for _, c := range b.allCollectors {
+		records = append(records, c.Records()...)
+	}
+	return records
 }
 
 func main() {
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+type fakeCollector struct {
+	cloud   string
+	queries int
+	records []billing.Record
+}
+
+func (f *fakeCollector) Query() error {
+	f.queries++
+	return nil
+}
+
+func (f *fakeCollector) Test() error {
+	return nil
+}
+
+func (f *fakeCollector) String() string {
+	return "fake " + f.cloud
+}
+
+func (f *fakeCollector) Cloud() string {
+	return f.cloud
+}
+
+func (f *fakeCollector) Records() []billing.Record {
+	return f.records
+}
+
+func TestFiltered(t *testing.T) {
+	b := &BillingCollector{
+		collectors: []cloudBillingCollector{
+			&fakeCollector{cloud: "aws"},
+			&fakeCollector{cloud: "gcp"},
+		},
+	}
+
+	f, err := b.filtered([]string{"gcp"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(f.collectors) != 1 || f.collectors[0].Cloud() != "gcp" {
+		t.Errorf("unexpected collectors: %+v", f.collectors)
+	}
+	if len(b.collectors) != 2 {
+		t.Errorf("original collectors modified: %+v", b.collectors)
+	}
+
+	if _, err := b.filtered([]string{"azure"}); err == nil {
+		t.Error("expected error for unknown collector")
+	}
+}
+
+func TestFilteredAllocations(t *testing.T) {
+	b := &BillingCollector{
+		collectors: []cloudBillingCollector{
+			&fakeCollector{cloud: "aws"},
+		},
+		allocations: newAllocationCollector(nil, "kubernetes", "USD", time.Minute),
+	}
+
+	f, err := b.filtered([]string{"kubernetes"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(f.collectors) != 0 {
+		t.Errorf("unexpected collectors: %+v", f.collectors)
+	}
+	if !f.cloudFilter["kubernetes"] {
+		t.Error("expected the allocated costs to be collected")
+	}
+}
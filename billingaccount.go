@@ -0,0 +1,84 @@
+package main
+
+import (
+	"sync"
+)
+
+// billingAccountLabel is the label of the monthly costs set to the billing
+// account of the collector, e.g. the AWS payer account
+const billingAccountLabel = "billing_account"
+
+// billingAccountCollector is implemented by collectors, whose costs are
+// billed to a billing account
+type billingAccountCollector interface {
+	BillingAccount() string
+}
+
+// billingAccountEnricher labels the monthly costs with the billing account
+// of the collector reporting their account, to tell apart the costs of the
+// billing sources of a cloud
+type billingAccountEnricher struct {
+	collectors func() []cloudBillingCollector
+
+	lock     sync.Mutex
+	accounts map[[2]string]string
+}
+
+func newBillingAccountEnricher(b *BillingCollector) *billingAccountEnricher {
+	return &billingAccountEnricher{
+		collectors: func() []cloudBillingCollector {
+			return b.collectors
+		},
+		accounts: make(map[[2]string]string),
+	}
+}
+
+func (e *billingAccountEnricher) labels() []string {
+	return []string{billingAccountLabel}
+}
+
+// update maps the accounts of the current records to the billing account of
+// their collector
+func (e *billingAccountEnricher) update() {
+	accounts := make(map[[2]string]string)
+	for _, c := range e.collectors() {
+		bc, ok := c.(billingAccountCollector)
+		if !ok {
+			continue
+		}
+		billingAccount := bc.BillingAccount()
+		for _, r := range c.Records() {
+			accounts[[2]string{r.Cloud, r.Account}] = billingAccount
+		}
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.accounts = accounts
+}
+
+func (e *billingAccountEnricher) enrich(labels map[string]string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	labels[billingAccountLabel] = e.accounts[[2]string{labels["cloud"], labels["account"]}]
+}
+
+// billingAccounts returns true if the costs of the collectors are labeled by
+// their billing account, as several collectors of a cloud are configured or
+// billing accounts are set
+func billingAccounts(awsSettings, gcpSettings []*BillingCollector) bool {
+	if len(awsSettings) > 1 || len(gcpSettings) > 1 {
+		return true
+	}
+	for _, s := range awsSettings {
+		if *s.AWSBillingAccount != "" {
+			return true
+		}
+	}
+	for _, s := range gcpSettings {
+		if *s.GCPBillingAccount != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+type billingAccountTestCollector struct {
+	fakeCollector
+	billingAccount string
+}
+
+func (c *billingAccountTestCollector) BillingAccount() string {
+	return c.billingAccount
+}
+
+func TestBillingAccountEnricher(t *testing.T) {
+	b := &BillingCollector{
+		collectors: []cloudBillingCollector{
+			&billingAccountTestCollector{
+				fakeCollector:  fakeCollector{cloud: "aws", records: []billing.Record{{Cloud: "aws", Account: "acme-dev"}}},
+				billingAccount: "acme",
+			},
+			&billingAccountTestCollector{
+				fakeCollector:  fakeCollector{cloud: "aws", records: []billing.Record{{Cloud: "aws", Account: "labs-dev"}}},
+				billingAccount: "12340002",
+			},
+			&fakeCollector{cloud: "gcp", records: []billing.Record{{Cloud: "gcp", Account: "project-a"}}},
+		},
+	}
+	e := newBillingAccountEnricher(b)
+	e.update()
+
+	for _, c := range []struct {
+		cloud, account, exp string
+	}{
+		{cloud: "aws", account: "acme-dev", exp: "acme"},
+		{cloud: "aws", account: "labs-dev", exp: "12340002"},
+		{cloud: "gcp", account: "project-a", exp: ""},
+	} {
+		labels := map[string]string{"cloud": c.cloud, "account": c.account}
+		e.enrich(labels)
+		if exp := map[string]string{"cloud": c.cloud, "account": c.account, billingAccountLabel: c.exp}; !reflect.DeepEqual(labels, exp) {
+			t.Errorf("unexpected labels: %v (expected: %v)", labels, exp)
+		}
+	}
+}
+
+func TestBillingAccounts(t *testing.T) {
+	empty, acme := "", "acme"
+	single := []*BillingCollector{{AWSBillingAccount: &empty, GCPBillingAccount: &empty}}
+	if billingAccounts(single, single) {
+		t.Error("unexpected billing account label of a single collector")
+	}
+	if !billingAccounts(single, []*BillingCollector{{GCPBillingAccount: &acme}}) {
+		t.Error("expected billing account label of a collector with billing account")
+	}
+	if !billingAccounts(single, []*BillingCollector{{GCPBillingAccount: &empty}, {GCPBillingAccount: &empty}}) {
+		t.Error("expected billing account label of several collectors")
+	}
+}
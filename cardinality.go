@@ -0,0 +1,54 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// cardinalityCollector exposes how many series and services each account
+// contributes to the monthly costs metric
+type cardinalityCollector struct {
+	costs        *prometheus.CounterVec
+	descServices *prometheus.Desc
+	descSeries   *prometheus.Desc
+}
+
+func newCardinalityCollector(costs *prometheus.CounterVec) *cardinalityCollector {
+	return &cardinalityCollector{
+		costs: costs,
+		descServices: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "billing", "account_services"),
+			"Number of distinct services with costs per account.",
+			[]string{"cloud", "account"},
+			nil,
+		),
+		descSeries: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "billing", "account_series"),
+			"Number of monthly costs series per account.",
+			[]string{"cloud", "account"},
+			nil,
+		),
+	}
+}
+
+func (c *cardinalityCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.descServices
+	ch <- c.descSeries
+}
+
+func (c *cardinalityCollector) Collect(ch chan<- prometheus.Metric) {
+	services := make(map[[2]string]map[string]bool)
+	seriesCount := make(map[[2]string]int)
+	for _, s := range collectSeries(c.costs) {
+		key := [2]string{s.labels["cloud"], s.labels["account"]}
+		if _, ok := services[key]; !ok {
+			services[key] = make(map[string]bool)
+		}
+		services[key][s.labels["service"]] = true
+		seriesCount[key]++
+	}
+
+	for key, count := range seriesCount {
+		ch <- prometheus.MustNewConstMetric(c.descServices, prometheus.GaugeValue, float64(len(services[key])), key[:]...)
+		ch <- prometheus.MustNewConstMetric(c.descSeries, prometheus.GaugeValue, float64(count), key[:]...)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+func TestCardinality(t *testing.T) {
+	costs := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+	costs.WithLabelValues("aws", "USD", "dev", "AmazonEC2", "", "", "", "").Add(10)
+	costs.WithLabelValues("aws", "EUR", "dev", "AmazonEC2", "", "", "", "").Add(10)
+	costs.WithLabelValues("aws", "USD", "dev", "AmazonS3", "", "", "", "").Add(10)
+	costs.WithLabelValues("gcp", "USD", "prod", "compute", "", "", "", "").Add(10)
+
+	exp := `
+# HELP cloud_billing_account_series Number of monthly costs series per account.
+# TYPE cloud_billing_account_series gauge
+cloud_billing_account_series{account="dev",cloud="aws"} 3
+cloud_billing_account_series{account="prod",cloud="gcp"} 1
+# HELP cloud_billing_account_services Number of distinct services with costs per account.
+# TYPE cloud_billing_account_services gauge
+cloud_billing_account_services{account="dev",cloud="aws"} 2
+cloud_billing_account_services{account="prod",cloud="gcp"} 1
+`
+	if err := testutil.CollectAndCompare(newCardinalityCollector(costs), strings.NewReader(exp)); err != nil {
+		t.Error(err)
+	}
+}
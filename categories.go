@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// categoryLabel is the label of the monthly costs set by the category rules
+const categoryLabel = "category"
+
+// categoryRules map the monthly costs series to a category, maintained in a
+// configuration file like:
+//
+//	rules:
+//	- category: compute
+//	  cloud: aws
+//	  service: Amazon(EC2|ECS)
+//	- category: storage
+//	  service: AmazonS3|Cloud Storage
+//	default: other
+//
+// The first rule matching all of its regexes sets the category.
+type categoryRules struct {
+	Rules   []categoryRule `yaml:"rules"`
+	Default string         `yaml:"default"`
+}
+
+// categoryRule matches series by regexes on their cloud, account, service and
+// SKU. The SKU is taken from the sku label or is the part of the service after
+// the first slash, as appended in the SKU mode of BigQuery. Empty regexes
+// match any value.
+type categoryRule struct {
+	Category string `yaml:"category"`
+	Cloud    string `yaml:"cloud"`
+	Account  string `yaml:"account"`
+	Service  string `yaml:"service"`
+	SKU      string `yaml:"sku"`
+
+	matchers map[string]*regexp.Regexp
+}
+
+// loadCategoryRules reads the category rules from a YAML file
+func loadCategoryRules(path string) (*categoryRules, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseCategoryRules(data)
+}
+
+func parseCategoryRules(data []byte) (*categoryRules, error) {
+	c := &categoryRules{}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return nil, fmt.Errorf("error parsing category rules: %s", err)
+	}
+	for i := range c.Rules {
+		r := &c.Rules[i]
+		if r.Category == "" {
+			return nil, fmt.Errorf("category rule %d has no category", i+1)
+		}
+		r.matchers = make(map[string]*regexp.Regexp)
+		for name, expr := range map[string]string{"cloud": r.Cloud, "account": r.Account, "service": r.Service, "sku": r.SKU} {
+			if expr == "" {
+				continue
+			}
+			re, err := regexp.Compile("^(?:" + expr + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s regex of category rule %d: %s", name, i+1, err)
+			}
+			r.matchers[name] = re
+		}
+	}
+	return c, nil
+}
+
+func (c *categoryRules) labels() []string {
+	return []string{categoryLabel}
+}
+
+func (c *categoryRules) update() {}
+
+func (c *categoryRules) enrich(labels map[string]string) {
+	labels[categoryLabel] = c.category(labels)
+}
+
+// category returns the category of a series with the given labels
+func (c *categoryRules) category(labels map[string]string) string {
+	values := map[string]string{
+		"cloud":   labels["cloud"],
+		"account": labels["account"],
+		"service": labels["service"],
+	}
+	if sku := labels[skuLabel]; sku != "" {
+		values["sku"] = sku
+	} else if parts := strings.SplitN(labels["service"], "/", 2); len(parts) == 2 {
+		values["sku"] = parts[1]
+	}
+
+	for _, r := range c.Rules {
+		matched := true
+		for name, re := range r.matchers {
+			if !re.MatchString(values[name]) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return r.Category
+		}
+	}
+	return c.Default
+}
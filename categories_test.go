@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+const testCategoryRules = `
+rules:
+- category: compute
+  cloud: aws
+  service: Amazon(EC2|ECS)
+- category: licenses
+  sku: Licensing.*
+- category: storage
+  service: AmazonS3|Cloud Storage
+default: other
+`
+
+func TestCategoryRules(t *testing.T) {
+	c, err := parseCategoryRules([]byte(testCategoryRules))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, tc := range []struct {
+		labels map[string]string
+		exp    string
+	}{
+		{labels: map[string]string{"cloud": "aws", "service": "AmazonEC2"}, exp: "compute"},
+		{labels: map[string]string{"cloud": "gcp", "service": "AmazonEC2"}, exp: "other"},
+		{labels: map[string]string{"cloud": "gcp", "service": "Compute Engine/Licensing Fee for Windows"}, exp: "licenses"},
+		{labels: map[string]string{"cloud": "gcp", "service": "Compute Engine", "sku": "Licensing Fee for Windows"}, exp: "licenses"},
+		{labels: map[string]string{"cloud": "gcp", "service": "Cloud Storage"}, exp: "storage"},
+		{labels: map[string]string{"cloud": "gcp", "service": "Cloud Storage Transfer"}, exp: "other"},
+	} {
+		if act := c.category(tc.labels); act != tc.exp {
+			t.Errorf("unexpected category of %v: '%s' (expected: '%s')", tc.labels, act, tc.exp)
+		}
+	}
+
+	for _, invalid := range []string{"rules:\n- service: AmazonEC2\n", "rules:\n- category: x\n  service: '('\n", "unknown: x\n"} {
+		if _, err := parseCategoryRules([]byte(invalid)); err == nil {
+			t.Errorf("expected error parsing %q", invalid)
+		}
+	}
+}
+
+func TestMonthlyCostsCategories(t *testing.T) {
+	rules, err := parseCategoryRules([]byte(testCategoryRules))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	costs := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cloud_billing_monthly_costs",
+		Help: "Billed costs per calendar month.",
+	}, billing.MonthlyCostsLabels)
+	c := monthlyCostsTestCollector{monthlyCostsCollector: newMonthlyCostsCollector(0).withEnricher(rules), costs: costs}
+	if err := c.withGroupBy([]string{"category"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	costs.WithLabelValues("aws", "USD", "acme-dev", "AmazonEC2", "", "", "", "").Add(10)
+	costs.WithLabelValues("aws", "USD", "acme-prod", "AmazonECS", "", "", "", "").Add(20)
+	costs.WithLabelValues("aws", "USD", "acme-prod", "AmazonS3", "", "", "", "").Add(3)
+
+	exp := `
+# HELP cloud_billing_monthly_costs Billed costs per calendar month.
+# TYPE cloud_billing_monthly_costs counter
+cloud_billing_monthly_costs{category="compute",cloud="aws",currency="USD"} 30
+cloud_billing_monthly_costs{category="storage",cloud="aws",currency="USD"} 3
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(exp), "cloud_billing_monthly_costs"); err != nil {
+		t.Errorf("unexpected metrics: %s", err)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/common/log"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+const monthFormat = "2006-01"
+
+var chargebackHeader = []string{"month", "cloud", "account", "path", "owner", "cost_centre", "type", "currency", "costs"}
+
+// writeChargebackCSV writes the costs per account of the records as CSV
+func writeChargebackCSV(w io.Writer, records []billing.Record) error {
+	totals := make(map[[8]string]float64)
+	for _, r := range records {
+		totals[[8]string{r.Month, r.Cloud, r.Account, r.Path, r.Owner, r.CostCentre, r.Type, r.Currency}] += r.Costs
+	}
+
+	keys := make([][8]string, 0, len(totals))
+	for key := range totals {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		for k := range keys[i] {
+			if keys[i][k] != keys[j][k] {
+				return keys[i][k] < keys[j][k]
+			}
+		}
+		return false
+	})
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(chargebackHeader); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := cw.Write(append(key[:], strconv.FormatFloat(totals[key], 'f', 2, 64))); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// chargebackHandler serves the costs per account of a closed billing month
+func (b *BillingCollector) chargebackHandler(w http.ResponseWriter, r *http.Request) {
+	month := r.URL.Query().Get("month")
+	m, err := time.Parse(monthFormat, month)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid month '%s', expected format YYYY-MM", month), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	if !m.Before(time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)) {
+		http.Error(w, fmt.Sprintf("month '%s' is not closed yet", month), http.StatusBadRequest)
+		return
+	}
+
+	records, err := b.monthRecords(r.Context(), month)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error querying records: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if len(records) == 0 {
+		http.Error(w, fmt.Sprintf("no costs for month '%s' available", month), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"chargeback-%s.csv\"", month))
+	if err := writeChargebackCSV(w, records); err != nil {
+		log.Warnf("error writing chargeback CSV: %s", err)
+	}
+}
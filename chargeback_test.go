@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+func TestWriteChargebackCSV(t *testing.T) {
+	records := []billing.Record{
+		{Cloud: "gcp", Month: "2019-11", Currency: "USD", Account: "b", Service: "compute", Owner: "team-b", Costs: 1.5},
+		{Cloud: "aws", Month: "2019-11", Currency: "USD", Account: "a", Service: "AmazonEC2", Owner: "team-a", Costs: 10},
+		{Cloud: "aws", Month: "2019-11", Currency: "USD", Account: "a", Service: "AmazonS3", Owner: "team-a", Costs: 2.25},
+	}
+
+	var buf bytes.Buffer
+	if err := writeChargebackCSV(&buf, records); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	exp := `month,cloud,account,path,owner,cost_centre,type,currency,costs
+2019-11,aws,a,,team-a,,,USD,12.25
+2019-11,gcp,b,,team-b,,,USD,1.50
+`
+	if act := buf.String(); act != exp {
+		t.Errorf("unexpected CSV: act:\n%s\nexp:\n%s", act, exp)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+// closedMonthsCollector is implemented by collectors knowing the costs of
+// invoice months which are no longer open
+type closedMonthsCollector interface {
+	ClosedMonths() []billing.Record
+}
+
+// closedMonthCollector exposes the total costs of closed invoice months,
+// separately from the monthly costs counter of the open month
+type closedMonthCollector struct {
+	desc *prometheus.Desc
+}
+
+func newClosedMonthCollector() *closedMonthCollector {
+	return &closedMonthCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "billing", "closed_month_costs"),
+			"Total costs of a service in a closed invoice month.",
+			append(append([]string(nil), billing.MonthlyCostsLabels...), "month"),
+			nil,
+		),
+	}
+}
+
+func (c *closedMonthCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *closedMonthCollector) collect(records []billing.Record, ch chan<- prometheus.Metric) {
+	for _, r := range records {
+		m, err := prometheus.NewConstMetric(c.desc, prometheus.GaugeValue, r.Costs, append(r.Labels(), r.Month)...)
+		if err != nil {
+			log.Warnf("error exposing closed month costs %+v: %s", r, err)
+			continue
+		}
+		ch <- m
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+type closedMonthTestCollector struct {
+	*closedMonthCollector
+	records []billing.Record
+}
+
+func (c closedMonthTestCollector) Collect(ch chan<- prometheus.Metric) {
+	c.collect(c.records, ch)
+}
+
+func TestClosedMonthCollector(t *testing.T) {
+	c := closedMonthTestCollector{
+		closedMonthCollector: newClosedMonthCollector(),
+		records: []billing.Record{
+			{Cloud: "gcp", Month: "2019-10", Currency: "USD", Account: "project-a", Service: "compute-engine", Costs: 100},
+		},
+	}
+
+	exp := `
+# HELP cloud_billing_closed_month_costs Total costs of a service in a closed invoice month.
+# TYPE cloud_billing_closed_month_costs gauge
+cloud_billing_closed_month_costs{account="project-a",cloud="gcp",cost_centre="",currency="USD",month="2019-10",owner="",path="",service="compute-engine",type=""} 100
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(exp)); err != nil {
+		t.Errorf("unexpected metrics: %s", err)
+	}
+}
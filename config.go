@@ -0,0 +1,162 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// collectorFlagPrefixes are the prefixes of the flags of the collector types
+// of the config file, the settings of a collector are named like the flags
+// without prefix
+var collectorFlagPrefixes = map[string]string{
+	"aws":   "aws-billing.",
+	"gcp":   "gcp-billing.",
+	"azure": "azure-billing.",
+	"focus": "focus.",
+}
+
+// configFile sets flags and the billing collectors, e.g.
+//
+//	flags:
+//	  billing.top-n: 10
+//	collectors:
+//	- type: aws
+//	  settings:
+//	    bucket-name: acme-billing
+//	    account-map: [1200000=acme-dev, 120001=acme-prod]
+//	- type: aws
+//	  settings:
+//	    bucket-name: acme-labs-billing
+type configFile struct {
+	Flags      map[string]interface{} `yaml:"flags"`
+	Collectors []collectorConfig      `yaml:"collectors"`
+}
+
+// collectorConfig configures a collector of the type by its settings,
+// settings not given default to the values of their flags
+type collectorConfig struct {
+	Type     string                 `yaml:"type"`
+	Settings map[string]interface{} `yaml:"settings"`
+}
+
+// configValue returns the flag value of a setting, lists are comma separated
+func configValue(v interface{}) string {
+	if list, ok := v.([]interface{}); ok {
+		values := make([]string, len(list))
+		for i, e := range list {
+			values[i] = fmt.Sprint(e)
+		}
+		return strings.Join(values, ",")
+	}
+	return fmt.Sprint(v)
+}
+
+// sortedSettings returns the names of the settings in order, so errors are
+// reported deterministically
+func sortedSettings(settings map[string]interface{}) []string {
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func parseConfigFile(data []byte) (*configFile, error) {
+	c := &configFile{}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return nil, err
+	}
+	for i, collector := range c.Collectors {
+		if _, ok := collectorFlagPrefixes[collector.Type]; !ok {
+			return nil, fmt.Errorf("collector %d has unknown type '%s'", i+1, collector.Type)
+		}
+	}
+	return c, nil
+}
+
+// loadConfigFile applies the flags of the config file, which are not set on
+// the command line, and keeps its collectors
+func (b *BillingCollector) loadConfigFile(fs *flag.FlagSet, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	c, err := parseConfigFile(data)
+	if err != nil {
+		return fmt.Errorf("error parsing %s: %s", path, err)
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	for _, name := range sortedSettings(c.Flags) {
+		if set[name] {
+			continue
+		}
+		if err := fs.Set(name, configValue(c.Flags[name])); err != nil {
+			return fmt.Errorf("error setting flag %s of %s: %s", name, path, err)
+		}
+	}
+
+	b.configFlags = fs
+	b.configCollectors = c.Collectors
+	return nil
+}
+
+// collectorsOfType returns the settings of the collectors of the type. The
+// collectors of the config file replace the one configured by the flags,
+// whose values are the defaults of their settings.
+func (b *BillingCollector) collectorsOfType(typ string) ([]*BillingCollector, error) {
+	var collectors []*BillingCollector
+	for i, config := range b.configCollectors {
+		if config.Type != typ {
+			continue
+		}
+
+		c := *b
+		fs := flag.NewFlagSet(typ, flag.ContinueOnError)
+		c.registerCollectorFlags(fs)
+		var err error
+		fs.VisitAll(func(f *flag.Flag) {
+			if global := b.configFlags.Lookup(f.Name); global != nil && err == nil {
+				err = fs.Set(f.Name, global.Value.String())
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		prefix := collectorFlagPrefixes[typ]
+		for _, name := range sortedSettings(config.Settings) {
+			if fs.Lookup(prefix+name) == nil {
+				return nil, fmt.Errorf("collector %d has unknown %s setting '%s'", i+1, typ, name)
+			}
+			if err := fs.Set(prefix+name, configValue(config.Settings[name])); err != nil {
+				return nil, fmt.Errorf("invalid %s setting '%s' of collector %d: %s", typ, name, i+1, err)
+			}
+		}
+		collectors = append(collectors, &c)
+	}
+	if len(collectors) == 0 {
+		return []*BillingCollector{b}, nil
+	}
+	return collectors, nil
+}
+
+// configured returns true if the config file configures collectors of the
+// type
+func (b *BillingCollector) configured(typ string) bool {
+	for _, config := range b.configCollectors {
+		if config.Type == typ {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(`flags:
+  billing.top-n: 10
+  aws-billing.region: eu-central-1
+  aws-billing.owner-tag: team
+collectors:
+- type: aws
+  settings:
+    bucket-name: acme-billing
+    account-map: [1200000=acme-dev, 120001=acme-prod]
+- type: aws
+  settings:
+    bucket-name: acme-labs-billing
+    region: us-east-1
+    requester-pays: true
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b := &BillingCollector{}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	b.registerCollectorFlags(fs)
+	b.TopN = fs.Int("billing.top-n", 0, "")
+	if err := fs.Parse([]string{"-aws-billing.owner-tag=owner-email"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.loadConfigFile(fs, path); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if *b.TopN != 10 || *b.AWSRegion != "eu-central-1" {
+		t.Errorf("flags of the config file not applied: top-n=%d region=%s", *b.TopN, *b.AWSRegion)
+	}
+	if *b.AWSOwnerTag != "owner-email" {
+		t.Errorf("flag of the command line overridden: %s", *b.AWSOwnerTag)
+	}
+
+	collectors, err := b.collectorsOfType("aws")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(collectors) != 2 {
+		t.Fatalf("unexpected number of collectors: %d", len(collectors))
+	}
+	for i, exp := range []struct {
+		bucket, region, accountMap string
+		requesterPays              bool
+	}{
+		{bucket: "acme-billing", region: "eu-central-1", accountMap: "1200000=acme-dev,120001=acme-prod"},
+		{bucket: "acme-labs-billing", region: "us-east-1", requesterPays: true},
+	} {
+		c := collectors[i]
+		if *c.AWSBucketName != exp.bucket || *c.AWSRegion != exp.region || *c.AWSAccountMap != exp.accountMap || *c.AWSRequesterPays != exp.requesterPays {
+			t.Errorf("unexpected settings of collector %d: bucket=%s region=%s account-map=%s requester-pays=%t", i+1, *c.AWSBucketName, *c.AWSRegion, *c.AWSAccountMap, *c.AWSRequesterPays)
+		}
+		if *c.AWSOwnerTag != "owner-email" {
+			t.Errorf("unexpected owner tag of collector %d: %s", i+1, *c.AWSOwnerTag)
+		}
+	}
+	if *b.AWSBucketName != "" {
+		t.Errorf("settings of the collectors changed the flags: %s", *b.AWSBucketName)
+	}
+
+	// types without collectors in the file are configured by the flags
+	if collectors, err := b.collectorsOfType("gcp"); err != nil || len(collectors) != 1 || collectors[0] != b {
+		t.Errorf("unexpected gcp collectors: %v %v", collectors, err)
+	}
+	if !b.configured("aws") || b.configured("gcp") {
+		t.Error("unexpected configured collector types")
+	}
+}
+
+func TestConfigFileInvalid(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		config string
+	}{
+		{name: "unknown type", config: "collectors:\n- type: oracle\n"},
+		{name: "unknown field", config: "collector:\n- type: aws\n"},
+		{name: "unknown setting", config: "collectors:\n- type: aws\n  settings:\n    bucket: acme\n"},
+		{name: "invalid setting", config: "collectors:\n- type: aws\n  settings:\n    root-account-id: acme\n"},
+	} {
+		b := &BillingCollector{}
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		b.registerCollectorFlags(fs)
+		config, err := parseConfigFile([]byte(c.config))
+		if err == nil {
+			b.configFlags = fs
+			b.configCollectors = config.Collectors
+			_, err = b.collectorsOfType("aws")
+		}
+		if err == nil {
+			t.Errorf("%s: expected an error", c.name)
+		}
+	}
+}
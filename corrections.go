@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+// correctionCollector counts the downward corrections of the costs of the
+// latest reports, independent of how the monthly costs counter follows them,
+// so corrections by the providers are visible even if they are skipped
+type correctionCollector struct {
+	corrections *prometheus.CounterVec
+	corrected   *prometheus.CounterVec
+
+	lock sync.Mutex
+	// costs are the last costs per cloud, month and series
+	costs map[string]map[string]float64
+}
+
+func newCorrectionCollector() *correctionCollector {
+	return &correctionCollector{
+		corrections: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: prometheus.BuildFQName(Namespace, "billing", "cost_corrections_total"),
+				Help: "Number of monthly costs series whose costs were corrected downwards by a report.",
+			},
+			[]string{"cloud"},
+		),
+		corrected: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: prometheus.BuildFQName(Namespace, "billing", "cost_corrections_amount_total"),
+				Help: "Sum of the downward corrections of the monthly costs by the reports.",
+			},
+			[]string{"cloud", "currency"},
+		),
+		costs: make(map[string]map[string]float64),
+	}
+}
+
+func (c *correctionCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.corrections.Describe(ch)
+	c.corrected.Describe(ch)
+}
+
+func (c *correctionCollector) Collect(ch chan<- prometheus.Metric) {
+	c.corrections.Collect(ch)
+	c.corrected.Collect(ch)
+}
+
+// update compares the costs of the records with the ones last seen and counts
+// the falling ones. The costs of the clouds of the records replace the ones
+// last seen, series no longer reported are forgotten.
+func (c *correctionCollector) update(records []billing.Record) {
+	costs := make(map[string]map[string]float64)
+	currencies := make(map[string]string)
+	for _, r := range records {
+		if _, ok := costs[r.Cloud]; !ok {
+			costs[r.Cloud] = make(map[string]float64)
+		}
+		key := r.Month + "\x00" + strings.Join(r.Labels(), "\x00")
+		costs[r.Cloud][key] += r.Costs
+		currencies[key] = r.Currency
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for cloud, cloudCosts := range costs {
+		for key, value := range cloudCosts {
+			if previous, ok := c.costs[cloud][key]; ok && value < previous {
+				c.corrections.WithLabelValues(cloud).Inc()
+				c.corrected.WithLabelValues(cloud, currencies[key]).Add(previous - value)
+			}
+		}
+		c.costs[cloud] = cloudCosts
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+func TestCorrectionCollector(t *testing.T) {
+	c := newCorrectionCollector()
+	records := []billing.Record{
+		{Cloud: "gcp", Month: "2019-11", Currency: "USD", Account: "project-a", Service: "Compute Engine", Costs: 10},
+		{Cloud: "gcp", Month: "2019-11", Currency: "USD", Account: "project-a", Service: "Cloud Storage", Costs: 2},
+		{Cloud: "aws", Month: "2019-11", Currency: "USD", Account: "acme-dev", Service: "AmazonEC2", Costs: 5},
+	}
+	c.update(records)
+
+	// the provider corrects the costs downwards
+	records[0].Costs = 7.5
+	records[1].Costs = 3
+	c.update(records)
+	// corrections are only counted once
+	c.update(records)
+	// the costs of other clouds are kept
+	c.update(records[2:])
+	records[2].Costs = 4
+	c.update(records[2:])
+
+	exp := `
+# HELP cloud_billing_cost_corrections_amount_total Sum of the downward corrections of the monthly costs by the reports.
+# TYPE cloud_billing_cost_corrections_amount_total counter
+cloud_billing_cost_corrections_amount_total{cloud="aws",currency="USD"} 1
+cloud_billing_cost_corrections_amount_total{cloud="gcp",currency="USD"} 2.5
+# HELP cloud_billing_cost_corrections_total Number of monthly costs series whose costs were corrected downwards by a report.
+# TYPE cloud_billing_cost_corrections_total counter
+cloud_billing_cost_corrections_total{cloud="aws"} 1
+cloud_billing_cost_corrections_total{cloud="gcp"} 1
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(exp)); err != nil {
+		t.Errorf("unexpected metrics: %s", err)
+	}
+
+	// a new month starts over
+	c.update([]billing.Record{{Cloud: "gcp", Month: "2019-12", Currency: "USD", Account: "project-a", Service: "Compute Engine", Costs: 0.5}})
+	if err := testutil.CollectAndCompare(c, strings.NewReader(exp)); err != nil {
+		t.Errorf("unexpected metrics: %s", err)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+// credentialsChecker is implemented by collectors which can verify their
+// credentials with a cheap API call
+type credentialsChecker interface {
+	CheckCredentials(ctx context.Context) error
+}
+
+// credentialsCollector exposes whether the credentials of the collectors are
+// valid, they are checked in the given interval. This catches expiring keys
+// and revoked service accounts before the costs silently stop updating.
+type credentialsCollector struct {
+	desc     *prometheus.Desc
+	interval time.Duration
+	now      func() time.Time
+
+	lock      sync.Mutex
+	valid     map[string]bool
+	lastCheck map[string]time.Time
+}
+
+func newCredentialsCollector(interval time.Duration) *credentialsCollector {
+	return &credentialsCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "billing", "credentials_valid"),
+			"Whether the last check of the collector's credentials succeeded.",
+			[]string{"cloud", "collector"},
+			nil,
+		),
+		interval:  interval,
+		now:       time.Now,
+		valid:     make(map[string]bool),
+		lastCheck: make(map[string]time.Time),
+	}
+}
+
+func (c *credentialsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *credentialsCollector) collect(collectors []cloudBillingCollector, ch chan<- prometheus.Metric) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, collector := range collectors {
+		checker, ok := collector.(credentialsChecker)
+		if !ok {
+			continue
+		}
+
+		name := collector.String()
+		if last, ok := c.lastCheck[name]; !ok || c.now().Sub(last) >= c.interval {
+			err := checker.CheckCredentials(context.Background())
+			if err != nil {
+				log.Warnf("error checking credentials of %s: %s", name, err)
+			}
+			c.valid[name] = err == nil
+			c.lastCheck[name] = c.now()
+		}
+
+		value := 0.0
+		if c.valid[name] {
+			value = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, value, collector.Cloud(), name)
+	}
+}
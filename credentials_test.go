@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type checkingCollector struct {
+	fakeCollector
+	err    error
+	checks int
+}
+
+func (c *checkingCollector) CheckCredentials(context.Context) error {
+	c.checks++
+	return c.err
+}
+
+type credentialsTestCollector struct {
+	*credentialsCollector
+	collectors []cloudBillingCollector
+}
+
+func (c credentialsTestCollector) Collect(ch chan<- prometheus.Metric) {
+	c.collect(c.collectors, ch)
+}
+
+func TestCredentialsCollector(t *testing.T) {
+	aws := &checkingCollector{fakeCollector: fakeCollector{cloud: "aws"}}
+	gcp := &checkingCollector{fakeCollector: fakeCollector{cloud: "gcp"}, err: errors.New("invalid_grant")}
+	now := time.Date(2019, 11, 3, 10, 0, 0, 0, time.UTC)
+	c := credentialsTestCollector{
+		credentialsCollector: newCredentialsCollector(time.Hour),
+		collectors: []cloudBillingCollector{
+			aws,
+			gcp,
+			// collectors without credentials check are skipped
+			&fakeCollector{cloud: "focus"},
+		},
+	}
+	c.now = func() time.Time { return now }
+
+	exp := `
+# HELP cloud_billing_credentials_valid Whether the last check of the collector's credentials succeeded.
+# TYPE cloud_billing_credentials_valid gauge
+cloud_billing_credentials_valid{cloud="aws",collector="fake aws"} 1
+cloud_billing_credentials_valid{cloud="gcp",collector="fake gcp"} 0
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(exp)); err != nil {
+		t.Errorf("unexpected metrics: %s", err)
+	}
+
+	// checked again after the interval
+	gcp.err = nil
+	if err := testutil.CollectAndCompare(c, strings.NewReader(exp)); err != nil {
+		t.Errorf("unexpected metrics: %s", err)
+	}
+	now = now.Add(time.Hour)
+	if err := testutil.CollectAndCompare(c, strings.NewReader(strings.Replace(exp, `collector="fake gcp"} 0`, `collector="fake gcp"} 1`, 1))); err != nil {
+		t.Errorf("unexpected metrics: %s", err)
+	}
+	if act, exp := aws.checks, 2; act != exp {
+		t.Errorf("unexpected number of checks: %d (expected: %d)", act, exp)
+	}
+}
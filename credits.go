@@ -0,0 +1,117 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+// creditsCollector is implemented by collectors exposing the credits granted
+// on the costs of their parsed reports
+type creditsCollector interface {
+	Credits() []billing.Credit
+}
+
+// creditCollector exposes the month-to-date credits per credit type and ID,
+// so committed use, free tier and promotional credits can be told apart
+type creditCollector struct {
+	desc *prometheus.Desc
+}
+
+func newCreditCollector() *creditCollector {
+	return &creditCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "billing", "monthly_credits"),
+			"Month-to-date credits granted on the costs of a service, per credit type and ID.",
+			billing.CreditLabels,
+			nil,
+		),
+	}
+}
+
+func (c *creditCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *creditCollector) collect(credits []billing.Credit, ch chan<- prometheus.Metric) {
+	// the latest month wins, if a collector reports multiple months
+	latest := make(map[string]billing.Credit)
+	for _, credit := range credits {
+		key := strings.Join(credit.Labels(), "\x00")
+		if previous, ok := latest[key]; ok {
+			if previous.Month > credit.Month {
+				continue
+			}
+			if previous.Month == credit.Month {
+				credit.Amount += previous.Amount
+			}
+		}
+		latest[key] = credit
+	}
+
+	for _, credit := range latest {
+		m, err := prometheus.NewConstMetric(c.desc, prometheus.GaugeValue, credit.Amount, credit.Labels()...)
+		if err != nil {
+			log.Warnf("error exposing credit %+v: %s", credit, err)
+			continue
+		}
+		ch <- m
+	}
+}
+
+// discountRateCollector exposes the negotiated discounts of each service
+// relative to its costs before credits, so the effective discount rates can
+// be tracked over time and compared against the contract
+type discountRateCollector struct {
+	desc *prometheus.Desc
+}
+
+func newDiscountRateCollector() *discountRateCollector {
+	return &discountRateCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "billing", "negotiated_discount_ratio"),
+			"Month-to-date negotiated discounts of a service divided by its costs before credits.",
+			[]string{"cloud", "currency", "service"},
+			nil,
+		),
+	}
+}
+
+func (c *discountRateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *discountRateCollector) collect(records []billing.Record, credits []billing.Credit, ch chan<- prometheus.Metric) {
+	discounts := make(map[[4]string]float64)
+	for _, credit := range credits {
+		if credit.CreditType == billing.CreditTypeNegotiated {
+			discounts[[4]string{credit.Cloud, credit.Currency, credit.Service, credit.Month}] += credit.Amount
+		}
+	}
+	if len(discounts) == 0 {
+		return
+	}
+	costs := make(map[[4]string]float64)
+	for _, r := range records {
+		costs[[4]string{r.Cloud, r.Currency, r.Service, r.Month}] += r.Costs
+	}
+
+	// the latest month wins, if a collector reports multiple months
+	latest := make(map[[3]string]string)
+	for key := range discounts {
+		series := [3]string{key[0], key[1], key[2]}
+		if month, ok := latest[series]; !ok || key[3] > month {
+			latest[series] = key[3]
+		}
+	}
+	for series, month := range latest {
+		key := [4]string{series[0], series[1], series[2], month}
+		if costs[key] <= 0 {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, discounts[key]/costs[key], series[:]...)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+type creditTestCollector struct {
+	*creditCollector
+	credits []billing.Credit
+}
+
+func (c creditTestCollector) Collect(ch chan<- prometheus.Metric) {
+	c.collect(c.credits, ch)
+}
+
+func TestCreditCollector(t *testing.T) {
+	c := creditTestCollector{
+		creditCollector: newCreditCollector(),
+		credits: []billing.Credit{
+			{Cloud: "gcp", Month: "2019-10", Currency: "USD", Account: "project-a", Service: "compute-engine", CreditType: billing.CreditTypeSustainedUse, CreditID: "SustainedUsageDiscount", Amount: 20},
+			{Cloud: "gcp", Month: "2019-11", Currency: "USD", Account: "project-a", Service: "compute-engine", CreditType: billing.CreditTypeSustainedUse, CreditID: "SustainedUsageDiscount", Amount: 1.5},
+			{Cloud: "gcp", Month: "2019-11", Currency: "USD", Account: "project-a", Service: "compute-engine", CreditType: billing.CreditTypeSustainedUse, CreditID: "SustainedUsageDiscount", Amount: 0.5},
+			{Cloud: "gcp", Month: "2019-11", Currency: "USD", Account: "project-a", Service: "compute-engine", CreditType: billing.CreditTypePromotion, CreditID: "FreeTrial:012345", Amount: 3},
+		},
+	}
+
+	exp := `
+# HELP cloud_billing_monthly_credits Month-to-date credits granted on the costs of a service, per credit type and ID.
+# TYPE cloud_billing_monthly_credits gauge
+cloud_billing_monthly_credits{account="project-a",cloud="gcp",cost_centre="",credit_id="FreeTrial:012345",credit_type="promotion",currency="USD",owner="",path="",service="compute-engine",type=""} 3
+cloud_billing_monthly_credits{account="project-a",cloud="gcp",cost_centre="",credit_id="SustainedUsageDiscount",credit_type="sustained_use_discount",currency="USD",owner="",path="",service="compute-engine",type=""} 2
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(exp)); err != nil {
+		t.Errorf("unexpected metrics: %s", err)
+	}
+}
+
+func TestDiscountRateCollector(t *testing.T) {
+	c := newDiscountRateCollector()
+	records := []billing.Record{
+		{Cloud: "gcp", Month: "2019-11", Currency: "USD", Account: "project-a", Service: "compute-engine", Costs: 60},
+		{Cloud: "gcp", Month: "2019-11", Currency: "USD", Account: "project-b", Service: "compute-engine", Costs: 40},
+		{Cloud: "gcp", Month: "2019-11", Currency: "USD", Account: "project-a", Service: "bigquery", Costs: 10},
+	}
+	credits := []billing.Credit{
+		{Cloud: "gcp", Month: "2019-11", Currency: "USD", Account: "project-a", Service: "compute-engine", CreditType: billing.CreditTypeNegotiated, CreditID: "Spending based discount (contractual)", Amount: 6},
+		{Cloud: "gcp", Month: "2019-11", Currency: "USD", Account: "project-b", Service: "compute-engine", CreditType: billing.CreditTypeNegotiated, CreditID: "Spending based discount (contractual)", Amount: 4},
+		{Cloud: "gcp", Month: "2019-11", Currency: "USD", Account: "project-a", Service: "compute-engine", CreditType: billing.CreditTypeSustainedUse, CreditID: "SustainedUsageDiscount", Amount: 20},
+		// no costs of the month
+		{Cloud: "gcp", Month: "2019-10", Currency: "USD", Account: "project-a", Service: "cloud-storage", CreditType: billing.CreditTypeNegotiated, CreditID: "DISCOUNT", Amount: 1},
+	}
+
+	exp := `
+# HELP cloud_billing_negotiated_discount_ratio Month-to-date negotiated discounts of a service divided by its costs before credits.
+# TYPE cloud_billing_negotiated_discount_ratio gauge
+cloud_billing_negotiated_discount_ratio{cloud="gcp",currency="USD",service="compute-engine"} 0.1
+`
+	rc := &recordsCollector{records: records, describe: c.Describe, collect: func(records []billing.Record, ch chan<- prometheus.Metric) {
+		c.collect(records, credits, ch)
+	}}
+	if err := testutil.CollectAndCompare(rc, strings.NewReader(exp)); err != nil {
+		t.Errorf("unexpected metrics: %s", err)
+	}
+}
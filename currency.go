@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+const (
+	// exchangeRatesTimeout limits the time of fetching the exchange rates
+	exchangeRatesTimeout = 10 * time.Second
+	// exchangeRatesRetry is the time after which failed fetches are retried
+	exchangeRatesRetry = time.Minute
+)
+
+// ecbRates are the daily reference rates of the European Central Bank, e.g.
+// https://www.ecb.europa.eu/stats/eurofxref/eurofxref-daily.xml, given in
+// units of the currency per euro
+type ecbRates struct {
+	Rates []struct {
+		Currency string  `xml:"currency,attr"`
+		Rate     float64 `xml:"rate,attr"`
+	} `xml:"Cube>Cube>Cube"`
+}
+
+// currencyConverter exposes the month-to-date costs converted to the target
+// currency. The exchange rates are given statically or fetched from the
+// reference rates of the European Central Bank, static rates take
+// precedence. The rates are fetched in the background in the interval and
+// kept, if a fetch fails.
+type currencyConverter struct {
+	target     string
+	static     map[string]float64
+	url        string
+	interval   time.Duration
+	httpClient *http.Client
+
+	labels    []string
+	costsDesc *prometheus.Desc
+	rateDesc  *prometheus.Desc
+
+	lock    sync.Mutex
+	fetched map[string]float64
+	warned  map[string]bool
+}
+
+// newCurrencyConverter converts the costs to the target currency using the
+// static rates, given as currency=rate in units of the target currency per
+// unit of the currency, e.g. USD=0.92,GBP=1.17, and the reference rates
+// fetched from the URL
+func newCurrencyConverter(target, rates, url string, interval time.Duration) (*currencyConverter, error) {
+	c := &currencyConverter{
+		target:     strings.ToUpper(strings.TrimSpace(target)),
+		static:     make(map[string]float64),
+		url:        url,
+		interval:   interval,
+		httpClient: &http.Client{Timeout: exchangeRatesTimeout},
+		fetched:    make(map[string]float64),
+		warned:     make(map[string]bool),
+		rateDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "billing", "exchange_rate"),
+			"Units of the target currency per unit of the currency used to convert the costs.",
+			[]string{"currency", "target_currency"},
+			nil,
+		),
+	}
+	for _, rate := range strings.Split(rates, ",") {
+		if strings.TrimSpace(rate) == "" {
+			continue
+		}
+		parts := strings.SplitN(rate, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid exchange rate '%s', expected currency=rate", rate)
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil || value <= 0 {
+			return nil, fmt.Errorf("invalid exchange rate '%s', expected a positive number", rate)
+		}
+		c.static[strings.ToUpper(strings.TrimSpace(parts[0]))] = value
+	}
+	if len(c.static) == 0 && c.url == "" {
+		return nil, fmt.Errorf("no exchange rates to %s given", c.target)
+	}
+	return c.withLabels(billing.MonthlyCostsLabels), nil
+}
+
+// withLabels exposes the converted costs by the given labels of the monthly
+// costs
+func (c *currencyConverter) withLabels(labels []string) *currencyConverter {
+	c.labels = labels
+	c.costsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "billing", "monthly_costs_converted"),
+		"Month-to-date costs of the latest billing report converted to the target currency.",
+		append(append([]string{}, labels...), "target_currency"),
+		nil,
+	)
+	return c
+}
+
+func (c *currencyConverter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.costsDesc
+	ch <- c.rateDesc
+}
+
+// fetch returns the rates of the URL in units of the target currency per
+// unit of the currency
+func (c *currencyConverter) fetch(ctx context.Context) (map[string]float64, error) {
+	req, err := http.NewRequest(http.MethodGet, c.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var ecb ecbRates
+	if err := xml.NewDecoder(resp.Body).Decode(&ecb); err != nil {
+		return nil, fmt.Errorf("error decoding exchange rates: %s", err)
+	}
+	perEuro := map[string]float64{"EUR": 1}
+	for _, r := range ecb.Rates {
+		if r.Rate > 0 {
+			perEuro[strings.ToUpper(r.Currency)] = r.Rate
+		}
+	}
+	target, ok := perEuro[c.target]
+	if !ok {
+		return nil, fmt.Errorf("no exchange rate of %s found", c.target)
+	}
+	rates := make(map[string]float64, len(perEuro))
+	for currency, rate := range perEuro {
+		rates[currency] = target / rate
+	}
+	return rates, nil
+}
+
+// refresh fetches the rates of the URL, the previous rates are kept if the
+// fetch fails
+func (c *currencyConverter) refresh(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, exchangeRatesTimeout)
+	defer cancel()
+	fetched, err := c.fetch(ctx)
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.fetched = fetched
+	return nil
+}
+
+// run refreshes the rates right away and then in the interval, failed
+// fetches are retried earlier
+func (c *currencyConverter) run() {
+	if c.url == "" {
+		return
+	}
+	for {
+		wait := c.interval
+		if err := c.refresh(context.Background()); err != nil {
+			log.Warnf("error fetching exchange rates from %s: %s", c.url, err)
+			wait = exchangeRatesRetry
+		}
+		time.Sleep(wait)
+	}
+}
+
+// rates returns the exchange rates to the target currency
+func (c *currencyConverter) rates() map[string]float64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	rates := map[string]float64{c.target: 1}
+	for currency, rate := range c.fetched {
+		rates[currency] = rate
+	}
+	for currency, rate := range c.static {
+		rates[currency] = rate
+	}
+	return rates
+}
+
+// collect sums up the converted costs of the records by the labels, which
+// are looked up including the ones of the enrichers. Costs in currencies
+// without exchange rate are left out.
+func (c *currencyConverter) collect(records []billing.Record, labels func(billing.Record) map[string]string, ch chan<- prometheus.Metric) {
+	rates := c.rates()
+
+	groups := make(map[string][]string)
+	costs := make(map[string]float64)
+	for _, r := range records {
+		rate, ok := rates[r.Currency]
+		if !ok {
+			c.lock.Lock()
+			if !c.warned[r.Currency] {
+				c.warned[r.Currency] = true
+				log.Warnf("no exchange rate of %s to %s, its costs aren't converted", r.Currency, c.target)
+			}
+			c.lock.Unlock()
+			continue
+		}
+		recordLabels := labels(r)
+		values := make([]string, len(c.labels), len(c.labels)+1)
+		for i, name := range c.labels {
+			values[i] = recordLabels[name]
+		}
+		values = append(values, c.target)
+		key := strings.Join(values, "\x00")
+		groups[key] = values
+		costs[key] += r.Costs * rate
+	}
+	for key, values := range groups {
+		ch <- prometheus.MustNewConstMetric(c.costsDesc, prometheus.GaugeValue, costs[key], values...)
+	}
+
+	currencies := make([]string, 0, len(rates))
+	for currency := range rates {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+	for _, currency := range currencies {
+		ch <- prometheus.MustNewConstMetric(c.rateDesc, prometheus.GaugeValue, rates[currency], currency, c.target)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+const fakeECBRates = `<?xml version="1.0" encoding="UTF-8"?>
+<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01" xmlns="http://www.ecb.int/vocabulary/2002-08-01/eurofxref">
+	<gesmes:subject>Reference rates</gesmes:subject>
+	<Cube>
+		<Cube time="2019-11-29">
+			<Cube currency="USD" rate="1.25"/>
+			<Cube currency="GBP" rate="0.8"/>
+		</Cube>
+	</Cube>
+</gesmes:Envelope>`
+
+func TestCurrencyConverter(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprint(w, fakeECBRates)
+	}))
+	defer server.Close()
+
+	monthlyCosts := newMonthlyCostsCollector(0)
+	if err := monthlyCosts.withGroupBy([]string{"account"}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := newCurrencyConverter("eur", "GBP=1.2", server.URL, time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c.withLabels(monthlyCosts.labels)
+
+	records := []billing.Record{
+		{Cloud: "aws", Currency: "USD", Account: "a", Service: "AmazonEC2", Costs: 60},
+		{Cloud: "aws", Currency: "USD", Account: "a", Service: "AmazonS3", Costs: 40},
+		{Cloud: "aws", Currency: "EUR", Account: "b", Service: "AmazonEC2", Costs: 5},
+		{Cloud: "gcp", Currency: "GBP", Account: "a", Service: "compute", Costs: 10},
+		{Cloud: "gcp", Currency: "JPY", Account: "c", Service: "compute", Costs: 1000},
+	}
+	collect := func(records []billing.Record, ch chan<- prometheus.Metric) {
+		c.collect(records, monthlyCosts.recordLabels, ch)
+	}
+
+	// the static rate of GBP takes precedence, costs in JPY have no rate
+	exp := `
+# HELP cloud_billing_exchange_rate Units of the target currency per unit of the currency used to convert the costs.
+# TYPE cloud_billing_exchange_rate gauge
+cloud_billing_exchange_rate{currency="EUR",target_currency="EUR"} 1
+cloud_billing_exchange_rate{currency="GBP",target_currency="EUR"} 1.2
+cloud_billing_exchange_rate{currency="USD",target_currency="EUR"} 0.8
+# HELP cloud_billing_monthly_costs_converted Month-to-date costs of the latest billing report converted to the target currency.
+# TYPE cloud_billing_monthly_costs_converted gauge
+cloud_billing_monthly_costs_converted{account="a",cloud="aws",currency="USD",target_currency="EUR"} 80
+cloud_billing_monthly_costs_converted{account="a",cloud="gcp",currency="GBP",target_currency="EUR"} 12
+cloud_billing_monthly_costs_converted{account="b",cloud="aws",currency="EUR",target_currency="EUR"} 5
+`
+	rc := &recordsCollector{records: records, describe: c.Describe, collect: collect}
+
+	// the rates are only fetched in the background
+	if rates := c.rates(); len(rates) != 2 || requests != 0 {
+		t.Errorf("unexpected rates before the first fetch: %v, %d requests", rates, requests)
+	}
+	if err := c.refresh(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := testutil.CollectAndCompare(rc, strings.NewReader(exp)); err != nil {
+			t.Error(err)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("expected the rates to be cached, got %d requests", requests)
+	}
+
+	// failed fetches keep the previous rates
+	c.url = server.URL + "/%zz"
+	if err := c.refresh(context.Background()); err == nil {
+		t.Error("expected an error fetching the rates")
+	}
+	if err := testutil.CollectAndCompare(rc, strings.NewReader(exp)); err != nil {
+		t.Error(err)
+	}
+
+	for _, rates := range []string{"", "USD", "USD=abc", "USD=-1"} {
+		if _, err := newCurrencyConverter("EUR", rates, "", time.Hour); err == nil {
+			t.Errorf("expected an error for the rates '%s'", rates)
+		}
+	}
+}
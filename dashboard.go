@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type grafanaDashboard struct {
+	Title         string            `json:"title"`
+	UID           string            `json:"uid"`
+	Tags          []string          `json:"tags"`
+	Editable      bool              `json:"editable"`
+	SchemaVersion int               `json:"schemaVersion"`
+	Time          grafanaTimeRange  `json:"time"`
+	Templating    grafanaTemplating `json:"templating"`
+	Panels        []*grafanaPanel   `json:"panels"`
+}
+
+type grafanaTimeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type grafanaTemplating struct {
+	List []grafanaVariable `json:"list"`
+}
+
+type grafanaVariable struct {
+	Name       string `json:"name"`
+	Label      string `json:"label,omitempty"`
+	Type       string `json:"type"`
+	Datasource string `json:"datasource,omitempty"`
+	Query      string `json:"query"`
+	Refresh    int    `json:"refresh,omitempty"`
+	Multi      bool   `json:"multi"`
+	IncludeAll bool   `json:"includeAll"`
+}
+
+type grafanaGridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type grafanaTarget struct {
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat,omitempty"`
+	Format       string `json:"format,omitempty"`
+	Instant      bool   `json:"instant,omitempty"`
+	RefID        string `json:"refId"`
+}
+
+type grafanaPanel struct {
+	ID          int             `json:"id"`
+	Title       string          `json:"title"`
+	Type        string          `json:"type"`
+	Datasource  string          `json:"datasource,omitempty"`
+	Description string          `json:"description,omitempty"`
+	GridPos     grafanaGridPos  `json:"gridPos"`
+	Stack       bool            `json:"stack,omitempty"`
+	Collapsed   bool            `json:"collapsed,omitempty"`
+	Targets     []grafanaTarget `json:"targets,omitempty"`
+}
+
+// dashboardBuilder lays out panels in rows on Grafana's 24 column grid
+type dashboardBuilder struct {
+	panels []*grafanaPanel
+	x, y   int
+	rowH   int
+}
+
+func (d *dashboardBuilder) row(title string) {
+	if d.x > 0 {
+		d.y += d.rowH
+		d.x = 0
+	}
+	d.panels = append(d.panels, &grafanaPanel{
+		ID:      len(d.panels) + 1,
+		Title:   title,
+		Type:    "row",
+		GridPos: grafanaGridPos{H: 1, W: 24, X: 0, Y: d.y},
+	})
+	d.y++
+	d.rowH = 0
+}
+
+func (d *dashboardBuilder) panel(p *grafanaPanel, w, h int) {
+	if d.x+w > 24 {
+		d.y += d.rowH
+		d.x = 0
+		d.rowH = 0
+	}
+	p.ID = len(d.panels) + 1
+	p.Datasource = "$datasource"
+	p.GridPos = grafanaGridPos{H: h, W: w, X: d.x, Y: d.y}
+	for i := range p.Targets {
+		p.Targets[i].RefID = string(rune('A' + i))
+	}
+	d.panels = append(d.panels, p)
+	d.x += w
+	if h > d.rowH {
+		d.rowH = h
+	}
+}
+
+// dashboard builds a Grafana dashboard for the given label names of the
+// monthly costs metric
+func dashboard(title string, labels []string) *grafanaDashboard {
+	costs := prometheus.BuildFQName(Namespace, "billing", "monthly_costs")
+	current := prometheus.BuildFQName(Namespace, "billing", "monthly_costs_current")
+	credits := prometheus.BuildFQName(Namespace, "billing", "monthly_credits")
+	selector := `{cloud=~"$cloud",account=~"$account"}`
+
+	dailyBy := func(metric string, by ...string) string {
+		return fmt.Sprintf(
+			"sum by (%s) (increase(%s%s[1d]))",
+			strings.Join(by, ", "),
+			metric,
+			selector,
+		)
+	}
+	legend := func(by ...string) string {
+		parts := make([]string, len(by))
+		for i, l := range by {
+			parts[i] = fmt.Sprintf("{{%s}}", l)
+		}
+		return strings.Join(parts, " ")
+	}
+
+	d := &dashboardBuilder{}
+
+	d.row("Overview")
+	d.panel(&grafanaPanel{
+		Title:       "Costs in time range",
+		Type:        "stat",
+		Description: "Costs billed within the selected time range per cloud.",
+		Targets: []grafanaTarget{{
+			Expr:         fmt.Sprintf("sum by (cloud, currency) (increase(%s%s[$__range]))", costs, selector),
+			LegendFormat: legend("cloud", "currency"),
+			Instant:      true,
+		}},
+	}, 8, 8)
+	d.panel(&grafanaPanel{
+		Title: "Daily costs per cloud",
+		Type:  "graph",
+		Stack: true,
+		Targets: []grafanaTarget{{
+			Expr:         dailyBy(costs, "cloud", "currency"),
+			LegendFormat: legend("cloud", "currency"),
+		}},
+	}, 16, 8)
+
+	d.row("Accounts")
+	d.panel(&grafanaPanel{
+		Title: "Daily costs per account",
+		Type:  "graph",
+		Stack: true,
+		Targets: []grafanaTarget{{
+			Expr:         dailyBy(costs, "cloud", "account", "currency"),
+			LegendFormat: legend("cloud", "account", "currency"),
+		}},
+	}, 12, 8)
+	d.panel(&grafanaPanel{
+		Title: "Daily costs per service",
+		Type:  "graph",
+		Stack: true,
+		Targets: []grafanaTarget{{
+			Expr:         dailyBy(costs, "cloud", "service", "currency"),
+			LegendFormat: legend("cloud", "service", "currency"),
+		}},
+	}, 12, 8)
+	d.panel(&grafanaPanel{
+		Title: "Costs per account and service in time range",
+		Type:  "table",
+		Targets: []grafanaTarget{{
+			Expr:    fmt.Sprintf("sum by (cloud, account, service, currency) (increase(%s%s[$__range])) > 0", costs, selector),
+			Format:  "table",
+			Instant: true,
+		}},
+	}, 24, 10)
+
+	var breakdown []string
+	for _, l := range labels {
+		if !fixedCostsLabels[l] {
+			breakdown = append(breakdown, l)
+		}
+	}
+	d.row("Attribution")
+	for _, l := range breakdown {
+		d.panel(&grafanaPanel{
+			Title: fmt.Sprintf("Daily costs per %s", l),
+			Type:  "graph",
+			Stack: true,
+			Targets: []grafanaTarget{{
+				Expr:         dailyBy(costs, l, "currency"),
+				LegendFormat: legend(l, "currency"),
+			}},
+		}, 12, 8)
+	}
+	d.panel(&grafanaPanel{
+		Title:       "Unallocated costs ratio per account",
+		Type:        "graph",
+		Description: "Share of the month-to-date costs without an owner, team or cost centre.",
+		Targets: []grafanaTarget{{
+			Expr:         fmt.Sprintf("%s%s", prometheus.BuildFQName(Namespace, "billing", "unallocated_costs_ratio"), selector),
+			LegendFormat: legend("cloud", "account", "currency"),
+		}},
+	}, 12, 8)
+
+	d.row("Credits")
+	d.panel(&grafanaPanel{
+		Title:       "Month-to-date credits per type",
+		Type:        "graph",
+		Description: "Sustained use, committed use, free tier and promotional credits granted on the costs.",
+		Stack:       true,
+		Targets: []grafanaTarget{{
+			Expr:         fmt.Sprintf("sum by (cloud, credit_type, currency) (%s%s)", credits, selector),
+			LegendFormat: legend("cloud", "credit_type", "currency"),
+		}},
+	}, 12, 8)
+	grossCosts := fmt.Sprintf("sum by (cloud, currency) (%s%s)", current, selector)
+	d.panel(&grafanaPanel{
+		Title:       "Month-to-date gross and net costs per cloud",
+		Type:        "graph",
+		Description: "Costs of the latest billing reports before and after credits.",
+		Targets: []grafanaTarget{{
+			Expr:         grossCosts,
+			LegendFormat: legend("cloud", "currency") + " gross",
+		}, {
+			Expr:         fmt.Sprintf("%s - (sum by (cloud, currency) (%s%s) or %s * 0)", grossCosts, credits, selector, grossCosts),
+			LegendFormat: legend("cloud", "currency") + " net",
+		}},
+	}, 12, 8)
+
+	d.row("Forecast")
+	d.panel(&grafanaPanel{
+		Title:       "Projected costs for the next 30 days",
+		Type:        "stat",
+		Description: "Extrapolated from the average spend of the last 7 days.",
+		Targets: []grafanaTarget{{
+			Expr:         fmt.Sprintf("sum by (cloud, currency) (rate(%s%s[7d])) * 86400 * 30", costs, selector),
+			LegendFormat: legend("cloud", "currency"),
+			Instant:      true,
+		}},
+	}, 8, 8)
+	d.panel(&grafanaPanel{
+		Title:       "Projected daily costs per account",
+		Type:        "graph",
+		Description: "Linear prediction of the daily costs in 7 days, based on the last 7 days.",
+		Targets: []grafanaTarget{{
+			Expr:         fmt.Sprintf("predict_linear((%s)[7d:1h], 7 * 86400)", dailyBy(costs, "cloud", "account", "currency")),
+			LegendFormat: legend("cloud", "account", "currency"),
+		}},
+	}, 16, 8)
+
+	return &grafanaDashboard{
+		Title:         title,
+		UID:           AppName,
+		Tags:          []string{"billing", "costs"},
+		Editable:      true,
+		SchemaVersion: 22,
+		Time:          grafanaTimeRange{From: "now-30d", To: "now"},
+		Templating: grafanaTemplating{List: []grafanaVariable{
+			{
+				Name:  "datasource",
+				Label: "Data Source",
+				Type:  "datasource",
+				Query: "prometheus",
+			},
+			{
+				Name:       "cloud",
+				Label:      "Cloud",
+				Type:       "query",
+				Datasource: "$datasource",
+				Query:      fmt.Sprintf("label_values(%s, cloud)", costs),
+				Refresh:    2,
+				Multi:      true,
+				IncludeAll: true,
+			},
+			{
+				Name:       "account",
+				Label:      "Account",
+				Type:       "query",
+				Datasource: "$datasource",
+				Query:      fmt.Sprintf(`label_values(%s{cloud=~"$cloud"}, account)`, costs),
+				Refresh:    2,
+				Multi:      true,
+				IncludeAll: true,
+			},
+		}},
+		Panels: d.panels,
+	}
+}
+
+func (b *BillingCollector) writeDashboard(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(dashboard(*b.DashboardTitle, b.monthlyCosts.labels))
+}
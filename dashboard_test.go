@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestDashboardBreakdownPanels(t *testing.T) {
+	d := dashboard("Costs", []string{"cloud", "currency", "account", "service", "team"})
+
+	titles := map[string]bool{}
+	for _, p := range d.Panels {
+		titles[p.Title] = true
+		if p.GridPos.X+p.GridPos.W > 24 {
+			t.Errorf("panel '%s' exceeds grid width: %+v", p.Title, p.GridPos)
+		}
+	}
+
+	for _, exp := range []string{"Daily costs per team", "Unallocated costs ratio per account", "Month-to-date credits per type", "Projected costs for the next 30 days"} {
+		if !titles[exp] {
+			t.Errorf("expected panel '%s' to exist", exp)
+		}
+	}
+
+	if titles["Daily costs per currency"] {
+		t.Error("unexpected breakdown panel for a fixed label")
+	}
+}
+
+func TestDashboardMetricsExist(t *testing.T) {
+	b := &BillingCollector{}
+	b.initMetrics()
+	topN, err := newTopNCollector(3, []string{"account"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.topN = topN
+
+	// collect the names of all metrics the exporter registers
+	descs := make(chan *prometheus.Desc)
+	go func() {
+		b.Describe(descs)
+		close(descs)
+	}()
+	fqName := regexp.MustCompile(`fqName: "([^"]+)"`)
+	registered := map[string]bool{}
+	for desc := range descs {
+		if m := fqName.FindStringSubmatch(desc.String()); m != nil {
+			registered[m[1]] = true
+		}
+	}
+
+	metricName := regexp.MustCompile(Namespace + `_billing_[a-z0-9_]+`)
+	d := dashboard("Costs", []string{"cloud", "currency", "account", "service", "team"})
+	for _, p := range d.Panels {
+		for _, target := range p.Targets {
+			for _, name := range metricName.FindAllString(target.Expr, -1) {
+				if !registered[name] {
+					t.Errorf("panel '%s' references unknown metric '%s'", p.Title, name)
+				}
+			}
+		}
+	}
+	for _, v := range d.Templating.List {
+		for _, name := range metricName.FindAllString(v.Query, -1) {
+			if !registered[name] {
+				t.Errorf("variable '%s' references unknown metric '%s'", v.Name, name)
+			}
+		}
+	}
+}
+
+func TestWriteDashboardEnricherLabels(t *testing.T) {
+	title := "Costs"
+	b := &BillingCollector{DashboardTitle: &title}
+	b.initMetrics()
+	b.monthlyCosts.withEnricher(staticTeams{})
+
+	var buf bytes.Buffer
+	if err := b.writeDashboard(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "Daily costs per team") {
+		t.Error("expected a breakdown panel for the team label of the enricher")
+	}
+}
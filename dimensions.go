@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// metricName matches the names of the configured metrics
+var metricName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// dimensionMetrics are additional metrics of the monthly costs, each summed
+// up by its own labels, maintained in a configuration file like:
+//
+//	metrics:
+//	- name: monthly_costs_by_account
+//	  labels: [account]
+//	- name: monthly_costs_by_account_service
+//	  labels: [account, path, service]
+//	  top: 10
+//	  top_label: account
+//
+// The cloud and currency labels are always kept. With top set, only the
+// series of the top values of the top label per cloud and currency are
+// exposed, by default of the account.
+type dimensionMetrics struct {
+	Metrics []dimensionMetric `yaml:"metrics"`
+}
+
+// dimensionMetric is an additional metric of the monthly costs
+type dimensionMetric struct {
+	Name     string   `yaml:"name"`
+	Help     string   `yaml:"help"`
+	Labels   []string `yaml:"labels"`
+	Top      int      `yaml:"top"`
+	TopLabel string   `yaml:"top_label"`
+
+	desc *prometheus.Desc
+}
+
+// loadDimensionMetrics reads the metrics from a YAML file, the labels need to
+// be known to the monthly costs
+func loadDimensionMetrics(path string, knownLabels []string) (*dimensionMetrics, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseDimensionMetrics(data, knownLabels)
+}
+
+func parseDimensionMetrics(data []byte, knownLabels []string) (*dimensionMetrics, error) {
+	d := &dimensionMetrics{}
+	if err := yaml.UnmarshalStrict(data, d); err != nil {
+		return nil, fmt.Errorf("error parsing metrics: %s", err)
+	}
+
+	known := make(map[string]bool)
+	for _, name := range knownLabels {
+		known[name] = true
+	}
+	names := make(map[string]bool)
+	for i := range d.Metrics {
+		m := &d.Metrics[i]
+		if !metricName.MatchString(m.Name) || m.Name == "monthly_costs" {
+			return nil, fmt.Errorf("invalid metric name '%s'", m.Name)
+		}
+		if names[m.Name] {
+			return nil, fmt.Errorf("duplicate metric '%s'", m.Name)
+		}
+		names[m.Name] = true
+
+		labels := []string{"cloud", "currency"}
+		for _, name := range m.Labels {
+			if !known[name] {
+				return nil, fmt.Errorf("unknown label '%s' of metric '%s'", name, m.Name)
+			}
+			if name != "cloud" && name != "currency" {
+				labels = append(labels, name)
+			}
+		}
+		m.Labels = labels
+
+		if m.Top > 0 {
+			if m.TopLabel == "" {
+				m.TopLabel = "account"
+			}
+			found := false
+			for _, name := range m.Labels {
+				found = found || name == m.TopLabel
+			}
+			if !found {
+				return nil, fmt.Errorf("top label '%s' is no label of metric '%s'", m.TopLabel, m.Name)
+			}
+		}
+
+		if m.Help == "" {
+			m.Help = fmt.Sprintf("Billed costs per calendar month by %s.", strings.Join(m.Labels, ", "))
+		}
+		m.desc = prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "billing", m.Name),
+			m.Help,
+			m.Labels,
+			nil,
+		)
+	}
+	return d, nil
+}
+
+func (d *dimensionMetrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range d.Metrics {
+		ch <- m.desc
+	}
+}
+
+// collect sums up the monthly costs series by the labels of each metric
+func (d *dimensionMetrics) collect(costs []series, ch chan<- prometheus.Metric) {
+	for _, m := range d.Metrics {
+		m.collect(costs, ch)
+	}
+}
+
+func (m *dimensionMetric) collect(costs []series, ch chan<- prometheus.Metric) {
+	groups := make(map[string][]string)
+	groupCosts := make(map[string]float64)
+	// the costs of the values of the top label per cloud and currency
+	topCosts := make(map[[2]string]map[string]float64)
+	for _, s := range costs {
+		values := make([]string, len(m.Labels))
+		for i, name := range m.Labels {
+			values[i] = s.labels[name]
+		}
+		key := strings.Join(values, "\x00")
+		groups[key] = values
+		groupCosts[key] += s.value
+
+		if m.Top > 0 {
+			scope := [2]string{s.labels["cloud"], s.labels["currency"]}
+			if topCosts[scope] == nil {
+				topCosts[scope] = make(map[string]float64)
+			}
+			topCosts[scope][s.labels[m.TopLabel]] += s.value
+		}
+	}
+
+	// the top values per cloud and currency, ties are broken by the value
+	top := make(map[[2]string]map[string]bool)
+	for scope, values := range topCosts {
+		sorted := make([]string, 0, len(values))
+		for value := range values {
+			sorted = append(sorted, value)
+		}
+		sort.Slice(sorted, func(i, j int) bool {
+			if values[sorted[i]] != values[sorted[j]] {
+				return values[sorted[i]] > values[sorted[j]]
+			}
+			return sorted[i] < sorted[j]
+		})
+		if len(sorted) > m.Top {
+			sorted = sorted[:m.Top]
+		}
+		top[scope] = make(map[string]bool, len(sorted))
+		for _, value := range sorted {
+			top[scope][value] = true
+		}
+	}
+
+	for key, values := range groups {
+		if m.Top > 0 {
+			labels := make(map[string]string, len(values))
+			for i, name := range m.Labels {
+				labels[name] = values[i]
+			}
+			if !top[[2]string{labels["cloud"], labels["currency"]}][labels[m.TopLabel]] {
+				continue
+			}
+		}
+		ch <- prometheus.MustNewConstMetric(m.desc, prometheus.CounterValue, groupCosts[key], values...)
+	}
+}
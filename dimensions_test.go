@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+// dimensionSeries adapts the dimension metrics to a prometheus.Collector
+type dimensionSeries struct {
+	*dimensionMetrics
+	costs []series
+}
+
+func (d *dimensionSeries) Collect(ch chan<- prometheus.Metric) {
+	d.collect(d.costs, ch)
+}
+
+func TestDimensionMetrics(t *testing.T) {
+	d, err := parseDimensionMetrics([]byte(`
+metrics:
+- name: monthly_costs_by_account
+  labels: [account]
+- name: monthly_costs_by_account_service
+  help: Costs of the top account by service.
+  labels: [account, service]
+  top: 1
+`), billing.MonthlyCostsLabels)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c := &dimensionSeries{dimensionMetrics: d}
+	for _, r := range []billing.Record{
+		{Cloud: "aws", Currency: "USD", Account: "a", Service: "AmazonEC2", Owner: "jane", Costs: 10},
+		{Cloud: "aws", Currency: "USD", Account: "a", Service: "AmazonEC2", Owner: "john", Costs: 5},
+		{Cloud: "aws", Currency: "USD", Account: "a", Service: "AmazonS3", Costs: 1},
+		{Cloud: "aws", Currency: "USD", Account: "b", Service: "AmazonEC2", Costs: 12},
+		{Cloud: "gcp", Currency: "EUR", Account: "c", Service: "compute", Costs: 3},
+	} {
+		labels := make(map[string]string)
+		for i, name := range billing.MonthlyCostsLabels {
+			labels[name] = r.Labels()[i]
+		}
+		c.costs = append(c.costs, series{labels: labels, value: r.Costs})
+	}
+
+	if err := testutil.CollectAndCompare(c, strings.NewReader(`
+# HELP cloud_billing_monthly_costs_by_account Billed costs per calendar month by cloud, currency, account.
+# TYPE cloud_billing_monthly_costs_by_account counter
+cloud_billing_monthly_costs_by_account{account="a",cloud="aws",currency="USD"} 16
+cloud_billing_monthly_costs_by_account{account="b",cloud="aws",currency="USD"} 12
+cloud_billing_monthly_costs_by_account{account="c",cloud="gcp",currency="EUR"} 3
+# HELP cloud_billing_monthly_costs_by_account_service Costs of the top account by service.
+# TYPE cloud_billing_monthly_costs_by_account_service counter
+cloud_billing_monthly_costs_by_account_service{account="a",cloud="aws",currency="USD",service="AmazonEC2"} 15
+cloud_billing_monthly_costs_by_account_service{account="a",cloud="aws",currency="USD",service="AmazonS3"} 1
+cloud_billing_monthly_costs_by_account_service{account="c",cloud="gcp",currency="EUR",service="compute"} 3
+`)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDimensionMetricsInvalid(t *testing.T) {
+	for _, config := range []string{
+		"metrics:\n- name: monthly_costs\n  labels: [account]\n",
+		"metrics:\n- name: by-account\n  labels: [account]\n",
+		"metrics:\n- name: by_region\n  labels: [region]\n",
+		"metrics:\n- name: by_service\n  labels: [service]\n  top: 5\n",
+		"metrics:\n- name: a\n- name: a\n",
+		"metric: []\n",
+	} {
+		if _, err := parseDimensionMetrics([]byte(config), billing.MonthlyCostsLabels); err == nil {
+			t.Errorf("expected error parsing %q", config)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/log"
+	"golang.org/x/oauth2/google"
+	directory "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// managerLabel is the label of the monthly costs set to the manager of the
+// owner by the directory enrichment
+const managerLabel = "manager"
+
+// directoryUser contains the attributes of an owner in the directory
+type directoryUser struct {
+	OrgUnit string
+	Manager string
+}
+
+// directoryUsers looks up users by their email address, unknown users are
+// returned as nil
+type directoryUsers interface {
+	user(ctx context.Context, email string) (*directoryUser, error)
+}
+
+type directoryEntry struct {
+	user    *directoryUser
+	expires time.Time
+}
+
+// directoryEnrichment resolves the owners against a user directory, so the
+// costs roll up to the org unit and manager instead of individual owners. The
+// org unit sets the team label, unless it is set by the team mapping already.
+type directoryEnrichment struct {
+	users directoryUsers
+	ttl   time.Duration
+	now   func() time.Time
+
+	lock  sync.Mutex
+	cache map[string]directoryEntry
+}
+
+func newDirectoryEnrichment(users directoryUsers, ttl time.Duration) *directoryEnrichment {
+	return &directoryEnrichment{
+		users: users,
+		ttl:   ttl,
+		now:   time.Now,
+		cache: make(map[string]directoryEntry),
+	}
+}
+
+func (d *directoryEnrichment) user(email string) *directoryUser {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	now := d.now()
+	entry, ok := d.cache[email]
+	if ok && now.Before(entry.expires) {
+		return entry.user
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), httpEnrichmentTimeout)
+	defer cancel()
+	user, err := d.users.user(ctx, email)
+	if err != nil {
+		log.Warnf("error looking up owner %s in the directory: %s", email, err)
+		entry.expires = now.Add(httpEnrichmentRetry)
+		d.cache[email] = entry
+		return entry.user
+	}
+	d.cache[email] = directoryEntry{user: user, expires: now.Add(d.ttl)}
+	return user
+}
+
+func (d *directoryEnrichment) labels() []string {
+	return []string{teamLabel, managerLabel}
+}
+
+func (d *directoryEnrichment) update() {}
+
+func (d *directoryEnrichment) enrich(labels map[string]string) {
+	if !strings.Contains(labels["owner"], "@") {
+		return
+	}
+	user := d.user(labels["owner"])
+	if user == nil {
+		return
+	}
+	if labels[teamLabel] == "" {
+		labels[teamLabel] = user.OrgUnit
+	}
+	labels[managerLabel] = user.Manager
+}
+
+// workspaceUsers looks up users in the Google Workspace directory
+type workspaceUsers struct {
+	svc *directory.Service
+}
+
+// newWorkspaceUsers creates a client of the Workspace directory with the
+// default credentials. The directory requires domain wide delegation, the
+// service account of GOOGLE_APPLICATION_CREDENTIALS impersonates the given
+// subject if set.
+func newWorkspaceUsers(ctx context.Context, subject string) (*workspaceUsers, error) {
+	opts := []option.ClientOption{option.WithScopes(directory.AdminDirectoryUserReadonlyScope)}
+	if subject != "" {
+		data, err := ioutil.ReadFile(os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"))
+		if err != nil {
+			return nil, fmt.Errorf("error reading service account key: %s", err)
+		}
+		conf, err := google.JWTConfigFromJSON(data, directory.AdminDirectoryUserReadonlyScope)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing service account key: %s", err)
+		}
+		conf.Subject = subject
+		opts = []option.ClientOption{option.WithTokenSource(conf.TokenSource(ctx))}
+	}
+	svc, err := directory.NewService(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &workspaceUsers{svc: svc}, nil
+}
+
+func (w *workspaceUsers) user(ctx context.Context, email string) (*directoryUser, error) {
+	u, err := w.svc.Users.Get(email).ViewType("domain_public").Context(ctx).Do()
+	if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user := &directoryUser{OrgUnit: strings.TrimPrefix(u.OrgUnitPath, "/")}
+	// relations are returned as generic JSON
+	data, err := json.Marshal(u.Relations)
+	if err != nil {
+		return nil, err
+	}
+	var relations []struct {
+		Type  string `json:"type"`
+		Value string `json:"value"`
+	}
+	if err := json.Unmarshal(data, &relations); err == nil {
+		for _, r := range relations {
+			if r.Type == "manager" {
+				user.Manager = r.Value
+			}
+		}
+	}
+	return user, nil
+}
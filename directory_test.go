@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type fakeDirectoryUsers struct {
+	users   map[string]*directoryUser
+	lookups int
+}
+
+func (f *fakeDirectoryUsers) user(_ context.Context, email string) (*directoryUser, error) {
+	f.lookups++
+	return f.users[email], nil
+}
+
+func TestDirectoryEnrichment(t *testing.T) {
+	users := &fakeDirectoryUsers{users: map[string]*directoryUser{
+		"jane@example.com": {OrgUnit: "engineering/platform", Manager: "joe@example.com"},
+	}}
+	d := newDirectoryEnrichment(users, time.Hour)
+
+	for _, c := range []struct {
+		labels map[string]string
+		exp    map[string]string
+	}{
+		{
+			labels: map[string]string{"owner": "jane@example.com"},
+			exp:    map[string]string{"owner": "jane@example.com", "team": "engineering/platform", "manager": "joe@example.com"},
+		},
+		{
+			// the team mapping takes precedence
+			labels: map[string]string{"owner": "jane@example.com", "team": "data"},
+			exp:    map[string]string{"owner": "jane@example.com", "team": "data", "manager": "joe@example.com"},
+		},
+		{
+			labels: map[string]string{"owner": "unknown@example.com"},
+			exp:    map[string]string{"owner": "unknown@example.com"},
+		},
+		{
+			labels: map[string]string{"owner": "jane"},
+			exp:    map[string]string{"owner": "jane"},
+		},
+	} {
+		d.enrich(c.labels)
+		if !reflect.DeepEqual(c.labels, c.exp) {
+			t.Errorf("unexpected labels: %v (expected: %v)", c.labels, c.exp)
+		}
+	}
+	if act, exp := users.lookups, 2; act != exp {
+		t.Errorf("unexpected number of lookups: %d (expected: %d)", act, exp)
+	}
+}
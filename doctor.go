@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+const (
+	// doctorTimeout limits the time of each credentials check
+	doctorTimeout = time.Minute
+	// doctorMaxReportAge is the age of the newest report, after which the
+	// exports are assumed to be broken
+	doctorMaxReportAge = 48 * time.Hour
+)
+
+// diagnosticsCollector is implemented by collectors describing their
+// credentials and the reports found by their last query
+type diagnosticsCollector interface {
+	Diagnostics(ctx context.Context) (billing.Diagnostics, error)
+}
+
+// doctorReport collects the sections and warnings of the doctor command
+type doctorReport struct {
+	tw       *tabwriter.Writer
+	warnings []string
+	ok       bool
+}
+
+func (r *doctorReport) section(title string) {
+	fmt.Fprintf(r.tw, "\n%s\n", title)
+}
+
+func (r *doctorReport) line(key, format string, args ...interface{}) {
+	fmt.Fprintf(r.tw, "  %s:\t%s\n", key, fmt.Sprintf(format, args...))
+}
+
+func (r *doctorReport) warn(format string, args ...interface{}) {
+	r.warnings = append(r.warnings, fmt.Sprintf(format, args...))
+}
+
+// fail adds a warning and fails the report
+func (r *doctorReport) fail(format string, args ...interface{}) {
+	r.warn(format, args...)
+	r.ok = false
+}
+
+// doctor runs the collectors once and writes a report of the configuration,
+// credentials, reports, parsed rows and exposed series for support triage.
+// It returns false if any collector failed.
+func (b *BillingCollector) doctor(ctx context.Context, w io.Writer, features map[string]bool, parseMetrics prometheus.Gatherer) bool {
+	r := &doctorReport{tw: tabwriter.NewWriter(w, 0, 8, 2, ' ', 0), ok: true}
+	now := time.Now()
+
+	r.section("CONFIGURATION")
+	var enabled []string
+	for feature, ok := range features {
+		if ok {
+			enabled = append(enabled, feature)
+		}
+	}
+	sort.Strings(enabled)
+	r.line("features", "%s", strings.Join(enabled, ", "))
+
+	for _, c := range b.collectors {
+		r.section(fmt.Sprintf("COLLECTOR %s", c))
+		r.line("cloud", "%s", c.Cloud())
+
+		if checker, ok := c.(credentialsChecker); ok {
+			checkCtx, cancel := context.WithTimeout(ctx, doctorTimeout)
+			if err := checker.CheckCredentials(checkCtx); err != nil {
+				r.line("credentials", "FAIL: %s", strings.Join(strings.Fields(err.Error()), " "))
+				r.fail("credentials of %s are invalid", c)
+			} else {
+				r.line("credentials", "pass")
+			}
+			cancel()
+		}
+
+		start := time.Now()
+		if err := b.health.run(c, c.Query); err != nil {
+			r.line("query", "FAIL after %s: %s", time.Since(start).Round(time.Millisecond), strings.Join(strings.Fields(err.Error()), " "))
+			r.fail("query of %s failed", c)
+		} else {
+			r.line("query", "pass in %s", time.Since(start).Round(time.Millisecond))
+		}
+
+		if d, ok := c.(diagnosticsCollector); ok {
+			checkCtx, cancel := context.WithTimeout(ctx, doctorTimeout)
+			diagnostics, err := d.Diagnostics(checkCtx)
+			cancel()
+			if err != nil {
+				r.line("identity", "unknown: %s", strings.Join(strings.Fields(err.Error()), " "))
+			} else if diagnostics.Identity != "" {
+				r.line("identity", "%s", diagnostics.Identity)
+			}
+			if diagnostics.Source != "" {
+				r.line("source", "%s", diagnostics.Source)
+			}
+			if diagnostics.NewestReport != "" {
+				r.line("reports", "%d found", diagnostics.Reports)
+				if diagnostics.NewestReportModified.IsZero() {
+					r.line("newest report", "%s", diagnostics.NewestReport)
+				} else {
+					age := now.Sub(diagnostics.NewestReportModified).Round(time.Minute)
+					r.line("newest report", "%s, modified %s ago", diagnostics.NewestReport, age)
+					if age > doctorMaxReportAge {
+						r.warn("newest report of %s is %s old", c, age)
+					}
+				}
+			} else if diagnostics.Source != "" && diagnostics.Reports == 0 {
+				r.warn("no reports of %s found in %s", c, diagnostics.Source)
+			}
+		}
+
+		records := c.Records()
+		accounts := make(map[string]bool)
+		costs := make(map[string]float64)
+		for _, record := range records {
+			accounts[record.Account] = true
+			costs[record.Currency] += record.Costs
+		}
+		r.line("records", "%d of %d accounts", len(records), len(accounts))
+		currencies := make([]string, 0, len(costs))
+		for currency := range costs {
+			currencies = append(currencies, currency)
+		}
+		sort.Strings(currencies)
+		for _, currency := range currencies {
+			r.line("costs", "%.2f %s", costs[currency], currency)
+		}
+		if len(records) == 0 {
+			r.warn("%s has no costs", c)
+		}
+	}
+
+	r.section("PARSED ROWS")
+	if families, err := parseMetrics.Gather(); err != nil {
+		r.warn("error gathering parse metrics: %s", err)
+	} else {
+		for _, f := range families {
+			if !strings.HasPrefix(f.GetName(), Namespace+"_parse_rows_") {
+				continue
+			}
+			for _, m := range f.GetMetric() {
+				labels := make([]string, 0, len(m.GetLabel()))
+				for _, l := range m.GetLabel() {
+					labels = append(labels, fmt.Sprintf("%s=%s", l.GetName(), l.GetValue()))
+				}
+				r.line(strings.TrimPrefix(f.GetName(), Namespace+"_parse_"), "%.0f {%s}", m.GetCounter().GetValue(), strings.Join(labels, ","))
+			}
+		}
+	}
+
+	// the series are collected like on a scrape, the collectors are queried
+	// again, which only parses changed reports
+	r.section("SERIES")
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(b); err != nil {
+		r.fail("error registering collector: %s", err)
+	} else if families, err := registry.Gather(); err != nil {
+		r.fail("error collecting metrics: %s", err)
+	} else {
+		total := 0
+		for _, f := range families {
+			r.line(f.GetName(), "%d", len(f.GetMetric()))
+			total += len(f.GetMetric())
+		}
+		r.line("total", "%d", total)
+	}
+
+	r.section("WARNINGS")
+	if len(r.warnings) == 0 {
+		fmt.Fprintln(r.tw, "  none")
+	}
+	for _, warning := range r.warnings {
+		fmt.Fprintf(r.tw, "  - %s\n", warning)
+	}
+	_ = r.tw.Flush()
+	return r.ok
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+type doctorTestCollector struct {
+	fakeCollector
+	diagnostics billing.Diagnostics
+	credentials error
+}
+
+func (c *doctorTestCollector) Diagnostics(context.Context) (billing.Diagnostics, error) {
+	return c.diagnostics, nil
+}
+
+func (c *doctorTestCollector) CheckCredentials(context.Context) error {
+	return c.credentials
+}
+
+func TestDoctor(t *testing.T) {
+	b := &BillingCollector{}
+	b.initMetrics()
+	b.collectors = []cloudBillingCollector{
+		&doctorTestCollector{
+			fakeCollector: fakeCollector{cloud: "aws", records: []billing.Record{
+				{Cloud: "aws", Currency: "USD", Account: "prod", Service: "AmazonEC2", Costs: 10},
+				{Cloud: "aws", Currency: "USD", Account: "dev", Service: "AmazonEC2", Costs: 2.5},
+			}},
+			diagnostics: billing.Diagnostics{
+				Identity:             "arn:aws:iam::12340002:root",
+				Source:               "s3://billing/12340002-aws-billing-csv-",
+				Reports:              2,
+				NewestReport:         "12340002-aws-billing-csv-2019-11.csv",
+				NewestReportModified: time.Now().Add(-72 * time.Hour),
+			},
+		},
+		&doctorTestCollector{
+			fakeCollector: fakeCollector{cloud: "gcp"},
+			credentials:   errors.New("AccessDenied:\n\tstatus code: 403"),
+		},
+	}
+	parseMetrics := prometheus.NewRegistry()
+	p := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "cloud_parse_rows_read_total"}, []string{"backend"})
+	p.WithLabelValues("aws").Add(3)
+	parseMetrics.MustRegister(p)
+
+	var out bytes.Buffer
+	if b.doctor(context.Background(), &out, map[string]bool{"aws": true, "gcp": true, "state": false}, parseMetrics) {
+		t.Error("expected failed doctor")
+	}
+	// the columns are aligned per section
+	act := strings.Join(strings.Fields(out.String()), " ")
+	for _, exp := range []string{
+		"features: aws, gcp COLLECTOR fake aws",
+		"identity: arn:aws:iam::12340002:root",
+		"newest report: 12340002-aws-billing-csv-2019-11.csv, modified 72h0m0s ago",
+		"records: 2 of 2 accounts costs: 12.50 USD",
+		"credentials: FAIL: AccessDenied: status code: 403",
+		"rows_read_total: 3 {backend=aws}",
+		"cloud_billing_collector_up: 2",
+		"WARNINGS - newest report of fake aws is 72h0m0s old - credentials of fake gcp are invalid - fake gcp has no costs",
+	} {
+		if !strings.Contains(act, exp) {
+			t.Errorf("expected output to contain %q:\n%s", exp, out.String())
+		}
+	}
+}
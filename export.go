@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/log"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+	"github.com/simonswine/cloud-billing-exporter/sink"
+)
+
+// runScheduledExport writes the current records of all collectors to the
+// sink in the given interval
+func (b *BillingCollector) runScheduledExport(s sink.Sink, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		records := b.records()
+		if len(records) == 0 {
+			log.Debugf("no records to export to %s", s)
+			continue
+		}
+
+		if err := s.Write(context.Background(), records); err != nil {
+			log.Warnf("error exporting records to %s: %s", s, err)
+			continue
+		}
+		log.Debugf("exported %d records to %s", len(records), s)
+	}
+}
+
+// collection contains the results of a collection written to the sinks
+type collection struct {
+	records   []billing.Record
+	lineItems []billing.LineItem
+}
+
+// sinkWriter writes the records to the sinks, that receive the records after
+// every collection. A single worker writes to the sinks, if they are slower
+// than the collections only the latest records are written.
+type sinkWriter struct {
+	sinks []sink.Sink
+	queue chan *collection
+
+	// lastQueued is the collection queued last, it is only queued again once
+	// a collector parsed a new report
+	lastQueued *collection
+	lock       sync.Mutex
+}
+
+func newSinkWriter(sinks []sink.Sink) *sinkWriter {
+	return &sinkWriter{
+		sinks: sinks,
+		queue: make(chan *collection, 1),
+	}
+}
+
+// enqueue queues the records and line items for writing, if they changed
+// since the last call. A pending write of an older collection is replaced.
+func (w *sinkWriter) enqueue(records []billing.Record, lineItems []billing.LineItem) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	c := &collection{records: records, lineItems: lineItems}
+	if len(records) == 0 || reflect.DeepEqual(w.lastQueued, c) {
+		return
+	}
+	w.lastQueued = c
+
+	for {
+		select {
+		case w.queue <- c:
+			return
+		default:
+		}
+		select {
+		case old := <-w.queue:
+			log.Debugf("dropping pending write of %d records to sinks", len(old.records))
+		default:
+		}
+	}
+}
+
+// run writes the queued collections to all sinks
+func (w *sinkWriter) run() {
+	for c := range w.queue {
+		failed := false
+		for _, s := range w.sinks {
+			if err := s.Write(context.Background(), c.records); err != nil {
+				log.Warnf("error writing records to %s: %s", s, err)
+				failed = true
+				continue
+			}
+			log.Debugf("wrote %d records to %s", len(c.records), s)
+
+			if l, ok := s.(sink.LineItemWriter); ok && len(c.lineItems) > 0 {
+				if err := l.WriteLineItems(context.Background(), c.lineItems); err != nil {
+					log.Warnf("error writing line items to %s: %s", s, err)
+					failed = true
+					continue
+				}
+				log.Debugf("wrote %d line items to %s", len(c.lineItems), s)
+			}
+		}
+
+		// retry with the next collection
+		if failed {
+			w.lock.Lock()
+			if w.lastQueued == c {
+				w.lastQueued = nil
+			}
+			w.lock.Unlock()
+		}
+	}
+}
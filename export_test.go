@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+	"github.com/simonswine/cloud-billing-exporter/sink"
+)
+
+type fakeSink struct {
+	lock   sync.Mutex
+	writes [][]billing.Record
+	// block delays writes until it is closed, started is notified when a
+	// write begins
+	block   chan struct{}
+	started chan struct{}
+}
+
+func (f *fakeSink) Write(_ context.Context, records []billing.Record) error {
+	if f.started != nil {
+		select {
+		case f.started <- struct{}{}:
+		default:
+		}
+	}
+	if f.block != nil {
+		<-f.block
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.writes = append(f.writes, records)
+	return nil
+}
+
+func (f *fakeSink) String() string {
+	return "fake"
+}
+
+func TestSinkWriterSkipsUnchangedRecords(t *testing.T) {
+	s := &fakeSink{}
+	w := newSinkWriter([]sink.Sink{s})
+
+	records := []billing.Record{{Cloud: "aws", Month: "2019-11", Account: "a", Costs: 1}}
+	w.enqueue(records, nil)
+	w.enqueue([]billing.Record{{Cloud: "aws", Month: "2019-11", Account: "a", Costs: 1}}, nil)
+	w.enqueue(nil, nil)
+
+	if act := len(w.queue); act != 1 {
+		t.Errorf("unexpected queued writes: act: %d, exp: 1", act)
+	}
+}
+
+func TestSinkWriterCoalescesPendingWrites(t *testing.T) {
+	s := &fakeSink{block: make(chan struct{}), started: make(chan struct{}, 1)}
+	w := newSinkWriter([]sink.Sink{s})
+
+	done := make(chan struct{})
+	go func() {
+		w.run()
+		close(done)
+	}()
+
+	// the first write blocks the worker, later ones replace each other
+	for i := 1; i <= 5; i++ {
+		w.enqueue([]billing.Record{{Cloud: "aws", Month: "2019-11", Account: "a", Costs: float64(i)}}, nil)
+		if i == 1 {
+			<-s.started
+		}
+	}
+	close(s.block)
+	close(w.queue)
+	<-done
+
+	if len(s.writes) != 2 {
+		t.Fatalf("unexpected number of writes: act: %d, exp: 2", len(s.writes))
+	}
+	if act := s.writes[1][0].Costs; act != 5 {
+		t.Errorf("expected the latest records to be written: act: %g, exp: 5", act)
+	}
+}
@@ -0,0 +1,231 @@
+// Package fake contains in-memory implementations of the cloud APIs used by
+// the collectors, so they can be tested without credentials
+package fake
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// etag returns the quoted MD5 of the content, like S3 does for objects not
+// uploaded in multiple parts
+func etag(content string) string {
+	return fmt.Sprintf(`"%x"`, md5.Sum([]byte(content)))
+}
+
+// S3 serves objects of a single bucket from memory
+type S3 struct {
+	// Objects contains the content per key
+	Objects map[string]string
+
+	// RequesterPays denies requests without the request payer header
+	RequesterPays bool
+
+	// Ranges contains the ranges of the ranged GETs
+	Ranges []string
+
+	// Truncate is the number of following GETs, which only return the first
+	// half of the content like interrupted downloads
+	Truncate int
+
+	lock sync.Mutex
+}
+
+func (f *S3) checkRequestPayer(payer *string) error {
+	if f.RequesterPays && aws.StringValue(payer) != s3.RequestPayerRequester {
+		return awserr.New("AccessDenied", "Access Denied", nil)
+	}
+	return nil
+}
+
+func (f *S3) keys(prefix string) []string {
+	var keys []string
+	for key := range f.Objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (f *S3) ListObjectsPagesWithContext(_ aws.Context, input *s3.ListObjectsInput, fn func(*s3.ListObjectsOutput, bool) bool, _ ...request.Option) error {
+	if err := f.checkRequestPayer(input.RequestPayer); err != nil {
+		return err
+	}
+	resp := &s3.ListObjectsOutput{}
+	for _, key := range f.keys(aws.StringValue(input.Prefix)) {
+		resp.Contents = append(resp.Contents, &s3.Object{
+			Key:  aws.String(key),
+			ETag: aws.String(etag(f.Objects[key])),
+			Size: aws.Int64(int64(len(f.Objects[key]))),
+		})
+	}
+	fn(resp, true)
+	return nil
+}
+
+func (f *S3) GetObjectWithContext(_ aws.Context, input *s3.GetObjectInput, _ ...request.Option) (*s3.GetObjectOutput, error) {
+	if err := f.checkRequestPayer(input.RequestPayer); err != nil {
+		return nil, err
+	}
+	content, ok := f.Objects[aws.StringValue(input.Key)]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	}
+	if input.IfMatch != nil && *input.IfMatch != etag(content) {
+		return nil, awserr.New("PreconditionFailed", "At least one of the pre-conditions you specified did not hold", nil)
+	}
+	if input.Range != nil {
+		f.lock.Lock()
+		f.Ranges = append(f.Ranges, *input.Range)
+		f.lock.Unlock()
+
+		var start, end int
+		if _, err := fmt.Sscanf(*input.Range, "bytes=%d-%d", &start, &end); err != nil || start > end || start >= len(content) {
+			return nil, awserr.New("InvalidRange", "The requested range is not satisfiable", nil)
+		}
+		if end >= len(content) {
+			end = len(content) - 1
+		}
+		content = content[start : end+1]
+	}
+	f.lock.Lock()
+	if f.Truncate > 0 {
+		f.Truncate--
+		content = content[:len(content)/2]
+	}
+	f.lock.Unlock()
+	return &s3.GetObjectOutput{
+		Body:          ioutil.NopCloser(strings.NewReader(content)),
+		ContentLength: aws.Int64(int64(len(content))),
+		ETag:          aws.String(etag(content)),
+	}, nil
+}
+
+// Organizations serves a fixed organization
+type Organizations struct {
+	// MasterAccountEmail is the email of the organization's master account
+	MasterAccountEmail string
+
+	Accounts []*organizations.Account
+
+	// Tags contains the tags per account ID
+	Tags map[string]map[string]string
+
+	// Parents contains the parent ID per account or organizational unit,
+	// roots start with r-, organizational units with ou-
+	Parents map[string]string
+
+	// OrganizationalUnits contains the name per organizational unit ID
+	OrganizationalUnits map[string]string
+}
+
+func (f *Organizations) ListAccountsPagesWithContext(_ aws.Context, _ *organizations.ListAccountsInput, fn func(*organizations.ListAccountsOutput, bool) bool, _ ...request.Option) error {
+	fn(&organizations.ListAccountsOutput{Accounts: f.Accounts}, true)
+	return nil
+}
+
+func (f *Organizations) ListTagsForResourcePagesWithContext(_ aws.Context, input *organizations.ListTagsForResourceInput, fn func(*organizations.ListTagsForResourceOutput, bool) bool, _ ...request.Option) error {
+	tags := f.Tags[aws.StringValue(input.ResourceId)]
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	resp := &organizations.ListTagsForResourceOutput{}
+	for _, key := range keys {
+		resp.Tags = append(resp.Tags, &organizations.Tag{Key: aws.String(key), Value: aws.String(tags[key])})
+	}
+	fn(resp, true)
+	return nil
+}
+
+func (f *Organizations) ListParentsWithContext(_ aws.Context, input *organizations.ListParentsInput, _ ...request.Option) (*organizations.ListParentsOutput, error) {
+	resp := &organizations.ListParentsOutput{}
+	if parent, ok := f.Parents[aws.StringValue(input.ChildId)]; ok {
+		resp.Parents = append(resp.Parents, &organizations.Parent{Id: aws.String(parent)})
+	}
+	return resp, nil
+}
+
+func (f *Organizations) DescribeOrganizationWithContext(_ aws.Context, _ *organizations.DescribeOrganizationInput, _ ...request.Option) (*organizations.DescribeOrganizationOutput, error) {
+	return &organizations.DescribeOrganizationOutput{Organization: &organizations.Organization{
+		MasterAccountEmail: aws.String(f.MasterAccountEmail),
+	}}, nil
+}
+
+func (f *Organizations) DescribeOrganizationalUnitWithContext(_ aws.Context, input *organizations.DescribeOrganizationalUnitInput, _ ...request.Option) (*organizations.DescribeOrganizationalUnitOutput, error) {
+	id := aws.StringValue(input.OrganizationalUnitId)
+	name, ok := f.OrganizationalUnits[id]
+	if !ok {
+		return nil, awserr.New(organizations.ErrCodeOrganizationalUnitNotFoundException, "organizational unit not found", nil)
+	}
+	return &organizations.DescribeOrganizationalUnitOutput{OrganizationalUnit: &organizations.OrganizationalUnit{
+		Id:   aws.String(id),
+		Name: aws.String(name),
+	}}, nil
+}
+
+// STS returns a fixed caller identity
+type STS struct {
+	Account string
+
+	// Calls counts the lookups of the caller identity
+	Calls int
+}
+
+func (f *STS) GetCallerIdentityWithContext(_ aws.Context, _ *sts.GetCallerIdentityInput, _ ...request.Option) (*sts.GetCallerIdentityOutput, error) {
+	f.Calls++
+	return &sts.GetCallerIdentityOutput{
+		Account: aws.String(f.Account),
+		Arn:     aws.String(fmt.Sprintf("arn:aws:iam::%s:root", f.Account)),
+	}, nil
+}
+
+// Athena succeeds every query immediately and returns fixed rows
+type Athena struct {
+	// Rows are returned as results, the first row is the header
+	Rows [][]string
+
+	// Queries contains the executed queries
+	Queries []string
+}
+
+func (f *Athena) StartQueryExecutionWithContext(_ aws.Context, input *athena.StartQueryExecutionInput, _ ...request.Option) (*athena.StartQueryExecutionOutput, error) {
+	f.Queries = append(f.Queries, aws.StringValue(input.QueryString))
+	return &athena.StartQueryExecutionOutput{QueryExecutionId: aws.String(fmt.Sprintf("query-%d", len(f.Queries)))}, nil
+}
+
+func (f *Athena) GetQueryExecutionWithContext(_ aws.Context, input *athena.GetQueryExecutionInput, _ ...request.Option) (*athena.GetQueryExecutionOutput, error) {
+	return &athena.GetQueryExecutionOutput{QueryExecution: &athena.QueryExecution{
+		QueryExecutionId: input.QueryExecutionId,
+		Status:           &athena.QueryExecutionStatus{State: aws.String(athena.QueryExecutionStateSucceeded)},
+	}}, nil
+}
+
+func (f *Athena) GetQueryResultsPagesWithContext(_ aws.Context, _ *athena.GetQueryResultsInput, fn func(*athena.GetQueryResultsOutput, bool) bool, _ ...request.Option) error {
+	resp := &athena.GetQueryResultsOutput{ResultSet: &athena.ResultSet{}}
+	for _, row := range f.Rows {
+		r := &athena.Row{}
+		for _, v := range row {
+			r.Data = append(r.Data, &athena.Datum{VarCharValue: aws.String(v)})
+		}
+		resp.ResultSet.Rows = append(resp.ResultSet.Rows, r)
+	}
+	fn(resp, true)
+	return nil
+}
@@ -0,0 +1,122 @@
+package fake
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/bigquery/v2"
+	crmv1 "google.golang.org/api/cloudresourcemanager/v1"
+	crmv2 "google.golang.org/api/cloudresourcemanager/v2"
+)
+
+// GCS serves objects of a single bucket from memory
+type GCS struct {
+	// Objects contains the content per object name
+	Objects map[string]string
+
+	// Truncate is the number of following reads, which only return the
+	// first half of the content like interrupted downloads
+	Truncate int
+
+	lock sync.Mutex
+}
+
+func (f *GCS) ListObjects(_ context.Context, prefix string) ([]*storage.ObjectAttrs, error) {
+	var names []string
+	for name := range f.Objects {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	objects := make([]*storage.ObjectAttrs, 0, len(names))
+	for _, name := range names {
+		sum := md5.Sum([]byte(f.Objects[name]))
+		objects = append(objects, &storage.ObjectAttrs{
+			Name: name,
+			MD5:  sum[:],
+			Size: int64(len(f.Objects[name])),
+		})
+	}
+	return objects, nil
+}
+
+func (f *GCS) NewReader(_ context.Context, name string) (io.ReadCloser, error) {
+	content, ok := f.Objects[name]
+	if !ok {
+		return nil, storage.ErrObjectNotExist
+	}
+	f.lock.Lock()
+	if f.Truncate > 0 {
+		f.Truncate--
+		content = content[:len(content)/2]
+	}
+	f.lock.Unlock()
+	return ioutil.NopCloser(strings.NewReader(content)), nil
+}
+
+// ResourceManager serves fixed projects, folders and organizations
+type ResourceManager struct {
+	Projects      []*crmv1.Project
+	Folders       []*crmv2.Folder
+	Organizations []*crmv1.Organization
+}
+
+func (f *ResourceManager) ListProjects(_ context.Context) ([]*crmv1.Project, error) {
+	return f.Projects, nil
+}
+
+func (f *ResourceManager) ListFolders(_ context.Context) ([]*crmv2.Folder, error) {
+	return f.Folders, nil
+}
+
+func (f *ResourceManager) ListOrganizations(_ context.Context) ([]*crmv1.Organization, error) {
+	return f.Organizations, nil
+}
+
+// BigQuery completes every query job immediately with fixed rows
+type BigQuery struct {
+	// Rows are the values of the result rows, in the order of the columns
+	Rows [][]string
+
+	// Jobs contains the inserted jobs
+	Jobs []*bigquery.Job
+}
+
+func (f *BigQuery) Insert(_ context.Context, projectID string, job *bigquery.Job) (*bigquery.Job, error) {
+	var location string
+	if job.JobReference != nil {
+		location = job.JobReference.Location
+	}
+	job.JobReference = &bigquery.JobReference{
+		ProjectId: projectID,
+		JobId:     fmt.Sprintf("job-%d", len(f.Jobs)),
+		Location:  location,
+	}
+	f.Jobs = append(f.Jobs, job)
+	return job, nil
+}
+
+func (f *BigQuery) GetQueryResults(_ context.Context, projectID, jobID, location, pageToken string) (*bigquery.GetQueryResultsResponse, error) {
+	rows := make([]*bigquery.TableRow, 0, len(f.Rows))
+	for _, values := range f.Rows {
+		row := &bigquery.TableRow{}
+		for _, v := range values {
+			row.F = append(row.F, &bigquery.TableCell{V: v})
+		}
+		rows = append(rows, row)
+	}
+	return &bigquery.GetQueryResultsResponse{
+		JobComplete:  true,
+		JobReference: &bigquery.JobReference{ProjectId: projectID, JobId: jobID, Location: location},
+		Rows:         rows,
+	}, nil
+}
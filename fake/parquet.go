@@ -0,0 +1,479 @@
+package fake
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// ParquetColumn is an optional column of a Parquet file, nil values are
+// null. The type is taken from the values, which are strings, float64,
+// int64, bool or time.Time.
+type ParquetColumn struct {
+	Name   string
+	Values []interface{}
+
+	// Dictionary encodes the values with a dictionary page
+	Dictionary bool
+
+	// Scale declares int64 values as decimals of the scale
+	Scale int
+}
+
+// ParquetFile writes Parquet files like the ones of the cost exports
+type ParquetFile struct {
+	Columns []ParquetColumn
+
+	// RowGroupRows splits the rows into row groups, all rows are written to
+	// a single row group if 0
+	RowGroupRows int
+
+	// Compression is SNAPPY, GZIP or empty
+	Compression string
+
+	// PageV2 writes the data pages in version 2
+	PageV2 bool
+}
+
+// String returns the content of the file
+func (p ParquetFile) String() string {
+	rows := 0
+	if len(p.Columns) > 0 {
+		rows = len(p.Columns[0].Values)
+	}
+	groupRows := p.RowGroupRows
+	if groupRows <= 0 {
+		groupRows = rows
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("PAR1")
+	var rowGroups [][]parquetChunk
+	for start := 0; start < rows; start += groupRows {
+		end := start + groupRows
+		if end > rows {
+			end = rows
+		}
+		var chunks []parquetChunk
+		for _, c := range p.Columns {
+			chunks = append(chunks, p.writeChunk(&buf, c, c.Values[start:end]))
+		}
+		rowGroups = append(rowGroups, chunks)
+	}
+
+	w := newThriftWriter()
+	w.i32(1, 1)
+	w.list(2, thriftStruct, len(p.Columns)+1)
+	w.element()
+	w.binary(4, "schema")
+	w.i32(5, int64(len(p.Columns)))
+	w.end()
+	for _, c := range p.Columns {
+		w.element()
+		w.i32(1, int64(parquetType(c.Values)))
+		w.i32(3, 1)
+		w.binary(4, c.Name)
+		switch {
+		case parquetType(c.Values) == 6:
+			w.i32(6, 0)
+		case c.Scale > 0:
+			w.i32(6, 5)
+			w.i32(7, int64(c.Scale))
+			w.i32(8, 18)
+		}
+		if _, ok := firstValue(c.Values).(time.Time); ok {
+			// timestamps in microseconds as logical type
+			w.begin(10)
+			w.begin(8)
+			w.boolean(1, true)
+			w.begin(2)
+			w.begin(2)
+			w.end()
+			w.end()
+			w.end()
+			w.end()
+		}
+		w.end()
+	}
+	w.i64(3, int64(rows))
+	w.list(4, thriftStruct, len(rowGroups))
+	for i, chunks := range rowGroups {
+		w.element()
+		w.list(1, thriftStruct, len(chunks))
+		for j, chunk := range chunks {
+			w.element()
+			w.i64(2, chunk.offset)
+			w.begin(3)
+			w.i32(1, int64(parquetType(p.Columns[j].Values)))
+			w.list(2, thriftI32, 1)
+			w.elementI32(chunk.encoding)
+			w.list(3, thriftBinary, 1)
+			w.elementBinary(p.Columns[j].Name)
+			w.i32(4, p.codec())
+			w.i64(5, int64(chunk.values))
+			w.i64(6, chunk.size)
+			w.i64(7, chunk.size)
+			w.i64(9, chunk.dataOffset)
+			if chunk.dictionaryOffset > 0 {
+				w.i64(11, chunk.dictionaryOffset)
+			}
+			w.end()
+			w.end()
+		}
+		w.i64(2, 0)
+		end := i*groupRows + groupRows
+		if end > rows {
+			end = rows
+		}
+		w.i64(3, int64(end-i*groupRows))
+		w.end()
+	}
+	w.binary(6, "fake")
+	w.end()
+
+	buf.Write(w.buf.Bytes())
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(w.buf.Len()))
+	buf.WriteString("PAR1")
+	return buf.String()
+}
+
+// parquetChunk is the position of a written column chunk
+type parquetChunk struct {
+	offset           int64
+	size             int64
+	dataOffset       int64
+	dictionaryOffset int64
+	values           int
+	encoding         int64
+}
+
+func (p ParquetFile) codec() int64 {
+	switch p.Compression {
+	case "":
+		return 0
+	case "SNAPPY":
+		return 1
+	case "GZIP":
+		return 2
+	}
+	panic(fmt.Sprintf("unsupported compression %s", p.Compression))
+}
+
+func (p ParquetFile) compress(data []byte) []byte {
+	var buf bytes.Buffer
+	switch p.Compression {
+	case "SNAPPY":
+		// literals only, of up to 60 bytes in the tag and longer ones with
+		// two bytes of length
+		buf.Write(uvarint(uint64(len(data))))
+		for len(data) > 0 {
+			n := len(data)
+			if n > 65536 {
+				n = 65536
+			}
+			if n <= 60 {
+				buf.WriteByte(byte(n-1) << 2)
+			} else {
+				buf.WriteByte(61 << 2)
+				_ = binary.Write(&buf, binary.LittleEndian, uint16(n-1))
+			}
+			buf.Write(data[:n])
+			data = data[n:]
+		}
+	case "GZIP":
+		gz := gzip.NewWriter(&buf)
+		_, _ = gz.Write(data)
+		_ = gz.Close()
+	default:
+		buf.Write(data)
+	}
+	return buf.Bytes()
+}
+
+// writeChunk writes the values of a column chunk as an optional dictionary
+// page and a data page
+func (p ParquetFile) writeChunk(buf *bytes.Buffer, c ParquetColumn, values []interface{}) parquetChunk {
+	chunk := parquetChunk{offset: int64(buf.Len()), values: len(values)}
+
+	var defined []interface{}
+	levels := make([]int, len(values))
+	for i, v := range values {
+		if v != nil {
+			defined = append(defined, v)
+			levels[i] = 1
+		}
+	}
+
+	var encoded []byte
+	if c.Dictionary {
+		var dictionary []interface{}
+		indices := make(map[interface{}]int)
+		var keys []int
+		for _, v := range defined {
+			if _, ok := indices[v]; !ok {
+				indices[v] = len(dictionary)
+				dictionary = append(dictionary, v)
+			}
+			keys = append(keys, indices[v])
+		}
+
+		chunk.dictionaryOffset = int64(buf.Len())
+		page := plain(dictionary)
+		compressed := p.compress(page)
+		w := newThriftWriter()
+		w.i32(1, 2)
+		w.i32(2, int64(len(page)))
+		w.i32(3, int64(len(compressed)))
+		w.begin(7)
+		w.i32(1, int64(len(dictionary)))
+		w.i32(2, 0)
+		w.end()
+		w.end()
+		buf.Write(w.buf.Bytes())
+		buf.Write(compressed)
+
+		width := 0
+		if len(dictionary) > 1 {
+			width = bits.Len(uint(len(dictionary) - 1))
+		}
+		encoded = append([]byte{byte(width)}, bitPacked(keys, width)...)
+		chunk.encoding = 2
+		if p.PageV2 {
+			chunk.encoding = 8
+		}
+	} else {
+		encoded = plain(defined)
+	}
+
+	chunk.dataOffset = int64(buf.Len())
+	levelsEncoded := rle(levels)
+	w := newThriftWriter()
+	var page []byte
+	if p.PageV2 {
+		compressed := p.compress(encoded)
+		page = append(levelsEncoded, compressed...)
+		w.i32(1, 3)
+		w.i32(2, int64(len(levelsEncoded)+len(encoded)))
+		w.i32(3, int64(len(page)))
+		w.begin(8)
+		w.i32(1, int64(len(values)))
+		w.i32(2, int64(len(values)-len(defined)))
+		w.i32(3, int64(len(values)))
+		w.i32(4, chunk.encoding)
+		w.i32(5, int64(len(levelsEncoded)))
+		w.i32(6, 0)
+		w.end()
+	} else {
+		uncompressed := make([]byte, 4, 4+len(levelsEncoded)+len(encoded))
+		binary.LittleEndian.PutUint32(uncompressed, uint32(len(levelsEncoded)))
+		uncompressed = append(append(uncompressed, levelsEncoded...), encoded...)
+		page = p.compress(uncompressed)
+		w.i32(1, 0)
+		w.i32(2, int64(len(uncompressed)))
+		w.i32(3, int64(len(page)))
+		w.begin(5)
+		w.i32(1, int64(len(values)))
+		w.i32(2, chunk.encoding)
+		w.i32(3, 3)
+		w.i32(4, 3)
+		w.end()
+	}
+	w.end()
+	buf.Write(w.buf.Bytes())
+	buf.Write(page)
+
+	chunk.size = int64(buf.Len()) - chunk.offset
+	return chunk
+}
+
+func firstValue(values []interface{}) interface{} {
+	for _, v := range values {
+		if v != nil {
+			return v
+		}
+	}
+	return nil
+}
+
+// parquetType returns the physical type of the values
+func parquetType(values []interface{}) int {
+	switch firstValue(values).(type) {
+	case bool:
+		return 0
+	case int64, time.Time:
+		return 2
+	case float64:
+		return 5
+	}
+	return 6
+}
+
+// plain encodes the values plain
+func plain(values []interface{}) []byte {
+	var buf bytes.Buffer
+	var bools []int
+	for _, v := range values {
+		switch v := v.(type) {
+		case string:
+			_ = binary.Write(&buf, binary.LittleEndian, uint32(len(v)))
+			buf.WriteString(v)
+		case int64:
+			_ = binary.Write(&buf, binary.LittleEndian, v)
+		case time.Time:
+			_ = binary.Write(&buf, binary.LittleEndian, v.UnixNano()/1000)
+		case float64:
+			_ = binary.Write(&buf, binary.LittleEndian, math.Float64bits(v))
+		case bool:
+			b := 0
+			if v {
+				b = 1
+			}
+			bools = append(bools, b)
+		}
+	}
+	if len(bools) > 0 {
+		return pack(bools, 1)
+	}
+	return buf.Bytes()
+}
+
+// bitPacked encodes the values as a bit-packed run of the hybrid encoding
+func bitPacked(values []int, width int) []byte {
+	groups := (len(values) + 7) / 8
+	return append(uvarint(uint64(groups<<1|1)), pack(values, width)...)
+}
+
+// pack packs the values in groups of 8 starting from the least significant
+// bit
+func pack(values []int, width int) []byte {
+	packed := make([]byte, (len(values)+7)/8*width)
+	for i, v := range values {
+		for b := 0; b < width; b++ {
+			if v>>uint(b)&1 == 1 {
+				bit := i*width + b
+				packed[bit/8] |= 1 << uint(bit%8)
+			}
+		}
+	}
+	return packed
+}
+
+// rle encodes levels of bit width 1 as runs of the hybrid encoding
+func rle(levels []int) []byte {
+	var buf bytes.Buffer
+	for start := 0; start < len(levels); {
+		end := start
+		for end < len(levels) && levels[end] == levels[start] {
+			end++
+		}
+		buf.Write(uvarint(uint64(end-start) << 1))
+		buf.WriteByte(byte(levels[start]))
+		start = end
+	}
+	return buf.Bytes()
+}
+
+func uvarint(v uint64) []byte {
+	b := make([]byte, binary.MaxVarintLen64)
+	return b[:binary.PutUvarint(b, v)]
+}
+
+// types of the Thrift compact protocol
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter writes structs in the Thrift compact protocol, as used by the
+// metadata of Parquet files
+type thriftWriter struct {
+	buf bytes.Buffer
+	// last contains the last field ID of the nested structs
+	last []int16
+}
+
+func newThriftWriter() *thriftWriter {
+	return &thriftWriter{last: []int16{0}}
+}
+
+func (w *thriftWriter) zigzag(v int64) {
+	w.buf.Write(uvarint(uint64(v<<1) ^ uint64(v>>63)))
+}
+
+func (w *thriftWriter) field(id int16, typ byte) {
+	last := &w.last[len(w.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		w.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		w.buf.WriteByte(typ)
+		w.zigzag(int64(id))
+	}
+	*last = id
+}
+
+func (w *thriftWriter) i32(id int16, v int64) {
+	w.field(id, thriftI32)
+	w.zigzag(v)
+}
+
+func (w *thriftWriter) i64(id int16, v int64) {
+	w.field(id, thriftI64)
+	w.zigzag(v)
+}
+
+func (w *thriftWriter) boolean(id int16, v bool) {
+	if v {
+		w.field(id, 1)
+	} else {
+		w.field(id, 2)
+	}
+}
+
+func (w *thriftWriter) binary(id int16, v string) {
+	w.field(id, thriftBinary)
+	w.elementBinary(v)
+}
+
+// begin starts a struct field, which is ended by end
+func (w *thriftWriter) begin(id int16) {
+	w.field(id, thriftStruct)
+	w.last = append(w.last, 0)
+}
+
+// end writes the stop field of the struct
+func (w *thriftWriter) end() {
+	w.buf.WriteByte(0)
+	w.last = w.last[:len(w.last)-1]
+}
+
+// list starts a list field of n elements
+func (w *thriftWriter) list(id int16, typ byte, n int) {
+	w.field(id, thriftList)
+	if n < 15 {
+		w.buf.WriteByte(byte(n)<<4 | typ)
+	} else {
+		w.buf.WriteByte(0xf0 | typ)
+		w.buf.Write(uvarint(uint64(n)))
+	}
+}
+
+// element starts a struct element of a list, which is ended by end
+func (w *thriftWriter) element() {
+	w.last = append(w.last, 0)
+}
+
+func (w *thriftWriter) elementI32(v int64) {
+	w.zigzag(v)
+}
+
+func (w *thriftWriter) elementBinary(v string) {
+	w.buf.Write(uvarint(uint64(len(v))))
+	w.buf.WriteString(v)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/simonswine/cloud-billing-exporter/aws"
+	"github.com/simonswine/cloud-billing-exporter/gcp"
+	"github.com/simonswine/cloud-billing-exporter/localfs"
+)
+
+// featureCollector exposes which collectors and optional features are
+// enabled, to track the configuration of the exporters across a fleet
+type featureCollector struct {
+	desc     *prometheus.Desc
+	features map[string]bool
+}
+
+func newFeatureCollector(features map[string]bool) *featureCollector {
+	return &featureCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(AppName, "", "feature_enabled"),
+			"Whether the collector or feature is enabled.",
+			[]string{"feature"},
+			nil,
+		),
+		features: features,
+	}
+}
+
+func (f *featureCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- f.desc
+}
+
+func (f *featureCollector) Collect(ch chan<- prometheus.Metric) {
+	for feature, enabled := range f.features {
+		value := 0.0
+		if enabled {
+			value = 1
+		}
+		ch <- prometheus.MustNewConstMetric(f.desc, prometheus.GaugeValue, value, feature)
+	}
+}
+
+// features returns whether the collectors and optional features are enabled
+// by the flags or the config file
+func (b *BillingCollector) features() map[string]bool {
+	return map[string]bool{
+		"aws":                     *b.AWSBucketName != "" || *b.AWSAthenaDatabase != "" || b.configured("aws"),
+		"aws_athena":              *b.AWSAthenaDatabase != "",
+		"aws_hourly_costs":        *b.AWSAthenaDatabase != "" && *b.AWSAthenaHourlyWindow > 0,
+		"aws_accounts_manifest":   *b.AWSAccountsManifest != "",
+		"aws_resumable_downloads": *b.AWSDownloadDir != "",
+		"aws_record_types":        *b.AWSRecordTypes != strings.Join(aws.DefaultRecordTypes, ","),
+		"aws_report_key_pattern":  *b.AWSReportKeyPattern != aws.DefaultReportKeyPattern,
+		"aws_cur":                 *b.AWSReportType == aws.ReportTypeCUR,
+		"aws_cur_tag":             *b.AWSCURTag != "",
+		"aws_inactive_accounts":   *b.AWSInactiveAccounts != aws.InactiveAccountsKeep,
+		"aws_role":                *b.AWSRoleARN != "",
+		"aws_pricing":             *b.AWSPricingInstanceTypes != "",
+		"gcp":                     *b.GCPBucketName != "" || *b.GCPBigQueryTable != "" || b.configured("gcp"),
+		"gcp_bigquery":            *b.GCPBigQueryTable != "",
+		"gcp_asset_inventory":     *b.GCPAssetInventory != "",
+		"gcp_report_prefix_match": *b.GCPReportPrefixMatch != gcp.ReportPrefixMatchPrefix,
+		"gcp_pricing":             *b.GCPPricingSKUs != "",
+		"local_reports":           strings.HasPrefix(*b.AWSBucketName, localfs.Scheme) || strings.HasPrefix(*b.GCPBucketName, localfs.Scheme),
+		"azure":                   *b.AzureContainerURL != "" || b.configured("azure"),
+		"focus":                   *b.FOCUSURL != "" || b.configured("focus"),
+		"opencost":                *b.OpenCostURL != "",
+		"simulation":              *b.SimulateFixture != "",
+		"enrichment":              !*b.DisableEnrichment,
+		"owner_label":             !*b.DisableOwnerLabel,
+		"path_label":              !*b.DisablePathLabel,
+		"categories":              *b.CategoriesFile != "",
+		"sku_label":               *b.SKULabel,
+		"teams":                   *b.TeamsFile != "",
+		"labels_from_tags":        *b.LabelsFromTags != "",
+		"http_enrichment":         *b.EnrichmentURL != "",
+		"directory":               *b.DirectoryEnabled,
+		"metrics_file":            *b.MetricsFile != "",
+		"group_by":                *b.GroupBy != "",
+		"currency_conversion":     *b.TargetCurrency != "",
+		"label_filter":            !b.labelFilter.empty(),
+		"max_series":              *b.MaxSeries > 0,
+		"max_staleness":           *b.MaxStaleness > 0,
+		"fiscal_calendar":         *b.FiscalCalendar != "",
+		"weekly_costs":            *b.WeeklyCostsWeeks > 0,
+		"top_n":                   *b.TopN > 0,
+		"state":                   *b.StateURL != "",
+		"export":                  *b.ExportURL != "",
+		"postgres":                *b.PostgresDSN != "",
+		"kafka":                   *b.KafkaBrokers != "",
+		"sqlite":                  *b.SQLitePath != "",
+		"grpc":                    *b.GRPCListenAddress != "",
+		"parse_spill":             *b.ParseMemoryBudget > 0,
+		"pprof":                   *b.EnablePprof,
+		"config_file":             *b.ConfigFile != "",
+		"background_refresh":      *b.CollectInterval > 0,
+		"billing_account_label":   b.monthlyCosts != nil && b.monthlyCosts.hasLabel(billingAccountLabel),
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestFeatureCollector(t *testing.T) {
+	c := newFeatureCollector(map[string]bool{"aws": true, "gcp": false})
+
+	exp := `
+# HELP cloud_billing_exporter_feature_enabled Whether the collector or feature is enabled.
+# TYPE cloud_billing_exporter_feature_enabled gauge
+cloud_billing_exporter_feature_enabled{feature="aws"} 1
+cloud_billing_exporter_feature_enabled{feature="gcp"} 0
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(exp)); err != nil {
+		t.Errorf("unexpected metrics: %s", err)
+	}
+}
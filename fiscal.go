@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+// daysPerWeeksYear is the length of a fiscal year of whole weeks
+const daysPerWeeksYear = 52 * 7
+
+// fiscalCalendar maps days to fiscal periods, either months starting on
+// another day than the 1st or periods of whole weeks like 4-4-5 calendars.
+// Fiscal years are named by the calendar year they end in.
+type fiscalCalendar struct {
+	// monthStartDay is the day of the calendar month the fiscal month
+	// starts on, the fiscal month is named after that calendar month
+	monthStartDay int
+	// yearStartMonth is the calendar month the fiscal year starts in
+	yearStartMonth time.Month
+
+	// weeks are the number of weeks of the periods of each quarter, counted
+	// from yearStart
+	weeks     []int
+	yearStart time.Time
+}
+
+// parseFiscalCalendar parses a comma separated list of key=value options,
+// either month-start-day and year-start-month or weeks and year-start, e.g.
+// month-start-day=15,year-start-month=4 or weeks=4-4-5,year-start=2019-12-29
+func parseFiscalCalendar(spec string) (*fiscalCalendar, error) {
+	c := &fiscalCalendar{monthStartDay: 1, yearStartMonth: time.January}
+	monthly := false
+	for _, option := range strings.Split(spec, ",") {
+		parts := strings.SplitN(option, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid fiscal calendar option '%s', expected key=value", option)
+		}
+		switch key, value := parts[0], parts[1]; key {
+		case "month-start-day":
+			day, err := strconv.Atoi(value)
+			if err != nil || day < 1 || day > 28 {
+				return nil, fmt.Errorf("invalid month-start-day '%s', expected a day between 1 and 28", value)
+			}
+			c.monthStartDay = day
+			monthly = true
+		case "year-start-month":
+			month, err := strconv.Atoi(value)
+			if err != nil || month < 1 || month > 12 {
+				return nil, fmt.Errorf("invalid year-start-month '%s', expected a month between 1 and 12", value)
+			}
+			c.yearStartMonth = time.Month(month)
+			monthly = true
+		case "weeks":
+			total := 0
+			for _, w := range strings.Split(value, "-") {
+				n, err := strconv.Atoi(w)
+				if err != nil || n < 1 {
+					return nil, fmt.Errorf("invalid weeks '%s', expected e.g. 4-4-5", value)
+				}
+				c.weeks = append(c.weeks, n)
+				total += n
+			}
+			if total != 13 {
+				return nil, fmt.Errorf("invalid weeks '%s', the periods of a quarter need to have 13 weeks", value)
+			}
+		case "year-start":
+			t, err := time.Parse(dateFormat, value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid year-start '%s': %s", value, err)
+			}
+			c.yearStart = t
+		default:
+			return nil, fmt.Errorf("unknown fiscal calendar option '%s'", key)
+		}
+	}
+
+	if len(c.weeks) > 0 && monthly {
+		return nil, fmt.Errorf("weeks can't be combined with month-start-day or year-start-month")
+	}
+	if (len(c.weeks) > 0) != !c.yearStart.IsZero() {
+		return nil, fmt.Errorf("weeks and year-start need to be set together")
+	}
+	return c, nil
+}
+
+// period returns the fiscal year, quarter and period of the day
+func (c *fiscalCalendar) period(day time.Time) (year, quarter, period int) {
+	if len(c.weeks) > 0 {
+		days := int(day.Sub(c.yearStart).Hours() / 24)
+		years := days / daysPerWeeksYear
+		if days < 0 && days%daysPerWeeksYear != 0 {
+			years--
+		}
+		week := (days - years*daysPerWeeksYear) / 7
+		for period = 0; week >= c.weeks[period%len(c.weeks)]; period++ {
+			week -= c.weeks[period%len(c.weeks)]
+		}
+		year = c.yearStart.AddDate(0, 0, (years+1)*daysPerWeeksYear-1).Year()
+		return year, period/len(c.weeks) + 1, period + 1
+	}
+
+	year, month := day.Year(), day.Month()
+	if day.Day() < c.monthStartDay {
+		month--
+		if month < time.January {
+			month = time.December
+			year--
+		}
+	}
+	if c.yearStartMonth > time.January && month >= c.yearStartMonth {
+		year++
+	}
+	period = (int(month)-int(c.yearStartMonth)+12)%12 + 1
+	return year, (period-1)/3 + 1, period
+}
+
+// fiscalCollector exposes the costs of the line items aggregated to fiscal
+// periods
+type fiscalCollector struct {
+	calendar *fiscalCalendar
+	items    *lineItemHistory
+	desc     *prometheus.Desc
+}
+
+func newFiscalCollector(spec string, items *lineItemHistory) (*fiscalCollector, error) {
+	calendar, err := parseFiscalCalendar(spec)
+	if err != nil {
+		return nil, err
+	}
+	return &fiscalCollector{
+		calendar: calendar,
+		items:    items,
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "billing", "fiscal_period_costs"),
+			"Costs per fiscal period. Costs of reports without daily granularity are spread evenly over the days of their month.",
+			[]string{"cloud", "currency", "account", "service", "fiscal_year", "fiscal_quarter", "fiscal_period"},
+			nil,
+		),
+	}, nil
+}
+
+func (f *fiscalCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- f.desc
+}
+
+func (f *fiscalCollector) Collect(ch chan<- prometheus.Metric) {
+	totals := make(map[[7]string]float64)
+	f.items.eachDay(true, func(i billing.LineItem, day time.Time, costs float64) {
+		year, quarter, period := f.calendar.period(day)
+		totals[[7]string{
+			i.Cloud,
+			i.Currency,
+			i.Account,
+			i.Service,
+			strconv.Itoa(year),
+			strconv.Itoa(quarter),
+			strconv.Itoa(period),
+		}] += costs
+	})
+
+	for key, value := range totals {
+		ch <- prometheus.MustNewConstMetric(f.desc, prometheus.GaugeValue, value, key[:]...)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+func TestFiscalCalendarPeriod(t *testing.T) {
+	for _, c := range []struct {
+		spec string
+		day  string
+		exp  string
+	}{
+		{"month-start-day=1", "2019-11-14", "2019/4/11"},
+		{"month-start-day=15", "2019-11-14", "2019/4/10"},
+		{"month-start-day=15", "2019-11-15", "2019/4/11"},
+		{"month-start-day=15", "2020-01-14", "2019/4/12"},
+		{"year-start-month=4", "2019-03-31", "2019/4/12"},
+		{"year-start-month=4", "2019-04-01", "2020/1/1"},
+		{"month-start-day=15,year-start-month=4", "2019-04-14", "2019/4/12"},
+		{"weeks=4-4-5,year-start=2019-12-29", "2019-12-29", "2020/1/1"},
+		{"weeks=4-4-5,year-start=2019-12-29", "2020-01-26", "2020/1/2"},
+		{"weeks=4-4-5,year-start=2019-12-29", "2020-03-22", "2020/1/3"},
+		{"weeks=4-4-5,year-start=2019-12-29", "2020-03-29", "2020/2/4"},
+		{"weeks=4-4-5,year-start=2019-12-29", "2020-12-26", "2020/4/12"},
+		{"weeks=4-4-5,year-start=2019-12-29", "2020-12-27", "2021/1/1"},
+		{"weeks=4-4-5,year-start=2019-12-29", "2019-12-28", "2019/4/12"},
+	} {
+		calendar, err := parseFiscalCalendar(c.spec)
+		if err != nil {
+			t.Fatalf("unexpected error parsing '%s': %s", c.spec, err)
+		}
+		day, err := time.Parse(dateFormat, c.day)
+		if err != nil {
+			t.Fatal(err)
+		}
+		year, quarter, period := calendar.period(day)
+		if act := fmt.Sprintf("%d/%d/%d", year, quarter, period); act != c.exp {
+			t.Errorf("unexpected period of %s in '%s': %s (expected: %s)", c.day, c.spec, act, c.exp)
+		}
+	}
+
+	for _, spec := range []string{"month-start-day=29", "weeks=4-4-4,year-start=2019-12-29", "weeks=4-4-5", "weeks=4-4-5,year-start=2019-12-29,year-start-month=4", "quarter=1"} {
+		if _, err := parseFiscalCalendar(spec); err == nil {
+			t.Errorf("expected error parsing '%s'", spec)
+		}
+	}
+}
+
+func TestFiscalCollector(t *testing.T) {
+	items := newLineItemHistory()
+	f, err := newFiscalCollector("month-start-day=15", items)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	items.update([]billing.LineItem{
+		{Cloud: "gcp", Month: "2019-10", Date: "2019-10-20", Currency: "USD", Account: "a", Service: "compute", Costs: 1},
+		{Cloud: "aws", Month: "2019-10", Currency: "USD", Account: "b", Service: "AmazonEC2", Costs: 31},
+	})
+	items.update([]billing.LineItem{
+		{Cloud: "gcp", Month: "2019-11", Date: "2019-11-01", Currency: "USD", Account: "a", Service: "compute", Costs: 2},
+		{Cloud: "gcp", Month: "2019-11", Date: "2019-11-15", Currency: "USD", Account: "a", Service: "compute", Costs: 4},
+	})
+
+	if err := testutil.CollectAndCompare(f, strings.NewReader(`
+# HELP cloud_billing_fiscal_period_costs Costs per fiscal period. Costs of reports without daily granularity are spread evenly over the days of their month.
+# TYPE cloud_billing_fiscal_period_costs gauge
+cloud_billing_fiscal_period_costs{account="a",cloud="gcp",currency="USD",fiscal_period="10",fiscal_quarter="4",fiscal_year="2019",service="compute"} 3
+cloud_billing_fiscal_period_costs{account="a",cloud="gcp",currency="USD",fiscal_period="11",fiscal_quarter="4",fiscal_year="2019",service="compute"} 4
+cloud_billing_fiscal_period_costs{account="b",cloud="aws",currency="USD",fiscal_period="10",fiscal_quarter="4",fiscal_year="2019",service="AmazonEC2"} 17
+cloud_billing_fiscal_period_costs{account="b",cloud="aws",currency="USD",fiscal_period="9",fiscal_quarter="3",fiscal_year="2019",service="AmazonEC2"} 14
+`)); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,529 @@
+// Package focus collects costs from exports following the FinOps Open Cost
+// and Usage Specification (FOCUS), see https://focus.finops.org. Exports of
+// other FinOps tools are read by mapping their columns onto FOCUS columns.
+package focus
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+	"github.com/simonswine/cloud-billing-exporter/objstore"
+	"github.com/simonswine/cloud-billing-exporter/parquet"
+	"github.com/simonswine/cloud-billing-exporter/state"
+)
+
+// bucket lists and reads the exported objects
+type bucket interface {
+	objstore.Bucket
+	objstore.Lister
+}
+
+// export contains the aggregated costs of an exported object
+type export struct {
+	hash      string
+	records   map[string]*billing.Record
+	lineItems map[string]*billing.LineItem
+	usage     []billing.Usage
+}
+
+// FOCUSBilling reads FOCUS exports in CSV or Parquet format from object
+// storage. All
+// exports below the prefix are read, only the latest billing period is
+// exposed.
+type FOCUSBilling struct {
+	cloud  string
+	bucket bucket
+	prefix string
+	format Format
+
+	lock    sync.Mutex
+	exports map[string]*export
+
+	records     []billing.Record
+	lineItems   []billing.LineItem
+	usage       []billing.Usage
+	recordsLock sync.Mutex
+
+	MetricMonthlyCosts *prometheus.CounterVec
+	metricRefunds      *prometheus.CounterVec
+	onDecrease         billing.OnDecrease
+	metricValues       map[string]state.Baseline
+
+	stateStore    state.Store
+	stateRestored bool
+}
+
+// focusBillingState is the part of FOCUSBilling persisted in the state store
+type focusBillingState struct {
+	Baselines map[string]state.Baseline
+}
+
+// NewFOCUSBilling reads the exports below the prefix of the bucket URL, the
+// costs are exposed with the given cloud label
+func NewFOCUSBilling(ctx context.Context, metric *prometheus.CounterVec, bucketURL, cloud string) (*FOCUSBilling, error) {
+	b, err := objstore.Parse(ctx, bucketURL)
+	if err != nil {
+		return nil, err
+	}
+	l, ok := b.(bucket)
+	if !ok {
+		return nil, fmt.Errorf("listing objects is not supported by %s", b)
+	}
+	return newFOCUSBilling(metric, l, cloud), nil
+}
+
+func newFOCUSBilling(metric *prometheus.CounterVec, b bucket, cloud string) *FOCUSBilling {
+	return &FOCUSBilling{
+		cloud:              cloud,
+		bucket:             b,
+		format:             FormatFOCUS,
+		exports:            make(map[string]*export),
+		MetricMonthlyCosts: metric,
+		metricValues:       make(map[string]state.Baseline),
+	}
+}
+
+// WithStateStore enables persisting the collector state in the given store
+func (f *FOCUSBilling) WithStateStore(s state.Store) *FOCUSBilling {
+	f.stateStore = s
+	return f
+}
+
+// WithOnDecrease selects how falling costs are handled, the refunds counter
+// is used by billing.OnDecreaseRefundMetric
+func (f *FOCUSBilling) WithOnDecrease(mode billing.OnDecrease, refunds *prometheus.CounterVec) *FOCUSBilling {
+	f.onDecrease = mode
+	f.metricRefunds = refunds
+	return f
+}
+
+// WithFormat reads exports in the given format instead of FOCUS
+func (f *FOCUSBilling) WithFormat(format Format) *FOCUSBilling {
+	f.format = format
+	return f
+}
+
+func (f *FOCUSBilling) stateKey() string {
+	return fmt.Sprintf("focus/%s", f.cloud)
+}
+
+// restoreState loads the persisted baselines once, before the first export
+// gets parsed
+func (f *FOCUSBilling) restoreState(ctx context.Context) error {
+	if f.stateStore == nil || f.stateRestored {
+		return nil
+	}
+
+	var s focusBillingState
+	if err := state.Load(ctx, f.stateStore, f.stateKey(), &s); err == state.ErrNotFound {
+		log.Debugf("no previous state for '%s' found in %s", f.stateKey(), f.stateStore)
+	} else if err != nil {
+		return fmt.Errorf("error restoring state from %s: %s", f.stateStore, err)
+	}
+
+	for key, baseline := range s.Baselines {
+		if _, err := f.MetricMonthlyCosts.GetMetricWithLabelValues(baseline.Labels...); err != nil {
+			log.Warnf("dropping baseline '%s' restored from %s: %s", key, f.stateStore, err)
+			continue
+		}
+		f.metricValues[key] = baseline
+	}
+	f.stateRestored = true
+	return nil
+}
+
+func (f *FOCUSBilling) saveState(ctx context.Context) {
+	if f.stateStore == nil {
+		return
+	}
+
+	if err := state.Save(ctx, f.stateStore, f.stateKey(), &focusBillingState{
+		Baselines: f.metricValues,
+	}); err != nil {
+		log.Warnf("error persisting state to %s: %s", f.stateStore, err)
+	}
+}
+
+// columns are the FOCUS columns used, the ones not required can be empty
+var columns = []struct {
+	name     string
+	required bool
+}{
+	{"BillingPeriodStart", true},
+	{"ChargePeriodStart", false},
+	{"BilledCost", true},
+	{"BillingCurrency", true},
+	{"ServiceName", true},
+	{"SubAccountId", true},
+	{"SubAccountName", false},
+	{"SkuId", false},
+	{"ConsumedQuantity", false},
+	{"ConsumedUnit", false},
+}
+
+// readCSV aggregates the costs of a CSV export
+func (f *FOCUSBilling) readCSV(input io.Reader) (*export, error) {
+	r := csv.NewReader(input)
+	r.ReuseRecord = true
+
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading header: %s", err)
+	}
+	return f.readRows(header, r.Read)
+}
+
+// readParquet aggregates the costs of a Parquet export, only the columns
+// used are decoded
+func (f *FOCUSBilling) readParquet(data []byte) (*export, error) {
+	p, err := parquet.Open(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	available := make(map[string]bool)
+	for _, name := range p.Columns() {
+		available[name] = true
+	}
+	var header []string
+	for _, c := range columns {
+		if name := f.format.column(c.name); available[name] {
+			header = append(header, name)
+		}
+	}
+	r, err := p.Reader(header...)
+	if err != nil {
+		return nil, err
+	}
+	return f.readRows(header, r.Read)
+}
+
+// readRows aggregates the costs of the rows of an export with the header per
+// billing period, sub account, service and currency
+func (f *FOCUSBilling) readRows(header []string, read func() ([]string, error)) (*export, error) {
+	pos := make(map[string]int)
+	for i, name := range header {
+		// exports can start with a byte order mark
+		pos[strings.TrimPrefix(name, "\ufeff")] = i
+	}
+	for _, c := range columns {
+		if !c.required || (c.name == "BillingCurrency" && f.format.Currency != "") {
+			continue
+		}
+		if _, ok := pos[f.format.column(c.name)]; !ok {
+			return nil, fmt.Errorf("required column '%s' missing", f.format.column(c.name))
+		}
+	}
+	value := func(row []string, name string) string {
+		if i, ok := pos[f.format.column(name)]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+
+	e := &export{
+		records:   make(map[string]*billing.Record),
+		lineItems: make(map[string]*billing.LineItem),
+	}
+	for {
+		row, err := read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		period := value(row, "BillingPeriodStart")
+		if len(period) < 7 {
+			log.Warnf("invalid billing period start '%s'", period)
+			continue
+		}
+		costs, err := strconv.ParseFloat(value(row, "BilledCost"), 64)
+		if err != nil {
+			log.Warnf("Couldn't parse costs float: %s", err)
+			continue
+		}
+
+		account := value(row, "SubAccountName")
+		if account == "" {
+			account = value(row, "SubAccountId")
+		}
+		currency := value(row, "BillingCurrency")
+		if currency == "" {
+			currency = f.format.Currency
+		}
+		record := billing.Record{
+			Cloud:    f.cloud,
+			Month:    period[:7],
+			Currency: currency,
+			Account:  account,
+			Service:  value(row, "ServiceName"),
+		}
+		key := strings.Join([]string{record.Month, record.Account, record.Service, record.Currency}, "\x00")
+		if _, ok := e.records[key]; !ok {
+			e.records[key] = &record
+		}
+		e.records[key].Costs += costs
+
+		if date := value(row, "ChargePeriodStart"); len(date) >= 10 {
+			itemKey := key + "\x00" + date[:10]
+			if _, ok := e.lineItems[itemKey]; !ok {
+				e.lineItems[itemKey] = &billing.LineItem{
+					Cloud:    record.Cloud,
+					Month:    record.Month,
+					Date:     date[:10],
+					Currency: record.Currency,
+					Account:  record.Account,
+					Service:  record.Service,
+				}
+			}
+			e.lineItems[itemKey].Costs += costs
+		}
+
+		if sku := value(row, "SkuId"); sku != "" {
+			quantity, err := strconv.ParseFloat(value(row, "ConsumedQuantity"), 64)
+			if err != nil {
+				continue
+			}
+			e.usage = append(e.usage, billing.Usage{
+				Cloud:    f.cloud,
+				Month:    record.Month,
+				Service:  record.Service,
+				SKU:      sku,
+				Unit:     value(row, "ConsumedUnit"),
+				Currency: record.Currency,
+				Quantity: quantity,
+				Costs:    costs,
+			})
+		}
+	}
+	e.usage = billing.MergeUsage(e.usage)
+	return e, nil
+}
+
+// readExport downloads and parses an exported object
+func (f *FOCUSBilling) readExport(ctx context.Context, o objstore.Object) (*export, error) {
+	data, err := f.bucket.Get(ctx, o.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasSuffix(o.Name, ".parquet") {
+		e, err := f.readParquet(data)
+		if err != nil {
+			return nil, err
+		}
+		e.hash = o.Hash
+		return e, nil
+	}
+
+	var input io.Reader = bytes.NewReader(data)
+	if strings.HasSuffix(o.Name, ".gz") {
+		gz, err := gzip.NewReader(input)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		input = gz
+	}
+
+	e, err := f.readCSV(input)
+	if err != nil {
+		return nil, err
+	}
+	e.hash = o.Hash
+	return e, nil
+}
+
+func (f *FOCUSBilling) Query() error {
+	ctx := context.Background()
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.restoreState(ctx); err != nil {
+		return err
+	}
+
+	objects, err := f.bucket.List(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	exports := make(map[string]*export)
+	for _, o := range objects {
+		switch {
+		case strings.HasSuffix(o.Name, ".csv"), strings.HasSuffix(o.Name, ".csv.gz"), strings.HasSuffix(o.Name, ".parquet"):
+		default:
+			continue
+		}
+
+		if e, ok := f.exports[o.Name]; ok && e.hash == o.Hash {
+			exports[o.Name] = e
+			continue
+		}
+		e, err := f.readExport(ctx, o)
+		if err != nil {
+			log.Warnf("failed to read %s export '%s': %s", f.format.Name, o.Name, err)
+			// keep the previous version of the export
+			if previous, ok := f.exports[o.Name]; ok {
+				exports[o.Name] = previous
+			}
+			continue
+		}
+		log.Debugf("parsed %s export '%s'", f.format.Name, o.Name)
+		exports[o.Name] = e
+	}
+	f.exports = exports
+
+	f.update(ctx)
+	return nil
+}
+
+// update exposes the costs of the latest billing period of all exports, it
+// needs to be called with the lock held
+func (f *FOCUSBilling) update(ctx context.Context) {
+	var month string
+	for _, e := range f.exports {
+		for _, r := range e.records {
+			if r.Month > month {
+				month = r.Month
+			}
+		}
+	}
+
+	records := make(map[string]*billing.Record)
+	lineItems := make(map[string]*billing.LineItem)
+	var usage []billing.Usage
+	for _, e := range f.exports {
+		for key, r := range e.records {
+			if r.Month != month {
+				continue
+			}
+			if _, ok := records[key]; !ok {
+				record := *r
+				record.Costs = 0
+				records[key] = &record
+			}
+			records[key].Costs += r.Costs
+		}
+		for key, i := range e.lineItems {
+			if i.Month != month {
+				continue
+			}
+			if _, ok := lineItems[key]; !ok {
+				item := *i
+				item.Costs = 0
+				lineItems[key] = &item
+			}
+			lineItems[key].Costs += i.Costs
+		}
+		for _, u := range e.usage {
+			if u.Month == month {
+				usage = append(usage, u)
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(records))
+	for key := range records {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	changed := false
+	result := make([]billing.Record, 0, len(records))
+	for _, key := range keys {
+		record := *records[key]
+		result = append(result, record)
+
+		labels := record.Labels()
+		baselineKey := strings.Join(labels, "\x00")
+		previous := f.metricValues[baselineKey].Previous(record.Month)
+		if !f.onDecrease.AddCosts(f.MetricMonthlyCosts, f.metricRefunds, labels, previous, record.Costs) {
+			log.With("account", record.Account).With("service", record.Service).Warnf("costs are falling by: '%f'", record.Costs-previous)
+			continue
+		}
+		if previous, ok := f.metricValues[baselineKey]; !ok || previous.Value != record.Costs || previous.Month != record.Month || !reflect.DeepEqual(previous.Labels, labels) {
+			changed = true
+		}
+		f.metricValues[baselineKey] = state.Baseline{Labels: labels, Value: record.Costs, Month: record.Month}
+	}
+
+	items := make([]billing.LineItem, 0, len(lineItems))
+	for _, i := range lineItems {
+		items = append(items, *i)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if a.Date != b.Date {
+			return a.Date < b.Date
+		}
+		if a.Account != b.Account {
+			return a.Account < b.Account
+		}
+		return a.Service < b.Service
+	})
+
+	f.recordsLock.Lock()
+	f.records = result
+	f.lineItems = items
+	f.usage = billing.MergeUsage(usage)
+	f.recordsLock.Unlock()
+
+	if changed {
+		f.saveState(ctx)
+	}
+}
+
+// Records returns the costs of the latest billing period
+func (f *FOCUSBilling) Records() []billing.Record {
+	f.recordsLock.Lock()
+	defer f.recordsLock.Unlock()
+	return append([]billing.Record(nil), f.records...)
+}
+
+// LineItems returns the costs per day of the latest billing period
+func (f *FOCUSBilling) LineItems() []billing.LineItem {
+	f.recordsLock.Lock()
+	defer f.recordsLock.Unlock()
+	return append([]billing.LineItem(nil), f.lineItems...)
+}
+
+// Usage returns the consumed quantity per SKU of the latest billing period
+func (f *FOCUSBilling) Usage() []billing.Usage {
+	f.recordsLock.Lock()
+	defer f.recordsLock.Unlock()
+	return append([]billing.Usage(nil), f.usage...)
+}
+
+// CheckCredentials verifies the credentials by listing the exports
+func (f *FOCUSBilling) CheckCredentials(ctx context.Context) error {
+	_, err := f.bucket.List(ctx, "")
+	return err
+}
+
+func (f *FOCUSBilling) Test() error {
+	return f.Query()
+}
+
+func (f *FOCUSBilling) String() string {
+	return fmt.Sprintf("%s exports in %s", f.format.Name, f.bucket)
+}
+
+// Cloud returns the name of the cloud, as used in the cloud label
+func (f *FOCUSBilling) Cloud() string {
+	return f.cloud
+}
@@ -0,0 +1,278 @@
+package focus
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+	"github.com/simonswine/cloud-billing-exporter/fake"
+	"github.com/simonswine/cloud-billing-exporter/objstore"
+)
+
+type memoryBucket map[string]string
+
+func (m memoryBucket) Get(_ context.Context, name string) ([]byte, error) {
+	data, ok := m[name]
+	if !ok {
+		return nil, objstore.ErrNotExist
+	}
+	return []byte(data), nil
+}
+
+func (m memoryBucket) Put(_ context.Context, name string, data []byte, _ string) error {
+	m[name] = string(data)
+	return nil
+}
+
+func (m memoryBucket) List(_ context.Context, prefix string) ([]objstore.Object, error) {
+	var objects []objstore.Object
+	for name, data := range m {
+		if strings.HasPrefix(name, prefix) {
+			objects = append(objects, objstore.Object{Name: name, Hash: data, Size: int64(len(data))})
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+	return objects, nil
+}
+
+func (m memoryBucket) String() string {
+	return "memory"
+}
+
+const exportHeader = "BilledCost,BillingCurrency,BillingPeriodStart,ChargePeriodStart,ServiceName,SubAccountId,SubAccountName,SkuId,ConsumedQuantity,ConsumedUnit\n"
+
+func gzipped(t *testing.T, s string) string {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestFOCUSBilling(t *testing.T) {
+	bucket := memoryBucket{
+		"2019-10/export.csv": exportHeader +
+			"100,USD,2019-10-01T00:00:00Z,2019-10-01T00:00:00Z,Virtual Machines,sub-1,prod,vm-sku,720,Hours\n",
+		"2019-11/export-1.csv": exportHeader +
+			"10,USD,2019-11-01T00:00:00Z,2019-11-01T00:00:00Z,Virtual Machines,sub-1,prod,vm-sku,24,Hours\n" +
+			"5,USD,2019-11-01T00:00:00Z,2019-11-02T00:00:00Z,Virtual Machines,sub-1,prod,vm-sku,24,Hours\n",
+		"2019-11/export-2.csv.gz": gzipped(t, exportHeader+
+			"2.5,USD,2019-11-01T00:00:00Z,2019-11-02T00:00:00Z,Storage,sub-2,,,,\n"),
+		"2019-11/export-3.parquet": fake.ParquetFile{
+			Columns: []fake.ParquetColumn{
+				{Name: "BilledCost", Values: []interface{}{1.25}},
+				{Name: "BillingCurrency", Values: []interface{}{"USD"}, Dictionary: true},
+				{Name: "BillingPeriodStart", Values: []interface{}{time.Date(2019, 11, 1, 0, 0, 0, 0, time.UTC)}},
+				{Name: "ChargePeriodStart", Values: []interface{}{time.Date(2019, 11, 3, 0, 0, 0, 0, time.UTC)}},
+				{Name: "ServiceName", Values: []interface{}{"Networking"}, Dictionary: true},
+				{Name: "SubAccountId", Values: []interface{}{"sub-2"}},
+				{Name: "SubAccountName", Values: []interface{}{nil}},
+			},
+			Compression: "SNAPPY",
+		}.String(),
+		"2019-11/broken.parquet": "not a Parquet file",
+	}
+	metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+	f := newFOCUSBilling(metric, bucket, "azure")
+
+	if err := f.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expRecords := []billing.Record{
+		{Cloud: "azure", Month: "2019-11", Currency: "USD", Account: "prod", Service: "Virtual Machines", Costs: 15},
+		{Cloud: "azure", Month: "2019-11", Currency: "USD", Account: "sub-2", Service: "Networking", Costs: 1.25},
+		{Cloud: "azure", Month: "2019-11", Currency: "USD", Account: "sub-2", Service: "Storage", Costs: 2.5},
+	}
+	if act := f.Records(); !reflect.DeepEqual(act, expRecords) {
+		t.Errorf("unexpected records: act: %+v, exp: %+v", act, expRecords)
+	}
+
+	expLineItems := []billing.LineItem{
+		{Cloud: "azure", Month: "2019-11", Date: "2019-11-01", Currency: "USD", Account: "prod", Service: "Virtual Machines", Costs: 10},
+		{Cloud: "azure", Month: "2019-11", Date: "2019-11-02", Currency: "USD", Account: "prod", Service: "Virtual Machines", Costs: 5},
+		{Cloud: "azure", Month: "2019-11", Date: "2019-11-02", Currency: "USD", Account: "sub-2", Service: "Storage", Costs: 2.5},
+		{Cloud: "azure", Month: "2019-11", Date: "2019-11-03", Currency: "USD", Account: "sub-2", Service: "Networking", Costs: 1.25},
+	}
+	if act := f.LineItems(); !reflect.DeepEqual(act, expLineItems) {
+		t.Errorf("unexpected line items: act: %+v, exp: %+v", act, expLineItems)
+	}
+
+	expUsage := []billing.Usage{
+		{Cloud: "azure", Month: "2019-11", Service: "Virtual Machines", SKU: "vm-sku", Unit: "Hours", Currency: "USD", Quantity: 48, Costs: 15},
+	}
+	if act := f.Usage(); !reflect.DeepEqual(act, expUsage) {
+		t.Errorf("unexpected usage: act: %+v, exp: %+v", act, expUsage)
+	}
+
+	// an updated export only adds the difference
+	bucket["2019-11/export-1.csv"] = exportHeader +
+		"12,USD,2019-11-01T00:00:00Z,2019-11-01T00:00:00Z,Virtual Machines,sub-1,prod,vm-sku,24,Hours\n" +
+		"5,USD,2019-11-01T00:00:00Z,2019-11-02T00:00:00Z,Virtual Machines,sub-1,prod,vm-sku,24,Hours\n"
+	if err := f.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if act := testutil.ToFloat64(metric.WithLabelValues("azure", "USD", "prod", "Virtual Machines", "", "", "", "")); act != 17 {
+		t.Errorf("unexpected costs: %f", act)
+	}
+}
+
+func TestFOCUSBillingProviderExports(t *testing.T) {
+	period := time.Date(2019, 11, 1, 0, 0, 0, 0, time.UTC)
+	bucket := memoryBucket{
+		// Parquet exports with decimal costs, split into row groups
+		"aws/data/BILLING_PERIOD=2019-11/focus-00001.snappy.parquet": fake.ParquetFile{
+			Columns: []fake.ParquetColumn{
+				{Name: "BilledCost", Values: []interface{}{int64(12500), int64(2500), int64(-1000)}, Scale: 4},
+				{Name: "BillingCurrency", Values: []interface{}{"USD", "USD", "USD"}, Dictionary: true},
+				{Name: "BillingPeriodStart", Values: []interface{}{period, period, period}, Dictionary: true},
+				{Name: "ChargePeriodStart", Values: []interface{}{period, period.AddDate(0, 0, 1), period.AddDate(0, 0, 1)}},
+				{Name: "ServiceName", Values: []interface{}{"Amazon Elastic Compute Cloud", "Amazon Elastic Compute Cloud", "Amazon Elastic Compute Cloud"}, Dictionary: true},
+				{Name: "SubAccountId", Values: []interface{}{"123456789012", "123456789012", "123456789012"}, Dictionary: true},
+				{Name: "SubAccountName", Values: []interface{}{"prod", "prod", "prod"}, Dictionary: true},
+				{Name: "SkuId", Values: []interface{}{"BoxUsage:t3.small", "BoxUsage:t3.small", nil}},
+				{Name: "ConsumedQuantity", Values: []interface{}{24.0, 4.0, nil}},
+				{Name: "ConsumedUnit", Values: []interface{}{"Hrs", "Hrs", nil}},
+				{Name: "x_ServiceCode", Values: []interface{}{"AmazonEC2", "AmazonEC2", "AmazonEC2"}},
+			},
+			RowGroupRows: 2,
+			Compression:  "SNAPPY",
+			PageV2:       true,
+		}.String(),
+		// gzipped CSV exports starting with a byte order mark
+		"azure/20191101-20191130/part_0_0001.csv.gz": gzipped(t, "\ufeff"+exportHeader+
+			"2.5,EUR,2019-11-01T00:00:00Z,2019-11-02T00:00:00Z,Storage,sub-2,dev,,,\n"),
+	}
+	metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+	f := newFOCUSBilling(metric, bucket, "focus")
+
+	if err := f.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expRecords := []billing.Record{
+		{Cloud: "focus", Month: "2019-11", Currency: "EUR", Account: "dev", Service: "Storage", Costs: 2.5},
+		{Cloud: "focus", Month: "2019-11", Currency: "USD", Account: "prod", Service: "Amazon Elastic Compute Cloud", Costs: 1.4},
+	}
+	if act := f.Records(); !reflect.DeepEqual(act, expRecords) {
+		t.Errorf("unexpected records: act: %+v, exp: %+v", act, expRecords)
+	}
+	expUsage := []billing.Usage{
+		{Cloud: "focus", Month: "2019-11", Service: "Amazon Elastic Compute Cloud", SKU: "BoxUsage:t3.small", Unit: "Hrs", Currency: "USD", Quantity: 28, Costs: 1.5},
+	}
+	if act := f.Usage(); !reflect.DeepEqual(act, expUsage) {
+		t.Errorf("unexpected usage: act: %+v, exp: %+v", act, expUsage)
+	}
+}
+
+func TestFOCUSBillingOnDecrease(t *testing.T) {
+	for _, c := range []struct {
+		mode                 billing.OnDecrease
+		expCosts, expRefunds []float64
+	}{
+		// the decrease is counted as refund
+		{billing.OnDecreaseRefundMetric, []float64{10, 10, 11}, []float64{0, 3, 3}},
+		// the counter follows the costs
+		{billing.OnDecreaseReset, []float64{10, 7, 8}, []float64{0, 0, 0}},
+		// the counter waits for the costs to exceed the previous value
+		{billing.OnDecreaseSkip, []float64{10, 10, 10}, []float64{0, 0, 0}},
+	} {
+		bucket := memoryBucket{}
+		metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+		refunds := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "refunds"}, billing.MonthlyCostsLabels)
+		f := newFOCUSBilling(metric, bucket, "azure").WithOnDecrease(c.mode, refunds)
+		labels := []string{"azure", "USD", "prod", "Virtual Machines", "", "", "", ""}
+
+		for i, costs := range []string{"10", "7", "8"} {
+			bucket["2019-11/export.csv"] = exportHeader +
+				costs + ",USD,2019-11-01T00:00:00Z,2019-11-01T00:00:00Z,Virtual Machines,sub-1,prod,vm-sku,24,Hours\n"
+			if err := f.Query(); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if act := testutil.ToFloat64(metric.WithLabelValues(labels...)); act != c.expCosts[i] {
+				t.Errorf("%s: unexpected costs: %f (expected: %f)", c.mode, act, c.expCosts[i])
+			}
+			if act := testutil.ToFloat64(refunds.WithLabelValues(labels...)); act != c.expRefunds[i] {
+				t.Errorf("%s: unexpected refunds: %f (expected: %f)", c.mode, act, c.expRefunds[i])
+			}
+		}
+	}
+}
+
+func TestReadCSVMissingColumn(t *testing.T) {
+	f := newFOCUSBilling(nil, memoryBucket{}, "focus")
+	if _, err := f.readCSV(strings.NewReader("BilledCost,BillingCurrency\n1,USD\n")); err == nil || !strings.Contains(err.Error(), "BillingPeriodStart") {
+		t.Errorf("expected missing column error, got: %v", err)
+	}
+}
+
+func TestReadParquetMissingColumn(t *testing.T) {
+	f := newFOCUSBilling(nil, memoryBucket{}, "focus")
+	export := fake.ParquetFile{Columns: []fake.ParquetColumn{
+		{Name: "BilledCost", Values: []interface{}{1.0}},
+		{Name: "BillingCurrency", Values: []interface{}{"USD"}},
+	}}.String()
+	if _, err := f.readParquet([]byte(export)); err == nil || !strings.Contains(err.Error(), "BillingPeriodStart") {
+		t.Errorf("expected missing column error, got: %v", err)
+	}
+}
+
+func TestCloudabilityFormat(t *testing.T) {
+	bucket := memoryBucket{
+		"cloudability/2019-11.csv": "Date,Vendor Account Identifier,Vendor Account Name,Service Name,Unblended Cost\n" +
+			"2019-11-01,123456789012,prod,Amazon Elastic Compute Cloud,10\n" +
+			"2019-11-02,123456789012,prod,Amazon Elastic Compute Cloud,5.5\n",
+	}
+	metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+	f := newFOCUSBilling(metric, bucket, "aws").WithFormat(FormatCloudability)
+
+	if err := f.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expRecords := []billing.Record{
+		{Cloud: "aws", Month: "2019-11", Currency: "USD", Account: "prod", Service: "Amazon Elastic Compute Cloud", Costs: 15.5},
+	}
+	if act := f.Records(); !reflect.DeepEqual(act, expRecords) {
+		t.Errorf("unexpected records: act: %+v, exp: %+v", act, expRecords)
+	}
+	if act := len(f.LineItems()); act != 2 {
+		t.Errorf("unexpected number of line items: %d", act)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	format, err := ParseFormat("cloudhealth", "BilledCost=Total Cost")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if act := format.column("BilledCost"); act != "Total Cost" {
+		t.Errorf("unexpected cost column: %s", act)
+	}
+	if act := format.column("ServiceName"); act != "Service" {
+		t.Errorf("unexpected service column: %s", act)
+	}
+	if act := FormatCloudHealth.column("BilledCost"); act != "Cost" {
+		t.Errorf("format was modified: %s", act)
+	}
+
+	if _, err := ParseFormat("unknown", ""); err == nil {
+		t.Error("expected error for unknown format")
+	}
+	if _, err := ParseFormat("focus", "BilledCost"); err == nil {
+		t.Error("expected error for invalid mapping")
+	}
+}
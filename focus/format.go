@@ -0,0 +1,101 @@
+package focus
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Format maps the columns of an export onto the FOCUS columns, so exports of
+// other FinOps tools can be read like FOCUS exports
+type Format struct {
+	Name string
+
+	// Columns maps FOCUS column names to the column names of the export,
+	// columns not listed are expected under their FOCUS name
+	Columns map[string]string
+
+	// Currency is used for exports without a BillingCurrency column
+	Currency string
+}
+
+// column returns the name of the export column holding the FOCUS column
+func (f Format) column(name string) string {
+	if c, ok := f.Columns[name]; ok {
+		return c
+	}
+	return name
+}
+
+var (
+	// FormatFOCUS reads exports following the FOCUS specification
+	FormatFOCUS = Format{Name: "focus"}
+
+	// FormatCloudability reads cost report CSV exports of Cloudability
+	FormatCloudability = Format{
+		Name: "cloudability",
+		Columns: map[string]string{
+			"BillingPeriodStart": "Date",
+			"ChargePeriodStart":  "Date",
+			"BilledCost":         "Unblended Cost",
+			"ServiceName":        "Service Name",
+			"SubAccountId":       "Vendor Account Identifier",
+			"SubAccountName":     "Vendor Account Name",
+			"SkuId":              "Usage Type",
+			"ConsumedQuantity":   "Usage Quantity",
+			"ConsumedUnit":       "Usage Unit",
+		},
+		Currency: "USD",
+	}
+
+	// FormatCloudHealth reads cost history CSV exports of CloudHealth, they
+	// only contain monthly costs
+	FormatCloudHealth = Format{
+		Name: "cloudhealth",
+		Columns: map[string]string{
+			"BillingPeriodStart": "Month",
+			"BilledCost":         "Cost",
+			"ServiceName":        "Service",
+			"SubAccountId":       "Account ID",
+			"SubAccountName":     "Account Name",
+		},
+		Currency: "USD",
+	}
+)
+
+var formats = map[string]Format{
+	FormatFOCUS.Name:        FormatFOCUS,
+	FormatCloudability.Name: FormatCloudability,
+	FormatCloudHealth.Name:  FormatCloudHealth,
+}
+
+// ParseFormat returns the export format by name, the columns override the
+// mapping of the format, e.g. "BilledCost=Total Cost,ServiceName=Product"
+func ParseFormat(name, columns string) (Format, error) {
+	format, ok := formats[name]
+	if !ok {
+		names := make([]string, 0, len(formats))
+		for n := range formats {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return Format{}, fmt.Errorf("unknown export format '%s', supported formats: %s", name, strings.Join(names, ", "))
+	}
+
+	if columns == "" {
+		return format, nil
+	}
+	mapping := make(map[string]string, len(format.Columns))
+	for k, v := range format.Columns {
+		mapping[k] = v
+	}
+	for _, c := range strings.Split(columns, ",") {
+		parts := strings.SplitN(c, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return Format{}, fmt.Errorf("invalid column mapping '%s', expected FOCUSColumn=ExportColumn", c)
+		}
+		mapping[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	format.Columns = mapping
+	return format, nil
+}
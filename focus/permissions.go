@@ -0,0 +1,53 @@
+package focus
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+// Permissions returns the permissions required to read the exports, with
+// checks making these calls
+func (f *FOCUSBilling) Permissions() []billing.Permission {
+	u, err := url.Parse(f.bucket.String())
+	if err != nil {
+		return nil
+	}
+	prefix := strings.TrimPrefix(u.Path, "/")
+
+	switch u.Scheme {
+	case "s3":
+		return []billing.Permission{
+			{Cloud: "aws", Action: "s3:ListBucket", Resource: fmt.Sprintf("arn:aws:s3:::%s", u.Host), Check: f.CheckCredentials},
+			{Cloud: "aws", Action: "s3:GetObject", Resource: fmt.Sprintf("arn:aws:s3:::%s/%s*", u.Host, prefix), Check: f.checkGetExport},
+		}
+	case "gs":
+		return []billing.Permission{
+			{Cloud: "gcp", Action: "storage.objects.list", Resource: fmt.Sprintf("projects/_/buckets/%s", u.Host), Check: f.CheckCredentials},
+			{Cloud: "gcp", Action: "storage.objects.get", Resource: fmt.Sprintf("projects/_/buckets/%s/objects/%s*", u.Host, prefix), Check: f.checkGetExport},
+		}
+	}
+	return nil
+}
+
+// checkGetExport reads the smallest export
+func (f *FOCUSBilling) checkGetExport(ctx context.Context) error {
+	objects, err := f.bucket.List(ctx, "")
+	if err != nil {
+		return err
+	}
+	if len(objects) == 0 {
+		return fmt.Errorf("no export found in %s", f.bucket)
+	}
+	smallest := objects[0]
+	for _, o := range objects[1:] {
+		if o.Size < smallest.Size {
+			smallest = o
+		}
+	}
+	_, err = f.bucket.Get(ctx, smallest.Name)
+	return err
+}
@@ -0,0 +1,209 @@
+package gcp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/common/log"
+	"golang.org/x/net/context"
+	crmv1 "google.golang.org/api/cloudresourcemanager/v1"
+	crmv2 "google.golang.org/api/cloudresourcemanager/v2"
+
+	"github.com/simonswine/cloud-billing-exporter/objstore"
+)
+
+const (
+	assetTypeProject      = "cloudresourcemanager.googleapis.com/Project"
+	assetTypeFolder       = "cloudresourcemanager.googleapis.com/Folder"
+	assetTypeOrganization = "cloudresourcemanager.googleapis.com/Organization"
+)
+
+// assetExportBucket lists and reads the Cloud Asset Inventory exports
+type assetExportBucket interface {
+	objstore.Bucket
+	objstore.Lister
+}
+
+// asset is a line of an export of the RESOURCE content type
+type asset struct {
+	Name      string `json:"name"`
+	AssetType string `json:"asset_type"`
+	Resource  struct {
+		Data json.RawMessage `json:"data"`
+	} `json:"resource"`
+}
+
+// assetInventory reads the projects, folders and organizations from Cloud
+// Asset Inventory exports in object storage instead of listing them through
+// the Resource Manager API, which is slow for organizations with many
+// projects. The exports are only parsed again if they changed.
+type assetInventory struct {
+	bucket assetExportBucket
+
+	lock          sync.Mutex
+	hash          string
+	projects      []*crmv1.Project
+	folders       []*crmv2.Folder
+	organizations []*crmv1.Organization
+}
+
+// WithAssetInventory looks up the projects, folders and organizations from
+// the Cloud Asset Inventory exports below the prefix of the bucket URL, e.g.
+// gs://bucket/assets/. The exports need to be in JSON with the resource
+// content type.
+func (g *GCPBilling) WithAssetInventory(ctx context.Context, bucketURL string) (*GCPBilling, error) {
+	b, err := objstore.Parse(ctx, bucketURL)
+	if err != nil {
+		return nil, err
+	}
+	l, ok := b.(assetExportBucket)
+	if !ok {
+		return nil, fmt.Errorf("listing objects is not supported by %s", b)
+	}
+	g.assetInventory = &assetInventory{bucket: l}
+	g.resourcesMetadata.client = g.assetInventory
+	return g, nil
+}
+
+// read parses the exports if they changed since the last read
+func (a *assetInventory) read(ctx context.Context) error {
+	objects, err := a.bucket.List(ctx, "")
+	if err != nil {
+		return err
+	}
+	if len(objects) == 0 {
+		return fmt.Errorf("no asset export found in %s", a.bucket)
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+
+	hashes := make([]string, len(objects))
+	for i, o := range objects {
+		hashes[i] = o.Name + "=" + o.Hash
+	}
+	hash := strings.Join(hashes, ",")
+	if hash == a.hash {
+		return nil
+	}
+
+	var (
+		projects      []*crmv1.Project
+		folders       []*crmv2.Folder
+		organizations []*crmv1.Organization
+	)
+	for _, o := range objects {
+		data, err := a.bucket.Get(ctx, o.Name)
+		if err != nil {
+			return err
+		}
+
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			line := scanner.Bytes()
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+
+			var e asset
+			if err := json.Unmarshal(line, &e); err != nil {
+				return fmt.Errorf("error parsing asset export %s: %s", o.Name, err)
+			}
+			if len(e.Resource.Data) == 0 {
+				log.Debugf("asset %s in export %s has no resource data", e.Name, o.Name)
+				continue
+			}
+
+			switch e.AssetType {
+			case assetTypeProject:
+				var p crmv1.Project
+				if err := json.Unmarshal(e.Resource.Data, &p); err != nil {
+					return fmt.Errorf("error parsing project %s: %s", e.Name, err)
+				}
+				projects = append(projects, &p)
+			case assetTypeFolder:
+				var f crmv2.Folder
+				if err := json.Unmarshal(e.Resource.Data, &f); err != nil {
+					return fmt.Errorf("error parsing folder %s: %s", e.Name, err)
+				}
+				folders = append(folders, &f)
+			case assetTypeOrganization:
+				var org crmv1.Organization
+				if err := json.Unmarshal(e.Resource.Data, &org); err != nil {
+					return fmt.Errorf("error parsing organization %s: %s", e.Name, err)
+				}
+				organizations = append(organizations, &org)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("error reading asset export %s: %s", o.Name, err)
+		}
+	}
+
+	log.Debugf("read %d projects, %d folders and %d organizations from the asset exports in %s", len(projects), len(folders), len(organizations), a.bucket)
+
+	a.hash = hash
+	a.projects = projects
+	a.folders = folders
+	a.organizations = organizations
+	return nil
+}
+
+func (a *assetInventory) ListProjects(ctx context.Context) ([]*crmv1.Project, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if err := a.read(ctx); err != nil {
+		return nil, err
+	}
+	return a.projects, nil
+}
+
+func (a *assetInventory) ListFolders(ctx context.Context) ([]*crmv2.Folder, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if err := a.read(ctx); err != nil {
+		return nil, err
+	}
+	return a.folders, nil
+}
+
+func (a *assetInventory) ListOrganizations(ctx context.Context) ([]*crmv1.Organization, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if err := a.read(ctx); err != nil {
+		return nil, err
+	}
+	return a.organizations, nil
+}
+
+// checkGetExport reads the first export
+func (a *assetInventory) checkGetExport(ctx context.Context) error {
+	objects, err := a.bucket.List(ctx, "")
+	if err != nil {
+		return err
+	}
+	if len(objects) == 0 {
+		return fmt.Errorf("no asset export found in %s", a.bucket)
+	}
+	_, err = a.bucket.Get(ctx, objects[0].Name)
+	return err
+}
+
+func (a *assetInventory) checkListExports(ctx context.Context) error {
+	_, err := a.bucket.List(ctx, "")
+	return err
+}
+
+// resource returns the bucket and the prefix of the exports
+func (a *assetInventory) resource() (bucket, prefix string) {
+	u, err := url.Parse(a.bucket.String())
+	if err != nil {
+		return "", ""
+	}
+	return u.Host, strings.TrimPrefix(u.Path, "/")
+}
@@ -0,0 +1,90 @@
+package gcp
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+	"github.com/simonswine/cloud-billing-exporter/fake"
+	"github.com/simonswine/cloud-billing-exporter/objstore"
+)
+
+type memoryBucket map[string]string
+
+func (m memoryBucket) Get(_ context.Context, name string) ([]byte, error) {
+	data, ok := m[name]
+	if !ok {
+		return nil, objstore.ErrNotExist
+	}
+	return []byte(data), nil
+}
+
+func (m memoryBucket) Put(_ context.Context, name string, data []byte, _ string) error {
+	m[name] = string(data)
+	return nil
+}
+
+func (m memoryBucket) List(_ context.Context, prefix string) ([]objstore.Object, error) {
+	var objects []objstore.Object
+	for name, data := range m {
+		if strings.HasPrefix(name, prefix) {
+			objects = append(objects, objstore.Object{Name: name, Hash: data, Size: int64(len(data))})
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+	return objects, nil
+}
+
+func (m memoryBucket) String() string {
+	return "gs://assets/export/"
+}
+
+func TestQueryWithAssetInventory(t *testing.T) {
+	assets := memoryBucket{
+		"cloudresourcemanager.googleapis.com/Project/0": `{"name":"//cloudresourcemanager.googleapis.com/projects/1234","asset_type":"cloudresourcemanager.googleapis.com/Project","resource":{"version":"v1","data":{"projectNumber":"1234","projectId":"project-a","lifecycleState":"ACTIVE","labels":{"cost-centre":"ops"},"parent":{"type":"folder","id":"42"}}},"ancestors":["projects/1234","folders/42","organizations/1"]}
+`,
+		"cloudresourcemanager.googleapis.com/Folder/0": `{"name":"//cloudresourcemanager.googleapis.com/folders/42","asset_type":"cloudresourcemanager.googleapis.com/Folder","resource":{"version":"v1","data":{"name":"folders/42","displayName":"team","parent":"organizations/1"}}}
+{"name":"//cloudresourcemanager.googleapis.com/organizations/1","asset_type":"cloudresourcemanager.googleapis.com/Organization","resource":{"version":"v1","data":{"name":"organizations/1","displayName":"example.com"}}}
+`,
+	}
+	reports := &fake.GCS{Objects: map[string]string{
+		"billing-2019-11-01.json": `[
+  {"projectId": "project-a", "measurements": [{"measurementId": "com.google.cloud/services/compute-engine/VmimageN1Standard_1", "sum": "3600", "unit": "seconds"}], "cost": {"amount": "1.5", "currency": "USD"}}
+]`,
+	}}
+	metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+	g := NewGCPBilling(metric, "bucket", "billing", "", "cost-centre", "").WithClients(Clients{Reports: reports})
+	g.assetInventory = &assetInventory{bucket: assets}
+	g.resourcesMetadata.client = g.assetInventory
+	g.clock = fakeClock{Time: time.Date(2019, 11, 3, 10, 0, 0, 0, time.UTC)}
+
+	if err := g.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if act, exp := testutil.ToFloat64(metric.WithLabelValues("gcp", "USD", "project-a", "compute-engine", "example.com/team", "", "ops", "")), 1.5; act != exp {
+		t.Errorf("unexpected costs: %f (expected: %f)", act, exp)
+	}
+
+	permissions := g.Permissions()
+	var actions []string
+	for _, p := range permissions {
+		actions = append(actions, p.Action+" "+p.Resource)
+	}
+	if act, exp := strings.Join(actions, ","), "storage.objects.list projects/_/buckets/bucket,storage.objects.get projects/_/buckets/bucket/objects/billing*,storage.objects.list projects/_/buckets/assets,storage.objects.get projects/_/buckets/assets/objects/export/*"; act != exp {
+		t.Errorf("unexpected permissions: %s (expected: %s)", act, exp)
+	}
+}
+
+func TestAssetInventoryInvalidExport(t *testing.T) {
+	a := &assetInventory{bucket: memoryBucket{"export.json": "{invalid"}}
+	if _, err := a.ListProjects(context.Background()); err == nil {
+		t.Error("expected error parsing the export")
+	}
+}
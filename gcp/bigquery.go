@@ -0,0 +1,440 @@
+package gcp
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/log"
+	"golang.org/x/net/context"
+	"google.golang.org/api/bigquery/v2"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+// bigQueryTable matches fully qualified table names, e.g.
+// my-project.billing.gcp_billing_export_v1_012345_6789AB_CDEF01
+var bigQueryTable = regexp.MustCompile(`^[a-zA-Z0-9:.-]+\.[a-zA-Z0-9_]+\.[a-zA-Z0-9_]+$`)
+
+// bigQueryTemplate sums up the costs of invoice months in the standard usage
+// cost export table, grouped by the selected columns. The table is
+// partitioned by ingestion time, the partitions are restricted to keep the
+// data scanned small.
+const bigQueryTemplate = "SELECT invoice.month, %s, SUM(cost)\n" +
+	"FROM `%s`\n" +
+	"WHERE invoice.month IN UNNEST(@invoice_months) AND DATE(_PARTITIONTIME) >= @since\n" +
+	"GROUP BY %s"
+
+const (
+	// BigQueryLabelsProject reads owner, cost centre and type from the
+	// labels of the project
+	BigQueryLabelsProject = "project"
+	// BigQueryLabelsResource reads owner, cost centre and type from the
+	// labels of the resources, costs of a project are split by them
+	BigQueryLabelsResource = "resource"
+)
+
+// bigQueryLabelFields are the export fields of the label sources
+var bigQueryLabelFields = map[string]string{
+	BigQueryLabelsProject:  "project.labels",
+	BigQueryLabelsResource: "labels",
+}
+
+// bigQueryLabelParameters are the query parameters of the label keys of
+// owner, cost centre and type, in the order of the columns
+var bigQueryLabelParameters = []string{"owner_label", "cost_centre_label", "type_label"}
+
+// bigQueryTimeout limits the time waiting for the results of a query job
+const bigQueryTimeout = 10 * time.Minute
+
+// bigQuery queries the costs from the BigQuery billing export, instead of
+// reading the reports in the bucket
+type bigQuery struct {
+	jobs BigQueryJobs
+
+	// projectID is the project the query jobs run in
+	projectID string
+	table     string
+
+	// interval is the minimum time between queries, as they are billed per
+	// data scanned
+	interval  time.Duration
+	lastQuery time.Time
+
+	// labelSource selects the labels queried for owner, cost centre and
+	// type, they are looked up through the resource manager if empty
+	labelSource string
+	labelKeys   []string
+
+	// skus groups the costs by SKU in addition to the service, the SKU is
+	// appended to the service unless skuLabel is set
+	skus     bool
+	skuLabel bool
+
+	// location is the location of the dataset the jobs run in, priority is
+	// either INTERACTIVE or BATCH and the labels are attached to the jobs
+	location  string
+	priority  string
+	jobLabels map[string]string
+}
+
+// bigQueryJobLabel matches valid keys and values of job labels
+var bigQueryJobLabel = regexp.MustCompile(`^[a-z0-9_-]{0,63}$`)
+
+// QualifyBigQueryTable returns the fully qualified name of the table in the
+// dataset. The dataset is qualified by the project, unless it is given as
+// project.dataset. Without dataset the table needs to be qualified already.
+func QualifyBigQueryTable(projectID, dataset, table string) (string, error) {
+	if dataset == "" {
+		return table, nil
+	}
+	if strings.Contains(table, ".") {
+		return "", fmt.Errorf("bigquery table '%s' is qualified already, it can't be combined with the dataset '%s'", table, dataset)
+	}
+	if !strings.Contains(dataset, ".") {
+		if projectID == "" {
+			return "", fmt.Errorf("project of the bigquery dataset '%s' unknown, expected a project or project.dataset", dataset)
+		}
+		dataset = projectID + "." + dataset
+	}
+	return dataset + "." + table, nil
+}
+
+// WithBigQuery queries the costs of the open and the previous invoice month
+// from the billing export table in BigQuery instead of the reports in the
+// bucket. The query jobs run in the given project, which defaults to the
+// project of the table.
+func (g *GCPBilling) WithBigQuery(ctx context.Context, projectID, table string, interval time.Duration) (*GCPBilling, error) {
+	if !bigQueryTable.MatchString(table) {
+		return nil, fmt.Errorf("invalid bigquery table '%s', expected project.dataset.table", table)
+	}
+	if projectID == "" {
+		projectID = table[:strings.Index(table, ".")]
+	}
+
+	jobs, err := g.bigQueryJobs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	g.bigQuery = &bigQuery{
+		jobs:      jobs,
+		projectID: projectID,
+		table:     table,
+		interval:  interval,
+	}
+	return g, nil
+}
+
+// WithBigQueryLabels queries the owner, cost centre and type labels together
+// with the costs, from either the project or the resource labels. The label
+// keys are the ones configured for the resource manager.
+func (g *GCPBilling) WithBigQueryLabels(source string) (*GCPBilling, error) {
+	if g.bigQuery == nil {
+		return nil, fmt.Errorf("bigquery is not set up")
+	}
+	if _, ok := bigQueryLabelFields[source]; !ok && source != "" {
+		return nil, fmt.Errorf("unknown bigquery label source '%s', expected %s or %s", source, BigQueryLabelsProject, BigQueryLabelsResource)
+	}
+	g.bigQuery.labelSource = source
+	g.bigQuery.labelKeys = []string{
+		g.resourcesMetadata.ownerLabel,
+		g.resourcesMetadata.costCentreLabel,
+		g.resourcesMetadata.projectTypeLabel,
+	}
+	return g, nil
+}
+
+// WithBigQuerySKUs groups the costs queried from BigQuery by SKU, the SKU is
+// appended to the service label. This multiplies the number of series.
+func (g *GCPBilling) WithBigQuerySKUs(enabled bool) (*GCPBilling, error) {
+	if g.bigQuery == nil {
+		return nil, fmt.Errorf("bigquery is not set up")
+	}
+	g.bigQuery.skus = enabled
+	return g, nil
+}
+
+// WithBigQuerySKULabel sets the SKU of the records instead of appending it to
+// the service, if the costs are grouped by SKU
+func (g *GCPBilling) WithBigQuerySKULabel(enabled bool) (*GCPBilling, error) {
+	if g.bigQuery == nil {
+		return nil, fmt.Errorf("bigquery is not set up")
+	}
+	g.bigQuery.skuLabel = enabled
+	return g, nil
+}
+
+// WithBigQueryJobConfig runs the query jobs in the location of the dataset
+// with the given priority, INTERACTIVE or BATCH. The labels, e.g.
+// "app=cloud-billing-exporter,team=finops", are attached to the jobs, so they
+// can be identified in the audit logs.
+func (g *GCPBilling) WithBigQueryJobConfig(location, priority, labels string) (*GCPBilling, error) {
+	if g.bigQuery == nil {
+		return nil, fmt.Errorf("bigquery is not set up")
+	}
+	priority = strings.ToUpper(priority)
+	if priority != "" && priority != "INTERACTIVE" && priority != "BATCH" {
+		return nil, fmt.Errorf("unknown bigquery job priority '%s', expected interactive or batch", priority)
+	}
+
+	jobLabels := make(map[string]string)
+	if labels != "" {
+		for _, l := range strings.Split(labels, ",") {
+			parts := strings.SplitN(l, "=", 2)
+			if len(parts) != 2 || parts[0] == "" || !bigQueryJobLabel.MatchString(parts[0]) || !bigQueryJobLabel.MatchString(parts[1]) {
+				return nil, fmt.Errorf("invalid bigquery job label '%s', expected key=value of lowercase letters, digits, _ and -", l)
+			}
+			jobLabels[parts[0]] = parts[1]
+		}
+	}
+
+	g.bigQuery.location = location
+	g.bigQuery.priority = priority
+	g.bigQuery.jobLabels = jobLabels
+	return g, nil
+}
+
+// columns returns the columns the costs are grouped by
+func (q *bigQuery) columns() []string {
+	columns := []string{"project.id", "service.description", "currency"}
+	if field, ok := bigQueryLabelFields[q.labelSource]; ok {
+		for _, p := range bigQueryLabelParameters {
+			columns = append(columns, fmt.Sprintf("(SELECT value FROM UNNEST(%s) WHERE key = @%s LIMIT 1)", field, p))
+		}
+	}
+	if q.skus {
+		columns = append(columns, "sku.description")
+	}
+	return columns
+}
+
+// query returns the statement summing up the costs of the invoice months
+func (q *bigQuery) query() string {
+	columns := q.columns()
+	groupBy := make([]string, len(columns)+1)
+	for i := range groupBy {
+		groupBy[i] = strconv.Itoa(i + 1)
+	}
+	return fmt.Sprintf(bigQueryTemplate, strings.Join(columns, ", "), q.table, strings.Join(groupBy, ", "))
+}
+
+// invoiceMonths returns the first days of the open and the previous, closed
+// invoice month
+func invoiceMonths(now time.Time) (open, closed time.Time) {
+	year, month, _ := now.UTC().Date()
+	open = time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	return open, open.AddDate(0, -1, 0)
+}
+
+// job returns the query job for the open and the closed invoice month
+func (q *bigQuery) job(now time.Time) *bigquery.Job {
+	open, closed := invoiceMonths(now)
+	// late usage of the previous month can be ingested shortly before the
+	// invoice month starts
+	since := closed.AddDate(0, 0, -7)
+
+	months := []*bigquery.QueryParameterValue{
+		{Value: open.Format("200601")},
+		{Value: closed.Format("200601")},
+	}
+	parameters := []*bigquery.QueryParameter{
+		{
+			Name: "invoice_months",
+			ParameterType: &bigquery.QueryParameterType{
+				Type:      "ARRAY",
+				ArrayType: &bigquery.QueryParameterType{Type: "STRING"},
+			},
+			ParameterValue: &bigquery.QueryParameterValue{ArrayValues: months},
+		},
+		{
+			Name:           "since",
+			ParameterType:  &bigquery.QueryParameterType{Type: "DATE"},
+			ParameterValue: &bigquery.QueryParameterValue{Value: since.Format(DateFormat)},
+		},
+	}
+	if q.labelSource != "" {
+		for i, name := range bigQueryLabelParameters {
+			parameters = append(parameters, &bigquery.QueryParameter{
+				Name:           name,
+				ParameterType:  &bigquery.QueryParameterType{Type: "STRING"},
+				ParameterValue: &bigquery.QueryParameterValue{Value: q.labelKeys[i]},
+			})
+		}
+	}
+
+	useLegacySQL := false
+	job := &bigquery.Job{
+		Configuration: &bigquery.JobConfiguration{
+			Labels: q.jobLabels,
+			Query: &bigquery.JobConfigurationQuery{
+				Query:           q.query(),
+				UseLegacySql:    &useLegacySQL,
+				ParameterMode:   "NAMED",
+				QueryParameters: parameters,
+				Priority:        q.priority,
+			},
+		},
+	}
+	if q.location != "" {
+		job.JobReference = &bigquery.JobReference{ProjectId: q.projectID, Location: q.location}
+	}
+	return job
+}
+
+// dryRun validates the query job without running it, which verifies the
+// credentials and the access to the table at no cost
+func (q *bigQuery) dryRun(ctx context.Context, now time.Time) error {
+	job := q.job(now)
+	job.Configuration.DryRun = true
+	if _, err := q.jobs.Insert(ctx, q.projectID, job); err != nil {
+		return fmt.Errorf("error validating bigquery job: %s", err)
+	}
+	return nil
+}
+
+// run executes the query for the open and the closed invoice month and waits
+// for its results
+func (q *bigQuery) run(ctx context.Context, now time.Time) ([][]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, bigQueryTimeout)
+	defer cancel()
+
+	job, err := q.jobs.Insert(ctx, q.projectID, q.job(now))
+	if err != nil {
+		return nil, fmt.Errorf("error starting bigquery job: %s", err)
+	}
+	if job.JobReference == nil {
+		return nil, fmt.Errorf("bigquery job has no reference")
+	}
+	ref := job.JobReference
+	if ref.Location == "" {
+		ref.Location = q.location
+	}
+	log.Debugf("started bigquery job %s in project %s", ref.JobId, q.projectID)
+
+	var rows [][]string
+	var pageToken string
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("error waiting for bigquery job %s: %s", ref.JobId, err)
+		}
+		resp, err := q.jobs.GetQueryResults(ctx, q.projectID, ref.JobId, ref.Location, pageToken)
+		if err != nil {
+			return nil, fmt.Errorf("error getting results of bigquery job %s: %s", ref.JobId, err)
+		}
+		if !resp.JobComplete {
+			log.Debugf("bigquery job %s has not completed yet", ref.JobId)
+			continue
+		}
+		for _, row := range resp.Rows {
+			values := make([]string, len(row.F))
+			for i, cell := range row.F {
+				// NULL values are returned as nil
+				if v, ok := cell.V.(string); ok {
+					values[i] = v
+				}
+			}
+			rows = append(rows, values)
+		}
+		if resp.PageToken == "" {
+			return rows, nil
+		}
+		pageToken = resp.PageToken
+	}
+}
+
+// queryBigQuery updates the costs of the open invoice month and the totals of
+// the previous, closed invoice month from BigQuery. It needs to be called with
+// the reports lock held.
+func (g *GCPBilling) queryBigQuery(ctx context.Context) error {
+	now := g.clock.Now()
+	if !g.bigQuery.lastQuery.IsZero() && now.Sub(g.bigQuery.lastQuery) < g.bigQuery.interval {
+		log.Debugf("bigquery has been queried at %s already", g.bigQuery.lastQuery)
+		return nil
+	}
+
+	rows, err := g.bigQuery.run(ctx, now)
+	if err != nil {
+		return err
+	}
+	g.bigQuery.lastQuery = now
+
+	// update metadata if neccessary
+	metadataUpdated := g.resourcesMetadata.updated()
+	if err := g.resourcesMetadata.update(ctx); err != nil {
+		log.Warnf("error updating resource metadata: %s", err)
+	}
+	changed := !metadataUpdated.Equal(g.resourcesMetadata.updated())
+
+	// the invoice month comes first, the costs last
+	columns := len(g.bigQuery.columns()) + 2
+	elemsByMonth := make(map[string][]*gcpBillingElement)
+	for _, row := range rows {
+		if len(row) != columns {
+			log.Warnf("unexpected number of columns in bigquery row: %d", len(row))
+			continue
+		}
+		invoiceMonth, err := time.Parse("200601", row[0])
+		if err != nil {
+			log.Warnf("invalid invoice month '%s': %s", row[0], err)
+			continue
+		}
+		costs, err := strconv.ParseFloat(row[columns-1], 64)
+		if err != nil {
+			log.Warnf("Couldn't parse costs float: %s", err)
+			continue
+		}
+		elem := &gcpBillingElement{
+			ProjectID:   row[1],
+			ServiceName: row[2],
+			Cost:        gcpBillingCost{Currency: row[3], Value: costs},
+		}
+		if g.bigQuery.skus && row[columns-2] != "" {
+			if g.bigQuery.skuLabel {
+				elem.SKU = row[columns-2]
+			} else {
+				elem.ServiceName = fmt.Sprintf("%s/%s", elem.ServiceName, row[columns-2])
+			}
+		}
+		if g.bigQuery.labelSource != "" {
+			labels := make(map[string]string)
+			for i, key := range g.bigQuery.labelKeys {
+				if value := row[4+i]; value != "" {
+					labels[key] = value
+				}
+			}
+			owner, costCentre, projectType := g.resourcesMetadata.decodeLabels(labels, elem.ProjectID)
+			elem.Labels = &gcpBillingLabels{Owner: owner, CostCentre: costCentre, ProjectType: projectType}
+		}
+		m := invoiceMonth.Format("2006-01")
+		elemsByMonth[m] = append(elemsByMonth[m], elem)
+	}
+
+	openMonth, closedMonth := invoiceMonths(now)
+	open := openMonth.Format("2006-01")
+	records, credits, costsChanged := g.updateCosts(open, reduceElementsByProjectIDServiceCurrency(elemsByMonth[open]))
+	closed, _ := g.costRecords(closedMonth.Format("2006-01"), reduceElementsByProjectIDServiceCurrency(elemsByMonth[closedMonth.Format("2006-01")]))
+	g.setRecords(records, nil)
+	g.setCredits(credits)
+	g.setClosedMonths(closed)
+
+	if changed || costsChanged {
+		g.saveState(ctx)
+	}
+	return nil
+}
+
+func (g *GCPBilling) setClosedMonths(records []billing.Record) {
+	g.recordsLock.Lock()
+	defer g.recordsLock.Unlock()
+	g.closedMonths = records
+}
+
+// ClosedMonths returns the total costs of the previous invoice month, they
+// are only known when querying BigQuery
+func (g *GCPBilling) ClosedMonths() []billing.Record {
+	g.recordsLock.Lock()
+	defer g.recordsLock.Unlock()
+	return append([]billing.Record(nil), g.closedMonths...)
+}
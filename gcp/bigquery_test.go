@@ -0,0 +1,299 @@
+package gcp
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/net/context"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+	"github.com/simonswine/cloud-billing-exporter/fake"
+)
+
+var _ BigQueryJobs = &fake.BigQuery{}
+
+func TestQueryBigQueryByInvoiceMonth(t *testing.T) {
+	jobs := &fake.BigQuery{Rows: [][]string{
+		{"201911", "project-a", "Compute Engine", "USD", "1.5"},
+		{"201911", "project-a", "Compute Engine", "USD", "2.5"},
+		{"201911", "project-b", "Cloud Storage", "USD", "0.25"},
+		{"201910", "project-a", "Compute Engine", "USD", "100"},
+		{"invalid", "project-a", "Compute Engine", "USD", "1"},
+	}}
+	metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+	g, err := NewGCPBilling(metric, "", "", "", "", "").WithClients(Clients{
+		BigQuery:        jobs,
+		ResourceManager: &fake.ResourceManager{},
+	}).WithBigQuery(context.Background(), "", "billing-project.billing.gcp_billing_export_v1_0", time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	g.clock = fakeClock{Time: time.Date(2019, 11, 3, 10, 0, 0, 0, time.UTC)}
+
+	if err := g.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if act, exp := len(jobs.Jobs), 1; act != exp {
+		t.Fatalf("unexpected number of jobs: %d (expected: %d)", act, exp)
+	}
+	job := jobs.Jobs[0]
+	if act, exp := job.JobReference.ProjectId, "billing-project"; act != exp {
+		t.Errorf("unexpected project: %s (expected: %s)", act, exp)
+	}
+	query := job.Configuration.Query
+	if !strings.Contains(query.Query, "`billing-project.billing.gcp_billing_export_v1_0`") {
+		t.Errorf("unexpected query: %s", query.Query)
+	}
+	months := query.QueryParameters[0].ParameterValue.ArrayValues
+	if len(months) != 2 || months[0].Value != "201911" || months[1].Value != "201910" {
+		t.Errorf("unexpected invoice months: %+v", months)
+	}
+
+	if act, exp := testutil.ToFloat64(metric.WithLabelValues("gcp", "USD", "project-a", "Compute Engine", "", "", "", "")), 4.0; act != exp {
+		t.Errorf("unexpected costs of the open month: %f (expected: %f)", act, exp)
+	}
+	records := g.Records()
+	if act, exp := len(records), 2; act != exp {
+		t.Fatalf("unexpected number of records: %d (expected: %d)", act, exp)
+	}
+	for _, r := range records {
+		if r.Month != "2019-11" {
+			t.Errorf("unexpected month of record: %+v", r)
+		}
+	}
+
+	closed := g.ClosedMonths()
+	if act, exp := len(closed), 1; act != exp {
+		t.Fatalf("unexpected number of closed month records: %d (expected: %d)", act, exp)
+	}
+	if r := closed[0]; r.Month != "2019-10" || r.Account != "project-a" || r.Costs != 100 {
+		t.Errorf("unexpected closed month record: %+v", r)
+	}
+
+	// no further query within the interval
+	if err := g.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if act, exp := len(jobs.Jobs), 1; act != exp {
+		t.Errorf("unexpected number of jobs: %d (expected: %d)", act, exp)
+	}
+}
+
+func TestWithBigQueryInvalidTable(t *testing.T) {
+	g := NewGCPBilling(nil, "", "", "", "", "").WithClients(Clients{BigQuery: &fake.BigQuery{}})
+	if _, err := g.WithBigQuery(context.Background(), "", "table`; DROP TABLE x", time.Hour); err == nil {
+		t.Error("expected error for invalid table name")
+	}
+}
+
+func TestQualifyBigQueryTable(t *testing.T) {
+	for _, c := range []struct {
+		project, dataset, table string
+		exp                     string
+	}{
+		{table: "my-project.billing.export", exp: "my-project.billing.export"},
+		{project: "my-project", dataset: "billing", table: "export", exp: "my-project.billing.export"},
+		{project: "my-project", dataset: "data-project.billing", table: "export", exp: "data-project.billing.export"},
+		{project: "example.com:my-project", dataset: "billing", table: "export", exp: "example.com:my-project.billing.export"},
+		// the project of the dataset is unknown
+		{dataset: "billing", table: "export"},
+		// the table is qualified already
+		{project: "my-project", dataset: "billing", table: "other.billing.export"},
+	} {
+		act, err := QualifyBigQueryTable(c.project, c.dataset, c.table)
+		if c.exp == "" {
+			if err == nil {
+				t.Errorf("expected an error for %+v, got '%s'", c, act)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for %+v: %s", c, err)
+		} else if act != c.exp {
+			t.Errorf("unexpected table: %s (expected: %s)", act, c.exp)
+		}
+	}
+}
+
+func TestQueryBigQueryResourceLabels(t *testing.T) {
+	jobs := &fake.BigQuery{Rows: [][]string{
+		// owner-base32 of "jane"
+		{"201911", "project-a", "Compute Engine", "USD", "njqw4zi_", "OPS", "prod", "1.5"},
+		{"201911", "project-a", "Compute Engine", "USD", "", "", "", "2.5"},
+	}}
+	metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+	g, err := NewGCPBilling(metric, "", "", "owner-base32", "cost_centre", "type").WithClients(Clients{
+		BigQuery:        jobs,
+		ResourceManager: &fake.ResourceManager{},
+	}).WithBigQuery(context.Background(), "", "billing-project.billing.gcp_billing_export_v1_0", time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := g.WithBigQueryLabels(BigQueryLabelsResource); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	g.clock = fakeClock{Time: time.Date(2019, 11, 3, 10, 0, 0, 0, time.UTC)}
+
+	if err := g.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	query := jobs.Jobs[0].Configuration.Query
+	if !strings.Contains(query.Query, "(SELECT value FROM UNNEST(labels) WHERE key = @owner_label LIMIT 1)") || !strings.HasSuffix(query.Query, "GROUP BY 1, 2, 3, 4, 5, 6, 7") {
+		t.Errorf("unexpected query: %s", query.Query)
+	}
+	if act, exp := query.QueryParameters[2].ParameterValue.Value, "owner-base32"; act != exp {
+		t.Errorf("unexpected owner label key: %s (expected: %s)", act, exp)
+	}
+
+	for _, c := range []struct {
+		labels []string
+		costs  float64
+	}{
+		{[]string{"gcp", "USD", "project-a", "Compute Engine", "", "jane", "ops", "prod"}, 1.5},
+		{[]string{"gcp", "USD", "project-a", "Compute Engine", "", "", "", ""}, 2.5},
+	} {
+		if act := testutil.ToFloat64(metric.WithLabelValues(c.labels...)); act != c.costs {
+			t.Errorf("unexpected costs of %v: %f (expected: %f)", c.labels, act, c.costs)
+		}
+	}
+}
+
+func TestQueryBigQuerySKUs(t *testing.T) {
+	jobs := &fake.BigQuery{Rows: [][]string{
+		{"201911", "project-a", "Compute Engine", "USD", "N1 Predefined Instance Core", "1.5"},
+		{"201911", "project-a", "Compute Engine", "USD", "N1 Predefined Instance Ram", "0.5"},
+	}}
+	metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+	g, err := NewGCPBilling(metric, "", "", "", "", "").WithClients(Clients{
+		BigQuery:        jobs,
+		ResourceManager: &fake.ResourceManager{},
+	}).WithBigQuery(context.Background(), "", "billing-project.billing.gcp_billing_export_v1_0", time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := g.WithBigQuerySKUs(true); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	g.clock = fakeClock{Time: time.Date(2019, 11, 3, 10, 0, 0, 0, time.UTC)}
+
+	if err := g.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	query := jobs.Jobs[0].Configuration.Query.Query
+	if !strings.Contains(query, "currency, sku.description, SUM(cost)") || !strings.HasSuffix(query, "GROUP BY 1, 2, 3, 4, 5") {
+		t.Errorf("unexpected query: %s", query)
+	}
+	if act, exp := testutil.ToFloat64(metric.WithLabelValues("gcp", "USD", "project-a", "Compute Engine/N1 Predefined Instance Ram", "", "", "", "")), 0.5; act != exp {
+		t.Errorf("unexpected costs: %f (expected: %f)", act, exp)
+	}
+	if act, exp := len(g.Records()), 2; act != exp {
+		t.Errorf("unexpected number of records: %d (expected: %d)", act, exp)
+	}
+}
+
+func TestQueryBigQuerySKULabel(t *testing.T) {
+	jobs := &fake.BigQuery{Rows: [][]string{
+		{"201911", "project-a", "Compute Engine", "USD", "N1 Predefined Instance Core", "1.5"},
+		{"201911", "project-a", "Compute Engine", "USD", "N1 Predefined Instance Ram", "0.5"},
+		{"201911", "project-a", "Cloud Storage", "USD", "", "0.25"},
+	}}
+	metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+	g, err := NewGCPBilling(metric, "", "", "", "", "").WithClients(Clients{
+		BigQuery:        jobs,
+		ResourceManager: &fake.ResourceManager{},
+	}).WithBigQuery(context.Background(), "", "billing-project.billing.gcp_billing_export_v1_0", time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := g.WithBigQuerySKUs(true); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := g.WithBigQuerySKULabel(true); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	g.clock = fakeClock{Time: time.Date(2019, 11, 3, 10, 0, 0, 0, time.UTC)}
+
+	if err := g.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// the counter sums up the SKUs of the service
+	if act, exp := testutil.ToFloat64(metric.WithLabelValues("gcp", "USD", "project-a", "Compute Engine", "", "", "", "")), 2.0; act != exp {
+		t.Errorf("unexpected costs: %f (expected: %f)", act, exp)
+	}
+	skus := make(map[string]string)
+	for _, r := range g.Records() {
+		skus[r.SKU] = r.Service
+	}
+	if exp := map[string]string{"N1 Predefined Instance Core": "Compute Engine", "N1 Predefined Instance Ram": "Compute Engine", "": "Cloud Storage"}; !reflect.DeepEqual(skus, exp) {
+		t.Errorf("unexpected services by SKU: %v (expected: %v)", skus, exp)
+	}
+	// the baselines are keyed like the SKUs appended to the service
+	if _, ok := g.metricValues["project-a-Compute Engine/N1 Predefined Instance Ram-USD"]; !ok {
+		t.Errorf("unexpected baselines: %v", g.metricValues)
+	}
+}
+
+func TestWithBigQueryJobConfig(t *testing.T) {
+	jobs := &fake.BigQuery{}
+	g, err := NewGCPBilling(prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels), "", "", "", "", "").WithClients(Clients{
+		BigQuery:        jobs,
+		ResourceManager: &fake.ResourceManager{},
+	}).WithBigQuery(context.Background(), "", "billing-project.billing.gcp_billing_export_v1_0", time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, c := range []struct {
+		priority, labels string
+	}{
+		{"urgent", ""},
+		{"batch", "App=exporter"},
+		{"batch", "app"},
+	} {
+		if _, err := g.WithBigQueryJobConfig("EU", c.priority, c.labels); err == nil {
+			t.Errorf("expected error for priority '%s' and labels '%s'", c.priority, c.labels)
+		}
+	}
+	if _, err := g.WithBigQueryJobConfig("EU", "batch", "app=cloud-billing-exporter,team=finops"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	g.clock = fakeClock{Time: time.Date(2019, 11, 3, 10, 0, 0, 0, time.UTC)}
+
+	if err := g.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	job := jobs.Jobs[0]
+	if act, exp := job.JobReference.Location, "EU"; act != exp {
+		t.Errorf("unexpected location: %s (expected: %s)", act, exp)
+	}
+	if act, exp := job.Configuration.Query.Priority, "BATCH"; act != exp {
+		t.Errorf("unexpected priority: %s (expected: %s)", act, exp)
+	}
+	if act, exp := job.Configuration.Labels, map[string]string{"app": "cloud-billing-exporter", "team": "finops"}; !reflect.DeepEqual(act, exp) {
+		t.Errorf("unexpected labels: %v (expected: %v)", act, exp)
+	}
+}
+
+func TestCheckCredentialsBigQuery(t *testing.T) {
+	jobs := &fake.BigQuery{}
+	g, err := NewGCPBilling(nil, "", "", "", "", "").WithClients(Clients{BigQuery: jobs}).WithBigQuery(context.Background(), "", "billing-project.billing.gcp_billing_export_v1_0", time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	g.clock = fakeClock{Time: time.Date(2019, 11, 3, 10, 0, 0, 0, time.UTC)}
+
+	if err := g.CheckCredentials(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(jobs.Jobs) != 1 || !jobs.Jobs[0].Configuration.DryRun {
+		t.Errorf("expected a dry run job: %+v", jobs.Jobs)
+	}
+}
@@ -0,0 +1,31 @@
+package gcp
+
+import (
+	"regexp"
+)
+
+// exportTableBillingAccount matches the billing account ID in the names of
+// the export tables, e.g. gcp_billing_export_v1_012345_6789AB_CDEF01
+var exportTableBillingAccount = regexp.MustCompile(`gcp_billing_export_(?:resource_)?v1_([0-9A-F]{6})_([0-9A-F]{6})_([0-9A-F]{6})$`)
+
+// WithBillingAccount sets the name of the billing account the costs are
+// billed to, e.g. to tell apart the exports of several billing accounts. It
+// defaults to the billing account ID of the BigQuery export table or the
+// report prefix.
+func (g *GCPBilling) WithBillingAccount(name string) *GCPBilling {
+	g.billingAccount = name
+	return g
+}
+
+// BillingAccount returns the name of the billing account
+func (g *GCPBilling) BillingAccount() string {
+	if g.billingAccount != "" {
+		return g.billingAccount
+	}
+	if g.bigQuery != nil {
+		if m := exportTableBillingAccount.FindStringSubmatch(g.bigQuery.table); m != nil {
+			return m[1] + "-" + m[2] + "-" + m[3]
+		}
+	}
+	return g.ReportPrefix
+}
@@ -0,0 +1,28 @@
+package gcp
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/simonswine/cloud-billing-exporter/fake"
+)
+
+func TestBillingAccount(t *testing.T) {
+	g := NewGCPBilling(nil, "billing", "acme-billing", "", "", "")
+	if act := g.BillingAccount(); act != "acme-billing" {
+		t.Errorf("unexpected billing account: %s (expected the report prefix)", act)
+	}
+
+	g, err := NewGCPBilling(nil, "", "", "", "", "").WithClients(Clients{BigQuery: &fake.BigQuery{}}).WithBigQuery(context.Background(), "", "billing-project.billing.gcp_billing_export_v1_012345_6789AB_CDEF01", time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if act := g.BillingAccount(); act != "012345-6789AB-CDEF01" {
+		t.Errorf("unexpected billing account: %s (expected the ID of the export table)", act)
+	}
+	if act := g.WithBillingAccount("acme").BillingAccount(); act != "acme" {
+		t.Errorf("unexpected billing account: %s", act)
+	}
+}
@@ -0,0 +1,196 @@
+package gcp
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/net/context"
+	"google.golang.org/api/bigquery/v2"
+	crmv1 "google.golang.org/api/cloudresourcemanager/v1"
+	crmv2 "google.golang.org/api/cloudresourcemanager/v2"
+	"google.golang.org/api/iterator"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+// ReportBucket lists and reads the billing report files
+type ReportBucket interface {
+	// ListObjects returns the objects with the given prefix
+	ListObjects(ctx context.Context, prefix string) ([]*storage.ObjectAttrs, error)
+	NewReader(ctx context.Context, name string) (io.ReadCloser, error)
+}
+
+// ResourceManager lists the projects, folders and organizations, to look up
+// the labels and position of projects
+type ResourceManager interface {
+	ListProjects(ctx context.Context) ([]*crmv1.Project, error)
+	ListFolders(ctx context.Context) ([]*crmv2.Folder, error)
+	ListOrganizations(ctx context.Context) ([]*crmv1.Organization, error)
+}
+
+// BigQueryJobs runs query jobs against the billing export tables and reads
+// their results
+type BigQueryJobs interface {
+	Insert(ctx context.Context, projectID string, job *bigquery.Job) (*bigquery.Job, error)
+	// GetQueryResults waits for the job to complete and returns a page of
+	// its results, JobComplete is false if it didn't complete in time
+	GetQueryResults(ctx context.Context, projectID, jobID, location, pageToken string) (*bigquery.GetQueryResultsResponse, error)
+}
+
+// Clients are the GCP APIs used by GCPBilling, clients not set are created
+// with the default credentials
+type Clients struct {
+	Reports         ReportBucket
+	ResourceManager ResourceManager
+	BigQuery        BigQueryJobs
+}
+
+// WithClients replaces the GCP API clients, e.g. with fakes in tests
+func (g *GCPBilling) WithClients(c Clients) *GCPBilling {
+	g.clients = c
+	g.resourcesMetadata.client = c.ResourceManager
+	if g.bigQuery != nil && c.BigQuery != nil {
+		g.bigQuery.jobs = c.BigQuery
+	}
+	return g
+}
+
+func (g *GCPBilling) reportBucket(ctx context.Context) (ReportBucket, error) {
+	if g.local != nil {
+		return g.local, nil
+	}
+	if g.clients.Reports != nil {
+		return g.clients.Reports, nil
+	}
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %v", err)
+	}
+	bucket := client.Bucket(g.BucketName)
+	if g.userProject != "" {
+		bucket = bucket.UserProject(g.userProject)
+	}
+	return &gcsReportBucket{bucket: bucket, apiLatency: g.apiLatency}, nil
+}
+
+func (g *GCPBilling) bigQueryJobs(ctx context.Context) (BigQueryJobs, error) {
+	if g.clients.BigQuery != nil {
+		return g.clients.BigQuery, nil
+	}
+	svc, err := bigquery.NewService(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %v", err)
+	}
+	return &apiBigQueryJobs{svc: svc, apiLatency: g.apiLatency}, nil
+}
+
+// gcsReportBucket reads the reports from a GCS bucket
+type gcsReportBucket struct {
+	bucket     *storage.BucketHandle
+	apiLatency *billing.APILatency
+}
+
+// ListObjects lists the objects, the duration of all pages is observed
+func (b *gcsReportBucket) ListObjects(ctx context.Context, prefix string) ([]*storage.ObjectAttrs, error) {
+	defer b.apiLatency.Observe("gcp", "storage.objects.list", time.Now())
+	var objects []*storage.ObjectAttrs
+	it := b.bucket.Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, attrs)
+	}
+	return objects, nil
+}
+
+// NewReader opens the object, the duration until the response headers are
+// read is observed
+func (b *gcsReportBucket) NewReader(ctx context.Context, name string) (io.ReadCloser, error) {
+	defer b.apiLatency.Observe("gcp", "storage.objects.get", time.Now())
+	return b.bucket.Object(name).NewReader(ctx)
+}
+
+// apiResourceManager lists the resources through the resource manager API
+type apiResourceManager struct {
+	v1         *crmv1.Service
+	v2         *crmv2.Service
+	apiLatency *billing.APILatency
+}
+
+func newAPIResourceManager(ctx context.Context, apiLatency *billing.APILatency) (*apiResourceManager, error) {
+	v1, err := crmv1.NewService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	v2, err := crmv2.NewService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &apiResourceManager{v1: v1, v2: v2, apiLatency: apiLatency}, nil
+}
+
+func (r *apiResourceManager) ListProjects(ctx context.Context) ([]*crmv1.Project, error) {
+	defer r.apiLatency.Observe("gcp", "cloudresourcemanager.projects.list", time.Now())
+	var projects []*crmv1.Project
+	if err := r.v1.Projects.List().Pages(ctx, func(page *crmv1.ListProjectsResponse) error {
+		projects = append(projects, page.Projects...)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return projects, nil
+}
+
+func (r *apiResourceManager) ListFolders(ctx context.Context) ([]*crmv2.Folder, error) {
+	defer r.apiLatency.Observe("gcp", "cloudresourcemanager.folders.search", time.Now())
+	var folders []*crmv2.Folder
+	if err := r.v2.Folders.Search(&crmv2.SearchFoldersRequest{}).Pages(ctx, func(page *crmv2.SearchFoldersResponse) error {
+		folders = append(folders, page.Folders...)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return folders, nil
+}
+
+func (r *apiResourceManager) ListOrganizations(ctx context.Context) ([]*crmv1.Organization, error) {
+	defer r.apiLatency.Observe("gcp", "cloudresourcemanager.organizations.search", time.Now())
+	var organizations []*crmv1.Organization
+	if err := r.v1.Organizations.Search(&crmv1.SearchOrganizationsRequest{}).Pages(ctx, func(page *crmv1.SearchOrganizationsResponse) error {
+		organizations = append(organizations, page.Organizations...)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return organizations, nil
+}
+
+// apiBigQueryJobs runs the jobs through the BigQuery API
+type apiBigQueryJobs struct {
+	svc        *bigquery.Service
+	apiLatency *billing.APILatency
+}
+
+func (j *apiBigQueryJobs) Insert(ctx context.Context, projectID string, job *bigquery.Job) (*bigquery.Job, error) {
+	defer j.apiLatency.Observe("gcp", "bigquery.jobs.insert", time.Now())
+	return j.svc.Jobs.Insert(projectID, job).Context(ctx).Do()
+}
+
+func (j *apiBigQueryJobs) GetQueryResults(ctx context.Context, projectID, jobID, location, pageToken string) (*bigquery.GetQueryResultsResponse, error) {
+	defer j.apiLatency.Observe("gcp", "bigquery.jobs.getQueryResults", time.Now())
+	call := j.svc.Jobs.GetQueryResults(projectID, jobID).Context(ctx).TimeoutMs(10000)
+	if location != "" {
+		call = call.Location(location)
+	}
+	if pageToken != "" {
+		call = call.PageToken(pageToken)
+	}
+	return call.Do()
+}
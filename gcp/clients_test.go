@@ -0,0 +1,56 @@
+package gcp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	crmv1 "google.golang.org/api/cloudresourcemanager/v1"
+	crmv2 "google.golang.org/api/cloudresourcemanager/v2"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+	"github.com/simonswine/cloud-billing-exporter/fake"
+)
+
+var (
+	_ ReportBucket    = &fake.GCS{}
+	_ ResourceManager = &fake.ResourceManager{}
+)
+
+func TestQueryWithFakeClients(t *testing.T) {
+	reports := &fake.GCS{Objects: map[string]string{
+		"billing-2019-11-01.json": `[
+  {"projectId": "project-a", "measurements": [{"measurementId": "com.google.cloud/services/compute-engine/VmimageN1Standard_1", "sum": "3600", "unit": "seconds"}], "cost": {"amount": "1.5", "currency": "USD"}}
+]`,
+		"billing-2019-11-02.json": `[
+  {"projectId": "project-a", "measurements": [{"measurementId": "com.google.cloud/services/compute-engine/VmimageN1Standard_1", "sum": "3600", "unit": "seconds"}], "cost": {"amount": "2.5", "currency": "USD"}}
+]`,
+	}}
+	metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+	g := NewGCPBilling(metric, "bucket", "billing", "", "cost-centre", "").WithClients(Clients{
+		Reports: reports,
+		ResourceManager: &fake.ResourceManager{
+			Projects: []*crmv1.Project{{
+				ProjectId:     "project-a",
+				ProjectNumber: 1234,
+				Labels:        map[string]string{"cost-centre": "ops"},
+				Parent:        &crmv1.ResourceId{Type: "folder", Id: "42"},
+			}},
+			Folders:       []*crmv2.Folder{{Name: "folders/42", DisplayName: "team", Parent: "organizations/1"}},
+			Organizations: []*crmv1.Organization{{Name: "organizations/1", DisplayName: "example.com"}},
+		},
+	})
+	g.clock = fakeClock{Time: time.Date(2019, 11, 3, 10, 0, 0, 0, time.UTC)}
+
+	if err := g.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if act, exp := testutil.ToFloat64(metric.WithLabelValues("gcp", "USD", "project-a", "compute-engine", "example.com/team", "", "ops", "")), 4.0; act != exp {
+		t.Errorf("unexpected costs: %f (expected: %f)", act, exp)
+	}
+	if act := len(g.LineItems()); act != 2 {
+		t.Errorf("unexpected number of line items: %d", act)
+	}
+}
@@ -1,23 +1,36 @@
 package gcp
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"hash/crc32"
+	"io"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	"cloud.google.com/go/storage"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
 	"golang.org/x/net/context"
-	"google.golang.org/api/iterator"
+	"golang.org/x/oauth2/google"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+	"github.com/simonswine/cloud-billing-exporter/localfs"
+	"github.com/simonswine/cloud-billing-exporter/parse"
+	"github.com/simonswine/cloud-billing-exporter/state"
 )
 
 const DateFormat = "2006-01-02"
-const ReportsPerMonth = 32
 
 type Clock interface {
 	Now() time.Time
@@ -47,35 +60,211 @@ type gcpBillingElement struct {
 	Measurements []gcpBillingMeasurements
 	Cost         gcpBillingCost
 	Credits      []gcpBillingCost
+
+	// SKU is set if the costs are queried by SKU from BigQuery with the sku
+	// label enabled
+	SKU string `json:"-"`
+	// Labels are set if the labels are queried together with the costs,
+	// instead of being looked up through the resource manager
+	Labels *gcpBillingLabels `json:"-"`
+}
+
+// gcpBillingLabels are the label values of the costs of an element
+type gcpBillingLabels struct {
+	Owner       string
+	CostCentre  string
+	ProjectType string
 }
 
 type gcpBillingReport struct {
 	Elements []*gcpBillingElement
+	Usage    []billing.Usage
 	Hash     []byte
+
+	// Date is the day of the costs, taken from the report name. It is empty
+	// for reports not following the default naming.
+	Date string
 }
 
 type GCPBilling struct {
 	clock        Clock
+	clients      Clients
+	pipeline     *parse.Pipeline
 	BucketName   string
 	ReportPrefix string
 
+	// billingAccount is the name of the billing account
+	billingAccount string
+
+	// apiLatency observes the calls of the GCP APIs
+	apiLatency *billing.APILatency
+
+	// reportMatch is set if the report prefix is a glob or regex
+	reportMatch *reportMatch
+	// local is set if the reports are read from a local directory
+	local *fileReportBucket
+
+	// userProject is billed for the requests to Requester Pays buckets
+	userProject string
+
 	ReportsLock        sync.Mutex
-	Reports            [ReportsPerMonth]gcpBillingReport
+	Reports            map[string]*gcpBillingReport
 	ReportsMonthPrefix string
 
+	// bigQuery is set if the costs are queried from BigQuery instead
+	bigQuery *bigQuery
+
+	// records contains the costs of all parsed reports
+	records   []billing.Record
+	lineItems []billing.LineItem
+	usage     []billing.Usage
+	credits   []billing.Credit
+	// closedMonths contains the costs of the previous invoice month
+	closedMonths []billing.Record
+	// diagnostics describe the reports found by the last query
+	diagnostics billing.Diagnostics
+	recordsLock sync.Mutex
+
 	MetricMonthlyCosts *prometheus.CounterVec
-	metricValues       map[string]float64
+	metricRefunds      *prometheus.CounterVec
+	onDecrease         billing.OnDecrease
+	metricValues       map[string]state.Baseline
 	resourcesMetadata  *resourcesMetadata
+
+	// assetInventory is set if the resources are read from Cloud Asset
+	// Inventory exports instead of the Resource Manager API
+	assetInventory *assetInventory
+
+	// stateStore persists counter baselines and metadata caches, so they
+	// survive restarts and can be shared between replicas
+	stateStore    state.Store
+	stateRestored bool
+}
+
+// gcpBillingState is the part of GCPBilling persisted in the state store. The
+// parsed reports are not persisted, as they grow with the number of projects
+// and days of the month. They are read again after a restart, the baselines
+// make sure costs are not counted twice.
+type gcpBillingState struct {
+	Baselines         map[string]state.Baseline
+	ResourcesMetadata *resourcesMetadataState
 }
 
 func NewGCPBilling(metric *prometheus.CounterVec, bucketName, reportPrefix, ownerLabel string, costCentreLabel string, projectTypeLabel string) *GCPBilling {
-	return &GCPBilling{
+	g := &GCPBilling{
 		MetricMonthlyCosts: metric,
 		BucketName:         bucketName,
 		ReportPrefix:       reportPrefix,
 		resourcesMetadata:  newResourcesMetadata().WithResourceLabels(ownerLabel, costCentreLabel, projectTypeLabel),
 		clock:              realClock{},
-		metricValues:       map[string]float64{},
+		Reports:            map[string]*gcpBillingReport{},
+		metricValues:       map[string]state.Baseline{},
+	}
+	if dir, ok := localfs.Dir(bucketName); ok {
+		g.local = newFileReportBucket(dir)
+	}
+	return g
+}
+
+// WithStateStore enables persisting the collector state in the given store
+func (g *GCPBilling) WithStateStore(s state.Store) *GCPBilling {
+	g.stateStore = s
+	return g
+}
+
+// WithOnDecrease selects how falling costs are handled, the refunds counter
+// is used by billing.OnDecreaseRefundMetric
+func (g *GCPBilling) WithOnDecrease(mode billing.OnDecrease, refunds *prometheus.CounterVec) *GCPBilling {
+	g.onDecrease = mode
+	g.metricRefunds = refunds
+	return g
+}
+
+// WithPipeline parses the reports on the workers of the given pipeline
+func (g *GCPBilling) WithPipeline(p *parse.Pipeline) *GCPBilling {
+	g.pipeline = p
+	return g
+}
+
+// WithAPILatency observes the duration of the calls of the storage,
+// resource manager and BigQuery APIs
+func (g *GCPBilling) WithAPILatency(l *billing.APILatency) *GCPBilling {
+	g.apiLatency = l
+	g.resourcesMetadata.apiLatency = l
+	return g
+}
+
+// WithUserProject bills the requests to the bucket to the given project,
+// which is required for Requester Pays buckets
+func (g *GCPBilling) WithUserProject(project string) *GCPBilling {
+	g.userProject = project
+	return g
+}
+
+// WithEnrichment enables looking up the owners, cost centres, types and paths
+// of the projects through the resource manager API, which requires
+// resourcemanager permissions
+func (g *GCPBilling) WithEnrichment(enabled bool) *GCPBilling {
+	g.resourcesMetadata.disabled = !enabled
+	return g
+}
+
+// WithOwnerAndPath enables the owner and path labels, which are looked up
+// from the project labels and the position of the project in the resource
+// hierarchy
+func (g *GCPBilling) WithOwnerAndPath(owner, path bool) *GCPBilling {
+	g.resourcesMetadata.disableOwner = !owner
+	g.resourcesMetadata.disablePath = !path
+	return g
+}
+
+func (g *GCPBilling) stateKey() string {
+	if g.bigQuery != nil {
+		return fmt.Sprintf("gcp/bigquery/%s", g.bigQuery.table)
+	}
+	return fmt.Sprintf("gcp/%s/%s", g.BucketName, g.ReportPrefix)
+}
+
+// restoreState loads the persisted state once, before the first reports get
+// parsed. If that fails no reports are parsed, as otherwise the complete
+// month's costs would be added to the counters again.
+func (g *GCPBilling) restoreState(ctx context.Context) error {
+	if g.stateStore == nil || g.stateRestored {
+		return nil
+	}
+
+	var s gcpBillingState
+	if err := state.Load(ctx, g.stateStore, g.stateKey(), &s); err == state.ErrNotFound {
+		log.Debugf("no previous state for '%s' found in %s", g.stateKey(), g.stateStore)
+	} else if err != nil {
+		return fmt.Errorf("error restoring state from %s: %s", g.stateStore, err)
+	}
+
+	for key, baseline := range s.Baselines {
+		// state written by a version with different labels can't be used
+		if _, err := g.MetricMonthlyCosts.GetMetricWithLabelValues(baseline.Labels...); err != nil {
+			log.Warnf("dropping baseline '%s' restored from %s: %s", key, g.stateStore, err)
+			continue
+		}
+		g.metricValues[key] = baseline
+	}
+	if s.ResourcesMetadata != nil {
+		g.resourcesMetadata.restore(s.ResourcesMetadata)
+	}
+	g.stateRestored = true
+	return nil
+}
+
+func (g *GCPBilling) saveState(ctx context.Context) {
+	if g.stateStore == nil {
+		return
+	}
+
+	if err := state.Save(ctx, g.stateStore, g.stateKey(), &gcpBillingState{
+		Baselines:         g.metricValues,
+		ResourcesMetadata: g.resourcesMetadata.snapshot(),
+	}); err != nil {
+		log.Warnf("error persisting state to %s: %s", g.stateStore, err)
 	}
 }
 
@@ -97,6 +286,11 @@ func (g *GCPBilling) filterLastTwoMonths() []string {
 	}
 }
 
+// prefixMonth returns the month of a prefix returned by filterLastTwoMonths
+func (g *GCPBilling) prefixMonth(prefix string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(prefix, g.ReportPrefix+"-"), "-")
+}
+
 // simplify service key
 func (e *gcpBillingElement) GetServiceName() string {
 	if e.ServiceName != "" {
@@ -117,16 +311,62 @@ func (e *gcpBillingElement) GetServiceName() string {
 }
 
 func (e *gcpBillingElement) GetValue() float64 {
-	if e.Cost.Amount != "" {
-		value, err := strconv.ParseFloat(e.Cost.Amount, 64)
+	return e.Cost.GetValue()
+}
+
+func (c *gcpBillingCost) GetValue() float64 {
+	if c.Amount != "" {
+		value, err := strconv.ParseFloat(c.Amount, 64)
 		if err != nil {
-			log.Warnf("failed to convert '%s' to float: %v", e.Cost.Amount, err)
+			log.Warnf("failed to convert '%s' to float: %v", c.Amount, err)
 		} else {
 			return value
 		}
 	}
 
-	return e.Cost.Value
+	return c.Value
+}
+
+// addCredits sums up the credits per credit ID and currency
+func (e *gcpBillingElement) addCredits(credits []gcpBillingCost) {
+	for _, c := range credits {
+		found := false
+		for i := range e.Credits {
+			if e.Credits[i].CreditID == c.CreditID && e.Credits[i].Currency == c.Currency {
+				e.Credits[i].Value += c.GetValue()
+				found = true
+				break
+			}
+		}
+		if !found {
+			e.Credits = append(e.Credits, gcpBillingCost{
+				CreditID: c.CreditID,
+				Currency: c.Currency,
+				Value:    c.GetValue(),
+			})
+		}
+	}
+}
+
+// creditType classifies a credit by its ID, e.g. SustainedUsageDiscount or
+// FreeTrial:012345. Discounts of negotiated agreements are named like
+// "Spending based discount (contractual)" or have the type DISCOUNT in the
+// detailed export.
+func creditType(id string) string {
+	id = strings.ToLower(strings.NewReplacer(" ", "", "_", "", "-", "").Replace(id))
+	switch {
+	case strings.Contains(id, "sustained"):
+		return billing.CreditTypeSustainedUse
+	case strings.Contains(id, "committed"):
+		return billing.CreditTypeCommittedUse
+	case strings.Contains(id, "freetier"):
+		return billing.CreditTypeFreeTier
+	case strings.Contains(id, "freetrial"), strings.Contains(id, "promotion"), strings.Contains(id, "promo"):
+		return billing.CreditTypePromotion
+	case id == "discount", strings.Contains(id, "contractual"), strings.Contains(id, "negotiated"), strings.Contains(id, "spendbased"), strings.Contains(id, "spendingbased"):
+		return billing.CreditTypeNegotiated
+	}
+	return billing.CreditTypeOther
 }
 
 func reduceElementsByFunc(elementsIn []*gcpBillingElement, fnKey func(*gcpBillingElement) string) []*gcpBillingElement {
@@ -140,68 +380,329 @@ func reduceElementsByFunc(elementsIn []*gcpBillingElement, fnKey func(*gcpBillin
 				ProjectID:   elem.ProjectID,
 				ProjectName: elem.ProjectName,
 				ServiceName: elem.GetServiceName(),
+				SKU:         elem.SKU,
+				Labels:      elem.Labels,
 				Cost: gcpBillingCost{
 					Currency: elem.Cost.Currency,
 					Value:    elem.GetValue(),
 				},
 			}
+			e.addCredits(elem.Credits)
 			elementsOut = append(elementsOut, e)
 			keyMap[key] = e
 		} else {
 			groupElem.Cost.Value = groupElem.Cost.Value + elem.GetValue()
+			groupElem.addCredits(elem.Credits)
 		}
 	}
 	return elementsOut
 }
 
 func groupByProjectIDServiceCurrency(e *gcpBillingElement) string {
-	return fmt.Sprintf(
+	// the SKU is keyed like it is appended to the service, so the baselines
+	// are kept when the sku label is enabled
+	service := e.GetServiceName()
+	if e.SKU != "" {
+		service = fmt.Sprintf("%s/%s", service, e.SKU)
+	}
+	key := fmt.Sprintf(
 		"%s-%s-%s",
 		e.ProjectID,
-		e.GetServiceName(),
+		service,
 		e.Cost.Currency,
 	)
+	if e.Labels != nil {
+		key = fmt.Sprintf("%s-%s-%s-%s", key, e.Labels.Owner, e.Labels.CostCentre, e.Labels.ProjectType)
+	}
+	return key
 }
 
 func reduceElementsByProjectIDServiceCurrency(elementsIn []*gcpBillingElement) []*gcpBillingElement {
 	return reduceElementsByFunc(elementsIn, groupByProjectIDServiceCurrency)
 }
 
-func (g *GCPBilling) getReportFile(ctx context.Context, bucket *storage.BucketHandle, objectAttrs *storage.ObjectAttrs) {
-	lengthName := len(objectAttrs.Name)
-	if lengthName < 8 {
-		log.Warnf("invalid report filename: %s", objectAttrs.Name)
+// usage returns the usage quantity and costs of an element with a single
+// measurement
+func (e *gcpBillingElement) usage() (billing.Usage, bool) {
+	if len(e.Measurements) != 1 {
+		return billing.Usage{}, false
+	}
+	m := e.Measurements[0]
+	quantity, err := strconv.ParseFloat(m.Sum, 64)
+	if err != nil {
+		log.Warnf("failed to convert usage '%s' to float: %v", m.Sum, err)
+		return billing.Usage{}, false
+	}
+	return billing.Usage{
+		Cloud:    "gcp",
+		Service:  e.GetServiceName(),
+		SKU:      m.MeasurementID,
+		Unit:     m.Unit,
+		Currency: e.Cost.Currency,
+		Quantity: quantity,
+		Costs:    e.GetValue(),
+	}, true
+}
+
+// reportReducer aggregates the elements of a report while they are decoded,
+// so only the costs per project, service and currency and the usage per
+// measurement are kept in memory
+type reportReducer struct {
+	stats *parse.Stats
+
+	elems      []*gcpBillingElement
+	elemIndex  map[string]*gcpBillingElement
+	usage      []billing.Usage
+	usageIndex map[[4]string]int
+}
+
+func newReportReducer(stats *parse.Stats) *reportReducer {
+	return &reportReducer{
+		stats:      stats,
+		elemIndex:  make(map[string]*gcpBillingElement),
+		usageIndex: make(map[[4]string]int),
+	}
+}
+
+func (r *reportReducer) add(elem *gcpBillingElement) {
+	r.stats.Read()
+	if elem.Cost.Amount != "" {
+		if _, err := strconv.ParseFloat(elem.Cost.Amount, 64); err != nil {
+			log.Warnf("failed to convert '%s' to float: %v", elem.Cost.Amount, err)
+			r.stats.InvalidCost()
+			return
+		}
+	}
+	r.stats.Aggregated(elem.ProjectID)
+
+	if u, ok := elem.usage(); ok {
+		key := [4]string{u.Service, u.SKU, u.Unit, u.Currency}
+		if i, ok := r.usageIndex[key]; ok {
+			r.usage[i].Quantity += u.Quantity
+			r.usage[i].Costs += u.Costs
+		} else {
+			r.usageIndex[key] = len(r.usage)
+			r.usage = append(r.usage, u)
+		}
+	}
+
+	key := groupByProjectIDServiceCurrency(elem)
+	if e, ok := r.elemIndex[key]; ok {
+		e.Cost.Value += elem.GetValue()
+		e.addCredits(elem.Credits)
 		return
 	}
+	e := &gcpBillingElement{
+		ProjectID:   elem.ProjectID,
+		ProjectName: elem.ProjectName,
+		ServiceName: elem.GetServiceName(),
+		Cost: gcpBillingCost{
+			Currency: elem.Cost.Currency,
+			Value:    elem.GetValue(),
+		},
+	}
+	e.addCredits(elem.Credits)
+	r.elemIndex[key] = e
+	r.elems = append(r.elems, e)
+}
+
+// decodeReport reads the JSON array of a report file element by element
+func decodeReport(input io.Reader, stats *parse.Stats) ([]*gcpBillingElement, []billing.Usage, error) {
+	dec := json.NewDecoder(input)
+	if t, err := dec.Token(); err != nil {
+		return nil, nil, err
+	} else if t != json.Delim('[') {
+		return nil, nil, fmt.Errorf("expected an array of elements, got '%v'", t)
+	}
 
-	i, err := strconv.Atoi(objectAttrs.Name[lengthName-7 : lengthName-5])
+	r := newReportReducer(stats)
+	for dec.More() {
+		var elem gcpBillingElement
+		if err := dec.Decode(&elem); err != nil {
+			return nil, nil, err
+		}
+		r.add(&elem)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, nil, err
+	}
+	return r.elems, r.usage, nil
+}
+
+// csvReportColumns are the columns of the CSV file export used, Project ID
+// is missing in older exports
+var csvReportColumns = []string{"Cost", "Currency", "Measurement1", "Measurement1 Total Consumption", "Measurement1 Units"}
+
+// decodeCSVReport reads a report of the CSV file export row by row
+func decodeCSVReport(input io.Reader, stats *parse.Stats) ([]*gcpBillingElement, []billing.Usage, error) {
+	r := csv.NewReader(input)
+	r.ReuseRecord = true
+
+	header, err := r.Read()
 	if err != nil {
-		log.Warnf("invalid report filename '%s': %s", objectAttrs.Name, err)
-		return
+		return nil, nil, fmt.Errorf("error reading header: %s", err)
+	}
+	pos := make(map[string]int)
+	for i, name := range header {
+		pos[strings.TrimPrefix(name, "\ufeff")] = i
+	}
+	for _, c := range csvReportColumns {
+		if _, ok := pos[c]; !ok {
+			return nil, nil, fmt.Errorf("required column '%s' missing", c)
+		}
+	}
+	value := func(row []string, name string) string {
+		if i, ok := pos[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
 	}
-	i = i - 1
 
-	if reflect.DeepEqual(g.Reports[i].Hash, objectAttrs.MD5) {
-		log.Debugf("report '%s' already parsed in cache", objectAttrs.Name)
-		return
+	reducer := newReportReducer(stats)
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		projectID := value(row, "Project ID")
+		if projectID == "" {
+			projectID = value(row, "Project")
+		}
+		elem := &gcpBillingElement{
+			ProjectID:   projectID,
+			ProjectName: value(row, "Project Name"),
+			Cost: gcpBillingCost{
+				Amount:   value(row, "Cost"),
+				Currency: value(row, "Currency"),
+			},
+		}
+		for i := 1; ; i++ {
+			column := fmt.Sprintf("Credit%d", i)
+			if _, ok := pos[column]; !ok {
+				break
+			}
+			if id := value(row, column); id != "" {
+				elem.Credits = append(elem.Credits, gcpBillingCost{
+					CreditID: id,
+					Amount:   value(row, column+" Amount"),
+					Currency: value(row, column+" Currency"),
+				})
+			}
+		}
+		if id := value(row, "Measurement1"); id != "" {
+			elem.Measurements = []gcpBillingMeasurements{{
+				MeasurementID: id,
+				Sum:           value(row, "Measurement1 Total Consumption"),
+				Unit:          value(row, "Measurement1 Units"),
+			}}
+		}
+		reducer.add(elem)
+	}
+	return reducer.elems, reducer.usage, nil
+}
+
+// decodeReportFile reads a report in JSON or CSV format, the format is taken
+// from the extension or detected from the content. The rows are counted in
+// stats.
+func decodeReportFile(name string, input io.Reader, stats *parse.Stats) ([]*gcpBillingElement, []billing.Usage, error) {
+	switch {
+	case strings.HasSuffix(name, ".json"):
+		return decodeReport(input, stats)
+	case strings.HasSuffix(name, ".csv"):
+		return decodeCSVReport(input, stats)
+	}
+
+	br := bufio.NewReader(input)
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error detecting report format: %s", err)
+		}
+		if unicode.IsSpace(rune(b[0])) {
+			if _, err := br.ReadByte(); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		if b[0] == '[' {
+			return decodeReport(br, stats)
+		}
+		return decodeCSVReport(br, stats)
+	}
+}
+
+// reportDate returns the day of a report named <prefix>-YYYY-MM-DD.json,
+// additional suffixes of multiple files per day are ignored
+func reportDate(monthPrefix, name string) string {
+	if !strings.HasPrefix(name, monthPrefix) {
+		return ""
+	}
+	day := strings.TrimPrefix(name, monthPrefix)
+	if len(day) < 2 {
+		return ""
+	}
+	if _, err := strconv.Atoi(day[:2]); err != nil {
+		return ""
 	}
+	date := strings.TrimSuffix(monthPrefix, "-")
+	date = date[len(date)-7:] + "-" + day[:2]
+	if _, err := time.Parse(DateFormat, date); err != nil {
+		return ""
+	}
+	return date
+}
+
+// newChecksumReader verifies the content against the MD5 of the object, or
+// its CRC32C for composite objects without MD5
+func newChecksumReader(r io.Reader, attrs *storage.ObjectAttrs) *parse.ChecksumReader {
+	if len(attrs.MD5) > 0 {
+		return parse.NewChecksumReader(r, md5.New(), attrs.MD5)
+	}
+	if attrs.CRC32C != 0 {
+		expected := make([]byte, 4)
+		binary.BigEndian.PutUint32(expected, attrs.CRC32C)
+		return parse.NewChecksumReader(r, crc32.New(crc32.MakeTable(crc32.Castagnoli)), expected)
+	}
+	return nil
+}
 
-	reader, err := bucket.Object(objectAttrs.Name).NewReader(ctx)
+// getReportFile parses a report and returns it with the number of bytes read
+func (g *GCPBilling) getReportFile(ctx context.Context, bucket ReportBucket, objectAttrs *storage.ObjectAttrs) (*gcpBillingReport, int64, error) {
+	reader, err := bucket.NewReader(ctx, objectAttrs.Name)
 	if err != nil {
-		log.Warnf("failed to read report '%s': %v", objectAttrs.Name, err)
-		return
+		return nil, 0, fmt.Errorf("failed to read report '%s': %v", objectAttrs.Name, err)
 	}
 	defer reader.Close()
-	err = json.NewDecoder(reader).Decode(&g.Reports[i].Elements)
+	counter := &parse.CountingReader{Reader: reader}
+	var content io.Reader = counter
+	checksum := newChecksumReader(counter, objectAttrs)
+	if checksum != nil {
+		content = checksum
+	}
+	stats := &parse.Stats{}
+	elems, usage, err := decodeReportFile(objectAttrs.Name, content, stats)
+	// truncated downloads fail the checksum and are retried, even if they
+	// could be parsed
+	if checksum != nil {
+		if verifyErr := checksum.Verify(); verifyErr != nil {
+			return nil, counter.N, fmt.Errorf("failed to verify report '%s': %w", objectAttrs.Name, verifyErr)
+		}
+	}
 	if err != nil {
-		log.Warnf("failed to parse report JSON '%s': %v", objectAttrs.Name, err)
-		return
+		return nil, counter.N, fmt.Errorf("failed to parse report '%s': %v", objectAttrs.Name, err)
 	}
+	g.pipeline.Observe("gcp", stats)
 
-	g.Reports[i].Elements = reduceElementsByProjectIDServiceCurrency(g.Reports[i].Elements)
-	g.Reports[i].Hash = objectAttrs.MD5
-
-	for _, elem := range g.Reports[i].Elements {
+	report := &gcpBillingReport{
+		Elements: elems,
+		Usage:    usage,
+		Hash:     objectAttrs.MD5,
+		Date:     reportDate(g.reportMonthPrefix(objectAttrs.Name), objectAttrs.Name),
+	}
+	for _, elem := range report.Elements {
 		log.With(
 			"currency",
 			elem.Cost.Currency,
@@ -218,36 +719,49 @@ func (g *GCPBilling) getReportFile(ctx context.Context, bucket *storage.BucketHa
 			objectAttrs.Name,
 		)
 	}
+	return report, counter.N, nil
 }
 
 func (g *GCPBilling) GetReports(ctx context.Context) error {
 
-	// create a GCS client.
-	client, err := storage.NewClient(ctx)
+	bucket, err := g.reportBucket(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to create client: %v", err)
+		return err
 	}
 
-	bucket := client.Bucket(g.BucketName)
+	// reports matched by a glob or regex are listed once for both months
+	var matched []*storage.ObjectAttrs
+	if g.reportMatch != nil {
+		log.Debugf("looking for reports in bucket '%s' matching '%s'", g.BucketName, g.reportMatch.expr)
+		matched, err = bucket.ListObjects(ctx, g.reportMatch.prefix)
+		if err != nil {
+			return fmt.Errorf("Failed to list objects: %v", err)
+		}
+	}
 
-	var it *storage.ObjectIterator
-	var bucketAttrs *storage.ObjectAttrs
+	var objects []*storage.ObjectAttrs
 	var prefix string
 	for _, prefix = range g.filterLastTwoMonths() {
-		log.Debugf("looking for reports in bucket '%s' with prefix '%s'", g.BucketName, prefix)
-		it = bucket.Objects(ctx, &storage.Query{Prefix: prefix})
-		bucketAttrs, err = it.Next()
-		if err == iterator.Done {
-			bucketAttrs = nil
-			continue
+		if g.reportMatch != nil {
+			objects = g.reportMatch.filter(matched, g.prefixMonth(prefix))
+		} else {
+			log.Debugf("looking for reports in bucket '%s' with prefix '%s'", g.BucketName, prefix)
+			objects, err = bucket.ListObjects(ctx, prefix)
+			if err != nil {
+				return fmt.Errorf("Failed to list objects: %v", err)
+			}
 		}
-		if err != nil {
-			return fmt.Errorf("Failed to list objects: %v", err)
+		if len(objects) > 0 {
+			break
 		}
-		break
 	}
 
-	if bucketAttrs == nil {
+	source := fmt.Sprintf("gs://%s/%s", g.BucketName, prefix)
+	if g.local != nil {
+		source = strings.TrimSuffix(g.BucketName, "/") + "/" + prefix
+	}
+	g.setDiagnostics(source, objects)
+	if len(objects) == 0 {
 		log.Warnf("No reports of this or last month found in bucket '%s' with prefix '%s'", g.BucketName, g.ReportPrefix)
 		return nil
 	}
@@ -255,37 +769,120 @@ func (g *GCPBilling) GetReports(ctx context.Context) error {
 	if g.ReportsMonthPrefix != prefix {
 		log.Debugf("reports prefix changed -> clear cache (old: %s, new: %s)", g.ReportsMonthPrefix, prefix)
 		g.ReportsMonthPrefix = prefix
-		g.Reports = [ReportsPerMonth]gcpBillingReport{}
+		g.Reports = map[string]*gcpBillingReport{}
 	}
 
-	var wg sync.WaitGroup
-
-	wg.Add(1)
-	go func(attr *storage.ObjectAttrs) {
-		defer wg.Done()
-		g.getReportFile(ctx, bucket, attr)
-	}(bucketAttrs)
+	// reports are parsed again if their hash changed, reports no longer
+	// listed are dropped
+	reports := make(map[string]*gcpBillingReport, len(objects))
+	var pending []*storage.ObjectAttrs
+	for _, attrs := range objects {
+		if r, ok := g.Reports[attrs.Name]; ok && bytes.Equal(r.Hash, attrs.MD5) {
+			log.Debugf("report '%s' already parsed in cache", attrs.Name)
+			reports[attrs.Name] = r
+			continue
+		}
+		pending = append(pending, attrs)
+	}
 
-	// list objects
-	for {
-		bucketAttrs, err := it.Next()
-		if err == iterator.Done {
-			break
+	parsed := make([]*gcpBillingReport, len(pending))
+	jobs := make([]parse.Job, len(pending))
+	for i, attrs := range pending {
+		i, attrs := i, attrs
+		jobs[i] = parse.RetryChecksum(parse.ChecksumAttempts, func() (int64, error) {
+			report, n, err := g.getReportFile(ctx, bucket, attrs)
+			parsed[i] = report
+			return n, err
+		})
+	}
+	for i, err := range g.pipeline.Run("gcp", jobs) {
+		name := pending[i].Name
+		if err == nil {
+			reports[name] = parsed[i]
+			continue
 		}
-		if err != nil {
-			return fmt.Errorf("Failed to list objects: %v", err)
+		log.Warn(err)
+		// keep the previous version of the report
+		if previous, ok := g.Reports[name]; ok {
+			reports[name] = previous
 		}
-		wg.Add(1)
-		go func(attr *storage.ObjectAttrs) {
-			defer wg.Done()
-			g.getReportFile(ctx, bucket, attr)
-		}(bucketAttrs)
 	}
-
-	wg.Wait()
+	g.Reports = reports
 	return nil
 }
 
+// CheckCredentials verifies the credentials by listing the reports of the
+// current month, in BigQuery mode by a dry run of the query
+func (g *GCPBilling) CheckCredentials(ctx context.Context) error {
+	if g.bigQuery != nil {
+		return g.bigQuery.dryRun(ctx, g.clock.Now())
+	}
+	bucket, err := g.reportBucket(ctx)
+	if err != nil {
+		return err
+	}
+	if g.reportMatch != nil {
+		_, err = bucket.ListObjects(ctx, g.reportMatch.prefix)
+		return err
+	}
+	_, err = bucket.ListObjects(ctx, g.filterLastTwoMonths()[0])
+	return err
+}
+
+// setDiagnostics keeps the reports found by the last query
+func (g *GCPBilling) setDiagnostics(source string, objects []*storage.ObjectAttrs) {
+	g.recordsLock.Lock()
+	defer g.recordsLock.Unlock()
+	g.diagnostics = billing.Diagnostics{Source: source, Reports: len(objects)}
+	for _, attrs := range objects {
+		if attrs.Updated.After(g.diagnostics.NewestReportModified) || g.diagnostics.NewestReport == "" {
+			g.diagnostics.NewestReport = attrs.Name
+			g.diagnostics.NewestReportModified = attrs.Updated
+		}
+	}
+}
+
+// LastReport returns when the newest report found by the last query was
+// modified
+func (g *GCPBilling) LastReport() time.Time {
+	g.recordsLock.Lock()
+	defer g.recordsLock.Unlock()
+	return g.diagnostics.NewestReportModified
+}
+
+// Diagnostics returns the identity of the default credentials and the
+// reports found by the last query
+func (g *GCPBilling) Diagnostics(ctx context.Context) (billing.Diagnostics, error) {
+	g.recordsLock.Lock()
+	d := g.diagnostics
+	g.recordsLock.Unlock()
+	if g.bigQuery != nil {
+		d.Source = fmt.Sprintf("bigquery table %s", g.bigQuery.table)
+	}
+	if g.local != nil && g.bigQuery == nil {
+		return d, nil
+	}
+
+	creds, err := google.FindDefaultCredentials(ctx, storage.ScopeReadOnly)
+	if err != nil {
+		return d, err
+	}
+	var key struct {
+		ClientEmail string `json:"client_email"`
+	}
+	if len(creds.JSON) > 0 {
+		if err := json.Unmarshal(creds.JSON, &key); err != nil {
+			return d, fmt.Errorf("error parsing credentials: %s", err)
+		}
+	}
+	d.Identity = key.ClientEmail
+	if d.Identity == "" {
+		// credentials of the metadata server or user credentials
+		d.Identity = fmt.Sprintf("default credentials of project '%s'", creds.ProjectID)
+	}
+	return d, nil
+}
+
 func (g *GCPBilling) Test() error {
 	return g.Query()
 }
@@ -297,6 +894,14 @@ func (g *GCPBilling) Query() error {
 	g.ReportsLock.Lock()
 	defer g.ReportsLock.Unlock()
 
+	if err := g.restoreState(ctx); err != nil {
+		return err
+	}
+
+	if g.bigQuery != nil {
+		return g.queryBigQuery(ctx)
+	}
+
 	// update from GCS buckets
 	err := g.GetReports(ctx)
 	if err != nil {
@@ -304,57 +909,211 @@ func (g *GCPBilling) Query() error {
 	}
 
 	// update metadata if neccessary
+	metadataUpdated := g.resourcesMetadata.updated()
 	if err := g.resourcesMetadata.update(ctx); err != nil {
 		log.Warnf("error updating resource metadata: %s", err)
 	}
 
-	// gather all costs
+	// only persist the state if anything changed
+	changed := !metadataUpdated.Equal(g.resourcesMetadata.updated())
+
+	// gather all costs, in the order of the report names
+	names := make([]string, 0, len(g.Reports))
+	for name := range g.Reports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 	elems := []*gcpBillingElement{}
-	for _, report := range g.Reports {
-		elems = append(elems, report.Elements...)
+	for _, name := range names {
+		elems = append(elems, g.Reports[name].Elements...)
 	}
 
 	// group them
 	elems = reduceElementsByProjectIDServiceCurrency(elems)
 
 	// write them into the metrics
+	month := g.prefixMonth(g.ReportsMonthPrefix)
+	records, credits, costsChanged := g.updateCosts(month, elems)
+	changed = changed || costsChanged
+
+	// line items with the costs per day, multiple reports of the same day
+	// are summed up
+	var lineItems []billing.LineItem
+	lineItemIndex := make(map[string]int)
+	for _, name := range names {
+		report := g.Reports[name]
+		if report.Date == "" {
+			log.Debugf("no line items for report '%s' without date in its name", name)
+			continue
+		}
+		for _, elem := range report.Elements {
+			key := report.Date + "-" + groupByProjectIDServiceCurrency(elem)
+			if i, ok := lineItemIndex[key]; ok {
+				lineItems[i].Costs += elem.GetValue()
+				continue
+			}
+			lineItemIndex[key] = len(lineItems)
+			lineItems = append(lineItems, billing.LineItem{
+				Cloud:    "gcp",
+				Month:    month,
+				Date:     report.Date,
+				Currency: elem.Cost.Currency,
+				Account:  elem.ProjectID,
+				Service:  elem.GetServiceName(),
+				Costs:    elem.GetValue(),
+			})
+		}
+	}
+	g.setRecords(records, lineItems)
+	g.setCredits(credits)
+
+	// usage of the month per measurement
+	var usage []billing.Usage
+	for _, name := range names {
+		for _, u := range g.Reports[name].Usage {
+			u.Month = month
+			usage = append(usage, u)
+		}
+	}
+	g.setUsage(billing.MergeUsage(usage))
+
+	if changed {
+		g.saveState(ctx)
+	}
+
+	return nil
+}
+
+// costRecords attaches the project metadata to the grouped costs of a month
+func (g *GCPBilling) costRecords(month string, elems []*gcpBillingElement) ([]billing.Record, []billing.Credit) {
+	records := make([]billing.Record, 0, len(elems))
+	var credits []billing.Credit
 	for _, elem := range elems {
-		var owner, costcentre, projectType, path string
+		record := billing.Record{
+			Cloud:    "gcp",
+			Month:    month,
+			Currency: elem.Cost.Currency,
+			Account:  elem.ProjectID,
+			Service:  elem.GetServiceName(),
+			SKU:      elem.SKU,
+			Costs:    elem.GetValue(),
+		}
 		metadata := g.resourcesMetadata.projectByID(elem.ProjectID)
 		if metadata != nil {
-			owner = metadata.owner
-			costcentre = metadata.costCentre
-			projectType = metadata.projectType
-			path = strings.Join(g.resourcesMetadata.path(metadata), "/")
+			record.Owner = metadata.owner
+			record.CostCentre = metadata.costCentre
+			record.Type = metadata.projectType
+			record.Path = strings.Join(g.resourcesMetadata.path(metadata), "/")
+		}
+		if elem.Labels != nil {
+			record.Owner = elem.Labels.Owner
+			record.CostCentre = elem.Labels.CostCentre
+			record.Type = elem.Labels.ProjectType
+		}
+		records = append(records, record)
+		for _, c := range elem.Credits {
+			currency := c.Currency
+			if currency == "" {
+				currency = record.Currency
+			}
+			credits = append(credits, billing.Credit{
+				Cloud:      record.Cloud,
+				Month:      record.Month,
+				Currency:   currency,
+				Account:    record.Account,
+				Service:    record.Service,
+				Path:       record.Path,
+				Owner:      record.Owner,
+				CostCentre: record.CostCentre,
+				Type:       record.Type,
+				CreditType: creditType(c.CreditID),
+				CreditID:   c.CreditID,
+				// credits are reported as negative costs
+				Amount: -c.GetValue(),
+			})
 		}
 
-		m := g.MetricMonthlyCosts.WithLabelValues(
-			"gcp",
-			elem.Cost.Currency,
-			elem.ProjectID,
-			elem.GetServiceName(),
-			path,
-			owner,
-			costcentre,
-			projectType,
-		)
+	}
+	return records, credits
+}
+
+// updateCosts adds the grouped costs of the month to the monthly costs
+// counter, it returns whether any baseline changed
+func (g *GCPBilling) updateCosts(month string, elems []*gcpBillingElement) ([]billing.Record, []billing.Credit, bool) {
+	records, credits := g.costRecords(month, elems)
+	changed := false
+	for i, elem := range elems {
+		labels := records[i].Labels()
 		key := groupByProjectIDServiceCurrency(elem)
-		if _, ok := g.metricValues[key]; !ok {
-			g.metricValues[groupByProjectIDServiceCurrency(elem)] = 0
-		}
 		value := elem.GetValue()
-		delta := value - g.metricValues[key]
-		if delta < 0 {
-			log.With("project", elem.ProjectID).With("service_name", elem.GetServiceName()).Warnf("costs are falling by: '%f'", delta)
+		previous := g.metricValues[key].Previous(month)
+		if !g.onDecrease.AddCosts(g.MetricMonthlyCosts, g.metricRefunds, labels, previous, value) {
+			log.With("project", elem.ProjectID).With("service_name", elem.GetServiceName()).Warnf("costs are falling by: '%f'", value-previous)
 			continue
 		}
-		m.Add(delta)
-		g.metricValues[key] = value
+		if previous, ok := g.metricValues[key]; !ok || previous.Value != value || previous.Month != month || !reflect.DeepEqual(previous.Labels, labels) {
+			changed = true
+		}
+		g.metricValues[key] = state.Baseline{Labels: labels, Value: value, Month: month}
 	}
+	return records, credits, changed
+}
 
-	return nil
+func (g *GCPBilling) setRecords(records []billing.Record, lineItems []billing.LineItem) {
+	g.recordsLock.Lock()
+	defer g.recordsLock.Unlock()
+	g.records = records
+	g.lineItems = lineItems
+}
+
+// Records returns the costs of all parsed reports
+func (g *GCPBilling) Records() []billing.Record {
+	g.recordsLock.Lock()
+	defer g.recordsLock.Unlock()
+	return append([]billing.Record(nil), g.records...)
+}
+
+func (g *GCPBilling) setUsage(usage []billing.Usage) {
+	g.recordsLock.Lock()
+	defer g.recordsLock.Unlock()
+	g.usage = usage
+}
+
+// Usage returns the usage per measurement of the parsed reports
+func (g *GCPBilling) Usage() []billing.Usage {
+	g.recordsLock.Lock()
+	defer g.recordsLock.Unlock()
+	return append([]billing.Usage(nil), g.usage...)
+}
+
+func (g *GCPBilling) setCredits(credits []billing.Credit) {
+	g.recordsLock.Lock()
+	defer g.recordsLock.Unlock()
+	g.credits = credits
+}
+
+// Credits returns the credits per credit ID of the parsed reports
+func (g *GCPBilling) Credits() []billing.Credit {
+	g.recordsLock.Lock()
+	defer g.recordsLock.Unlock()
+	return append([]billing.Credit(nil), g.credits...)
+}
+
+// LineItems returns the line items of the parsed reports
+func (g *GCPBilling) LineItems() []billing.LineItem {
+	g.recordsLock.Lock()
+	defer g.recordsLock.Unlock()
+	return append([]billing.LineItem(nil), g.lineItems...)
 }
 
 func (g *GCPBilling) String() string {
-	return fmt.Sprintf("GCP Billing in bucket '%s'", g.BucketName)
+	if g.bigQuery != nil {
+		return fmt.Sprintf("GCP Billing in BigQuery table '%s'", g.bigQuery.table)
+	}
+	return fmt.Sprintf("GCP Billing in bucket '%s' with report prefix '%s'", g.BucketName, g.ReportPrefix)
+}
+
+// Cloud returns the name of the cloud, as used in the cloud label
+func (g *GCPBilling) Cloud() string {
+	return "gcp"
 }
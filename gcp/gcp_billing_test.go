@@ -1,9 +1,18 @@
 package gcp
 
 import (
+	"context"
+	"math"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+	"github.com/simonswine/cloud-billing-exporter/fake"
+	"github.com/simonswine/cloud-billing-exporter/state"
 )
 
 type fakeClock struct {
@@ -66,3 +75,245 @@ func Test_FilterLastTwoMonths(t *testing.T) {
 	}
 
 }
+
+type memoryStore map[string][]byte
+
+func (m memoryStore) Get(_ context.Context, key string) ([]byte, error) {
+	data, ok := m[key]
+	if !ok {
+		return nil, state.ErrNotFound
+	}
+	return data, nil
+}
+
+func (m memoryStore) Put(_ context.Context, key string, data []byte) error {
+	m[key] = data
+	return nil
+}
+
+func (m memoryStore) String() string {
+	return "memory"
+}
+
+func Test_RestoreStateDropsMismatchedBaselines(t *testing.T) {
+	ctx := context.Background()
+	store := memoryStore{}
+	if err := state.Save(ctx, store, "gcp/bucket/billing", &gcpBillingState{
+		Baselines: map[string]state.Baseline{
+			"project-a-compute-USD": {Labels: []string{"gcp", "USD", "project-a", "compute", "", "", "", ""}, Value: 1.5},
+			"project-b-compute-USD": {Labels: []string{"gcp", "USD", "project-b", "compute"}, Value: 2.5},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+	g := NewGCPBilling(metric, "bucket", "billing", "", "", "").WithStateStore(store)
+	if err := g.restoreState(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, ok := g.metricValues["project-a-compute-USD"]; !ok {
+		t.Error("expected matching baseline to be restored")
+	}
+	if _, ok := g.metricValues["project-b-compute-USD"]; ok {
+		t.Error("expected baseline with wrong label count to be dropped")
+	}
+}
+
+func Test_DecodeReport(t *testing.T) {
+	elems, usage, err := decodeReport(strings.NewReader(`[
+  {"projectId": "a", "measurements": [{"measurementId": "com.google.cloud/services/compute-engine/VmimageN1Standard_1", "sum": "3600", "unit": "seconds"}], "cost": {"amount": "0.0475", "currency": "USD"}},
+  {"projectId": "b", "measurements": [{"measurementId": "com.google.cloud/services/compute-engine/VmimageN1Standard_1", "sum": "7200", "unit": "seconds"}], "cost": {"amount": "0.095", "currency": "USD"}},
+  {"projectId": "b", "measurements": [{"measurementId": "com.google.cloud/services/compute-engine/VmimageN1Standard_1", "sum": "3600", "unit": "seconds"}], "cost": {"amount": "0.0475", "currency": "USD"}},
+  {"projectId": "b", "measurements": [{"measurementId": "com.google.cloud/services/a"}, {"measurementId": "com.google.cloud/services/b"}], "cost": {"amount": "1", "currency": "USD"}}
+]`), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(elems) != 3 {
+		t.Fatalf("unexpected elements: %+v", elems)
+	}
+	if e := elems[1]; e.ProjectID != "b" || e.GetServiceName() != "compute-engine" || math.Abs(e.GetValue()-0.1425) > 1e-9 {
+		t.Errorf("unexpected element: %+v", e)
+	}
+
+	// elements with multiple measurements can't be attributed to a SKU
+	if len(usage) != 1 {
+		t.Fatalf("unexpected usage: %+v", usage)
+	}
+	if u := usage[0]; u.Service != "compute-engine" || u.SKU != "com.google.cloud/services/compute-engine/VmimageN1Standard_1" || u.Unit != "seconds" || u.Quantity != 14400 || u.Currency != "USD" || math.Abs(u.Costs-0.19) > 1e-9 {
+		t.Errorf("unexpected usage: %+v", u)
+	}
+
+	for _, input := range []string{`{"projectId": "a"}`, `[{"projectId": "a"}`} {
+		if _, _, err := decodeReport(strings.NewReader(input), nil); err == nil {
+			t.Errorf("expected error decoding '%s'", input)
+		}
+	}
+}
+
+func Test_ReportDate(t *testing.T) {
+	for name, exp := range map[string]string{
+		"billing-2019-11-01.json":       "2019-11-01",
+		"billing-2019-11-31.json":       "",
+		"billing-2019-11-02-part2.json": "2019-11-02",
+		"billing-2019-11-export.json":   "",
+		"other-2019-11-01.json":         "",
+	} {
+		if act := reportDate("billing-2019-11-", name); act != exp {
+			t.Errorf("unexpected date of '%s': %s (expected: %s)", name, act, exp)
+		}
+	}
+}
+
+func Test_QueryReportsByName(t *testing.T) {
+	element := func(costs string) string {
+		return `[{"projectId": "project-a", "measurements": [{"measurementId": "com.google.cloud/services/compute-engine/VmimageN1Standard_1", "sum": "3600", "unit": "seconds"}], "cost": {"amount": "` + costs + `", "currency": "USD"}}]`
+	}
+	reports := &fake.GCS{Objects: map[string]string{
+		"billing-2019-11-01.json":       element("1"),
+		"billing-2019-11-02.json":       element("2"),
+		"billing-2019-11-02-part2.json": element("4"),
+		"billing-2019-11-export.json":   element("8"),
+	}}
+	metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+	g := NewGCPBilling(metric, "bucket", "billing", "", "", "").WithClients(Clients{
+		Reports:         reports,
+		ResourceManager: &fake.ResourceManager{},
+	})
+	g.clock = fakeClock{Time: time.Date(2019, 11, 3, 10, 0, 0, 0, time.UTC)}
+
+	if err := g.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if act := g.Records(); len(act) != 1 || act[0].Costs != 15 {
+		t.Errorf("unexpected records: %+v", act)
+	}
+
+	expLineItems := []billing.LineItem{
+		{Cloud: "gcp", Month: "2019-11", Date: "2019-11-01", Currency: "USD", Account: "project-a", Service: "compute-engine", Costs: 1},
+		{Cloud: "gcp", Month: "2019-11", Date: "2019-11-02", Currency: "USD", Account: "project-a", Service: "compute-engine", Costs: 6},
+	}
+	if act := g.LineItems(); !reflect.DeepEqual(act, expLineItems) {
+		t.Errorf("unexpected line items: act: %+v, exp: %+v", act, expLineItems)
+	}
+
+	// updated reports are parsed again, removed ones are dropped
+	reports.Objects["billing-2019-11-01.json"] = element("3")
+	delete(reports.Objects, "billing-2019-11-export.json")
+	if err := g.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if act := g.Records(); len(act) != 1 || act[0].Costs != 9 {
+		t.Errorf("unexpected records: %+v", act)
+	}
+	if len(g.Reports) != 3 {
+		t.Errorf("unexpected number of reports: %d", len(g.Reports))
+	}
+}
+
+func Test_QueryRetriesTruncatedReport(t *testing.T) {
+	reports := &fake.GCS{Objects: map[string]string{
+		"billing-2019-11-01.json": `[{"projectId": "project-a", "measurements": [], "cost": {"amount": "1.5", "currency": "USD"}}]`,
+	}, Truncate: 1}
+	metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+	g := NewGCPBilling(metric, "bucket", "billing", "", "", "").WithClients(Clients{
+		Reports:         reports,
+		ResourceManager: &fake.ResourceManager{},
+	})
+	g.clock = fakeClock{Time: time.Date(2019, 11, 3, 10, 0, 0, 0, time.UTC)}
+
+	if err := g.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if act := g.Records(); len(act) != 1 || act[0].Costs != 1.5 {
+		t.Errorf("unexpected records: %+v", act)
+	}
+}
+
+const csvReport = `Account ID,Line Item,Start Time,End Time,Project,Measurement1,Measurement1 Total Consumption,Measurement1 Units,Credit1,Credit1 Amount,Credit1 Currency,Cost,Currency,Project Number,Project ID,Project Name,Project Labels,Description
+0000AA-BBBBBB-CCCCCC,com.google.cloud/services/compute-engine/VmimageN1Standard_1,2019-11-01T00:00:00-07:00,2019-11-02T00:00:00-07:00,,com.google.cloud/services/compute-engine/VmimageN1Standard_1,3600,seconds,,,,0.0475,USD,1234,project-a,Project A,,
+0000AA-BBBBBB-CCCCCC,com.google.cloud/services/compute-engine/VmimageN1Standard_1,2019-11-01T00:00:00-07:00,2019-11-02T00:00:00-07:00,,com.google.cloud/services/compute-engine/VmimageN1Standard_1,7200,seconds,,,,0.095,USD,1234,project-a,Project A,,
+0000AA-BBBBBB-CCCCCC,com.google.cloud/services/cloud-storage/StorageMultiRegionalUsGbsec,2019-11-01T00:00:00-07:00,2019-11-02T00:00:00-07:00,,com.google.cloud/services/cloud-storage/StorageMultiRegionalUsGbsec,1000,gibibyte-seconds,,,,0.01,USD,5678,project-b,Project B,,
+`
+
+func Test_DecodeReportFile(t *testing.T) {
+	for _, name := range []string{"billing-2019-11-01.csv", "billing-2019-11-01"} {
+		elems, usage, err := decodeReportFile(name, strings.NewReader(csvReport), nil)
+		if err != nil {
+			t.Fatalf("unexpected error decoding '%s': %s", name, err)
+		}
+		if len(elems) != 2 {
+			t.Fatalf("unexpected elements: %+v", elems)
+		}
+		if e := elems[0]; e.ProjectID != "project-a" || e.GetServiceName() != "compute-engine" || math.Abs(e.GetValue()-0.1425) > 1e-9 || e.Cost.Currency != "USD" {
+			t.Errorf("unexpected element: %+v", e)
+		}
+		if len(usage) != 2 || usage[0].Quantity != 10800 || usage[0].Unit != "seconds" {
+			t.Errorf("unexpected usage: %+v", usage)
+		}
+	}
+
+	// JSON reports are detected by their content
+	elems, _, err := decodeReportFile("billing-2019-11-01", strings.NewReader(`
+  [{"projectId": "project-a", "cost": {"amount": "1", "currency": "USD"}}]`), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(elems) != 1 || elems[0].GetValue() != 1 {
+		t.Errorf("unexpected elements: %+v", elems)
+	}
+
+	if _, _, err := decodeReportFile("billing-2019-11-01.csv", strings.NewReader("Cost,Currency\n1,USD\n"), nil); err == nil || !strings.Contains(err.Error(), "Measurement1") {
+		t.Errorf("expected missing column error, got: %v", err)
+	}
+}
+
+func Test_CreditType(t *testing.T) {
+	for id, exp := range map[string]string{
+		"SustainedUsageDiscount":                billing.CreditTypeSustainedUse,
+		"Committed Usage Discount":              billing.CreditTypeCommittedUse,
+		"Free Tier":                             billing.CreditTypeFreeTier,
+		"FreeTrial:012345":                      billing.CreditTypePromotion,
+		"Promotion":                             billing.CreditTypePromotion,
+		"Reseller Margin":                       billing.CreditTypeOther,
+		"Spending based discount (contractual)": billing.CreditTypeNegotiated,
+		"DISCOUNT":                              billing.CreditTypeNegotiated,
+		"Negotiated Savings":                    billing.CreditTypeNegotiated,
+	} {
+		if act := creditType(id); act != exp {
+			t.Errorf("unexpected credit type of '%s': %s (expected: %s)", id, act, exp)
+		}
+	}
+}
+
+func Test_QueryCredits(t *testing.T) {
+	reports := &fake.GCS{Objects: map[string]string{
+		"billing-2019-11-01.json": `[
+  {"projectId": "project-a", "measurements": [{"measurementId": "com.google.cloud/services/compute-engine/VmimageN1Standard_1", "sum": "3600", "unit": "seconds"}], "cost": {"amount": "10", "currency": "USD"}, "credits": [{"creditId": "SustainedUsageDiscount", "amount": "-2", "currency": "USD"}, {"creditId": "FreeTrial:012345", "amount": "-1"}]}
+]`,
+		"billing-2019-11-02.json": `[
+  {"projectId": "project-a", "measurements": [{"measurementId": "com.google.cloud/services/compute-engine/VmimageN1Standard_1", "sum": "3600", "unit": "seconds"}], "cost": {"amount": "10", "currency": "USD"}, "credits": [{"creditId": "SustainedUsageDiscount", "amount": "-3", "currency": "USD"}]}
+]`,
+	}}
+	metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+	g := NewGCPBilling(metric, "bucket", "billing", "", "", "").WithClients(Clients{
+		Reports:         reports,
+		ResourceManager: &fake.ResourceManager{},
+	})
+	g.clock = fakeClock{Time: time.Date(2019, 11, 3, 10, 0, 0, 0, time.UTC)}
+
+	if err := g.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	exp := []billing.Credit{
+		{Cloud: "gcp", Month: "2019-11", Currency: "USD", Account: "project-a", Service: "compute-engine", CreditType: billing.CreditTypeSustainedUse, CreditID: "SustainedUsageDiscount", Amount: 5},
+		{Cloud: "gcp", Month: "2019-11", Currency: "USD", Account: "project-a", Service: "compute-engine", CreditType: billing.CreditTypePromotion, CreditID: "FreeTrial:012345", Amount: 1},
+	}
+	if act := g.Credits(); !reflect.DeepEqual(act, exp) {
+		t.Errorf("unexpected credits: act: %+v, exp: %+v", act, exp)
+	}
+}
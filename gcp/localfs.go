@@ -0,0 +1,47 @@
+package gcp
+
+import (
+	"io"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/net/context"
+
+	"github.com/simonswine/cloud-billing-exporter/localfs"
+)
+
+// fileReportBucket serves the reports synced into a local directory like the
+// GCS bucket
+type fileReportBucket struct {
+	*localfs.Bucket
+}
+
+func newFileReportBucket(dir string) *fileReportBucket {
+	return &fileReportBucket{Bucket: localfs.New(dir)}
+}
+
+func (b *fileReportBucket) ListObjects(_ context.Context, prefix string) ([]*storage.ObjectAttrs, error) {
+	files, err := b.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]*storage.ObjectAttrs, len(files))
+	for i, f := range files {
+		objects[i] = &storage.ObjectAttrs{
+			Name:    f.Name,
+			Size:    f.Size,
+			MD5:     f.MD5,
+			Updated: f.Modified,
+		}
+	}
+	return objects, nil
+}
+
+func (b *fileReportBucket) NewReader(_ context.Context, name string) (io.ReadCloser, error) {
+	r, err := b.Open(name)
+	if err == localfs.ErrNotExist {
+		return nil, storage.ErrObjectNotExist
+	} else if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
@@ -0,0 +1,56 @@
+package gcp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+func TestQueryLocalReports(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gcp-reports-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, costs string) {
+		report := `[{"projectId": "project-a", "measurements": [{"measurementId": "com.google.cloud/services/compute-engine/VmimageN1Standard_1", "sum": "3600", "unit": "seconds"}], "cost": {"amount": "` + costs + `", "currency": "USD"}}]`
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(report), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("billing-2019-11-01.json", "1.5")
+	write("billing-2019-11-02.json", "2.5")
+
+	metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+	g := NewGCPBilling(metric, "file://"+dir, "billing", "", "", "").WithEnrichment(false)
+	g.clock = fakeClock{Time: time.Date(2019, 11, 3, 10, 0, 0, 0, time.UTC)}
+
+	if err := g.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if act := g.Records(); len(act) != 1 || act[0].Costs != 4 {
+		t.Errorf("unexpected records: %+v", act)
+	}
+
+	// changed files are parsed again
+	write("billing-2019-11-02.json", "3.5")
+	modified := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(dir, "billing-2019-11-02.json"), modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if act := g.Records(); len(act) != 1 || act[0].Costs != 5 {
+		t.Errorf("unexpected records: %+v", act)
+	}
+	if len(g.Permissions()) != 0 {
+		t.Errorf("unexpected permissions of local reports: %+v", g.Permissions())
+	}
+}
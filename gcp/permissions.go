@@ -0,0 +1,112 @@
+package gcp
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+// Permissions returns the permissions required by the API calls of the
+// collector, with checks making these calls
+func (g *GCPBilling) Permissions() []billing.Permission {
+	var permissions []billing.Permission
+	add := func(action, resource string, check func(context.Context) error) {
+		permissions = append(permissions, billing.Permission{
+			Cloud:    "gcp",
+			Action:   action,
+			Resource: resource,
+			Check:    check,
+		})
+	}
+
+	if g.bigQuery != nil {
+		// a dry run validates both, the job and the access to the table
+		dryRun := billing.CheckOnce(func(ctx context.Context) error {
+			return g.bigQuery.dryRun(ctx, g.clock.Now())
+		})
+		parts := strings.SplitN(g.bigQuery.table, ".", 3)
+		add("bigquery.jobs.create", fmt.Sprintf("projects/%s", g.bigQuery.projectID), dryRun)
+		add("bigquery.tables.getData", fmt.Sprintf("projects/%s/datasets/%s/tables/%s", parts[0], parts[1], parts[2]), dryRun)
+	} else if g.local == nil {
+		// local reports are read without any GCP API calls
+		add("storage.objects.list", fmt.Sprintf("projects/_/buckets/%s", g.BucketName), g.checkListReports)
+		add("storage.objects.get", fmt.Sprintf("projects/_/buckets/%s/objects/%s*", g.BucketName, g.listPrefix()), g.checkGetReport)
+		if g.userProject != "" {
+			// required to bill the requests to the user project
+			add("serviceusage.services.use", fmt.Sprintf("projects/%s", g.userProject), g.checkListReports)
+		}
+	}
+
+	if !g.resourcesMetadata.disabled && g.assetInventory != nil {
+		bucket, prefix := g.assetInventory.resource()
+		add("storage.objects.list", fmt.Sprintf("projects/_/buckets/%s", bucket), g.assetInventory.checkListExports)
+		add("storage.objects.get", fmt.Sprintf("projects/_/buckets/%s/objects/%s*", bucket, prefix), g.assetInventory.checkGetExport)
+	} else if !g.resourcesMetadata.disabled {
+		add("resourcemanager.projects.get", "*", g.checkListProjects)
+		if !g.resourcesMetadata.disablePath {
+			add("resourcemanager.folders.get", "*", g.checkListFolders)
+			add("resourcemanager.organizations.get", "*", g.checkListOrganizations)
+		}
+	}
+
+	return permissions
+}
+
+func (g *GCPBilling) checkListReports(ctx context.Context) error {
+	bucket, err := g.reportBucket(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = bucket.ListObjects(ctx, g.listPrefix())
+	return err
+}
+
+func (g *GCPBilling) checkGetReport(ctx context.Context) error {
+	bucket, err := g.reportBucket(ctx)
+	if err != nil {
+		return err
+	}
+	objects, err := bucket.ListObjects(ctx, g.listPrefix())
+	if err != nil {
+		return err
+	}
+	if len(objects) == 0 {
+		return fmt.Errorf("no report found in bucket '%s' with prefix '%s'", g.BucketName, g.listPrefix())
+	}
+	r, err := bucket.NewReader(ctx, objects[0].Name)
+	if err != nil {
+		return err
+	}
+	_ = r.Close()
+	return nil
+}
+
+func (g *GCPBilling) checkListProjects(ctx context.Context) error {
+	client, err := g.resourcesMetadata.resourceManager(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = client.ListProjects(ctx)
+	return err
+}
+
+func (g *GCPBilling) checkListFolders(ctx context.Context) error {
+	client, err := g.resourcesMetadata.resourceManager(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = client.ListFolders(ctx)
+	return err
+}
+
+func (g *GCPBilling) checkListOrganizations(ctx context.Context) error {
+	client, err := g.resourcesMetadata.resourceManager(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = client.ListOrganizations(ctx)
+	return err
+}
@@ -0,0 +1,112 @@
+package gcp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/common/log"
+	"golang.org/x/net/context"
+	cloudbilling "google.golang.org/api/cloudbilling/v1"
+	"google.golang.org/api/option"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+// PricingCatalog looks up the list prices of SKUs in the Cloud Billing
+// Catalog API
+type PricingCatalog struct {
+	service  *cloudbilling.APIService
+	currency string
+
+	// skus contains the SKU IDs to look up per service ID
+	skus map[string]map[string]bool
+}
+
+// NewPricingCatalog returns a catalog for the given SKUs, each SKU is given as
+// <service id>/<sku id>, e.g. 6F81-5844-456A/9CBD-8E06-8A32
+func NewPricingCatalog(ctx context.Context, skus []string, currency string, opts ...option.ClientOption) (*PricingCatalog, error) {
+	service, err := cloudbilling.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("error creating cloud billing service: %s", err)
+	}
+
+	p := &PricingCatalog{
+		service:  service,
+		currency: currency,
+		skus:     make(map[string]map[string]bool),
+	}
+
+	for _, sku := range skus {
+		parts := strings.Split(strings.TrimSpace(sku), "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid SKU '%s', expected <service id>/<sku id>", sku)
+		}
+		if _, ok := p.skus[parts[0]]; !ok {
+			p.skus[parts[0]] = make(map[string]bool)
+		}
+		p.skus[parts[0]][parts[1]] = true
+	}
+	if len(p.skus) == 0 {
+		return nil, fmt.Errorf("no SKUs given")
+	}
+
+	return p, nil
+}
+
+// ListPrices returns the current list prices of all SKUs, the catalog only
+// allows listing all SKUs of a service
+func (p *PricingCatalog) ListPrices(ctx context.Context) ([]billing.ListPrice, error) {
+	var prices []billing.ListPrice
+	for service, skus := range p.skus {
+		found := make(map[string]bool)
+		call := p.service.Services.Skus.List("services/" + service)
+		if p.currency != "" {
+			call = call.CurrencyCode(p.currency)
+		}
+		if err := call.Pages(ctx, func(resp *cloudbilling.ListSkusResponse) error {
+			for _, sku := range resp.Skus {
+				if !skus[sku.SkuId] || len(sku.PricingInfo) == 0 {
+					continue
+				}
+				found[sku.SkuId] = true
+
+				// the last pricing info is the current one
+				expr := sku.PricingInfo[len(sku.PricingInfo)-1].PricingExpression
+				if expr == nil {
+					continue
+				}
+				for _, tier := range expr.TieredRates {
+					if tier.UnitPrice == nil {
+						continue
+					}
+					prices = append(prices, billing.ListPrice{
+						Cloud:       "gcp",
+						Service:     service,
+						SKU:         sku.SkuId,
+						Description: sku.Description,
+						Region:      strings.Join(sku.ServiceRegions, ","),
+						Unit:        expr.UsageUnit,
+						Currency:    tier.UnitPrice.CurrencyCode,
+						TierStart:   strconv.FormatFloat(tier.StartUsageAmount, 'f', -1, 64),
+						Price:       float64(tier.UnitPrice.Units) + float64(tier.UnitPrice.Nanos)/1e9,
+					})
+				}
+			}
+			return nil
+		}); err != nil {
+			return nil, fmt.Errorf("error listing SKUs of service %s: %s", service, err)
+		}
+
+		for sku := range skus {
+			if !found[sku] {
+				log.Warnf("SKU %s not found in the catalog of service %s", sku, service)
+			}
+		}
+	}
+	return prices, nil
+}
+
+func (p *PricingCatalog) String() string {
+	return "GCP Cloud Billing Catalog"
+}
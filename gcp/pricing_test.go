@@ -0,0 +1,71 @@
+package gcp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/option"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+func TestPricingCatalog(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path+"?currencyCode="+r.URL.Query().Get("currencyCode"))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"skus": [
+  {"skuId": "9CBD-8E06-8A32", "description": "Network Internet Egress from Americas to Americas", "serviceRegions": ["global"], "pricingInfo": [{"pricingExpression": {"usageUnit": "GiBy", "tieredRates": [
+    {"startUsageAmount": 0, "unitPrice": {"currencyCode": "EUR", "units": "0", "nanos": 0}},
+    {"startUsageAmount": 1, "unitPrice": {"currencyCode": "EUR", "units": "0", "nanos": 105000000}}
+  ]}}]},
+  {"skuId": "AAAA-BBBB-CCCC", "description": "Not selected", "pricingInfo": [{"pricingExpression": {"usageUnit": "h", "tieredRates": [
+    {"startUsageAmount": 0, "unitPrice": {"currencyCode": "EUR", "units": "1", "nanos": 0}}
+  ]}}]}
+]}`))
+	}))
+	defer srv.Close()
+
+	p, err := NewPricingCatalog(context.Background(), []string{"6F81-5844-456A/9CBD-8E06-8A32"}, "EUR",
+		option.WithEndpoint(srv.URL+"/"),
+		option.WithoutAuthentication(),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	prices, err := p.ListPrices(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	price := billing.ListPrice{
+		Cloud:       "gcp",
+		Service:     "6F81-5844-456A",
+		SKU:         "9CBD-8E06-8A32",
+		Description: "Network Internet Egress from Americas to Americas",
+		Region:      "global",
+		Unit:        "GiBy",
+		Currency:    "EUR",
+		TierStart:   "0",
+	}
+	exp := []billing.ListPrice{price, price}
+	exp[1].TierStart = "1"
+	exp[1].Price = 0.105
+	if !reflect.DeepEqual(prices, exp) {
+		t.Errorf("unexpected prices: act: %+v, exp: %+v", prices, exp)
+	}
+
+	if len(requests) != 1 || requests[0] != "/v1/services/6F81-5844-456A/skus?currencyCode=EUR" {
+		t.Errorf("unexpected requests: %+v", requests)
+	}
+}
+
+func TestPricingCatalogInvalidSKU(t *testing.T) {
+	if _, err := NewPricingCatalog(context.Background(), []string{"9CBD-8E06-8A32"}, "", option.WithoutAuthentication()); err == nil {
+		t.Error("expected error for SKU without service")
+	}
+}
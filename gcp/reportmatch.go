@@ -0,0 +1,152 @@
+package gcp
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+const (
+	// ReportPrefixMatchPrefix selects the reports by the fixed report prefix
+	ReportPrefixMatchPrefix = "prefix"
+	// ReportPrefixMatchGlob selects the reports by a glob of their prefix
+	ReportPrefixMatchGlob = "glob"
+	// ReportPrefixMatchRegex selects the reports by a regular expression of
+	// their prefix
+	ReportPrefixMatchRegex = "regex"
+)
+
+// reportMatch selects the reports named <prefix>-YYYY-MM-DD.json by a glob or
+// regular expression of their prefix, e.g. of the exports of several billing
+// accounts or legacy names in the same bucket
+type reportMatch struct {
+	// prefix is the literal start of the pattern, the reports are listed
+	// with
+	prefix string
+	expr   *regexp.Regexp
+}
+
+func newReportMatch(mode, pattern string) (*reportMatch, error) {
+	var expr, prefix string
+	switch mode {
+	case ReportPrefixMatchGlob:
+		var err error
+		if expr, err = globExpr(pattern); err != nil {
+			return nil, err
+		}
+		prefix = pattern
+		if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+			prefix = pattern[:i]
+		}
+	case ReportPrefixMatchRegex:
+		expr = pattern
+	default:
+		return nil, fmt.Errorf("unknown report prefix match '%s', expected one of %s, %s or %s", mode, ReportPrefixMatchPrefix, ReportPrefixMatchGlob, ReportPrefixMatchRegex)
+	}
+
+	re, err := regexp.Compile(`^(?:` + expr + `)-\d{4}-\d{2}-`)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing report prefix '%s': %s", pattern, err)
+	}
+	if mode == ReportPrefixMatchRegex {
+		// the literal prefix is only known for the unanchored expression
+		prefix, _ = regexp.MustCompile(`(?:` + expr + `)-`).LiteralPrefix()
+	}
+	return &reportMatch{prefix: prefix, expr: re}, nil
+}
+
+// globExpr translates a glob into a regular expression, * and ? match any
+// characters but / and [...] a character class
+func globExpr(pattern string) (string, error) {
+	var expr strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			expr.WriteString(`[^/]*`)
+		case '?':
+			expr.WriteString(`[^/]`)
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated character class in report prefix '%s'", pattern)
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + class + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return expr.String(), nil
+}
+
+// monthPrefix returns the start of the report name up to the day, e.g.
+// billing-2019-11- of billing-2019-11-01.json, it is empty if the name
+// doesn't match
+func (m *reportMatch) monthPrefix(name string) string {
+	return m.expr.FindString(name)
+}
+
+// month returns the month of the report, e.g. 2019-11, it is empty if the
+// name doesn't match
+func (m *reportMatch) month(name string) string {
+	prefix := m.monthPrefix(name)
+	if prefix == "" {
+		return ""
+	}
+	prefix = strings.TrimSuffix(prefix, "-")
+	return prefix[len(prefix)-7:]
+}
+
+// filter returns the objects of the reports of the month
+func (m *reportMatch) filter(objects []*storage.ObjectAttrs, month string) []*storage.ObjectAttrs {
+	var result []*storage.ObjectAttrs
+	for _, attrs := range objects {
+		if m.month(attrs.Name) == month {
+			result = append(result, attrs)
+		}
+	}
+	return result
+}
+
+// WithReportPrefixMatch selects how the report prefix matches the names of
+// the reports, one of ReportPrefixMatchPrefix, ReportPrefixMatchGlob or
+// ReportPrefixMatchRegex
+func (g *GCPBilling) WithReportPrefixMatch(mode string) (*GCPBilling, error) {
+	if mode == ReportPrefixMatchPrefix || mode == "" {
+		g.reportMatch = nil
+		return g, nil
+	}
+	m, err := newReportMatch(mode, g.ReportPrefix)
+	if err != nil {
+		return nil, err
+	}
+	g.reportMatch = m
+	return g, nil
+}
+
+// listPrefix returns the prefix the reports are listed with
+func (g *GCPBilling) listPrefix() string {
+	if g.reportMatch != nil {
+		return g.reportMatch.prefix
+	}
+	return g.ReportPrefix
+}
+
+// reportMonthPrefix returns the start of the report name up to the day
+func (g *GCPBilling) reportMonthPrefix(name string) string {
+	if g.reportMatch != nil {
+		return g.reportMatch.monthPrefix(name)
+	}
+	return g.ReportsMonthPrefix
+}
@@ -0,0 +1,121 @@
+package gcp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+	"github.com/simonswine/cloud-billing-exporter/fake"
+)
+
+func TestReportMatch(t *testing.T) {
+	for _, c := range []struct {
+		mode, pattern, prefix string
+		months                map[string]string
+	}{
+		{
+			mode:    ReportPrefixMatchGlob,
+			pattern: "billing-*",
+			prefix:  "billing-",
+			months: map[string]string{
+				"billing-0123AB-2019-11-01.json":         "2019-11",
+				"billing-0123AB-2019-11-02-part2.json":   "2019-11",
+				"billing-2019-11-01.json":                "",
+				"exports/billing-0123AB-2019-11-01.json": "",
+			},
+		},
+		{
+			mode:    ReportPrefixMatchGlob,
+			pattern: "exports/*/billing",
+			prefix:  "exports/",
+			months: map[string]string{
+				"exports/a/billing-2019-11-01.json":   "2019-11",
+				"exports/a/b/billing-2019-11-01.json": "",
+			},
+		},
+		{
+			mode:    ReportPrefixMatchRegex,
+			pattern: "(billing|legacy-billing)-[0-9A-F]{6}",
+			prefix:  "",
+			months: map[string]string{
+				"billing-0123AB-2019-11-01.json":        "2019-11",
+				"legacy-billing-0123AB-2019-10-31.json": "2019-10",
+				"billing-2019-11-01.json":               "",
+			},
+		},
+		{
+			mode:    ReportPrefixMatchRegex,
+			pattern: "billing-[0-9A-F]+",
+			prefix:  "billing-",
+			months: map[string]string{
+				"billing-0123AB-2019-11-01.json": "2019-11",
+			},
+		},
+	} {
+		m, err := newReportMatch(c.mode, c.pattern)
+		if err != nil {
+			t.Fatalf("unexpected error parsing %s %s: %s", c.mode, c.pattern, err)
+		}
+		if m.prefix != c.prefix {
+			t.Errorf("unexpected prefix of %s %s: '%s' (expected: '%s')", c.mode, c.pattern, m.prefix, c.prefix)
+		}
+		for name, exp := range c.months {
+			if act := m.month(name); act != exp {
+				t.Errorf("unexpected month of %s matching %s %s: '%s' (expected: '%s')", name, c.mode, c.pattern, act, exp)
+			}
+		}
+	}
+}
+
+func TestReportMatchErrors(t *testing.T) {
+	for mode, pattern := range map[string]string{
+		ReportPrefixMatchGlob:  "billing-[0-9",
+		ReportPrefixMatchRegex: "billing-(",
+		"wildcard":             "billing-*",
+	} {
+		if _, err := newReportMatch(mode, pattern); err == nil {
+			t.Errorf("expected error parsing %s %s", mode, pattern)
+		}
+	}
+}
+
+func TestQueryReportPrefixMatch(t *testing.T) {
+	element := func(project, costs string) string {
+		return `[{"projectId": "` + project + `", "measurements": [{"measurementId": "com.google.cloud/services/compute-engine/VmimageN1Standard_1", "sum": "3600", "unit": "seconds"}], "cost": {"amount": "` + costs + `", "currency": "USD"}}]`
+	}
+	reports := &fake.GCS{Objects: map[string]string{
+		"billing-0123AB-2019-10-31.json":        element("project-a", "16"),
+		"billing-0123AB-2019-11-01.json":        element("project-a", "1"),
+		"billing-4567CD-2019-11-01.json":        element("project-b", "2"),
+		"legacy-billing-0123AB-2019-11-02.json": element("project-a", "4"),
+		"other-0123AB-2019-11-02.json":          element("project-a", "8"),
+	}}
+	metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+	g, err := NewGCPBilling(metric, "bucket", "*billing-*", "", "", "").WithClients(Clients{
+		Reports:         reports,
+		ResourceManager: &fake.ResourceManager{},
+	}).WithReportPrefixMatch(ReportPrefixMatchGlob)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	g.clock = fakeClock{Time: time.Date(2019, 11, 3, 10, 0, 0, 0, time.UTC)}
+
+	if err := g.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	costs := make(map[string]float64)
+	for _, r := range g.Records() {
+		if r.Month != "2019-11" {
+			t.Errorf("unexpected month: %+v", r)
+		}
+		costs[r.Account] += r.Costs
+	}
+	if costs["project-a"] != 5 || costs["project-b"] != 2 {
+		t.Errorf("unexpected costs: %+v", costs)
+	}
+	if act := len(g.LineItems()); act != 3 {
+		t.Errorf("unexpected number of line items: %d", act)
+	}
+}
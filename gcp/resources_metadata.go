@@ -9,8 +9,8 @@ import (
 
 	"github.com/prometheus/common/log"
 	"golang.org/x/net/context"
-	crmv1 "google.golang.org/api/cloudresourcemanager/v1"
-	crmv2 "google.golang.org/api/cloudresourcemanager/v2"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
 )
 
 type resourceMetadata struct {
@@ -20,6 +20,8 @@ type resourceMetadata struct {
 	costCentre  string
 	projectType string
 	parent      string
+	// tags are the values of the project labels mapped to labels
+	tags map[string]string
 }
 
 type resourcesMetadata struct {
@@ -31,6 +33,38 @@ type resourcesMetadata struct {
 	lastUpdate          time.Time
 	updateLock          sync.Mutex
 	clock               Clock
+
+	// disabled skips the lookup, projects are only labeled by their ID
+	disabled bool
+
+	// disableOwner and disablePath leave the owner and path labels empty,
+	// the folders and organizations are not looked up without path
+	disableOwner bool
+	disablePath  bool
+	// tagKeys are the keys of the project labels mapped to labels
+	tagKeys []string
+
+	// client lists the resources, it is created on the first update if not
+	// set
+	client ResourceManager
+	// apiLatency observes the calls of the created client
+	apiLatency *billing.APILatency
+}
+
+// resourcesMetadataState is the cached metadata persisted in the state store
+type resourcesMetadataState struct {
+	Resources  []resourceMetadataState
+	LastUpdate time.Time
+}
+
+type resourceMetadataState struct {
+	ID          string
+	DisplayName string
+	Owner       string            `json:",omitempty"`
+	CostCentre  string            `json:",omitempty"`
+	ProjectType string            `json:",omitempty"`
+	Parent      string            `json:",omitempty"`
+	Tags        map[string]string `json:",omitempty"`
 }
 
 func newResourcesMetadata() *resourcesMetadata {
@@ -56,8 +90,56 @@ func (r *resourcesMetadata) ingest(e *resourceMetadata) {
 	}
 }
 
+func (r *resourcesMetadata) snapshot() *resourcesMetadataState {
+	r.updateLock.Lock()
+	defer r.updateLock.Unlock()
+
+	s := &resourcesMetadataState{LastUpdate: r.lastUpdate}
+	for _, m := range []map[string]*resourceMetadata{r.metadataByProjectID, r.metadataByID} {
+		for _, e := range m {
+			s.Resources = append(s.Resources, resourceMetadataState{
+				ID:          e.id,
+				DisplayName: e.displayName,
+				Owner:       e.owner,
+				CostCentre:  e.costCentre,
+				ProjectType: e.projectType,
+				Parent:      e.parent,
+				Tags:        e.tags,
+			})
+		}
+	}
+	return s
+}
+
+// restore ingests previously cached metadata, it is only renewed from the API
+// once the cache expires
+func (r *resourcesMetadata) restore(s *resourcesMetadataState) {
+	r.updateLock.Lock()
+	defer r.updateLock.Unlock()
+
+	for _, e := range s.Resources {
+		r.ingest(&resourceMetadata{
+			id:          e.ID,
+			displayName: e.DisplayName,
+			owner:       e.Owner,
+			costCentre:  e.CostCentre,
+			projectType: e.ProjectType,
+			parent:      e.Parent,
+			tags:        e.Tags,
+		})
+	}
+	r.lastUpdate = s.LastUpdate
+}
+
+// updated returns the time of the last successful update
+func (r *resourcesMetadata) updated() time.Time {
+	r.updateLock.Lock()
+	defer r.updateLock.Unlock()
+	return r.lastUpdate
+}
+
 func (r *resourcesMetadata) path(e *resourceMetadata) []string {
-	if e.parent != "" {
+	if e.parent != "" && !r.disablePath {
 		if parent, ok := r.metadataByID[e.parent]; ok {
 			return append(r.path(parent), parent.displayName)
 		}
@@ -69,10 +151,76 @@ func (r *resourcesMetadata) projectByID(id string) *resourceMetadata {
 	return r.metadataByProjectID[id]
 }
 
+// decodeLabels returns the owner, cost centre and type from the labels of a
+// project
+func (r *resourcesMetadata) decodeLabels(labels map[string]string, project string) (owner, costCentre, projectType string) {
+	if value, ok := labels[r.ownerLabel]; ok && !r.disableOwner {
+		value = strings.ToUpper(strings.ReplaceAll(value, "_", "="))
+		if valueDecoded, err := base32.StdEncoding.DecodeString(value); err != nil {
+			log.Warnf("error decoding label '%s=%s' of project '%s': %s", r.ownerLabel, value, project, err)
+		} else {
+			owner = string(valueDecoded)
+		}
+	}
+
+	if value, ok := labels[r.costCentreLabel]; ok {
+		value = strings.ToUpper(strings.ReplaceAll(value, "_", "="))
+		costCentre = strings.ToLower(value)
+	}
+
+	if value, ok := labels[r.projectTypeLabel]; ok {
+		value = strings.ReplaceAll(value, "_", "=")
+		projectType = string(value)
+	}
+	return owner, costCentre, projectType
+}
+
+// tags returns the values of the project labels mapped to labels
+func (r *resourcesMetadata) tags(labels map[string]string) map[string]string {
+	var tags map[string]string
+	for _, key := range r.tagKeys {
+		value, ok := labels[key]
+		if !ok {
+			continue
+		}
+		if tags == nil {
+			tags = make(map[string]string)
+		}
+		tags[key] = value
+	}
+	return tags
+}
+
+// projectTags returns the values of the mapped project labels by project ID
+func (r *resourcesMetadata) projectTags() map[string]map[string]string {
+	r.updateLock.Lock()
+	defer r.updateLock.Unlock()
+
+	tags := make(map[string]map[string]string)
+	for id, e := range r.metadataByProjectID {
+		if len(e.tags) > 0 {
+			tags[id] = e.tags
+		}
+	}
+	return tags
+}
+
+// resourceManager returns the client, which is created if not set
+func (r *resourcesMetadata) resourceManager(ctx context.Context) (ResourceManager, error) {
+	if r.client != nil {
+		return r.client, nil
+	}
+	return newAPIResourceManager(ctx, r.apiLatency)
+}
+
 func (r *resourcesMetadata) update(ctx context.Context) error {
 	r.updateLock.Lock()
 	defer r.updateLock.Unlock()
 
+	if r.disabled {
+		return nil
+	}
+
 	// cache information for one hour
 	if r.clock.Now().Before(r.lastUpdate.Add(time.Hour)) {
 		return nil
@@ -80,87 +228,62 @@ func (r *resourcesMetadata) update(ctx context.Context) error {
 
 	log.Debug("renew resource metadata from GCP resourcemanager")
 
-	crmv1Service, err := crmv1.NewService(ctx)
+	client, err := r.resourceManager(ctx)
 	if err != nil {
 		return err
 	}
 
-	crmv2Service, err := crmv2.NewService(ctx)
+	// list projects
+	projects, err := client.ListProjects(ctx)
 	if err != nil {
 		return err
 	}
+	for _, e := range projects {
+		owner, costCentre, projectType := r.decodeLabels(e.Labels, e.ProjectId)
 
-	// list projects
-	{
-		req := crmv1Service.Projects.List()
-		if err := req.Pages(ctx, func(page *crmv1.ListProjectsResponse) error {
-			for _, e := range page.Projects {
-				var owner, costCentre, projectType string
-				if value, ok := e.Labels[r.ownerLabel]; ok {
-					value = strings.ToUpper(strings.ReplaceAll(value, "_", "="))
-					if valueDecoded, err := base32.StdEncoding.DecodeString(value); err != nil {
-						log.Warnf("error decoding label '%s=%s' of project '%s': %s", r.ownerLabel, value, e.ProjectId, err)
-					} else {
-						owner = string(valueDecoded)
-					}
-				}
-
-				if value, ok := e.Labels[r.costCentreLabel]; ok {
-					value = strings.ToUpper(strings.ReplaceAll(value, "_", "="))
-					costCentre = strings.ToLower(value)
-				}
-
-				if value, ok := e.Labels[r.projectTypeLabel]; ok {
-					value = strings.ReplaceAll(value, "_", "=")
-					projectType = string(value)
-				}
-
-				r.ingest(&resourceMetadata{
-					id:          fmt.Sprintf("projects/%d", e.ProjectNumber),
-					displayName: e.ProjectId,
-					owner:       owner,
-					costCentre:  costCentre,
-					projectType: projectType,
-					parent:      fmt.Sprintf("%ss/%s", e.Parent.Type, e.Parent.Id),
-				})
-			}
-			return nil
-		}); err != nil {
-			return err
+		var parent string
+		if e.Parent != nil {
+			parent = fmt.Sprintf("%ss/%s", e.Parent.Type, e.Parent.Id)
 		}
+		r.ingest(&resourceMetadata{
+			id:          fmt.Sprintf("projects/%d", e.ProjectNumber),
+			displayName: e.ProjectId,
+			owner:       owner,
+			costCentre:  costCentre,
+			projectType: projectType,
+			parent:      parent,
+			tags:        r.tags(e.Labels),
+		})
+	}
+
+	if r.disablePath {
+		r.lastUpdate = r.clock.Now()
+		return nil
 	}
 
 	// list folders
-	{
-		req := crmv2Service.Folders.Search(&crmv2.SearchFoldersRequest{})
-		if err := req.Pages(ctx, func(page *crmv2.SearchFoldersResponse) error {
-			for _, e := range page.Folders {
-				r.ingest(&resourceMetadata{
-					id:          e.Name,
-					displayName: e.DisplayName,
-					parent:      e.Parent,
-				})
-			}
-			return nil
-		}); err != nil {
-			return err
-		}
+	folders, err := client.ListFolders(ctx)
+	if err != nil {
+		return err
+	}
+	for _, e := range folders {
+		r.ingest(&resourceMetadata{
+			id:          e.Name,
+			displayName: e.DisplayName,
+			parent:      e.Parent,
+		})
 	}
 
 	// list organizations
-	{
-		req := crmv1Service.Organizations.Search(&crmv1.SearchOrganizationsRequest{})
-		if err := req.Pages(ctx, func(page *crmv1.SearchOrganizationsResponse) error {
-			for _, e := range page.Organizations {
-				r.ingest(&resourceMetadata{
-					id:          e.Name,
-					displayName: e.DisplayName,
-				})
-			}
-			return nil
-		}); err != nil {
-			return err
-		}
+	organizations, err := client.ListOrganizations(ctx)
+	if err != nil {
+		return err
+	}
+	for _, e := range organizations {
+		r.ingest(&resourceMetadata{
+			id:          e.Name,
+			displayName: e.DisplayName,
+		})
 	}
 
 	r.lastUpdate = r.clock.Now()
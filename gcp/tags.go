@@ -0,0 +1,13 @@
+package gcp
+
+// WithTags looks up the values of the project labels with the given keys,
+// which are mapped to labels
+func (g *GCPBilling) WithTags(keys []string) *GCPBilling {
+	g.resourcesMetadata.tagKeys = keys
+	return g
+}
+
+// AccountTags returns the values of the mapped project labels by project ID
+func (g *GCPBilling) AccountTags() map[string]map[string]string {
+	return g.resourcesMetadata.projectTags()
+}
@@ -0,0 +1,40 @@
+package gcp
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	crmv1 "google.golang.org/api/cloudresourcemanager/v1"
+
+	"github.com/simonswine/cloud-billing-exporter/fake"
+)
+
+func TestProjectTags(t *testing.T) {
+	r := newResourcesMetadata()
+	r.tagKeys = []string{"team", "env", "cost-centre"}
+	r.disablePath = true
+	r.clock = fakeClock{Time: time.Date(2019, 11, 3, 10, 0, 0, 0, time.UTC)}
+	r.client = &fake.ResourceManager{
+		Projects: []*crmv1.Project{
+			{ProjectId: "project-a", ProjectNumber: 1234, Labels: map[string]string{"team": "platform", "cost-centre": "ops", "ignored": "x"}},
+			{ProjectId: "project-b", ProjectNumber: 1235},
+		},
+	}
+	if err := r.update(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	exp := map[string]map[string]string{"project-a": {"team": "platform", "cost-centre": "ops"}}
+	if act := r.projectTags(); !reflect.DeepEqual(act, exp) {
+		t.Errorf("unexpected project tags: %+v (expected: %+v)", act, exp)
+	}
+
+	// the tags are persisted with the metadata
+	restored := newResourcesMetadata()
+	restored.restore(r.snapshot())
+	if act := restored.projectTags(); !reflect.DeepEqual(act, exp) {
+		t.Errorf("unexpected restored project tags: %+v (expected: %+v)", act, exp)
+	}
+}
@@ -4,9 +4,20 @@ go 1.13
 
 require (
 	cloud.google.com/go/storage v1.3.0
+	github.com/DATA-DOG/go-sqlmock v1.4.1
+	github.com/DataDog/zstd v1.4.0
 	github.com/aws/aws-sdk-go v1.25.36
+	github.com/go-redis/redis v6.15.9+incompatible
+	github.com/golang/protobuf v1.3.2
+	github.com/lib/pq v1.1.1
+	github.com/mattn/go-sqlite3 v1.14.0
 	github.com/prometheus/client_golang v1.2.1
+	github.com/prometheus/client_model v0.0.0-20190812154241-14fe0d1b01d4
 	github.com/prometheus/common v0.7.0
-	golang.org/x/net v0.0.0-20191116160921-f9c825593386
+	github.com/segmentio/kafka-go v0.3.5
+	golang.org/x/net v0.0.0-20200324143707-d3edc9973b7e
+	golang.org/x/oauth2 v0.0.0-20190604053449-0f29369cfe45
 	google.golang.org/api v0.14.0
+	google.golang.org/grpc v1.21.1
+	gopkg.in/yaml.v2 v2.4.0
 )
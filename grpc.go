@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+	"github.com/simonswine/cloud-billing-exporter/grpcapi"
+)
+
+// grpcSource serves the billing data of the collector to the gRPC API
+type grpcSource struct {
+	b *BillingCollector
+}
+
+func (s *grpcSource) Records(ctx context.Context, month string) ([]billing.Record, error) {
+	return s.b.monthRecords(ctx, month)
+}
+
+// Accounts returns the account metadata from the local store if configured,
+// otherwise it is derived from the records of the current month
+func (s *grpcSource) Accounts(ctx context.Context) ([]billing.AccountMetadata, error) {
+	if s.b.store != nil {
+		return s.b.store.Accounts(ctx)
+	}
+
+	month := time.Now().Format(monthFormat)
+	accounts := make(map[[2]string]billing.AccountMetadata)
+	for _, r := range s.b.history.records(month) {
+		accounts[[2]string{r.Cloud, r.Account}] = billing.AccountMetadata{
+			Cloud:      r.Cloud,
+			Account:    r.Account,
+			Path:       r.Path,
+			Owner:      r.Owner,
+			CostCentre: r.CostCentre,
+			Type:       r.Type,
+			Month:      r.Month,
+		}
+	}
+
+	result := make([]billing.AccountMetadata, 0, len(accounts))
+	for _, a := range accounts {
+		result = append(result, a)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Cloud != result[j].Cloud {
+			return result[i].Cloud < result[j].Cloud
+		}
+		return result[i].Account < result[j].Account
+	})
+	return result, nil
+}
+
+// serveGRPC starts the gRPC API in the background
+func (b *BillingCollector) serveGRPC() error {
+	var opts []grpc.ServerOption
+	if *b.GRPCTLSCert != "" {
+		creds, err := credentials.NewServerTLSFromFile(*b.GRPCTLSCert, *b.GRPCTLSKey)
+		if err != nil {
+			return fmt.Errorf("error loading TLS certificate: %s", err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	if path := *b.GRPCBearerTokenFile; path != "" {
+		opts = append(opts, grpcapi.TokenAuth(func() (string, error) {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return "", err
+			}
+			return strings.TrimSpace(string(data)), nil
+		})...)
+	}
+
+	listeners, err := listen(*b.GRPCListenAddress)
+	if err != nil {
+		return err
+	}
+
+	s := grpc.NewServer(opts...)
+	grpcapi.RegisterBillingServer(s, grpcapi.NewServer(&grpcSource{b: b}))
+	for _, l := range listeners {
+		log.Infoln("Serving gRPC API on", l.Addr())
+		go func(l net.Listener) {
+			if err := s.Serve(l); err != nil {
+				log.Fatalf("error serving gRPC API: %s", err)
+			}
+		}(l)
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+package grpcapi
+
+// Messages and service descriptors of billing.proto. They follow the layout
+// of protoc-gen-go output, the messages are marshaled through their struct
+// tags.
+
+import (
+	"context"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+)
+
+type ListCostsRequest struct {
+	Month string `protobuf:"bytes,1,opt,name=month,proto3" json:"month,omitempty"`
+	Cloud string `protobuf:"bytes,2,opt,name=cloud,proto3" json:"cloud,omitempty"`
+}
+
+func (m *ListCostsRequest) Reset()         { *m = ListCostsRequest{} }
+func (m *ListCostsRequest) String() string { return proto.CompactTextString(m) }
+func (*ListCostsRequest) ProtoMessage()    {}
+
+type Cost struct {
+	Cloud      string  `protobuf:"bytes,1,opt,name=cloud,proto3" json:"cloud,omitempty"`
+	Month      string  `protobuf:"bytes,2,opt,name=month,proto3" json:"month,omitempty"`
+	Currency   string  `protobuf:"bytes,3,opt,name=currency,proto3" json:"currency,omitempty"`
+	Account    string  `protobuf:"bytes,4,opt,name=account,proto3" json:"account,omitempty"`
+	Service    string  `protobuf:"bytes,5,opt,name=service,proto3" json:"service,omitempty"`
+	Path       string  `protobuf:"bytes,6,opt,name=path,proto3" json:"path,omitempty"`
+	Owner      string  `protobuf:"bytes,7,opt,name=owner,proto3" json:"owner,omitempty"`
+	CostCentre string  `protobuf:"bytes,8,opt,name=cost_centre,json=costCentre,proto3" json:"cost_centre,omitempty"`
+	Type       string  `protobuf:"bytes,9,opt,name=type,proto3" json:"type,omitempty"`
+	Costs      float64 `protobuf:"fixed64,10,opt,name=costs,proto3" json:"costs,omitempty"`
+}
+
+func (m *Cost) Reset()         { *m = Cost{} }
+func (m *Cost) String() string { return proto.CompactTextString(m) }
+func (*Cost) ProtoMessage()    {}
+
+type ListAccountsRequest struct {
+	Cloud string `protobuf:"bytes,1,opt,name=cloud,proto3" json:"cloud,omitempty"`
+}
+
+func (m *ListAccountsRequest) Reset()         { *m = ListAccountsRequest{} }
+func (m *ListAccountsRequest) String() string { return proto.CompactTextString(m) }
+func (*ListAccountsRequest) ProtoMessage()    {}
+
+type Account struct {
+	Cloud      string `protobuf:"bytes,1,opt,name=cloud,proto3" json:"cloud,omitempty"`
+	Account    string `protobuf:"bytes,2,opt,name=account,proto3" json:"account,omitempty"`
+	Path       string `protobuf:"bytes,3,opt,name=path,proto3" json:"path,omitempty"`
+	Owner      string `protobuf:"bytes,4,opt,name=owner,proto3" json:"owner,omitempty"`
+	CostCentre string `protobuf:"bytes,5,opt,name=cost_centre,json=costCentre,proto3" json:"cost_centre,omitempty"`
+	Type       string `protobuf:"bytes,6,opt,name=type,proto3" json:"type,omitempty"`
+	Month      string `protobuf:"bytes,7,opt,name=month,proto3" json:"month,omitempty"`
+}
+
+func (m *Account) Reset()         { *m = Account{} }
+func (m *Account) String() string { return proto.CompactTextString(m) }
+func (*Account) ProtoMessage()    {}
+
+type GetSummaryRequest struct {
+	Month string `protobuf:"bytes,1,opt,name=month,proto3" json:"month,omitempty"`
+}
+
+func (m *GetSummaryRequest) Reset()         { *m = GetSummaryRequest{} }
+func (m *GetSummaryRequest) String() string { return proto.CompactTextString(m) }
+func (*GetSummaryRequest) ProtoMessage()    {}
+
+type Summary struct {
+	Month  string           `protobuf:"bytes,1,opt,name=month,proto3" json:"month,omitempty"`
+	Totals []*Summary_Total `protobuf:"bytes,2,rep,name=totals,proto3" json:"totals,omitempty"`
+}
+
+func (m *Summary) Reset()         { *m = Summary{} }
+func (m *Summary) String() string { return proto.CompactTextString(m) }
+func (*Summary) ProtoMessage()    {}
+
+type Summary_Total struct {
+	Cloud    string  `protobuf:"bytes,1,opt,name=cloud,proto3" json:"cloud,omitempty"`
+	Currency string  `protobuf:"bytes,2,opt,name=currency,proto3" json:"currency,omitempty"`
+	Costs    float64 `protobuf:"fixed64,3,opt,name=costs,proto3" json:"costs,omitempty"`
+	Accounts int64   `protobuf:"varint,4,opt,name=accounts,proto3" json:"accounts,omitempty"`
+}
+
+func (m *Summary_Total) Reset()         { *m = Summary_Total{} }
+func (m *Summary_Total) String() string { return proto.CompactTextString(m) }
+func (*Summary_Total) ProtoMessage()    {}
+
+// BillingClient is the client API for the Billing service.
+type BillingClient interface {
+	ListCosts(ctx context.Context, in *ListCostsRequest, opts ...grpc.CallOption) (Billing_ListCostsClient, error)
+	ListAccounts(ctx context.Context, in *ListAccountsRequest, opts ...grpc.CallOption) (Billing_ListAccountsClient, error)
+	GetSummary(ctx context.Context, in *GetSummaryRequest, opts ...grpc.CallOption) (*Summary, error)
+}
+
+type billingClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewBillingClient(cc *grpc.ClientConn) BillingClient {
+	return &billingClient{cc}
+}
+
+func (c *billingClient) ListCosts(ctx context.Context, in *ListCostsRequest, opts ...grpc.CallOption) (Billing_ListCostsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Billing_serviceDesc.Streams[0], "/cloudbilling.v1.Billing/ListCosts", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &billingListCostsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Billing_ListCostsClient interface {
+	Recv() (*Cost, error)
+	grpc.ClientStream
+}
+
+type billingListCostsClient struct {
+	grpc.ClientStream
+}
+
+func (x *billingListCostsClient) Recv() (*Cost, error) {
+	m := new(Cost)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *billingClient) ListAccounts(ctx context.Context, in *ListAccountsRequest, opts ...grpc.CallOption) (Billing_ListAccountsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Billing_serviceDesc.Streams[1], "/cloudbilling.v1.Billing/ListAccounts", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &billingListAccountsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Billing_ListAccountsClient interface {
+	Recv() (*Account, error)
+	grpc.ClientStream
+}
+
+type billingListAccountsClient struct {
+	grpc.ClientStream
+}
+
+func (x *billingListAccountsClient) Recv() (*Account, error) {
+	m := new(Account)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *billingClient) GetSummary(ctx context.Context, in *GetSummaryRequest, opts ...grpc.CallOption) (*Summary, error) {
+	out := new(Summary)
+	err := c.cc.Invoke(ctx, "/cloudbilling.v1.Billing/GetSummary", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// BillingServer is the server API for the Billing service.
+type BillingServer interface {
+	ListCosts(*ListCostsRequest, Billing_ListCostsServer) error
+	ListAccounts(*ListAccountsRequest, Billing_ListAccountsServer) error
+	GetSummary(context.Context, *GetSummaryRequest) (*Summary, error)
+}
+
+func RegisterBillingServer(s *grpc.Server, srv BillingServer) {
+	s.RegisterService(&_Billing_serviceDesc, srv)
+}
+
+func _Billing_ListCosts_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListCostsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BillingServer).ListCosts(m, &billingListCostsServer{stream})
+}
+
+type Billing_ListCostsServer interface {
+	Send(*Cost) error
+	grpc.ServerStream
+}
+
+type billingListCostsServer struct {
+	grpc.ServerStream
+}
+
+func (x *billingListCostsServer) Send(m *Cost) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Billing_ListAccounts_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListAccountsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BillingServer).ListAccounts(m, &billingListAccountsServer{stream})
+}
+
+type Billing_ListAccountsServer interface {
+	Send(*Account) error
+	grpc.ServerStream
+}
+
+type billingListAccountsServer struct {
+	grpc.ServerStream
+}
+
+func (x *billingListAccountsServer) Send(m *Account) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Billing_GetSummary_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetSummaryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BillingServer).GetSummary(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cloudbilling.v1.Billing/GetSummary",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BillingServer).GetSummary(ctx, req.(*GetSummaryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Billing_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "cloudbilling.v1.Billing",
+	HandlerType: (*BillingServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetSummary",
+			Handler:    _Billing_GetSummary_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ListCosts",
+			Handler:       _Billing_ListCosts_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ListAccounts",
+			Handler:       _Billing_ListAccounts_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "billing.proto",
+}
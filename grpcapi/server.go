@@ -0,0 +1,185 @@
+// Package grpcapi implements a gRPC service serving the billing data parsed
+// by the exporter, see billing.proto for the service definition.
+package grpcapi
+
+import (
+	"context"
+	"crypto/subtle"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+const monthFormat = "2006-01"
+
+// Source provides the billing data served
+type Source interface {
+	Records(ctx context.Context, month string) ([]billing.Record, error)
+	Accounts(ctx context.Context) ([]billing.AccountMetadata, error)
+}
+
+// Server implements BillingServer on top of a Source
+type Server struct {
+	source Source
+	now    func() time.Time
+}
+
+func NewServer(source Source) *Server {
+	return &Server{
+		source: source,
+		now:    time.Now,
+	}
+}
+
+// month validates the requested month, it defaults to the current month
+func (s *Server) month(month string) (string, error) {
+	if month == "" {
+		return s.now().Format(monthFormat), nil
+	}
+	if _, err := time.Parse(monthFormat, month); err != nil {
+		return "", status.Errorf(codes.InvalidArgument, "invalid month '%s', expected format YYYY-MM", month)
+	}
+	return month, nil
+}
+
+func (s *Server) ListCosts(req *ListCostsRequest, stream Billing_ListCostsServer) error {
+	month, err := s.month(req.Month)
+	if err != nil {
+		return err
+	}
+
+	records, err := s.source.Records(stream.Context(), month)
+	if err != nil {
+		return status.Errorf(codes.Internal, "error querying records: %s", err)
+	}
+
+	for _, r := range records {
+		if req.Cloud != "" && r.Cloud != req.Cloud {
+			continue
+		}
+		if err := stream.Send(&Cost{
+			Cloud:      r.Cloud,
+			Month:      r.Month,
+			Currency:   r.Currency,
+			Account:    r.Account,
+			Service:    r.Service,
+			Path:       r.Path,
+			Owner:      r.Owner,
+			CostCentre: r.CostCentre,
+			Type:       r.Type,
+			Costs:      r.Costs,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) ListAccounts(req *ListAccountsRequest, stream Billing_ListAccountsServer) error {
+	accounts, err := s.source.Accounts(stream.Context())
+	if err != nil {
+		return status.Errorf(codes.Internal, "error querying accounts: %s", err)
+	}
+
+	for _, a := range accounts {
+		if req.Cloud != "" && a.Cloud != req.Cloud {
+			continue
+		}
+		if err := stream.Send(&Account{
+			Cloud:      a.Cloud,
+			Account:    a.Account,
+			Path:       a.Path,
+			Owner:      a.Owner,
+			CostCentre: a.CostCentre,
+			Type:       a.Type,
+			Month:      a.Month,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) GetSummary(ctx context.Context, req *GetSummaryRequest) (*Summary, error) {
+	month, err := s.month(req.Month)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := s.source.Records(ctx, month)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error querying records: %s", err)
+	}
+
+	type key struct {
+		cloud    string
+		currency string
+	}
+	totals := make(map[key]*Summary_Total)
+	accounts := make(map[key]map[string]bool)
+	for _, r := range records {
+		k := key{r.Cloud, r.Currency}
+		if _, ok := totals[k]; !ok {
+			totals[k] = &Summary_Total{Cloud: r.Cloud, Currency: r.Currency}
+			accounts[k] = make(map[string]bool)
+		}
+		totals[k].Costs += r.Costs
+		accounts[k][r.Account] = true
+	}
+
+	summary := &Summary{Month: month}
+	for k, t := range totals {
+		t.Accounts = int64(len(accounts[k]))
+		summary.Totals = append(summary.Totals, t)
+	}
+	sort.Slice(summary.Totals, func(i, j int) bool {
+		if summary.Totals[i].Cloud != summary.Totals[j].Cloud {
+			return summary.Totals[i].Cloud < summary.Totals[j].Cloud
+		}
+		return summary.Totals[i].Currency < summary.Totals[j].Currency
+	})
+	return summary, nil
+}
+
+// TokenAuth returns server options, which require the bearer token returned
+// by token in the authorization metadata of all calls
+func TokenAuth(token func() (string, error)) []grpc.ServerOption {
+	authorize := func(ctx context.Context) error {
+		expected, err := token()
+		if err != nil {
+			return status.Errorf(codes.Internal, "error reading bearer token: %s", err)
+		}
+		md, _ := metadata.FromIncomingContext(ctx)
+		for _, value := range md.Get("authorization") {
+			if !strings.HasPrefix(value, "Bearer ") {
+				continue
+			}
+			if subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(value, "Bearer ")), []byte(expected)) == 1 {
+				return nil
+			}
+		}
+		return status.Error(codes.Unauthenticated, "missing or invalid bearer token")
+	}
+
+	return []grpc.ServerOption{
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := authorize(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := authorize(stream.Context()); err != nil {
+				return err
+			}
+			return handler(srv, stream)
+		}),
+	}
+}
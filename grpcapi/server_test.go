@@ -0,0 +1,141 @@
+package grpcapi
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+type fakeSource map[string][]billing.Record
+
+func (f fakeSource) Records(_ context.Context, month string) ([]billing.Record, error) {
+	return f[month], nil
+}
+
+func (f fakeSource) Accounts(_ context.Context) ([]billing.AccountMetadata, error) {
+	return []billing.AccountMetadata{
+		{Cloud: "aws", Account: "a", Owner: "jane", Month: "2019-11"},
+		{Cloud: "gcp", Account: "b", Month: "2019-10"},
+	}, nil
+}
+
+func newTestClient(t *testing.T, token string) (BillingClient, func()) {
+	listener := bufconn.Listen(1 << 20)
+	srv := NewServer(fakeSource{
+		"2019-11": {
+			{Cloud: "aws", Month: "2019-11", Currency: "USD", Account: "a", Service: "AmazonEC2", Costs: 10},
+			{Cloud: "aws", Month: "2019-11", Currency: "USD", Account: "a", Service: "AmazonS3", Costs: 2.5},
+			{Cloud: "gcp", Month: "2019-11", Currency: "EUR", Account: "b", Service: "compute", Costs: 1},
+		},
+	})
+	srv.now = func() time.Time {
+		return time.Date(2019, 11, 20, 10, 0, 0, 0, time.UTC)
+	}
+	s := grpc.NewServer(TokenAuth(func() (string, error) {
+		return token, nil
+	})...)
+	RegisterBillingServer(s, srv)
+	go func() {
+		_ = s.Serve(listener)
+	}()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithDialer(func(string, time.Duration) (net.Conn, error) {
+			return listener.Dial()
+		}),
+		grpc.WithInsecure(),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return NewBillingClient(conn), func() {
+		conn.Close()
+		s.Stop()
+	}
+}
+
+func TestServer(t *testing.T) {
+	client, stop := newTestClient(t, "secret")
+	defer stop()
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer secret")
+
+	stream, err := client.ListCosts(ctx, &ListCostsRequest{Cloud: "aws"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var costs []*Cost
+	for {
+		c, err := stream.Recv()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		costs = append(costs, c)
+	}
+	if len(costs) != 2 || costs[0].Service != "AmazonEC2" || costs[1].Costs != 2.5 {
+		t.Errorf("unexpected costs: %+v", costs)
+	}
+
+	accounts, err := client.ListAccounts(ctx, &ListAccountsRequest{Cloud: "aws"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	a, err := accounts.Recv()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if a.Account != "a" || a.Owner != "jane" {
+		t.Errorf("unexpected account: %+v", a)
+	}
+	if _, err := accounts.Recv(); err != io.EOF {
+		t.Errorf("expected end of stream, got: %v", err)
+	}
+
+	summary, err := client.GetSummary(ctx, &GetSummaryRequest{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if summary.Month != "2019-11" || len(summary.Totals) != 2 {
+		t.Fatalf("unexpected summary: %+v", summary)
+	}
+	if act := summary.Totals[0]; act.Cloud != "aws" || act.Costs != 12.5 || act.Accounts != 1 {
+		t.Errorf("unexpected aws total: %+v", act)
+	}
+
+	if _, err := client.GetSummary(ctx, &GetSummaryRequest{Month: "november"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected invalid argument error, got: %v", err)
+	}
+}
+
+func TestServerTokenAuth(t *testing.T) {
+	client, stop := newTestClient(t, "secret")
+	defer stop()
+
+	for _, ctx := range []context.Context{
+		context.Background(),
+		metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer wrong"),
+	} {
+		if _, err := client.GetSummary(ctx, &GetSummaryRequest{}); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("expected unauthenticated error, got: %v", err)
+		}
+
+		stream, err := client.ListCosts(ctx, &ListCostsRequest{})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if _, err := stream.Recv(); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("expected unauthenticated error, got: %v", err)
+		}
+	}
+}
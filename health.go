@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// collectorMinBackoff is the time waited after the first failed query
+	// of a collector, it doubles with every further failure
+	collectorMinBackoff = 30 * time.Second
+	collectorMaxBackoff = 30 * time.Minute
+)
+
+// collectorStatus contains the result of the last query of a collector
+type collectorStatus struct {
+	up          bool
+	failures    int
+	nextAttempt time.Time
+
+	// since is when the collector was first queried, lastSuccess when its
+	// last query succeeded
+	since       time.Time
+	lastSuccess time.Time
+
+	// duration is how long the last query took
+	duration time.Duration
+	// queried is set once a query of the costs succeeded
+	queried bool
+}
+
+// lastReportCollector is implemented by collectors knowing when the newest
+// report found by their last query was modified
+type lastReportCollector interface {
+	LastReport() time.Time
+}
+
+// collectorHealth tracks whether the queries of the collectors succeed.
+// Failing collectors are retried with an exponential backoff, so a collector
+// failing at startup, e.g. as permissions are not propagated yet, recovers
+// without a restart.
+type collectorHealth struct {
+	desc            *prometheus.Desc
+	lastSuccessDesc *prometheus.Desc
+	successDesc     *prometheus.Desc
+	durationDesc    *prometheus.Desc
+	lastReportDesc  *prometheus.Desc
+	now             func() time.Time
+
+	// status is kept per collector instance, as the names of the collectors
+	// may change, e.g. once the AWS root account is looked up
+	lock   sync.Mutex
+	status map[cloudBillingCollector]*collectorStatus
+}
+
+func newCollectorHealth() *collectorHealth {
+	return &collectorHealth{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "billing", "collector_up"),
+			"Whether the last query of the collector succeeded.",
+			[]string{"cloud", "collector"},
+			nil,
+		),
+		lastSuccessDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "billing", "collector_last_success_timestamp_seconds"),
+			"Time of the last successful query of the collector.",
+			[]string{"cloud", "collector"},
+			nil,
+		),
+		successDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "billing", "scrape_success"),
+			"Whether the last query of the billing source succeeded.",
+			[]string{"cloud", "source"},
+			nil,
+		),
+		durationDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "billing", "scrape_duration_seconds"),
+			"Duration of the last query of the billing source.",
+			[]string{"cloud", "source"},
+			nil,
+		),
+		lastReportDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "billing", "last_report_timestamp_seconds"),
+			"Modification time of the newest report found by the last query of the billing source.",
+			[]string{"cloud", "source"},
+			nil,
+		),
+		now:    time.Now,
+		status: make(map[cloudBillingCollector]*collectorStatus),
+	}
+}
+
+func (h *collectorHealth) get(c cloudBillingCollector) *collectorStatus {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	s, ok := h.status[c]
+	if !ok {
+		s = &collectorStatus{since: h.now()}
+		h.status[c] = s
+	}
+	return s
+}
+
+// run calls fn, which queries the collector, unless the collector is backing
+// off after failed queries
+func (h *collectorHealth) run(c cloudBillingCollector, fn func() error) error {
+	s := h.get(c)
+
+	h.lock.Lock()
+	now := h.now()
+	if now.Before(s.nextAttempt) {
+		h.lock.Unlock()
+		return fmt.Errorf("backing off after %d failed queries until %s", s.failures, s.nextAttempt.Format(time.RFC3339))
+	}
+	h.lock.Unlock()
+
+	err := fn()
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	s.duration = h.now().Sub(now)
+	if err == nil {
+		s.up = true
+		s.failures = 0
+		s.nextAttempt = time.Time{}
+		s.lastSuccess = h.now()
+		return nil
+	}
+	s.up = false
+	backoff := collectorMinBackoff << uint(s.failures)
+	if backoff > collectorMaxBackoff || backoff <= 0 {
+		backoff = collectorMaxBackoff
+	}
+	s.failures++
+	s.nextAttempt = h.now().Add(backoff)
+	return err
+}
+
+// runQuery queries the costs of the collector, unless it is backing off
+func (h *collectorHealth) runQuery(c cloudBillingCollector) error {
+	if err := h.run(c, c.Query); err != nil {
+		return err
+	}
+	s := h.get(c)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	s.queried = true
+	return nil
+}
+
+func (h *collectorHealth) Describe(ch chan<- *prometheus.Desc) {
+	ch <- h.desc
+	ch <- h.lastSuccessDesc
+	ch <- h.successDesc
+	ch <- h.durationDesc
+	ch <- h.lastReportDesc
+}
+
+func (h *collectorHealth) collect(collectors []cloudBillingCollector, ch chan<- prometheus.Metric) {
+	for _, c := range collectors {
+		s := h.get(c)
+		h.lock.Lock()
+		value := 0.0
+		if s.up {
+			value = 1
+		}
+		lastSuccess := s.lastSuccess
+		duration := s.duration
+		h.lock.Unlock()
+		cloud, name := c.Cloud(), c.String()
+		ch <- prometheus.MustNewConstMetric(h.desc, prometheus.GaugeValue, value, cloud, name)
+		ch <- prometheus.MustNewConstMetric(h.successDesc, prometheus.GaugeValue, value, cloud, name)
+		ch <- prometheus.MustNewConstMetric(h.durationDesc, prometheus.GaugeValue, duration.Seconds(), cloud, name)
+		if !lastSuccess.IsZero() {
+			ch <- prometheus.MustNewConstMetric(h.lastSuccessDesc, prometheus.GaugeValue, float64(lastSuccess.UnixNano())/1e9, cloud, name)
+		}
+		if r, ok := c.(lastReportCollector); ok {
+			if lastReport := r.LastReport(); !lastReport.IsZero() {
+				ch <- prometheus.MustNewConstMetric(h.lastReportDesc, prometheus.GaugeValue, float64(lastReport.UnixNano())/1e9, cloud, name)
+			}
+		}
+	}
+}
+
+// stale returns the collectors without a successful query within
+// maxStaleness, collectors which never succeeded are stale maxStaleness after
+// their first query
+func (h *collectorHealth) stale(collectors []cloudBillingCollector, maxStaleness time.Duration) []string {
+	var stale []string
+	for _, c := range collectors {
+		s := h.get(c)
+		h.lock.Lock()
+		last := s.lastSuccess
+		if last.IsZero() {
+			last = s.since
+		}
+		if h.now().Sub(last) > maxStaleness {
+			stale = append(stale, c.String())
+		}
+		h.lock.Unlock()
+	}
+	sort.Strings(stale)
+	return stale
+}
+
+// unready returns the collectors without a successful query of their costs
+func (h *collectorHealth) unready(collectors []cloudBillingCollector) []string {
+	var unready []string
+	for _, c := range collectors {
+		s := h.get(c)
+		h.lock.Lock()
+		if !s.queried {
+			unready = append(unready, c.String())
+		}
+		h.lock.Unlock()
+	}
+	sort.Strings(unready)
+	return unready
+}
+
+// readyzHandler responds with 503 until each collector completed a
+// successful query of its costs
+func (h *collectorHealth) readyzHandler(collectors []cloudBillingCollector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if unready := h.unready(collectors); len(unready) > 0 {
+			http.Error(w, fmt.Sprintf("no successful query yet by collectors: %s", strings.Join(unready, ", ")), http.StatusServiceUnavailable)
+			return
+		}
+		healthzHandler(w, r)
+	})
+}
+
+// healthzHandler responds with 503 if none of the collectors had a successful
+// query within maxStaleness, so a wedged exporter gets restarted
+func (h *collectorHealth) healthzHandler(collectors []cloudBillingCollector, maxStaleness time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if stale := h.stale(collectors, maxStaleness); len(collectors) > 0 && len(stale) == len(collectors) {
+			http.Error(w, fmt.Sprintf("no successful query within %s by any collector", maxStaleness), http.StatusServiceUnavailable)
+			return
+		}
+		healthzHandler(w, r)
+	})
+}
+
+// bufferedResponseWriter holds back the response, until it is known whether
+// it is served
+type bufferedResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferedResponseWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+func (w *bufferedResponseWriter) Write(p []byte) (int, error) {
+	return w.body.Write(p)
+}
+
+// staleHandler responds with 503 if a collector had no successful query
+// within maxStaleness, so consumers don't keep trusting outdated costs. The
+// metrics are gathered first, as the collectors are queried by the scrape.
+func (h *collectorHealth) staleHandler(collectors []cloudBillingCollector, maxStaleness time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := &bufferedResponseWriter{header: make(http.Header), status: http.StatusOK}
+		next.ServeHTTP(buf, r)
+
+		if stale := h.stale(collectors, maxStaleness); len(stale) > 0 {
+			http.Error(w, fmt.Sprintf("no successful query within %s by collectors: %s", maxStaleness, strings.Join(stale, ", ")), http.StatusServiceUnavailable)
+			return
+		}
+
+		for k, v := range buf.header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(buf.status)
+		_, _ = w.Write(buf.body.Bytes())
+	})
+}
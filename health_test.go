@@ -0,0 +1,253 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type healthTestCollector struct {
+	*collectorHealth
+	collectors []cloudBillingCollector
+}
+
+func (c healthTestCollector) Collect(ch chan<- prometheus.Metric) {
+	c.collect(c.collectors, ch)
+}
+
+func TestCollectorHealth(t *testing.T) {
+	aws := &fakeCollector{cloud: "aws"}
+	gcp := &fakeCollector{cloud: "gcp"}
+	now := time.Date(2019, 11, 3, 10, 0, 0, 0, time.UTC)
+	c := healthTestCollector{
+		collectorHealth: newCollectorHealth(),
+		collectors:      []cloudBillingCollector{aws, gcp},
+	}
+	c.now = func() time.Time { return now }
+
+	calls := 0
+	failing := func() error {
+		calls++
+		return errors.New("AccessDenied")
+	}
+	if err := c.run(aws, aws.Test); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := c.run(gcp, failing); err == nil {
+		t.Fatal("expected error")
+	}
+
+	exp := `
+# HELP cloud_billing_collector_up Whether the last query of the collector succeeded.
+# TYPE cloud_billing_collector_up gauge
+cloud_billing_collector_up{cloud="aws",collector="fake aws"} 1
+cloud_billing_collector_up{cloud="gcp",collector="fake gcp"} 0
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(exp), "cloud_billing_collector_up"); err != nil {
+		t.Errorf("unexpected metrics: %s", err)
+	}
+
+	// not retried during the backoff, which doubles after each failure
+	for _, d := range []time.Duration{collectorMinBackoff, 2 * collectorMinBackoff} {
+		if err := c.run(gcp, failing); err == nil {
+			t.Fatal("expected error")
+		}
+		now = now.Add(d)
+		if err := c.run(gcp, failing); err == nil {
+			t.Fatal("expected error")
+		}
+	}
+	if act, exp := calls, 3; act != exp {
+		t.Errorf("unexpected number of queries: %d (expected: %d)", act, exp)
+	}
+
+	// recovers after the backoff
+	now = now.Add(4 * collectorMinBackoff)
+	if err := c.run(gcp, gcp.Query); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := testutil.CollectAndCompare(c, strings.NewReader(strings.Replace(exp, `collector="fake gcp"} 0`, `collector="fake gcp"} 1`, 1)), "cloud_billing_collector_up"); err != nil {
+		t.Errorf("unexpected metrics: %s", err)
+	}
+}
+
+func TestCollectorHealthStaleness(t *testing.T) {
+	aws := &fakeCollector{cloud: "aws"}
+	gcp := &fakeCollector{cloud: "gcp"}
+	now := time.Date(2019, 11, 3, 10, 0, 0, 0, time.UTC)
+	c := healthTestCollector{
+		collectorHealth: newCollectorHealth(),
+		collectors:      []cloudBillingCollector{aws, gcp},
+	}
+	c.now = func() time.Time { return now }
+
+	failing := func() error { return errors.New("AccessDenied") }
+	if err := c.run(aws, aws.Query); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := c.run(gcp, failing); err == nil {
+		t.Fatal("expected error")
+	}
+
+	exp := `
+# HELP cloud_billing_collector_last_success_timestamp_seconds Time of the last successful query of the collector.
+# TYPE cloud_billing_collector_last_success_timestamp_seconds gauge
+cloud_billing_collector_last_success_timestamp_seconds{cloud="aws",collector="fake aws"} 1.5727752e+09
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(exp), "cloud_billing_collector_last_success_timestamp_seconds"); err != nil {
+		t.Errorf("unexpected metrics: %s", err)
+	}
+
+	handler := c.staleHandler(c.collectors, time.Hour, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("metrics"))
+	}))
+	serve := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		return rec
+	}
+
+	// collectors failing since startup are stale after maxStaleness
+	if rec := serve(); rec.Code != http.StatusOK || rec.Body.String() != "metrics" || rec.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("unexpected response: %d %s", rec.Code, rec.Body)
+	}
+	now = now.Add(2 * time.Hour)
+	rec := serve()
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("unexpected status: %d", rec.Code)
+	}
+	if act, exp := rec.Body.String(), "no successful query within 1h0m0s by collectors: fake aws, fake gcp\n"; act != exp {
+		t.Errorf("unexpected body: %q (expected: %q)", act, exp)
+	}
+	if stale := c.stale(c.collectors, 3*time.Hour); len(stale) != 0 {
+		t.Errorf("unexpected stale collectors: %v", stale)
+	}
+}
+
+type lastReportTestCollector struct {
+	*fakeCollector
+	lastReport time.Time
+}
+
+func (c *lastReportTestCollector) LastReport() time.Time {
+	return c.lastReport
+}
+
+func TestCollectorHealthScrapeMetrics(t *testing.T) {
+	now := time.Date(2019, 11, 3, 10, 0, 0, 0, time.UTC)
+	aws := &lastReportTestCollector{fakeCollector: &fakeCollector{cloud: "aws"}, lastReport: now.Add(-2 * time.Hour)}
+	gcp := &lastReportTestCollector{fakeCollector: &fakeCollector{cloud: "gcp"}}
+	c := healthTestCollector{
+		collectorHealth: newCollectorHealth(),
+		collectors:      []cloudBillingCollector{aws, gcp},
+	}
+	c.now = func() time.Time { return now }
+
+	if err := c.run(aws, func() error {
+		now = now.Add(90 * time.Second)
+		return nil
+	}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := c.run(gcp, func() error {
+		now = now.Add(time.Second)
+		return errors.New("AccessDenied")
+	}); err == nil {
+		t.Fatal("expected error")
+	}
+
+	exp := `
+# HELP cloud_billing_last_report_timestamp_seconds Modification time of the newest report found by the last query of the billing source.
+# TYPE cloud_billing_last_report_timestamp_seconds gauge
+cloud_billing_last_report_timestamp_seconds{cloud="aws",source="fake aws"} 1.5727680e+09
+# HELP cloud_billing_scrape_duration_seconds Duration of the last query of the billing source.
+# TYPE cloud_billing_scrape_duration_seconds gauge
+cloud_billing_scrape_duration_seconds{cloud="aws",source="fake aws"} 90
+cloud_billing_scrape_duration_seconds{cloud="gcp",source="fake gcp"} 1
+# HELP cloud_billing_scrape_success Whether the last query of the billing source succeeded.
+# TYPE cloud_billing_scrape_success gauge
+cloud_billing_scrape_success{cloud="aws",source="fake aws"} 1
+cloud_billing_scrape_success{cloud="gcp",source="fake gcp"} 0
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(exp), "cloud_billing_scrape_success", "cloud_billing_scrape_duration_seconds", "cloud_billing_last_report_timestamp_seconds"); err != nil {
+		t.Errorf("unexpected metrics: %s", err)
+	}
+}
+
+func TestCollectorHealthProbes(t *testing.T) {
+	aws := &fakeCollector{cloud: "aws"}
+	gcp := &fakeCollector{cloud: "gcp"}
+	now := time.Date(2019, 11, 3, 10, 0, 0, 0, time.UTC)
+	h := newCollectorHealth()
+	h.now = func() time.Time { return now }
+	collectors := []cloudBillingCollector{aws, gcp}
+
+	readyz := h.readyzHandler(collectors)
+	healthz := h.healthzHandler(collectors, 3*time.Hour)
+	serve := func(handler http.Handler) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec.Code
+	}
+
+	// a successful test is no query of the costs
+	if err := h.run(gcp, gcp.Test); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := h.runQuery(aws); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if code := serve(readyz); code != http.StatusServiceUnavailable {
+		t.Errorf("unexpected readiness before all collectors queried: %d", code)
+	}
+	if err := h.runQuery(gcp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if code := serve(readyz); code != http.StatusOK {
+		t.Errorf("unexpected readiness: %d", code)
+	}
+
+	// live as long as any collector succeeds
+	now = now.Add(2 * time.Hour)
+	if err := h.run(gcp, func() error { return errors.New("AccessDenied") }); err == nil {
+		t.Fatal("expected error")
+	}
+	if err := h.runQuery(aws); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	now = now.Add(2 * time.Hour)
+	if code := serve(healthz); code != http.StatusOK {
+		t.Errorf("unexpected liveness: %d", code)
+	}
+	now = now.Add(2 * time.Hour)
+	if code := serve(healthz); code != http.StatusServiceUnavailable {
+		t.Errorf("unexpected liveness without successful queries: %d", code)
+	}
+}
+
+func TestCollectorHealthRenamed(t *testing.T) {
+	c := &fakeCollector{cloud: "aws"}
+	h := newCollectorHealth()
+	if err := h.runQuery(c); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := h.run(c, func() error { return errors.New("AccessDenied") }); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	// the status is kept, if the name of the collector changes
+	c.cloud = "aws-renamed"
+	if unready := h.unready([]cloudBillingCollector{c}); len(unready) != 0 {
+		t.Errorf("unexpected unready collectors: %v", unready)
+	}
+	if err := h.run(c, c.Query); err == nil || !strings.Contains(err.Error(), "backing off") {
+		t.Errorf("expected the collector to back off, got: %v", err)
+	}
+}
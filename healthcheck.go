@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// healthzPath is the path of the liveness endpoint
+	healthzPath = "/healthz"
+	// readyzPath is the path of the readiness endpoint
+	readyzPath = "/readyz"
+	// healthcheckTimeout limits the time of the request of the healthcheck
+	// command
+	healthcheckTimeout = 5 * time.Second
+)
+
+// healthzHandler responds with 200 as long as the exporter serves requests
+func healthzHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = io.WriteString(w, "ok\n")
+}
+
+// healthcheckURL returns the URL of the liveness endpoint on the first of the
+// comma separated listen addresses, wildcard addresses are reached on the
+// loopback address
+func healthcheckURL(addresses string) (string, error) {
+	address := strings.TrimSpace(strings.Split(addresses, ",")[0])
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", fmt.Errorf("invalid listen address '%s': %s", address, err)
+	}
+	switch host {
+	case "", "0.0.0.0":
+		host = "127.0.0.1"
+	case "::":
+		host = "::1"
+	}
+	return "http://" + net.JoinHostPort(host, port) + healthzPath, nil
+}
+
+// healthcheck requests the liveness endpoint, it fails unless the exporter
+// responds with 200, e.g. for the HEALTHCHECK of images without curl
+func healthcheck(client *http.Client, url string) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return fmt.Errorf("error requesting %s: %s", url, err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status of %s: %s", url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthcheckURL(t *testing.T) {
+	for _, c := range []struct {
+		addresses string
+		exp       string
+	}{
+		{addresses: ":9660", exp: "http://127.0.0.1:9660/healthz"},
+		{addresses: "0.0.0.0:9660", exp: "http://127.0.0.1:9660/healthz"},
+		{addresses: "[::]:9660,0.0.0.0:9661", exp: "http://[::1]:9660/healthz"},
+		{addresses: "10.0.0.1:9660", exp: "http://10.0.0.1:9660/healthz"},
+	} {
+		act, err := healthcheckURL(c.addresses)
+		if err != nil {
+			t.Errorf("unexpected error for %s: %s", c.addresses, err)
+		} else if act != c.exp {
+			t.Errorf("unexpected URL for %s: %s (expected: %s)", c.addresses, act, c.exp)
+		}
+	}
+
+	if _, err := healthcheckURL("9660"); err == nil {
+		t.Error("expected an error for an address without port")
+	}
+}
+
+func TestHealthcheck(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc(healthzPath, healthzHandler)
+	mux.HandleFunc("/unavailable", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	if err := healthcheck(server.Client(), server.URL+healthzPath); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := healthcheck(server.Client(), server.URL+"/unavailable"); err == nil {
+		t.Error("expected an error for an unavailable exporter")
+	}
+
+	server.Close()
+	if err := healthcheck(server.Client(), server.URL+healthzPath); err == nil {
+		t.Error("expected an error for a stopped exporter")
+	}
+}
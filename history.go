@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/prometheus/common/log"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+	"github.com/simonswine/cloud-billing-exporter/state"
+)
+
+// historyMonths is the number of billing months kept in memory
+const historyMonths = 13
+
+// historyStateKey is the key of the history in the state store
+const historyStateKey = "history"
+
+// history keeps the latest records of each collector per billing month, so
+// that months can still be reported on after the collectors moved on
+type history struct {
+	lock sync.Mutex
+	// months contains the records per month and collector ID
+	months map[string]map[string][]billing.Record
+
+	// stateStore persists the history, so closed months survive restarts
+	stateStore state.Store
+}
+
+func newHistory() *history {
+	return &history{
+		months: make(map[string]map[string][]billing.Record),
+	}
+}
+
+// withStateStore enables persisting the history in the given store
+func (h *history) withStateStore(s state.Store) *history {
+	h.stateStore = s
+	return h
+}
+
+// restore loads the persisted history, months already known are kept
+func (h *history) restore(ctx context.Context) error {
+	if h.stateStore == nil {
+		return nil
+	}
+
+	var months map[string]map[string][]billing.Record
+	if err := state.Load(ctx, h.stateStore, historyStateKey, &months); err == state.ErrNotFound {
+		log.Debugf("no previous history found in %s", h.stateStore)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for month, collectors := range months {
+		if _, ok := h.months[month]; !ok {
+			h.months[month] = collectors
+		}
+	}
+	h.expire()
+	return nil
+}
+
+// collectorIDs returns the IDs of the collectors in the history. The first
+// collector of a cloud is identified by the cloud, further ones by their
+// position among the collectors of the cloud, e.g. aws/2, so the IDs are
+// stable across restarts with the same configuration.
+func collectorIDs(collectors []cloudBillingCollector) []string {
+	ids := make([]string, len(collectors))
+	count := make(map[string]int)
+	for i, c := range collectors {
+		cloud := c.Cloud()
+		count[cloud]++
+		ids[i] = cloud
+		if n := count[cloud]; n > 1 {
+			ids[i] = fmt.Sprintf("%s/%d", cloud, n)
+		}
+	}
+	return ids
+}
+
+// update stores the current records of the collectors and persists the
+// history if it changed
+func (h *history) update(collectors []cloudBillingCollector) {
+	ids := collectorIDs(collectors)
+
+	h.lock.Lock()
+	changed := false
+	for i, c := range collectors {
+		byMonth := make(map[string][]billing.Record)
+		for _, r := range c.Records() {
+			byMonth[r.Month] = append(byMonth[r.Month], r)
+		}
+		for month, records := range byMonth {
+			if _, ok := h.months[month]; !ok {
+				h.months[month] = make(map[string][]billing.Record)
+			}
+			if !reflect.DeepEqual(h.months[month][ids[i]], records) {
+				h.months[month][ids[i]] = records
+				changed = true
+			}
+		}
+	}
+	h.expire()
+
+	if !changed || h.stateStore == nil {
+		h.lock.Unlock()
+		return
+	}
+
+	// copy the months, so the store is written without holding the lock
+	months := make(map[string]map[string][]billing.Record, len(h.months))
+	for month, collectors := range h.months {
+		months[month] = make(map[string][]billing.Record, len(collectors))
+		for id, records := range collectors {
+			months[month][id] = records
+		}
+	}
+	h.lock.Unlock()
+
+	if err := state.Save(context.Background(), h.stateStore, historyStateKey, months); err != nil {
+		log.Warnf("error persisting history to %s: %s", h.stateStore, err)
+	}
+}
+
+// expire removes the oldest months, it needs to be called with the lock held
+func (h *history) expire() {
+	if len(h.months) <= historyMonths {
+		return
+	}
+
+	months := make([]string, 0, len(h.months))
+	for month := range h.months {
+		months = append(months, month)
+	}
+	sort.Strings(months)
+	for _, month := range months[:len(months)-historyMonths] {
+		delete(h.months, month)
+	}
+}
+
+// records returns all records of a billing month (YYYY-MM)
+func (h *history) records(month string) []billing.Record {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	ids := make([]string, 0, len(h.months[month]))
+	for id := range h.months[month] {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var records []billing.Record
+	for _, id := range ids {
+		records = append(records, h.months[month][id]...)
+	}
+	return records
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+	"github.com/simonswine/cloud-billing-exporter/state"
+)
+
+type memoryStore map[string][]byte
+
+func (m memoryStore) Get(_ context.Context, key string) ([]byte, error) {
+	data, ok := m[key]
+	if !ok {
+		return nil, state.ErrNotFound
+	}
+	return data, nil
+}
+
+func (m memoryStore) Put(_ context.Context, key string, data []byte) error {
+	m[key] = data
+	return nil
+}
+
+func (m memoryStore) String() string {
+	return "memory"
+}
+
+func TestHistoryRetention(t *testing.T) {
+	c := &fakeCollector{cloud: "aws"}
+	h := newHistory()
+
+	for _, month := range []string{"2018-10", "2018-11", "2018-12", "2019-01", "2019-02", "2019-03", "2019-04", "2019-05", "2019-06", "2019-07", "2019-08", "2019-09", "2019-10", "2019-11"} {
+		c.records = []billing.Record{{Cloud: "aws", Month: month, Costs: 1}}
+		h.update([]cloudBillingCollector{c})
+	}
+
+	if len(h.records("2018-10")) != 0 {
+		t.Error("expected oldest month to be expired")
+	}
+	if len(h.records("2019-11")) != 1 {
+		t.Error("expected latest month to be kept")
+	}
+}
+
+func TestHistoryRestore(t *testing.T) {
+	store := memoryStore{}
+	c := &fakeCollector{cloud: "aws"}
+
+	h := newHistory().withStateStore(store)
+	for _, month := range []string{"2019-10", "2019-11"} {
+		c.records = []billing.Record{{Cloud: "aws", Month: month, Account: "a", Costs: 1}}
+		h.update([]cloudBillingCollector{c})
+	}
+	if _, ok := store[historyStateKey]; !ok {
+		t.Fatal("expected history to be persisted")
+	}
+
+	// unchanged records don't write the store
+	delete(store, historyStateKey)
+	h.update([]cloudBillingCollector{c})
+	if _, ok := store[historyStateKey]; ok {
+		t.Error("expected unchanged history not to be persisted")
+	}
+
+	// updated costs of the current month are persisted
+	c.records = []billing.Record{{Cloud: "aws", Month: "2019-11", Account: "a", Costs: 3}}
+	h.update([]cloudBillingCollector{c})
+
+	// a restarted exporter still knows the closed month
+	restarted := newHistory().withStateStore(store)
+	if err := restarted.restore(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if act := restarted.records("2019-10"); len(act) != 1 || act[0].Costs != 1 {
+		t.Errorf("unexpected records of closed month: %+v", act)
+	}
+	if act := restarted.records("2019-11"); len(act) != 1 || act[0].Costs != 3 {
+		t.Errorf("unexpected records of current month: %+v", act)
+	}
+}
+
+func TestHistoryCollectorsOfCloud(t *testing.T) {
+	store := memoryStore{}
+	payer := &fakeCollector{cloud: "aws", records: []billing.Record{{Cloud: "aws", Month: "2019-11", Account: "a", Costs: 1}}}
+	labs := &fakeCollector{cloud: "aws", records: []billing.Record{{Cloud: "aws", Month: "2019-11", Account: "b", Costs: 2}}}
+	collectors := []cloudBillingCollector{payer, labs}
+	if ids := collectorIDs(collectors); !reflect.DeepEqual(ids, []string{"aws", "aws/2"}) {
+		t.Errorf("unexpected collector IDs: %v", ids)
+	}
+
+	// the records of both collectors of the cloud are kept
+	h := newHistory().withStateStore(store)
+	h.update(collectors)
+	if act := h.records("2019-11"); len(act) != 2 || act[0].Account != "a" || act[1].Account != "b" {
+		t.Errorf("unexpected records: %+v", act)
+	}
+
+	delete(store, historyStateKey)
+	h.update(collectors)
+	if _, ok := store[historyStateKey]; ok {
+		t.Error("expected unchanged history not to be persisted")
+	}
+}
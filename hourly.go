@@ -0,0 +1,50 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+// hourlyCostsCollector is implemented by collectors knowing the costs of the
+// last hours, from reports with hourly granularity
+type hourlyCostsCollector interface {
+	HourlyCosts() []billing.HourlyCost
+}
+
+// hourlyCostCollector exposes the costs per hour of the last hours, so spend
+// spikes are visible before the daily or monthly costs catch up
+type hourlyCostCollector struct {
+	desc *prometheus.Desc
+}
+
+func newHourlyCostCollector() *hourlyCostCollector {
+	return &hourlyCostCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "billing", "hourly_costs"),
+			"Costs of a service in an hour (UTC, formatted as 2006-01-02T15) of the last hours.",
+			[]string{"cloud", "currency", "account", "service", "hour"},
+			nil,
+		),
+	}
+}
+
+func (h *hourlyCostCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- h.desc
+}
+
+func (h *hourlyCostCollector) collect(costs []billing.HourlyCost, ch chan<- prometheus.Metric) {
+	totals := make(map[[5]string]float64)
+	for _, c := range costs {
+		totals[[5]string{c.Cloud, c.Currency, c.Account, c.Service, c.Hour}] += c.Costs
+	}
+	for key, value := range totals {
+		m, err := prometheus.NewConstMetric(h.desc, prometheus.GaugeValue, value, key[:]...)
+		if err != nil {
+			log.Warnf("error exposing hourly costs %v: %s", key, err)
+			continue
+		}
+		ch <- m
+	}
+}
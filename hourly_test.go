@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+// hourlyCosts adapts the hourly cost collector to a prometheus.Collector
+type hourlyCosts struct {
+	*hourlyCostCollector
+	costs []billing.HourlyCost
+}
+
+func (h *hourlyCosts) Collect(ch chan<- prometheus.Metric) {
+	h.collect(h.costs, ch)
+}
+
+func TestHourlyCostCollector(t *testing.T) {
+	c := &hourlyCosts{
+		hourlyCostCollector: newHourlyCostCollector(),
+		costs: []billing.HourlyCost{
+			{Cloud: "aws", Hour: "2019-11-30T23", Currency: "USD", Account: "acme-dev", Service: "AmazonEC2", Costs: 1.5},
+			{Cloud: "aws", Hour: "2019-11-30T23", Currency: "USD", Account: "acme-dev", Service: "AmazonEC2", Costs: 0.5},
+			{Cloud: "aws", Hour: "2019-12-01T00", Currency: "USD", Account: "acme-dev", Service: "AmazonEC2", Costs: 40},
+		},
+	}
+
+	if err := testutil.CollectAndCompare(c, strings.NewReader(`
+# HELP cloud_billing_hourly_costs Costs of a service in an hour (UTC, formatted as 2006-01-02T15) of the last hours.
+# TYPE cloud_billing_hourly_costs gauge
+cloud_billing_hourly_costs{account="acme-dev",cloud="aws",currency="USD",hour="2019-11-30T23",service="AmazonEC2"} 2
+cloud_billing_hourly_costs{account="acme-dev",cloud="aws",currency="USD",hour="2019-12-01T00",service="AmazonEC2"} 40
+`)); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/log"
+)
+
+const (
+	// httpEnrichmentTimeout limits the time of a single lookup
+	httpEnrichmentTimeout = 5 * time.Second
+	// httpEnrichmentRetry is the time after which failed lookups are retried
+	httpEnrichmentRetry = time.Minute
+)
+
+// httpAccount is the response of the account lookup
+type httpAccount struct {
+	Owner string `json:"owner"`
+	Team  string `json:"team"`
+	Env   string `json:"env"`
+}
+
+type httpAccountEntry struct {
+	account httpAccount
+	expires time.Time
+}
+
+// httpEnrichment looks up the owner, team and environment of accounts from an
+// internal HTTP service, e.g. a CMDB. The URL contains {id}, which is replaced
+// by the account label. Responses are cached, unknown accounts (404) get empty
+// labels.
+type httpEnrichment struct {
+	url        string
+	ttl        time.Duration
+	httpClient *http.Client
+	now        func() time.Time
+
+	lock  sync.Mutex
+	cache map[string]httpAccountEntry
+}
+
+func newHTTPEnrichment(lookupURL string, ttl time.Duration) (*httpEnrichment, error) {
+	if !strings.Contains(lookupURL, "{id}") {
+		return nil, fmt.Errorf("URL '%s' doesn't contain {id}", lookupURL)
+	}
+	u, err := url.Parse(strings.Replace(lookupURL, "{id}", "id", -1))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing URL '%s': %s", lookupURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme '%s' in URL '%s'", u.Scheme, lookupURL)
+	}
+	return &httpEnrichment{
+		url:        lookupURL,
+		ttl:        ttl,
+		httpClient: &http.Client{Timeout: httpEnrichmentTimeout},
+		now:        time.Now,
+		cache:      make(map[string]httpAccountEntry),
+	}, nil
+}
+
+// lookup queries the service for an account, it returns an empty account if
+// it is not known
+func (h *httpEnrichment) lookup(ctx context.Context, id string) (httpAccount, error) {
+	var account httpAccount
+	u := strings.Replace(h.url, "{id}", url.PathEscape(id), -1)
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return account, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := h.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return account, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return account, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return account, fmt.Errorf("unexpected status of %s: %s", u, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&account); err != nil {
+		return account, fmt.Errorf("error decoding %s: %s", u, err)
+	}
+	return account, nil
+}
+
+// account returns the cached account or looks it up if the cache expired.
+// Stale entries are kept if the lookup fails.
+func (h *httpEnrichment) account(id string) httpAccount {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	now := h.now()
+	entry, ok := h.cache[id]
+	if ok && now.Before(entry.expires) {
+		return entry.account
+	}
+
+	account, err := h.lookup(context.Background(), id)
+	if err != nil {
+		log.Warnf("error looking up account %s: %s", id, err)
+		entry.expires = now.Add(httpEnrichmentRetry)
+		h.cache[id] = entry
+		return entry.account
+	}
+	h.cache[id] = httpAccountEntry{account: account, expires: now.Add(h.ttl)}
+	return account
+}
+
+func (h *httpEnrichment) labels() []string {
+	return []string{teamLabel, "env"}
+}
+
+func (h *httpEnrichment) update() {}
+
+func (h *httpEnrichment) enrich(labels map[string]string) {
+	if labels["account"] == "" {
+		return
+	}
+	account := h.account(labels["account"])
+	if account.Owner != "" {
+		labels["owner"] = account.Owner
+	}
+	if account.Team != "" || labels[teamLabel] == "" {
+		labels[teamLabel] = account.Team
+	}
+	labels["env"] = account.Env
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHTTPEnrichment(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/accounts/acme-dev":
+			fmt.Fprint(w, `{"owner":"jane@example.com","team":"platform","env":"dev"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	if _, err := newHTTPEnrichment(srv.URL+"/accounts", time.Hour); err == nil {
+		t.Error("expected error for URL without {id}")
+	}
+	h, err := newHTTPEnrichment(srv.URL+"/accounts/{id}", time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	now := time.Date(2019, 11, 3, 10, 0, 0, 0, time.UTC)
+	h.now = func() time.Time { return now }
+
+	for _, c := range []struct {
+		labels map[string]string
+		exp    map[string]string
+	}{
+		{
+			labels: map[string]string{"account": "acme-dev", "owner": "joe"},
+			exp:    map[string]string{"account": "acme-dev", "owner": "jane@example.com", "team": "platform", "env": "dev"},
+		},
+		{
+			labels: map[string]string{"account": "acme-dev"},
+			exp:    map[string]string{"account": "acme-dev", "owner": "jane@example.com", "team": "platform", "env": "dev"},
+		},
+		{
+			labels: map[string]string{"account": "unknown", "owner": "joe"},
+			exp:    map[string]string{"account": "unknown", "owner": "joe", "team": "", "env": ""},
+		},
+	} {
+		h.enrich(c.labels)
+		if !reflect.DeepEqual(c.labels, c.exp) {
+			t.Errorf("unexpected labels: %v (expected: %v)", c.labels, c.exp)
+		}
+	}
+	if act, exp := requests, 2; act != exp {
+		t.Errorf("unexpected number of requests: %d (expected: %d)", act, exp)
+	}
+
+	// looked up again once the cache expired
+	now = now.Add(time.Hour)
+	h.enrich(map[string]string{"account": "acme-dev"})
+	if act, exp := requests, 3; act != exp {
+		t.Errorf("unexpected number of requests: %d (expected: %d)", act, exp)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/log"
+)
+
+// labelFilter drops metrics by the values of their labels. A metric is kept
+// if the values match one of the allowed expressions of their label and none
+// of the denied ones, labels without expressions are not filtered.
+type labelFilter struct {
+	allow map[string][]*regexp.Regexp
+	deny  map[string][]*regexp.Regexp
+}
+
+func newLabelFilter() *labelFilter {
+	return &labelFilter{
+		allow: make(map[string][]*regexp.Regexp),
+		deny:  make(map[string][]*regexp.Regexp),
+	}
+}
+
+// add parses an expression given as label=regex, the regex is anchored
+func (f *labelFilter) add(allow bool, expr string) error {
+	parts := strings.SplitN(expr, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("invalid label filter '%s', expected label=regex", expr)
+	}
+	re, err := regexp.Compile("^(?:" + parts[1] + ")$")
+	if err != nil {
+		return fmt.Errorf("invalid label filter '%s': %s", expr, err)
+	}
+	if allow {
+		f.allow[parts[0]] = append(f.allow[parts[0]], re)
+	} else {
+		f.deny[parts[0]] = append(f.deny[parts[0]], re)
+	}
+	return nil
+}
+
+func (f *labelFilter) empty() bool {
+	return f == nil || (len(f.allow) == 0 && len(f.deny) == 0)
+}
+
+func matchAny(expressions []*regexp.Regexp, value string) bool {
+	for _, re := range expressions {
+		if re.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// keep returns whether a metric with the given labels is exposed
+func (f *labelFilter) keep(labels []*dto.LabelPair) bool {
+	for _, l := range labels {
+		if allow, ok := f.allow[l.GetName()]; ok && !matchAny(allow, l.GetValue()) {
+			return false
+		}
+		if matchAny(f.deny[l.GetName()], l.GetValue()) {
+			return false
+		}
+	}
+	return true
+}
+
+// collect forwards the metrics of collect which are kept by the filter
+func (f *labelFilter) collect(collect func(chan<- prometheus.Metric), ch chan<- prometheus.Metric) {
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		collect(metrics)
+		close(metrics)
+	}()
+
+	for m := range metrics {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			log.Warnf("error filtering metric %s: %s", m.Desc(), err)
+			continue
+		}
+		if f.keep(pb.Label) {
+			ch <- m
+		}
+	}
+}
+
+// labelFilterFlag adds the expressions given by a repeatable flag to the
+// filter
+type labelFilterFlag struct {
+	filter *labelFilter
+	allow  bool
+}
+
+func (f *labelFilterFlag) String() string {
+	return ""
+}
+
+func (f *labelFilterFlag) Set(value string) error {
+	return f.filter.add(f.allow, value)
+}
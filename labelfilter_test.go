@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+type labelFilterTestCollector struct {
+	*labelFilter
+	costs *prometheus.CounterVec
+}
+
+func (c labelFilterTestCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.costs.Describe(ch)
+}
+
+func (c labelFilterTestCollector) Collect(ch chan<- prometheus.Metric) {
+	c.collect(c.costs.Collect, ch)
+}
+
+func TestLabelFilter(t *testing.T) {
+	f := newLabelFilter()
+	for _, expr := range []string{"owner=.*@example.com", "owner="} {
+		if err := f.add(true, expr); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if err := f.add(false, "service=AWS Support.*"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := f.add(false, "service"); err == nil {
+		t.Error("expected error for filter without regex")
+	}
+
+	costs := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cloud_billing_monthly_costs",
+		Help: "Billed costs per calendar month.",
+	}, billing.MonthlyCostsLabels)
+	costs.WithLabelValues("aws", "USD", "acme-dev", "AmazonEC2", "", "jane@example.com", "", "").Add(10)
+	costs.WithLabelValues("aws", "USD", "acme-dev", "AWS Support (Business)", "", "jane@example.com", "", "").Add(100)
+	costs.WithLabelValues("aws", "USD", "acme-ext", "AmazonEC2", "", "joe@example.org", "", "").Add(5)
+	costs.WithLabelValues("aws", "USD", "shared", "AmazonS3", "", "", "", "").Add(1)
+
+	exp := `
+# HELP cloud_billing_monthly_costs Billed costs per calendar month.
+# TYPE cloud_billing_monthly_costs counter
+cloud_billing_monthly_costs{account="acme-dev",cloud="aws",cost_centre="",currency="USD",owner="jane@example.com",path="",service="AmazonEC2",type=""} 10
+cloud_billing_monthly_costs{account="shared",cloud="aws",cost_centre="",currency="USD",owner="",path="",service="AmazonS3",type=""} 1
+`
+	if err := testutil.CollectAndCompare(labelFilterTestCollector{labelFilter: f, costs: costs}, strings.NewReader(exp)); err != nil {
+		t.Errorf("unexpected metrics: %s", err)
+	}
+}
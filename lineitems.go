@@ -0,0 +1,101 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/log"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+// dateFormat is the format of the days of line items
+const dateFormat = "2006-01-02"
+
+// lineItemHistory keeps the latest line items of the last months, so costs
+// can be aggregated to periods spanning calendar months
+type lineItemHistory struct {
+	lock sync.Mutex
+	// items contains the latest line items per cloud and month
+	items map[[2]string][]billing.LineItem
+}
+
+func newLineItemHistory() *lineItemHistory {
+	return &lineItemHistory{
+		items: make(map[[2]string][]billing.LineItem),
+	}
+}
+
+// update replaces the line items of the months and clouds given, the oldest
+// months are dropped
+func (h *lineItemHistory) update(items []billing.LineItem) {
+	byMonth := make(map[[2]string][]billing.LineItem)
+	for _, i := range items {
+		key := [2]string{i.Cloud, i.Month}
+		byMonth[key] = append(byMonth[key], i)
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for key, items := range byMonth {
+		h.items[key] = items
+	}
+
+	months := make(map[string]bool)
+	for key := range h.items {
+		months[key[1]] = true
+	}
+	if len(months) <= historyMonths {
+		return
+	}
+	sorted := make([]string, 0, len(months))
+	for month := range months {
+		sorted = append(sorted, month)
+	}
+	sort.Strings(sorted)
+	expired := make(map[string]bool)
+	for _, month := range sorted[:len(sorted)-historyMonths] {
+		expired[month] = true
+	}
+	for key := range h.items {
+		if expired[key[1]] {
+			delete(h.items, key)
+		}
+	}
+}
+
+// eachDay calls fn with the costs of the line items per day. The costs of
+// line items without day are spread evenly over the days of their month if
+// spread is set, otherwise they are skipped.
+func (h *lineItemHistory) eachDay(spread bool, fn func(i billing.LineItem, day time.Time, costs float64)) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for _, items := range h.items {
+		for _, i := range items {
+			if i.Date != "" {
+				day, err := time.Parse(dateFormat, i.Date)
+				if err != nil {
+					log.Warnf("error parsing date of line item %+v: %s", i, err)
+					continue
+				}
+				fn(i, day, i.Costs)
+				continue
+			}
+			if !spread {
+				continue
+			}
+
+			month, err := time.Parse(monthFormat, i.Month)
+			if err != nil {
+				log.Warnf("error parsing month of line item %+v: %s", i, err)
+				continue
+			}
+			days := month.AddDate(0, 1, -1).Day()
+			for d := 0; d < days; d++ {
+				fn(i, month.AddDate(0, 0, d), i.Costs/float64(days))
+			}
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// listen opens listeners on the comma separated addresses. IPv6 addresses
+// need brackets, [::]:9660 listens on all IPv6 addresses and also accepts
+// IPv4 connections if the host is dual-stack.
+func listen(addresses string) ([]net.Listener, error) {
+	var listeners []net.Listener
+	closeAll := func() {
+		for _, l := range listeners {
+			_ = l.Close()
+		}
+	}
+
+	for _, address := range strings.Split(addresses, ",") {
+		address = strings.TrimSpace(address)
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			closeAll()
+			if strings.Count(address, ":") > 1 && !strings.HasPrefix(address, "[") {
+				return nil, fmt.Errorf("invalid listen address '%s', IPv6 addresses need brackets, e.g. [::]:9660", address)
+			}
+			return nil, fmt.Errorf("invalid listen address '%s': %s", address, err)
+		}
+
+		l, err := net.Listen("tcp", address)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestListen(t *testing.T) {
+	listeners, err := listen("127.0.0.1:0, [::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback not available: %s", err)
+	}
+	defer func() {
+		for _, l := range listeners {
+			_ = l.Close()
+		}
+	}()
+	if act, exp := len(listeners), 2; act != exp {
+		t.Fatalf("unexpected number of listeners: %d (expected: %d)", act, exp)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			_ = http.Serve(l, mux)
+		}(l)
+	}
+
+	addr := listeners[1].Addr().String()
+	if !strings.HasPrefix(addr, "[::1]:") {
+		t.Fatalf("unexpected address of IPv6 listener: %s", addr)
+	}
+	resp, err := http.Get("http://" + addr + "/")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if string(body) != "ok" {
+		t.Errorf("unexpected response: %s", body)
+	}
+}
+
+func TestListenInvalidAddress(t *testing.T) {
+	for _, c := range []struct {
+		address string
+		err     string
+	}{
+		{"::1:9660", "IPv6 addresses need brackets"},
+		{"localhost", "missing port"},
+	} {
+		_, err := listen(c.address)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("unexpected error for '%s': %v", c.address, err)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+// Package localfs serves reports synced into a local directory like the
+// objects of a bucket, e.g. in air-gapped environments where a separate
+// process copies the reports, or to run the parsers in tests without any
+// cloud API.
+package localfs
+
+import (
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scheme starts the bucket names of local directories, e.g.
+// file:///var/lib/reports
+const Scheme = "file://"
+
+// ErrNotExist is returned for files not in the directory
+var ErrNotExist = errors.New("file doesn't exist")
+
+// Dir returns the directory of a bucket name with the file:// scheme, ok is
+// false for other bucket names
+func Dir(bucketName string) (dir string, ok bool) {
+	if !strings.HasPrefix(bucketName, Scheme) {
+		return "", false
+	}
+	return strings.TrimPrefix(bucketName, Scheme), true
+}
+
+// File describes a file of the directory, Name is the slash separated path
+// relative to the directory
+type File struct {
+	Name     string
+	Size     int64
+	Modified time.Time
+	MD5      []byte
+}
+
+// Bucket lists and opens the files below a directory. Files and directories
+// starting with a dot, like the partial files of rsync, are skipped.
+type Bucket struct {
+	dir string
+
+	// hashes caches the MD5 of the files by their name, size and time of
+	// the last modification, so unchanged files are only read once
+	lock   sync.Mutex
+	hashes map[fileVersion][]byte
+}
+
+// fileVersion identifies the content of a file
+type fileVersion struct {
+	name     string
+	size     int64
+	modified int64
+}
+
+func (f File) version() fileVersion {
+	return fileVersion{name: f.Name, size: f.Size, modified: f.Modified.UnixNano()}
+}
+
+// New returns a bucket of the files below the directory, which doesn't need
+// to exist until the files are listed
+func New(dir string) *Bucket {
+	return &Bucket{dir: dir, hashes: make(map[fileVersion][]byte)}
+}
+
+// path returns the path of the file, names escaping the directory are
+// rejected
+func (b *Bucket) path(name string) (string, error) {
+	if name == "" || path.Clean("/"+name) != "/"+name {
+		return "", ErrNotExist
+	}
+	return filepath.Join(b.dir, filepath.FromSlash(name)), nil
+}
+
+// List returns the files with the given prefix, sorted by their names
+func (b *Bucket) List(prefix string) ([]File, error) {
+	var files []File
+	err := filepath.Walk(b.dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == b.dir {
+			return nil
+		}
+		if strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(b.dir, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if info.IsDir() {
+			// skip directories which can't contain files with the prefix
+			if !strings.HasPrefix(name+"/", prefix) && !strings.HasPrefix(prefix, name+"/") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+		f, err := b.stat(name, info)
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing local reports in '%s': %s", b.dir, err)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+	b.prune(files, prefix)
+	return files, nil
+}
+
+// Stat returns the file with the given name
+func (b *Bucket) Stat(name string) (File, error) {
+	p, err := b.path(name)
+	if err != nil {
+		return File{}, err
+	}
+	info, err := os.Stat(p)
+	if os.IsNotExist(err) || (err == nil && !info.Mode().IsRegular()) {
+		return File{}, ErrNotExist
+	} else if err != nil {
+		return File{}, err
+	}
+	return b.stat(name, info)
+}
+
+func (b *Bucket) stat(name string, info os.FileInfo) (File, error) {
+	f := File{Name: name, Size: info.Size(), Modified: info.ModTime()}
+
+	b.lock.Lock()
+	sum, ok := b.hashes[f.version()]
+	b.lock.Unlock()
+	if !ok {
+		var err error
+		if sum, err = b.hash(name); err != nil {
+			return File{}, err
+		}
+		b.lock.Lock()
+		b.hashes[f.version()] = sum
+		b.lock.Unlock()
+	}
+	f.MD5 = sum
+	return f, nil
+}
+
+func (b *Bucket) hash(name string) ([]byte, error) {
+	r, err := b.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	h := md5.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, fmt.Errorf("error hashing '%s': %s", name, err)
+	}
+	return h.Sum(nil), nil
+}
+
+// prune drops the cached hashes of the files with the prefix, which are no
+// longer listed in the same version
+func (b *Bucket) prune(files []File, prefix string) {
+	listed := make(map[fileVersion]bool, len(files))
+	for _, f := range files {
+		listed[f.version()] = true
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for v := range b.hashes {
+		if strings.HasPrefix(v.name, prefix) && !listed[v] {
+			delete(b.hashes, v)
+		}
+	}
+}
+
+// Open opens the file with the given name
+func (b *Bucket) Open(name string) (*os.File, error) {
+	p, err := b.path(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotExist
+	}
+	return f, err
+}
+
+func (b *Bucket) String() string {
+	return Scheme + b.dir
+}
@@ -0,0 +1,113 @@
+package localfs
+
+import (
+	"bytes"
+	"crypto/md5"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, dir, name, content string) {
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func names(files []File) []string {
+	var result []string
+	for _, f := range files {
+		result = append(result, f.Name)
+	}
+	return result
+}
+
+func TestDir(t *testing.T) {
+	if dir, ok := Dir("file:///var/lib/reports"); !ok || dir != "/var/lib/reports" {
+		t.Errorf("unexpected directory: %s, %v", dir, ok)
+	}
+	if _, ok := Dir("billing"); ok {
+		t.Error("expected bucket name without directory")
+	}
+}
+
+func TestBucketList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "localfs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFile(t, dir, "billing-2019-11-01.json", "a")
+	writeFile(t, dir, "billing-2019-11-02.json", "b")
+	writeFile(t, dir, ".billing-2019-11-03.json.XyZ123", "c")
+	writeFile(t, dir, "reports/1234/2019/11/report.csv", "d")
+	writeFile(t, dir, "other/billing-2019-11-01.json", "e")
+	writeFile(t, dir, ".sync/billing-2019-11-04.json", "f")
+
+	b := New(dir)
+	for prefix, exp := range map[string][]string{
+		"billing-":      {"billing-2019-11-01.json", "billing-2019-11-02.json"},
+		"reports/12":    {"reports/1234/2019/11/report.csv"},
+		"reports/1234/": {"reports/1234/2019/11/report.csv"},
+		"":              {"billing-2019-11-01.json", "billing-2019-11-02.json", "other/billing-2019-11-01.json", "reports/1234/2019/11/report.csv"},
+		"missing":       nil,
+	} {
+		files, err := b.List(prefix)
+		if err != nil {
+			t.Fatalf("unexpected error listing '%s': %s", prefix, err)
+		}
+		if act := names(files); !reflect.DeepEqual(act, exp) {
+			t.Errorf("unexpected files with prefix '%s': %v (expected: %v)", prefix, act, exp)
+		}
+	}
+
+	if _, err := New(filepath.Join(dir, "missing")).List(""); err == nil {
+		t.Error("expected error listing missing directory")
+	}
+}
+
+func TestBucketHash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "localfs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFile(t, dir, "report.csv", "a,b\n")
+	b := New(dir)
+	f, err := b.Stat("report.csv")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if act, exp := f.MD5, md5.Sum([]byte("a,b\n")); !bytes.Equal(act, exp[:]) {
+		t.Errorf("unexpected MD5: %x (expected: %x)", act, exp)
+	}
+
+	// a changed file is hashed again
+	writeFile(t, dir, "report.csv", "a,b\n1,2\n")
+	modified := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(dir, "report.csv"), modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := b.Stat("report.csv")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if reflect.DeepEqual(changed.MD5, f.MD5) {
+		t.Error("expected the MD5 to change with the content")
+	}
+
+	for _, name := range []string{"missing.csv", "../report.csv", "./report.csv", ""} {
+		if _, err := b.Stat(name); err != ErrNotExist {
+			t.Errorf("unexpected error of '%s': %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"runtime/debug"
+
+	"github.com/prometheus/common/log"
+)
+
+// memoryBallast is allocated but never used, it raises the heap size the
+// garbage collector targets without occupying resident memory
+var memoryBallast []byte
+
+// tuneMemory configures the garbage collector, so large reports can be
+// parsed within the memory limit of the container. The GC percent and memory
+// limit are left as set by GOGC and GOMEMLIMIT if 0.
+func tuneMemory(gcPercent int, memoryLimit, ballast int64) error {
+	if gcPercent != 0 {
+		previous := debug.SetGCPercent(gcPercent)
+		log.Infof("set GC percent to %d (was %d)", gcPercent, previous)
+	}
+	if memoryLimit > 0 {
+		if err := setMemoryLimit(memoryLimit); err != nil {
+			return err
+		}
+		log.Infof("set memory limit to %d bytes", memoryLimit)
+	}
+	if ballast > 0 {
+		memoryBallast = make([]byte, ballast)
+		log.Infof("allocated memory ballast of %d bytes", ballast)
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build go1.19
+// +build go1.19
+
+package main
+
+import (
+	"runtime/debug"
+)
+
+// setMemoryLimit sets the soft memory limit of the runtime, the garbage
+// collector runs more often when approaching it
+func setMemoryLimit(limit int64) error {
+	debug.SetMemoryLimit(limit)
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !go1.19
+// +build !go1.19
+
+package main
+
+import (
+	"fmt"
+)
+
+// setMemoryLimit is not supported before Go 1.19, a memory ballast can be
+// used instead
+func setMemoryLimit(limit int64) error {
+	return fmt.Errorf("setting a memory limit requires a build with Go 1.19 or newer, use -runtime.memory-ballast instead")
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"runtime/debug"
+	"testing"
+)
+
+func TestTuneMemory(t *testing.T) {
+	defer debug.SetGCPercent(debug.SetGCPercent(100))
+	defer func() { memoryBallast = nil }()
+
+	if err := tuneMemory(50, 0, 1024); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if act, exp := debug.SetGCPercent(100), 50; act != exp {
+		t.Errorf("unexpected GC percent: %d (expected: %d)", act, exp)
+	}
+	if act, exp := len(memoryBallast), 1024; act != exp {
+		t.Errorf("unexpected ballast size: %d (expected: %d)", act, exp)
+	}
+}
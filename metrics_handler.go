@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/log"
+)
+
+// metricsHandler serves the metrics of the default registry, or only the
+// ones of the clouds selected by collect[] URL parameters. The responses are
+// gzip compressed if the client accepts it, unless compression is disabled.
+// At most maxRequests are served at the same time, further requests wait for
+// a free slot until they time out. Unlimited if 0.
+func (b *BillingCollector) metricsHandler(disableCompression bool, maxRequests int) http.Handler {
+	opts := promhttp.HandlerOpts{
+		ErrorLog:           log.NewErrorLogger(),
+		ErrorHandling:      promhttp.ContinueOnError,
+		DisableCompression: disableCompression,
+	}
+	handler := promhttp.HandlerFor(prometheus.DefaultGatherer, opts)
+
+	var slots chan struct{}
+	if maxRequests > 0 {
+		slots = make(chan struct{}, maxRequests)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slots != nil {
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-r.Context().Done():
+				log.Warnf("scrape from %s gave up waiting for %d concurrent scrapes to finish", r.RemoteAddr, maxRequests)
+				http.Error(w, "too many concurrent scrapes", http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		filters := r.URL.Query()["collect[]"]
+		if len(filters) == 0 {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		filtered, err := b.filtered(filters)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		registry := prometheus.NewRegistry()
+		if err := registry.Register(filtered); err != nil {
+			http.Error(w, fmt.Sprintf("couldn't register collector: %s", err), http.StatusInternalServerError)
+			return
+		}
+		promhttp.HandlerFor(registry, opts).ServeHTTP(w, r)
+	})
+}
+
+// newResponseSizeHistogram returns the histogram of the metrics response
+// sizes, to monitor the growth of the exposition
+func newResponseSizeHistogram() *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Subsystem: "metrics",
+			Name:      "response_size_bytes",
+			Help:      "Size of the metrics responses as sent, per content encoding.",
+			Buckets:   prometheus.ExponentialBuckets(1024, 4, 9),
+		},
+		[]string{"encoding"},
+	)
+}
+
+// responseSizeWriter counts the bytes written to the response
+type responseSizeWriter struct {
+	http.ResponseWriter
+	size int
+}
+
+func (w *responseSizeWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.size += n
+	return n, err
+}
+
+// instrumentResponseSize observes the size of the responses written by the
+// handler
+func instrumentResponseSize(h *prometheus.HistogramVec, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &responseSizeWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+
+		encoding := w.Header().Get("Content-Encoding")
+		if encoding == "" {
+			encoding = "identity"
+		}
+		h.WithLabelValues(encoding).Observe(float64(sw.size))
+	})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+func TestMetricsHandlerCompression(t *testing.T) {
+	b := &BillingCollector{
+		collectors: []cloudBillingCollector{
+			&fakeCollector{cloud: "gcp", records: []billing.Record{{Cloud: "gcp", Month: "2019-11", Currency: "USD", Account: "project-a", Service: "compute-engine", Costs: 1}}},
+		},
+	}
+	b.initMetrics()
+	b.metricMonthlyCosts.WithLabelValues("gcp", "USD", "project-a", "compute-engine", "", "", "", "").Add(1)
+
+	for _, disableCompression := range []bool{false, true} {
+		size := newResponseSizeHistogram()
+		handler := instrumentResponseSize(size, b.metricsHandler(disableCompression, 0))
+
+		req := httptest.NewRequest(http.MethodGet, "/metrics?collect[]=gcp", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		sent := w.Body.Len()
+		body := w.Body.String()
+		encoding := "identity"
+		if !disableCompression {
+			encoding = "gzip"
+			if act := w.Header().Get("Content-Encoding"); act != "gzip" {
+				t.Fatalf("unexpected content encoding: %s", act)
+			}
+			gz, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			data, err := ioutil.ReadAll(gz)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			body = string(data)
+		}
+		if !strings.Contains(body, `cloud_billing_monthly_costs{account="project-a"`) {
+			t.Errorf("unexpected metrics: %s", body)
+		}
+
+		var m dto.Metric
+		if err := size.WithLabelValues(encoding).(prometheus.Histogram).Write(&m); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if act, exp := m.GetHistogram().GetSampleSum(), float64(sent); act != exp {
+			t.Errorf("unexpected %s response size: %f (expected: %f)", encoding, act, exp)
+		}
+	}
+}
+
+// blockingCollector blocks its queries until released
+type blockingCollector struct {
+	fakeCollector
+	started chan struct{}
+	release chan struct{}
+}
+
+func (c *blockingCollector) Query() error {
+	c.started <- struct{}{}
+	<-c.release
+	return nil
+}
+
+func TestMetricsHandlerMaxRequests(t *testing.T) {
+	c := &blockingCollector{
+		fakeCollector: fakeCollector{cloud: "gcp"},
+		started:       make(chan struct{}, 2),
+		release:       make(chan struct{}),
+	}
+	b := &BillingCollector{collectors: []cloudBillingCollector{c}}
+	b.initMetrics()
+	handler := b.metricsHandler(false, 1)
+
+	first := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics?collect[]=gcp", nil))
+		first <- w.Code
+	}()
+	<-c.started
+
+	// the second scrape times out waiting for the first one
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics?collect[]=gcp", nil).WithContext(ctx))
+	if act, exp := w.Code, http.StatusServiceUnavailable; act != exp {
+		t.Errorf("unexpected status of the second scrape: %d (expected: %d)", act, exp)
+	}
+	select {
+	case <-c.started:
+		t.Error("second scrape queried the collector")
+	default:
+	}
+
+	close(c.release)
+	if act, exp := <-first, http.StatusOK; act != exp {
+		t.Errorf("unexpected status of the first scrape: %d (expected: %d)", act, exp)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+// overflowLabelValue replaces the account and service of the series folded
+// by the series limit
+const overflowLabelValue = "overflow"
+
+// seriesEnricher sets labels of the monthly costs series when they are
+// exposed
+type seriesEnricher interface {
+	// labels returns the labels added to the monthly costs
+	labels() []string
+	// update is called once per collection, before the series are enriched
+	update()
+	enrich(labels map[string]string)
+}
+
+// monthlyCostsCollector exposes the monthly costs series. It adds the labels
+// of the enrichers, sums up the series by the group by labels and limits the
+// number of series per cloud. The first series seen are exposed, the costs of
+// further series are summed up in an overflow series per currency. Series are
+// never moved out of the overflow, so all exposed counters keep increasing.
+type monthlyCostsCollector struct {
+	limit     int
+	labels    []string
+	enrichers []seriesEnricher
+	desc      *prometheus.Desc
+	current   *prometheus.Desc
+	folded    *prometheus.CounterVec
+
+	lock     sync.Mutex
+	admitted map[string]map[string]bool
+	overflow map[string]bool
+}
+
+func newMonthlyCostsCollector(limit int) *monthlyCostsCollector {
+	l := &monthlyCostsCollector{
+		limit: limit,
+		folded: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: prometheus.BuildFQName(Namespace, "billing", "overflow_series_total"),
+				Help: "Number of monthly costs series folded into the overflow series, as the series limit is exceeded.",
+			},
+			[]string{"cloud"},
+		),
+		admitted: make(map[string]map[string]bool),
+		overflow: make(map[string]bool),
+	}
+	if err := l.withGroupBy(nil); err != nil {
+		panic(err)
+	}
+	return l
+}
+
+// withEnricher adds the labels set by the enricher, it needs to be called
+// before withGroupBy
+func (l *monthlyCostsCollector) withEnricher(e seriesEnricher) *monthlyCostsCollector {
+	l.enrichers = append(l.enrichers, e)
+	if err := l.withGroupBy(nil); err != nil {
+		panic(err)
+	}
+	return l
+}
+
+// allLabels returns the labels of the monthly costs before grouping
+func (l *monthlyCostsCollector) allLabels() []string {
+	labels := append([]string{}, billing.MonthlyCostsLabels...)
+	known := make(map[string]bool)
+	for _, name := range labels {
+		known[name] = true
+	}
+	for _, e := range l.enrichers {
+		for _, name := range e.labels() {
+			if !known[name] {
+				known[name] = true
+				labels = append(labels, name)
+			}
+		}
+	}
+	return labels
+}
+
+// withGroupBy exposes the monthly costs by the given labels only, the cloud
+// and currency label are always kept. All labels are kept if empty.
+func (l *monthlyCostsCollector) withGroupBy(labels []string) error {
+	known := make(map[string]bool)
+	for _, name := range l.allLabels() {
+		known[name] = true
+	}
+	selected := map[string]bool{"cloud": true, "currency": true}
+	for _, name := range labels {
+		name = strings.TrimSpace(name)
+		if !known[name] {
+			return fmt.Errorf("unknown group by label '%s'", name)
+		}
+		selected[name] = true
+	}
+
+	l.labels = nil
+	for _, name := range l.allLabels() {
+		if len(labels) == 0 || selected[name] {
+			l.labels = append(l.labels, name)
+		}
+	}
+	l.desc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "billing", "monthly_costs"),
+		"Billed costs per calendar month.",
+		l.labels,
+		nil,
+	)
+	l.current = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "billing", "monthly_costs_current"),
+		"Month-to-date costs of the latest billing report, which decrease when costs are corrected or credited.",
+		l.labels,
+		nil,
+	)
+	return nil
+}
+
+// hasLabel returns true if the monthly costs have the label before grouping
+func (l *monthlyCostsCollector) hasLabel(name string) bool {
+	for _, n := range l.allLabels() {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *monthlyCostsCollector) grouped() bool {
+	return len(l.labels) != len(l.allLabels())
+}
+
+func (l *monthlyCostsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- l.desc
+	ch <- l.current
+	l.folded.Describe(ch)
+}
+
+// series returns the monthly costs series with the labels of the enrichers,
+// which are updated by collect
+func (l *monthlyCostsCollector) series(costs *prometheus.CounterVec) []series {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	result := collectSeries(costs)
+	for _, s := range result {
+		for _, e := range l.enrichers {
+			e.enrich(s.labels)
+		}
+	}
+	return result
+}
+
+// recordLabels returns the labels of the record with the labels of the
+// enrichers, which are updated by collect
+func (l *monthlyCostsCollector) recordLabels(r billing.Record) map[string]string {
+	labels := make(map[string]string, len(billing.MonthlyCostsLabels))
+	for _, name := range billing.MonthlyCostsLabels {
+		labels[name] = r.LabelValue(name)
+	}
+	if r.SKU != "" {
+		labels[skuLabel] = r.SKU
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for _, e := range l.enrichers {
+		e.enrich(labels)
+	}
+	return labels
+}
+
+// collect forwards the monthly costs series within the limit, the other ones
+// are folded into the overflow series
+func (l *monthlyCostsCollector) collect(costs *prometheus.CounterVec, ch chan<- prometheus.Metric) {
+	defer l.folded.Collect(ch)
+	if l.limit <= 0 && !l.grouped() && len(l.enrichers) == 0 {
+		costs.Collect(ch)
+		return
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for _, e := range l.enrichers {
+		e.update()
+	}
+	result := collectSeries(costs)
+	for _, s := range result {
+		for _, e := range l.enrichers {
+			e.enrich(s.labels)
+		}
+	}
+	l.emit(l.desc, prometheus.CounterValue, result, ch)
+}
+
+// collectCurrent exposes the month-to-date costs of the records as gauges,
+// grouped and limited like the monthly costs. The labels of the enrichers are
+// updated by collect.
+func (l *monthlyCostsCollector) collectCurrent(records []billing.Record, ch chan<- prometheus.Metric) {
+	result := make([]series, len(records))
+	for i, r := range records {
+		result[i] = series{labels: l.recordLabels(r), value: r.Costs}
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.emit(l.current, prometheus.GaugeValue, result, ch)
+}
+
+// emit sums up the series by the group by labels and forwards the ones
+// within the limit, the other ones are summed up in the overflow series
+func (l *monthlyCostsCollector) emit(desc *prometheus.Desc, valueType prometheus.ValueType, result []series, ch chan<- prometheus.Metric) {
+	groups := make(map[string][]string)
+	groupCosts := make(map[string]float64)
+	for _, s := range result {
+		values := make([]string, len(l.labels))
+		for i, name := range l.labels {
+			values[i] = s.labels[name]
+		}
+		key := strings.Join(values, "\x00")
+		groups[key] = values
+		groupCosts[key] += s.value
+	}
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	overflow := make(map[string][]string)
+	overflowCosts := make(map[string]float64)
+	for _, key := range keys {
+		values := groups[key]
+		if l.limit <= 0 {
+			ch <- prometheus.MustNewConstMetric(desc, valueType, groupCosts[key], values...)
+			continue
+		}
+
+		// the cloud is the first label
+		cloud := values[0]
+		admitted, ok := l.admitted[cloud]
+		if !ok {
+			admitted = make(map[string]bool)
+			l.admitted[cloud] = admitted
+		}
+		if !admitted[key] && len(admitted) < l.limit && !l.overflow[key] {
+			admitted[key] = true
+		}
+		if admitted[key] {
+			ch <- prometheus.MustNewConstMetric(desc, valueType, groupCosts[key], values...)
+			continue
+		}
+
+		if !l.overflow[key] {
+			l.overflow[key] = true
+			l.folded.WithLabelValues(cloud).Inc()
+		}
+		overflowValues := make([]string, len(values))
+		for i, name := range l.labels {
+			switch name {
+			case "cloud", "currency":
+				// kept, the overflow is summed up per currency
+				overflowValues[i] = values[i]
+			case "account", "service":
+				overflowValues[i] = overflowLabelValue
+			}
+		}
+		overflowKey := strings.Join(overflowValues, "\x00")
+		overflow[overflowKey] = overflowValues
+		overflowCosts[overflowKey] += groupCosts[key]
+	}
+
+	for key, values := range overflow {
+		ch <- prometheus.MustNewConstMetric(desc, valueType, overflowCosts[key], values...)
+	}
+}
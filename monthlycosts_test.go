@@ -0,0 +1,131 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+type monthlyCostsTestCollector struct {
+	*monthlyCostsCollector
+	costs *prometheus.CounterVec
+}
+
+func (c monthlyCostsTestCollector) Collect(ch chan<- prometheus.Metric) {
+	c.collect(c.costs, ch)
+}
+
+func TestMonthlyCostsSeriesLimit(t *testing.T) {
+	costs := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cloud_billing_monthly_costs",
+		Help: "Billed costs per calendar month.",
+	}, billing.MonthlyCostsLabels)
+	c := monthlyCostsTestCollector{monthlyCostsCollector: newMonthlyCostsCollector(2), costs: costs}
+
+	costs.WithLabelValues("aws", "USD", "acme-dev", "AmazonEC2", "", "jane", "", "").Add(10)
+	costs.WithLabelValues("aws", "USD", "acme-dev", "AmazonS3", "", "jane", "", "").Add(1)
+	costs.WithLabelValues("gcp", "USD", "project-a", "Compute Engine", "", "", "", "").Add(5)
+
+	exp := `
+# HELP cloud_billing_monthly_costs Billed costs per calendar month.
+# TYPE cloud_billing_monthly_costs counter
+cloud_billing_monthly_costs{account="acme-dev",cloud="aws",cost_centre="",currency="USD",owner="jane",path="",service="AmazonEC2",type=""} 10
+cloud_billing_monthly_costs{account="acme-dev",cloud="aws",cost_centre="",currency="USD",owner="jane",path="",service="AmazonS3",type=""} 1
+cloud_billing_monthly_costs{account="project-a",cloud="gcp",cost_centre="",currency="USD",owner="",path="",service="Compute Engine",type=""} 5
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(exp), "cloud_billing_monthly_costs"); err != nil {
+		t.Errorf("unexpected metrics: %s", err)
+	}
+
+	// further series of aws are folded into the overflow, the admitted
+	// series keep being exposed
+	costs.WithLabelValues("aws", "USD", "acme-prod", "AmazonEC2", "", "", "", "").Add(20)
+	costs.WithLabelValues("aws", "USD", "acme-prod", "AmazonRDS", "", "", "", "").Add(2)
+	costs.WithLabelValues("aws", "USD", "acme-dev", "AmazonS3", "", "jane", "", "").Add(1)
+
+	exp = `
+# HELP cloud_billing_monthly_costs Billed costs per calendar month.
+# TYPE cloud_billing_monthly_costs counter
+cloud_billing_monthly_costs{account="acme-dev",cloud="aws",cost_centre="",currency="USD",owner="jane",path="",service="AmazonEC2",type=""} 10
+cloud_billing_monthly_costs{account="acme-dev",cloud="aws",cost_centre="",currency="USD",owner="jane",path="",service="AmazonS3",type=""} 2
+cloud_billing_monthly_costs{account="overflow",cloud="aws",cost_centre="",currency="USD",owner="",path="",service="overflow",type=""} 22
+cloud_billing_monthly_costs{account="project-a",cloud="gcp",cost_centre="",currency="USD",owner="",path="",service="Compute Engine",type=""} 5
+# HELP cloud_billing_overflow_series_total Number of monthly costs series folded into the overflow series, as the series limit is exceeded.
+# TYPE cloud_billing_overflow_series_total counter
+cloud_billing_overflow_series_total{cloud="aws"} 2
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(exp)); err != nil {
+		t.Errorf("unexpected metrics: %s", err)
+	}
+	// folded series are only counted once
+	if err := testutil.CollectAndCompare(c, strings.NewReader(exp)); err != nil {
+		t.Errorf("unexpected metrics: %s", err)
+	}
+}
+
+func TestMonthlyCostsGroupBy(t *testing.T) {
+	costs := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cloud_billing_monthly_costs",
+		Help: "Billed costs per calendar month.",
+	}, billing.MonthlyCostsLabels)
+	c := monthlyCostsTestCollector{monthlyCostsCollector: newMonthlyCostsCollector(0), costs: costs}
+	if err := c.withGroupBy([]string{"unknown"}); err == nil {
+		t.Error("expected error for unknown label")
+	}
+	if err := c.withGroupBy([]string{"service"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	costs.WithLabelValues("aws", "USD", "acme-dev", "AmazonEC2", "", "jane", "", "").Add(10)
+	costs.WithLabelValues("aws", "USD", "acme-prod", "AmazonEC2", "", "joe", "", "").Add(20)
+	costs.WithLabelValues("aws", "EUR", "acme-prod", "AmazonEC2", "", "joe", "", "").Add(3)
+
+	exp := `
+# HELP cloud_billing_monthly_costs Billed costs per calendar month.
+# TYPE cloud_billing_monthly_costs counter
+cloud_billing_monthly_costs{cloud="aws",currency="EUR",service="AmazonEC2"} 3
+cloud_billing_monthly_costs{cloud="aws",currency="USD",service="AmazonEC2"} 30
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(exp), "cloud_billing_monthly_costs"); err != nil {
+		t.Errorf("unexpected metrics: %s", err)
+	}
+}
+
+type monthlyCostsCurrentTestCollector struct {
+	*monthlyCostsCollector
+	records []billing.Record
+}
+
+func (c monthlyCostsCurrentTestCollector) Collect(ch chan<- prometheus.Metric) {
+	c.collectCurrent(c.records, ch)
+}
+
+func TestMonthlyCostsCurrent(t *testing.T) {
+	c := monthlyCostsCurrentTestCollector{monthlyCostsCollector: newMonthlyCostsCollector(0)}
+	if err := c.withGroupBy([]string{"account"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c.records = []billing.Record{
+		{Cloud: "gcp", Currency: "USD", Account: "project-a", Service: "Compute Engine", Costs: 5},
+		{Cloud: "gcp", Currency: "USD", Account: "project-a", Service: "Cloud Storage", Costs: 1},
+	}
+	exp := `
+# HELP cloud_billing_monthly_costs_current Month-to-date costs of the latest billing report, which decrease when costs are corrected or credited.
+# TYPE cloud_billing_monthly_costs_current gauge
+cloud_billing_monthly_costs_current{account="project-a",cloud="gcp",currency="USD"} 6
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(exp)); err != nil {
+		t.Errorf("unexpected metrics: %s", err)
+	}
+
+	// credits lower the month-to-date costs of the latest report
+	c.records[0].Costs = 3.5
+	if err := testutil.CollectAndCompare(c, strings.NewReader(strings.Replace(exp, "} 6", "} 4.5", 1))); err != nil {
+		t.Errorf("unexpected metrics: %s", err)
+	}
+}
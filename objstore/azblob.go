@@ -0,0 +1,281 @@
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// azureStorageVersion is the version of the blob service REST API
+const azureStorageVersion = "2019-12-12"
+
+// azureBlobContainer reads and writes the blobs of an Azure Storage Account
+// container through the REST API. The requests are authorized by the SAS
+// token in AZURE_STORAGE_SAS_TOKEN or signed with the account key in
+// AZURE_STORAGE_KEY.
+type azureBlobContainer struct {
+	client    *http.Client
+	endpoint  *url.URL
+	account   string
+	container string
+	prefix    string
+
+	sasToken url.Values
+	key      []byte
+
+	now func() time.Time
+}
+
+// newAzureBlobContainer creates a container from an URL like
+// azblob://account/container/prefix, the endpoint parameter overrides the
+// blob service endpoint, e.g. of other clouds or the storage emulator
+func newAzureBlobContainer(u *url.URL) (*azureBlobContainer, error) {
+	parts := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2)
+	if u.Host == "" || parts[0] == "" {
+		return nil, fmt.Errorf("invalid Azure blob URL '%s', expected azblob://account/container/prefix", u)
+	}
+	c := &azureBlobContainer{
+		client:    http.DefaultClient,
+		account:   u.Host,
+		container: parts[0],
+		now:       time.Now,
+	}
+	if len(parts) > 1 {
+		c.prefix = parts[1]
+	}
+
+	endpoint := fmt.Sprintf("https://%s.blob.core.windows.net", u.Host)
+	if e := u.Query().Get("endpoint"); e != "" {
+		endpoint = e
+	}
+	var err error
+	if c.endpoint, err = url.Parse(strings.TrimSuffix(endpoint, "/")); err != nil {
+		return nil, fmt.Errorf("invalid Azure blob endpoint '%s': %s", endpoint, err)
+	}
+
+	if token := os.Getenv("AZURE_STORAGE_SAS_TOKEN"); token != "" {
+		if c.sasToken, err = url.ParseQuery(strings.TrimPrefix(token, "?")); err != nil {
+			return nil, fmt.Errorf("invalid SAS token in AZURE_STORAGE_SAS_TOKEN: %s", err)
+		}
+	} else if key := os.Getenv("AZURE_STORAGE_KEY"); key != "" {
+		if c.key, err = base64.StdEncoding.DecodeString(key); err != nil {
+			return nil, fmt.Errorf("invalid account key in AZURE_STORAGE_KEY: %s", err)
+		}
+	} else {
+		return nil, fmt.Errorf("no credentials for %s, set AZURE_STORAGE_SAS_TOKEN or AZURE_STORAGE_KEY", c)
+	}
+	return c, nil
+}
+
+func (c *azureBlobContainer) blob(name string) string {
+	return path.Join(c.prefix, name)
+}
+
+// do sends a request to the container or the given blob of it
+func (c *azureBlobContainer) do(ctx context.Context, method, blob string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u := *c.endpoint
+	u.Path = path.Join(u.Path, c.container, blob)
+	if query == nil {
+		query = url.Values{}
+	}
+	for k, v := range c.sasToken {
+		query[k] = v
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("x-ms-date", c.now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", azureStorageVersion)
+	if c.key != nil {
+		req.Header.Set("Authorization", fmt.Sprintf("SharedKey %s:%s", c.account, c.signature(req, int64(len(body)))))
+	}
+	return c.client.Do(req)
+}
+
+// signature signs the request with the account key, see
+// https://docs.microsoft.com/en-us/rest/api/storageservices/authorize-with-shared-key
+func (c *azureBlobContainer) signature(req *http.Request, contentLength int64) string {
+	length := ""
+	if contentLength > 0 {
+		length = strconv.FormatInt(contentLength, 10)
+	}
+	lines := []string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		length,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // the date is sent as x-ms-date
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+	}
+
+	var headers []string
+	for k, v := range req.Header {
+		if k = strings.ToLower(k); strings.HasPrefix(k, "x-ms-") {
+			headers = append(headers, k+":"+strings.TrimSpace(strings.Join(v, ",")))
+		}
+	}
+	sort.Strings(headers)
+	lines = append(lines, headers...)
+
+	resource := "/" + c.account + req.URL.EscapedPath()
+	query := req.URL.Query()
+	names := make([]string, 0, len(query))
+	for k := range query {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		resource += "\n" + strings.ToLower(k) + ":" + strings.Join(values, ",")
+	}
+	lines = append(lines, resource)
+
+	mac := hmac.New(sha256.New, c.key)
+	_, _ = io.WriteString(mac, strings.Join(lines, "\n"))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// azureError returns the error of a failed request
+func azureError(resp *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	var e struct {
+		Code    string
+		Message string
+	}
+	if xml.Unmarshal(body, &e) == nil && e.Code != "" {
+		return fmt.Errorf("%s: %s", e.Code, strings.SplitN(e.Message, "\n", 2)[0])
+	}
+	return fmt.Errorf("unexpected status %s", resp.Status)
+}
+
+func (c *azureBlobContainer) Get(ctx context.Context, name string) ([]byte, error) {
+	resp, err := c.do(ctx, http.MethodGet, c.blob(name), nil, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %s", c.url(c.blob(name)), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotExist
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error reading %s: %s", c.url(c.blob(name)), azureError(resp))
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (c *azureBlobContainer) Put(ctx context.Context, name string, data []byte, contentType string) error {
+	header := http.Header{}
+	header.Set("Content-Type", contentType)
+	header.Set("x-ms-blob-type", "BlockBlob")
+	resp, err := c.do(ctx, http.MethodPut, c.blob(name), nil, header, data)
+	if err != nil {
+		return fmt.Errorf("error writing %s: %s", c.url(c.blob(name)), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("error writing %s: %s", c.url(c.blob(name)), azureError(resp))
+	}
+	return nil
+}
+
+// azureBlobList is the response of the List Blobs operation
+type azureBlobList struct {
+	Blobs []struct {
+		Name       string
+		Properties struct {
+			LastModified  string `xml:"Last-Modified"`
+			Etag          string
+			ContentLength int64  `xml:"Content-Length"`
+			ContentMD5    string `xml:"Content-MD5"`
+		}
+	} `xml:"Blobs>Blob"`
+	NextMarker string
+}
+
+func (c *azureBlobContainer) List(ctx context.Context, prefix string) ([]Object, error) {
+	base := c.prefix
+	if base != "" && !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+
+	var objects []Object
+	marker := ""
+	for {
+		query := url.Values{
+			"restype": {"container"},
+			"comp":    {"list"},
+			"prefix":  {base + prefix},
+		}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+		resp, err := c.do(ctx, http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error listing %s: %s", c.url(base+prefix), err)
+		}
+		var list azureBlobList
+		if resp.StatusCode != http.StatusOK {
+			err = azureError(resp)
+		} else {
+			err = xml.NewDecoder(resp.Body).Decode(&list)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error listing %s: %s", c.url(base+prefix), err)
+		}
+
+		for _, b := range list.Blobs {
+			// blobs uploaded in blocks have no MD5, but a new ETag on change
+			hash := b.Properties.ContentMD5
+			if hash == "" {
+				hash = strings.Trim(b.Properties.Etag, `"`)
+			}
+			modified, _ := time.Parse(http.TimeFormat, b.Properties.LastModified)
+			objects = append(objects, Object{
+				Name:     strings.TrimPrefix(b.Name, base),
+				Hash:     hash,
+				Size:     b.Properties.ContentLength,
+				Modified: modified,
+			})
+		}
+		if list.NextMarker == "" {
+			return objects, nil
+		}
+		marker = list.NextMarker
+	}
+}
+
+func (c *azureBlobContainer) url(blob string) string {
+	return fmt.Sprintf("azblob://%s/%s/%s", c.account, c.container, blob)
+}
+
+func (c *azureBlobContainer) String() string {
+	return c.url(c.prefix)
+}
@@ -0,0 +1,115 @@
+package objstore
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+// fakeBlobService serves the blobs of the container costs, listed in pages of
+// one blob
+func fakeBlobService(blobs map[string]string, authorized func(*http.Request) bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r) {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><Error><Code>AuthenticationFailed</Code><Message>Server failed to authenticate the request.
+RequestId:1</Message></Error>`)
+			return
+		}
+		if r.URL.Path == "/costs" && r.URL.Query().Get("comp") == "list" {
+			names := []string{"exports/a.csv", "exports/b.csv"}
+			i := 0
+			if r.URL.Query().Get("marker") == "b" {
+				i = 1
+			}
+			next := ""
+			if i == 0 {
+				next = "b"
+			}
+			fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Blobs><Blob><Name>%s</Name><Properties><Last-Modified>Sat, 02 Nov 2019 06:00:00 GMT</Last-Modified><Etag>"0x8D7%d"</Etag><Content-Length>%d</Content-Length></Properties></Blob></Blobs><NextMarker>%s</NextMarker></EnumerationResults>`, names[i], i, len(blobs[names[i]]), next)
+			return
+		}
+		data, ok := blobs[strings.TrimPrefix(r.URL.Path, "/costs/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, data)
+	}))
+}
+
+func TestAzureBlobContainer(t *testing.T) {
+	blobs := map[string]string{"exports/a.csv": "a", "exports/b.csv": "bb"}
+	server := fakeBlobService(blobs, func(r *http.Request) bool {
+		return r.Header.Get("x-ms-version") != "" && strings.HasPrefix(r.Header.Get("Authorization"), "SharedKey account:")
+	})
+	defer server.Close()
+
+	os.Setenv("AZURE_STORAGE_KEY", "a2V5")
+	defer os.Unsetenv("AZURE_STORAGE_KEY")
+	u, _ := url.Parse("azblob://account/costs/exports?endpoint=" + url.QueryEscape(server.URL))
+	c, err := newAzureBlobContainer(u)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	objects, err := c.List(context.Background(), "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(objects) != 2 || objects[0].Name != "a.csv" || objects[1].Name != "b.csv" || objects[1].Size != 2 || objects[1].Hash != "0x8D71" || objects[1].Modified.Day() != 2 {
+		t.Errorf("unexpected objects: %+v", objects)
+	}
+
+	if data, err := c.Get(context.Background(), "b.csv"); err != nil || string(data) != "bb" {
+		t.Errorf("unexpected blob: '%s', %v", data, err)
+	}
+	if _, err := c.Get(context.Background(), "c.csv"); err != ErrNotExist {
+		t.Errorf("unexpected error of a missing blob: %v", err)
+	}
+	if c.String() != "azblob://account/costs/exports" {
+		t.Errorf("unexpected name: %s", c)
+	}
+}
+
+func TestAzureBlobContainerSASToken(t *testing.T) {
+	server := fakeBlobService(map[string]string{}, func(r *http.Request) bool {
+		return r.URL.Query().Get("sig") == "secret" && r.Header.Get("Authorization") == ""
+	})
+	defer server.Close()
+
+	os.Setenv("AZURE_STORAGE_SAS_TOKEN", "?sv=2019-12-12&sig=secret")
+	defer os.Unsetenv("AZURE_STORAGE_SAS_TOKEN")
+	u, _ := url.Parse("azblob://account/costs?endpoint=" + url.QueryEscape(server.URL))
+	c, err := newAzureBlobContainer(u)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := c.List(context.Background(), ""); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	os.Setenv("AZURE_STORAGE_SAS_TOKEN", "?sv=2019-12-12&sig=invalid")
+	if c, err = newAzureBlobContainer(u); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := c.List(context.Background(), ""); err == nil || !strings.Contains(err.Error(), "AuthenticationFailed: Server failed to authenticate the request.") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestAzureBlobContainerCredentials(t *testing.T) {
+	u, _ := url.Parse("azblob://account/costs")
+	if _, err := newAzureBlobContainer(u); err == nil {
+		t.Error("expected an error without credentials")
+	}
+	u, _ = url.Parse("azblob://account")
+	if _, err := newAzureBlobContainer(u); err == nil {
+		t.Error("expected an error without container")
+	}
+}
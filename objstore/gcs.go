@@ -0,0 +1,103 @@
+package objstore
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+type gcsBucket struct {
+	bucket *storage.BucketHandle
+	name   string
+	prefix string
+}
+
+func newGCSBucket(ctx context.Context, u *url.URL) (*gcsBucket, error) {
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %v", err)
+	}
+
+	bucket := client.Bucket(u.Host)
+	if project := u.Query().Get("user-project"); project != "" {
+		// bills the requests to Requester Pays buckets to the project
+		bucket = bucket.UserProject(project)
+	}
+
+	return &gcsBucket{
+		bucket: bucket,
+		name:   u.Host,
+		prefix: strings.TrimPrefix(u.Path, "/"),
+	}, nil
+}
+
+func (s *gcsBucket) object(name string) string {
+	return path.Join(s.prefix, name)
+}
+
+func (s *gcsBucket) Get(ctx context.Context, name string) ([]byte, error) {
+	reader, err := s.bucket.Object(s.object(name)).NewReader(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, ErrNotExist
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading gs://%s/%s: %s", s.name, s.object(name), err)
+	}
+	defer reader.Close()
+
+	return ioutil.ReadAll(reader)
+}
+
+func (s *gcsBucket) Put(ctx context.Context, name string, data []byte, contentType string) error {
+	w := s.bucket.Object(s.object(name)).NewWriter(ctx)
+	w.ContentType = contentType
+	if _, err := w.Write(data); err != nil {
+		_ = w.Close()
+		return fmt.Errorf("error writing gs://%s/%s: %s", s.name, s.object(name), err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("error writing gs://%s/%s: %s", s.name, s.object(name), err)
+	}
+	return nil
+}
+
+func (s *gcsBucket) List(ctx context.Context, prefix string) ([]Object, error) {
+	base := s.prefix
+	if base != "" && !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+
+	var objects []Object
+	it := s.bucket.Objects(ctx, &storage.Query{Prefix: base + prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("error listing gs://%s/%s: %s", s.name, base+prefix, err)
+		}
+		// composite objects have no MD5, but get a new generation on change
+		hash := hex.EncodeToString(attrs.MD5)
+		if hash == "" {
+			hash = strconv.FormatInt(attrs.Generation, 10)
+		}
+		objects = append(objects, Object{
+			Name:     strings.TrimPrefix(attrs.Name, base),
+			Hash:     hash,
+			Size:     attrs.Size,
+			Modified: attrs.Updated,
+		})
+	}
+	return objects, nil
+}
+
+func (s *gcsBucket) String() string {
+	return fmt.Sprintf("gs://%s/%s", s.name, s.prefix)
+}
@@ -0,0 +1,60 @@
+package objstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// ErrNotExist is returned by a Bucket if an object doesn't exist
+var ErrNotExist = errors.New("object doesn't exist")
+
+// Bucket reads and writes objects in an object storage bucket, below a
+// common prefix
+type Bucket interface {
+	Get(ctx context.Context, name string) ([]byte, error)
+	Put(ctx context.Context, name string, data []byte, contentType string) error
+	String() string
+}
+
+// Object describes an object in a bucket, Name is relative to the bucket's
+// prefix and Hash changes with the object's content
+type Object struct {
+	Name     string
+	Hash     string
+	Size     int64
+	Modified time.Time
+}
+
+// Lister is implemented by buckets which can list their objects
+type Lister interface {
+	List(ctx context.Context, prefix string) ([]Object, error)
+}
+
+// New creates a Bucket from an URL. Supported schemes are s3://bucket/prefix,
+// gs://bucket/prefix and azblob://account/container/prefix. Requester Pays
+// buckets are accessed with the requester-pays=true parameter on S3 and
+// user-project=<project> on GCS.
+func New(ctx context.Context, u *url.URL) (Bucket, error) {
+	switch u.Scheme {
+	case "s3":
+		return newS3Bucket(u)
+	case "gs":
+		return newGCSBucket(ctx, u)
+	case "azblob":
+		return newAzureBlobContainer(u)
+	default:
+		return nil, fmt.Errorf("unsupported object storage scheme '%s'", u.Scheme)
+	}
+}
+
+// Parse parses the URL and creates a Bucket from it
+func Parse(ctx context.Context, bucketURL string) (Bucket, error) {
+	u, err := url.Parse(bucketURL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing object storage URL '%s': %s", bucketURL, err)
+	}
+	return New(ctx, u)
+}
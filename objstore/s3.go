@@ -0,0 +1,110 @@
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+type s3Bucket struct {
+	svc    *s3.S3
+	bucket string
+	prefix string
+
+	// requestPayer is set for Requester Pays buckets
+	requestPayer *string
+}
+
+func newS3Bucket(u *url.URL) (*s3Bucket, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	config := &aws.Config{}
+	if region := u.Query().Get("region"); region != "" {
+		config.Region = aws.String(region)
+	}
+
+	b := &s3Bucket{
+		svc:    s3.New(sess, config),
+		bucket: u.Host,
+		prefix: strings.TrimPrefix(u.Path, "/"),
+	}
+	if u.Query().Get("requester-pays") == "true" {
+		b.requestPayer = aws.String(s3.RequestPayerRequester)
+	}
+	return b, nil
+}
+
+func (s *s3Bucket) key(name string) string {
+	return path.Join(s.prefix, name)
+}
+
+func (s *s3Bucket) Get(ctx context.Context, name string) ([]byte, error) {
+	resp, err := s.svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket:       aws.String(s.bucket),
+		Key:          aws.String(s.key(name)),
+		RequestPayer: s.requestPayer,
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		return nil, ErrNotExist
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading s3://%s/%s: %s", s.bucket, s.key(name), err)
+	}
+	defer resp.Body.Close()
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (s *s3Bucket) Put(ctx context.Context, name string, data []byte, contentType string) error {
+	if _, err := s.svc.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:       aws.String(s.bucket),
+		Key:          aws.String(s.key(name)),
+		Body:         bytes.NewReader(data),
+		ContentType:  aws.String(contentType),
+		RequestPayer: s.requestPayer,
+	}); err != nil {
+		return fmt.Errorf("error writing s3://%s/%s: %s", s.bucket, s.key(name), err)
+	}
+	return nil
+}
+
+func (s *s3Bucket) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	base := s.prefix
+	if base != "" && !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	if err := s.svc.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket:       aws.String(s.bucket),
+		Prefix:       aws.String(base + prefix),
+		RequestPayer: s.requestPayer,
+	}, func(resp *s3.ListObjectsV2Output, _ bool) bool {
+		for _, o := range resp.Contents {
+			objects = append(objects, Object{
+				Name:     strings.TrimPrefix(aws.StringValue(o.Key), base),
+				Hash:     strings.Trim(aws.StringValue(o.ETag), `"`),
+				Size:     aws.Int64Value(o.Size),
+				Modified: aws.TimeValue(o.LastModified),
+			})
+		}
+		return true
+	}); err != nil {
+		return nil, fmt.Errorf("error listing s3://%s/%s: %s", s.bucket, base+prefix, err)
+	}
+	return objects, nil
+}
+
+func (s *s3Bucket) String() string {
+	return fmt.Sprintf("s3://%s/%s", s.bucket, s.prefix)
+}
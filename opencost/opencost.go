@@ -0,0 +1,153 @@
+// Package opencost queries the allocation API of OpenCost or Kubecost for the
+// costs of Kubernetes workloads, see https://www.opencost.io/docs/api
+package opencost
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// allocationPath is the path of the OpenCost allocation API, Kubecost serves
+// it below /model/allocation
+const allocationPath = "/allocation/compute"
+
+// Allocation contains the costs of a workload in the queried window
+type Allocation struct {
+	Cluster        string
+	Namespace      string
+	ControllerKind string
+	Controller     string
+
+	// Costs per resource, e.g. cpu, ram, gpu, pv, network or loadbalancer
+	Costs map[string]float64
+}
+
+// Client queries the allocation API
+type Client struct {
+	url        *url.URL
+	httpClient *http.Client
+}
+
+// NewClient returns a client for the allocation API at the given URL. If the
+// URL has no path the OpenCost allocation path is used, for Kubecost the full
+// URL needs to be given, e.g. http://kubecost-cost-analyzer:9090/model/allocation
+func NewClient(apiURL string) (*Client, error) {
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing URL '%s': %s", apiURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme '%s' in URL '%s'", u.Scheme, apiURL)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = allocationPath
+	}
+	return &Client{
+		url:        u,
+		httpClient: &http.Client{Timeout: time.Minute},
+	}, nil
+}
+
+// allocationResponse is the response of the allocation API, data contains a
+// set of allocations per step of the window
+type allocationResponse struct {
+	Code    int                             `json:"code"`
+	Message string                          `json:"message"`
+	Data    []map[string]allocationResource `json:"data"`
+}
+
+type allocationResource struct {
+	Name       string `json:"name"`
+	Properties struct {
+		Cluster        string `json:"cluster"`
+		Namespace      string `json:"namespace"`
+		ControllerKind string `json:"controllerKind"`
+		Controller     string `json:"controller"`
+	} `json:"properties"`
+	CPUCost          float64 `json:"cpuCost"`
+	GPUCost          float64 `json:"gpuCost"`
+	RAMCost          float64 `json:"ramCost"`
+	PVCost           float64 `json:"pvCost"`
+	NetworkCost      float64 `json:"networkCost"`
+	LoadBalancerCost float64 `json:"loadBalancerCost"`
+	SharedCost       float64 `json:"sharedCost"`
+	ExternalCost     float64 `json:"externalCost"`
+}
+
+// MonthToDate returns the allocated costs of the current month per workload
+func (c *Client) MonthToDate(ctx context.Context) ([]Allocation, error) {
+	u := *c.url
+	q := u.Query()
+	q.Set("window", "month")
+	q.Set("aggregate", "cluster,namespace,controllerKind,controller")
+	q.Set("accumulate", "true")
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, c)
+	}
+
+	var r allocationResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("error decoding response from %s: %s", c, err)
+	}
+	if r.Code != 0 && r.Code != http.StatusOK {
+		return nil, fmt.Errorf("error response from %s: %d %s", c, r.Code, r.Message)
+	}
+
+	byWorkload := make(map[string]*Allocation)
+	var allocations []*Allocation
+	for _, set := range r.Data {
+		for _, a := range set {
+			// unallocated and idle costs are not attributed to a workload
+			if strings.HasPrefix(a.Name, "__") {
+				continue
+			}
+			p := a.Properties
+			key := strings.Join([]string{p.Cluster, p.Namespace, p.ControllerKind, p.Controller}, "\x00")
+			if _, ok := byWorkload[key]; !ok {
+				byWorkload[key] = &Allocation{
+					Cluster:        p.Cluster,
+					Namespace:      p.Namespace,
+					ControllerKind: p.ControllerKind,
+					Controller:     p.Controller,
+					Costs:          make(map[string]float64),
+				}
+				allocations = append(allocations, byWorkload[key])
+			}
+			costs := byWorkload[key].Costs
+			costs["cpu"] += a.CPUCost
+			costs["gpu"] += a.GPUCost
+			costs["ram"] += a.RAMCost
+			costs["pv"] += a.PVCost
+			costs["network"] += a.NetworkCost
+			costs["loadbalancer"] += a.LoadBalancerCost
+			costs["shared"] += a.SharedCost
+			costs["external"] += a.ExternalCost
+		}
+	}
+
+	result := make([]Allocation, 0, len(allocations))
+	for _, a := range allocations {
+		result = append(result, *a)
+	}
+	return result, nil
+}
+
+func (c *Client) String() string {
+	return fmt.Sprintf("OpenCost allocation API at %s", c.url)
+}
@@ -0,0 +1,82 @@
+package opencost
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+const allocationJSON = `{
+  "code": 200,
+  "data": [
+    {
+      "prod/monitoring/deployment/prometheus": {
+        "name": "prod/monitoring/deployment/prometheus",
+        "properties": {"cluster": "prod", "namespace": "monitoring", "controllerKind": "deployment", "controller": "prometheus"},
+        "cpuCost": 12.5,
+        "ramCost": 7.25,
+        "pvCost": 1
+      },
+      "__idle__": {
+        "name": "__idle__",
+        "properties": {"cluster": "prod"},
+        "cpuCost": 100
+      }
+    }
+  ]
+}`
+
+func TestMonthToDate(t *testing.T) {
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/allocation/compute" {
+			http.NotFound(w, r)
+			return
+		}
+		query = r.URL.RawQuery
+		_, _ = w.Write([]byte(allocationJSON))
+	}))
+	defer server.Close()
+
+	c, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	allocations, err := c.MonthToDate(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	exp := []Allocation{{
+		Cluster:        "prod",
+		Namespace:      "monitoring",
+		ControllerKind: "deployment",
+		Controller:     "prometheus",
+		Costs: map[string]float64{
+			"cpu": 12.5, "ram": 7.25, "pv": 1,
+			"gpu": 0, "network": 0, "loadbalancer": 0, "shared": 0, "external": 0,
+		},
+	}}
+	if !reflect.DeepEqual(allocations, exp) {
+		t.Errorf("unexpected allocations: act: %+v, exp: %+v", allocations, exp)
+	}
+	if exp := "accumulate=true&aggregate=cluster%2Cnamespace%2CcontrollerKind%2Ccontroller&window=month"; query != exp {
+		t.Errorf("unexpected query: %s", query)
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	c, err := NewClient("http://kubecost-cost-analyzer:9090/model/allocation")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if exp := "/model/allocation"; c.url.Path != exp {
+		t.Errorf("unexpected path: %s", c.url.Path)
+	}
+
+	if _, err := NewClient("ftp://opencost"); err == nil {
+		t.Error("expected error for unsupported scheme")
+	}
+}
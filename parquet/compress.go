@@ -0,0 +1,111 @@
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// compression codecs of the column chunks
+const (
+	codecUncompressed = 0
+	codecSnappy       = 1
+	codecGzip         = 2
+	codecZstd         = 6
+)
+
+// decompress decompresses a page with the codec to its uncompressed size
+func decompress(codec int64, data []byte, size int) ([]byte, error) {
+	switch codec {
+	case codecUncompressed:
+		return data, nil
+	case codecSnappy:
+		return snappyDecode(data, size)
+	case codecGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(io.LimitReader(r, int64(size)))
+	case codecZstd:
+		return zstdDecode(data, size)
+	}
+	return nil, fmt.Errorf("compression codec %d is not supported", codec)
+}
+
+// snappyDecode decodes a snappy block, which is expected to be of the given
+// size
+func snappyDecode(src []byte, size int) ([]byte, error) {
+	n, pos := binary.Uvarint(src)
+	if pos <= 0 {
+		return nil, fmt.Errorf("invalid snappy block length")
+	}
+	if n != uint64(size) {
+		return nil, fmt.Errorf("snappy block of %d bytes, expected %d", n, size)
+	}
+	dst := make([]byte, 0, size)
+	for pos < len(src) {
+		tag := src[pos]
+		var length, offset int
+		switch tag & 0x03 {
+		case 0x00:
+			// literal, longer lengths follow in 1 to 4 bytes
+			length = int(tag >> 2)
+			pos++
+			if length >= 60 {
+				k := length - 59
+				if pos+k > len(src) {
+					return nil, io.ErrUnexpectedEOF
+				}
+				length = 0
+				for i := 0; i < k; i++ {
+					length |= int(src[pos+i]) << (8 * uint(i))
+				}
+				pos += k
+			}
+			length++
+			if length <= 0 || length > len(src)-pos || length > size-len(dst) {
+				return nil, fmt.Errorf("invalid snappy literal of %d bytes", length)
+			}
+			dst = append(dst, src[pos:pos+length]...)
+			pos += length
+			continue
+		case 0x01:
+			if pos+2 > len(src) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			length = 4 + int(tag>>2&0x07)
+			offset = int(tag&0xe0)<<3 | int(src[pos+1])
+			pos += 2
+		case 0x02:
+			if pos+3 > len(src) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[pos+1:]))
+			pos += 3
+		case 0x03:
+			if pos+5 > len(src) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[pos+1:]))
+			pos += 5
+		}
+		if offset <= 0 || offset > len(dst) || length > size-len(dst) {
+			return nil, fmt.Errorf("invalid snappy copy of %d bytes at offset %d", length, offset)
+		}
+		// copies may overlap the bytes they produce
+		for i := 0; i < length; i++ {
+			dst = append(dst, dst[len(dst)-offset])
+		}
+	}
+	if len(dst) != size {
+		return nil, fmt.Errorf("snappy block decoded to %d bytes, expected %d", len(dst), size)
+	}
+	return dst, nil
+}
@@ -0,0 +1,84 @@
+package parquet
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSnappyDecode(t *testing.T) {
+	for name, tc := range map[string]struct {
+		block    string
+		size     int
+		expected string
+		err      string
+	}{
+		"literal": {
+			block:    "\x05\x10hello",
+			size:     5,
+			expected: "hello",
+		},
+		"long literal": {
+			block:    "\x41\xf0\x40" + strings.Repeat("a", 65),
+			size:     65,
+			expected: strings.Repeat("a", 65),
+		},
+		"copy with 1 byte offset": {
+			// 3 literals, then 6 bytes copied from offset 3
+			block:    "\x09\x08abc\x09\x03",
+			size:     9,
+			expected: "abcabcabc",
+		},
+		"overlapping copy with 2 byte offset": {
+			block:    "\x0a\x00a\x22\x01\x00",
+			size:     10,
+			expected: strings.Repeat("a", 10),
+		},
+		"copy with 4 byte offset": {
+			block:    "\x04\x04ab\x07\x02\x00\x00\x00",
+			size:     4,
+			expected: "abab",
+		},
+		"wrong size": {
+			block: "\x05\x10hello",
+			size:  6,
+			err:   "snappy block of 5 bytes, expected 6",
+		},
+		"invalid offset": {
+			block: "\x09\x08abc\x09\x04",
+			size:  9,
+			err:   "invalid snappy copy",
+		},
+		"truncated literal": {
+			block: "\x05\x10hel",
+			size:  5,
+			err:   "invalid snappy literal",
+		},
+		"truncated block": {
+			block: "\x09\x08abc",
+			size:  9,
+			err:   "snappy block decoded to 3 bytes, expected 9",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			data, err := snappyDecode([]byte(tc.block), tc.size)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error containing %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if string(data) != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, data)
+			}
+		})
+	}
+}
+
+func TestDecompressUnsupported(t *testing.T) {
+	if _, err := decompress(4, []byte("brotli"), 6); err == nil || err.Error() != "compression codec 4 is not supported" {
+		t.Errorf("expected unsupported codec, got %v", err)
+	}
+}
@@ -0,0 +1,246 @@
+package parquet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// physical types of the columns
+const (
+	typeBoolean           = 0
+	typeInt32             = 1
+	typeInt64             = 2
+	typeInt96             = 3
+	typeFloat             = 4
+	typeDouble            = 5
+	typeByteArray         = 6
+	typeFixedLenByteArray = 7
+)
+
+// encodings of the values and levels
+const (
+	encodingPlain           = 0
+	encodingPlainDictionary = 2
+	encodingRLE             = 3
+	encodingRLEDictionary   = 8
+)
+
+// converted types of the columns, which describe the logical types of files
+// written by older writers
+const (
+	convertedDecimal         = 5
+	convertedDate            = 6
+	convertedTimestampMillis = 9
+	convertedTimestampMicros = 10
+)
+
+// julianDayUnixEpoch is the Julian day of 1970-01-01, which INT96 timestamps
+// count the days from
+const julianDayUnixEpoch = 2440588
+
+// bitWidth returns the number of bits needed to encode values up to max
+func bitWidth(max int) int {
+	return bits.Len(uint(max))
+}
+
+// readHybrid decodes n values of the RLE/bit-packed hybrid encoding, which
+// is used by the levels and dictionary indices
+func readHybrid(data []byte, width, n int) ([]int, error) {
+	if width > 32 {
+		return nil, fmt.Errorf("invalid bit width %d", width)
+	}
+	values := make([]int, 0, n)
+	byteWidth := (width + 7) / 8
+	pos := 0
+	for len(values) < n {
+		header, m := binary.Uvarint(data[pos:])
+		if m <= 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		pos += m
+		if header>>1 > uint64(len(data)) && header&1 == 1 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		count := int(header >> 1)
+
+		if header&1 == 0 {
+			// run of a repeated value
+			if pos+byteWidth > len(data) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			var v int
+			for i := 0; i < byteWidth; i++ {
+				v |= int(data[pos+i]) << (8 * uint(i))
+			}
+			pos += byteWidth
+			for i := 0; i < count && len(values) < n; i++ {
+				values = append(values, v)
+			}
+			if count == 0 && pos >= len(data) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			continue
+		}
+
+		// groups of 8 values packed starting from the least significant bit
+		size := count * width
+		if size > len(data)-pos {
+			return nil, io.ErrUnexpectedEOF
+		}
+		for i := 0; i < count*8 && len(values) < n; i++ {
+			var v int
+			for b := 0; b < width; b++ {
+				bit := i*width + b
+				if data[pos+bit/8]>>(uint(bit)%8)&1 == 1 {
+					v |= 1 << uint(b)
+				}
+			}
+			values = append(values, v)
+		}
+		pos += size
+	}
+	return values, nil
+}
+
+// readLevels decodes n levels up to max, which are prefixed by their length
+// in data pages of version 1. It returns the levels and the remaining data.
+func readLevels(data []byte, max, n int, prefixed bool) ([]int, []byte, error) {
+	if max == 0 {
+		return make([]int, n), data, nil
+	}
+	length := len(data)
+	if prefixed {
+		if len(data) < 4 {
+			return nil, nil, io.ErrUnexpectedEOF
+		}
+		length = int(binary.LittleEndian.Uint32(data))
+		data = data[4:]
+		if length < 0 || length > len(data) {
+			return nil, nil, io.ErrUnexpectedEOF
+		}
+	}
+	levels, err := readHybrid(data[:length], bitWidth(max), n)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error decoding levels: %s", err)
+	}
+	return levels, data[length:], nil
+}
+
+// readPlain decodes n plain encoded values of the column as strings
+func (c *column) readPlain(data []byte, n int) ([]string, error) {
+	values := make([]string, 0, n)
+	size := 0
+	switch c.physical {
+	case typeBoolean:
+		if (n+7)/8 > len(data) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		for i := 0; i < n; i++ {
+			values = append(values, strconv.FormatBool(data[i/8]>>(uint(i)%8)&1 == 1))
+		}
+		return values, nil
+	case typeInt32, typeFloat:
+		size = 4
+	case typeInt64, typeDouble:
+		size = 8
+	case typeInt96:
+		size = 12
+	case typeFixedLenByteArray:
+		size = c.typeLength
+	case typeByteArray:
+		pos := 0
+		for i := 0; i < n; i++ {
+			if pos+4 > len(data) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			length := int(binary.LittleEndian.Uint32(data[pos:]))
+			pos += 4
+			if length < 0 || length > len(data)-pos {
+				return nil, io.ErrUnexpectedEOF
+			}
+			values = append(values, c.formatBytes(data[pos:pos+length]))
+			pos += length
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("physical type %d is not supported", c.physical)
+	}
+
+	if size <= 0 || n > len(data)/size {
+		return nil, io.ErrUnexpectedEOF
+	}
+	for i := 0; i < n; i++ {
+		v := data[i*size : (i+1)*size]
+		switch c.physical {
+		case typeInt32:
+			values = append(values, c.formatInt(int64(int32(binary.LittleEndian.Uint32(v)))))
+		case typeInt64:
+			values = append(values, c.formatInt(int64(binary.LittleEndian.Uint64(v))))
+		case typeInt96:
+			nanos := int64(binary.LittleEndian.Uint64(v))
+			days := int64(binary.LittleEndian.Uint32(v[8:])) - julianDayUnixEpoch
+			values = append(values, formatTime(time.Unix(days*86400, nanos)))
+		case typeFloat:
+			values = append(values, strconv.FormatFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(v))), 'g', -1, 32))
+		case typeDouble:
+			values = append(values, strconv.FormatFloat(math.Float64frombits(binary.LittleEndian.Uint64(v)), 'g', -1, 64))
+		case typeFixedLenByteArray:
+			values = append(values, c.formatBytes(v))
+		}
+	}
+	return values, nil
+}
+
+// formatInt formats integers by the logical type of the column
+func (c *column) formatInt(v int64) string {
+	switch {
+	case c.scale >= 0:
+		return formatDecimal(big.NewInt(v), c.scale)
+	case c.date:
+		return time.Unix(v*86400, 0).UTC().Format("2006-01-02")
+	case c.timeUnit > 0:
+		perSecond := int64(time.Second / c.timeUnit)
+		return formatTime(time.Unix(v/perSecond, v%perSecond*int64(c.timeUnit)))
+	}
+	return strconv.FormatInt(v, 10)
+}
+
+// formatBytes formats byte arrays as strings or as decimals, which are
+// encoded as big-endian two's complement
+func (c *column) formatBytes(v []byte) string {
+	if c.scale < 0 {
+		return string(v)
+	}
+	unscaled := new(big.Int).SetBytes(v)
+	if len(v) > 0 && v[0]&0x80 != 0 {
+		unscaled.Sub(unscaled, new(big.Int).Lsh(big.NewInt(1), uint(len(v))*8))
+	}
+	return formatDecimal(unscaled, c.scale)
+}
+
+// formatDecimal formats the unscaled value of a decimal with the scale
+func formatDecimal(unscaled *big.Int, scale int) string {
+	digits := new(big.Int).Abs(unscaled).String()
+	if scale > 0 {
+		if len(digits) <= scale {
+			digits = strings.Repeat("0", scale-len(digits)+1) + digits
+		}
+		digits = digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+	}
+	if unscaled.Sign() < 0 {
+		return "-" + digits
+	}
+	return digits
+}
+
+// formatTime formats timestamps in UTC
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,143 @@
+package parquet
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReadHybrid(t *testing.T) {
+	for name, tc := range map[string]struct {
+		data     []byte
+		width    int
+		n        int
+		expected []int
+	}{
+		"rle run": {
+			data:     []byte{0x0a, 0x01},
+			width:    1,
+			n:        5,
+			expected: []int{1, 1, 1, 1, 1},
+		},
+		"rle run of 2 bytes": {
+			data:     []byte{0x06, 0x34, 0x12},
+			width:    13,
+			n:        3,
+			expected: []int{0x1234, 0x1234, 0x1234},
+		},
+		"bit-packed": {
+			// 0 to 7 with 3 bits
+			data:     []byte{0x03, 0x88, 0xc6, 0xfa},
+			width:    3,
+			n:        8,
+			expected: []int{0, 1, 2, 3, 4, 5, 6, 7},
+		},
+		"bit-packed padded": {
+			data:     []byte{0x03, 0x05},
+			width:    1,
+			n:        3,
+			expected: []int{1, 0, 1},
+		},
+		"mixed": {
+			data:     []byte{0x04, 0x02, 0x03, 0x1b, 0x00},
+			width:    2,
+			n:        6,
+			expected: []int{2, 2, 3, 2, 1, 0},
+		},
+		"zero width": {
+			data:     []byte{0x08},
+			width:    0,
+			n:        4,
+			expected: []int{0, 0, 0, 0},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			values, err := readHybrid(tc.data, tc.width, tc.n)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(values, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, values)
+			}
+		})
+	}
+
+	if _, err := readHybrid([]byte{0x05, 0x00}, 1, 16); err == nil {
+		t.Error("expected error decoding truncated bit-packed run")
+	}
+}
+
+func TestReadPlain(t *testing.T) {
+	for name, tc := range map[string]struct {
+		column   column
+		data     []byte
+		expected []string
+	}{
+		"int32": {
+			column:   column{physical: typeInt32, scale: -1},
+			data:     []byte{0xff, 0xff, 0xff, 0xff, 0x2a, 0x00, 0x00, 0x00},
+			expected: []string{"-1", "42"},
+		},
+		"date": {
+			column:   column{physical: typeInt32, scale: -1, date: true},
+			data:     []byte{0x19, 0x47, 0x00, 0x00},
+			expected: []string{"2019-11-01"},
+		},
+		"int32 decimal": {
+			column:   column{physical: typeInt32, scale: 4},
+			data:     []byte{0xf6, 0xff, 0xff, 0xff},
+			expected: []string{"-0.0010"},
+		},
+		"timestamp millis": {
+			column:   column{physical: typeInt64, scale: -1, timeUnit: time.Millisecond},
+			data:     []byte{0x01, 0xfc, 0x42, 0x24, 0x6e, 0x01, 0x00, 0x00},
+			expected: []string{"2019-11-01T00:00:00.001Z"},
+		},
+		"timestamp nanos": {
+			column:   column{physical: typeInt64, scale: -1, timeUnit: time.Nanosecond},
+			data:     []byte{0x01, 0x00, 0xb7, 0x19, 0xcf, 0xe0, 0xd2, 0x15},
+			expected: []string{"2019-11-01T00:00:00.000000001Z"},
+		},
+		"int96": {
+			// an hour into the day, Julian day 2458789 is 2019-11-01
+			column:   column{physical: typeInt96, scale: -1},
+			data:     []byte{0x00, 0xa0, 0xb8, 0x30, 0x46, 0x03, 0x00, 0x00, 0xa5, 0x84, 0x25, 0x00},
+			expected: []string{"2019-11-01T01:00:00Z"},
+		},
+		"float": {
+			column:   column{physical: typeFloat, scale: -1},
+			data:     []byte{0x00, 0x00, 0xc0, 0x3f},
+			expected: []string{"1.5"},
+		},
+		"fixed length decimal": {
+			column:   column{physical: typeFixedLenByteArray, typeLength: 3, scale: 2},
+			data:     []byte{0x00, 0x30, 0x39, 0xff, 0xff, 0xfe},
+			expected: []string{"123.45", "-0.02"},
+		},
+		"byte array decimal": {
+			column:   column{physical: typeByteArray, scale: 0},
+			data:     []byte{0x02, 0x00, 0x00, 0x00, 0x01, 0x00},
+			expected: []string{"256"},
+		},
+		"fixed length string": {
+			column:   column{physical: typeFixedLenByteArray, typeLength: 3, scale: -1},
+			data:     []byte("USDEUR"),
+			expected: []string{"USD", "EUR"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			values, err := tc.column.readPlain(tc.data, len(tc.expected))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(values, tc.expected) {
+				t.Errorf("expected %q, got %q", tc.expected, values)
+			}
+		})
+	}
+
+	c := column{physical: typeInt64, scale: -1}
+	if _, err := c.readPlain([]byte{0x01, 0x02}, 1); err == nil {
+		t.Error("expected error decoding truncated values")
+	}
+}
@@ -0,0 +1,432 @@
+// Package parquet reads the flat columns of Parquet files, as written by the
+// cost and usage exports, as strings. Nested and repeated columns are
+// skipped.
+package parquet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// magic starts and ends Parquet files
+const magic = "PAR1"
+
+// types of the pages
+const (
+	pageData       = 0
+	pageDictionary = 2
+	pageDataV2     = 3
+)
+
+// maxPreallocated limits the values allocated up front by the row counts of
+// the metadata
+const maxPreallocated = 1 << 16
+
+// column is a flat leaf column of the schema
+type column struct {
+	name string
+	// chunk is the index of the column chunks in the row groups
+	chunk      int
+	physical   int
+	typeLength int
+	maxDef     int
+
+	// scale of decimals, -1 for other types
+	scale    int
+	date     bool
+	timeUnit time.Duration
+}
+
+// File is a Parquet file
+type File struct {
+	r         io.ReaderAt
+	size      int64
+	numRows   int64
+	columns   []*column
+	rowGroups []thriftStruct
+}
+
+// Open reads the metadata of the Parquet file of the given size
+func Open(r io.ReaderAt, size int64) (*File, error) {
+	if size < int64(2*len(magic)+4) {
+		return nil, fmt.Errorf("not a Parquet file, only %d bytes", size)
+	}
+	head := make([]byte, len(magic))
+	if _, err := r.ReadAt(head, 0); err != nil {
+		return nil, err
+	}
+	footer := make([]byte, 4+len(magic))
+	if _, err := r.ReadAt(footer, size-int64(len(footer))); err != nil {
+		return nil, err
+	}
+	if string(head) != magic || string(footer[4:]) != magic {
+		return nil, fmt.Errorf("not a Parquet file")
+	}
+
+	length := int64(binary.LittleEndian.Uint32(footer))
+	if length > size-int64(len(magic)+len(footer)) {
+		return nil, fmt.Errorf("invalid metadata length %d", length)
+	}
+	data := make([]byte, length)
+	if _, err := r.ReadAt(data, size-int64(len(footer))-length); err != nil {
+		return nil, err
+	}
+	metadata, err := (&thriftDecoder{data: data}).strct(0)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding metadata: %s", err)
+	}
+
+	f := &File{r: r, size: size, numRows: metadata.int(3)}
+	for _, rowGroup := range metadata.list(4) {
+		s, ok := rowGroup.(thriftStruct)
+		if !ok {
+			return nil, fmt.Errorf("invalid row group in metadata")
+		}
+		f.rowGroups = append(f.rowGroups, s)
+	}
+	if err := f.readSchema(metadata.list(2)); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// readSchema walks the schema elements, which are stored depth-first, and
+// keeps the leaf columns, which aren't repeated
+func (f *File) readSchema(elements []interface{}) error {
+	if len(elements) == 0 {
+		return fmt.Errorf("missing schema in metadata")
+	}
+	root, _ := elements[0].(thriftStruct)
+	pos, leaf := 1, 0
+
+	var walk func(children int, prefix string, maxDef int, repeated bool) error
+	walk = func(children int, prefix string, maxDef int, repeated bool) error {
+		for i := 0; i < children; i++ {
+			if pos >= len(elements) {
+				return fmt.Errorf("invalid schema, missing elements")
+			}
+			e, ok := elements[pos].(thriftStruct)
+			if !ok {
+				return fmt.Errorf("invalid schema element %d", pos)
+			}
+			pos++
+
+			name, def, rep := prefix+e.string(4), maxDef, repeated
+			switch e.int(3) {
+			case 1:
+				def++
+			case 2:
+				def++
+				rep = true
+			}
+			if !e.has(1) {
+				// groups have no physical type
+				if err := walk(int(e.int(5)), name+".", def, rep); err != nil {
+					return err
+				}
+				continue
+			}
+			c := newColumn(e, name, leaf, def)
+			leaf++
+			if !rep {
+				f.columns = append(f.columns, c)
+			}
+		}
+		return nil
+	}
+	if n := root.int(5); n < 0 || n >= int64(len(elements)) {
+		return fmt.Errorf("invalid schema with %d columns", n)
+	}
+	return walk(int(root.int(5)), "", 0, false)
+}
+
+// newColumn returns the column of a leaf element of the schema, its logical
+// type is taken from the converted type of older writers, if missing
+func newColumn(e thriftStruct, name string, chunk, maxDef int) *column {
+	c := &column{
+		name:       name,
+		chunk:      chunk,
+		physical:   int(e.int(1)),
+		typeLength: int(e.int(2)),
+		maxDef:     maxDef,
+		scale:      -1,
+	}
+	if logical := e.strct(10); logical != nil {
+		switch {
+		case logical.has(5):
+			c.scale = int(logical.strct(5).int(1))
+		case logical.has(6):
+			c.date = true
+		case logical.has(8):
+			unit := logical.strct(8).strct(2)
+			switch {
+			case unit.has(1):
+				c.timeUnit = time.Millisecond
+			case unit.has(2):
+				c.timeUnit = time.Microsecond
+			case unit.has(3):
+				c.timeUnit = time.Nanosecond
+			}
+		}
+		return c
+	}
+	switch e.int(6) {
+	case convertedDecimal:
+		c.scale = int(e.int(7))
+	case convertedDate:
+		c.date = true
+	case convertedTimestampMillis:
+		c.timeUnit = time.Millisecond
+	case convertedTimestampMicros:
+		c.timeUnit = time.Microsecond
+	}
+	return c
+}
+
+// Columns returns the names of the flat columns, nested columns are named by
+// their path joined with dots
+func (f *File) Columns() []string {
+	names := make([]string, len(f.columns))
+	for i, c := range f.columns {
+		names[i] = c.name
+	}
+	return names
+}
+
+// NumRows returns the number of rows of the file
+func (f *File) NumRows() int64 {
+	return f.numRows
+}
+
+// Reader reads the rows of the named columns, which are returned in the
+// given order
+func (f *File) Reader(names ...string) (*Reader, error) {
+	r := &Reader{f: f}
+	for _, name := range names {
+		var found *column
+		for _, c := range f.columns {
+			if c.name == name {
+				found = c
+				break
+			}
+		}
+		if found == nil {
+			return nil, fmt.Errorf("column %s not found", name)
+		}
+		r.columns = append(r.columns, found)
+	}
+	return r, nil
+}
+
+// Reader reads the rows of selected columns, decoding a row group at a time
+type Reader struct {
+	f       *File
+	columns []*column
+
+	group  int
+	values [][]string
+	row    int
+	rows   int
+}
+
+// Read returns the values of the next row, null values are empty. It returns
+// io.EOF after the last row.
+func (r *Reader) Read() ([]string, error) {
+	for r.row >= r.rows {
+		if r.group >= len(r.f.rowGroups) {
+			return nil, io.EOF
+		}
+		if err := r.readRowGroup(r.f.rowGroups[r.group]); err != nil {
+			return nil, fmt.Errorf("error reading row group %d: %s", r.group, err)
+		}
+		r.group++
+	}
+	row := make([]string, len(r.columns))
+	for i := range r.columns {
+		row[i] = r.values[i][r.row]
+	}
+	r.row++
+	return row, nil
+}
+
+// readRowGroup decodes the selected columns of the row group
+func (r *Reader) readRowGroup(rowGroup thriftStruct) error {
+	rows := rowGroup.int(3)
+	if rows < 0 {
+		return fmt.Errorf("invalid number of rows %d", rows)
+	}
+	chunks := rowGroup.list(1)
+	values := make([][]string, len(r.columns))
+	for i, c := range r.columns {
+		if c.chunk >= len(chunks) {
+			return fmt.Errorf("missing chunk of column %s", c.name)
+		}
+		chunk, _ := chunks[c.chunk].(thriftStruct)
+		if chunk.string(1) != "" {
+			return fmt.Errorf("column %s is stored in the file %s, which is not supported", c.name, chunk.string(1))
+		}
+		var err error
+		values[i], err = r.f.readChunk(c, chunk.strct(3), int(rows))
+		if err != nil {
+			return fmt.Errorf("error reading column %s: %s", c.name, err)
+		}
+	}
+	r.values = values
+	r.row, r.rows = 0, int(rows)
+	return nil
+}
+
+// readChunk decodes the pages of a column chunk
+func (f *File) readChunk(c *column, metadata thriftStruct, rows int) ([]string, error) {
+	if metadata == nil {
+		return nil, fmt.Errorf("missing metadata")
+	}
+	offset, size := metadata.int(9), metadata.int(7)
+	if dictionary := metadata.int(11); dictionary > 0 && dictionary < offset {
+		offset = dictionary
+	}
+	if offset < 0 || size < 0 || offset+size > f.size {
+		return nil, fmt.Errorf("invalid chunk of %d bytes at offset %d", size, offset)
+	}
+	data := make([]byte, size)
+	if _, err := f.r.ReadAt(data, offset); err != nil {
+		return nil, err
+	}
+	codec := metadata.int(4)
+
+	capacity := rows
+	if capacity > maxPreallocated {
+		capacity = maxPreallocated
+	}
+	values := make([]string, 0, capacity)
+	var dictionary []string
+	for len(values) < rows {
+		d := &thriftDecoder{data: data}
+		header, err := d.strct(0)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding page header: %s", err)
+		}
+		data = data[d.pos:]
+		compressed, uncompressed := int(header.int(3)), int(header.int(2))
+		if compressed < 0 || compressed > len(data) || uncompressed < 0 {
+			return nil, fmt.Errorf("invalid page of %d bytes", compressed)
+		}
+		page := data[:compressed]
+		data = data[compressed:]
+
+		switch header.int(1) {
+		case pageDictionary:
+			page, err = decompress(codec, page, uncompressed)
+			if err != nil {
+				return nil, err
+			}
+			dictionary, err = c.readPlain(page, int(header.strct(7).int(1)))
+			if err != nil {
+				return nil, fmt.Errorf("error decoding dictionary: %s", err)
+			}
+		case pageData:
+			h := header.strct(5)
+			n := int(h.int(1))
+			if n < 0 || n > rows-len(values) {
+				return nil, fmt.Errorf("page of %d values exceeds the %d rows", n, rows)
+			}
+			page, err = decompress(codec, page, uncompressed)
+			if err != nil {
+				return nil, err
+			}
+			levels, page, err := readLevels(page, c.maxDef, n, true)
+			if err != nil {
+				return nil, err
+			}
+			values, err = c.readValues(values, page, h.int(2), levels, dictionary)
+			if err != nil {
+				return nil, err
+			}
+		case pageDataV2:
+			h := header.strct(8)
+			n := int(h.int(1))
+			if n < 0 || n > rows-len(values) {
+				return nil, fmt.Errorf("page of %d values exceeds the %d rows", n, rows)
+			}
+			// the levels are stored uncompressed in front of the values,
+			// repetition levels first
+			levelsLength := int(h.int(5) + h.int(6))
+			if h.int(5) < 0 || h.int(6) < 0 || levelsLength > len(page) || levelsLength > uncompressed {
+				return nil, fmt.Errorf("invalid levels of %d bytes", levelsLength)
+			}
+			levels, _, err := readLevels(page[h.int(6):levelsLength], c.maxDef, n, false)
+			if err != nil {
+				return nil, err
+			}
+			page = page[levelsLength:]
+			if isCompressed, ok := h.bool(7); !ok || isCompressed {
+				page, err = decompress(codec, page, uncompressed-levelsLength)
+				if err != nil {
+					return nil, err
+				}
+			}
+			values, err = c.readValues(values, page, h.int(4), levels, dictionary)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			// index pages are skipped
+		}
+	}
+	return values, nil
+}
+
+// readValues appends the values of a data page to values by the definition
+// levels, nulls are appended empty
+func (c *column) readValues(values []string, data []byte, encoding int64, levels []int, dictionary []string) ([]string, error) {
+	n := 0
+	for _, level := range levels {
+		if level == c.maxDef {
+			n++
+		}
+	}
+
+	var page []string
+	var err error
+	switch {
+	case n == 0:
+	case encoding == encodingPlain:
+		page, err = c.readPlain(data, n)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding values: %s", err)
+		}
+	case encoding == encodingPlainDictionary || encoding == encodingRLEDictionary:
+		if dictionary == nil {
+			return nil, fmt.Errorf("missing dictionary page")
+		}
+		if len(data) == 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		indices, err := readHybrid(data[1:], int(data[0]), n)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding dictionary indices: %s", err)
+		}
+		page = make([]string, n)
+		for i, index := range indices {
+			if index >= len(dictionary) {
+				return nil, fmt.Errorf("dictionary index %d out of range", index)
+			}
+			page[i] = dictionary[index]
+		}
+	default:
+		return nil, fmt.Errorf("encoding %d is not supported", encoding)
+	}
+
+	i := 0
+	for _, level := range levels {
+		if level == c.maxDef {
+			values = append(values, page[i])
+			i++
+		} else {
+			values = append(values, "")
+		}
+	}
+	return values, nil
+}
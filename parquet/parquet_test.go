@@ -0,0 +1,157 @@
+package parquet
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/simonswine/cloud-billing-exporter/fake"
+)
+
+func readAll(t *testing.T, content string, names ...string) [][]string {
+	t.Helper()
+	f, err := Open(strings.NewReader(content), int64(len(content)))
+	if err != nil {
+		t.Fatalf("unexpected error opening: %s", err)
+	}
+	r, err := f.Reader(names...)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var rows [][]string
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			return rows
+		}
+		if err != nil {
+			t.Fatalf("unexpected error reading: %s", err)
+		}
+		rows = append(rows, row)
+	}
+}
+
+func TestRead(t *testing.T) {
+	start := time.Date(2019, 11, 1, 0, 0, 0, 0, time.UTC)
+	columns := func(dictionary bool) []fake.ParquetColumn {
+		return []fake.ParquetColumn{
+			{Name: "line_item_usage_account_id", Values: []interface{}{"123456789012", "123456789012", nil, "210987654321", "123456789012"}, Dictionary: dictionary},
+			{Name: "line_item_unblended_cost", Values: []interface{}{1.5, 0.25, 3.0, nil, -0.5}, Dictionary: dictionary},
+			{Name: "line_item_usage_start_date", Values: []interface{}{start, start, start.Add(time.Hour), start, nil}},
+			{Name: "pricing_units", Values: []interface{}{int64(1), nil, int64(3), int64(-4), int64(5)}},
+			{Name: "cost", Values: []interface{}{int64(12345), int64(-5), nil, int64(0), int64(100)}, Scale: 2},
+			{Name: "reservation", Values: []interface{}{true, false, nil, true, true}},
+		}
+	}
+	expected := [][]string{
+		{"123456789012", "1.5", "2019-11-01T00:00:00Z", "1", "123.45", "true"},
+		{"123456789012", "0.25", "2019-11-01T00:00:00Z", "", "-0.05", "false"},
+		{"", "3", "2019-11-01T01:00:00Z", "3", "", ""},
+		{"210987654321", "", "2019-11-01T00:00:00Z", "-4", "0.00", "true"},
+		{"123456789012", "-0.5", "", "5", "1.00", "true"},
+	}
+
+	for name, file := range map[string]fake.ParquetFile{
+		"plain":              {Columns: columns(false)},
+		"dictionary":         {Columns: columns(true)},
+		"snappy":             {Columns: columns(true), Compression: "SNAPPY"},
+		"gzip":               {Columns: columns(false), Compression: "GZIP"},
+		"page v2":            {Columns: columns(true), Compression: "SNAPPY", PageV2: true},
+		"uncompressed v2":    {Columns: columns(false), PageV2: true},
+		"row groups":         {Columns: columns(true), RowGroupRows: 2},
+		"row groups gzip v2": {Columns: columns(false), RowGroupRows: 3, Compression: "GZIP", PageV2: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			content := file.String()
+			f, err := Open(strings.NewReader(content), int64(len(content)))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if f.NumRows() != 5 {
+				t.Errorf("expected 5 rows, got %d", f.NumRows())
+			}
+			names := []string{"line_item_usage_account_id", "line_item_unblended_cost", "line_item_usage_start_date", "pricing_units", "cost", "reservation"}
+			if !reflect.DeepEqual(f.Columns(), names) {
+				t.Errorf("unexpected columns %v", f.Columns())
+			}
+
+			if rows := readAll(t, content, names...); !reflect.DeepEqual(rows, expected) {
+				t.Errorf("expected %q, got %q", expected, rows)
+			}
+		})
+	}
+}
+
+func TestReadColumns(t *testing.T) {
+	content := fake.ParquetFile{Columns: []fake.ParquetColumn{
+		{Name: "a", Values: []interface{}{"1", "2"}},
+		{Name: "b", Values: []interface{}{"3", "4"}},
+	}}.String()
+
+	rows := readAll(t, content, "b", "a", "b")
+	expected := [][]string{{"3", "1", "3"}, {"4", "2", "4"}}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("expected %q, got %q", expected, rows)
+	}
+
+	f, err := Open(strings.NewReader(content), int64(len(content)))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := f.Reader("a", "missing"); err == nil || !strings.Contains(err.Error(), "column missing not found") {
+		t.Errorf("expected missing column error, got %v", err)
+	}
+}
+
+func TestReadEmpty(t *testing.T) {
+	content := fake.ParquetFile{Columns: []fake.ParquetColumn{{Name: "a"}}}.String()
+	if rows := readAll(t, content, "a"); len(rows) != 0 {
+		t.Errorf("expected no rows, got %q", rows)
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	content := fake.ParquetFile{Columns: []fake.ParquetColumn{{Name: "a", Values: []interface{}{"1"}}}}.String()
+	for name, tc := range map[string]struct {
+		content string
+		err     string
+	}{
+		"csv":       {"a,b\n1,2\n3,4\n", "not a Parquet file"},
+		"short":     {"PAR1", "not a Parquet file"},
+		"length":    {content[:len(content)-8] + "\xff\xff\x00\x00PAR1", "invalid metadata length"},
+		"metadata":  {"PAR1\x15\xff\xff\xff\xff\xff\xff\xff\x08\x00\x00\x00PAR1", "error decoding metadata"},
+		"truncated": {content[:4] + content[len(content)-8:], "invalid metadata length"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := Open(strings.NewReader(tc.content), int64(len(tc.content)))
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("expected error containing %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestReadCorrupted(t *testing.T) {
+	content := fake.ParquetFile{
+		Columns:     []fake.ParquetColumn{{Name: "a", Values: []interface{}{"some", "values", "to", "compress"}}},
+		Compression: "GZIP",
+	}.String()
+	// corrupt the compressed data page, which fails its checksum
+	data := []byte(content)
+	data[strings.Index(content, "\x1f\x8b")+12] ^= 0xff
+	corrupted := string(data)
+
+	f, err := Open(strings.NewReader(corrupted), int64(len(corrupted)))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	r, err := f.Reader("a")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := r.Read(); err == nil || !strings.Contains(err.Error(), "error reading column a") {
+		t.Errorf("expected error reading the column, got %v", err)
+	}
+}
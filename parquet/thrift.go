@@ -0,0 +1,244 @@
+package parquet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// types of the Thrift compact protocol
+const (
+	compactStop   = 0
+	compactTrue   = 1
+	compactFalse  = 2
+	compactByte   = 3
+	compactI16    = 4
+	compactI32    = 5
+	compactI64    = 6
+	compactDouble = 7
+	compactBinary = 8
+	compactList   = 9
+	compactSet    = 10
+	compactMap    = 11
+	compactStruct = 12
+)
+
+// thriftMaxDepth limits the nesting of the decoded structs
+const thriftMaxDepth = 32
+
+// thriftStruct contains the fields of a struct decoded from the Thrift
+// compact protocol by their ID. Integers are decoded as int64, binaries as
+// []byte, lists and sets as []interface{}, maps as map[interface{}]interface{}
+// and structs as thriftStruct.
+type thriftStruct map[int16]interface{}
+
+func (s thriftStruct) has(id int16) bool {
+	_, ok := s[id]
+	return ok
+}
+
+func (s thriftStruct) int(id int16) int64 {
+	v, _ := s[id].(int64)
+	return v
+}
+
+func (s thriftStruct) bool(id int16) (bool, bool) {
+	v, ok := s[id].(bool)
+	return v, ok
+}
+
+func (s thriftStruct) string(id int16) string {
+	v, _ := s[id].([]byte)
+	return string(v)
+}
+
+func (s thriftStruct) list(id int16) []interface{} {
+	v, _ := s[id].([]interface{})
+	return v
+}
+
+func (s thriftStruct) strct(id int16) thriftStruct {
+	v, _ := s[id].(thriftStruct)
+	return v
+}
+
+// thriftDecoder decodes values of the Thrift compact protocol, as used by
+// the metadata and page headers of Parquet files
+type thriftDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *thriftDecoder) byte() (byte, error) {
+	if d.pos >= len(d.data) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	b := d.data[d.pos]
+	d.pos++
+	return b, nil
+}
+
+func (d *thriftDecoder) bytes(n int) ([]byte, error) {
+	if n < 0 || n > len(d.data)-d.pos {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *thriftDecoder) uvarint() (uint64, error) {
+	v, n := binary.Uvarint(d.data[d.pos:])
+	if n <= 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	d.pos += n
+	return v, nil
+}
+
+// varint decodes a zigzag encoded integer
+func (d *thriftDecoder) varint() (int64, error) {
+	v, err := d.uvarint()
+	return int64(v>>1) ^ -int64(v&1), err
+}
+
+func (d *thriftDecoder) size() (int, error) {
+	n, err := d.uvarint()
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(len(d.data)-d.pos) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return int(n), nil
+}
+
+// value decodes a value of the given type
+func (d *thriftDecoder) value(typ byte, depth int) (interface{}, error) {
+	switch typ {
+	case compactTrue, compactFalse:
+		// elements of lists are encoded as a byte
+		b, err := d.byte()
+		return b == compactTrue, err
+	case compactByte:
+		b, err := d.byte()
+		return int64(int8(b)), err
+	case compactI16, compactI32, compactI64:
+		return d.varint()
+	case compactDouble:
+		b, err := d.bytes(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+	case compactBinary:
+		n, err := d.size()
+		if err != nil {
+			return nil, err
+		}
+		return d.bytes(n)
+	case compactList, compactSet:
+		return d.list(depth)
+	case compactMap:
+		return d.mp(depth)
+	case compactStruct:
+		return d.strct(depth)
+	}
+	return nil, fmt.Errorf("unknown thrift type %d", typ)
+}
+
+func (d *thriftDecoder) list(depth int) ([]interface{}, error) {
+	header, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+	n := int(header >> 4)
+	if n == 15 {
+		if n, err = d.size(); err != nil {
+			return nil, err
+		}
+	}
+	list := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		v, err := d.value(header&0x0f, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+func (d *thriftDecoder) mp(depth int) (map[interface{}]interface{}, error) {
+	n, err := d.size()
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	types, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[interface{}]interface{}, n)
+	for i := 0; i < n; i++ {
+		k, err := d.value(types>>4, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.value(types&0x0f, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		// binary keys aren't comparable
+		if b, ok := k.([]byte); ok {
+			k = string(b)
+		}
+		if _, ok := k.(thriftStruct); ok {
+			continue
+		}
+		m[k] = v
+	}
+	return m, nil
+}
+
+// strct decodes the fields of a struct up to its stop field
+func (d *thriftDecoder) strct(depth int) (thriftStruct, error) {
+	if depth > thriftMaxDepth {
+		return nil, fmt.Errorf("thrift structs nested too deep")
+	}
+	s := make(thriftStruct)
+	var id int16
+	for {
+		header, err := d.byte()
+		if err != nil {
+			return nil, err
+		}
+		typ := header & 0x0f
+		if typ == compactStop {
+			return s, nil
+		}
+		if delta := int16(header >> 4); delta != 0 {
+			id += delta
+		} else {
+			v, err := d.varint()
+			if err != nil {
+				return nil, err
+			}
+			id = int16(v)
+		}
+
+		// booleans of fields are encoded in the type
+		switch typ {
+		case compactTrue:
+			s[id] = true
+		case compactFalse:
+			s[id] = false
+		default:
+			v, err := d.value(typ, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			s[id] = v
+		}
+	}
+}
@@ -0,0 +1,22 @@
+//go:build cgo
+// +build cgo
+
+package parquet
+
+import (
+	"fmt"
+
+	"github.com/DataDog/zstd"
+)
+
+// zstdDecode decompresses a zstd frame of the given size
+func zstdDecode(data []byte, size int) ([]byte, error) {
+	dst, err := zstd.Decompress(make([]byte, size), data)
+	if err != nil {
+		return nil, err
+	}
+	if len(dst) != size {
+		return nil, fmt.Errorf("zstd frame decoded to %d bytes, expected %d", len(dst), size)
+	}
+	return dst, nil
+}
@@ -0,0 +1,12 @@
+//go:build !cgo
+// +build !cgo
+
+package parquet
+
+import "fmt"
+
+// zstdDecode is not supported without cgo, as the zstd library wraps the C
+// implementation
+func zstdDecode(data []byte, size int) ([]byte, error) {
+	return nil, fmt.Errorf("zstd compressed Parquet files require a build with cgo")
+}
@@ -0,0 +1,27 @@
+//go:build cgo
+// +build cgo
+
+package parquet
+
+import (
+	"testing"
+
+	"github.com/DataDog/zstd"
+)
+
+func TestDecompressZstd(t *testing.T) {
+	compressed, err := zstd.Compress(nil, []byte("some page"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := decompress(codecZstd, compressed, 9)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(data) != "some page" {
+		t.Errorf("unexpected page %q", data)
+	}
+	if _, err := decompress(codecZstd, compressed, 4); err == nil {
+		t.Error("expected error decompressing to the wrong size")
+	}
+}
@@ -0,0 +1,66 @@
+package parse
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+)
+
+// ChecksumAttempts is the number of times a report is downloaded, before a
+// checksum mismatch fails the job
+const ChecksumAttempts = 3
+
+// ErrChecksumMismatch is returned if the content read doesn't match the
+// checksum advertised by the object storage, e.g. as the download was
+// truncated
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ChecksumReader hashes the content read, to verify it against the checksum
+// of the object once it is read completely
+type ChecksumReader struct {
+	io.Reader
+	hash     hash.Hash
+	expected []byte
+}
+
+// NewChecksumReader verifies the content read from r against the expected
+// sum of the hash
+func NewChecksumReader(r io.Reader, h hash.Hash, expected []byte) *ChecksumReader {
+	return &ChecksumReader{
+		Reader:   io.TeeReader(r, h),
+		hash:     h,
+		expected: expected,
+	}
+}
+
+// Verify reads the content not read by the parser and compares the checksum
+func (r *ChecksumReader) Verify() error {
+	if _, err := io.Copy(ioutil.Discard, r.Reader); err != nil {
+		return err
+	}
+	if actual := r.hash.Sum(nil); !bytes.Equal(actual, r.expected) {
+		return fmt.Errorf("%w: expected %x, got %x", ErrChecksumMismatch, r.expected, actual)
+	}
+	return nil
+}
+
+// RetryChecksum runs the job again while it fails with a checksum mismatch,
+// up to attempts times in total. The bytes read by all attempts are counted.
+func RetryChecksum(attempts int, job Job) Job {
+	return func() (int64, error) {
+		var total int64
+		var err error
+		for i := 0; i < attempts; i++ {
+			var n int64
+			n, err = job()
+			total += n
+			if !errors.Is(err, ErrChecksumMismatch) {
+				return total, err
+			}
+		}
+		return total, err
+	}
+}
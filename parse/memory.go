@@ -0,0 +1,44 @@
+package parse
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// peakResidentMemory returns the peak resident memory of the process in
+// bytes, it is only available on Linux
+func peakResidentMemory() (int64, error) {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return parseVmHWM(f)
+}
+
+// parseVmHWM returns the VmHWM field of /proc/self/status in bytes
+func parseVmHWM(r io.Reader) (int64, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 || fields[0] != "VmHWM:" {
+			continue
+		}
+		if fields[2] != "kB" {
+			return 0, fmt.Errorf("unexpected unit of VmHWM: %s", fields[2])
+		}
+		kb, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("error parsing VmHWM: %s", err)
+		}
+		return kb * 1024, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no VmHWM found")
+}
@@ -0,0 +1,208 @@
+// Package parse runs the parsing of billing reports on a bounded number of
+// workers shared by all backends
+package parse
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Job parses a single report object and returns the number of bytes read
+type Job func() (int64, error)
+
+// Pipeline queues jobs in a bounded channel, which is drained by a fixed
+// number of workers
+type Pipeline struct {
+	queue chan func()
+
+	queueDepth prometheus.GaugeFunc
+	objects    *prometheus.CounterVec
+	errors     *prometheus.CounterVec
+	bytes      *prometheus.CounterVec
+	duration   *prometheus.CounterVec
+	peakMemory prometheus.Gauge
+
+	rowsRead       *prometheus.CounterVec
+	rowsAggregated *prometheus.CounterVec
+	rowsSkipped    *prometheus.CounterVec
+	accounts       *prometheus.GaugeVec
+
+	// spillDir and spillBudget configure the aggregators of the jobs
+	spillDir    string
+	spillBudget int64
+}
+
+// New starts a pipeline with the given number of workers, submitting jobs
+// blocks while queueSize jobs are waiting
+func New(namespace string, workers, queueSize int) *Pipeline {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+
+	p := &Pipeline{
+		queue: make(chan func(), queueSize),
+		objects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "parse",
+			Name:      "objects_total",
+			Help:      "Number of report objects parsed.",
+		}, []string{"backend"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "parse",
+			Name:      "errors_total",
+			Help:      "Number of report objects failed to parse.",
+		}, []string{"backend"}),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "parse",
+			Name:      "bytes_total",
+			Help:      "Number of bytes of report objects read.",
+		}, []string{"backend"}),
+		duration: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "parse",
+			Name:      "duration_seconds_total",
+			Help:      "Time spent parsing report objects.",
+		}, []string{"backend"}),
+		peakMemory: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "parse",
+			Name:      "peak_resident_memory_bytes",
+			Help:      "Peak resident memory of the process, as of the last parsed report object. Only available on Linux.",
+		}),
+		rowsRead: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "parse",
+			Name:      "rows_read_total",
+			Help:      "Number of rows read from report objects.",
+		}, []string{"backend"}),
+		rowsAggregated: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "parse",
+			Name:      "rows_aggregated_total",
+			Help:      "Number of rows of report objects aggregated into the costs.",
+		}, []string{"backend"}),
+		rowsSkipped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "parse",
+			Name:      "rows_skipped_total",
+			Help:      "Number of rows of report objects skipped, by the record type filter or as their cost couldn't be parsed.",
+		}, []string{"backend", "reason"}),
+		accounts: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "parse",
+			Name:      "accounts",
+			Help:      "Number of distinct accounts in the last parsed report object.",
+		}, []string{"backend"}),
+	}
+	p.queueDepth = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "parse",
+		Name:      "queue_depth",
+		Help:      "Number of report objects waiting to be parsed.",
+	}, func() float64 {
+		return float64(len(p.queue))
+	})
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for run := range p.queue {
+				run()
+			}
+		}()
+	}
+	return p
+}
+
+// Close stops the workers once the queued jobs are done
+func (p *Pipeline) Close() {
+	close(p.queue)
+}
+
+// Run parses the jobs of a backend and waits for them to finish, the errors
+// are returned in the order of the jobs. Without pipeline each job runs in
+// its own goroutine.
+func (p *Pipeline) Run(backend string, jobs []Job) []error {
+	errs := make([]error, len(jobs))
+	var wg sync.WaitGroup
+	wg.Add(len(jobs))
+	for i, job := range jobs {
+		i, job := i, job
+		run := func() {
+			defer wg.Done()
+			errs[i] = p.run(backend, job)
+		}
+		if p == nil {
+			go run()
+			continue
+		}
+		p.queue <- run
+	}
+	wg.Wait()
+	return errs
+}
+
+func (p *Pipeline) run(backend string, job Job) error {
+	start := time.Now()
+	n, err := job()
+	if p == nil {
+		return err
+	}
+
+	p.duration.WithLabelValues(backend).Add(time.Since(start).Seconds())
+	p.bytes.WithLabelValues(backend).Add(float64(n))
+	if err != nil {
+		p.errors.WithLabelValues(backend).Inc()
+	} else {
+		p.objects.WithLabelValues(backend).Inc()
+	}
+	if peak, err := peakResidentMemory(); err == nil {
+		p.peakMemory.Set(float64(peak))
+	}
+	return err
+}
+
+func (p *Pipeline) Describe(ch chan<- *prometheus.Desc) {
+	p.queueDepth.Describe(ch)
+	p.objects.Describe(ch)
+	p.errors.Describe(ch)
+	p.bytes.Describe(ch)
+	p.duration.Describe(ch)
+	p.peakMemory.Describe(ch)
+	p.rowsRead.Describe(ch)
+	p.rowsAggregated.Describe(ch)
+	p.rowsSkipped.Describe(ch)
+	p.accounts.Describe(ch)
+}
+
+func (p *Pipeline) Collect(ch chan<- prometheus.Metric) {
+	p.queueDepth.Collect(ch)
+	p.objects.Collect(ch)
+	p.errors.Collect(ch)
+	p.bytes.Collect(ch)
+	p.duration.Collect(ch)
+	p.peakMemory.Collect(ch)
+	p.rowsRead.Collect(ch)
+	p.rowsAggregated.Collect(ch)
+	p.rowsSkipped.Collect(ch)
+	p.accounts.Collect(ch)
+}
+
+// CountingReader counts the bytes read, to report the parse throughput
+type CountingReader struct {
+	io.Reader
+	N int64
+}
+
+func (r *CountingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.N += int64(n)
+	return n, err
+}
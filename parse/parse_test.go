@@ -0,0 +1,141 @@
+package parse
+
+import (
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPipelineRun(t *testing.T) {
+	p := New("cloud", 2, 1)
+	defer p.Close()
+
+	var running, maxRunning int32
+	block := make(chan struct{})
+	job := func(content string) Job {
+		return func() (int64, error) {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&maxRunning)
+				if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+					break
+				}
+			}
+			<-block
+			defer atomic.AddInt32(&running, -1)
+
+			r := &CountingReader{Reader: strings.NewReader(content)}
+			if _, err := ioutil.ReadAll(r); err != nil {
+				return r.N, err
+			}
+			if content == "broken" {
+				return r.N, fmt.Errorf("invalid report")
+			}
+			return r.N, nil
+		}
+	}
+
+	done := make(chan []error)
+	go func() {
+		done <- p.Run("gcp", []Job{job("a"), job("bb"), job("ccc"), job("broken")})
+	}()
+	close(block)
+	errs := <-done
+
+	if len(errs) != 4 || errs[0] != nil || errs[1] != nil || errs[2] != nil || errs[3] == nil {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+	if m := atomic.LoadInt32(&maxRunning); m > 2 {
+		t.Errorf("expected at most 2 jobs running at the same time, got %d", m)
+	}
+	if act := testutil.ToFloat64(p.objects.WithLabelValues("gcp")); act != 3 {
+		t.Errorf("unexpected number of parsed objects: %f", act)
+	}
+	if act := testutil.ToFloat64(p.errors.WithLabelValues("gcp")); act != 1 {
+		t.Errorf("unexpected number of errors: %f", act)
+	}
+	if act := testutil.ToFloat64(p.bytes.WithLabelValues("gcp")); act != 12 {
+		t.Errorf("unexpected number of bytes: %f", act)
+	}
+}
+
+func TestNilPipelineRun(t *testing.T) {
+	var p *Pipeline
+	errs := p.Run("aws", []Job{
+		func() (int64, error) { return 1, nil },
+		func() (int64, error) { return 0, fmt.Errorf("failed") },
+	})
+	if len(errs) != 2 || errs[0] != nil || errs[1] == nil {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
+
+func TestParseVmHWM(t *testing.T) {
+	status := `Name:	cloud-billing-exporter
+VmPeak:	  812345 kB
+VmHWM:	  204800 kB
+VmRSS:	  102400 kB
+`
+	act, err := parseVmHWM(strings.NewReader(status))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if exp := int64(204800 * 1024); act != exp {
+		t.Errorf("unexpected peak memory: %d (expected: %d)", act, exp)
+	}
+
+	if _, err := parseVmHWM(strings.NewReader("Name:	cloud-billing-exporter\n")); err == nil {
+		t.Error("expected error without VmHWM")
+	}
+}
+
+func TestChecksumReader(t *testing.T) {
+	content := "a,b\n1,2\n"
+	sum := md5.Sum([]byte(content))
+
+	r := NewChecksumReader(strings.NewReader(content), md5.New(), sum[:])
+	// the parser stops before the end of the content
+	if _, err := r.Read(make([]byte, 4)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := r.Verify(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	truncated := NewChecksumReader(strings.NewReader(content[:5]), md5.New(), sum[:])
+	if err := truncated.Verify(); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("expected checksum mismatch, got: %v", err)
+	}
+}
+
+func TestRetryChecksum(t *testing.T) {
+	attempts := 0
+	job := RetryChecksum(3, func() (int64, error) {
+		attempts++
+		if attempts < 2 {
+			return 5, fmt.Errorf("error verifying report: %w", ErrChecksumMismatch)
+		}
+		return 8, nil
+	})
+	n, err := job()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if attempts != 2 || n != 13 {
+		t.Errorf("unexpected attempts %d and bytes %d", attempts, n)
+	}
+
+	attempts = 0
+	if _, err := RetryChecksum(3, func() (int64, error) {
+		attempts++
+		return 0, ErrChecksumMismatch
+	})(); err == nil || attempts != 3 {
+		t.Errorf("unexpected error %v after %d attempts", err, attempts)
+	}
+}
@@ -0,0 +1,277 @@
+package parse
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/prometheus/common/log"
+)
+
+// entryOverhead approximates the memory of a map entry besides its key
+const entryOverhead = 64
+
+// WithSpill spills the aggregation state of reports to sorted files in dir,
+// once it exceeds the memory budget in bytes. A budget of 0 keeps the state
+// in memory.
+func (p *Pipeline) WithSpill(dir string, budget int64) *Pipeline {
+	p.spillDir = dir
+	p.spillBudget = budget
+	return p
+}
+
+// TempFile creates a temporary file in the spill directory, e.g. to spool
+// reports, which can't be read as a stream
+func (p *Pipeline) TempFile(pattern string) (*os.File, error) {
+	dir := ""
+	if p != nil {
+		dir = p.spillDir
+	}
+	return ioutil.TempFile(dir, pattern)
+}
+
+// NewAggregator returns an aggregator within the memory budget of the
+// pipeline
+func (p *Pipeline) NewAggregator() *Aggregator {
+	a := &Aggregator{sums: make(map[string][]float64)}
+	if p != nil {
+		a.dir = p.spillDir
+		a.budget = p.spillBudget
+	}
+	return a
+}
+
+// Aggregator sums up values by key. The sums exceeding the memory budget
+// are spilled to sorted partial files, which are merged once read.
+type Aggregator struct {
+	dir    string
+	budget int64
+
+	size   int64
+	sums   map[string][]float64
+	spills []string
+}
+
+// Add adds the values to the sums of the key
+func (a *Aggregator) Add(key string, values ...float64) error {
+	if sum, ok := a.sums[key]; ok {
+		for i := range sum {
+			sum[i] += values[i]
+		}
+		return nil
+	}
+	a.sums[key] = append([]float64(nil), values...)
+	a.size += int64(len(key)+8*len(values)) + entryOverhead
+	if a.budget > 0 && a.size > a.budget {
+		return a.spill()
+	}
+	return nil
+}
+
+// sorted returns the keys in memory in order
+func (a *Aggregator) sorted() []string {
+	keys := make([]string, 0, len(a.sums))
+	for key := range a.sums {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// spill writes the sums in memory to a partial file sorted by key
+func (a *Aggregator) spill() error {
+	f, err := ioutil.TempFile(a.dir, "spill-")
+	if err != nil {
+		return fmt.Errorf("error creating spill file: %s", err)
+	}
+	a.spills = append(a.spills, f.Name())
+
+	w := bufio.NewWriter(f)
+	for _, key := range a.sorted() {
+		if err := writeEntry(w, key, a.sums[key]); err != nil {
+			_ = f.Close()
+			return fmt.Errorf("error writing spill file: %s", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("error writing spill file: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error writing spill file: %s", err)
+	}
+
+	log.Debugf("spilled %d keys of %d bytes to %s", len(a.sums), a.size, f.Name())
+	a.sums = make(map[string][]float64)
+	a.size = 0
+	return nil
+}
+
+// Each calls fn with the sums in the order of their keys
+func (a *Aggregator) Each(fn func(key string, values []float64)) error {
+	if len(a.spills) == 0 {
+		for _, key := range a.sorted() {
+			fn(key, a.sums[key])
+		}
+		return nil
+	}
+
+	h := &mergeHeap{}
+	memory := a.sorted()
+	if len(memory) > 0 {
+		h.sources = append(h.sources, &mergeSource{next: func() (string, []float64, error) {
+			if len(memory) == 0 {
+				return "", nil, io.EOF
+			}
+			key := memory[0]
+			memory = memory[1:]
+			return key, a.sums[key], nil
+		}})
+	}
+	for _, name := range a.spills {
+		f, err := os.Open(name)
+		if err != nil {
+			return fmt.Errorf("error reading spill file: %s", err)
+		}
+		defer f.Close()
+		r := bufio.NewReader(f)
+		h.sources = append(h.sources, &mergeSource{next: func() (string, []float64, error) {
+			return readEntry(r)
+		}})
+	}
+	for i := 0; i < len(h.sources); {
+		if err := h.sources[i].advance(); err == io.EOF {
+			h.sources = append(h.sources[:i], h.sources[i+1:]...)
+			continue
+		} else if err != nil {
+			return fmt.Errorf("error reading spill file: %s", err)
+		}
+		i++
+	}
+	heap.Init(h)
+
+	var (
+		key    string
+		values []float64
+	)
+	for h.Len() > 0 {
+		s := h.sources[0]
+		if values != nil && s.key != key {
+			fn(key, values)
+			values = nil
+		}
+		if values == nil {
+			key, values = s.key, append([]float64(nil), s.values...)
+		} else {
+			for i := range values {
+				values[i] += s.values[i]
+			}
+		}
+
+		if err := s.advance(); err == io.EOF {
+			heap.Pop(h)
+		} else if err != nil {
+			return fmt.Errorf("error reading spill file: %s", err)
+		} else {
+			heap.Fix(h, 0)
+		}
+	}
+	if values != nil {
+		fn(key, values)
+	}
+	return nil
+}
+
+// Close removes the spill files
+func (a *Aggregator) Close() error {
+	var result error
+	for _, name := range a.spills {
+		if err := os.Remove(name); err != nil && result == nil {
+			result = err
+		}
+	}
+	a.spills = nil
+	return result
+}
+
+// writeEntry writes the length prefixed key followed by the values
+func writeEntry(w *bufio.Writer, key string, values []float64) error {
+	buf := make([]byte, binary.MaxVarintLen64)
+	for _, n := range []int{len(key), len(values)} {
+		if _, err := w.Write(buf[:binary.PutUvarint(buf, uint64(n))]); err != nil {
+			return err
+		}
+	}
+	if _, err := w.WriteString(key); err != nil {
+		return err
+	}
+	for _, v := range values {
+		binary.LittleEndian.PutUint64(buf, math.Float64bits(v))
+		if _, err := w.Write(buf[:8]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readEntry reads an entry written by writeEntry
+func readEntry(r *bufio.Reader) (string, []float64, error) {
+	keyLen, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", nil, err
+	}
+	valuesLen, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", nil, io.ErrUnexpectedEOF
+	}
+	key := make([]byte, keyLen)
+	if _, err := io.ReadFull(r, key); err != nil {
+		return "", nil, io.ErrUnexpectedEOF
+	}
+	values := make([]float64, valuesLen)
+	buf := make([]byte, 8)
+	for i := range values {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return "", nil, io.ErrUnexpectedEOF
+		}
+		values[i] = math.Float64frombits(binary.LittleEndian.Uint64(buf))
+	}
+	return string(key), values, nil
+}
+
+// mergeSource is a sorted source of sums, e.g. a spill file
+type mergeSource struct {
+	next   func() (string, []float64, error)
+	key    string
+	values []float64
+}
+
+func (s *mergeSource) advance() error {
+	key, values, err := s.next()
+	if err != nil {
+		return err
+	}
+	s.key, s.values = key, values
+	return nil
+}
+
+// mergeHeap orders the sources by their current key
+type mergeHeap struct {
+	sources []*mergeSource
+}
+
+func (h *mergeHeap) Len() int           { return len(h.sources) }
+func (h *mergeHeap) Less(i, j int) bool { return h.sources[i].key < h.sources[j].key }
+func (h *mergeHeap) Swap(i, j int)      { h.sources[i], h.sources[j] = h.sources[j], h.sources[i] }
+func (h *mergeHeap) Push(x interface{}) { h.sources = append(h.sources, x.(*mergeSource)) }
+func (h *mergeHeap) Pop() interface{} {
+	s := h.sources[len(h.sources)-1]
+	h.sources = h.sources[:len(h.sources)-1]
+	return s
+}
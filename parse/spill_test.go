@@ -0,0 +1,66 @@
+package parse
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAggregatorSpill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, budget := range []int64{0, 200} {
+		a := New("cloud", 1, 0).WithSpill(dir, budget).NewAggregator()
+		for i := 0; i < 100; i++ {
+			if err := a.Add(fmt.Sprintf("key-%d", i%7), 1, float64(i)); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		}
+		if budget > 0 && len(a.spills) == 0 {
+			t.Error("expected aggregation state to be spilled")
+		}
+
+		var sums []string
+		if err := a.Each(func(key string, values []float64) {
+			sums = append(sums, fmt.Sprintf("%s=%g/%g", key, values[0], values[1]))
+		}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if act, exp := strings.Join(sums, ","), "key-0=15/735,key-1=15/750,key-2=14/665,key-3=14/679,key-4=14/693,key-5=14/707,key-6=14/721"; act != exp {
+			t.Errorf("unexpected sums with budget %d: %s (expected: %s)", budget, act, exp)
+		}
+
+		if err := a.Close(); err != nil {
+			t.Errorf("unexpected error closing: %s", err)
+		}
+		if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 0 {
+			t.Errorf("unexpected spill files after closing: %v", files)
+		}
+	}
+}
+
+func TestPipelineTempFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := New("cloud", 1, 1).WithSpill(dir, 0)
+	defer p.Close()
+	f, err := p.TempFile("parquet-")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer f.Close()
+	if filepath.Dir(f.Name()) != dir || !strings.HasPrefix(filepath.Base(f.Name()), "parquet-") {
+		t.Errorf("expected temporary file in %s, got %s", dir, f.Name())
+	}
+}
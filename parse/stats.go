@@ -0,0 +1,57 @@
+package parse
+
+// Stats counts the rows of a report object, to detect data quality
+// regressions of the provider exports. A nil Stats counts nothing.
+type Stats struct {
+	rowsRead        int64
+	rowsAggregated  int64
+	rowsFiltered    int64
+	rowsInvalidCost int64
+	accounts        map[string]struct{}
+}
+
+// Read counts a row read from the report
+func (s *Stats) Read() {
+	if s != nil {
+		s.rowsRead++
+	}
+}
+
+// Aggregated counts a row aggregated into the costs of the account
+func (s *Stats) Aggregated(account string) {
+	if s == nil {
+		return
+	}
+	s.rowsAggregated++
+	if s.accounts == nil {
+		s.accounts = make(map[string]struct{})
+	}
+	s.accounts[account] = struct{}{}
+}
+
+// Filtered counts a row ignored by the record type filter
+func (s *Stats) Filtered() {
+	if s != nil {
+		s.rowsFiltered++
+	}
+}
+
+// InvalidCost counts a row with a cost, which couldn't be parsed
+func (s *Stats) InvalidCost() {
+	if s != nil {
+		s.rowsInvalidCost++
+	}
+}
+
+// Observe adds the stats of a parsed report object to the metrics of the
+// backend
+func (p *Pipeline) Observe(backend string, s *Stats) {
+	if p == nil || s == nil {
+		return
+	}
+	p.rowsRead.WithLabelValues(backend).Add(float64(s.rowsRead))
+	p.rowsAggregated.WithLabelValues(backend).Add(float64(s.rowsAggregated))
+	p.rowsSkipped.WithLabelValues(backend, "record_type").Add(float64(s.rowsFiltered))
+	p.rowsSkipped.WithLabelValues(backend, "invalid_cost").Add(float64(s.rowsInvalidCost))
+	p.accounts.WithLabelValues(backend).Set(float64(len(s.accounts)))
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+// permissionsTimeout limits the time of each permission check
+const permissionsTimeout = time.Minute
+
+// permissionsCollector is implemented by collectors listing the permissions
+// required by their API calls
+type permissionsCollector interface {
+	Permissions() []billing.Permission
+}
+
+// checkPermissions tests the permissions of the collectors and writes the
+// results as table, it returns false if any check failed
+func (b *BillingCollector) checkPermissions(ctx context.Context, w io.Writer) bool {
+	ok := true
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "COLLECTOR\tACTION\tRESOURCE\tRESULT")
+	for _, c := range b.collectors {
+		p, isPermissionsCollector := c.(permissionsCollector)
+		if !isPermissionsCollector {
+			continue
+		}
+		for _, permission := range p.Permissions() {
+			result := "pass"
+			if permission.Check == nil {
+				result = "not tested"
+			} else {
+				checkCtx, cancel := context.WithTimeout(ctx, permissionsTimeout)
+				if err := permission.Check(checkCtx); err != nil {
+					// API errors span multiple lines
+					result = fmt.Sprintf("FAIL: %s", strings.Join(strings.Fields(err.Error()), " "))
+					ok = false
+				}
+				cancel()
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c, permission.Action, permission.Resource, result)
+		}
+	}
+	_ = tw.Flush()
+	return ok
+}
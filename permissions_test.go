@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+type permissionsTestCollector struct {
+	fakeCollector
+	permissions []billing.Permission
+}
+
+func (c *permissionsTestCollector) Permissions() []billing.Permission {
+	return c.permissions
+}
+
+func TestCheckPermissions(t *testing.T) {
+	pass := func(context.Context) error { return nil }
+	fail := func(context.Context) error { return errors.New("AccessDenied:\n\tstatus code: 403") }
+	b := &BillingCollector{
+		collectors: []cloudBillingCollector{
+			&permissionsTestCollector{
+				fakeCollector: fakeCollector{cloud: "aws"},
+				permissions: []billing.Permission{
+					{Cloud: "aws", Action: "s3:ListBucket", Resource: "arn:aws:s3:::billing", Check: pass},
+					{Cloud: "aws", Action: "organizations:ListAccounts", Resource: "*", Check: fail},
+					{Cloud: "aws", Action: "organizations:DescribeOrganizationalUnit", Resource: "*"},
+				},
+			},
+			// collectors without permissions are skipped
+			&fakeCollector{cloud: "gcp"},
+		},
+	}
+
+	var out bytes.Buffer
+	if b.checkPermissions(context.Background(), &out) {
+		t.Error("expected failed check")
+	}
+	exp := `COLLECTOR  ACTION                                    RESOURCE              RESULT
+fake aws   s3:ListBucket                             arn:aws:s3:::billing  pass
+fake aws   organizations:ListAccounts                *                     FAIL: AccessDenied: status code: 403
+fake aws   organizations:DescribeOrganizationalUnit  *                     not tested
+`
+	if act := out.String(); act != exp {
+		t.Errorf("unexpected output:\n%s\nexpected:\n%s", act, exp)
+	}
+}
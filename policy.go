@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+type awsPolicy struct {
+	Version   string               `json:"Version"`
+	Statement []awsPolicyStatement `json:"Statement"`
+}
+
+type awsPolicyStatement struct {
+	Effect   string   `json:"Effect"`
+	Action   []string `json:"Action"`
+	Resource []string `json:"Resource"`
+}
+
+type gcpRole struct {
+	Title               string   `yaml:"title"`
+	Description         string   `yaml:"description"`
+	Stage               string   `yaml:"stage"`
+	IncludedPermissions []string `yaml:"includedPermissions"`
+}
+
+// permissions returns the permissions required by the collectors in the
+// given cloud
+func (b *BillingCollector) permissions(cloud string) []billing.Permission {
+	var permissions []billing.Permission
+	for _, c := range b.collectors {
+		p, ok := c.(permissionsCollector)
+		if !ok {
+			continue
+		}
+		for _, permission := range p.Permissions() {
+			if permission.Cloud == cloud {
+				permissions = append(permissions, permission)
+			}
+		}
+	}
+	return permissions
+}
+
+// writeAWSPolicy writes the minimal IAM policy for the configured collectors,
+// resources requiring the same actions share a statement
+func (b *BillingCollector) writeAWSPolicy(w io.Writer) error {
+	actionsByResource := make(map[string]map[string]bool)
+	for _, p := range b.permissions("aws") {
+		if actionsByResource[p.Resource] == nil {
+			actionsByResource[p.Resource] = make(map[string]bool)
+		}
+		actionsByResource[p.Resource][p.Action] = true
+	}
+
+	resourcesByActions := make(map[string][]string)
+	for resource, actions := range actionsByResource {
+		key := strings.Join(sortedKeys(actions), ",")
+		resourcesByActions[key] = append(resourcesByActions[key], resource)
+	}
+
+	policy := awsPolicy{Version: "2012-10-17", Statement: []awsPolicyStatement{}}
+	for actions, resources := range resourcesByActions {
+		sort.Strings(resources)
+		policy.Statement = append(policy.Statement, awsPolicyStatement{
+			Effect:   "Allow",
+			Action:   strings.Split(actions, ","),
+			Resource: resources,
+		})
+	}
+	sort.Slice(policy.Statement, func(i, j int) bool {
+		return policy.Statement[i].Resource[0] < policy.Statement[j].Resource[0]
+	})
+
+	data, err := json.MarshalIndent(policy, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+// writeGCPRole writes the custom role for the configured collectors, in the
+// format of gcloud iam roles create --file
+func (b *BillingCollector) writeGCPRole(w io.Writer) error {
+	permissions := make(map[string]bool)
+	for _, p := range b.permissions("gcp") {
+		permissions[p.Action] = true
+	}
+
+	data, err := yaml.Marshal(gcpRole{
+		Title:               AppNameLong,
+		Description:         fmt.Sprintf("Permissions required by the %s", AppNameLong),
+		Stage:               "GA",
+		IncludedPermissions: sortedKeys(permissions),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+func TestWriteAWSPolicyAndGCPRole(t *testing.T) {
+	b := &BillingCollector{
+		collectors: []cloudBillingCollector{
+			&permissionsTestCollector{
+				fakeCollector: fakeCollector{cloud: "aws"},
+				permissions: []billing.Permission{
+					{Cloud: "aws", Action: "sts:GetCallerIdentity", Resource: "*"},
+					{Cloud: "aws", Action: "s3:ListBucket", Resource: "arn:aws:s3:::billing"},
+					{Cloud: "aws", Action: "s3:GetObject", Resource: "arn:aws:s3:::billing/*"},
+					{Cloud: "aws", Action: "organizations:ListAccounts", Resource: "*"},
+				},
+			},
+			&permissionsTestCollector{
+				fakeCollector: fakeCollector{cloud: "gcp"},
+				permissions: []billing.Permission{
+					{Cloud: "gcp", Action: "storage.objects.list", Resource: "projects/_/buckets/billing"},
+					{Cloud: "gcp", Action: "storage.objects.get", Resource: "projects/_/buckets/billing/objects/my-billing*"},
+				},
+			},
+			&permissionsTestCollector{
+				fakeCollector: fakeCollector{cloud: "focus"},
+				permissions: []billing.Permission{
+					{Cloud: "aws", Action: "s3:ListBucket", Resource: "arn:aws:s3:::exports"},
+				},
+			},
+		},
+	}
+
+	var out bytes.Buffer
+	if err := b.writeAWSPolicy(&out); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	exp := `{
+  "Version": "2012-10-17",
+  "Statement": [
+    {
+      "Effect": "Allow",
+      "Action": [
+        "organizations:ListAccounts",
+        "sts:GetCallerIdentity"
+      ],
+      "Resource": [
+        "*"
+      ]
+    },
+    {
+      "Effect": "Allow",
+      "Action": [
+        "s3:ListBucket"
+      ],
+      "Resource": [
+        "arn:aws:s3:::billing",
+        "arn:aws:s3:::exports"
+      ]
+    },
+    {
+      "Effect": "Allow",
+      "Action": [
+        "s3:GetObject"
+      ],
+      "Resource": [
+        "arn:aws:s3:::billing/*"
+      ]
+    }
+  ]
+}
+`
+	if act := out.String(); act != exp {
+		t.Errorf("unexpected policy:\n%s\nexpected:\n%s", act, exp)
+	}
+
+	out.Reset()
+	if err := b.writeGCPRole(&out); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	exp = `title: Cloud Billing Exporter
+description: Permissions required by the Cloud Billing Exporter
+stage: GA
+includedPermissions:
+- storage.objects.get
+- storage.objects.list
+`
+	if act := out.String(); act != exp {
+		t.Errorf("unexpected role:\n%s\nexpected:\n%s", act, exp)
+	}
+}
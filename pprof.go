@@ -0,0 +1,21 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+)
+
+// registerPprof serves the runtime profiles below /debug/pprof/, e.g. to
+// diagnose slow collections. The mutex and block profiles are only recorded
+// with a fraction or rate above 0, as recording them slows down the exporter.
+func registerPprof(mux *http.ServeMux, mutexFraction, blockRate int) {
+	runtime.SetMutexProfileFraction(mutexFraction)
+	runtime.SetBlockProfileRate(blockRate)
+
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestRegisterPprof(t *testing.T) {
+	mux := http.NewServeMux()
+	registerPprof(mux, 5, 0)
+	defer runtime.SetMutexProfileFraction(0)
+
+	for path, exp := range map[string]string{
+		"/debug/pprof/":              "goroutine",
+		"/debug/pprof/mutex?debug=1": "--- mutex:",
+	} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("unexpected status of %s: %d", path, rec.Code)
+		}
+		if !strings.Contains(rec.Body.String(), exp) {
+			t.Errorf("unexpected body of %s: %s", path, rec.Body)
+		}
+	}
+
+	if act, exp := runtime.SetMutexProfileFraction(-1), 5; act != exp {
+		t.Errorf("unexpected mutex profile fraction: %d (expected: %d)", act, exp)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+// listPriceSource looks up list prices in a cloud provider's catalog
+type listPriceSource interface {
+	ListPrices(ctx context.Context) ([]billing.ListPrice, error)
+	String() string
+}
+
+// listPriceCollector exposes the list prices of all sources, which are
+// refreshed in the given interval
+type listPriceCollector struct {
+	desc     *prometheus.Desc
+	sources  []listPriceSource
+	interval time.Duration
+	now      func() time.Time
+
+	lock       sync.Mutex
+	prices     map[listPriceSource][]billing.ListPrice
+	lastUpdate map[listPriceSource]time.Time
+}
+
+func newListPriceCollector(interval time.Duration) *listPriceCollector {
+	return &listPriceCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "pricing", "list_price"),
+			"List price per usage unit of a SKU from the cloud provider's catalog, per pricing tier.",
+			billing.ListPriceLabels,
+			nil,
+		),
+		interval:   interval,
+		now:        time.Now,
+		prices:     make(map[listPriceSource][]billing.ListPrice),
+		lastUpdate: make(map[listPriceSource]time.Time),
+	}
+}
+
+func (c *listPriceCollector) withSource(s listPriceSource) *listPriceCollector {
+	c.sources = append(c.sources, s)
+	return c
+}
+
+func (c *listPriceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect exposes the prices of all sources, the prices of a source failing
+// to refresh are kept until the next successful refresh
+func (c *listPriceCollector) Collect(ch chan<- prometheus.Metric) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, s := range c.sources {
+		if c.now().Sub(c.lastUpdate[s]) >= c.interval {
+			prices, err := s.ListPrices(context.Background())
+			if err != nil {
+				log.Warnf("error updating list prices from %s: %s", s, err)
+			} else {
+				c.prices[s] = prices
+				c.lastUpdate[s] = c.now()
+			}
+		}
+
+		for _, p := range c.prices[s] {
+			m, err := prometheus.NewConstMetric(c.desc, prometheus.GaugeValue, p.Price, p.Labels()...)
+			if err != nil {
+				log.Warnf("error exposing list price %+v: %s", p, err)
+				continue
+			}
+			ch <- m
+		}
+	}
+}
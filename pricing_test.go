@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+type fakeListPriceSource struct {
+	calls int
+	err   error
+}
+
+func (f *fakeListPriceSource) ListPrices(_ context.Context) ([]billing.ListPrice, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return []billing.ListPrice{
+		{Cloud: "aws", Service: "AmazonEC2", SKU: "XJ7VP6NE6CBYRWJ7", Description: "m5.large", Region: "eu-west-1", Unit: "Hrs", Currency: "USD", TierStart: "0", Price: 0.107},
+	}, nil
+}
+
+func (f *fakeListPriceSource) String() string {
+	return "fake"
+}
+
+func TestListPriceCollector(t *testing.T) {
+	now := time.Date(2019, 11, 20, 10, 0, 0, 0, time.UTC)
+	source := &fakeListPriceSource{}
+	c := newListPriceCollector(time.Hour).withSource(source)
+	c.now = func() time.Time { return now }
+
+	exp := `
+# HELP cloud_pricing_list_price List price per usage unit of a SKU from the cloud provider's catalog, per pricing tier.
+# TYPE cloud_pricing_list_price gauge
+cloud_pricing_list_price{cloud="aws",currency="USD",description="m5.large",region="eu-west-1",service="AmazonEC2",sku="XJ7VP6NE6CBYRWJ7",tier_start="0",unit="Hrs"} 0.107
+`
+	for i := 0; i < 2; i++ {
+		if err := testutil.CollectAndCompare(c, strings.NewReader(exp)); err != nil {
+			t.Errorf("unexpected metrics: %s", err)
+		}
+	}
+	if source.calls != 1 {
+		t.Errorf("expected prices to be looked up once within the interval, got %d calls", source.calls)
+	}
+
+	// failed refreshes keep the previous prices
+	now = now.Add(time.Hour)
+	source.err = fmt.Errorf("throttled")
+	if err := testutil.CollectAndCompare(c, strings.NewReader(exp)); err != nil {
+		t.Errorf("unexpected metrics: %s", err)
+	}
+	if source.calls != 2 {
+		t.Errorf("expected prices to be refreshed after the interval, got %d calls", source.calls)
+	}
+}
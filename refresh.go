@@ -0,0 +1,62 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/log"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+// query queries the collectors in parallel, collectors backing off after
+// failed queries are skipped
+func (b BillingCollector) query() {
+	var wg sync.WaitGroup
+	for _, c := range b.collectors {
+		wg.Add(1)
+		go func(c cloudBillingCollector) {
+			defer wg.Done()
+			err := b.health.runQuery(c)
+			if err != nil {
+				log.Warnf("Error querying collector (%s): %s", c.String(), err)
+			}
+		}(c)
+	}
+	wg.Wait()
+	b.publish()
+}
+
+// publish hands the records of the last queries to the history, the
+// corrections and the sinks, so they are updated in the background refresh
+// independent of scrapes. The history and the sinks get the records of all
+// clouds, independent of the clouds selected by collect[].
+func (b BillingCollector) publish() {
+	collectors := b.collectors
+	if b.cloudFilter != nil {
+		collectors = b.allCollectors
+	}
+	b.history.update(collectors)
+	b.corrections.update(b.records())
+	if b.sinkWriter != nil {
+		var records []billing.Record
+		for _, c := range collectors {
+			records = append(records, c.Records()...)
+		}
+		b.sinkWriter.enqueue(records, b.lineItems())
+	}
+}
+
+// runRefresh queries the collectors right away and then in the interval,
+// metrics requests are served from the results of the last queries
+func (b *BillingCollector) runRefresh(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		start := time.Now()
+		b.query()
+		log.Debugf("refreshed %d collectors in %s", len(b.collectors), time.Since(start))
+		<-ticker.C
+	}
+}
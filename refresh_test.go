@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+func TestRefresh(t *testing.T) {
+	c := &fakeCollector{cloud: "gcp"}
+	b := &BillingCollector{collectors: []cloudBillingCollector{c}}
+	b.initMetrics()
+
+	collect := func() {
+		ch := make(chan prometheus.Metric)
+		go func() {
+			b.collect(ch)
+			close(ch)
+		}()
+		for range ch {
+		}
+	}
+
+	collect()
+	if c.queries != 1 {
+		t.Fatalf("unexpected number of queries per metrics request: %d (expected: 1)", c.queries)
+	}
+
+	// collectors refreshed in the background are served from the last query
+	b.refreshInterval = time.Hour
+	collect()
+	collect()
+	if c.queries != 1 {
+		t.Errorf("unexpected number of queries: %d (expected: 1)", c.queries)
+	}
+	b.query()
+	if c.queries != 2 {
+		t.Errorf("unexpected number of queries: %d (expected: 2)", c.queries)
+	}
+}
+
+func TestRefreshPublishes(t *testing.T) {
+	c := &fakeCollector{cloud: "gcp", records: []billing.Record{{Cloud: "gcp", Month: "2019-11", Account: "a", Costs: 10}}}
+	b := &BillingCollector{collectors: []cloudBillingCollector{c}}
+	b.initMetrics()
+	b.refreshInterval = time.Hour
+	b.sinkWriter = newSinkWriter(nil)
+
+	// the history, the corrections and the sinks are updated without any
+	// metrics request
+	b.query()
+	if act := b.history.records("2019-11"); len(act) != 1 {
+		t.Errorf("unexpected history: %+v", act)
+	}
+	if act := len(b.sinkWriter.queue); act != 1 {
+		t.Errorf("unexpected number of queued collections: %d (expected: 1)", act)
+	}
+
+	c.records = []billing.Record{{Cloud: "gcp", Month: "2019-11", Account: "a", Costs: 8}}
+	b.query()
+	if act := testutil.ToFloat64(b.corrections.corrections.WithLabelValues("gcp")); act != 1 {
+		t.Errorf("unexpected number of corrections: %f (expected: 1)", act)
+	}
+}
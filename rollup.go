@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// hierarchyRollupCollector aggregates the monthly costs on every level of the
+// AWS organizational unit / GCP folder hierarchy
+type hierarchyRollupCollector struct {
+	costs *prometheus.CounterVec
+	desc  *prometheus.Desc
+}
+
+func newHierarchyRollupCollector(costs *prometheus.CounterVec) *hierarchyRollupCollector {
+	return &hierarchyRollupCollector{
+		costs: costs,
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "billing", "monthly_costs_hierarchy"),
+			"Billed costs per calendar month aggregated on each level of the organization hierarchy.",
+			[]string{"cloud", "currency", "hierarchy_level", "path"},
+			nil,
+		),
+	}
+}
+
+func (h *hierarchyRollupCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- h.desc
+}
+
+func (h *hierarchyRollupCollector) Collect(ch chan<- prometheus.Metric) {
+	totals := make(map[[4]string]float64)
+	for _, s := range collectSeries(h.costs) {
+		path := s.labels["path"]
+		if path == "" {
+			continue
+		}
+
+		parts := strings.Split(path, "/")
+		for i := range parts {
+			key := [4]string{
+				s.labels["cloud"],
+				s.labels["currency"],
+				strconv.Itoa(i + 1),
+				strings.Join(parts[:i+1], "/"),
+			}
+			totals[key] += s.value
+		}
+	}
+
+	for key, value := range totals {
+		ch <- prometheus.MustNewConstMetric(h.desc, prometheus.CounterValue, value, key[:]...)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+func TestHierarchyRollup(t *testing.T) {
+	costs := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+	costs.WithLabelValues("aws", "USD", "dev", "AmazonEC2", "example.com/eng/team-a", "", "", "").Add(10)
+	costs.WithLabelValues("aws", "USD", "prod", "AmazonEC2", "example.com/eng/team-b", "", "", "").Add(20)
+	costs.WithLabelValues("aws", "USD", "shared", "AmazonS3", "example.com", "", "", "").Add(5)
+	costs.WithLabelValues("aws", "USD", "unknown", "AmazonS3", "", "", "", "").Add(100)
+
+	act := map[string]float64{}
+	for _, s := range collectSeries(newHierarchyRollupCollector(costs)) {
+		act[s.labels["hierarchy_level"]+" "+s.labels["path"]] = s.value
+	}
+
+	exp := map[string]float64{
+		"1 example.com":            35,
+		"2 example.com/eng":        30,
+		"3 example.com/eng/team-a": 10,
+		"3 example.com/eng/team-b": 20,
+	}
+	if len(act) != len(exp) {
+		t.Errorf("unexpected rollups: act: %+v, exp: %+v", act, exp)
+	}
+	for key, value := range exp {
+		if act[key] != value {
+			t.Errorf("unexpected value for '%s': act: %f, exp: %f", key, act[key], value)
+		}
+	}
+}
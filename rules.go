@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	yaml "gopkg.in/yaml.v2"
+)
+
+type ruleGroups struct {
+	Groups []ruleGroup `yaml:"groups"`
+}
+
+type ruleGroup struct {
+	Name  string `yaml:"name"`
+	Rules []rule `yaml:"rules"`
+}
+
+type rule struct {
+	Record      string            `yaml:"record,omitempty"`
+	Alert       string            `yaml:"alert,omitempty"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+type rulesOptions struct {
+	// Staleness is the time without any cost updates after which the data is
+	// considered stale
+	Staleness model.Duration
+	// AnomalyFactor is the factor by which the daily costs need to exceed the
+	// average of the last week to trigger the anomaly alert
+	AnomalyFactor float64
+	// Budget is the limit of costs within 30 days, no budget alert is
+	// generated if it is 0
+	Budget float64
+}
+
+// rules builds recording and alerting rules for the given label names of the
+// monthly costs metric
+func rules(labels []string, opts rulesOptions) *ruleGroups {
+	costs := prometheus.BuildFQName(Namespace, "billing", "monthly_costs")
+	daily := "cloud_account:" + costs + ":increase1d"
+	monthly := "cloud:" + costs + ":increase30d"
+
+	recording := ruleGroup{
+		Name: AppName + ".rules",
+		Rules: []rule{
+			{
+				Record: daily,
+				Expr:   fmt.Sprintf("sum by (cloud, account, currency) (increase(%s[1d]))", costs),
+			},
+			{
+				Record: monthly,
+				Expr:   fmt.Sprintf("sum by (cloud, currency) (increase(%s[30d]))", costs),
+			},
+		},
+	}
+	for _, l := range labels {
+		if fixedCostsLabels[l] {
+			continue
+		}
+		recording.Rules = append(recording.Rules, rule{
+			Record: fmt.Sprintf("%s:%s:increase1d", l, costs),
+			Expr:   fmt.Sprintf("sum by (cloud, %s, currency) (increase(%s[1d]))", l, costs),
+		}, rule{
+			Record: fmt.Sprintf("%s:%s:increase30d", l, costs),
+			Expr:   fmt.Sprintf("sum by (cloud, %s, currency) (increase(%s[30d]))", l, costs),
+		})
+	}
+
+	alerting := ruleGroup{
+		Name: AppName + ".alerts",
+		Rules: []rule{
+			{
+				Alert: "CloudBillingDataStale",
+				Expr:  fmt.Sprintf("sum by (cloud) (changes(%s[%s])) == 0", costs, opts.Staleness),
+				For:   "1h",
+				Labels: map[string]string{
+					"severity": "warning",
+				},
+				Annotations: map[string]string{
+					"summary":     "Billing data of {{ $labels.cloud }} is stale",
+					"description": fmt.Sprintf("No costs of {{ $labels.cloud }} have been updated for %s.", opts.Staleness),
+				},
+			},
+			{
+				Alert: "CloudBillingScrapeFailing",
+				Expr:  fmt.Sprintf("%s_billing_scrape_success == 0", Namespace),
+				For:   "1h",
+				Labels: map[string]string{
+					"severity": "warning",
+				},
+				Annotations: map[string]string{
+					"summary":     "Billing source {{ $labels.source }} of {{ $labels.cloud }} fails",
+					"description": "The queries of the billing source {{ $labels.source }} have been failing for an hour, its costs are not updated.",
+				},
+			},
+			{
+				Alert: "CloudBillingCostAnomaly",
+				Expr:  fmt.Sprintf("%s > %g * avg_over_time(%s[7d])", daily, opts.AnomalyFactor, daily),
+				For:   "3h",
+				Labels: map[string]string{
+					"severity": "warning",
+				},
+				Annotations: map[string]string{
+					"summary":     "Unusual costs in {{ $labels.cloud }} account {{ $labels.account }}",
+					"description": fmt.Sprintf("Daily costs of {{ $value | printf \"%%.2f\" }} {{ $labels.currency }} are more than %g times the average of the last week.", opts.AnomalyFactor),
+				},
+			},
+		},
+	}
+	if opts.Budget > 0 {
+		alerting.Rules = append(alerting.Rules, rule{
+			Alert: "CloudBillingBudgetExceeded",
+			Expr:  fmt.Sprintf("%s > %g", monthly, opts.Budget),
+			Labels: map[string]string{
+				"severity": "critical",
+			},
+			Annotations: map[string]string{
+				"summary":     "Costs of {{ $labels.cloud }} exceeded the budget",
+				"description": fmt.Sprintf("Costs within the last 30 days are {{ $value | printf \"%%.2f\" }} {{ $labels.currency }}, the budget is %g.", opts.Budget),
+			},
+		})
+	}
+
+	return &ruleGroups{Groups: []ruleGroup{recording, alerting}}
+}
+
+func (b *BillingCollector) writeRules(w io.Writer) error {
+	staleness, err := model.ParseDuration(*b.RulesStaleness)
+	if err != nil {
+		return fmt.Errorf("invalid staleness '%s': %s", *b.RulesStaleness, err)
+	}
+
+	data, err := yaml.Marshal(rules(b.monthlyCosts.labels, rulesOptions{
+		Staleness:     staleness,
+		AnomalyFactor: *b.RulesAnomalyFactor,
+		Budget:        *b.RulesBudget,
+	}))
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
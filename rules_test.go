@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+func TestRulesBudgetAlert(t *testing.T) {
+	opts := rulesOptions{
+		Staleness:     model.Duration(48 * time.Hour),
+		AnomalyFactor: 1.5,
+	}
+
+	alerts := func(g *ruleGroups) map[string]rule {
+		m := map[string]rule{}
+		for _, r := range g.Groups[1].Rules {
+			m[r.Alert] = r
+		}
+		return m
+	}
+
+	if _, ok := alerts(rules(billing.MonthlyCostsLabels, opts))["CloudBillingBudgetExceeded"]; ok {
+		t.Error("unexpected budget alert without budget")
+	}
+
+	opts.Budget = 1000
+	r, ok := alerts(rules(billing.MonthlyCostsLabels, opts))["CloudBillingBudgetExceeded"]
+	if !ok {
+		t.Fatal("expected budget alert")
+	}
+	if exp := "cloud:cloud_billing_monthly_costs:increase30d > 1000"; r.Expr != exp {
+		t.Errorf("unexpected expression: act: %s, exp: %s", r.Expr, exp)
+	}
+}
+
+func TestRulesPerLabelRollups(t *testing.T) {
+	g := rules([]string{"cloud", "currency", "account", "service", "team"}, rulesOptions{Staleness: model.Duration(time.Hour)})
+
+	records := map[string]string{}
+	for _, r := range g.Groups[0].Rules {
+		records[r.Record] = r.Expr
+	}
+
+	exp := "sum by (cloud, team, currency) (increase(cloud_billing_monthly_costs[1d]))"
+	if act := records["team:cloud_billing_monthly_costs:increase1d"]; act != exp {
+		t.Errorf("unexpected team rollup: act: %s, exp: %s", act, exp)
+	}
+	if _, ok := records["service:cloud_billing_monthly_costs:increase1d"]; ok {
+		t.Error("unexpected rollup for fixed label")
+	}
+}
+
+func TestWriteRulesEnricherLabels(t *testing.T) {
+	staleness, factor, budget := "48h", 1.5, 0.0
+	b := &BillingCollector{RulesStaleness: &staleness, RulesAnomalyFactor: &factor, RulesBudget: &budget}
+	b.initMetrics()
+	b.monthlyCosts.withEnricher(staticTeams{})
+
+	var buf bytes.Buffer
+	if err := b.writeRules(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "team:cloud_billing_monthly_costs:increase1d") {
+		t.Error("expected a rollup for the team label of the enricher")
+	}
+}
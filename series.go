@@ -0,0 +1,47 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/log"
+)
+
+// series is a single metric with its label values
+type series struct {
+	labels map[string]string
+	value  float64
+}
+
+// collectSeries reads the current value of all counters and gauges of a
+// collector
+func collectSeries(c prometheus.Collector) []series {
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(metrics)
+		close(metrics)
+	}()
+
+	var result []series
+	for m := range metrics {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			log.Warnf("error reading metric %s: %s", m.Desc(), err)
+			continue
+		}
+
+		s := series{labels: make(map[string]string, len(pb.Label))}
+		for _, l := range pb.Label {
+			s.labels[l.GetName()] = l.GetValue()
+		}
+		switch {
+		case pb.Counter != nil:
+			s.value = pb.Counter.GetValue()
+		case pb.Gauge != nil:
+			s.value = pb.Gauge.GetValue()
+		default:
+			continue
+		}
+		result = append(result, s)
+	}
+	return result
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+// costShareCollector exposes each account's share of the total month-to-date
+// costs, which is used for proportional chargeback of shared costs
+type costShareCollector struct {
+	desc *prometheus.Desc
+}
+
+func newCostShareCollector() *costShareCollector {
+	return &costShareCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "billing", "account_costs_ratio"),
+			"Share of the account's month-to-date costs in the total costs of all collected clouds in the same month and currency.",
+			[]string{"cloud", "currency", "account"},
+			nil,
+		),
+	}
+}
+
+func (c *costShareCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *costShareCollector) collect(records []billing.Record, ch chan<- prometheus.Metric) {
+	// clouds can be on different billing months around the turn of the month,
+	// so the totals are per month
+	totals := make(map[[2]string]float64)
+	accounts := make(map[[4]string]float64)
+	for _, r := range records {
+		totals[[2]string{r.Month, r.Currency}] += r.Costs
+		accounts[[4]string{r.Month, r.Cloud, r.Currency, r.Account}] += r.Costs
+	}
+
+	for key, costs := range accounts {
+		total := totals[[2]string{key[0], key[2]}]
+		if total <= 0 {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, costs/total, key[1:]...)
+	}
+}
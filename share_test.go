@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+func TestCostShare(t *testing.T) {
+	share := newCostShareCollector()
+
+	records := []billing.Record{
+		{Cloud: "aws", Month: "2020-02", Currency: "USD", Account: "a", Service: "AmazonEC2", Costs: 10},
+		{Cloud: "aws", Month: "2020-02", Currency: "USD", Account: "a", Service: "AmazonS3", Costs: 15},
+		{Cloud: "gcp", Month: "2020-02", Currency: "USD", Account: "b", Service: "compute", Costs: 75},
+		{Cloud: "gcp", Month: "2020-02", Currency: "EUR", Account: "c", Service: "compute", Costs: 5},
+		// still on the previous billing month
+		{Cloud: "azure", Month: "2020-01", Currency: "USD", Account: "d", Service: "compute", Costs: 500},
+	}
+
+	exp := `
+# HELP cloud_billing_account_costs_ratio Share of the account's month-to-date costs in the total costs of all collected clouds in the same month and currency.
+# TYPE cloud_billing_account_costs_ratio gauge
+cloud_billing_account_costs_ratio{account="a",cloud="aws",currency="USD"} 0.25
+cloud_billing_account_costs_ratio{account="b",cloud="gcp",currency="USD"} 0.75
+cloud_billing_account_costs_ratio{account="c",cloud="gcp",currency="EUR"} 1
+cloud_billing_account_costs_ratio{account="d",cloud="azure",currency="USD"} 1
+`
+	c := &recordsCollector{records: records, describe: share.Describe, collect: share.collect}
+	if err := testutil.CollectAndCompare(c, strings.NewReader(exp)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCostShareFiltered(t *testing.T) {
+	b := &BillingCollector{
+		collectors: []cloudBillingCollector{
+			&fakeCollector{cloud: "aws", records: []billing.Record{
+				{Cloud: "aws", Month: "2020-02", Currency: "USD", Account: "a", Service: "AmazonEC2", Costs: 25},
+			}},
+			&fakeCollector{cloud: "gcp", records: []billing.Record{
+				{Cloud: "gcp", Month: "2020-02", Currency: "USD", Account: "b", Service: "compute", Costs: 75},
+			}},
+		},
+	}
+	b.initMetrics()
+
+	f, err := b.filtered([]string{"gcp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := `
+# HELP cloud_billing_account_costs_ratio Share of the account's month-to-date costs in the total costs of all collected clouds in the same month and currency.
+# TYPE cloud_billing_account_costs_ratio gauge
+cloud_billing_account_costs_ratio{account="b",cloud="gcp",currency="USD"} 0.75
+`
+	if err := testutil.CollectAndCompare(f, strings.NewReader(exp), "cloud_billing_account_costs_ratio"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,248 @@
+// Package simulate replays the costs of a fixture month against an
+// accelerated clock, e.g. to develop dashboards and alerts around month
+// rollovers without waiting for a month to pass.
+package simulate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+	"github.com/simonswine/cloud-billing-exporter/state"
+)
+
+const (
+	monthFormat = "2006-01"
+	dateFormat  = "2006-01-02"
+)
+
+// Clock advances from the start time speed times faster than the real time
+type Clock struct {
+	start time.Time
+	speed float64
+
+	realStart time.Time
+	now       func() time.Time
+}
+
+// NewClock returns a clock starting now at the given time
+func NewClock(start time.Time, speed float64) *Clock {
+	return &Clock{
+		start:     start,
+		speed:     speed,
+		realStart: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// Now returns the simulated time
+func (c *Clock) Now() time.Time {
+	elapsed := c.now().Sub(c.realStart)
+	return c.start.Add(time.Duration(float64(elapsed) * c.speed))
+}
+
+// Simulation exposes the line items of a fixture month as the costs of the
+// simulated months. The costs of a day of the fixture are reported once the
+// simulated day is over, like the daily billing reports. Days of the fixture
+// missing in shorter months are reported on their last day.
+type Simulation struct {
+	cloud string
+	path  string
+	clock *Clock
+	items []billing.LineItem
+
+	lock         sync.Mutex
+	records      []billing.Record
+	lineItems    []billing.LineItem
+	closedMonths []billing.Record
+
+	MetricMonthlyCosts *prometheus.CounterVec
+	metricRefunds      *prometheus.CounterVec
+	onDecrease         billing.OnDecrease
+	metricValues       map[string]state.Baseline
+}
+
+// NewSimulation reads the line items of the fixture from a JSON file, as
+// served by /api/v1/line_items of a month. The costs are exposed with the
+// given cloud label.
+func NewSimulation(metric *prometheus.CounterVec, path, cloud string, clock *Clock) (*Simulation, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var items []billing.LineItem
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, fmt.Errorf("error parsing fixture '%s': %s", path, err)
+	}
+	for _, i := range items {
+		if _, err := time.Parse(dateFormat, i.Date); err != nil {
+			return nil, fmt.Errorf("line item of fixture '%s' without daily granularity: %s", path, err)
+		}
+	}
+
+	s := newSimulation(metric, items, cloud, clock)
+	s.path = path
+	return s, nil
+}
+
+func newSimulation(metric *prometheus.CounterVec, items []billing.LineItem, cloud string, clock *Clock) *Simulation {
+	return &Simulation{
+		cloud:              cloud,
+		clock:              clock,
+		items:              items,
+		MetricMonthlyCosts: metric,
+		metricValues:       make(map[string]state.Baseline),
+	}
+}
+
+// WithOnDecrease selects how falling costs are handled, the refunds counter
+// is used by billing.OnDecreaseRefundMetric. The costs of a simulated month
+// never fall, the counters continue with the costs of the next month.
+func (s *Simulation) WithOnDecrease(mode billing.OnDecrease, refunds *prometheus.CounterVec) *Simulation {
+	s.onDecrease = mode
+	s.metricRefunds = refunds
+	return s
+}
+
+// month returns the line items of the fixture moved to the month, which are
+// reported until the day
+func (s *Simulation) month(month time.Time, until time.Time) []billing.LineItem {
+	lastDay := month.AddDate(0, 1, -1).Day()
+	var items []billing.LineItem
+	for _, i := range s.items {
+		date, _ := time.Parse(dateFormat, i.Date)
+		day := date.Day()
+		if day > lastDay {
+			day = lastDay
+		}
+		date = time.Date(month.Year(), month.Month(), day, 0, 0, 0, 0, time.UTC)
+		if !date.Before(until) {
+			continue
+		}
+		i.Cloud = s.cloud
+		i.Month = date.Format(monthFormat)
+		i.Date = date.Format(dateFormat)
+		items = append(items, i)
+	}
+	return items
+}
+
+// monthRecords sums up the line items per account, service and currency
+func monthRecords(items []billing.LineItem) []billing.Record {
+	records := make(map[string]*billing.Record)
+	for _, i := range items {
+		key := strings.Join([]string{i.Account, i.Service, i.Currency}, "\x00")
+		r, ok := records[key]
+		if !ok {
+			r = &billing.Record{
+				Cloud:    i.Cloud,
+				Month:    i.Month,
+				Currency: i.Currency,
+				Account:  i.Account,
+				Service:  i.Service,
+			}
+			records[key] = r
+		}
+		r.Costs += i.Costs
+	}
+
+	result := make([]billing.Record, 0, len(records))
+	for _, r := range records {
+		result = append(result, *r)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Account != b.Account {
+			return a.Account < b.Account
+		}
+		if a.Service != b.Service {
+			return a.Service < b.Service
+		}
+		return a.Currency < b.Currency
+	})
+	return result
+}
+
+// Query updates the costs to the simulated time
+func (s *Simulation) Query() error {
+	now := s.clock.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	previous := month.AddDate(0, -1, 0)
+
+	lineItems := s.month(month, today)
+	records := monthRecords(lineItems)
+	closedMonths := monthRecords(s.month(previous, month))
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// the baselines of the previous month are ignored, the counters
+	// continue with the costs of the new month
+	for _, r := range records {
+		labels := r.Labels()
+		s.update(strings.Join(labels, "\x00"), labels, r.Month, r.Costs)
+	}
+
+	s.records = records
+	s.lineItems = lineItems
+	s.closedMonths = closedMonths
+	log.Debugf("simulated %s: %d records", now.Format(time.RFC3339), len(records))
+	return nil
+}
+
+// update advances the monthly costs counter of the series by the costs of
+// the month, it needs to be called with the lock held
+func (s *Simulation) update(key string, labels []string, month string, value float64) {
+	previous, ok := s.metricValues[key]
+	if ok && previous.Value == value && previous.Month == month && reflect.DeepEqual(previous.Labels, labels) {
+		return
+	}
+	if !s.onDecrease.AddCosts(s.MetricMonthlyCosts, s.metricRefunds, labels, previous.Previous(month), value) {
+		return
+	}
+	s.metricValues[key] = state.Baseline{Labels: labels, Value: value, Month: month}
+}
+
+// Records returns the costs of the simulated month
+func (s *Simulation) Records() []billing.Record {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]billing.Record(nil), s.records...)
+}
+
+// LineItems returns the costs per day of the simulated month
+func (s *Simulation) LineItems() []billing.LineItem {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]billing.LineItem(nil), s.lineItems...)
+}
+
+// ClosedMonths returns the costs of the simulated month before
+func (s *Simulation) ClosedMonths() []billing.Record {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]billing.Record(nil), s.closedMonths...)
+}
+
+func (s *Simulation) Test() error {
+	return s.Query()
+}
+
+func (s *Simulation) String() string {
+	return fmt.Sprintf("simulation of %s", s.path)
+}
+
+// Cloud returns the name of the cloud, as used in the cloud label
+func (s *Simulation) Cloud() string {
+	return s.cloud
+}
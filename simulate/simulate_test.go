@@ -0,0 +1,95 @@
+package simulate
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+func TestClock(t *testing.T) {
+	realNow := time.Date(2019, 11, 20, 12, 0, 0, 0, time.UTC)
+	c := NewClock(time.Date(2019, 11, 1, 0, 0, 0, 0, time.UTC), 720)
+	c.realStart = realNow
+	c.now = func() time.Time { return realNow.Add(30 * time.Minute) }
+
+	if act, exp := c.Now(), time.Date(2019, 11, 16, 0, 0, 0, 0, time.UTC); !act.Equal(exp) {
+		t.Errorf("unexpected time: %s (expected: %s)", act, exp)
+	}
+}
+
+func TestSimulation(t *testing.T) {
+	items := []billing.LineItem{
+		{Month: "2019-10", Date: "2019-10-01", Currency: "USD", Account: "prod", Service: "Compute", Costs: 10},
+		{Month: "2019-10", Date: "2019-10-02", Currency: "USD", Account: "prod", Service: "Compute", Costs: 5},
+		{Month: "2019-10", Date: "2019-10-31", Currency: "USD", Account: "prod", Service: "Storage", Costs: 1},
+	}
+	metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "costs"}, billing.MonthlyCostsLabels)
+	refunds := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "refunds"}, billing.MonthlyCostsLabels)
+	now := time.Date(2019, 11, 2, 12, 0, 0, 0, time.UTC)
+	clock := &Clock{start: now, speed: 1, realStart: now, now: func() time.Time { return now }}
+	s := newSimulation(metric, items, "simulation", clock).WithOnDecrease(billing.OnDecreaseRefundMetric, refunds)
+	compute := []string{"simulation", "USD", "prod", "Compute", "", "", "", ""}
+	storage := []string{"simulation", "USD", "prod", "Storage", "", "", "", ""}
+
+	// only the costs of the days which are over are reported
+	if err := s.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expRecords := []billing.Record{
+		{Cloud: "simulation", Month: "2019-11", Currency: "USD", Account: "prod", Service: "Compute", Costs: 10},
+	}
+	if act := s.Records(); !reflect.DeepEqual(act, expRecords) {
+		t.Errorf("unexpected records: act: %+v, exp: %+v", act, expRecords)
+	}
+	expClosed := []billing.Record{
+		{Cloud: "simulation", Month: "2019-10", Currency: "USD", Account: "prod", Service: "Compute", Costs: 15},
+		{Cloud: "simulation", Month: "2019-10", Currency: "USD", Account: "prod", Service: "Storage", Costs: 1},
+	}
+	if act := s.ClosedMonths(); !reflect.DeepEqual(act, expClosed) {
+		t.Errorf("unexpected closed months: act: %+v, exp: %+v", act, expClosed)
+	}
+
+	now = time.Date(2019, 11, 30, 12, 0, 0, 0, time.UTC)
+	if err := s.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if act := testutil.ToFloat64(metric.WithLabelValues(compute...)); act != 15 {
+		t.Errorf("unexpected compute costs: %f", act)
+	}
+
+	// the month rollover is no decrease, the 31st of the fixture is
+	// reported on the last day of November
+	now = time.Date(2019, 12, 1, 0, 0, 0, 0, time.UTC)
+	if err := s.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expClosed = []billing.Record{
+		{Cloud: "simulation", Month: "2019-11", Currency: "USD", Account: "prod", Service: "Compute", Costs: 15},
+		{Cloud: "simulation", Month: "2019-11", Currency: "USD", Account: "prod", Service: "Storage", Costs: 1},
+	}
+	if act := s.ClosedMonths(); !reflect.DeepEqual(act, expClosed) {
+		t.Errorf("unexpected closed months: act: %+v, exp: %+v", act, expClosed)
+	}
+	if act := testutil.ToFloat64(refunds.WithLabelValues(compute...)); act != 0 {
+		t.Errorf("unexpected compute refunds: %f", act)
+	}
+	if act := testutil.ToFloat64(metric.WithLabelValues(compute...)); act != 15 {
+		t.Errorf("unexpected compute costs: %f", act)
+	}
+	if act := testutil.ToFloat64(metric.WithLabelValues(storage...)); act != 0 {
+		t.Errorf("unexpected storage costs: %f", act)
+	}
+
+	now = time.Date(2019, 12, 2, 0, 0, 0, 0, time.UTC)
+	if err := s.Query(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if act := testutil.ToFloat64(metric.WithLabelValues(compute...)); act != 25 {
+		t.Errorf("unexpected compute costs: %f", act)
+	}
+}
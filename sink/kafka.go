@@ -0,0 +1,88 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+const (
+	kafkaTypeRecord   = "record"
+	kafkaTypeLineItem = "line_item"
+)
+
+// messageWriter is implemented by kafka.Writer
+type messageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// Kafka publishes the records and line items as JSON messages to a topic.
+// Messages are keyed by cloud and account and carry their kind in the type
+// header.
+type Kafka struct {
+	writer messageWriter
+	topic  string
+}
+
+func NewKafka(brokers, topic string) (*Kafka, error) {
+	if topic == "" {
+		return nil, fmt.Errorf("no kafka topic given")
+	}
+	config := kafka.WriterConfig{
+		Brokers:  strings.Split(brokers, ","),
+		Topic:    topic,
+		Balancer: &kafka.Hash{},
+	}
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid kafka configuration: %s", err)
+	}
+	return &Kafka{
+		writer: kafka.NewWriter(config),
+		topic:  topic,
+	}, nil
+}
+
+func kafkaMessage(kind, cloud, account string, v interface{}) (kafka.Message, error) {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return kafka.Message{}, err
+	}
+	return kafka.Message{
+		Key:     []byte(cloud + "/" + account),
+		Value:   value,
+		Headers: []kafka.Header{{Key: "type", Value: []byte(kind)}},
+	}, nil
+}
+
+func (k *Kafka) Write(ctx context.Context, records []billing.Record) error {
+	msgs := make([]kafka.Message, 0, len(records))
+	for _, r := range records {
+		m, err := kafkaMessage(kafkaTypeRecord, r.Cloud, r.Account, r)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, m)
+	}
+	return k.writer.WriteMessages(ctx, msgs...)
+}
+
+func (k *Kafka) WriteLineItems(ctx context.Context, items []billing.LineItem) error {
+	msgs := make([]kafka.Message, 0, len(items))
+	for _, i := range items {
+		m, err := kafkaMessage(kafkaTypeLineItem, i.Cloud, i.Account, i)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, m)
+	}
+	return k.writer.WriteMessages(ctx, msgs...)
+}
+
+func (k *Kafka) String() string {
+	return fmt.Sprintf("kafka topic %s", k.topic)
+}
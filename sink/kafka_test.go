@@ -0,0 +1,56 @@
+package sink
+
+import (
+	"context"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+type fakeMessageWriter struct {
+	msgs []kafka.Message
+}
+
+func (f *fakeMessageWriter) WriteMessages(_ context.Context, msgs ...kafka.Message) error {
+	f.msgs = append(f.msgs, msgs...)
+	return nil
+}
+
+func TestKafkaWrite(t *testing.T) {
+	w := &fakeMessageWriter{}
+	k := &Kafka{writer: w, topic: "billing"}
+
+	if err := k.Write(context.Background(), []billing.Record{
+		{Cloud: "aws", Month: "2019-11", Currency: "USD", Account: "a", Service: "AmazonEC2", Costs: 10.5},
+	}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := k.WriteLineItems(context.Background(), []billing.LineItem{
+		{Cloud: "gcp", Month: "2019-11", Date: "2019-11-02", Currency: "USD", Account: "b", Service: "compute", Costs: 1},
+	}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(w.msgs) != 2 {
+		t.Fatalf("unexpected number of messages: %d", len(w.msgs))
+	}
+	for i, exp := range []struct {
+		key, kind, value string
+	}{
+		{"aws/a", "record", `{"cloud":"aws","month":"2019-11","currency":"USD","account":"a","service":"AmazonEC2","path":"","owner":"","cost_centre":"","type":"","costs":10.5}`},
+		{"gcp/b", "line_item", `{"cloud":"gcp","month":"2019-11","date":"2019-11-02","currency":"USD","account":"b","service":"compute","costs":1}`},
+	} {
+		m := w.msgs[i]
+		if string(m.Key) != exp.key {
+			t.Errorf("unexpected key: act: %s, exp: %s", m.Key, exp.key)
+		}
+		if len(m.Headers) != 1 || string(m.Headers[0].Value) != exp.kind {
+			t.Errorf("unexpected headers: %+v", m.Headers)
+		}
+		if string(m.Value) != exp.value {
+			t.Errorf("unexpected value: act: %s, exp: %s", m.Value, exp.value)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+	"github.com/simonswine/cloud-billing-exporter/objstore"
+)
+
+// ObjectStorage writes the records of each billing month as a new object
+// named <month>/<timestamp>.<format>, which keeps an audit trail of all
+// exports
+type ObjectStorage struct {
+	bucket objstore.Bucket
+	format string
+	now    func() time.Time
+}
+
+func NewObjectStorage(ctx context.Context, bucketURL, format string) (*ObjectStorage, error) {
+	if format != FormatCSV && format != FormatJSON {
+		return nil, fmt.Errorf("unsupported format '%s'", format)
+	}
+
+	bucket, err := objstore.Parse(ctx, bucketURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ObjectStorage{
+		bucket: bucket,
+		format: format,
+		now:    time.Now,
+	}, nil
+}
+
+func (o *ObjectStorage) Write(ctx context.Context, records []billing.Record) error {
+	byMonth := make(map[string][]billing.Record)
+	for _, r := range records {
+		byMonth[r.Month] = append(byMonth[r.Month], r)
+	}
+
+	timestamp := o.now().UTC().Format("20060102T150405Z")
+	for month, records := range byMonth {
+		data, contentType, err := encode(o.format, records)
+		if err != nil {
+			return err
+		}
+
+		name := fmt.Sprintf("%s/%s.%s", month, timestamp, o.format)
+		if err := o.bucket.Put(ctx, name, data, contentType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *ObjectStorage) String() string {
+	return fmt.Sprintf("%s export to %s", o.format, o.bucket)
+}
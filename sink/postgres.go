@@ -0,0 +1,85 @@
+package sink
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+// Postgres upserts the records into a PostgreSQL table, with one row per
+// month and labels of the records
+type Postgres struct {
+	db    *sql.DB
+	table string
+}
+
+func NewPostgres(ctx context.Context, dsn, table string) (*Postgres, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("error opening postgres connection: %s", err)
+	}
+	return newPostgres(ctx, db, table)
+}
+
+func newPostgres(ctx context.Context, db *sql.DB, table string) (*Postgres, error) {
+	p := &Postgres{
+		db:    db,
+		table: pq.QuoteIdentifier(table),
+	}
+
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+p.table+` (
+	month date NOT NULL,
+	cloud text NOT NULL,
+	account text NOT NULL,
+	service text NOT NULL,
+	currency text NOT NULL,
+	path text NOT NULL DEFAULT '',
+	owner text NOT NULL DEFAULT '',
+	cost_centre text NOT NULL DEFAULT '',
+	type text NOT NULL DEFAULT '',
+	costs double precision NOT NULL,
+	updated_at timestamptz NOT NULL DEFAULT now(),
+	PRIMARY KEY (month, cloud, account, service, currency, path, owner, cost_centre, type)
+)`); err != nil {
+		return nil, fmt.Errorf("error creating table %s: %s", p.table, err)
+	}
+
+	return p, nil
+}
+
+func (p *Postgres) Write(ctx context.Context, records []billing.Record) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO `+p.table+` (month, cloud, account, service, currency, path, owner, cost_centre, type, costs)
+VALUES (to_date($1, 'YYYY-MM'), $2, $3, $4, $5, $6, $7, $8, $9, $10)
+ON CONFLICT (month, cloud, account, service, currency, path, owner, cost_centre, type) DO UPDATE SET
+	costs = EXCLUDED.costs,
+	updated_at = now()`)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	// records of a project split by resource labels only differ by their
+	// owner, cost centre or type
+	for _, r := range sumRecords(records) {
+		if _, err := stmt.ExecContext(ctx, r.Month, r.Cloud, r.Account, r.Service, r.Currency, r.Path, r.Owner, r.CostCentre, r.Type, r.Costs); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("error upserting record %+v: %s", r, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (p *Postgres) String() string {
+	return fmt.Sprintf("postgres table %s", p.table)
+}
@@ -0,0 +1,98 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+func TestPostgresWrite(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec(regexp.QuoteMeta(`CREATE TABLE IF NOT EXISTS "billing_records"`)).WillReturnResult(sqlmock.NewResult(0, 0))
+	p, err := newPostgres(ctx, db, "billing_records")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	records := []billing.Record{
+		{Cloud: "aws", Month: "2019-11", Currency: "USD", Account: "a", Service: "AmazonEC2", Owner: "team-a", Costs: 10.5},
+		{Cloud: "gcp", Month: "2019-11", Currency: "USD", Account: "b", Service: "compute", Path: "org/folder", Costs: 1},
+	}
+
+	mock.ExpectBegin()
+	upsert := mock.ExpectPrepare(regexp.QuoteMeta(`INSERT INTO "billing_records"`))
+	upsert.ExpectExec().
+		WithArgs("2019-11", "aws", "a", "AmazonEC2", "USD", "", "team-a", "", "", 10.5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	upsert.ExpectExec().
+		WithArgs("2019-11", "gcp", "b", "compute", "USD", "org/folder", "", "", "", 1.0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := p.Write(ctx, records); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	// a failing upsert rolls back the whole write
+	mock.ExpectBegin()
+	upsert = mock.ExpectPrepare(regexp.QuoteMeta(`INSERT INTO "billing_records"`))
+	upsert.ExpectExec().WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+
+	if err := p.Write(ctx, records); err == nil {
+		t.Error("expected error")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPostgresWriteSplitProject(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec(regexp.QuoteMeta(`PRIMARY KEY (month, cloud, account, service, currency, path, owner, cost_centre, type)`)).WillReturnResult(sqlmock.NewResult(0, 0))
+	p, err := newPostgres(ctx, db, "billing_records")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// the costs of a project split by resource labels are upserted per
+	// owner
+	records := []billing.Record{
+		{Cloud: "gcp", Month: "2019-11", Currency: "USD", Account: "p", Service: "compute", Owner: "a", Costs: 10},
+		{Cloud: "gcp", Month: "2019-11", Currency: "USD", Account: "p", Service: "compute", Owner: "b", Costs: 5},
+	}
+	mock.ExpectBegin()
+	upsert := mock.ExpectPrepare(regexp.QuoteMeta(`ON CONFLICT (month, cloud, account, service, currency, path, owner, cost_centre, type)`))
+	upsert.ExpectExec().
+		WithArgs("2019-11", "gcp", "p", "compute", "USD", "", "a", "", "", 10.0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	upsert.ExpectExec().
+		WithArgs("2019-11", "gcp", "p", "compute", "USD", "", "b", "", "", 5.0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := p.Write(ctx, records); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,87 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+// Sink receives the normalized billing records after they have been
+// collected
+type Sink interface {
+	Write(ctx context.Context, records []billing.Record) error
+	String() string
+}
+
+// LineItemWriter is implemented by sinks which also keep the line items
+// parsed from the billing reports
+type LineItemWriter interface {
+	WriteLineItems(ctx context.Context, items []billing.LineItem) error
+}
+
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+)
+
+var csvHeader = []string{"month", "cloud", "account", "service", "path", "owner", "cost_centre", "type", "currency", "costs"}
+
+// sumRecords sums up the costs of records with the same month and labels, so
+// each of them is upserted once
+func sumRecords(records []billing.Record) []billing.Record {
+	result := make([]billing.Record, 0, len(records))
+	index := make(map[string]int, len(records))
+	for _, r := range records {
+		key := r.Month + "\x00" + strings.Join(r.Labels(), "\x00")
+		if i, ok := index[key]; ok {
+			result[i].Costs += r.Costs
+			continue
+		}
+		index[key] = len(result)
+		result = append(result, r)
+	}
+	return result
+}
+
+// encode serializes records in the given format
+func encode(format string, records []billing.Record) ([]byte, string, error) {
+	var buf bytes.Buffer
+	switch format {
+	case FormatCSV:
+		w := csv.NewWriter(&buf)
+		if err := w.Write(csvHeader); err != nil {
+			return nil, "", err
+		}
+		for _, r := range records {
+			if err := w.Write([]string{
+				r.Month,
+				r.Cloud,
+				r.Account,
+				r.Service,
+				r.Path,
+				r.Owner,
+				r.CostCentre,
+				r.Type,
+				r.Currency,
+				strconv.FormatFloat(r.Costs, 'f', -1, 64),
+			}); err != nil {
+				return nil, "", err
+			}
+		}
+		w.Flush()
+		return buf.Bytes(), "text/csv", w.Error()
+	case FormatJSON:
+		if err := json.NewEncoder(&buf).Encode(records); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), "application/json", nil
+	default:
+		return nil, "", fmt.Errorf("unsupported format '%s'", format)
+	}
+}
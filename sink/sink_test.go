@@ -0,0 +1,69 @@
+package sink
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+type memoryBucket map[string]string
+
+func (m memoryBucket) Get(_ context.Context, name string) ([]byte, error) {
+	return []byte(m[name]), nil
+}
+
+func (m memoryBucket) Put(_ context.Context, name string, data []byte, _ string) error {
+	m[name] = string(data)
+	return nil
+}
+
+func (m memoryBucket) String() string {
+	return "memory"
+}
+
+func TestObjectStorageWrite(t *testing.T) {
+	bucket := memoryBucket{}
+	o := &ObjectStorage{
+		bucket: bucket,
+		format: FormatCSV,
+		now: func() time.Time {
+			return time.Date(2019, 11, 20, 10, 0, 0, 0, time.UTC)
+		},
+	}
+
+	if err := o.Write(context.Background(), []billing.Record{
+		{Cloud: "aws", Month: "2019-11", Currency: "USD", Account: "a", Service: "AmazonEC2", Costs: 10.5},
+		{Cloud: "gcp", Month: "2019-10", Currency: "USD", Account: "b", Service: "compute", Owner: "jane", Costs: 1},
+	}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	exp := map[string]string{
+		"2019-11/20191120T100000Z.csv": "month,cloud,account,service,path,owner,cost_centre,type,currency,costs\n2019-11,aws,a,AmazonEC2,,,,,USD,10.5\n",
+		"2019-10/20191120T100000Z.csv": "month,cloud,account,service,path,owner,cost_centre,type,currency,costs\n2019-10,gcp,b,compute,,jane,,,USD,1\n",
+	}
+	if len(bucket) != len(exp) {
+		t.Errorf("unexpected objects: %+v", bucket)
+	}
+	for name, content := range exp {
+		if bucket[name] != content {
+			t.Errorf("unexpected content of '%s': act: %q, exp: %q", name, bucket[name], content)
+		}
+	}
+}
+
+func TestEncodeJSON(t *testing.T) {
+	data, contentType, err := encode(FormatJSON, []billing.Record{{Cloud: "aws", Month: "2019-11", Costs: 1}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if contentType != "application/json" {
+		t.Errorf("unexpected content type: %s", contentType)
+	}
+	exp := `[{"cloud":"aws","month":"2019-11","currency":"","account":"","service":"","path":"","owner":"","cost_centre":"","type":"","costs":1}]` + "\n"
+	if string(data) != exp {
+		t.Errorf("unexpected JSON: act: %s, exp: %s", data, exp)
+	}
+}
@@ -0,0 +1,243 @@
+package sink
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	// register sqlite3 driver, this requires a build with cgo enabled
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+const monthFormat = "2006-01"
+
+var sqliteSchema = []string{
+	`CREATE TABLE IF NOT EXISTS records (
+	month TEXT NOT NULL,
+	cloud TEXT NOT NULL,
+	account TEXT NOT NULL,
+	service TEXT NOT NULL,
+	currency TEXT NOT NULL,
+	path TEXT NOT NULL DEFAULT '',
+	owner TEXT NOT NULL DEFAULT '',
+	cost_centre TEXT NOT NULL DEFAULT '',
+	type TEXT NOT NULL DEFAULT '',
+	costs REAL NOT NULL,
+	updated_at INTEGER NOT NULL,
+	PRIMARY KEY (month, cloud, account, service, currency, path, owner, cost_centre, type)
+)`,
+	`CREATE TABLE IF NOT EXISTS line_items (
+	month TEXT NOT NULL,
+	date TEXT NOT NULL,
+	cloud TEXT NOT NULL,
+	account TEXT NOT NULL,
+	service TEXT NOT NULL,
+	currency TEXT NOT NULL,
+	costs REAL NOT NULL,
+	updated_at INTEGER NOT NULL,
+	PRIMARY KEY (month, date, cloud, account, service, currency)
+)`,
+	`CREATE TABLE IF NOT EXISTS accounts (
+	cloud TEXT NOT NULL,
+	account TEXT NOT NULL,
+	path TEXT NOT NULL DEFAULT '',
+	owner TEXT NOT NULL DEFAULT '',
+	cost_centre TEXT NOT NULL DEFAULT '',
+	type TEXT NOT NULL DEFAULT '',
+	month TEXT NOT NULL,
+	updated_at INTEGER NOT NULL,
+	PRIMARY KEY (cloud, account)
+)`,
+}
+
+// SQLite keeps records, line items and account metadata in a local SQLite
+// database for a number of months, so historical months can be queried after
+// the collectors moved on
+type SQLite struct {
+	db              *sql.DB
+	path            string
+	retentionMonths int
+	now             func() time.Time
+}
+
+func NewSQLite(ctx context.Context, path string, retentionMonths int) (*SQLite, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening sqlite database '%s': %s", path, err)
+	}
+	// sqlite only supports a single writer
+	db.SetMaxOpenConns(1)
+
+	for _, stmt := range sqliteSchema {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return nil, fmt.Errorf("error creating sqlite schema in '%s': %s", path, err)
+		}
+	}
+
+	return &SQLite{
+		db:              db,
+		path:            path,
+		retentionMonths: retentionMonths,
+		now:             time.Now,
+	}, nil
+}
+
+// oldestMonth returns the oldest month within the retention
+func (s *SQLite) oldestMonth() string {
+	now := s.now().UTC()
+	return time.Date(now.Year(), now.Month()-time.Month(s.retentionMonths-1), 1, 0, 0, 0, 0, time.UTC).Format(monthFormat)
+}
+
+// exec runs the statement for every row within a transaction and expires
+// rows outside of the retention afterwards
+func (s *SQLite) exec(ctx context.Context, query string, rows [][]interface{}, expire ...string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for _, row := range rows {
+		if _, err := stmt.ExecContext(ctx, row...); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("error upserting %v: %s", row, err)
+		}
+	}
+
+	if s.retentionMonths > 0 {
+		oldest := s.oldestMonth()
+		for _, e := range expire {
+			if _, err := tx.ExecContext(ctx, e, oldest); err != nil {
+				_ = tx.Rollback()
+				return fmt.Errorf("error expiring rows: %s", err)
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Write upserts the records and the metadata of their accounts
+func (s *SQLite) Write(ctx context.Context, records []billing.Record) error {
+	now := s.now().Unix()
+
+	rows := make([][]interface{}, 0, len(records))
+	accounts := make(map[[2]string]billing.Record)
+	// records of a project split by resource labels only differ by their
+	// owner, cost centre or type
+	for _, r := range sumRecords(records) {
+		rows = append(rows, []interface{}{r.Month, r.Cloud, r.Account, r.Service, r.Currency, r.Path, r.Owner, r.CostCentre, r.Type, r.Costs, now})
+		key := [2]string{r.Cloud, r.Account}
+		if a, ok := accounts[key]; !ok || a.Month < r.Month {
+			accounts[key] = r
+		}
+	}
+	if err := s.exec(ctx, `INSERT INTO records (month, cloud, account, service, currency, path, owner, cost_centre, type, costs, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (month, cloud, account, service, currency, path, owner, cost_centre, type) DO UPDATE SET
+	costs = excluded.costs,
+	updated_at = excluded.updated_at`, rows, `DELETE FROM records WHERE month < ?`); err != nil {
+		return err
+	}
+
+	rows = make([][]interface{}, 0, len(accounts))
+	for _, r := range accounts {
+		rows = append(rows, []interface{}{r.Cloud, r.Account, r.Path, r.Owner, r.CostCentre, r.Type, r.Month, now})
+	}
+	// metadata of older months never overwrites newer metadata
+	return s.exec(ctx, `INSERT INTO accounts (cloud, account, path, owner, cost_centre, type, month, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (cloud, account) DO UPDATE SET
+	path = excluded.path,
+	owner = excluded.owner,
+	cost_centre = excluded.cost_centre,
+	type = excluded.type,
+	month = excluded.month,
+	updated_at = excluded.updated_at
+WHERE excluded.month >= accounts.month`, rows, `DELETE FROM accounts WHERE month < ?`)
+}
+
+// WriteLineItems upserts the line items parsed from the billing reports
+func (s *SQLite) WriteLineItems(ctx context.Context, items []billing.LineItem) error {
+	now := s.now().Unix()
+
+	rows := make([][]interface{}, 0, len(items))
+	for _, i := range items {
+		rows = append(rows, []interface{}{i.Month, i.Date, i.Cloud, i.Account, i.Service, i.Currency, i.Costs, now})
+	}
+	return s.exec(ctx, `INSERT INTO line_items (month, date, cloud, account, service, currency, costs, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (month, date, cloud, account, service, currency) DO UPDATE SET
+	costs = excluded.costs,
+	updated_at = excluded.updated_at`, rows, `DELETE FROM line_items WHERE month < ?`)
+}
+
+// Records returns all records of a billing month (YYYY-MM)
+func (s *SQLite) Records(ctx context.Context, month string) ([]billing.Record, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT month, cloud, account, service, currency, path, owner, cost_centre, type, costs FROM records WHERE month = ? ORDER BY cloud, account, service, currency, path, owner, cost_centre, type`, month)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []billing.Record
+	for rows.Next() {
+		var r billing.Record
+		if err := rows.Scan(&r.Month, &r.Cloud, &r.Account, &r.Service, &r.Currency, &r.Path, &r.Owner, &r.CostCentre, &r.Type, &r.Costs); err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// LineItems returns all line items of a billing month (YYYY-MM)
+func (s *SQLite) LineItems(ctx context.Context, month string) ([]billing.LineItem, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT month, date, cloud, account, service, currency, costs FROM line_items WHERE month = ? ORDER BY date, cloud, account, service, currency`, month)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []billing.LineItem
+	for rows.Next() {
+		var i billing.LineItem
+		if err := rows.Scan(&i.Month, &i.Date, &i.Cloud, &i.Account, &i.Service, &i.Currency, &i.Costs); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	return items, rows.Err()
+}
+
+// Accounts returns the latest metadata of all accounts
+func (s *SQLite) Accounts(ctx context.Context) ([]billing.AccountMetadata, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT cloud, account, path, owner, cost_centre, type, month FROM accounts ORDER BY cloud, account`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accounts []billing.AccountMetadata
+	for rows.Next() {
+		var a billing.AccountMetadata
+		if err := rows.Scan(&a.Cloud, &a.Account, &a.Path, &a.Owner, &a.CostCentre, &a.Type, &a.Month); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, a)
+	}
+	return accounts, rows.Err()
+}
+
+func (s *SQLite) String() string {
+	return fmt.Sprintf("sqlite database %s", s.path)
+}
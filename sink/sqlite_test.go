@@ -0,0 +1,172 @@
+package sink
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+func newTestSQLite(t *testing.T) (*SQLite, func()) {
+	dir, err := ioutil.TempDir("", "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSQLite(context.Background(), filepath.Join(dir, "billing.db"), 3)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return s, func() {
+		s.db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSQLiteWrite(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := newTestSQLite(t)
+	defer cleanup()
+	s.now = func() time.Time { return time.Date(2019, 11, 20, 10, 0, 0, 0, time.UTC) }
+
+	if err := s.Write(ctx, []billing.Record{
+		{Cloud: "aws", Month: "2019-10", Currency: "USD", Account: "a", Service: "AmazonEC2", Owner: "team-old", Costs: 8},
+		{Cloud: "aws", Month: "2019-11", Currency: "USD", Account: "a", Service: "AmazonEC2", Owner: "team-a", Costs: 1},
+		{Cloud: "gcp", Month: "2019-11", Currency: "USD", Account: "b", Service: "compute", Path: "org", Costs: 2},
+	}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// upsert of updated costs
+	if err := s.Write(ctx, []billing.Record{
+		{Cloud: "aws", Month: "2019-11", Currency: "USD", Account: "a", Service: "AmazonEC2", Owner: "team-a", Costs: 10.5},
+	}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	act, err := s.Records(ctx, "2019-11")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	exp := []billing.Record{
+		{Cloud: "aws", Month: "2019-11", Currency: "USD", Account: "a", Service: "AmazonEC2", Owner: "team-a", Costs: 10.5},
+		{Cloud: "gcp", Month: "2019-11", Currency: "USD", Account: "b", Service: "compute", Path: "org", Costs: 2},
+	}
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("unexpected records: act: %+v, exp: %+v", act, exp)
+	}
+
+	// metadata of the latest month wins
+	accounts, err := s.Accounts(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expAccounts := []billing.AccountMetadata{
+		{Cloud: "aws", Account: "a", Owner: "team-a", Month: "2019-11"},
+		{Cloud: "gcp", Account: "b", Path: "org", Month: "2019-11"},
+	}
+	if !reflect.DeepEqual(accounts, expAccounts) {
+		t.Errorf("unexpected accounts: act: %+v, exp: %+v", accounts, expAccounts)
+	}
+}
+
+func TestSQLiteWriteSplitProject(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := newTestSQLite(t)
+	defer cleanup()
+	s.now = func() time.Time { return time.Date(2019, 11, 20, 10, 0, 0, 0, time.UTC) }
+
+	// the costs of a project split by resource labels
+	if err := s.Write(ctx, []billing.Record{
+		{Cloud: "gcp", Month: "2019-11", Currency: "USD", Account: "p", Service: "compute", Owner: "a", Costs: 10},
+		{Cloud: "gcp", Month: "2019-11", Currency: "USD", Account: "p", Service: "compute", Owner: "b", Costs: 5},
+		{Cloud: "gcp", Month: "2019-11", Currency: "USD", Account: "p", Service: "compute", Owner: "b", Costs: 1},
+	}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	act, err := s.Records(ctx, "2019-11")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	exp := []billing.Record{
+		{Cloud: "gcp", Month: "2019-11", Currency: "USD", Account: "p", Service: "compute", Owner: "a", Costs: 10},
+		{Cloud: "gcp", Month: "2019-11", Currency: "USD", Account: "p", Service: "compute", Owner: "b", Costs: 6},
+	}
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("unexpected records: act: %+v, exp: %+v", act, exp)
+	}
+}
+
+func TestSQLiteLineItems(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := newTestSQLite(t)
+	defer cleanup()
+	s.now = func() time.Time { return time.Date(2019, 11, 20, 10, 0, 0, 0, time.UTC) }
+
+	exp := []billing.LineItem{
+		{Cloud: "gcp", Month: "2019-11", Date: "2019-11-01", Currency: "USD", Account: "b", Service: "compute", Costs: 1},
+		{Cloud: "gcp", Month: "2019-11", Date: "2019-11-02", Currency: "USD", Account: "b", Service: "compute", Costs: 1.5},
+	}
+	if err := s.WriteLineItems(ctx, exp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	act, err := s.LineItems(ctx, "2019-11")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("unexpected line items: act: %+v, exp: %+v", act, exp)
+	}
+}
+
+func TestSQLiteRetention(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := newTestSQLite(t)
+	defer cleanup()
+
+	s.now = func() time.Time { return time.Date(2019, 8, 20, 10, 0, 0, 0, time.UTC) }
+	if err := s.Write(ctx, []billing.Record{
+		{Cloud: "aws", Month: "2019-08", Currency: "USD", Account: "old", Service: "AmazonEC2", Costs: 1},
+	}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := s.WriteLineItems(ctx, []billing.LineItem{
+		{Cloud: "aws", Month: "2019-08", Currency: "USD", Account: "old", Service: "AmazonEC2", Costs: 1},
+	}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// with a retention of 3 months, August expires in November
+	s.now = func() time.Time { return time.Date(2019, 11, 1, 10, 0, 0, 0, time.UTC) }
+	if err := s.Write(ctx, []billing.Record{
+		{Cloud: "aws", Month: "2019-11", Currency: "USD", Account: "new", Service: "AmazonEC2", Costs: 1},
+	}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := s.WriteLineItems(ctx, []billing.LineItem{
+		{Cloud: "aws", Month: "2019-11", Currency: "USD", Account: "new", Service: "AmazonEC2", Costs: 1},
+	}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if records, err := s.Records(ctx, "2019-08"); err != nil || len(records) != 0 {
+		t.Errorf("expected expired records: %+v, %v", records, err)
+	}
+	if items, err := s.LineItems(ctx, "2019-08"); err != nil || len(items) != 0 {
+		t.Errorf("expected expired line items: %+v, %v", items, err)
+	}
+	accounts, err := s.Accounts(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(accounts) != 1 || accounts[0].Account != "new" {
+		t.Errorf("expected expired account metadata: %+v", accounts)
+	}
+}
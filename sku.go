@@ -0,0 +1,22 @@
+package main
+
+// skuLabel is the label of the monthly costs set to the SKU of the costs
+// queried from BigQuery by SKU
+const skuLabel = "sku"
+
+// skuEnricher exposes the SKU of the records in the sku label. The series of
+// the monthly costs counter sum up the costs of all SKUs, their sku label is
+// empty.
+type skuEnricher struct{}
+
+func (e *skuEnricher) labels() []string {
+	return []string{skuLabel}
+}
+
+func (e *skuEnricher) update() {}
+
+func (e *skuEnricher) enrich(labels map[string]string) {
+	if _, ok := labels[skuLabel]; !ok {
+		labels[skuLabel] = ""
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+func TestSKUEnricher(t *testing.T) {
+	l := newMonthlyCostsCollector(0).withEnricher(&skuEnricher{})
+	for _, c := range []struct {
+		record billing.Record
+		exp    map[string]string
+	}{
+		{
+			record: billing.Record{Cloud: "gcp", Service: "Compute Engine", SKU: "Network Egress via Carrier Peering/Interconnect"},
+			exp:    map[string]string{"service": "Compute Engine", "sku": "Network Egress via Carrier Peering/Interconnect"},
+		},
+		{
+			record: billing.Record{Cloud: "gcp", Service: "Cloud Storage"},
+			exp:    map[string]string{"service": "Cloud Storage", "sku": ""},
+		},
+		// the service is never split
+		{
+			record: billing.Record{Cloud: "gcp", Service: "Compute Engine/N1 Predefined Instance Core"},
+			exp:    map[string]string{"service": "Compute Engine/N1 Predefined Instance Core", "sku": ""},
+		},
+	} {
+		labels := l.recordLabels(c.record)
+		act := map[string]string{"service": labels["service"], "sku": labels["sku"]}
+		if _, ok := labels["sku"]; !ok || !reflect.DeepEqual(act, c.exp) {
+			t.Errorf("unexpected labels: %v (expected: %v)", labels, c.exp)
+		}
+	}
+}
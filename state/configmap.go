@@ -0,0 +1,196 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// configMapMaxSize is the maximum size of a ConfigMap accepted by the
+// Kubernetes API
+const configMapMaxSize = 1024 * 1024
+
+// configMapStore keeps the state in the data of a Kubernetes ConfigMap, using
+// the in-cluster service account of the pod. Every key is stored as separate
+// data entry, the total size of a ConfigMap is limited to 1MiB.
+type configMapStore struct {
+	client    *http.Client
+	host      string
+	tokenPath string
+	namespace string
+	name      string
+}
+
+// newConfigMapStore parses URLs like configmap://namespace/name. If the
+// namespace is empty, the namespace of the pod is used.
+func newConfigMapStore(u *url.URL) (*configMapStore, error) {
+	name := strings.Trim(u.Path, "/")
+	if name == "" {
+		return nil, fmt.Errorf("no ConfigMap name given in '%s'", u)
+	}
+
+	namespace := u.Host
+	if namespace == "" {
+		data, err := ioutil.ReadFile(serviceAccountDir + "/namespace")
+		if err != nil {
+			return nil, fmt.Errorf("error detecting namespace: %s", err)
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("ConfigMap state store is only supported within a Kubernetes cluster")
+	}
+
+	ca, err := ioutil.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("error reading service account CA: %s", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates found in service account CA")
+	}
+
+	return &configMapStore{
+		client: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			},
+		},
+		host:      "https://" + net.JoinHostPort(host, port),
+		tokenPath: serviceAccountDir + "/token",
+		namespace: namespace,
+		name:      name,
+	}, nil
+}
+
+type configMap struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   configMapMetadata `json:"metadata"`
+	Data       map[string]string `json:"data,omitempty"`
+}
+
+type configMapMetadata struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+// dataKey maps a state key to a valid ConfigMap data key
+func (s *configMapStore) dataKey(key string) string {
+	return strings.ReplaceAll(key, "/", ".") + ".json"
+}
+
+// do sends a request to the API server. The token is read for every request,
+// as projected service account tokens get rotated by the kubelet.
+func (s *configMapStore) do(ctx context.Context, method, path, contentType string, body []byte) (*http.Response, error) {
+	token, err := ioutil.ReadFile(s.tokenPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading service account token: %s", err)
+	}
+
+	req, err := http.NewRequest(method, s.host+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return s.client.Do(req)
+}
+
+func (s *configMapStore) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.do(ctx, http.MethodGet, fmt.Sprintf("/api/v1/namespaces/%s/configmaps/%s", s.namespace, s.name), "", nil)
+	if err != nil {
+		return nil, fmt.Errorf("error reading state '%s' from %s: %s", key, s, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error reading state '%s' from %s: %s", key, s, apiError(resp))
+	}
+
+	var cm configMap
+	if err := json.NewDecoder(resp.Body).Decode(&cm); err != nil {
+		return nil, fmt.Errorf("error decoding %s: %s", s, err)
+	}
+
+	data, ok := cm.Data[s.dataKey(key)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return []byte(data), nil
+}
+
+// Put merges the key into the ConfigMap, creating it if it doesn't exist yet
+func (s *configMapStore) Put(ctx context.Context, key string, data []byte) error {
+	if len(data) > configMapMaxSize {
+		return fmt.Errorf("state '%s' with %d bytes exceeds the maximum ConfigMap size of 1MiB, use a different state store", key, len(data))
+	}
+
+	cm := configMap{
+		APIVersion: "v1",
+		Kind:       "ConfigMap",
+		Metadata: configMapMetadata{
+			Name:      s.name,
+			Namespace: s.namespace,
+		},
+		Data: map[string]string{s.dataKey(key): string(data)},
+	}
+	body, err := json.Marshal(&cm)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(ctx, http.MethodPatch, fmt.Sprintf("/api/v1/namespaces/%s/configmaps/%s", s.namespace, s.name), "application/merge-patch+json", body)
+	if err != nil {
+		return fmt.Errorf("error writing state '%s' to %s: %s", key, s, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp, err = s.do(ctx, http.MethodPost, fmt.Sprintf("/api/v1/namespaces/%s/configmaps", s.namespace), "application/json", body)
+		if err != nil {
+			return fmt.Errorf("error writing state '%s' to %s: %s", key, s, err)
+		}
+		defer resp.Body.Close()
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("error writing state '%s' to %s: %s", key, s, apiError(resp))
+	}
+	return nil
+}
+
+// apiError returns the message of a failed API request, the status is used
+// if the body contains no Status object
+func apiError(resp *http.Response) string {
+	var status struct {
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil || status.Message == "" {
+		return fmt.Sprintf("unexpected status %s", resp.Status)
+	}
+	return fmt.Sprintf("%s: %s", resp.Status, status.Message)
+}
+
+func (s *configMapStore) String() string {
+	return fmt.Sprintf("configmap://%s/%s", s.namespace, s.name)
+}
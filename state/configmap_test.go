@@ -0,0 +1,159 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeConfigMapAPI implements the parts of the Kubernetes API used by the
+// ConfigMap store
+type fakeConfigMapAPI struct {
+	sync.Mutex
+	t          *testing.T
+	token      string
+	configMaps map[string]*configMap
+	requests   []string
+}
+
+func (f *fakeConfigMapAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+
+	if act, exp := r.Header.Get("Authorization"), "Bearer "+f.token; act != exp {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"kind":"Status","message":"Unauthorized"}`))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodPatch:
+		name := strings.TrimPrefix(r.URL.Path, "/api/v1/namespaces/monitoring/configmaps/")
+		cm, ok := f.configMaps[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodPatch {
+			if act, exp := r.Header.Get("Content-Type"), "application/merge-patch+json"; act != exp {
+				f.t.Errorf("unexpected content type: act: %s, exp: %s", act, exp)
+			}
+			var patch configMap
+			if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+				f.t.Fatal(err)
+			}
+			for k, v := range patch.Data {
+				cm.Data[k] = v
+			}
+		}
+		_ = json.NewEncoder(w).Encode(cm)
+	case http.MethodPost:
+		var cm configMap
+		if err := json.NewDecoder(r.Body).Decode(&cm); err != nil {
+			f.t.Fatal(err)
+		}
+		f.configMaps[cm.Metadata.Name] = &cm
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(&cm)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestConfigMapStore(t *testing.T) {
+	ctx := context.Background()
+
+	api := &fakeConfigMapAPI{t: t, token: "token-1", configMaps: map[string]*configMap{}}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	tokenFile, err := ioutil.TempFile("", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tokenFile.Name())
+	writeToken := func(token string) {
+		if err := ioutil.WriteFile(tokenFile.Name(), []byte(token+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeToken("token-1")
+
+	s := &configMapStore{
+		client:    server.Client(),
+		host:      server.URL,
+		tokenPath: tokenFile.Name(),
+		namespace: "monitoring",
+		name:      "billing-state",
+	}
+
+	// ConfigMap doesn't exist yet
+	if _, err := s.Get(ctx, "aws/bucket"); err != ErrNotFound {
+		t.Errorf("unexpected error: act: %v, exp: %v", err, ErrNotFound)
+	}
+
+	// first write creates the ConfigMap after the patch failed
+	if err := s.Put(ctx, "aws/bucket", []byte(`{"ReportHash":"abc"}`)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := api.configMaps["billing-state"]; !ok {
+		t.Fatal("expected ConfigMap to be created")
+	}
+
+	// token is rotated
+	api.token = "token-2"
+	writeToken("token-2")
+
+	// second write patches the existing ConfigMap
+	if err := s.Put(ctx, "gcp/bucket/prefix", []byte(`{"Baselines":{}}`)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for key, exp := range map[string]string{
+		"aws/bucket":        `{"ReportHash":"abc"}`,
+		"gcp/bucket/prefix": `{"Baselines":{}}`,
+	} {
+		act, err := s.Get(ctx, key)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if string(act) != exp {
+			t.Errorf("unexpected state of '%s': act: %s, exp: %s", key, act, exp)
+		}
+	}
+
+	// unknown key within existing ConfigMap
+	if _, err := s.Get(ctx, "aws/other"); err != ErrNotFound {
+		t.Errorf("unexpected error: act: %v, exp: %v", err, ErrNotFound)
+	}
+
+	expRequests := []string{
+		"GET /api/v1/namespaces/monitoring/configmaps/billing-state",
+		"PATCH /api/v1/namespaces/monitoring/configmaps/billing-state",
+		"POST /api/v1/namespaces/monitoring/configmaps",
+		"PATCH /api/v1/namespaces/monitoring/configmaps/billing-state",
+	}
+	for i, exp := range expRequests {
+		if act := api.requests[i]; act != exp {
+			t.Errorf("unexpected request %d: act: %s, exp: %s", i, act, exp)
+		}
+	}
+
+	// API errors are returned with their message
+	writeToken("expired")
+	if err := s.Put(ctx, "aws/bucket", []byte(`{}`)); err == nil || !strings.Contains(err.Error(), "Unauthorized") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// oversized state is refused before sending it
+	if err := s.Put(ctx, "aws/bucket", make([]byte, configMapMaxSize+1)); err == nil || !strings.Contains(err.Error(), "1MiB") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
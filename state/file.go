@@ -0,0 +1,70 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// fileStore keeps the state as JSON files below a local directory, e.g. on a
+// persistent volume
+type fileStore struct {
+	dir string
+}
+
+func newFileStore(dir string) (*fileStore, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("no directory given for file state store")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating state directory '%s': %s", dir, err)
+	}
+	return &fileStore{dir: dir}, nil
+}
+
+func (s *fileStore) path(key string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(key)+".json")
+}
+
+func (s *fileStore) Get(_ context.Context, key string) ([]byte, error) {
+	data, err := ioutil.ReadFile(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading state '%s': %s", key, err)
+	}
+	return data, nil
+}
+
+// Put writes to a temporary file first and renames it, so a crash never
+// leaves a truncated state behind
+func (s *fileStore) Put(_ context.Context, key string, data []byte) error {
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error writing state '%s': %s", key, err)
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), ".state-")
+	if err != nil {
+		return fmt.Errorf("error writing state '%s': %s", key, err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing state '%s': %s", key, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error writing state '%s': %s", key, err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("error writing state '%s': %s", key, err)
+	}
+	return nil
+}
+
+func (s *fileStore) String() string {
+	return fmt.Sprintf("file://%s", s.dir)
+}
@@ -0,0 +1,28 @@
+package state
+
+import (
+	"context"
+
+	"github.com/simonswine/cloud-billing-exporter/objstore"
+)
+
+// objectStore keeps the state as JSON objects in an object storage bucket
+type objectStore struct {
+	bucket objstore.Bucket
+}
+
+func (s *objectStore) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := s.bucket.Get(ctx, key+".json")
+	if err == objstore.ErrNotExist {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (s *objectStore) Put(ctx context.Context, key string, data []byte) error {
+	return s.bucket.Put(ctx, key+".json", data, "application/json")
+}
+
+func (s *objectStore) String() string {
+	return s.bucket.String()
+}
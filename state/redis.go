@@ -0,0 +1,49 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/go-redis/redis"
+)
+
+const redisKeyPrefix = "cloud-billing-exporter/"
+
+type redisStore struct {
+	client *redis.Client
+	addr   string
+}
+
+func newRedisStore(u *url.URL) (*redisStore, error) {
+	opts, err := redis.ParseURL(u.String())
+	if err != nil {
+		return nil, fmt.Errorf("error parsing redis URL: %s", err)
+	}
+
+	return &redisStore{
+		client: redis.NewClient(opts),
+		addr:   opts.Addr,
+	}, nil
+}
+
+func (s *redisStore) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := s.client.WithContext(ctx).Get(redisKeyPrefix + key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading state '%s' from redis: %s", key, err)
+	}
+	return data, nil
+}
+
+func (s *redisStore) Put(ctx context.Context, key string, data []byte) error {
+	if err := s.client.WithContext(ctx).Set(redisKeyPrefix+key, data, 0).Err(); err != nil {
+		return fmt.Errorf("error writing state '%s' to redis: %s", key, err)
+	}
+	return nil
+}
+
+func (s *redisStore) String() string {
+	return fmt.Sprintf("redis://%s", s.addr)
+}
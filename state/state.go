@@ -0,0 +1,88 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/simonswine/cloud-billing-exporter/objstore"
+)
+
+// ErrNotFound is returned by a Store if no state exists for the given key
+var ErrNotFound = errors.New("state not found")
+
+// Store persists the state of the billing collectors (counter baselines,
+// report hashes, metadata caches), so that it survives restarts and can be
+// shared between multiple replicas of the exporter.
+type Store interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Put(ctx context.Context, key string, data []byte) error
+	String() string
+}
+
+// Baseline is the last value a counter series has been advanced to by the
+// costs of the month
+type Baseline struct {
+	Labels []string
+	Value  float64
+	Month  string `json:",omitempty"`
+}
+
+// Previous returns the value the costs of the month advance the counter
+// from. The costs of a new month start from zero, so a month rollover is no
+// decrease. Baselines without month, as written by earlier versions, are
+// taken as of the month.
+func (b Baseline) Previous(month string) float64 {
+	if b.Month != "" && b.Month != month {
+		return 0
+	}
+	return b.Value
+}
+
+// New creates a Store from an URL. Supported schemes are file:///path,
+// s3://bucket/prefix, gs://bucket/prefix, redis://host:port/db and
+// configmap://namespace/name.
+func New(ctx context.Context, storeURL string) (Store, error) {
+	u, err := url.Parse(storeURL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing state store URL '%s': %s", storeURL, err)
+	}
+
+	switch u.Scheme {
+	case "file":
+		return newFileStore(u.Path)
+	case "s3", "gs":
+		bucket, err := objstore.New(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+		return &objectStore{bucket: bucket}, nil
+	case "redis":
+		return newRedisStore(u)
+	case "configmap":
+		return newConfigMapStore(u)
+	default:
+		return nil, fmt.Errorf("unsupported state store scheme '%s'", u.Scheme)
+	}
+}
+
+// Load reads the state stored under key and decodes it into v. If no state
+// exists yet, v is left untouched and ErrNotFound is returned.
+func Load(ctx context.Context, s Store, key string, v interface{}) error {
+	data, err := s.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Save encodes v and writes it to the store under key.
+func Save(ctx context.Context, s Store, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, key, data)
+}
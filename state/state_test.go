@@ -0,0 +1,118 @@
+package state
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+type memoryStore map[string][]byte
+
+func (m memoryStore) Get(_ context.Context, key string) ([]byte, error) {
+	data, ok := m[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return data, nil
+}
+
+func (m memoryStore) Put(_ context.Context, key string, data []byte) error {
+	m[key] = data
+	return nil
+}
+
+func (m memoryStore) String() string {
+	return "memory"
+}
+
+func TestLoadSave(t *testing.T) {
+	ctx := context.Background()
+	s := memoryStore{}
+
+	type collectorState struct {
+		ReportHash string
+		Baselines  map[string]Baseline
+	}
+
+	var act collectorState
+	if err := Load(ctx, s, "aws/bucket", &act); err != ErrNotFound {
+		t.Errorf("unexpected error: act: %v, exp: %v", err, ErrNotFound)
+	}
+
+	exp := collectorState{
+		ReportHash: "\"abc\"",
+		Baselines: map[string]Baseline{
+			"1234-AmazonEC2-USD": {
+				Labels: []string{"aws", "USD", "acme-prod", "AmazonEC2", "", "", "", ""},
+				Value:  12.5,
+			},
+		},
+	}
+	if err := Save(ctx, s, "aws/bucket", &exp); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := Load(ctx, s, "aws/bucket", &act); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("unexpected state: act: %+v, exp: %+v", act, exp)
+	}
+}
+
+func TestNewUnsupportedScheme(t *testing.T) {
+	if _, err := New(context.Background(), "ftp://example.com/state"); err == nil {
+		t.Error("expected error for unsupported scheme")
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := New(ctx, "file://"+dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := s.Get(ctx, "gcp/bucket/prefix"); err != ErrNotFound {
+		t.Errorf("unexpected error: act: %v, exp: %v", err, ErrNotFound)
+	}
+
+	exp := []byte(`{"ReportHash":"abc"}`)
+	if err := s.Put(ctx, "gcp/bucket/prefix", exp); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	act, err := s.Get(ctx, "gcp/bucket/prefix")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("unexpected state: act: %s, exp: %s", act, exp)
+	}
+}
+
+func TestBaselinePrevious(t *testing.T) {
+	for _, c := range []struct {
+		baseline Baseline
+		month    string
+		exp      float64
+	}{
+		{baseline: Baseline{Value: 10, Month: "2019-11"}, month: "2019-11", exp: 10},
+		// the costs of a new month start from zero
+		{baseline: Baseline{Value: 10, Month: "2019-11"}, month: "2019-12", exp: 0},
+		// baselines of earlier versions have no month
+		{baseline: Baseline{Value: 10}, month: "2019-12", exp: 10},
+	} {
+		if act := c.baseline.Previous(c.month); act != c.exp {
+			t.Errorf("unexpected previous value of %+v in %s: %f (expected: %f)", c.baseline, c.month, act, c.exp)
+		}
+	}
+}
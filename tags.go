@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+// invalidLabelChars matches the characters of tag keys, which are invalid in
+// label names
+var invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// accountTagsCollector is implemented by collectors looking up the tags of
+// their accounts, the values are returned per tag key by account
+type accountTagsCollector interface {
+	AccountTags() map[string]map[string]string
+}
+
+// tagLabelName returns the label name of a tag key, e.g. cost_centre for
+// cost-centre
+func tagLabelName(key string) string {
+	name := invalidLabelChars.ReplaceAllString(key, "_")
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// tagsEnricher labels the monthly costs with the tags of the AWS accounts and
+// the labels of the GCP projects. Tags mapped to labels of the monthly costs,
+// like owner or cost_centre, only override them if set.
+type tagsEnricher struct {
+	keys       []string
+	names      []string
+	builtin    map[string]bool
+	collectors func() []cloudBillingCollector
+
+	lock     sync.Mutex
+	accounts map[[2]string]map[string]string
+}
+
+func newTagsEnricher(b *BillingCollector, keys []string) (*tagsEnricher, error) {
+	e := &tagsEnricher{
+		builtin: make(map[string]bool),
+		collectors: func() []cloudBillingCollector {
+			return b.collectors
+		},
+		accounts: make(map[[2]string]map[string]string),
+	}
+	for _, name := range billing.MonthlyCostsLabels {
+		e.builtin[name] = true
+	}
+
+	names := make(map[string]string)
+	for _, key := range keys {
+		key = strings.TrimSpace(key)
+		name := tagLabelName(key)
+		if name == "" {
+			return nil, fmt.Errorf("empty tag key")
+		}
+		if other, ok := names[name]; ok {
+			return nil, fmt.Errorf("tags '%s' and '%s' map to the same label '%s'", other, key, name)
+		}
+		names[name] = key
+		e.keys = append(e.keys, key)
+		e.names = append(e.names, name)
+	}
+	return e, nil
+}
+
+func (e *tagsEnricher) labels() []string {
+	return e.names
+}
+
+// update reads the tags of the accounts from the collectors
+func (e *tagsEnricher) update() {
+	accounts := make(map[[2]string]map[string]string)
+	for _, c := range e.collectors() {
+		tc, ok := c.(accountTagsCollector)
+		if !ok {
+			continue
+		}
+		for account, tags := range tc.AccountTags() {
+			accounts[[2]string{c.Cloud(), account}] = tags
+		}
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.accounts = accounts
+}
+
+func (e *tagsEnricher) enrich(labels map[string]string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	tags := e.accounts[[2]string{labels["cloud"], labels["account"]}]
+	for i, key := range e.keys {
+		value := tags[key]
+		if value == "" && e.builtin[e.names[i]] {
+			continue
+		}
+		labels[e.names[i]] = value
+	}
+}
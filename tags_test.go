@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+type tagsTestCollector struct {
+	*fakeCollector
+	tags map[string]map[string]string
+}
+
+func (c *tagsTestCollector) AccountTags() map[string]map[string]string {
+	return c.tags
+}
+
+func TestTagLabelName(t *testing.T) {
+	for key, exp := range map[string]string{
+		"team":        "team",
+		"cost-centre": "cost_centre",
+		"aws:env":     "aws_env",
+		"1st-level":   "_1st_level",
+	} {
+		if act := tagLabelName(key); act != exp {
+			t.Errorf("unexpected label name of %s: %s (expected: %s)", key, act, exp)
+		}
+	}
+}
+
+func TestTagsEnricher(t *testing.T) {
+	b := &BillingCollector{collectors: []cloudBillingCollector{
+		&tagsTestCollector{fakeCollector: &fakeCollector{cloud: "aws"}, tags: map[string]map[string]string{
+			"acme-dev": {"team": "platform", "env": "dev", "cost-centre": "ops"},
+		}},
+		&tagsTestCollector{fakeCollector: &fakeCollector{cloud: "gcp"}, tags: map[string]map[string]string{
+			"project-a": {"env": "prod"},
+		}},
+	}}
+	e, err := newTagsEnricher(b, []string{"team", " env", "cost-centre"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if act, exp := e.labels(), []string{"team", "env", "cost_centre"}; !reflect.DeepEqual(act, exp) {
+		t.Errorf("unexpected labels: %v (expected: %v)", act, exp)
+	}
+	e.update()
+
+	for _, c := range []struct {
+		labels map[string]string
+		exp    map[string]string
+	}{
+		{
+			labels: map[string]string{"cloud": "aws", "account": "acme-dev", "cost_centre": "finance"},
+			exp:    map[string]string{"cloud": "aws", "account": "acme-dev", "team": "platform", "env": "dev", "cost_centre": "ops"},
+		},
+		// existing labels are kept without tag
+		{
+			labels: map[string]string{"cloud": "gcp", "account": "project-a", "cost_centre": "finance"},
+			exp:    map[string]string{"cloud": "gcp", "account": "project-a", "team": "", "env": "prod", "cost_centre": "finance"},
+		},
+		{
+			labels: map[string]string{"cloud": "gcp", "account": "acme-dev"},
+			exp:    map[string]string{"cloud": "gcp", "account": "acme-dev", "team": "", "env": ""},
+		},
+	} {
+		e.enrich(c.labels)
+		if !reflect.DeepEqual(c.labels, c.exp) {
+			t.Errorf("unexpected labels: %v (expected: %v)", c.labels, c.exp)
+		}
+	}
+
+	if _, err := newTagsEnricher(b, []string{"cost-centre", "cost_centre"}); err == nil {
+		t.Error("expected an error for tags mapped to the same label")
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/log"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// teamLabel is the label of the monthly costs set by the team mapping
+const teamLabel = "team"
+
+// teamMappingEntry maps an account or project to its team, owner and cost
+// centre. Empty owners and cost centres keep the ones from the cloud's tags
+// and labels, an empty cloud matches accounts of all clouds.
+type teamMappingEntry struct {
+	Cloud      string `yaml:"cloud"`
+	Account    string `yaml:"account"`
+	Team       string `yaml:"team"`
+	Owner      string `yaml:"owner"`
+	CostCentre string `yaml:"cost_centre"`
+}
+
+type teamMappingFile struct {
+	Accounts []teamMappingEntry `yaml:"accounts"`
+}
+
+// teamMapping sets the team, owner and cost centre of accounts from a YAML or
+// CSV file. The file is read again once its modification time changes, so
+// ownership changes apply without a restart.
+type teamMapping struct {
+	path string
+
+	lock    sync.Mutex
+	modTime time.Time
+	entries map[[2]string]teamMappingEntry
+}
+
+func newTeamMapping(path string) (*teamMapping, error) {
+	m := &teamMapping{path: path}
+	if err := m.reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// parseTeamMapping reads the mapping in YAML or, for files ending in .csv,
+// in CSV with the header cloud,account,team,owner,cost_centre
+func parseTeamMapping(name string, data []byte) ([]teamMappingEntry, error) {
+	if strings.ToLower(filepath.Ext(name)) != ".csv" {
+		f := &teamMappingFile{}
+		if err := yaml.UnmarshalStrict(data, f); err != nil {
+			return nil, err
+		}
+		return f.Accounts, nil
+	}
+
+	r := csv.NewReader(bytes.NewReader(data))
+	header, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+	pos := make(map[string]int)
+	for i, name := range header {
+		pos[strings.TrimSpace(name)] = i
+	}
+	if _, ok := pos["account"]; !ok {
+		return nil, fmt.Errorf("missing account column")
+	}
+	column := func(row []string, name string) string {
+		if i, ok := pos[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	var entries []teamMappingEntry
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, teamMappingEntry{
+			Cloud:      column(row, "cloud"),
+			Account:    column(row, "account"),
+			Team:       column(row, "team"),
+			Owner:      column(row, "owner"),
+			CostCentre: column(row, "cost_centre"),
+		})
+	}
+	return entries, nil
+}
+
+// reload reads the file if it changed since it was read last
+func (m *teamMapping) reload() error {
+	info, err := os.Stat(m.path)
+	if err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if info.ModTime().Equal(m.modTime) {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(m.path)
+	if err != nil {
+		return err
+	}
+	entries, err := parseTeamMapping(m.path, data)
+	if err != nil {
+		return fmt.Errorf("error parsing team mapping %s: %s", m.path, err)
+	}
+	m.entries = make(map[[2]string]teamMappingEntry, len(entries))
+	for _, e := range entries {
+		m.entries[[2]string{e.Cloud, e.Account}] = e
+	}
+	m.modTime = info.ModTime()
+	log.Infof("read team mapping of %d accounts from %s", len(entries), m.path)
+	return nil
+}
+
+func (m *teamMapping) labels() []string {
+	return []string{teamLabel}
+}
+
+// update reads the file again if it changed, the previous mapping is kept if
+// it can't be read
+func (m *teamMapping) update() {
+	if err := m.reload(); err != nil {
+		log.Warnf("error reloading team mapping: %s", err)
+	}
+}
+
+func (m *teamMapping) enrich(labels map[string]string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	e, ok := m.entries[[2]string{labels["cloud"], labels["account"]}]
+	if !ok {
+		e, ok = m.entries[[2]string{"", labels["account"]}]
+	}
+	labels[teamLabel] = e.Team
+	if !ok {
+		return
+	}
+	if e.Owner != "" {
+		labels["owner"] = e.Owner
+	}
+	if e.CostCentre != "" {
+		labels["cost_centre"] = e.CostCentre
+	}
+}
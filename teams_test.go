@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTeamMapping(t *testing.T) {
+	dir, err := ioutil.TempDir("", "teams")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "teams.yaml")
+	if err := ioutil.WriteFile(path, []byte(`accounts:
+- account: acme-dev
+  team: platform
+- cloud: gcp
+  account: project-a
+  team: data
+  owner: jane@example.com
+  cost_centre: cc-1
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := newTeamMapping(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	enrich := func(labels map[string]string) map[string]string {
+		m.update()
+		m.enrich(labels)
+		return labels
+	}
+	if act, exp := enrich(map[string]string{"cloud": "aws", "account": "acme-dev", "owner": "joe"}), map[string]string{"cloud": "aws", "account": "acme-dev", "owner": "joe", "team": "platform"}; !reflect.DeepEqual(act, exp) {
+		t.Errorf("unexpected labels: %v (expected: %v)", act, exp)
+	}
+	if act, exp := enrich(map[string]string{"cloud": "gcp", "account": "project-a", "owner": "joe"}), map[string]string{"cloud": "gcp", "account": "project-a", "owner": "jane@example.com", "cost_centre": "cc-1", "team": "data"}; !reflect.DeepEqual(act, exp) {
+		t.Errorf("unexpected labels: %v (expected: %v)", act, exp)
+	}
+	if act, exp := enrich(map[string]string{"cloud": "aws", "account": "project-a"}), map[string]string{"cloud": "aws", "account": "project-a", "team": ""}; !reflect.DeepEqual(act, exp) {
+		t.Errorf("unexpected labels: %v (expected: %v)", act, exp)
+	}
+
+	// changes are applied once the modification time changes
+	if err := ioutil.WriteFile(path, []byte("accounts:\n- account: acme-dev\n  team: security\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if act, exp := enrich(map[string]string{"account": "acme-dev"})["team"], "security"; act != exp {
+		t.Errorf("unexpected team after reload: '%s' (expected: '%s')", act, exp)
+	}
+
+	// invalid files keep the previous mapping
+	if err := ioutil.WriteFile(path, []byte("accounts: ["), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, time.Now(), time.Now().Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if act, exp := enrich(map[string]string{"account": "acme-dev"})["team"], "security"; act != exp {
+		t.Errorf("unexpected team after invalid reload: '%s' (expected: '%s')", act, exp)
+	}
+}
+
+func TestParseTeamMappingCSV(t *testing.T) {
+	entries, err := parseTeamMapping("teams.csv", []byte("account,team,cost_centre\nacme-dev,platform,cc-1\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if exp := []teamMappingEntry{{Account: "acme-dev", Team: "platform", CostCentre: "cc-1"}}; !reflect.DeepEqual(entries, exp) {
+		t.Errorf("unexpected entries: %+v (expected: %+v)", entries, exp)
+	}
+	if _, err := parseTeamMapping("teams.csv", []byte("team\nplatform\n")); err == nil {
+		t.Error("expected error without account column")
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+const topNOther = "other"
+
+// topNCollector exposes the month-to-date costs of the N biggest spenders
+// per cloud and currency, everything else is summed up as other
+type topNCollector struct {
+	n      int
+	labels []string
+	desc   *prometheus.Desc
+}
+
+func newTopNCollector(n int, labels []string) (*topNCollector, error) {
+	for _, l := range labels {
+		if l == "cloud" || l == "currency" {
+			return nil, fmt.Errorf("label '%s' is always part of the top N metric", l)
+		}
+		found := false
+		for _, name := range billing.MonthlyCostsLabels {
+			if l == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown label '%s'", l)
+		}
+	}
+
+	return &topNCollector{
+		n:      n,
+		labels: labels,
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "billing", "top_monthly_costs"),
+			fmt.Sprintf("Month-to-date costs of the top %d spenders per cloud, all others are aggregated as '%s'.", n, topNOther),
+			append([]string{"cloud", "currency"}, labels...),
+			nil,
+		),
+	}, nil
+}
+
+func (t *topNCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- t.desc
+}
+
+type topNEntry struct {
+	labels []string
+	costs  float64
+}
+
+func (t *topNCollector) collect(records []billing.Record, ch chan<- prometheus.Metric) {
+	// sum up costs per cloud, currency and the top N labels
+	groups := make(map[[2]string]map[string]*topNEntry)
+	for _, r := range records {
+		group := [2]string{r.Cloud, r.Currency}
+		if _, ok := groups[group]; !ok {
+			groups[group] = make(map[string]*topNEntry)
+		}
+
+		labels := make([]string, len(t.labels))
+		for i, l := range t.labels {
+			labels[i] = r.LabelValue(l)
+		}
+		key := fmt.Sprintf("%q", labels)
+		if e, ok := groups[group][key]; ok {
+			e.costs += r.Costs
+		} else {
+			groups[group][key] = &topNEntry{labels: labels, costs: r.Costs}
+		}
+	}
+
+	for group, entries := range groups {
+		sorted := make([]*topNEntry, 0, len(entries))
+		for _, e := range entries {
+			sorted = append(sorted, e)
+		}
+		sort.Slice(sorted, func(i, j int) bool {
+			return sorted[i].costs > sorted[j].costs
+		})
+
+		var other float64
+		for i, e := range sorted {
+			if i >= t.n {
+				other += e.costs
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(t.desc, prometheus.GaugeValue, e.costs, append(group[:], e.labels...)...)
+		}
+
+		if len(sorted) > t.n {
+			labels := append([]string{}, group[:]...)
+			for range t.labels {
+				labels = append(labels, topNOther)
+			}
+			ch <- prometheus.MustNewConstMetric(t.desc, prometheus.GaugeValue, other, labels...)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+// recordsCollector adapts a collect function on records to a
+// prometheus.Collector
+type recordsCollector struct {
+	records  []billing.Record
+	describe func(chan<- *prometheus.Desc)
+	collect  func([]billing.Record, chan<- prometheus.Metric)
+}
+
+func (r *recordsCollector) Describe(ch chan<- *prometheus.Desc) {
+	r.describe(ch)
+}
+
+func (r *recordsCollector) Collect(ch chan<- prometheus.Metric) {
+	r.collect(r.records, ch)
+}
+
+func TestTopN(t *testing.T) {
+	topN, err := newTopNCollector(2, []string{"account"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	records := []billing.Record{
+		{Cloud: "aws", Currency: "USD", Account: "a", Service: "AmazonEC2", Costs: 10},
+		{Cloud: "aws", Currency: "USD", Account: "a", Service: "AmazonS3", Costs: 15},
+		{Cloud: "aws", Currency: "USD", Account: "b", Service: "AmazonEC2", Costs: 30},
+		{Cloud: "aws", Currency: "USD", Account: "c", Service: "AmazonEC2", Costs: 3},
+		{Cloud: "aws", Currency: "USD", Account: "d", Service: "AmazonEC2", Costs: 4},
+		{Cloud: "gcp", Currency: "USD", Account: "e", Service: "compute", Costs: 1},
+	}
+
+	exp := `
+# HELP cloud_billing_top_monthly_costs Month-to-date costs of the top 2 spenders per cloud, all others are aggregated as 'other'.
+# TYPE cloud_billing_top_monthly_costs gauge
+cloud_billing_top_monthly_costs{account="a",cloud="aws",currency="USD"} 25
+cloud_billing_top_monthly_costs{account="b",cloud="aws",currency="USD"} 30
+cloud_billing_top_monthly_costs{account="other",cloud="aws",currency="USD"} 7
+cloud_billing_top_monthly_costs{account="e",cloud="gcp",currency="USD"} 1
+`
+	c := &recordsCollector{records: records, describe: topN.Describe, collect: topN.collect}
+	if err := testutil.CollectAndCompare(c, strings.NewReader(exp)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTopNInvalidLabel(t *testing.T) {
+	if _, err := newTopNCollector(2, []string{"currency"}); err == nil {
+		t.Error("expected error for currency label")
+	}
+	if _, err := newTopNCollector(2, []string{"region"}); err == nil {
+		t.Error("expected error for unknown label")
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+// defaultAttributionLabels attribute costs to an owner, team or cost centre,
+// the team label is only set with a teams file
+var defaultAttributionLabels = []string{"owner", "team", "cost_centre"}
+
+// unallocatedCollector exposes the month-to-date costs per account, which
+// are attributed to no owner, team or cost centre, e.g. as the tags or labels
+// are missing. Costs are unallocated if all attribution labels are empty.
+type unallocatedCollector struct {
+	labels    []string
+	costsDesc *prometheus.Desc
+	ratioDesc *prometheus.Desc
+}
+
+func newUnallocatedCollector() *unallocatedCollector {
+	return &unallocatedCollector{
+		labels: defaultAttributionLabels,
+		costsDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "billing", "unallocated_monthly_costs"),
+			"Month-to-date costs of the account without an owner, team or cost centre.",
+			[]string{"cloud", "currency", "account"},
+			nil,
+		),
+		ratioDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "billing", "unallocated_costs_ratio"),
+			"Share of the month-to-date costs of the account without an owner, team or cost centre.",
+			[]string{"cloud", "currency", "account"},
+			nil,
+		),
+	}
+}
+
+// withLabels selects the labels attributing costs, which need to be known to
+// the monthly costs. The default labels are used if empty.
+func (u *unallocatedCollector) withLabels(labels []string, knownLabels []string) error {
+	if len(labels) == 0 {
+		u.labels = defaultAttributionLabels
+		return nil
+	}
+	known := make(map[string]bool)
+	for _, name := range knownLabels {
+		known[name] = true
+	}
+	u.labels = nil
+	for _, name := range labels {
+		name = strings.TrimSpace(name)
+		if !known[name] {
+			return fmt.Errorf("unknown attribution label '%s'", name)
+		}
+		u.labels = append(u.labels, name)
+	}
+	return nil
+}
+
+func (u *unallocatedCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- u.costsDesc
+	ch <- u.ratioDesc
+}
+
+// collect sums up the costs per account, the labels of the records are looked
+// up including the ones of the enrichers
+func (u *unallocatedCollector) collect(records []billing.Record, labels func(billing.Record) map[string]string, ch chan<- prometheus.Metric) {
+	totals := make(map[[3]string]float64)
+	unallocated := make(map[[3]string]float64)
+	for _, r := range records {
+		key := [3]string{r.Cloud, r.Currency, r.Account}
+		totals[key] += r.Costs
+		values := labels(r)
+		allocated := false
+		for _, name := range u.labels {
+			allocated = allocated || values[name] != ""
+		}
+		if !allocated {
+			unallocated[key] += r.Costs
+		}
+	}
+
+	for key, total := range totals {
+		costs := unallocated[key]
+		ch <- prometheus.MustNewConstMetric(u.costsDesc, prometheus.GaugeValue, costs, key[:]...)
+		if total > 0 {
+			ch <- prometheus.MustNewConstMetric(u.ratioDesc, prometheus.GaugeValue, costs/total, key[:]...)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+// staticTeams sets the team label of accounts
+type staticTeams map[string]string
+
+func (s staticTeams) labels() []string {
+	return []string{"team"}
+}
+
+func (s staticTeams) update() {}
+
+func (s staticTeams) enrich(labels map[string]string) {
+	labels["team"] = s[labels["account"]]
+}
+
+func TestUnallocated(t *testing.T) {
+	monthlyCosts := newMonthlyCostsCollector(0).withEnricher(staticTeams{"b": "platform"})
+	u := newUnallocatedCollector()
+
+	records := []billing.Record{
+		{Cloud: "aws", Currency: "USD", Account: "a", Service: "AmazonEC2", Owner: "jane", Costs: 30},
+		{Cloud: "aws", Currency: "USD", Account: "a", Service: "AmazonS3", Costs: 10},
+		{Cloud: "aws", Currency: "USD", Account: "b", Service: "AmazonEC2", Costs: 20},
+		{Cloud: "gcp", Currency: "USD", Account: "c", Service: "compute", CostCentre: "ops", Costs: 5},
+		{Cloud: "gcp", Currency: "USD", Account: "d", Service: "compute", Costs: 5},
+	}
+	collect := func(records []billing.Record, ch chan<- prometheus.Metric) {
+		u.collect(records, monthlyCosts.recordLabels, ch)
+	}
+
+	exp := `
+# HELP cloud_billing_unallocated_costs_ratio Share of the month-to-date costs of the account without an owner, team or cost centre.
+# TYPE cloud_billing_unallocated_costs_ratio gauge
+cloud_billing_unallocated_costs_ratio{account="a",cloud="aws",currency="USD"} 0.25
+cloud_billing_unallocated_costs_ratio{account="b",cloud="aws",currency="USD"} 0
+cloud_billing_unallocated_costs_ratio{account="c",cloud="gcp",currency="USD"} 0
+cloud_billing_unallocated_costs_ratio{account="d",cloud="gcp",currency="USD"} 1
+# HELP cloud_billing_unallocated_monthly_costs Month-to-date costs of the account without an owner, team or cost centre.
+# TYPE cloud_billing_unallocated_monthly_costs gauge
+cloud_billing_unallocated_monthly_costs{account="a",cloud="aws",currency="USD"} 10
+cloud_billing_unallocated_monthly_costs{account="b",cloud="aws",currency="USD"} 0
+cloud_billing_unallocated_monthly_costs{account="c",cloud="gcp",currency="USD"} 0
+cloud_billing_unallocated_monthly_costs{account="d",cloud="gcp",currency="USD"} 5
+`
+	c := &recordsCollector{records: records, describe: u.Describe, collect: collect}
+	if err := testutil.CollectAndCompare(c, strings.NewReader(exp)); err != nil {
+		t.Error(err)
+	}
+
+	// only the owner attributes costs
+	if err := u.withLabels([]string{"owner"}, monthlyCosts.allLabels()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	exp = `
+# HELP cloud_billing_unallocated_monthly_costs Month-to-date costs of the account without an owner, team or cost centre.
+# TYPE cloud_billing_unallocated_monthly_costs gauge
+cloud_billing_unallocated_monthly_costs{account="a",cloud="aws",currency="USD"} 10
+cloud_billing_unallocated_monthly_costs{account="b",cloud="aws",currency="USD"} 20
+cloud_billing_unallocated_monthly_costs{account="c",cloud="gcp",currency="USD"} 5
+cloud_billing_unallocated_monthly_costs{account="d",cloud="gcp",currency="USD"} 5
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(exp), "cloud_billing_unallocated_monthly_costs"); err != nil {
+		t.Error(err)
+	}
+
+	if err := u.withLabels([]string{"department"}, monthlyCosts.allLabels()); err == nil {
+		t.Error("expected error for unknown label")
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+// usageCollector is implemented by collectors exposing the usage quantity of
+// their parsed reports
+type usageCollector interface {
+	Usage() []billing.Usage
+}
+
+// unitPriceCollector exposes the effective price per usage unit, which jumps
+// if discounts are lost or pricing tiers change
+type unitPriceCollector struct {
+	desc *prometheus.Desc
+}
+
+func newUnitPriceCollector() *unitPriceCollector {
+	return &unitPriceCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "billing", "effective_unit_price"),
+			"Month-to-date costs divided by the month-to-date usage quantity of a SKU.",
+			[]string{"cloud", "service", "sku", "unit", "currency"},
+			nil,
+		),
+	}
+}
+
+func (c *unitPriceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *unitPriceCollector) collect(usage []billing.Usage, ch chan<- prometheus.Metric) {
+	// the latest month wins, if a collector reports multiple months
+	latest := make(map[[5]string]billing.Usage)
+	for _, u := range billing.MergeUsage(usage) {
+		key := [5]string{u.Cloud, u.Service, u.SKU, u.Unit, u.Currency}
+		if previous, ok := latest[key]; ok && previous.Month > u.Month {
+			continue
+		}
+		latest[key] = u
+	}
+
+	for key, u := range latest {
+		if u.Quantity <= 0 {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, u.Costs/u.Quantity, key[:]...)
+	}
+}
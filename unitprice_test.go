@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+type unitPriceTestCollector struct {
+	*unitPriceCollector
+	usage []billing.Usage
+}
+
+func (c unitPriceTestCollector) Collect(ch chan<- prometheus.Metric) {
+	c.collect(c.usage, ch)
+}
+
+func TestUnitPriceCollector(t *testing.T) {
+	c := unitPriceTestCollector{
+		unitPriceCollector: newUnitPriceCollector(),
+		usage: []billing.Usage{
+			{Cloud: "aws", Month: "2019-10", Service: "AmazonEC2", SKU: "EU-BoxUsage:m4.large", Currency: "USD", Quantity: 100, Costs: 20},
+			{Cloud: "aws", Month: "2019-11", Service: "AmazonEC2", SKU: "EU-BoxUsage:m4.large", Currency: "USD", Quantity: 100, Costs: 10},
+			{Cloud: "aws", Month: "2019-11", Service: "AmazonEC2", SKU: "EU-BoxUsage:m4.large", Currency: "USD", Quantity: 100, Costs: 14},
+			{Cloud: "gcp", Month: "2019-11", Service: "compute-engine", SKU: "VmimageN1Standard_1", Unit: "seconds", Currency: "USD", Quantity: 0, Costs: 1},
+		},
+	}
+
+	exp := `
+# HELP cloud_billing_effective_unit_price Month-to-date costs divided by the month-to-date usage quantity of a SKU.
+# TYPE cloud_billing_effective_unit_price gauge
+cloud_billing_effective_unit_price{cloud="aws",currency="USD",service="AmazonEC2",sku="EU-BoxUsage:m4.large",unit=""} 0.12
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(exp)); err != nil {
+		t.Errorf("unexpected metrics: %s", err)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+// validateSamples is the number of accounts per collector and of label sets
+// printed by the validate command
+const validateSamples = 5
+
+// validate checks the access of the collectors, queries them once and writes
+// the reports found, a sample of the resolved accounts and the label sets of
+// the monthly costs, which would be exposed. It returns false if any
+// collector failed.
+func (b *BillingCollector) validate(ctx context.Context, w io.Writer) bool {
+	r := &doctorReport{tw: tabwriter.NewWriter(w, 0, 8, 2, ' ', 0), ok: true}
+
+	r.section("CONFIGURATION")
+	if b.ConfigFile != nil && *b.ConfigFile != "" {
+		r.line("config file", "%s", *b.ConfigFile)
+	}
+	r.line("collectors", "%d", len(b.collectors))
+	r.line("labels", "%s", strings.Join(b.monthlyCosts.labels, ", "))
+
+	var records []billing.Record
+	for _, c := range b.collectors {
+		r.section(fmt.Sprintf("COLLECTOR %s", c))
+
+		if checker, ok := c.(credentialsChecker); ok {
+			checkCtx, cancel := context.WithTimeout(ctx, doctorTimeout)
+			if err := checker.CheckCredentials(checkCtx); err != nil {
+				r.line("access", "FAIL: %s", strings.Join(strings.Fields(err.Error()), " "))
+				r.fail("credentials of %s are invalid", c)
+			} else {
+				r.line("access", "pass")
+			}
+			cancel()
+		}
+
+		start := time.Now()
+		if err := b.health.run(c, c.Query); err != nil {
+			r.line("query", "FAIL after %s: %s", time.Since(start).Round(time.Millisecond), strings.Join(strings.Fields(err.Error()), " "))
+			r.fail("query of %s failed", c)
+			continue
+		}
+		r.line("query", "pass in %s", time.Since(start).Round(time.Millisecond))
+
+		if d, ok := c.(diagnosticsCollector); ok {
+			checkCtx, cancel := context.WithTimeout(ctx, doctorTimeout)
+			diagnostics, _ := d.Diagnostics(checkCtx)
+			cancel()
+			if diagnostics.NewestReport != "" {
+				r.line("report", "%s", diagnostics.NewestReport)
+			} else if diagnostics.Source != "" {
+				r.warn("no reports of %s found in %s", c, diagnostics.Source)
+			}
+		}
+
+		collectorRecords := c.Records()
+		known := make(map[string]bool)
+		var accounts []string
+		for _, record := range collectorRecords {
+			if !known[record.Account] {
+				known[record.Account] = true
+				accounts = append(accounts, record.Account)
+			}
+		}
+		sort.Strings(accounts)
+		if len(accounts) > validateSamples {
+			r.line("accounts", "%s and %d more", strings.Join(accounts[:validateSamples], ", "), len(accounts)-validateSamples)
+		} else {
+			r.line("accounts", "%s", strings.Join(accounts, ", "))
+		}
+		if len(collectorRecords) == 0 {
+			r.warn("%s has no costs", c)
+		}
+		records = append(records, collectorRecords...)
+	}
+
+	// the label sets are built like the ones of the monthly costs current
+	// series, grouped by the group by labels
+	r.section("LABEL SETS")
+	for _, e := range b.monthlyCosts.enrichers {
+		e.update()
+	}
+	known := make(map[string]bool)
+	var sets []string
+	for _, record := range records {
+		labels := b.monthlyCosts.recordLabels(record)
+		pairs := make([]string, len(b.monthlyCosts.labels))
+		for i, name := range b.monthlyCosts.labels {
+			pairs[i] = fmt.Sprintf("%s=%q", name, labels[name])
+		}
+		set := "{" + strings.Join(pairs, ", ") + "}"
+		if !known[set] {
+			known[set] = true
+			sets = append(sets, set)
+		}
+	}
+	sort.Strings(sets)
+	for i, set := range sets {
+		if i == validateSamples {
+			fmt.Fprintf(r.tw, "  ... %d more\n", len(sets)-validateSamples)
+			break
+		}
+		fmt.Fprintf(r.tw, "  %s\n", set)
+	}
+	if len(sets) == 0 {
+		fmt.Fprintln(r.tw, "  none")
+	}
+
+	r.section("WARNINGS")
+	if len(r.warnings) == 0 {
+		fmt.Fprintln(r.tw, "  none")
+	}
+	for _, warning := range r.warnings {
+		fmt.Fprintf(r.tw, "  - %s\n", warning)
+	}
+	_ = r.tw.Flush()
+	return r.ok
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+func TestValidate(t *testing.T) {
+	b := &BillingCollector{}
+	b.initMetrics()
+	b.collectors = []cloudBillingCollector{
+		&doctorTestCollector{
+			fakeCollector: fakeCollector{cloud: "aws", records: []billing.Record{
+				{Cloud: "aws", Currency: "USD", Account: "prod", Service: "AmazonEC2", Costs: 10},
+				{Cloud: "aws", Currency: "USD", Account: "dev", Service: "AmazonEC2", Costs: 2.5},
+				{Cloud: "aws", Currency: "USD", Account: "dev", Service: "AmazonEC2", Costs: 1},
+			}},
+			diagnostics: billing.Diagnostics{
+				Source:       "s3://billing/12340002-aws-billing-csv-",
+				Reports:      2,
+				NewestReport: "12340002-aws-billing-csv-2019-11.csv",
+			},
+		},
+		&doctorTestCollector{
+			fakeCollector: fakeCollector{cloud: "gcp"},
+			credentials:   errors.New("AccessDenied:\n\tstatus code: 403"),
+		},
+	}
+	if err := b.monthlyCosts.withGroupBy([]string{"account"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if b.validate(context.Background(), &out) {
+		t.Error("expected failed validation")
+	}
+	act := strings.Join(strings.Fields(out.String()), " ")
+	for _, exp := range []string{
+		"collectors: 2 labels: cloud, currency, account",
+		"access: pass query: pass",
+		"report: 12340002-aws-billing-csv-2019-11.csv accounts: dev, prod",
+		"access: FAIL: AccessDenied: status code: 403",
+		`LABEL SETS {cloud="aws", currency="USD", account="dev"} {cloud="aws", currency="USD", account="prod"} WARNINGS`,
+		"WARNINGS - credentials of fake gcp are invalid - fake gcp has no costs",
+	} {
+		if !strings.Contains(act, exp) {
+			t.Errorf("expected output to contain %q:\n%s", exp, out.String())
+		}
+	}
+}
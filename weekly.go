@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+// weeklyCollector exposes the costs of the last ISO weeks, aggregated from
+// the line items of reports with daily granularity
+type weeklyCollector struct {
+	weeks int
+	items *lineItemHistory
+	desc  *prometheus.Desc
+	now   func() time.Time
+}
+
+func newWeeklyCollector(weeks int, items *lineItemHistory) *weeklyCollector {
+	return &weeklyCollector{
+		weeks: weeks,
+		items: items,
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "billing", "weekly_costs"),
+			fmt.Sprintf("Costs per ISO week of the last %d weeks, only reports with daily granularity are included.", weeks),
+			[]string{"cloud", "currency", "account", "service", "week"},
+			nil,
+		),
+		now: time.Now,
+	}
+}
+
+// isoWeek returns the ISO week of the day, e.g. 2019-W46
+func isoWeek(day time.Time) string {
+	year, week := day.ISOWeek()
+	return fmt.Sprintf("%04d-W%02d", year, week)
+}
+
+func (w *weeklyCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- w.desc
+}
+
+func (w *weeklyCollector) Collect(ch chan<- prometheus.Metric) {
+	now := w.now().UTC()
+	// the Monday starting the oldest week exposed
+	weekday := (int(now.Weekday()) + 6) % 7
+	since := time.Date(now.Year(), now.Month(), now.Day()-weekday-7*(w.weeks-1), 0, 0, 0, 0, time.UTC)
+
+	totals := make(map[[5]string]float64)
+	w.items.eachDay(false, func(i billing.LineItem, day time.Time, costs float64) {
+		if day.Before(since) {
+			return
+		}
+		totals[[5]string{i.Cloud, i.Currency, i.Account, i.Service, isoWeek(day)}] += costs
+	})
+
+	for key, value := range totals {
+		ch <- prometheus.MustNewConstMetric(w.desc, prometheus.GaugeValue, value, key[:]...)
+	}
+}
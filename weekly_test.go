@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/simonswine/cloud-billing-exporter/billing"
+)
+
+func TestWeeklyCollector(t *testing.T) {
+	items := newLineItemHistory()
+	w := newWeeklyCollector(2, items)
+	// Wednesday of 2019-W46
+	w.now = func() time.Time { return time.Date(2019, 11, 13, 10, 0, 0, 0, time.UTC) }
+
+	items.update([]billing.LineItem{
+		{Cloud: "gcp", Month: "2019-11", Date: "2019-11-03", Currency: "USD", Account: "a", Service: "compute", Costs: 1},
+		{Cloud: "gcp", Month: "2019-11", Date: "2019-11-04", Currency: "USD", Account: "a", Service: "compute", Costs: 2},
+		{Cloud: "gcp", Month: "2019-11", Date: "2019-11-10", Currency: "USD", Account: "a", Service: "compute", Costs: 4},
+		{Cloud: "gcp", Month: "2019-11", Date: "2019-11-11", Currency: "USD", Account: "a", Service: "compute", Costs: 8},
+		{Cloud: "gcp", Month: "2019-11", Date: "2019-11-13", Currency: "USD", Account: "a", Service: "compute", Costs: 16},
+		{Cloud: "aws", Month: "2019-11", Currency: "USD", Account: "b", Service: "AmazonEC2", Costs: 30},
+	})
+
+	if err := testutil.CollectAndCompare(w, strings.NewReader(`
+# HELP cloud_billing_weekly_costs Costs per ISO week of the last 2 weeks, only reports with daily granularity are included.
+# TYPE cloud_billing_weekly_costs gauge
+cloud_billing_weekly_costs{account="a",cloud="gcp",currency="USD",service="compute",week="2019-W45"} 6
+cloud_billing_weekly_costs{account="a",cloud="gcp",currency="USD",service="compute",week="2019-W46"} 24
+`)); err != nil {
+		t.Error(err)
+	}
+}